@@ -2,25 +2,33 @@ package cli
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
 
 	"github.com/luckyjian/pgdba/internal/cluster"
 	"github.com/luckyjian/pgdba/internal/config"
+	"github.com/luckyjian/pgdba/internal/dcs"
+	"github.com/luckyjian/pgdba/internal/lifecycle"
 	"github.com/luckyjian/pgdba/internal/output"
 	"github.com/luckyjian/pgdba/internal/patroni"
+	"github.com/luckyjian/pgdba/internal/provider"
 )
 
 // ClusterStatusResult holds the cluster topology response data.
 type ClusterStatusResult struct {
-	ClusterName  string            `json:"cluster_name"`
-	Members      []clusterMember   `json:"members"`
-	Primary      string            `json:"primary"`
-	ReplicaCount int               `json:"replica_count"`
-	Healthy      bool              `json:"healthy"`
+	ClusterName  string               `json:"cluster_name"`
+	Members      []clusterMember      `json:"members"`
+	Primary      string               `json:"primary"`
+	ReplicaCount int                  `json:"replica_count"`
+	Healthy      bool                 `json:"healthy"`
+	Paused       bool                 `json:"paused"`
+	Pause        *PauseStatus         `json:"pause,omitempty"`
+	Etcd         *dcs.QuorumStatus    `json:"etcd,omitempty"`
 }
 
 type clusterMember struct {
@@ -36,37 +44,71 @@ type clusterMember struct {
 func newClusterCmd(cfg *config.Config, format *output.Format, reg *cluster.Registry) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "cluster",
-		Short: "Cluster lifecycle management (status, connect, init, destroy)",
+		Short: "Cluster lifecycle management (status, connect, init, destroy, plan, apply, scale, rolling, upgrade, pause, resume, config, history, dcs)",
 	}
 	cmd.AddCommand(
 		newClusterStatusCmd(format, reg),
 		newClusterConnectCmd(format, reg),
-		newClusterInitCmd(cfg, format),
-		newClusterDestroyCmd(format, reg),
+		newClusterInitCmd(cfg, format, reg),
+		newClusterDestroyCmd(cfg, format, reg),
+		newClusterPlanCmd(cfg, format, reg),
+		newClusterApplyCmd(cfg, format, reg),
+		newClusterScaleCmd(cfg, format, reg),
+		newClusterRollingRestartCmd(cfg, format, reg),
+		newClusterRollingUpgradeCmd(cfg, format, reg),
+		newClusterUpgradeMajorCmd(cfg, format, reg),
+		newClusterPauseCmd(format, reg),
+		newClusterResumeCmd(format, reg),
+		newClusterConfigCmd(cfg, format, reg),
+		newClusterHistoryCmd(format, reg),
+		newClusterDCSCmd(format, reg),
 	)
 	return cmd
 }
 
 // newClusterStatusCmd returns "cluster status" which shows topology from Patroni API.
 func newClusterStatusCmd(format *output.Format, reg *cluster.Registry) *cobra.Command {
-	var name, patroniURL string
+	var name, patroniURL, etcdEndpoints string
 
 	cmd := &cobra.Command{
 		Use:   "status",
 		Short: "Show cluster topology from Patroni API",
+		Long: "Show the members, roles and lag reported by Patroni. When the cluster's etcd\n" +
+			"endpoints are known (recorded by init or connect, or given by --etcd-endpoints),\n" +
+			"each one is probed as well: health, leader, raft index, DB size and alarms. The\n" +
+			"cluster is reported unhealthy when etcd has lost quorum.",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			url, err := resolvePatroniURL(name, patroniURL, reg)
+			client, err := resolvePatroniClient(name, patroniURL, reg)
 			if err != nil {
 				return writeFailure(cmd, *format, "cluster status", err)
 			}
 
-			client := patroni.NewClient(url)
+			pause, err := readPause(reg, name)
+			if err != nil {
+				return writeFailure(cmd, *format, "cluster status", err)
+			}
 			cs, err := client.GetClusterStatus(context.Background())
 			if err != nil {
 				return writeFailure(cmd, *format, "cluster status", err)
 			}
 
 			result := buildStatusResult(name, cs)
+			result.Paused = cs.Pause
+			if cs.Pause {
+				result.Pause = pause
+			}
+			endpoints, err := resolveEtcdEndpoints(name, etcdEndpoints, reg)
+			if err != nil {
+				return writeFailure(cmd, *format, "cluster status", err)
+			}
+			if len(endpoints) > 0 {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				result.Etcd = dcs.NewEtcdClient(endpoints).Health(ctx)
+				cancel()
+				if !result.Etcd.Quorum {
+					result.Healthy = false
+				}
+			}
 			resp := output.Success("cluster status", result)
 			out, err := output.FormatResponse(resp, *format)
 			if err != nil {
@@ -78,17 +120,38 @@ func newClusterStatusCmd(format *output.Format, reg *cluster.Registry) *cobra.Co
 	}
 	cmd.Flags().StringVar(&name, "name", "", "Cluster name (looks up Patroni URL from registry)")
 	cmd.Flags().StringVar(&patroniURL, "patroni-url", "", "Patroni API URL (e.g. http://10.0.0.1:8008)")
+	cmd.Flags().StringVar(&etcdEndpoints, "etcd-endpoints", "", "Comma-separated etcd endpoints to probe (default: from registry)")
 	return cmd
 }
 
+// resolveEtcdEndpoints returns the etcd endpoints given by flag, or else
+// those recorded for cluster name (none for clusters given by URL).
+func resolveEtcdEndpoints(name, flag string, reg *cluster.Registry) ([]string, error) {
+	if flag != "" || name == "" {
+		return splitList(flag), nil
+	}
+	entry, err := reg.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	return entry.EtcdEndpoints, nil
+}
+
 // newClusterConnectCmd returns "cluster connect" which imports an existing Patroni cluster.
 func newClusterConnectCmd(format *output.Format, reg *cluster.Registry) *cobra.Command {
 	var name, patroniURL, pgHost, provider string
 	var pgPort int
+	var access cluster.PatroniAccess
+	var endpoints, etcdEndpoints string
 
 	cmd := &cobra.Command{
 		Use:   "connect",
 		Short: "Import an existing Patroni cluster into the registry",
+		Long: "Register an existing Patroni cluster. For REST APIs that need it, --patroni-user\n" +
+			"(password from PGDBA_PATRONI_PASSWORD or PGDBA_PATRONI_PASSWORD_FILE) and the TLS\n" +
+			"files are recorded; --patroni-endpoints lists further API URLs to use when\n" +
+			"--patroni-url is down. Members' api_urls are tried as well. --etcd-endpoints\n" +
+			"records the cluster's DCS so that status can check its quorum.",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if name == "" {
 				return writeFailure(cmd, *format, "cluster connect",
@@ -103,8 +166,18 @@ func newClusterConnectCmd(format *output.Format, reg *cluster.Registry) *cobra.C
 					fmt.Errorf("--pg-host is required"))
 			}
 
+			access.Endpoints = splitList(endpoints)
+			var accessPtr *cluster.PatroniAccess
+			if access.Username != "" || access.CAFile != "" || access.CertFile != "" ||
+				access.KeyFile != "" || len(access.Endpoints) > 0 {
+				accessPtr = &access
+			}
+
 			// Pre-flight: verify Patroni is reachable.
-			client := patroni.NewClient(patroniURL)
+			client, err := patroniClient(patroniURL, accessPtr)
+			if err != nil {
+				return writeFailure(cmd, *format, "cluster connect", err)
+			}
 			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 			defer cancel()
 			if _, err := client.GetClusterStatus(ctx); err != nil {
@@ -120,6 +193,9 @@ func newClusterConnectCmd(format *output.Format, reg *cluster.Registry) *cobra.C
 				Provider:   provider,
 				Source:     cluster.SourceExternal,
 				CreatedAt:  time.Now().UTC(),
+
+				EtcdEndpoints: splitList(etcdEndpoints),
+				PatroniAccess: accessPtr,
 			}
 			if err := reg.Add(entry); err != nil {
 				return writeFailure(cmd, *format, "cluster connect",
@@ -144,16 +220,29 @@ func newClusterConnectCmd(format *output.Format, reg *cluster.Registry) *cobra.C
 	cmd.Flags().StringVar(&pgHost, "pg-host", "", "PostgreSQL host")
 	cmd.Flags().IntVar(&pgPort, "pg-port", 5432, "PostgreSQL port")
 	cmd.Flags().StringVar(&provider, "provider", "baremetal", "Infrastructure provider")
+	cmd.Flags().StringVar(&access.Username, "patroni-user", "", "Patroni REST API basic auth user")
+	cmd.Flags().StringVar(&access.CAFile, "patroni-ca-file", "", "CA bundle for an https Patroni API")
+	cmd.Flags().StringVar(&access.CertFile, "patroni-cert-file", "", "Client certificate for Patroni mTLS")
+	cmd.Flags().StringVar(&access.KeyFile, "patroni-key-file", "", "Client key for Patroni mTLS")
+	cmd.Flags().StringVar(&endpoints, "patroni-endpoints", "", "Comma-separated further Patroni API URLs for failover")
+	cmd.Flags().StringVar(&etcdEndpoints, "etcd-endpoints", "", "Comma-separated etcd client endpoints (host:2379) of the cluster's DCS")
 	return cmd
 }
 
 // newClusterInitCmd returns "cluster init" which bootstraps a new managed cluster.
-func newClusterInitCmd(cfg *config.Config, format *output.Format) *cobra.Command {
-	var name, primaryHost string
+func newClusterInitCmd(cfg *config.Config, format *output.Format, reg *cluster.Registry) *cobra.Command {
+	var name, primaryHost, replicaHosts, etcdHosts, dataDir string
+	var replicas, pgPort int
+	var withPgBouncer, progress bool
+	var timeout time.Duration
 
 	cmd := &cobra.Command{
 		Use:   "init",
 		Short: "Bootstrap a new managed Patroni/PostgreSQL cluster",
+		Long: "Bootstrap a new managed cluster through the configured provider: an etcd quorum,\n" +
+			"a primary, --replicas replicas and optionally a PgBouncer. Passwords are read from\n" +
+			"PGDBA_PG_PASSWORD, PGDBA_REPLICATION_PASSWORD and PGDBA_REWIND_PASSWORD.\n" +
+			"With --progress, step events are streamed to stderr as NDJSON.",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if primaryHost == "" {
 				return writeFailure(cmd, *format, "cluster init",
@@ -163,25 +252,107 @@ func newClusterInitCmd(cfg *config.Config, format *output.Format) *cobra.Command
 				return writeFailure(cmd, *format, "cluster init",
 					fmt.Errorf("--name is required"))
 			}
-			// Provider.CreateNode is not yet implemented.
-			return writeFailure(cmd, *format, "cluster init",
-				fmt.Errorf("provider CreateNode: not implemented"))
+			passwords, err := lifecycle.PasswordsFromEnv()
+			if err != nil {
+				return writeFailure(cmd, *format, "cluster init", err)
+			}
+			prov, err := newProvider(cfg, cfg.Provider.Type, name)
+			if err != nil {
+				return writeFailure(cmd, *format, "cluster init", err)
+			}
+
+			opts := lifecycle.BootstrapOptions{
+				ClusterName:   name,
+				PrimaryHost:   primaryHost,
+				Replicas:      replicas,
+				ReplicaHosts:  splitList(replicaHosts),
+				EtcdHosts:     splitList(etcdHosts),
+				PGPort:        pgPort,
+				DataDir:       dataDir,
+				PgBouncer:     withPgBouncer,
+				Passwords:     passwords,
+				LeaderTimeout: timeout,
+			}
+			if progress {
+				enc := json.NewEncoder(cmd.ErrOrStderr())
+				opts.OnEvent = func(ev lifecycle.StepEvent) { _ = enc.Encode(ev) }
+			}
+
+			result, err := lifecycle.Bootstrap(context.Background(), prov, reg, opts)
+			if err != nil {
+				return writeFailure(cmd, *format, "cluster init", err)
+			}
+
+			resp := output.Success("cluster init", result)
+			out, err := output.FormatResponse(resp, *format)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), out)
+			return nil
 		},
 	}
 	cmd.Flags().StringVar(&name, "name", "", "Cluster name")
 	cmd.Flags().StringVar(&primaryHost, "primary-host", "", "Host for the primary node")
-	_ = cfg
+	cmd.Flags().IntVar(&replicas, "replicas", 2, "Number of replicas to create besides the primary")
+	cmd.Flags().StringVar(&replicaHosts, "replica-hosts", "", "Comma-separated replica hosts (default: node names)")
+	cmd.Flags().StringVar(&etcdHosts, "etcd-hosts", "", "Comma-separated hosts for the 3 etcd members (default: node names)")
+	cmd.Flags().IntVar(&pgPort, "pg-port", 5432, "PostgreSQL port")
+	cmd.Flags().StringVar(&dataDir, "data-dir", lifecycle.DefaultDataDir, "PostgreSQL data directory on each node")
+	cmd.Flags().BoolVar(&withPgBouncer, "pgbouncer", false, "Also deploy a PgBouncer in front of the primary")
+	cmd.Flags().DurationVar(&timeout, "timeout", 5*time.Minute, "How long to wait for Patroni to elect a leader")
+	cmd.Flags().BoolVar(&progress, "progress", false, "Stream step events to stderr as NDJSON")
 	return cmd
 }
 
-// newClusterDestroyCmd returns "cluster destroy" which removes a managed cluster.
-func newClusterDestroyCmd(format *output.Format, reg *cluster.Registry) *cobra.Command {
+// newProvider builds an infrastructure provider of the given type for a
+// cluster. Provider options from the config file apply only when its
+// provider.type matches. The cluster name is passed as the "cluster" option
+// so providers can label the nodes they report.
+func newProvider(cfg *config.Config, providerType, clusterName string) (provider.Provider, error) {
+	if providerType == "" {
+		providerType = config.DefaultProvider
+	}
+	opts := map[string]string{}
+	if cfg.Provider.Type == providerType {
+		for k, v := range cfg.Provider.Options {
+			opts[k] = v
+		}
+	}
+	if _, ok := opts["cluster"]; !ok && clusterName != "" {
+		opts["cluster"] = clusterName
+	}
+	p, err := provider.New(providerType, opts)
+	if err != nil {
+		return nil, fmt.Errorf("create %s provider: %w", providerType, err)
+	}
+	return p, nil
+}
+
+// splitList splits a comma-separated flag value, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// newClusterDestroyCmd returns "cluster destroy" which tears down a managed cluster.
+func newClusterDestroyCmd(cfg *config.Config, format *output.Format, reg *cluster.Registry) *cobra.Command {
 	var name string
-	var confirm bool
+	var confirm, purgeData, force, progress bool
 
 	cmd := &cobra.Command{
 		Use:   "destroy",
 		Short: "Destroy a managed cluster (not allowed for externally-connected clusters)",
+		Long: "Destroy every node of a managed cluster through its provider, then remove it from the\n" +
+			"registry. Data volumes are kept unless --purge-data is given. If any node fails to be\n" +
+			"destroyed the registry entry is kept, unless --force is given. Nodes the provider\n" +
+			"cannot tell the owner of (unreachable bare-metal hosts) stop the destroy; --force\n" +
+			"leaves them alone.",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if !confirm {
 				return writeFailure(cmd, *format, "cluster destroy",
@@ -198,15 +369,52 @@ func newClusterDestroyCmd(format *output.Format, reg *cluster.Registry) *cobra.C
 					fmt.Errorf("cluster %q was connected with 'cluster connect' and is not managed by pgdba; refusing to destroy", name))
 			}
 
-			if err := reg.Remove(name); err != nil {
-				return writeFailure(cmd, *format, "cluster destroy",
-					fmt.Errorf("remove from registry: %w", err))
+			if entry.PatroniURL != "" {
+				client, err := entryPatroniClient(entry)
+				if err != nil {
+					return writeFailure(cmd, *format, "cluster destroy", err)
+				}
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+				err = checkPaused(ctx, cmd, reg, name, client, false)
+				cancel()
+				if err != nil {
+					return writeFailure(cmd, *format, "cluster destroy", err)
+				}
 			}
 
-			resp := output.Success("cluster destroy", map[string]string{
-				"cluster": name,
-				"status":  "destroyed",
-			})
+			opts := lifecycle.TeardownOptions{PurgeData: purgeData, Force: force}
+			if progress {
+				enc := json.NewEncoder(cmd.ErrOrStderr())
+				opts.OnEvent = func(ev lifecycle.StepEvent) { _ = enc.Encode(ev) }
+			}
+
+			var result *lifecycle.TeardownResult
+			prov, err := newProvider(cfg, entry.Provider, name)
+			if err != nil {
+				if !force {
+					return writeFailure(cmd, *format, "cluster destroy", err)
+				}
+				// Forced: the infrastructure is unreachable, drop the entry only.
+				if err := reg.Remove(name); err != nil {
+					return writeFailure(cmd, *format, "cluster destroy",
+						fmt.Errorf("remove from registry: %w", err))
+				}
+				result = &lifecycle.TeardownResult{Cluster: name, Provider: entry.Provider,
+					Nodes: []lifecycle.NodeTeardown{}, Failed: 1, RegistryRemoved: true, Forced: true,
+					Events: []lifecycle.StepEvent{}}
+			} else {
+				result, err = lifecycle.Teardown(context.Background(), prov, reg, name, opts)
+				if err != nil {
+					return writeFailure(cmd, *format, "cluster destroy", err)
+				}
+				if result.RegistryRemoved {
+					if err := reg.ClearPause(name); err != nil {
+						return writeFailure(cmd, *format, "cluster destroy", err)
+					}
+				}
+			}
+
+			resp := output.Success("cluster destroy", result)
 			out, err := output.FormatResponse(resp, *format)
 			if err != nil {
 				return err
@@ -217,6 +425,9 @@ func newClusterDestroyCmd(format *output.Format, reg *cluster.Registry) *cobra.C
 	}
 	cmd.Flags().StringVar(&name, "name", "", "Cluster name")
 	cmd.Flags().BoolVar(&confirm, "confirm", false, "Confirm destruction of cluster")
+	cmd.Flags().BoolVar(&purgeData, "purge-data", false, "Also delete data volumes (default: keep them)")
+	cmd.Flags().BoolVar(&force, "force", false, "Remove the registry entry even if some nodes could not be destroyed")
+	cmd.Flags().BoolVar(&progress, "progress", false, "Stream step events to stderr as NDJSON")
 	return cmd
 }
 
@@ -235,6 +446,61 @@ func resolvePatroniURL(name, patroniURL string, reg *cluster.Registry) (string,
 	return entry.PatroniURL, nil
 }
 
+// resolvePatroniClient returns a client for the Patroni API of cluster name
+// or, if given, patroniURL; see patroniClient.
+func resolvePatroniClient(name, patroniURL string, reg *cluster.Registry) (*patroni.Client, error) {
+	url, err := resolvePatroniURL(name, patroniURL, reg)
+	if err != nil {
+		return nil, err
+	}
+	var access *cluster.PatroniAccess
+	if patroniURL == "" {
+		entry, err := reg.Get(name)
+		if err != nil {
+			return nil, err
+		}
+		access = entry.PatroniAccess
+	}
+	return patroniClient(url, access)
+}
+
+// entryPatroniClient returns a client for the Patroni API of entry.
+func entryPatroniClient(entry *cluster.Entry) (*patroni.Client, error) {
+	return patroniClient(entry.PatroniURL, entry.PatroniAccess)
+}
+
+// patroniClient returns a client for the Patroni API at url. Settings missing
+// from access (nil for clusters given by --patroni-url) are taken from
+// PGDBA_PATRONI_USER, PGDBA_PATRONI_CA_FILE, PGDBA_PATRONI_CERT_FILE and
+// PGDBA_PATRONI_KEY_FILE; the password from patroni.Password.
+func patroniClient(url string, access *cluster.PatroniAccess) (*patroni.Client, error) {
+	var a cluster.PatroniAccess
+	if access != nil {
+		a = *access
+	}
+	orEnv := func(v, env string) string {
+		if v == "" {
+			return os.Getenv(env)
+		}
+		return v
+	}
+	opts := patroni.Options{
+		Username:  orEnv(a.Username, "PGDBA_PATRONI_USER"),
+		CAFile:    orEnv(a.CAFile, "PGDBA_PATRONI_CA_FILE"),
+		CertFile:  orEnv(a.CertFile, "PGDBA_PATRONI_CERT_FILE"),
+		KeyFile:   orEnv(a.KeyFile, "PGDBA_PATRONI_KEY_FILE"),
+		Endpoints: a.Endpoints,
+	}
+	if opts.Username != "" {
+		pw, err := patroni.Password()
+		if err != nil {
+			return nil, err
+		}
+		opts.Password = pw
+	}
+	return patroni.NewClientWithOptions(url, opts)
+}
+
 // buildStatusResult converts a ClusterStatus into a ClusterStatusResult.
 func buildStatusResult(name string, cs *patroni.ClusterStatus) *ClusterStatusResult {
 	result := &ClusterStatusResult{
@@ -262,9 +528,27 @@ func buildStatusResult(name string, cs *patroni.ClusterStatus) *ClusterStatusRes
 	return result
 }
 
+// writeSuccess emits the success response of command carrying data.
+func writeSuccess(cmd *cobra.Command, format output.Format, command string, data interface{}) error {
+	resp := output.Success(command, data)
+	out, err := output.FormatResponse(resp, format)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(cmd.OutOrStdout(), out)
+	return nil
+}
+
 // writeFailure emits a JSON failure response and returns the original error.
 func writeFailure(cmd *cobra.Command, format output.Format, command string, err error) error {
+	return writeFailureWith(cmd, format, command, nil, err)
+}
+
+// writeFailureWith is writeFailure for a command that got part of the way:
+// the failure response also carries data.
+func writeFailureWith(cmd *cobra.Command, format output.Format, command string, data interface{}, err error) error {
 	resp := output.Failure(command, err)
+	resp.Data = data
 	out, fmtErr := output.FormatResponse(resp, format)
 	if fmtErr != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
@@ -0,0 +1,314 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/luckyjian/pgdba/internal/cluster"
+	"github.com/luckyjian/pgdba/internal/config"
+	"github.com/luckyjian/pgdba/internal/inspect"
+	"github.com/luckyjian/pgdba/internal/output"
+	"github.com/luckyjian/pgdba/internal/patroni"
+	"github.com/luckyjian/pgdba/internal/postgres"
+	"github.com/luckyjian/pgdba/internal/tuning"
+)
+
+// ConfigPatchResult is the data of "cluster config patch" and "cluster config
+// edit" responses.
+type ConfigPatchResult struct {
+	Patch    map[string]interface{} `json:"patch"`
+	Diff     patroni.ConfigDiff     `json:"diff"`
+	Warnings []string               `json:"warnings,omitempty"`
+	Applied  bool                   `json:"applied"`
+}
+
+// newClusterConfigCmd returns the "cluster config" parent command.
+func newClusterConfigCmd(cfg *config.Config, format *output.Format, reg *cluster.Registry) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "View and change Patroni's dynamic configuration (get, patch, edit)",
+	}
+	cmd.AddCommand(
+		newClusterConfigGetCmd(format, reg),
+		newClusterConfigPatchCmd(cfg, format, reg),
+		newClusterConfigEditCmd(cfg, format, reg),
+	)
+	return cmd
+}
+
+// newClusterConfigGetCmd returns "cluster config get".
+func newClusterConfigGetCmd(format *output.Format, reg *cluster.Registry) *cobra.Command {
+	var name, patroniURL string
+
+	cmd := &cobra.Command{
+		Use:   "get",
+		Short: "Show the dynamic configuration stored in the DCS (GET /config)",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := resolvePatroniClient(name, patroniURL, reg)
+			if err != nil {
+				return writeFailure(cmd, *format, "cluster config get", err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			dcs, err := client.GetConfig(ctx)
+			if err != nil {
+				return writeFailure(cmd, *format, "cluster config get", err)
+			}
+
+			resp := output.Success("cluster config get", dcs)
+			out, err := output.FormatResponse(resp, *format)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), out)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&name, "name", "", "Cluster name (looks up Patroni URL from registry)")
+	cmd.Flags().StringVar(&patroniURL, "patroni-url", "", "Patroni API URL")
+	return cmd
+}
+
+// newClusterConfigPatchCmd returns "cluster config patch".
+func newClusterConfigPatchCmd(cfg *config.Config, format *output.Format, reg *cluster.Registry) *cobra.Command {
+	var name, patroniURL, file string
+	var sets []string
+	var dryRun bool
+
+	cmd := &cobra.Command{
+		Use:   "patch",
+		Short: "Change the dynamic configuration (PATCH /config)",
+		Long: "Merge --set key=value pairs and/or a YAML or JSON --file into the dynamic\n" +
+			"configuration. Keys are dotted paths (ttl, postgresql.parameters.work_mem); a value\n" +
+			"of null removes the key. The change is shown as a diff and validated first: Patroni's\n" +
+			"timing rules, and PostgreSQL parameters against pg_settings of the cluster.\n" +
+			"A paused cluster is refused, except with --dry-run.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(sets) == 0 && file == "" {
+				return writeFailure(cmd, *format, "cluster config patch", fmt.Errorf("--set or --file is required"))
+			}
+			patch := map[string]interface{}{}
+			if file != "" {
+				data, err := os.ReadFile(file)
+				if err != nil {
+					return writeFailure(cmd, *format, "cluster config patch", fmt.Errorf("read patch file: %w", err))
+				}
+				if err := yaml.Unmarshal(data, &patch); err != nil {
+					return writeFailure(cmd, *format, "cluster config patch", fmt.Errorf("parse patch file: %w", err))
+				}
+			}
+			for _, s := range sets {
+				key, raw, ok := strings.Cut(s, "=")
+				if !ok || key == "" {
+					return writeFailure(cmd, *format, "cluster config patch",
+						fmt.Errorf("invalid --set %q: expected key=value", s))
+				}
+				var value interface{}
+				if err := yaml.Unmarshal([]byte(raw), &value); err != nil {
+					value = raw
+				}
+				patroni.SetConfigPath(patch, patroni.ConfigPath(key), value)
+			}
+			client, err := resolvePatroniClient(name, patroniURL, reg)
+			if err != nil {
+				return writeFailure(cmd, *format, "cluster config patch", err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			if err := checkPaused(ctx, cmd, reg, name, client, !dryRun); err != nil {
+				return writeFailure(cmd, *format, "cluster config patch", err)
+			}
+			current, err := client.GetConfig(ctx)
+			if err != nil {
+				return writeFailure(cmd, *format, "cluster config patch", err)
+			}
+			result, err := patchDynamicConfig(ctx, client, current, patch, dryRun, dcsSettingsLoader(name, cfg, reg))
+			if err != nil {
+				return writeFailure(cmd, *format, "cluster config patch", err)
+			}
+
+			resp := output.Success("cluster config patch", result)
+			out, err := output.FormatResponse(resp, *format)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), out)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&name, "name", "", "Cluster name (looks up Patroni URL from registry)")
+	cmd.Flags().StringVar(&patroniURL, "patroni-url", "", "Patroni API URL")
+	cmd.Flags().StringArrayVar(&sets, "set", nil, "key=value to set (repeatable), e.g. postgresql.parameters.work_mem=64MB")
+	cmd.Flags().StringVarP(&file, "file", "f", "", "Patch file (YAML or JSON)")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show the diff and validation without patching")
+	return cmd
+}
+
+// newClusterConfigEditCmd returns "cluster config edit".
+func newClusterConfigEditCmd(cfg *config.Config, format *output.Format, reg *cluster.Registry) *cobra.Command {
+	var name, patroniURL string
+	var dryRun bool
+
+	cmd := &cobra.Command{
+		Use:   "edit",
+		Short: "Edit the dynamic configuration in $EDITOR",
+		Long: "Open the dynamic configuration as YAML in $VISUAL or $EDITOR (default vi). When the\n" +
+			"editor exits, the changes are diffed, validated as by 'cluster config patch' and sent\n" +
+			"as a single PATCH /config. Keys deleted in the editor are removed. A paused\n" +
+			"cluster is refused, except with --dry-run.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := resolvePatroniClient(name, patroniURL, reg)
+			if err != nil {
+				return writeFailure(cmd, *format, "cluster config edit", err)
+			}
+			getCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			err = checkPaused(getCtx, cmd, reg, name, client, !dryRun)
+			var current map[string]interface{}
+			if err == nil {
+				current, err = client.GetConfig(getCtx)
+			}
+			cancel()
+			if err != nil {
+				return writeFailure(cmd, *format, "cluster config edit", err)
+			}
+
+			edited, err := editConfig(cmd, current)
+			if err != nil {
+				return writeFailure(cmd, *format, "cluster config edit", err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			result, err := patchDynamicConfig(ctx, client, current, patroni.EditPatch(current, edited),
+				dryRun, dcsSettingsLoader(name, cfg, reg))
+			if err != nil {
+				return writeFailure(cmd, *format, "cluster config edit", err)
+			}
+
+			resp := output.Success("cluster config edit", result)
+			out, err := output.FormatResponse(resp, *format)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), out)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&name, "name", "", "Cluster name (looks up Patroni URL from registry)")
+	cmd.Flags().StringVar(&patroniURL, "patroni-url", "", "Patroni API URL")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show the diff and validation without patching")
+	return cmd
+}
+
+// settingsLoader returns pg_settings of the cluster being patched.
+type settingsLoader func(ctx context.Context) ([]inspect.PGSetting, error)
+
+// dcsSettingsLoader reads pg_settings from the PostgreSQL instance of cluster
+// name (or the configured instance).
+func dcsSettingsLoader(name string, cfg *config.Config, reg *cluster.Registry) settingsLoader {
+	return func(ctx context.Context) ([]inspect.PGSetting, error) {
+		pgCfg, err := resolvePGConfig(name, cfg, reg)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := postgres.Connect(ctx, pgCfg)
+		if err != nil {
+			return nil, fmt.Errorf("connect to postgres: %w", err)
+		}
+		defer conn.Close(ctx)
+		return inspect.NewPgxDB(conn).PGSettings(ctx)
+	}
+}
+
+// patchDynamicConfig diffs and validates patch against the current dynamic
+// configuration and, unless dryRun is set or validation fails, sends it.
+// pg_settings is only read when the patch touches PostgreSQL parameters.
+func patchDynamicConfig(ctx context.Context, client *patroni.Client, current, patch map[string]interface{},
+	dryRun bool, load settingsLoader) (*ConfigPatchResult, error) {
+	merged := patroni.MergeConfig(current, patch)
+	result := &ConfigPatchResult{Patch: patch, Diff: patroni.DiffConfig(current, merged)}
+	if result.Diff.Empty() {
+		return result, nil
+	}
+
+	errs := patroni.ValidateTiming(merged)
+	if len(result.Diff.Parameters) > 0 {
+		settings, err := load(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("read pg_settings to validate parameters: %w", err)
+		}
+		params := map[string]interface{}{}
+		for _, c := range result.Diff.Parameters {
+			params[c.Key] = c.To
+		}
+		check := tuning.ValidateDCSParameters(settings, params)
+		errs = append(errs, check.Errors...)
+		result.Warnings = check.Warnings
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid configuration: %s", strings.Join(errs, "; "))
+	}
+	if dryRun {
+		return result, nil
+	}
+	if err := client.PatchConfig(ctx, patch); err != nil {
+		return nil, fmt.Errorf("patch config: %w", err)
+	}
+	result.Applied = true
+	return result, nil
+}
+
+// editConfig writes current to a temporary YAML file, opens it in the
+// user's editor and returns the edited configuration.
+func editConfig(cmd *cobra.Command, current map[string]interface{}) (map[string]interface{}, error) {
+	data, err := yaml.Marshal(current)
+	if err != nil {
+		return nil, fmt.Errorf("marshal config: %w", err)
+	}
+	f, err := os.CreateTemp("", "pgdba-config-*.yaml")
+	if err != nil {
+		return nil, fmt.Errorf("create temp file: %w", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("write temp file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return nil, fmt.Errorf("write temp file: %w", err)
+	}
+
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	argv := append(strings.Fields(editor), f.Name())
+	run := exec.Command(argv[0], argv[1:]...)
+	run.Stdin = os.Stdin
+	run.Stdout = cmd.ErrOrStderr()
+	run.Stderr = cmd.ErrOrStderr()
+	if err := run.Run(); err != nil {
+		return nil, fmt.Errorf("run editor %q: %w", editor, err)
+	}
+
+	data, err = os.ReadFile(f.Name())
+	if err != nil {
+		return nil, fmt.Errorf("read edited config: %w", err)
+	}
+	edited := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &edited); err != nil {
+		return nil, fmt.Errorf("parse edited config: %w", err)
+	}
+	return edited, nil
+}
@@ -0,0 +1,195 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/luckyjian/pgdba/internal/cluster"
+	"github.com/luckyjian/pgdba/internal/dcs"
+	"github.com/luckyjian/pgdba/internal/lifecycle"
+	"github.com/luckyjian/pgdba/internal/output"
+	"github.com/luckyjian/pgdba/internal/patroni"
+)
+
+// newClusterDCSCmd returns the "cluster dcs" parent command.
+func newClusterDCSCmd(format *output.Format, reg *cluster.Registry) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "dcs",
+		Short: "Read Patroni's keys straight from etcd (dump, check)",
+	}
+	cmd.AddCommand(
+		newClusterDCSDumpCmd(format, reg),
+		newClusterDCSCheckCmd(format, reg),
+	)
+	return cmd
+}
+
+// dcsFlags are the flags shared by the "cluster dcs" sub-commands.
+type dcsFlags struct {
+	name      string
+	endpoints string
+	namespace string
+	scope     string
+}
+
+func (f *dcsFlags) register(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&f.name, "name", "", "Cluster name (looks up etcd endpoints and scope from registry)")
+	cmd.Flags().StringVar(&f.endpoints, "etcd-endpoints", "", "Comma-separated etcd endpoints (host:port or URL); defaults to the registry's")
+	cmd.Flags().StringVar(&f.namespace, "namespace", "", "Patroni DCS namespace (default /db/ for managed clusters, /service/ otherwise)")
+	cmd.Flags().StringVar(&f.scope, "scope", "", "Patroni scope (default: --name)")
+}
+
+// resolve returns the etcd client, namespace and scope to read, and the
+// registry entry of --name if there is one.
+func (f *dcsFlags) resolve(reg *cluster.Registry) (*dcs.EtcdClient, string, string, *cluster.Entry, error) {
+	var entry *cluster.Entry
+	if f.name != "" {
+		e, err := reg.Get(f.name)
+		if err != nil {
+			return nil, "", "", nil, err
+		}
+		entry = e
+	}
+
+	endpoints := splitList(f.endpoints)
+	if len(endpoints) == 0 && entry != nil {
+		endpoints = entry.EtcdEndpoints
+	}
+	if len(endpoints) == 0 {
+		return nil, "", "", nil, fmt.Errorf("--etcd-endpoints is required (no etcd endpoints recorded for the cluster)")
+	}
+
+	scope := f.scope
+	if scope == "" {
+		scope = f.name
+	}
+	if scope == "" {
+		return nil, "", "", nil, fmt.Errorf("--scope or --name is required")
+	}
+
+	namespace := f.namespace
+	if namespace == "" {
+		namespace = dcs.DefaultNamespace
+		if entry != nil && entry.Source == cluster.SourceManaged {
+			namespace = lifecycle.PatroniNamespace
+		}
+	}
+	return dcs.NewEtcdClient(endpoints), namespace, scope, entry, nil
+}
+
+// newClusterDCSDumpCmd returns "cluster dcs dump".
+func newClusterDCSDumpCmd(format *output.Format, reg *cluster.Registry) *cobra.Command {
+	var flags dcsFlags
+
+	cmd := &cobra.Command{
+		Use:   "dump",
+		Short: "Show the cluster's keys in etcd (leader, members, config, history, initialize, failover)",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, namespace, scope, _, err := flags.resolve(reg)
+			if err != nil {
+				return writeFailure(cmd, *format, "cluster dcs dump", err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			snap, err := dcs.Dump(ctx, client, namespace, scope)
+			if err != nil {
+				return writeFailure(cmd, *format, "cluster dcs dump", err)
+			}
+
+			resp := output.Success("cluster dcs dump", snap)
+			out, err := output.FormatResponse(resp, *format)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), out)
+			return nil
+		},
+	}
+	flags.register(cmd)
+	return cmd
+}
+
+// newClusterDCSCheckCmd returns "cluster dcs check".
+func newClusterDCSCheckCmd(format *output.Format, reg *cluster.Registry) *cobra.Command {
+	var flags dcsFlags
+
+	cmd := &cobra.Command{
+		Use:   "check",
+		Short: "Compare the DCS leader lock with what each member's REST API reports",
+		Long: "Read the leader key and its lease from etcd and ask every member listed in the DCS\n" +
+			"for its role through its own REST API (api_url). Flags split-brain (more than one\n" +
+			"primary, or a primary not holding the lock), stale leader locks (no live lease, or\n" +
+			"held by a member that is not primary), lease TTLs that differ from the configured\n" +
+			"ttl and unreachable members. healthy is false when any finding has severity fail.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, namespace, scope, entry, err := flags.resolve(reg)
+			if err != nil {
+				return writeFailure(cmd, *format, "cluster dcs check", err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+			defer cancel()
+
+			snap, err := dcs.Dump(ctx, client, namespace, scope)
+			if err != nil {
+				return writeFailure(cmd, *format, "cluster dcs check", err)
+			}
+			views, err := memberViews(ctx, snap, entry)
+			if err != nil {
+				return writeFailure(cmd, *format, "cluster dcs check", err)
+			}
+
+			resp := output.Success("cluster dcs check", dcs.Check(snap, views))
+			out, err := output.FormatResponse(resp, *format)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), out)
+			return nil
+		},
+	}
+	flags.register(cmd)
+	return cmd
+}
+
+// memberViews asks each member of snap for its role through its own REST
+// API, with the Patroni credentials of entry (nil: from the environment).
+func memberViews(ctx context.Context, snap *dcs.Snapshot, entry *cluster.Entry) ([]dcs.MemberView, error) {
+	var url string
+	var access *cluster.PatroniAccess
+	if entry != nil {
+		url, access = entry.PatroniURL, entry.PatroniAccess
+	}
+	base, err := patroniClient(url, access)
+	if err != nil {
+		return nil, err
+	}
+
+	views := make([]dcs.MemberView, 0, len(snap.Members))
+	for _, m := range snap.Members {
+		view := dcs.MemberView{Name: m.Name, APIURL: m.APIURL}
+		info, err := memberNodeInfo(ctx, base, patroni.Member{Name: m.Name, APIURL: m.APIURL})
+		if err != nil {
+			view.Error = err.Error()
+		} else {
+			view.Reachable = true
+			view.Role = info.Role
+			view.State = string(info.State)
+			view.Timeline = info.Timeline
+		}
+		views = append(views, view)
+	}
+	return views, nil
+}
+
+// memberNodeInfo queries GET /patroni on member m itself.
+func memberNodeInfo(ctx context.Context, base *patroni.Client, m patroni.Member) (*patroni.NodeInfo, error) {
+	client, err := base.ForMember(m)
+	if err != nil {
+		return nil, err
+	}
+	return client.GetNodeInfo(ctx)
+}
@@ -0,0 +1,90 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/luckyjian/pgdba/internal/cluster"
+	"github.com/luckyjian/pgdba/internal/failover"
+	"github.com/luckyjian/pgdba/internal/output"
+)
+
+// HistoryResult is the data of a "cluster history" response.
+type HistoryResult struct {
+	Cluster string `json:"cluster,omitempty"`
+	*failover.History
+}
+
+// TableHeader implements output.Table.
+func (r HistoryResult) TableHeader() []string {
+	return []string{"TIMELINE", "ENDED AT LSN", "REASON", "TIMESTAMP", "NEW LEADER", "MEMBERS ON TIMELINE"}
+}
+
+// TableRows implements output.Table: one row per past timeline, then the
+// current one.
+func (r HistoryResult) TableRows() [][]string {
+	orDash := func(s string) string {
+		if s == "" {
+			return "-"
+		}
+		return s
+	}
+	rows := make([][]string, 0, len(r.Entries)+1)
+	for _, e := range r.Entries {
+		rows = append(rows, []string{strconv.FormatInt(e.Timeline, 10), e.LSN, orDash(e.Reason),
+			orDash(e.Timestamp), orDash(e.NewLeader), orDash(strings.Join(e.Members, ","))})
+	}
+	rows = append(rows, []string{strconv.FormatInt(r.CurrentTimeline, 10), "-", "(current)", "-", "-",
+		orDash(strings.Join(r.Current, ","))})
+	return rows
+}
+
+// newClusterHistoryCmd returns "cluster history" which shows timeline switches from Patroni.
+func newClusterHistoryCmd(format *output.Format, reg *cluster.Registry) *cobra.Command {
+	var name, patroniURL string
+
+	cmd := &cobra.Command{
+		Use:   "history",
+		Short: "Show the timeline and failover history from Patroni",
+		Long: "Read Patroni's timeline history (GET /history): for each past timeline, the LSN it\n" +
+			"ended at, the reason, when it happened and the new leader. Members still reporting a\n" +
+			"past timeline are listed next to it and under stale_members: they did not follow a\n" +
+			"switchover or failover and usually have to be re-initialized.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := resolvePatroniClient(name, patroniURL, reg)
+			if err != nil {
+				return writeFailure(cmd, *format, "cluster history", err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			history, err := client.GetHistory(ctx)
+			if err != nil {
+				return writeFailure(cmd, *format, "cluster history", err)
+			}
+			cs, err := client.GetClusterStatus(ctx)
+			if err != nil {
+				return writeFailure(cmd, *format, "cluster history",
+					fmt.Errorf("get cluster status: %w", err))
+			}
+
+			result := HistoryResult{Cluster: name, History: failover.BuildHistory(history, cs)}
+			resp := output.Success("cluster history", result)
+			out, err := output.FormatResponse(resp, *format)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), out)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&name, "name", "", "Cluster name (looks up Patroni URL from registry)")
+	cmd.Flags().StringVar(&patroniURL, "patroni-url", "", "Patroni API URL")
+	return cmd
+}
@@ -0,0 +1,239 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/luckyjian/pgdba/internal/cluster"
+	"github.com/luckyjian/pgdba/internal/output"
+	"github.com/luckyjian/pgdba/internal/patroni"
+)
+
+// PauseResult is the data of "cluster pause" and "cluster resume" responses.
+type PauseResult struct {
+	Cluster string               `json:"cluster"`
+	Paused  bool                 `json:"paused"`
+	Record  *cluster.PauseRecord `json:"record,omitempty"` // the pause set, or the one lifted
+}
+
+// newClusterPauseCmd returns "cluster pause".
+func newClusterPauseCmd(format *output.Format, reg *cluster.Registry) *cobra.Command {
+	var name, reason, by string
+	var deadline time.Duration
+
+	cmd := &cobra.Command{
+		Use:   "pause",
+		Short: "Put a cluster into Patroni maintenance mode",
+		Long: "Pause Patroni (PATCH /config {\"pause\": true}): it stops managing PostgreSQL and no\n" +
+			"automatic failover happens. Who paused the cluster and why is recorded next to the\n" +
+			"registry. --deadline records when the pause should end. Nothing runs in the\n" +
+			"background to end it: once the deadline has passed, the next pgdba command that\n" +
+			"changes the cluster resumes it, and so does 'cluster resume --if-expired' (schedule\n" +
+			"it, e.g. from cron, to enforce the deadline unattended). Status commands only\n" +
+			"report the pause as expired.\n" +
+			"While paused, commands that need Patroni to act refuse to run; others warn.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if name == "" {
+				return writeFailure(cmd, *format, "cluster pause", fmt.Errorf("--name is required"))
+			}
+			if reason == "" {
+				return writeFailure(cmd, *format, "cluster pause", fmt.Errorf("--reason is required"))
+			}
+			if deadline < 0 {
+				return writeFailure(cmd, *format, "cluster pause", fmt.Errorf("--deadline must not be negative"))
+			}
+			entry, err := reg.Get(name)
+			if err != nil {
+				return writeFailure(cmd, *format, "cluster pause", err)
+			}
+			if by == "" {
+				by = pauseActor()
+			}
+
+			client, err := entryPatroniClient(entry)
+			if err != nil {
+				return writeFailure(cmd, *format, "cluster pause", err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			if err := client.SetPause(ctx, true); err != nil {
+				return writeFailure(cmd, *format, "cluster pause", fmt.Errorf("pause patroni: %w", err))
+			}
+			rec := cluster.PauseRecord{Cluster: name, By: by, Reason: reason, PausedAt: time.Now().UTC()}
+			if deadline > 0 {
+				expires := rec.PausedAt.Add(deadline)
+				rec.ExpiresAt = &expires
+			}
+			if err := reg.SetPause(rec); err != nil {
+				return writeFailure(cmd, *format, "cluster pause", err)
+			}
+
+			resp := output.Success("cluster pause", PauseResult{Cluster: name, Paused: true, Record: &rec})
+			out, err := output.FormatResponse(resp, *format)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), out)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&name, "name", "", "Cluster name")
+	cmd.Flags().StringVar(&reason, "reason", "", "Why the cluster is paused (recorded)")
+	cmd.Flags().StringVar(&by, "by", "", "Who pauses the cluster (default: user@host)")
+	cmd.Flags().DurationVar(&deadline, "deadline", 0,
+		"How long from now the pause may last; enforced by later pgdba commands, not a timer (0 = until resumed)")
+	cmd.Flags().DurationVar(&deadline, "ttl", 0, "Deprecated alias of --deadline")
+	_ = cmd.Flags().MarkDeprecated("ttl", "use --deadline; the pause is not lifted by a timer")
+	return cmd
+}
+
+// newClusterResumeCmd returns "cluster resume".
+func newClusterResumeCmd(format *output.Format, reg *cluster.Registry) *cobra.Command {
+	var name string
+	var ifExpired bool
+
+	cmd := &cobra.Command{
+		Use:   "resume",
+		Short: "Take a cluster out of Patroni maintenance mode",
+		Long: "Resume Patroni (PATCH /config {\"pause\": false}) and drop the pause record. With\n" +
+			"--if-expired, only a pause whose --deadline has passed is lifted.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if name == "" {
+				return writeFailure(cmd, *format, "cluster resume", fmt.Errorf("--name is required"))
+			}
+			entry, err := reg.Get(name)
+			if err != nil {
+				return writeFailure(cmd, *format, "cluster resume", err)
+			}
+			rec, err := reg.GetPause(name)
+			if err != nil {
+				return writeFailure(cmd, *format, "cluster resume", err)
+			}
+
+			result := PauseResult{Cluster: name, Record: rec}
+			if ifExpired && (rec == nil || !rec.Expired(time.Now())) {
+				result.Paused = rec != nil
+			} else {
+				client, err := entryPatroniClient(entry)
+				if err != nil {
+					return writeFailure(cmd, *format, "cluster resume", err)
+				}
+				ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+				defer cancel()
+				if err := client.SetPause(ctx, false); err != nil {
+					return writeFailure(cmd, *format, "cluster resume", fmt.Errorf("resume patroni: %w", err))
+				}
+				if err := reg.ClearPause(name); err != nil {
+					return writeFailure(cmd, *format, "cluster resume", err)
+				}
+			}
+
+			resp := output.Success("cluster resume", result)
+			out, err := output.FormatResponse(resp, *format)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), out)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&name, "name", "", "Cluster name")
+	cmd.Flags().BoolVar(&ifExpired, "if-expired", false, "Only resume if the pause deadline has passed")
+	return cmd
+}
+
+// pauseActor identifies the local user for pause records.
+func pauseActor() string {
+	name := os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	if host, err := os.Hostname(); err == nil {
+		return name + "@" + host
+	}
+	return name
+}
+
+// PauseStatus is a pause record as the read-only status commands report it.
+// They leave an expired pause in place for a command that changes the
+// cluster, or 'cluster resume', to lift.
+type PauseStatus struct {
+	*cluster.PauseRecord
+	Expired bool `json:"expired,omitempty"`
+}
+
+// readPause returns the pause record of cluster name without lifting it, or
+// nil when there is none. Clusters addressed only by Patroni URL (empty
+// name) have no record.
+func readPause(reg *cluster.Registry, name string) (*PauseStatus, error) {
+	if name == "" {
+		return nil, nil
+	}
+	rec, err := reg.GetPause(name)
+	if err != nil || rec == nil {
+		return nil, err
+	}
+	return &PauseStatus{PauseRecord: rec, Expired: rec.Expired(time.Now())}, nil
+}
+
+// liftExpiredPause resumes cluster name if pgdba paused it with a deadline
+// that has passed. It returns the pause record still in effect, if any. Clusters
+// addressed only by Patroni URL (empty name) have no record.
+func liftExpiredPause(ctx context.Context, reg *cluster.Registry, name string, client *patroni.Client) (*cluster.PauseRecord, error) {
+	if name == "" {
+		return nil, nil
+	}
+	rec, err := reg.GetPause(name)
+	if err != nil || rec == nil || !rec.Expired(time.Now()) {
+		return rec, err
+	}
+	if err := client.SetPause(ctx, false); err != nil {
+		return rec, fmt.Errorf("resume cluster %q after its pause expired: %w", name, err)
+	}
+	return nil, reg.ClearPause(name)
+}
+
+// checkPaused runs before a command changes cluster name (reached through
+// client). It lifts an expired pause and then, if Patroni reports the cluster
+// as paused, returns an error when refuse is set, or writes a JSON warning
+// line to stderr otherwise. An unreachable Patroni is left for the command
+// itself to report; with refuse unset, so is a failure to lift the pause.
+func checkPaused(ctx context.Context, cmd *cobra.Command, reg *cluster.Registry, name string,
+	client *patroni.Client, refuse bool) error {
+	warn := func(msg string) {
+		_ = json.NewEncoder(cmd.ErrOrStderr()).Encode(map[string]string{"warning": msg})
+	}
+	rec, err := liftExpiredPause(ctx, reg, name, client)
+	if err != nil {
+		if refuse {
+			return err
+		}
+		warn(err.Error())
+	}
+	cs, err := client.GetClusterStatus(ctx)
+	if err != nil || !cs.Pause {
+		return nil
+	}
+
+	msg := "cluster is paused (Patroni maintenance mode)"
+	if name != "" {
+		msg = fmt.Sprintf("cluster %q is paused (Patroni maintenance mode)", name)
+	}
+	if rec != nil {
+		msg += fmt.Sprintf(" by %s: %s", rec.By, rec.Reason)
+		if rec.ExpiresAt != nil {
+			msg += " until " + rec.ExpiresAt.Format(time.RFC3339)
+		}
+	}
+	if refuse {
+		return fmt.Errorf("%s; run 'pgdba cluster resume' first", msg)
+	}
+	warn(msg)
+	return nil
+}
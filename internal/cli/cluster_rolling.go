@@ -0,0 +1,127 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/luckyjian/pgdba/internal/cluster"
+	"github.com/luckyjian/pgdba/internal/config"
+	"github.com/luckyjian/pgdba/internal/lifecycle"
+	"github.com/luckyjian/pgdba/internal/output"
+	"github.com/luckyjian/pgdba/internal/provider"
+)
+
+// newClusterRollingRestartCmd returns "cluster rolling-restart".
+func newClusterRollingRestartCmd(cfg *config.Config, format *output.Format, reg *cluster.Registry) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rolling-restart",
+		Short: "Restart every member one at a time, the leader last after a switchover",
+		Long: "Restart replicas one at a time through each member's own Patroni REST API, waiting\n" +
+			"for each to stream again within the cluster's lag policy (see 'failover policy'), then\n" +
+			"switch over and restart the old leader. Progress is recorded next to the registry;\n" +
+			"rerunning an interrupted operation resumes it.",
+	}
+	return rollingCmd(cmd, cfg, format, reg, lifecycle.RollingRestart)
+}
+
+// newClusterRollingUpgradeCmd returns "cluster rolling-upgrade".
+func newClusterRollingUpgradeCmd(cfg *config.Config, format *output.Format, reg *cluster.Registry) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rolling-upgrade",
+		Short: "Move every member to a new image one at a time (minor-version upgrade)",
+		Long: "Recreate members on --image one at a time through the provider, replicas first, waiting\n" +
+			"for each to stream again within the cluster's lag policy (see 'failover policy'), then\n" +
+			"switch over and upgrade the old leader. Only managed clusters on docker or kubernetes\n" +
+			"are supported. Progress is recorded next to the registry; rerunning an interrupted\n" +
+			"operation resumes it.",
+	}
+	return rollingCmd(cmd, cfg, format, reg, lifecycle.RollingUpgrade)
+}
+
+// rollingCmd adds the shared flags and RunE of the rolling commands to cmd.
+func rollingCmd(cmd *cobra.Command, cfg *config.Config, format *output.Format, reg *cluster.Registry, kind string) *cobra.Command {
+	var name, image string
+	var lag lagFlags
+	var progress bool
+	var timeout time.Duration
+	command := "cluster " + kind
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		if name == "" {
+			return writeFailure(cmd, *format, command, fmt.Errorf("--name is required"))
+		}
+		if kind == lifecycle.RollingUpgrade && image == "" {
+			return writeFailure(cmd, *format, command, fmt.Errorf("--image is required"))
+		}
+		entry, err := reg.Get(name)
+		if err != nil {
+			return writeFailure(cmd, *format, command, err)
+		}
+		policy, err := lag.resolve(reg, name)
+		if err != nil {
+			return writeFailure(cmd, *format, command, err)
+		}
+		var prov provider.Provider
+		if entry.Source == cluster.SourceManaged {
+			if prov, err = newProvider(cfg, entry.Provider, name); err != nil {
+				return writeFailure(cmd, *format, command, err)
+			}
+		} else if kind == lifecycle.RollingUpgrade {
+			return writeFailure(cmd, *format, command,
+				fmt.Errorf("cluster %q is not managed by pgdba; cannot change its images", name))
+		}
+
+		opts := lifecycle.RollingOptions{
+			Kind:      kind,
+			Image:     image,
+			Lag:       policy,
+			Timeout:   timeout,
+			StatePath: lifecycle.OperationStatePath(reg.Dir(), name, kind),
+		}
+		if progress {
+			enc := json.NewEncoder(cmd.ErrOrStderr())
+			opts.OnEvent = func(ev lifecycle.StepEvent) { _ = enc.Encode(ev) }
+		}
+
+		client, err := entryPatroniClient(entry)
+		if err != nil {
+			return writeFailure(cmd, *format, command, err)
+		}
+		if err := checkPaused(context.Background(), cmd, reg, name, client, true); err != nil {
+			return writeFailure(cmd, *format, command, err)
+		}
+		result, err := lifecycle.Rolling(context.Background(), prov, client, entry, opts)
+		if err != nil {
+			return writeFailure(cmd, *format, command, err)
+		}
+		if kind == lifecycle.RollingUpgrade {
+			// Members added later should start from the new image too.
+			entry.Image = image
+			if err := reg.Add(*entry); err != nil {
+				return writeFailure(cmd, *format, command, fmt.Errorf("write registry: %w", err))
+			}
+		}
+
+		resp := output.Success(command, result)
+		out, err := output.FormatResponse(resp, *format)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(cmd.OutOrStdout(), out)
+		return nil
+	}
+	cmd.Flags().StringVar(&name, "name", "", "Cluster name")
+	if kind == lifecycle.RollingUpgrade {
+		cmd.Flags().StringVar(&image, "image", "", "New PostgreSQL/Patroni image")
+	}
+	lag.register(cmd)
+	cmd.Flags().Int64Var(&lag.maxLagBytes, "max-lag", 0, "Maximum replication lag (bytes) before moving to the next member")
+	_ = cmd.Flags().MarkDeprecated("max-lag", "use --max-lag-bytes")
+	cmd.Flags().DurationVar(&timeout, "timeout", 10*time.Minute, "How long to wait for each member to catch up")
+	cmd.Flags().BoolVar(&progress, "progress", false, "Stream step events to stderr as NDJSON")
+	return cmd
+}
@@ -0,0 +1,93 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/luckyjian/pgdba/internal/cluster"
+	"github.com/luckyjian/pgdba/internal/config"
+	"github.com/luckyjian/pgdba/internal/lifecycle"
+	"github.com/luckyjian/pgdba/internal/output"
+)
+
+// newClusterScaleCmd returns "cluster scale" which adds or removes replicas of a managed cluster.
+func newClusterScaleCmd(cfg *config.Config, format *output.Format, reg *cluster.Registry) *cobra.Command {
+	var name, hosts string
+	var replicas int
+	var progress bool
+	var timeout time.Duration
+
+	cmd := &cobra.Command{
+		Use:   "scale",
+		Short: "Grow or shrink the number of replicas of a managed cluster",
+		Long: "Scale a managed cluster to --replicas replicas besides the leader. New members are\n" +
+			"provisioned through the provider and must reach the streaming state within --timeout.\n" +
+			"When shrinking, the most-lagged replicas are removed first; the best failover candidate\n" +
+			"and synchronous standbys are kept, and removed members' Patroni keys are deleted from\n" +
+			"etcd. Adding members reads PGDBA_PG_PASSWORD, PGDBA_REPLICATION_PASSWORD and\n" +
+			"PGDBA_REWIND_PASSWORD.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if name == "" {
+				return writeFailure(cmd, *format, "cluster scale",
+					fmt.Errorf("--name is required"))
+			}
+			if !cmd.Flags().Changed("replicas") {
+				return writeFailure(cmd, *format, "cluster scale",
+					fmt.Errorf("--replicas is required"))
+			}
+			entry, err := reg.Get(name)
+			if err != nil {
+				return writeFailure(cmd, *format, "cluster scale", err)
+			}
+			if entry.Source != cluster.SourceManaged {
+				return writeFailure(cmd, *format, "cluster scale",
+					fmt.Errorf("cluster %q was connected with 'cluster connect' and is not managed by pgdba; cannot scale it", name))
+			}
+			prov, err := newProvider(cfg, entry.Provider, name)
+			if err != nil {
+				return writeFailure(cmd, *format, "cluster scale", err)
+			}
+
+			opts := lifecycle.ScaleOptions{
+				Replicas:  replicas,
+				Hosts:     splitList(hosts),
+				Passwords: lifecycle.PasswordsFromEnv,
+				Timeout:   timeout,
+			}
+			if progress {
+				enc := json.NewEncoder(cmd.ErrOrStderr())
+				opts.OnEvent = func(ev lifecycle.StepEvent) { _ = enc.Encode(ev) }
+			}
+
+			client, err := entryPatroniClient(entry)
+			if err != nil {
+				return writeFailure(cmd, *format, "cluster scale", err)
+			}
+			if err := checkPaused(context.Background(), cmd, reg, name, client, true); err != nil {
+				return writeFailure(cmd, *format, "cluster scale", err)
+			}
+			result, err := lifecycle.Scale(context.Background(), prov, client, entry, opts)
+			if err != nil {
+				return writeFailure(cmd, *format, "cluster scale", err)
+			}
+
+			resp := output.Success("cluster scale", result)
+			out, err := output.FormatResponse(resp, *format)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), out)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&name, "name", "", "Cluster name")
+	cmd.Flags().IntVar(&replicas, "replicas", 0, "Desired number of replicas besides the leader")
+	cmd.Flags().StringVar(&hosts, "hosts", "", "Comma-separated advertised hosts for added members (default: node names)")
+	cmd.Flags().DurationVar(&timeout, "timeout", 10*time.Minute, "How long to wait for added members to stream")
+	cmd.Flags().BoolVar(&progress, "progress", false, "Stream step events to stderr as NDJSON")
+	return cmd
+}
@@ -0,0 +1,132 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/luckyjian/pgdba/internal/cluster"
+	"github.com/luckyjian/pgdba/internal/config"
+	"github.com/luckyjian/pgdba/internal/lifecycle"
+	"github.com/luckyjian/pgdba/internal/output"
+	"github.com/luckyjian/pgdba/internal/provider"
+	"github.com/luckyjian/pgdba/internal/spec"
+)
+
+// newClusterPlanCmd returns "cluster plan" which diffs a spec file against the live cluster.
+func newClusterPlanCmd(cfg *config.Config, format *output.Format, reg *cluster.Registry) *cobra.Command {
+	var file string
+
+	cmd := &cobra.Command{
+		Use:   "plan",
+		Short: "Show the changes needed to converge a cluster to a spec file",
+		Long: "Compare a cluster spec (YAML) with the live cluster — Patroni /cluster and /config\n" +
+			"and the provider's nodes — and list the actions 'cluster apply' would take.\n" +
+			"Blocked actions cannot be applied automatically.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if file == "" {
+				return writeFailure(cmd, *format, "cluster plan",
+					fmt.Errorf("--file is required"))
+			}
+			s, err := spec.Load(file)
+			if err != nil {
+				return writeFailure(cmd, *format, "cluster plan", err)
+			}
+			_, st, err := observeSpec(context.Background(), cfg, reg, s)
+			if err != nil {
+				return writeFailure(cmd, *format, "cluster plan", err)
+			}
+
+			resp := output.Success("cluster plan", spec.Compute(s, st))
+			out, err := output.FormatResponse(resp, *format)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), out)
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&file, "file", "f", "", "Cluster spec file (YAML)")
+	return cmd
+}
+
+// newClusterApplyCmd returns "cluster apply" which converges a cluster to a spec file.
+func newClusterApplyCmd(cfg *config.Config, format *output.Format, reg *cluster.Registry) *cobra.Command {
+	var file string
+
+	cmd := &cobra.Command{
+		Use:   "apply",
+		Short: "Converge a cluster to a spec file",
+		Long: "Compute the plan for a cluster spec (see 'cluster plan') and carry it out: set labels,\n" +
+			"patch the Patroni DCS config, add or remove replicas and create, re-render or remove\n" +
+			"PgBouncer. Plans with blocked actions are refused, and so are plans changing more than\n" +
+			"labels while the cluster is paused. Adding members or PgBouncer reads\n" +
+			"PGDBA_PG_PASSWORD, PGDBA_REPLICATION_PASSWORD and PGDBA_REWIND_PASSWORD.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if file == "" {
+				return writeFailure(cmd, *format, "cluster apply",
+					fmt.Errorf("--file is required"))
+			}
+			s, err := spec.Load(file)
+			if err != nil {
+				return writeFailure(cmd, *format, "cluster apply", err)
+			}
+			ctx := context.Background()
+			target, st, err := observeSpec(ctx, cfg, reg, s)
+			if err != nil {
+				return writeFailure(cmd, *format, "cluster apply", err)
+			}
+			plan := spec.Compute(s, st)
+			if err := checkPaused(ctx, cmd, reg, s.Name, target.Patroni, !plan.LabelsOnly()); err != nil {
+				return writeFailure(cmd, *format, "cluster apply", err)
+			}
+			if plan.NeedsPasswords() {
+				if target.Passwords, err = lifecycle.PasswordsFromEnv(); err != nil {
+					return writeFailure(cmd, *format, "cluster apply", err)
+				}
+			}
+
+			result, err := spec.Apply(ctx, s, st, plan, target)
+			if err != nil {
+				return writeFailure(cmd, *format, "cluster apply", err)
+			}
+
+			resp := output.Success("cluster apply", result)
+			out, err := output.FormatResponse(resp, *format)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), out)
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&file, "file", "f", "", "Cluster spec file (YAML)")
+	return cmd
+}
+
+// observeSpec looks up the cluster a spec names and observes its live state.
+// Managed clusters are observed through their provider as well.
+func observeSpec(ctx context.Context, cfg *config.Config, reg *cluster.Registry, s *spec.Spec) (spec.Target, *spec.State, error) {
+	target := spec.Target{Registry: reg}
+	entry, err := reg.Get(s.Name)
+	if err != nil {
+		return target, nil, err
+	}
+	if target.Patroni, err = entryPatroniClient(entry); err != nil {
+		return target, nil, err
+	}
+
+	var prov provider.Provider
+	if entry.Source == cluster.SourceManaged {
+		if prov, err = newProvider(cfg, entry.Provider, entry.Name); err != nil {
+			return target, nil, err
+		}
+		target.Provider = prov
+	}
+	st, err := spec.Observe(ctx, entry, target.Patroni, prov)
+	if err != nil {
+		return target, nil, err
+	}
+	return target, st, nil
+}
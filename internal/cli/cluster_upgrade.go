@@ -0,0 +1,185 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/luckyjian/pgdba/internal/cluster"
+	"github.com/luckyjian/pgdba/internal/config"
+	"github.com/luckyjian/pgdba/internal/lifecycle"
+	"github.com/luckyjian/pgdba/internal/output"
+	"github.com/luckyjian/pgdba/internal/postgres"
+	"github.com/luckyjian/pgdba/internal/upgrade"
+)
+
+// upgradeMajorOutput is the data of a "cluster upgrade-major" response.
+type upgradeMajorOutput struct {
+	Readiness *upgrade.Report               `json:"readiness"`
+	Upgrade   *lifecycle.MajorUpgradeResult `json:"upgrade,omitempty"`
+}
+
+// newClusterUpgradeMajorCmd returns "cluster upgrade-major".
+func newClusterUpgradeMajorCmd(cfg *config.Config, format *output.Format, reg *cluster.Registry) *cobra.Command {
+	var name, method, image, target, targetHost, databases string
+	var to, targetReplicas int
+	var checkOnly, progress bool
+	var timeout time.Duration
+	var lag lagFlags
+
+	cmd := &cobra.Command{
+		Use:   "upgrade-major",
+		Short: "Check readiness for and run a PostgreSQL major version upgrade",
+		Long: "Run readiness checks against the live instance (extensions missing from the target\n" +
+			"version, reg* columns, removed parameters that are set, prepared transactions) and,\n" +
+			"unless --check-only is given and all checks pass, upgrade the cluster to --to.\n\n" +
+			"--method link runs pg_upgrade --link on the leader and re-clones the replicas; the\n" +
+			"nodes need both versions' binaries (see --image). --method logical bootstraps a new\n" +
+			"cluster (--target) on --image, replicates into it and cuts over, leaving the old\n" +
+			"cluster read-only. --check-only works without --name against the configured instance.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if to == 0 {
+				return writeFailure(cmd, *format, "cluster upgrade-major", fmt.Errorf("--to is required"))
+			}
+			if !checkOnly && name == "" {
+				return writeFailure(cmd, *format, "cluster upgrade-major", fmt.Errorf("--name is required"))
+			}
+			pgCfg, err := resolvePGConfig(name, cfg, reg)
+			if err != nil {
+				return writeFailure(cmd, *format, "cluster upgrade-major", err)
+			}
+
+			report, err := upgradeReadiness(pgCfg, to, method)
+			if err != nil {
+				return writeFailure(cmd, *format, "cluster upgrade-major", err)
+			}
+			result := upgradeMajorOutput{Readiness: report}
+			if !checkOnly {
+				if !report.Ready {
+					var failed []string
+					for _, c := range report.Checks {
+						if c.Status == upgrade.StatusFail {
+							failed = append(failed, c.Name)
+						}
+					}
+					return writeFailure(cmd, *format, "cluster upgrade-major",
+						fmt.Errorf("cluster is not ready for PostgreSQL %d: failed checks %s (see --check-only)",
+							to, strings.Join(failed, ", ")))
+				}
+				entry, err := reg.Get(name)
+				if err != nil {
+					return writeFailure(cmd, *format, "cluster upgrade-major", err)
+				}
+
+				policy, err := lag.resolve(reg, name)
+				if err != nil {
+					return writeFailure(cmd, *format, "cluster upgrade-major", err)
+				}
+				opts := lifecycle.MajorUpgradeOptions{
+					From:    report.FromMajor,
+					To:      to,
+					Method:  method,
+					Image:   image,
+					Lag:     policy,
+					Timeout: timeout,
+				}
+				providerType := entry.Provider
+				if method == upgrade.MethodLogical {
+					if entry.Source != cluster.SourceManaged {
+						providerType = cfg.Provider.Type
+					}
+					if target == "" {
+						target = fmt.Sprintf("%s-pg%d", name, to)
+					}
+					if targetHost == "" {
+						targetHost = lifecycle.PGNodeName(target, 0)
+					}
+					if opts.Passwords, err = lifecycle.PasswordsFromEnv(); err != nil {
+						return writeFailure(cmd, *format, "cluster upgrade-major", err)
+					}
+					opts.Databases = splitList(databases)
+					if len(opts.Databases) == 0 {
+						opts.Databases = report.Databases
+					}
+					opts.Target = lifecycle.BootstrapOptions{
+						ClusterName:   target,
+						PrimaryHost:   targetHost,
+						Replicas:      targetReplicas,
+						PGPort:        entry.PGPort,
+						DataDir:       entry.DataDir,
+						LeaderTimeout: timeout,
+					}
+				}
+				prov, err := newProvider(cfg, providerType, name)
+				if err != nil {
+					return writeFailure(cmd, *format, "cluster upgrade-major", err)
+				}
+				if progress {
+					enc := json.NewEncoder(cmd.ErrOrStderr())
+					opts.OnEvent = func(ev lifecycle.StepEvent) { _ = enc.Encode(ev) }
+				}
+
+				client, err := entryPatroniClient(entry)
+				if err != nil {
+					return writeFailure(cmd, *format, "cluster upgrade-major", err)
+				}
+				if err := checkPaused(context.Background(), cmd, reg, name, client, true); err != nil {
+					return writeFailure(cmd, *format, "cluster upgrade-major", err)
+				}
+				result.Upgrade, err = lifecycle.UpgradeMajor(context.Background(), prov, reg, client, entry, opts)
+				if err != nil {
+					return writeFailure(cmd, *format, "cluster upgrade-major", err)
+				}
+			}
+
+			resp := output.Success("cluster upgrade-major", result)
+			out, err := output.FormatResponse(resp, *format)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), out)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&name, "name", "", "Cluster name")
+	cmd.Flags().IntVar(&to, "to", 0, "Target PostgreSQL major version (e.g. 17)")
+	cmd.Flags().BoolVar(&checkOnly, "check-only", false, "Only run the readiness checks")
+	cmd.Flags().StringVar(&method, "method", upgrade.MethodLink, "Upgrade method: link or logical")
+	cmd.Flags().StringVar(&image, "image", "", "Image with the new binaries (link: rolled out first; logical: new cluster image)")
+	cmd.Flags().StringVar(&target, "target", "", "Name of the new cluster for --method logical (default <name>-pg<to>)")
+	cmd.Flags().StringVar(&targetHost, "target-host", "", "Primary host of the new cluster (default: node name)")
+	cmd.Flags().IntVar(&targetReplicas, "target-replicas", 2, "Replicas of the new cluster")
+	cmd.Flags().StringVar(&databases, "databases", "", "Comma-separated databases to replicate (default: all)")
+	cmd.Flags().DurationVar(&timeout, "timeout", 10*time.Minute, "How long to wait for each step that polls")
+	lag.register(cmd)
+	cmd.Flags().BoolVar(&progress, "progress", false, "Stream step events to stderr as NDJSON")
+	return cmd
+}
+
+// upgradeReadiness runs the upgrade readiness checks against the instance
+// of pgCfg, visiting every database it serves.
+func upgradeReadiness(pgCfg postgres.Config, to int, method string) (*upgrade.Report, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	conn, err := postgres.Connect(ctx, pgCfg)
+	if err != nil {
+		return nil, fmt.Errorf("connect to postgres: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	open := func(ctx context.Context, database string) (upgrade.DB, error) {
+		c := pgCfg
+		c.Database = database
+		conn, err := postgres.Connect(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		return upgrade.NewPgxDB(conn), nil
+	}
+	return upgrade.Check(ctx, upgrade.NewPgxDB(conn), open, to, method)
+}
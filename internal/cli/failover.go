@@ -8,20 +8,26 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/luckyjian/pgdba/internal/cluster"
+	"github.com/luckyjian/pgdba/internal/config"
 	"github.com/luckyjian/pgdba/internal/failover"
+	"github.com/luckyjian/pgdba/internal/lifecycle"
 	"github.com/luckyjian/pgdba/internal/output"
 	"github.com/luckyjian/pgdba/internal/patroni"
 )
 
 // newFailoverCmd returns the "failover" parent command.
-func newFailoverCmd(format *output.Format, reg *cluster.Registry) *cobra.Command {
+func newFailoverCmd(cfg *config.Config, format *output.Format, reg *cluster.Registry) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "failover",
-		Short: "Trigger or inspect a cluster failover / switchover",
+		Short: "Trigger, inspect or drill a cluster failover / switchover",
 	}
 	cmd.AddCommand(
-		newFailoverTriggerCmd(format, reg),
+		newFailoverTriggerCmd(cfg, format, reg),
 		newFailoverStatusCmd(format, reg),
+		newFailoverCancelCmd(format, reg),
+		newFailoverCandidatesCmd(format, reg),
+		newFailoverPolicyCmd(format, reg),
+		newFailoverDrillCmd(cfg, format, reg),
 	)
 	return cmd
 }
@@ -31,16 +37,45 @@ func newFailoverCmd(format *output.Format, reg *cluster.Registry) *cobra.Command
 //   - Default (no --force): controlled switchover — both primary and replica
 //     participate; no data loss. Runs pre-checks before calling Patroni.
 //   - --force: forced failover — used when the primary is unreachable.
-//     Pre-checks are skipped; the candidate must be specified.
-func newFailoverTriggerCmd(format *output.Format, reg *cluster.Registry) *cobra.Command {
-	var name, patroniURL, candidate string
+//     Pre-checks are skipped; the candidate must be specified. The old
+//     leader is fenced so that it cannot keep accepting writes.
+func newFailoverTriggerCmd(cfg *config.Config, format *output.Format, reg *cluster.Registry) *cobra.Command {
+	var name, patroniURL, candidate, at string
 	var force bool
+	var timeout time.Duration
+	var lag lagFlags
+	var fence fenceFlags
 
 	cmd := &cobra.Command{
 		Use:   "trigger",
 		Short: "Trigger a switchover (controlled) or forced failover",
+		Long: "Trigger a controlled switchover, or with --force a failover to --candidate.\n" +
+			"Without --candidate, the switchover goes to the replica ranked best by \"failover\n" +
+			"candidates\"; a given --candidate must be eligible under the cluster's lag policy.\n" +
+			"After a switchover, Patroni is polled until the candidate is the running leader on\n" +
+			"a new timeline and the old primary streams from it as a replica; the result gives\n" +
+			"the downtime without a writable leader and the final topology. The command fails\n" +
+			"if the cluster has not converged within --timeout.\n\n" +
+			"With --at, the switchover is scheduled in Patroni instead and the command returns\n" +
+			"at once; without --candidate Patroni picks the best replica when the time comes.\n" +
+			"\"failover status\" shows a scheduled switchover and \"failover cancel\" removes it.\n\n" +
+			"A forced failover fences the old leader so that it cannot keep accepting writes:\n" +
+			"--fence stop stops Patroni and PostgreSQL on it, --fence partition cuts it off the\n" +
+			"network, both through the cluster's provider, before or after the promotion\n" +
+			"(--fence-when). The old leader's own Patroni API must then show it is no longer a\n" +
+			"writable primary. A fence that cannot be verified before the promotion aborts the\n" +
+			"failover; after it, the command fails with the failover done. Managed clusters\n" +
+			"default to --fence stop, others to none; \"failover policy\" sets a cluster default.",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			url, err := resolvePatroniURL(name, patroniURL, reg)
+			scheduledAt, err := parseAt(at, time.Now())
+			if err != nil {
+				return writeFailure(cmd, *format, "failover trigger", err)
+			}
+			if force && !scheduledAt.IsZero() {
+				return writeFailure(cmd, *format, "failover trigger",
+					fmt.Errorf("--at cannot be combined with --force; a forced failover cannot be scheduled"))
+			}
+			client, err := resolvePatroniClient(name, patroniURL, reg)
 			if err != nil {
 				return writeFailure(cmd, *format, "failover trigger", err)
 			}
@@ -48,24 +83,55 @@ func newFailoverTriggerCmd(format *output.Format, reg *cluster.Registry) *cobra.
 			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 			defer cancel()
 
-			client := patroni.NewClient(url)
+			if err := checkPaused(ctx, cmd, reg, name, client, true); err != nil {
+				return writeFailure(cmd, *format, "failover trigger", err)
+			}
 
 			if force {
-				return runForcedFailover(cmd, ctx, client, format, candidate)
+				plan, err := fence.plan(cfg, reg, name)
+				if err != nil {
+					return writeFailure(cmd, *format, "failover trigger", err)
+				}
+				return runForcedFailover(cmd, ctx, client, format, candidate, plan)
 			}
-			return runSwitchover(cmd, ctx, client, format, candidate)
+			policy, err := lag.resolve(reg, name)
+			if err != nil {
+				return writeFailure(cmd, *format, "failover trigger", err)
+			}
+			return runSwitchover(cmd, ctx, client, format, candidate, policy, scheduledAt, timeout)
 		},
 	}
 	cmd.Flags().StringVar(&name, "name", "", "Cluster name (looks up Patroni URL from registry)")
 	cmd.Flags().StringVar(&patroniURL, "patroni-url", "", "Patroni API URL")
 	cmd.Flags().StringVar(&candidate, "candidate", "", "Target replica to promote (empty = Patroni chooses best)")
 	cmd.Flags().BoolVar(&force, "force", false, "Force failover even if primary is unreachable (skips pre-checks)")
+	cmd.Flags().DurationVar(&timeout, "timeout", failover.DefaultConvergeTimeout, "How long to wait for a switchover to converge")
+	cmd.Flags().StringVar(&at, "at", "", "Schedule the switchover: RFC 3339 time (2025-06-01T02:00:00Z) or delay from now (90m)")
+	lag.register(cmd)
+	fence.register(cmd)
 	return cmd
 }
 
-// runSwitchover performs a controlled switchover with pre-checks.
-func runSwitchover(cmd *cobra.Command, ctx context.Context, client *patroni.Client,
-	format *output.Format, candidate string) error {
+// SwitchoverResult is the data of a "failover trigger" switchover response.
+type SwitchoverResult struct {
+	Type   string `json:"type"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Status string `json:"status"`
+
+	ScheduledAt *time.Time `json:"scheduled_at,omitempty"`
+
+	// Ranking is the candidate ranking the switchover target was checked
+	// against.
+	Ranking []failover.Candidate `json:"ranking"`
+	*failover.Convergence
+}
+
+// runSwitchover performs a controlled switchover with pre-checks against
+// policy and waits up to timeout for the cluster to converge. With a
+// non-zero at, the switchover is scheduled instead.
+func runSwitchover(cmd *cobra.Command, ctx context.Context, client *patroni.Client, format *output.Format,
+	candidate string, policy failover.LagPolicy, at time.Time, timeout time.Duration) error {
 
 	cs, err := client.GetClusterStatus(ctx)
 	if err != nil {
@@ -73,7 +139,7 @@ func runSwitchover(cmd *cobra.Command, ctx context.Context, client *patroni.Clie
 			fmt.Errorf("get cluster status: %w", err))
 	}
 
-	if err := failover.CheckSwitchover(cs, candidate, failover.DefaultMaxLagBytes); err != nil {
+	if err := failover.CheckSwitchover(cs, candidate, policy.MaxLagBytes); err != nil {
 		return writeFailure(cmd, *format, "failover trigger", err)
 	}
 
@@ -83,24 +149,64 @@ func runSwitchover(cmd *cobra.Command, ctx context.Context, client *patroni.Clie
 	}
 
 	// Auto-select best candidate if not specified.
+	ranking := failover.RankCandidates(cs, failover.ReplayLags(ctx, client, cs), policy)
 	target := candidate
-	if target == "" {
-		target, err = failover.FindBestCandidate(cs)
+	switch {
+	case target != "":
+		err = failover.CheckCandidate(ranking, target)
+	case at.IsZero():
+		target, err = failover.BestCandidate(ranking)
+	}
+	if err != nil {
+		return writeFailure(cmd, *format, "failover trigger", err)
+	}
+
+	if !at.IsZero() {
+		if err := client.ScheduleSwitchover(ctx, primary, target, at); err != nil {
+			return writeFailure(cmd, *format, "failover trigger",
+				fmt.Errorf("schedule switchover: %w", err))
+		}
+		resp := output.Success("failover trigger", SwitchoverResult{
+			Type:        "switchover",
+			From:        primary,
+			To:          target,
+			Status:      "scheduled",
+			ScheduledAt: &at,
+			Ranking:     ranking,
+		})
+		out, err := output.FormatResponse(resp, *format)
 		if err != nil {
-			return writeFailure(cmd, *format, "failover trigger", err)
+			return err
 		}
+		fmt.Fprintln(cmd.OutOrStdout(), out)
+		return nil
 	}
 
+	var timeline int64
+	for _, m := range cs.Members {
+		if m.Name == primary {
+			timeline = m.Timeline
+		}
+	}
+	requested := time.Now()
 	if err := client.Switchover(ctx, primary, target); err != nil {
 		return writeFailure(cmd, *format, "failover trigger",
 			fmt.Errorf("switchover failed: %w", err))
 	}
 
-	resp := output.Success("failover trigger", map[string]string{
-		"type":      "switchover",
-		"from":      primary,
-		"to":        target,
-		"status":    "completed",
+	conv, err := failover.WaitForSwitchover(context.Background(), client, primary, target, timeline,
+		requested, timeout, failover.DefaultPollInterval)
+	if err != nil {
+		return writeFailure(cmd, *format, "failover trigger", err)
+	}
+
+	resp := output.Success("failover trigger", SwitchoverResult{
+		Type:        "switchover",
+		From:        primary,
+		To:          target,
+		Status:      "completed",
+		Ranking:     ranking,
+		Convergence: conv,
 	})
 	out, err := output.FormatResponse(resp, *format)
 	if err != nil {
@@ -110,25 +216,83 @@ func runSwitchover(cmd *cobra.Command, ctx context.Context, client *patroni.Clie
 	return nil
 }
 
-// runForcedFailover performs a forced failover (POST /failover) without pre-checks.
+// ForcedFailoverResult is the data of a "failover trigger --force" response.
+type ForcedFailoverResult struct {
+	Type    string                 `json:"type"`
+	From    string                 `json:"from,omitempty"`
+	To      string                 `json:"to"`
+	Status  string                 `json:"status"`
+	Fencing *lifecycle.FenceResult `json:"fencing"`
+}
+
+// runForcedFailover performs a forced failover (POST /failover) without
+// pre-checks, fencing the old leader before or after it as plan says.
 func runForcedFailover(cmd *cobra.Command, ctx context.Context, client *patroni.Client,
-	format *output.Format, candidate string) error {
+	format *output.Format, candidate string, plan *forcedFence) error {
 
 	if candidate == "" {
 		return writeFailure(cmd, *format, "failover trigger",
 			fmt.Errorf("--candidate is required when using --force"))
 	}
 
-	if err := client.Failover(ctx, candidate); err != nil {
+	// The old leader, as far as the cluster view still shows it. Only
+	// fencing needs it, so a failed lookup is fatal only then.
+	old := patroni.Member{Name: plan.oldLeader}
+	cs, err := client.GetClusterStatus(ctx)
+	if err == nil {
+		if old.Name == "" {
+			old.Name, _ = failover.FindPrimary(cs)
+		}
+		for _, m := range cs.Members {
+			if m.Name == old.Name {
+				old = m
+			}
+		}
+	}
+	fencing := plan.opts.Method != lifecycle.FenceNone
+	switch {
+	case fencing && old.Name == "" && err != nil:
+		return writeFailure(cmd, *format, "failover trigger",
+			fmt.Errorf("cannot tell the old leader to fence (get cluster status: %w); pass --old-leader or --fence none", err))
+	case fencing && old.Name == "":
+		return writeFailure(cmd, *format, "failover trigger",
+			fmt.Errorf("no leader in the cluster view to fence; pass --old-leader or --fence none"))
+	case fencing && old.Name == candidate:
+		return writeFailure(cmd, *format, "failover trigger",
+			fmt.Errorf("candidate %s is the old leader; refusing to fence it", candidate))
+	}
+
+	result := ForcedFailoverResult{Type: "failover", From: old.Name, To: candidate, Status: "completed"}
+	if !fencing {
+		result.Fencing = lifecycle.Fence(ctx, nil, client, old, plan.opts) // records "not fenced"
+	}
+
+	if fencing && plan.opts.When == lifecycle.FenceBefore {
+		result.Fencing = lifecycle.Fence(context.Background(), plan.prov, client, old, plan.opts)
+		if !result.Fencing.Verified {
+			return writeFailure(cmd, *format, "failover trigger",
+				fmt.Errorf("fencing old leader %s failed: %s; the failover was not requested "+
+					"(retry with another --fence method, or --fence none to fail over unfenced)", old.Name, result.Fencing.Detail))
+		}
+	}
+
+	fctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := client.Failover(fctx, candidate); err != nil {
 		return writeFailure(cmd, *format, "failover trigger",
 			fmt.Errorf("forced failover failed: %w", err))
 	}
 
-	resp := output.Success("failover trigger", map[string]string{
-		"type":      "failover",
-		"to":        candidate,
-		"status":    "completed",
-	})
+	if fencing && plan.opts.When == lifecycle.FenceAfter {
+		result.Fencing = lifecycle.Fence(context.Background(), plan.prov, client, old, plan.opts)
+	}
+
+	if fencing && !result.Fencing.Verified {
+		// The failover happened; the one response says so and why it failed.
+		return writeFailureWith(cmd, *format, "failover trigger", result,
+			fmt.Errorf("failed over to %s, but old leader %s is not fenced: %s", candidate, old.Name, result.Fencing.Detail))
+	}
+	resp := output.Success("failover trigger", result)
 	out, err := output.FormatResponse(resp, *format)
 	if err != nil {
 		return err
@@ -137,6 +301,71 @@ func runForcedFailover(cmd *cobra.Command, ctx context.Context, client *patroni.
 	return nil
 }
 
+// newFailoverCancelCmd implements "failover cancel".
+func newFailoverCancelCmd(format *output.Format, reg *cluster.Registry) *cobra.Command {
+	var name, patroniURL string
+
+	cmd := &cobra.Command{
+		Use:   "cancel",
+		Short: "Cancel a scheduled switchover",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := resolvePatroniClient(name, patroniURL, reg)
+			if err != nil {
+				return writeFailure(cmd, *format, "failover cancel", err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			// Removing a scheduled switchover is safe while paused; only warn.
+			_ = checkPaused(ctx, cmd, reg, name, client, false)
+
+			// Read what is pending first, so the response says what was cancelled.
+			cs, err := client.GetClusterStatus(ctx)
+			if err != nil {
+				return writeFailure(cmd, *format, "failover cancel",
+					fmt.Errorf("get cluster status: %w", err))
+			}
+			if err := client.CancelSwitchover(ctx); err != nil {
+				return writeFailure(cmd, *format, "failover cancel", err)
+			}
+
+			resp := output.Success("failover cancel", map[string]interface{}{
+				"status":    "cancelled",
+				"cancelled": cs.Failover,
+			})
+			out, err := output.FormatResponse(resp, *format)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), out)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&name, "name", "", "Cluster name (looks up Patroni URL from registry)")
+	cmd.Flags().StringVar(&patroniURL, "patroni-url", "", "Patroni API URL")
+	return cmd
+}
+
+// parseAt parses the --at flag of the switchover commands: an RFC 3339
+// time or a delay from now. The empty string gives the zero time.
+func parseAt(at string, now time.Time) (time.Time, error) {
+	if at == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, at)
+	if err != nil {
+		d, derr := time.ParseDuration(at)
+		if derr != nil {
+			return time.Time{}, fmt.Errorf("--at %q is neither an RFC 3339 time nor a duration", at)
+		}
+		t = now.Add(d).Truncate(time.Second)
+	}
+	if !t.After(now) {
+		return time.Time{}, fmt.Errorf("--at %s is not in the future", t.Format(time.RFC3339))
+	}
+	return t, nil
+}
+
 // newFailoverStatusCmd implements "failover status".
 func newFailoverStatusCmd(format *output.Format, reg *cluster.Registry) *cobra.Command {
 	var name, patroniURL string
@@ -145,7 +374,7 @@ func newFailoverStatusCmd(format *output.Format, reg *cluster.Registry) *cobra.C
 		Use:   "status",
 		Short: "Show current cluster failover / switchover state",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			url, err := resolvePatroniURL(name, patroniURL, reg)
+			client, err := resolvePatroniClient(name, patroniURL, reg)
 			if err != nil {
 				return writeFailure(cmd, *format, "failover status", err)
 			}
@@ -153,7 +382,11 @@ func newFailoverStatusCmd(format *output.Format, reg *cluster.Registry) *cobra.C
 			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 			defer cancel()
 
-			cs, err := patroni.NewClient(url).GetClusterStatus(ctx)
+			pause, err := readPause(reg, name)
+			if err != nil {
+				return writeFailure(cmd, *format, "failover status", err)
+			}
+			cs, err := client.GetClusterStatus(ctx)
 			if err != nil {
 				return writeFailure(cmd, *format, "failover status",
 					fmt.Errorf("get cluster status: %w", err))
@@ -169,10 +402,16 @@ func newFailoverStatusCmd(format *output.Format, reg *cluster.Registry) *cobra.C
 			data := map[string]interface{}{
 				"primary":           primary,
 				"replicas":          replicaNames,
-				"failover_in_progress": cs.Failover != nil,
+				"failover_in_progress": cs.Failover != nil && !cs.Failover.Scheduled(time.Now()),
 				"paused":            cs.Pause,
 				"member_count":      len(cs.Members),
 			}
+			if cs.Pause && pause != nil {
+				data["pause"] = pause
+			}
+			if cs.Failover.Scheduled(time.Now()) {
+				data["scheduled_switchover"] = cs.Failover
+			}
 
 			resp := output.Success("failover status", data)
 			out, err := output.FormatResponse(resp, *format)
@@ -0,0 +1,230 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/luckyjian/pgdba/internal/cluster"
+	"github.com/luckyjian/pgdba/internal/failover"
+	"github.com/luckyjian/pgdba/internal/output"
+)
+
+// lagFlags are the lag threshold overrides shared by the commands that pick
+// or validate a promotion candidate.
+type lagFlags struct {
+	maxLagBytes   int64
+	maxLagSeconds float64
+}
+
+func (f *lagFlags) register(cmd *cobra.Command) {
+	cmd.Flags().Int64Var(&f.maxLagBytes, "max-lag-bytes", 0, "Maximum candidate lag in bytes (default: cluster policy, else 10MB)")
+	cmd.Flags().Float64Var(&f.maxLagSeconds, "max-lag-seconds", 0, "Maximum candidate replay lag in seconds (default: cluster policy, else unchecked)")
+}
+
+// resolve returns the lag policy for cluster name: the defaults, overridden
+// by the cluster's policy in the registry, overridden by the flags.
+func (f *lagFlags) resolve(reg *cluster.Registry, name string) (failover.LagPolicy, error) {
+	if f.maxLagBytes < 0 || f.maxLagSeconds < 0 {
+		return failover.LagPolicy{}, fmt.Errorf("lag thresholds must not be negative")
+	}
+	policy := failover.DefaultLagPolicy()
+	if name != "" {
+		entry, err := reg.Get(name)
+		if err != nil {
+			return policy, err
+		}
+		if p := entry.Failover; p != nil {
+			if p.MaxLagBytes > 0 {
+				policy.MaxLagBytes = p.MaxLagBytes
+			}
+			policy.MaxLagSeconds = p.MaxLagSeconds
+		}
+	}
+	if f.maxLagBytes > 0 {
+		policy.MaxLagBytes = f.maxLagBytes
+	}
+	if f.maxLagSeconds > 0 {
+		policy.MaxLagSeconds = f.maxLagSeconds
+	}
+	return policy, nil
+}
+
+// CandidatesResult is the data of a "failover candidates" response.
+type CandidatesResult struct {
+	Cluster    string               `json:"cluster,omitempty"`
+	Leader     string               `json:"leader"`
+	Policy     failover.LagPolicy   `json:"policy"`
+	Candidates []failover.Candidate `json:"candidates"`
+}
+
+// TableHeader implements output.Table.
+func (r CandidatesResult) TableHeader() []string {
+	return []string{"RANK", "MEMBER", "STATE", "SYNC", "PRIORITY", "LAG BYTES", "REPLAY LAG", "REASONS"}
+}
+
+// TableRows implements output.Table: one row per replica, best first.
+func (r CandidatesResult) TableRows() [][]string {
+	rows := make([][]string, 0, len(r.Candidates))
+	for _, c := range r.Candidates {
+		rank, replay := "-", "-"
+		if c.Eligible {
+			rank = strconv.Itoa(c.Rank)
+		}
+		if c.ReplayLagSeconds != nil {
+			replay = fmt.Sprintf("%.1fs", *c.ReplayLagSeconds)
+		}
+		rows = append(rows, []string{rank, c.Name, string(c.State), strconv.FormatBool(c.Sync),
+			strconv.Itoa(c.FailoverPriority), strconv.FormatInt(c.LagBytes, 10), replay, strings.Join(c.Reasons, "; ")})
+	}
+	return rows
+}
+
+// newFailoverCandidatesCmd implements "failover candidates".
+func newFailoverCandidatesCmd(format *output.Format, reg *cluster.Registry) *cobra.Command {
+	var name, patroniURL string
+	var lag lagFlags
+
+	cmd := &cobra.Command{
+		Use:   "candidates",
+		Short: "Rank replicas as switchover / failover targets and explain the ranking",
+		Long: "Rank the replicas the way \"failover trigger\" picks its candidate: members that are not\n" +
+			"running or streaming, tagged nofailover (or failover_priority 0), or lagging beyond the\n" +
+			"cluster's policy are not eligible. Eligible members are ordered by synchronous standby\n" +
+			"status, failover_priority tag, lag in bytes, then replay lag in seconds (from each\n" +
+			"member's last replayed transaction, pg_last_xact_replay_timestamp()).",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := resolvePatroniClient(name, patroniURL, reg)
+			if err != nil {
+				return writeFailure(cmd, *format, "failover candidates", err)
+			}
+			policy, err := lag.resolve(reg, name)
+			if err != nil {
+				return writeFailure(cmd, *format, "failover candidates", err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			cs, err := client.GetClusterStatus(ctx)
+			if err != nil {
+				return writeFailure(cmd, *format, "failover candidates",
+					fmt.Errorf("get cluster status: %w", err))
+			}
+			leader, _ := failover.FindPrimary(cs)
+			result := CandidatesResult{
+				Cluster:    name,
+				Leader:     leader,
+				Policy:     policy,
+				Candidates: failover.RankCandidates(cs, failover.ReplayLags(ctx, client, cs), policy),
+			}
+
+			resp := output.Success("failover candidates", result)
+			out, err := output.FormatResponse(resp, *format)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), out)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&name, "name", "", "Cluster name (looks up Patroni URL from registry)")
+	cmd.Flags().StringVar(&patroniURL, "patroni-url", "", "Patroni API URL")
+	lag.register(cmd)
+	return cmd
+}
+
+// newFailoverPolicyCmd implements "failover policy".
+func newFailoverPolicyCmd(format *output.Format, reg *cluster.Registry) *cobra.Command {
+	var name string
+	var maxLagBytes int64
+	var maxLagSeconds float64
+	var fence, fenceWhen string
+	var reset bool
+
+	cmd := &cobra.Command{
+		Use:   "policy",
+		Short: "Show or set a cluster's promotion lag thresholds and fencing",
+		Long: "Show the lag thresholds a cluster's switchover and failover candidates must meet and\n" +
+			"how a forced failover fences the old leader, or change them with --max-lag-bytes /\n" +
+			"--max-lag-seconds / --fence / --fence-when. The policy is kept in the registry;\n" +
+			"--reset goes back to the defaults (10MB, replay lag unchecked, stop after the\n" +
+			"promotion for managed clusters, no fencing for others).",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if name == "" {
+				return writeFailure(cmd, *format, "failover policy", fmt.Errorf("--name is required"))
+			}
+			if maxLagBytes < 0 || maxLagSeconds < 0 {
+				return writeFailure(cmd, *format, "failover policy", fmt.Errorf("lag thresholds must not be negative"))
+			}
+			entry, err := reg.Get(name)
+			if err != nil {
+				return writeFailure(cmd, *format, "failover policy", err)
+			}
+
+			bytesSet, secondsSet := cmd.Flags().Changed("max-lag-bytes"), cmd.Flags().Changed("max-lag-seconds")
+			fenceSet, whenSet := cmd.Flags().Changed("fence"), cmd.Flags().Changed("fence-when")
+			if reset || bytesSet || secondsSet || fenceSet || whenSet {
+				next := cluster.FailoverPolicy{}
+				if entry.Failover != nil && !reset {
+					next = *entry.Failover
+				}
+				if bytesSet {
+					next.MaxLagBytes = maxLagBytes
+				}
+				if secondsSet {
+					next.MaxLagSeconds = maxLagSeconds
+				}
+				if fenceSet {
+					next.Fence = fence
+				}
+				if whenSet {
+					next.FenceWhen = fenceWhen
+				}
+				if _, _, err := resolveFence(next.Fence, next.FenceWhen, entry); err != nil {
+					return writeFailure(cmd, *format, "failover policy", err)
+				}
+				entry.Failover = &next
+				if next == (cluster.FailoverPolicy{}) {
+					entry.Failover = nil
+				}
+				if err := reg.Add(*entry); err != nil {
+					return writeFailure(cmd, *format, "failover policy", fmt.Errorf("write registry: %w", err))
+				}
+			}
+
+			var none lagFlags
+			policy, err := none.resolve(reg, name)
+			if err != nil {
+				return writeFailure(cmd, *format, "failover policy", err)
+			}
+			method, when, err := resolveFence("", "", entry)
+			if err != nil {
+				return writeFailure(cmd, *format, "failover policy", err)
+			}
+			resp := output.Success("failover policy", map[string]interface{}{
+				"cluster":    name,
+				"policy":     policy,
+				"fence":      method,
+				"fence_when": when,
+			})
+			out, err := output.FormatResponse(resp, *format)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), out)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&name, "name", "", "Cluster name")
+	cmd.Flags().Int64Var(&maxLagBytes, "max-lag-bytes", 0, "Maximum candidate lag in bytes (0 = default 10MB)")
+	cmd.Flags().Float64Var(&maxLagSeconds, "max-lag-seconds", 0, "Maximum candidate replay lag in seconds (0 = unchecked)")
+	cmd.Flags().StringVar(&fence, "fence", "", "Fence the old leader of a forced failover: none, stop or partition")
+	cmd.Flags().StringVar(&fenceWhen, "fence-when", "", "Fence the old leader before or after the promotion")
+	cmd.Flags().BoolVar(&reset, "reset", false, "Remove the cluster's policy and use the defaults")
+	return cmd
+}
@@ -0,0 +1,109 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/luckyjian/pgdba/internal/cluster"
+	"github.com/luckyjian/pgdba/internal/config"
+	"github.com/luckyjian/pgdba/internal/lifecycle"
+	"github.com/luckyjian/pgdba/internal/output"
+)
+
+// DrillResult is the data of a "failover drill" response.
+type DrillResult struct {
+	*lifecycle.DrillReport
+	ReportPath string `json:"report_path"`
+}
+
+// newFailoverDrillCmd implements "failover drill".
+func newFailoverDrillCmd(cfg *config.Config, format *output.Format, reg *cluster.Registry) *cobra.Command {
+	var name, scenario, targets string
+	var observe, timeout time.Duration
+	var progress bool
+
+	cmd := &cobra.Command{
+		Use:   "drill",
+		Short: "Prove automatic failover works by partitioning nodes of a managed cluster",
+		Long: "Isolate nodes of a managed cluster from the network through its provider and watch\n" +
+			"Patroni react, then heal the partition and wait for the cluster to converge.\n\n" +
+			"Scenarios:\n" +
+			"  primary-partition  isolate the leader; another member must take over. RTO is the\n" +
+			"                     time until the new leader runs, RPO the WAL the old leader had\n" +
+			"                     written beyond where the new timeline starts.\n" +
+			"  replica-partition  isolate a replica (--target); the leader must stay in place.\n" +
+			"  etcd-minority      isolate a minority of etcd (--target); the leader must stay in place.\n\n" +
+			"The report is always saved under the registry directory (drills/) for audit, and\n" +
+			"the command fails if the drill did not pass.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if name == "" {
+				return writeFailure(cmd, *format, "failover drill", fmt.Errorf("--name is required"))
+			}
+			if scenario == "" {
+				return writeFailure(cmd, *format, "failover drill", fmt.Errorf("--scenario is required"))
+			}
+			entry, err := reg.Get(name)
+			if err != nil {
+				return writeFailure(cmd, *format, "failover drill", err)
+			}
+			if entry.Source != cluster.SourceManaged {
+				return writeFailure(cmd, *format, "failover drill",
+					fmt.Errorf("cluster %q is not managed by pgdba; cannot partition its nodes", name))
+			}
+			prov, err := newProvider(cfg, entry.Provider, name)
+			if err != nil {
+				return writeFailure(cmd, *format, "failover drill", err)
+			}
+			client, err := entryPatroniClient(entry)
+			if err != nil {
+				return writeFailure(cmd, *format, "failover drill", err)
+			}
+			if err := checkPaused(context.Background(), cmd, reg, name, client, true); err != nil {
+				return writeFailure(cmd, *format, "failover drill", err)
+			}
+
+			opts := lifecycle.DrillOptions{
+				Scenario: scenario,
+				Targets:  splitList(targets),
+				Observe:  observe,
+				Timeout:  timeout,
+			}
+			if progress {
+				enc := json.NewEncoder(cmd.ErrOrStderr())
+				opts.OnEvent = func(ev lifecycle.StepEvent) { _ = enc.Encode(ev) }
+			}
+			report, err := lifecycle.Drill(context.Background(), prov, client, entry, opts)
+			if err != nil {
+				return writeFailure(cmd, *format, "failover drill", err)
+			}
+			path := lifecycle.DrillReportPath(reg.Dir(), name, scenario, report.StartedAt)
+			if err := lifecycle.SaveDrillReport(path, report); err != nil {
+				return writeFailure(cmd, *format, "failover drill", err)
+			}
+
+			resp := output.Success("failover drill", DrillResult{DrillReport: report, ReportPath: path})
+			out, err := output.FormatResponse(resp, *format)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), out)
+			if !report.Passed {
+				return writeFailure(cmd, *format, "failover drill", fmt.Errorf("drill %s did not pass: %s (report: %s)",
+					scenario, strings.Join(report.Findings, "; "), path))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&name, "name", "", "Cluster name")
+	cmd.Flags().StringVar(&scenario, "scenario", "", "Drill scenario: primary-partition, replica-partition or etcd-minority")
+	cmd.Flags().StringVar(&targets, "target", "", "Comma-separated nodes to isolate (replica-partition, etcd-minority)")
+	cmd.Flags().DurationVar(&observe, "observe", time.Minute, "How long the leader must stay in place (replica-partition, etcd-minority)")
+	cmd.Flags().DurationVar(&timeout, "timeout", 5*time.Minute, "How long to wait for a new leader and for convergence")
+	cmd.Flags().BoolVar(&progress, "progress", false, "Stream step events to stderr as NDJSON")
+	return cmd
+}
@@ -0,0 +1,101 @@
+package cli
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/luckyjian/pgdba/internal/cluster"
+	"github.com/luckyjian/pgdba/internal/config"
+	"github.com/luckyjian/pgdba/internal/lifecycle"
+	"github.com/luckyjian/pgdba/internal/provider"
+)
+
+// fenceFlags are the fencing overrides of "failover trigger --force".
+type fenceFlags struct {
+	method, when, oldLeader string
+	timeout                 time.Duration
+}
+
+func (f *fenceFlags) register(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&f.method, "fence", "", "Fence the old leader of a forced failover: none, stop or partition (default: cluster policy, else stop for managed clusters)")
+	cmd.Flags().StringVar(&f.when, "fence-when", "", "Fence the old leader before or after the promotion (default: cluster policy, else before for partition, after for stop)")
+	cmd.Flags().StringVar(&f.oldLeader, "old-leader", "", "Old leader to fence (default: the leader in Patroni's cluster view)")
+	cmd.Flags().DurationVar(&f.timeout, "fence-timeout", 30*time.Second, "How long to wait for the old leader to stop accepting writes")
+}
+
+// forcedFence is how a forced failover fences the old leader: prov is nil
+// when it is not fenced.
+type forcedFence struct {
+	prov      provider.Provider
+	opts      lifecycle.FenceOptions
+	oldLeader string
+}
+
+// plan resolves the fencing of a forced failover on cluster name, which
+// may be empty for a cluster given by --patroni-url only.
+func (f *fenceFlags) plan(cfg *config.Config, reg *cluster.Registry, name string) (*forcedFence, error) {
+	var entry *cluster.Entry
+	if name != "" {
+		e, err := reg.Get(name)
+		if err != nil {
+			return nil, err
+		}
+		entry = e
+	}
+	method, when, err := resolveFence(f.method, f.when, entry)
+	if err != nil {
+		return nil, err
+	}
+	plan := &forcedFence{
+		oldLeader: f.oldLeader,
+		opts:      lifecycle.FenceOptions{Method: method, When: when, VerifyTimeout: f.timeout},
+	}
+	if method == lifecycle.FenceNone {
+		return plan, nil
+	}
+	if entry == nil {
+		return nil, fmt.Errorf("--fence %s needs --name of a cluster managed by pgdba", method)
+	}
+	if entry.Source != cluster.SourceManaged {
+		return nil, fmt.Errorf("cluster %q is not managed by pgdba; cannot fence its nodes (use --fence none)", name)
+	}
+	plan.prov, err = newProvider(cfg, entry.Provider, name)
+	if err != nil {
+		return nil, err
+	}
+	plan.opts.DataDir, plan.opts.PGVersion = entry.DataDir, entry.PGVersion
+	return plan, nil
+}
+
+// resolveFence returns how to fence the old leader of a forced failover:
+// the flags, else the cluster's policy, else stop for managed clusters and
+// none for the others, with partitions applied before the promotion and
+// stops after it. entry may be nil.
+func resolveFence(method, when string, entry *cluster.Entry) (string, string, error) {
+	if entry != nil && entry.Failover != nil {
+		if method == "" {
+			method = entry.Failover.Fence
+		}
+		if when == "" {
+			when = entry.Failover.FenceWhen
+		}
+	}
+	if method == "" {
+		method = lifecycle.FenceNone
+		if entry != nil && entry.Source == cluster.SourceManaged {
+			method = lifecycle.FenceStop
+		}
+	}
+	if when == "" {
+		when = lifecycle.FenceAfter
+		if method == lifecycle.FencePartition {
+			when = lifecycle.FenceBefore
+		}
+	}
+	if err := lifecycle.ValidateFence(method, when); err != nil {
+		return "", "", err
+	}
+	return method, when, nil
+}
@@ -0,0 +1,551 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/luckyjian/pgdba/internal/cluster"
+	"github.com/luckyjian/pgdba/internal/config"
+	"github.com/luckyjian/pgdba/internal/failover"
+	"github.com/luckyjian/pgdba/internal/output"
+	"github.com/luckyjian/pgdba/internal/postgres"
+	"github.com/luckyjian/pgdba/internal/replication"
+)
+
+// newLogicalCmd returns the "logical" parent command.
+func newLogicalCmd(cfg *config.Config, format *output.Format, reg *cluster.Registry) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "logical",
+		Short: "Manage logical replication between registered clusters (pub, sub, status)",
+	}
+	pub := &cobra.Command{
+		Use:   "pub",
+		Short: "Manage publications on the source cluster (create, list, drop)",
+	}
+	pub.AddCommand(
+		newLogicalPubCreateCmd(cfg, format, reg),
+		newLogicalPubListCmd(cfg, format, reg),
+		newLogicalPubDropCmd(cfg, format, reg),
+	)
+	sub := &cobra.Command{
+		Use:   "sub",
+		Short: "Manage subscriptions on the target cluster (create, list, drop, refresh)",
+	}
+	sub.AddCommand(
+		newLogicalSubCreateCmd(cfg, format, reg),
+		newLogicalSubListCmd(cfg, format, reg),
+		newLogicalSubDropCmd(cfg, format, reg),
+		newLogicalSubRefreshCmd(cfg, format, reg),
+	)
+	cmd.AddCommand(pub, sub, newLogicalStatusCmd(cfg, format, reg))
+	return cmd
+}
+
+// leaderPGConfig returns the connection config for the leader of registered
+// cluster name, as Patroni reports it, on database (default: the
+// configured one).
+func leaderPGConfig(ctx context.Context, name, database string, cfg *config.Config, reg *cluster.Registry) (postgres.Config, error) {
+	client, err := resolvePatroniClient(name, "", reg)
+	if err != nil {
+		return postgres.Config{}, err
+	}
+	cs, err := client.GetClusterStatus(ctx)
+	if err != nil {
+		return postgres.Config{}, fmt.Errorf("get cluster status of %s: %w", name, err)
+	}
+	leader, err := failover.FindPrimary(cs)
+	if err != nil {
+		return postgres.Config{}, fmt.Errorf("%s: %w", name, err)
+	}
+	var pgCfg postgres.Config
+	for _, m := range cs.Members {
+		if m.Name == leader {
+			pgCfg, err = memberPGConfig(name, cfg, reg, m)
+		}
+	}
+	if err != nil {
+		return pgCfg, err
+	}
+	if database != "" {
+		pgCfg.Database = database
+	}
+	return pgCfg, nil
+}
+
+// openLogical connects to database on the leader of registered cluster name.
+// Commands that change the cluster pass cmd, to be warned when it is paused.
+func openLogical(ctx context.Context, cmd *cobra.Command, name, database string, cfg *config.Config,
+	reg *cluster.Registry) (*replication.PgxDB, postgres.Config, error) {
+
+	if cmd != nil {
+		client, err := resolvePatroniClient(name, "", reg)
+		if err != nil {
+			return nil, postgres.Config{}, err
+		}
+		_ = checkPaused(ctx, cmd, reg, name, client, false)
+	}
+	pgCfg, err := leaderPGConfig(ctx, name, database, cfg, reg)
+	if err != nil {
+		return nil, pgCfg, err
+	}
+	conn, err := postgres.Connect(ctx, pgCfg)
+	if err != nil {
+		return nil, pgCfg, err
+	}
+	return replication.NewPgxDB(conn), pgCfg, nil
+}
+
+// LogicalChangeResult is the data of a "logical pub|sub create|drop|refresh"
+// response. Publication or Subscription is the object as read back after
+// the change.
+type LogicalChangeResult struct {
+	Cluster      string                    `json:"cluster"`
+	Database     string                    `json:"database"`
+	Name         string                    `json:"name"`
+	Action       string                    `json:"action"`
+	Publication  *replication.Publication  `json:"publication,omitempty"`
+	Subscription *replication.Subscription `json:"subscription,omitempty"`
+}
+
+// newLogicalPubCreateCmd implements "logical pub create".
+func newLogicalPubCreateCmd(cfg *config.Config, format *output.Format, reg *cluster.Registry) *cobra.Command {
+	var name, database, pub, tables string
+
+	cmd := &cobra.Command{
+		Use:   "create",
+		Short: "Create a publication on the source cluster's leader",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			switch {
+			case name == "":
+				return writeFailure(cmd, *format, "logical pub create", fmt.Errorf("--name is required"))
+			case pub == "":
+				return writeFailure(cmd, *format, "logical pub create", fmt.Errorf("--pub is required"))
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			db, pgCfg, err := openLogical(ctx, cmd, name, database, cfg, reg)
+			if err != nil {
+				return writeFailure(cmd, *format, "logical pub create", err)
+			}
+			defer db.Close(ctx)
+
+			if err := db.Exec(ctx, replication.CreatePublicationSQL(pub, splitList(tables))); err != nil {
+				return writeFailure(cmd, *format, "logical pub create", fmt.Errorf("create publication %s: %w", pub, err))
+			}
+			result := LogicalChangeResult{Cluster: name, Database: pgCfg.Database, Name: pub, Action: "created"}
+			if pubs, err := db.Publications(ctx); err == nil {
+				for i := range pubs {
+					if pubs[i].Name == pub {
+						result.Publication = &pubs[i]
+					}
+				}
+			}
+			return writeSuccess(cmd, *format, "logical pub create", result)
+		},
+	}
+	cmd.Flags().StringVar(&name, "name", "", "Source cluster name in the registry (required)")
+	cmd.Flags().StringVar(&database, "database", "", "Database to publish from (default: the configured one)")
+	cmd.Flags().StringVar(&pub, "pub", "", "Publication name (required)")
+	cmd.Flags().StringVar(&tables, "tables", "", "Comma-separated tables (schema.table); default all tables")
+	return cmd
+}
+
+// PublicationListResult is the data of a "logical pub list" response.
+type PublicationListResult struct {
+	Cluster      string                    `json:"cluster"`
+	Database     string                    `json:"database"`
+	Publications []replication.Publication `json:"publications"`
+}
+
+// TableHeader implements output.Table.
+func (r PublicationListResult) TableHeader() []string {
+	return []string{"PUBLICATION", "OWNER", "ALL TABLES", "OPERATIONS", "TABLES"}
+}
+
+// TableRows implements output.Table.
+func (r PublicationListResult) TableRows() [][]string {
+	rows := make([][]string, 0, len(r.Publications))
+	for _, p := range r.Publications {
+		rows = append(rows, []string{p.Name, p.Owner, strconv.FormatBool(p.AllTables),
+			strings.Join(p.Operations, ","), strconv.Itoa(len(p.Tables))})
+	}
+	return rows
+}
+
+// newLogicalPubListCmd implements "logical pub list".
+func newLogicalPubListCmd(cfg *config.Config, format *output.Format, reg *cluster.Registry) *cobra.Command {
+	var name, database string
+
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the publications of the source cluster and their tables",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if name == "" {
+				return writeFailure(cmd, *format, "logical pub list", fmt.Errorf("--name is required"))
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			db, pgCfg, err := openLogical(ctx, nil, name, database, cfg, reg)
+			if err != nil {
+				return writeFailure(cmd, *format, "logical pub list", err)
+			}
+			defer db.Close(ctx)
+
+			pubs, err := db.Publications(ctx)
+			if err != nil {
+				return writeFailure(cmd, *format, "logical pub list", fmt.Errorf("read pg_publication: %w", err))
+			}
+			if pubs == nil {
+				pubs = []replication.Publication{}
+			}
+			return writeSuccess(cmd, *format, "logical pub list",
+				PublicationListResult{Cluster: name, Database: pgCfg.Database, Publications: pubs})
+		},
+	}
+	cmd.Flags().StringVar(&name, "name", "", "Source cluster name in the registry (required)")
+	cmd.Flags().StringVar(&database, "database", "", "Database (default: the configured one)")
+	return cmd
+}
+
+// newLogicalPubDropCmd implements "logical pub drop".
+func newLogicalPubDropCmd(cfg *config.Config, format *output.Format, reg *cluster.Registry) *cobra.Command {
+	var name, database, pub string
+
+	cmd := &cobra.Command{
+		Use:   "drop",
+		Short: "Drop a publication on the source cluster",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			switch {
+			case name == "":
+				return writeFailure(cmd, *format, "logical pub drop", fmt.Errorf("--name is required"))
+			case pub == "":
+				return writeFailure(cmd, *format, "logical pub drop", fmt.Errorf("--pub is required"))
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			db, pgCfg, err := openLogical(ctx, cmd, name, database, cfg, reg)
+			if err != nil {
+				return writeFailure(cmd, *format, "logical pub drop", err)
+			}
+			defer db.Close(ctx)
+
+			if err := db.Exec(ctx, replication.DropPublicationSQL(pub)); err != nil {
+				return writeFailure(cmd, *format, "logical pub drop", fmt.Errorf("drop publication %s: %w", pub, err))
+			}
+			return writeSuccess(cmd, *format, "logical pub drop",
+				LogicalChangeResult{Cluster: name, Database: pgCfg.Database, Name: pub, Action: "dropped"})
+		},
+	}
+	cmd.Flags().StringVar(&name, "name", "", "Source cluster name in the registry (required)")
+	cmd.Flags().StringVar(&database, "database", "", "Database (default: the configured one)")
+	cmd.Flags().StringVar(&pub, "pub", "", "Publication name (required)")
+	return cmd
+}
+
+// findSubscription reads back subscription name from db.
+func findSubscription(ctx context.Context, db replication.LogicalDB, name string) *replication.Subscription {
+	subs, err := db.Subscriptions(ctx)
+	if err != nil {
+		return nil
+	}
+	for i := range subs {
+		if subs[i].Name == name {
+			return &subs[i]
+		}
+	}
+	return nil
+}
+
+// newLogicalSubCreateCmd implements "logical sub create".
+func newLogicalSubCreateCmd(cfg *config.Config, format *output.Format, reg *cluster.Registry) *cobra.Command {
+	var name, target, database, sub, pubs, slotName string
+	var copyData bool
+
+	cmd := &cobra.Command{
+		Use:   "create",
+		Short: "Subscribe the target cluster to publications of the source cluster",
+		Long: "Create a subscription on the --target leader that connects to the --name leader, at\n" +
+			"the host and port Patroni reports for it, with the configured user and\n" +
+			"PGDBA_PG_PASSWORD. The slot is created on the source. The subscribed tables must\n" +
+			"already exist on the target: logical replication does not copy the schema.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			switch {
+			case name == "":
+				return writeFailure(cmd, *format, "logical sub create", fmt.Errorf("--name is required"))
+			case target == "":
+				return writeFailure(cmd, *format, "logical sub create", fmt.Errorf("--target is required"))
+			case sub == "":
+				return writeFailure(cmd, *format, "logical sub create", fmt.Errorf("--sub is required"))
+			case len(splitList(pubs)) == 0:
+				return writeFailure(cmd, *format, "logical sub create", fmt.Errorf("--pub is required"))
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+			defer cancel()
+
+			db, pgCfg, err := openLogical(ctx, cmd, target, database, cfg, reg)
+			if err != nil {
+				return writeFailure(cmd, *format, "logical sub create", err)
+			}
+			defer db.Close(ctx)
+			srcCfg, err := leaderPGConfig(ctx, name, database, cfg, reg)
+			if err != nil {
+				return writeFailure(cmd, *format, "logical sub create", err)
+			}
+
+			stmt := replication.CreateSubscriptionSQL(sub, srcCfg.DSN(postgres.Password()), splitList(pubs),
+				replication.SubscriptionOptions{CopyData: copyData, SlotName: slotName})
+			if err := db.Exec(ctx, stmt); err != nil {
+				return writeFailure(cmd, *format, "logical sub create", fmt.Errorf("create subscription %s: %w", sub, err))
+			}
+			return writeSuccess(cmd, *format, "logical sub create", LogicalChangeResult{
+				Cluster: target, Database: pgCfg.Database, Name: sub, Action: "created",
+				Subscription: findSubscription(ctx, db, sub)})
+		},
+	}
+	cmd.Flags().StringVar(&name, "name", "", "Source cluster name in the registry (required)")
+	cmd.Flags().StringVar(&target, "target", "", "Target cluster name in the registry (required)")
+	cmd.Flags().StringVar(&database, "database", "", "Database on both clusters (default: the configured one)")
+	cmd.Flags().StringVar(&sub, "sub", "", "Subscription name (required)")
+	cmd.Flags().StringVar(&pubs, "pub", "", "Comma-separated publications to subscribe to (required)")
+	cmd.Flags().StringVar(&slotName, "slot-name", "", "Slot to create on the source (default: the subscription name)")
+	cmd.Flags().BoolVar(&copyData, "copy-data", true, "Copy the existing rows of the published tables first")
+	return cmd
+}
+
+// SubscriptionListResult is the data of a "logical sub list" response.
+type SubscriptionListResult struct {
+	Cluster       string                     `json:"cluster"`
+	Database      string                     `json:"database"`
+	Subscriptions []replication.Subscription `json:"subscriptions"`
+}
+
+// TableHeader implements output.Table.
+func (r SubscriptionListResult) TableHeader() []string {
+	return []string{"SUBSCRIPTION", "ENABLED", "PUBLICATIONS", "SLOT", "TABLE", "STATE", "LSN"}
+}
+
+// TableRows implements output.Table: one row per subscribed table.
+func (r SubscriptionListResult) TableRows() [][]string {
+	var rows [][]string
+	for _, s := range r.Subscriptions {
+		head := []string{s.Name, strconv.FormatBool(s.Enabled), strings.Join(s.Publications, ","), s.SlotName}
+		if len(s.Tables) == 0 {
+			rows = append(rows, append(head, "-", "-", "-"))
+		}
+		for _, t := range s.Tables {
+			lsn := t.LSN
+			if lsn == "" {
+				lsn = "-"
+			}
+			rows = append(rows, append(append([]string{}, head...), t.Table, t.State, lsn))
+		}
+	}
+	return rows
+}
+
+// newLogicalSubListCmd implements "logical sub list".
+func newLogicalSubListCmd(cfg *config.Config, format *output.Format, reg *cluster.Registry) *cobra.Command {
+	var target, database string
+
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the target cluster's subscriptions with per-table sync state",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if target == "" {
+				return writeFailure(cmd, *format, "logical sub list", fmt.Errorf("--target is required"))
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			db, pgCfg, err := openLogical(ctx, nil, target, database, cfg, reg)
+			if err != nil {
+				return writeFailure(cmd, *format, "logical sub list", err)
+			}
+			defer db.Close(ctx)
+
+			subs, err := db.Subscriptions(ctx)
+			if err != nil {
+				return writeFailure(cmd, *format, "logical sub list", fmt.Errorf("read pg_subscription: %w", err))
+			}
+			if subs == nil {
+				subs = []replication.Subscription{}
+			}
+			return writeSuccess(cmd, *format, "logical sub list",
+				SubscriptionListResult{Cluster: target, Database: pgCfg.Database, Subscriptions: subs})
+		},
+	}
+	cmd.Flags().StringVar(&target, "target", "", "Target cluster name in the registry (required)")
+	cmd.Flags().StringVar(&database, "database", "", "Database (default: the configured one)")
+	return cmd
+}
+
+// newLogicalSubDropCmd implements "logical sub drop".
+func newLogicalSubDropCmd(cfg *config.Config, format *output.Format, reg *cluster.Registry) *cobra.Command {
+	var target, database, sub string
+
+	cmd := &cobra.Command{
+		Use:   "drop",
+		Short: "Drop a subscription on the target cluster and its slot on the source",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			switch {
+			case target == "":
+				return writeFailure(cmd, *format, "logical sub drop", fmt.Errorf("--target is required"))
+			case sub == "":
+				return writeFailure(cmd, *format, "logical sub drop", fmt.Errorf("--sub is required"))
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+
+			db, pgCfg, err := openLogical(ctx, cmd, target, database, cfg, reg)
+			if err != nil {
+				return writeFailure(cmd, *format, "logical sub drop", err)
+			}
+			defer db.Close(ctx)
+
+			if err := db.Exec(ctx, replication.DropSubscriptionSQL(sub)); err != nil {
+				return writeFailure(cmd, *format, "logical sub drop", fmt.Errorf("drop subscription %s: %w", sub, err))
+			}
+			return writeSuccess(cmd, *format, "logical sub drop",
+				LogicalChangeResult{Cluster: target, Database: pgCfg.Database, Name: sub, Action: "dropped"})
+		},
+	}
+	cmd.Flags().StringVar(&target, "target", "", "Target cluster name in the registry (required)")
+	cmd.Flags().StringVar(&database, "database", "", "Database (default: the configured one)")
+	cmd.Flags().StringVar(&sub, "sub", "", "Subscription name (required)")
+	return cmd
+}
+
+// newLogicalSubRefreshCmd implements "logical sub refresh".
+func newLogicalSubRefreshCmd(cfg *config.Config, format *output.Format, reg *cluster.Registry) *cobra.Command {
+	var target, database, sub string
+	var copyData bool
+
+	cmd := &cobra.Command{
+		Use:   "refresh",
+		Short: "Pick up tables added to the subscribed publications",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			switch {
+			case target == "":
+				return writeFailure(cmd, *format, "logical sub refresh", fmt.Errorf("--target is required"))
+			case sub == "":
+				return writeFailure(cmd, *format, "logical sub refresh", fmt.Errorf("--sub is required"))
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+
+			db, pgCfg, err := openLogical(ctx, cmd, target, database, cfg, reg)
+			if err != nil {
+				return writeFailure(cmd, *format, "logical sub refresh", err)
+			}
+			defer db.Close(ctx)
+
+			if err := db.Exec(ctx, replication.RefreshSubscriptionSQL(sub, copyData)); err != nil {
+				return writeFailure(cmd, *format, "logical sub refresh", fmt.Errorf("refresh subscription %s: %w", sub, err))
+			}
+			return writeSuccess(cmd, *format, "logical sub refresh", LogicalChangeResult{
+				Cluster: target, Database: pgCfg.Database, Name: sub, Action: "refreshed",
+				Subscription: findSubscription(ctx, db, sub)})
+		},
+	}
+	cmd.Flags().StringVar(&target, "target", "", "Target cluster name in the registry (required)")
+	cmd.Flags().StringVar(&database, "database", "", "Database (default: the configured one)")
+	cmd.Flags().StringVar(&sub, "sub", "", "Subscription name (required)")
+	cmd.Flags().BoolVar(&copyData, "copy-data", true, "Copy the existing rows of newly added tables")
+	return cmd
+}
+
+// LogicalStatusResult is the data of a "logical status" response.
+type LogicalStatusResult struct {
+	Source   string `json:"source"`
+	Target   string `json:"target"`
+	Database string `json:"database"`
+	*replication.LogicalStatus
+}
+
+// TableHeader implements output.Table.
+func (r LogicalStatusResult) TableHeader() []string {
+	return []string{"SUBSCRIPTION", "ENABLED", "SYNCED", "TABLE STATES", "SOURCE SLOT", "SLOT ACTIVE", "PENDING BYTES"}
+}
+
+// TableRows implements output.Table.
+func (r LogicalStatusResult) TableRows() [][]string {
+	rows := make([][]string, 0, len(r.Subscriptions))
+	for _, s := range r.Subscriptions {
+		states := make([]string, 0, len(s.TableStates))
+		for state, n := range s.TableStates {
+			states = append(states, fmt.Sprintf("%s=%d", state, n))
+		}
+		sort.Strings(states)
+		slot, active, pending := "-", "-", "-"
+		if s.SourceSlot != nil {
+			slot, active = s.SourceSlot.Name, strconv.FormatBool(s.SourceSlot.Active)
+			pending = strconv.FormatInt(s.SourceSlot.PendingBytes, 10)
+		}
+		rows = append(rows, []string{s.Name, strconv.FormatBool(s.Enabled), strconv.FormatBool(s.Synced),
+			strings.Join(states, ","), slot, active, pending})
+	}
+	return rows
+}
+
+// newLogicalStatusCmd implements "logical status".
+func newLogicalStatusCmd(cfg *config.Config, format *output.Format, reg *cluster.Registry) *cobra.Command {
+	var name, target, database string
+
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "Show the logical replication from the source to the target cluster",
+		Long: "Read the source's publications and replication slots and the target's\n" +
+			"subscriptions that replicate from it, with the sync state of every table\n" +
+			"(pg_subscription_rel), the workers (pg_stat_subscription) and the WAL the source\n" +
+			"slot holds that the subscription has not confirmed yet.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			switch {
+			case name == "":
+				return writeFailure(cmd, *format, "logical status", fmt.Errorf("--name is required"))
+			case target == "":
+				return writeFailure(cmd, *format, "logical status", fmt.Errorf("--target is required"))
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			src, pgCfg, err := openLogical(ctx, nil, name, database, cfg, reg)
+			if err != nil {
+				return writeFailure(cmd, *format, "logical status", err)
+			}
+			defer src.Close(ctx)
+			dst, _, err := openLogical(ctx, nil, target, database, cfg, reg)
+			if err != nil {
+				return writeFailure(cmd, *format, "logical status", err)
+			}
+			defer dst.Close(ctx)
+
+			st, err := replication.Status(ctx, src, dst)
+			if err != nil {
+				return writeFailure(cmd, *format, "logical status", err)
+			}
+			return writeSuccess(cmd, *format, "logical status",
+				LogicalStatusResult{Source: name, Target: target, Database: pgCfg.Database, LogicalStatus: st})
+		},
+	}
+	cmd.Flags().StringVar(&name, "name", "", "Source cluster name in the registry (required)")
+	cmd.Flags().StringVar(&target, "target", "", "Target cluster name in the registry (required)")
+	cmd.Flags().StringVar(&database, "database", "", "Database on both clusters (default: the configured one)")
+	return cmd
+}
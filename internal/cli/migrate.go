@@ -0,0 +1,360 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/luckyjian/pgdba/internal/cluster"
+	"github.com/luckyjian/pgdba/internal/config"
+	"github.com/luckyjian/pgdba/internal/failover"
+	"github.com/luckyjian/pgdba/internal/lifecycle"
+	"github.com/luckyjian/pgdba/internal/output"
+	"github.com/luckyjian/pgdba/internal/postgres"
+	"github.com/luckyjian/pgdba/internal/provider"
+	"github.com/luckyjian/pgdba/internal/replication"
+)
+
+// newMigrateCmd returns the "migrate" parent command.
+func newMigrateCmd(cfg *config.Config, format *output.Format, reg *cluster.Registry) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Move databases between registered clusters through logical replication (plan, start, status, cutover)",
+		Long: "Move databases (tenants) from the --name cluster to the --target cluster with little\n" +
+			"downtime: \"start\" copies the schema and subscribes the target to the source,\n" +
+			"\"status\" tracks the initial copy and the lag, and \"cutover\" pauses PgBouncer, waits\n" +
+			"for the target to confirm the source's WAL position, copies sequences and repoints\n" +
+			"the PgBouncer [databases] entries at the target before resuming. psql and pg_dump\n" +
+			"run on the target leader's node, so the target must be managed by pgdba. Both\n" +
+			"clusters are reached as postgres with PGDBA_PG_PASSWORD.",
+	}
+	cmd.AddCommand(
+		newMigrateRunCmd(cfg, format, reg, "plan"),
+		newMigrateRunCmd(cfg, format, reg, "start"),
+		newMigrateStatusCmd(cfg, format, reg),
+		newMigrateRunCmd(cfg, format, reg, "cutover"),
+	)
+	return cmd
+}
+
+// migrateFlags are the flags shared by the "migrate" subcommands.
+type migrateFlags struct {
+	name, target, databases string
+}
+
+func (f *migrateFlags) register(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&f.name, "name", "", "Source cluster name in the registry (required)")
+	cmd.Flags().StringVar(&f.target, "target", "", "Target cluster name in the registry (required)")
+	cmd.Flags().StringVar(&f.databases, "databases", "", "Comma-separated databases to move (required)")
+}
+
+func (f *migrateFlags) validate() error {
+	switch {
+	case f.name == "":
+		return fmt.Errorf("--name is required")
+	case f.target == "":
+		return fmt.Errorf("--target is required")
+	case f.name == f.target:
+		return fmt.Errorf("--name and --target must be different clusters")
+	case len(splitList(f.databases)) == 0:
+		return fmt.Errorf("--databases is required")
+	}
+	return nil
+}
+
+// leaderEndpoint returns the leader of registered cluster name as Patroni
+// reports it.
+func leaderEndpoint(ctx context.Context, name string, reg *cluster.Registry) (lifecycle.MigrationEndpoint, string, error) {
+	ep := lifecycle.MigrationEndpoint{Cluster: name}
+	client, err := resolvePatroniClient(name, "", reg)
+	if err != nil {
+		return ep, "", err
+	}
+	cs, err := client.GetClusterStatus(ctx)
+	if err != nil {
+		return ep, "", fmt.Errorf("get cluster status of %s: %w", name, err)
+	}
+	leader, err := failover.FindPrimary(cs)
+	if err != nil {
+		return ep, "", fmt.Errorf("%s: %w", name, err)
+	}
+	for _, m := range cs.Members {
+		if m.Name == leader {
+			ep.Host, ep.Port = m.Host, m.Port
+		}
+	}
+	if ep.Port == 0 {
+		ep.Port = 5432
+	}
+	if ep.Host == "" {
+		return ep, "", fmt.Errorf("%s: Patroni reports no host for leader %s", name, leader)
+	}
+	return ep, leader, nil
+}
+
+// options resolves both leaders, the node to run psql on (the target
+// leader's) and the source's PgBouncer: pgbouncerNode if given, else the
+// source's own PgBouncer node when it is managed alike, unless noPgBouncer.
+func (f *migrateFlags) options(ctx context.Context, cfg *config.Config, reg *cluster.Registry,
+	pgbouncerNode string, noPgBouncer bool) (provider.Provider, lifecycle.MigrateOptions, error) {
+
+	opts := lifecycle.MigrateOptions{Databases: splitList(f.databases), Password: postgres.Password()}
+	if opts.Password == "" {
+		return nil, opts, fmt.Errorf("PGDBA_PG_PASSWORD is required")
+	}
+	source, err := reg.Get(f.name)
+	if err != nil {
+		return nil, opts, fmt.Errorf("cluster %q not found in registry: %w", f.name, err)
+	}
+	target, err := reg.Get(f.target)
+	if err != nil {
+		return nil, opts, fmt.Errorf("cluster %q not found in registry: %w", f.target, err)
+	}
+	if target.Source != cluster.SourceManaged {
+		return nil, opts, fmt.Errorf("cluster %q is not managed by pgdba; migrate runs pg_dump and psql on its leader's node", f.target)
+	}
+	if opts.Source, _, err = leaderEndpoint(ctx, f.name, reg); err != nil {
+		return nil, opts, err
+	}
+	if opts.Target, opts.Node, err = leaderEndpoint(ctx, f.target, reg); err != nil {
+		return nil, opts, err
+	}
+	prov, err := newProvider(cfg, target.Provider, f.target)
+	if err != nil {
+		return nil, opts, err
+	}
+
+	switch {
+	case noPgBouncer:
+	case pgbouncerNode != "":
+		opts.PgBouncerNode = pgbouncerNode
+	case source.Source == cluster.SourceManaged && source.Provider == target.Provider:
+		nodes, err := lifecycle.ClusterNodes(ctx, prov, f.name)
+		if err != nil {
+			return nil, opts, err
+		}
+		for _, n := range nodes {
+			if n.ID == lifecycle.PgBouncerNodeName(f.name) {
+				opts.PgBouncerNode = n.ID
+			}
+		}
+	}
+	return prov, opts, nil
+}
+
+// newMigrateRunCmd implements "migrate plan", "migrate start" and "migrate
+// cutover".
+func newMigrateRunCmd(cfg *config.Config, format *output.Format, reg *cluster.Registry, use string) *cobra.Command {
+	var f migrateFlags
+	var pgbouncerNode string
+	var noPgBouncer, wait, progress bool
+	var timeout time.Duration
+
+	command := "migrate " + use
+	cmd := &cobra.Command{Use: use}
+	switch use {
+	case "plan":
+		cmd.Short = "Check that the databases can be moved and show the steps"
+		cmd.Long = "Check without changing anything that the source has wal_level logical and free\n" +
+			"replication slots, that the databases exist on the source and have no tables on the\n" +
+			"target, which tables lack a replica identity, and which PgBouncer [databases]\n" +
+			"entries the cutover repoints."
+	case "start":
+		cmd.Short = "Copy the schema and subscribe the target to the source"
+		cmd.Long = "Copy the source's roles to the target (pg_dumpall --roles-only), create the\n" +
+			"missing databases on the target, copy their schema (pg_dump --schema-only),\n" +
+			"publish all tables on the source and subscribe the target to them.\n" +
+			"The rows are copied in the background; follow them with \"migrate status\", or pass\n" +
+			"--wait."
+	case "cutover":
+		cmd.Short = "Switch the clients to the target through PgBouncer"
+		cmd.Long = "Refuse unless every table is replicated; then pause the PgBouncer entries of the\n" +
+			"databases, make them read-only on the source and end their sessions, wait until the\n" +
+			"subscriptions confirm the source's WAL position, copy sequence values, repoint the\n" +
+			"entries at the target and resume. A failure before the entries are repointed is\n" +
+			"rolled back. The publication and subscriptions are dropped afterwards; the source\n" +
+			"databases stay read-only."
+	}
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		if err := f.validate(); err != nil {
+			return writeFailure(cmd, *format, command, err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		if use != "plan" {
+			for _, name := range []string{f.name, f.target} {
+				if client, err := resolvePatroniClient(name, "", reg); err == nil {
+					_ = checkPaused(ctx, cmd, reg, name, client, false)
+				}
+			}
+		}
+		prov, opts, err := f.options(ctx, cfg, reg, pgbouncerNode, noPgBouncer)
+		cancel()
+		if err != nil {
+			return writeFailure(cmd, *format, command, err)
+		}
+		opts.Wait, opts.Timeout = wait, timeout
+		if progress {
+			enc := json.NewEncoder(cmd.ErrOrStderr())
+			opts.OnEvent = func(ev lifecycle.StepEvent) { _ = enc.Encode(ev) }
+		}
+
+		var result interface{}
+		switch use {
+		case "plan":
+			result, err = lifecycle.PlanMigration(context.Background(), prov, opts)
+		case "start":
+			result, err = lifecycle.StartMigration(context.Background(), prov, opts)
+		case "cutover":
+			result, err = lifecycle.Cutover(context.Background(), prov, opts)
+		}
+		if err != nil {
+			return writeFailure(cmd, *format, command, err)
+		}
+		return writeSuccess(cmd, *format, command, result)
+	}
+	f.register(cmd)
+	if use != "start" {
+		cmd.Flags().StringVar(&pgbouncerNode, "pgbouncer", "", "Node of the PgBouncer clients use (default: the source's own)")
+		cmd.Flags().BoolVar(&noPgBouncer, "no-pgbouncer", false, "Do not pause or repoint PgBouncer; clients are moved by other means")
+	}
+	if use != "plan" {
+		cmd.Flags().DurationVar(&timeout, "timeout", 10*time.Minute, "How long to wait for the initial copy or the catch-up")
+		cmd.Flags().BoolVar(&progress, "progress", false, "Stream step events to stderr as NDJSON")
+	}
+	if use == "start" {
+		cmd.Flags().BoolVar(&wait, "wait", false, "Wait for the initial copy of every table")
+	}
+	return cmd
+}
+
+// MigrateDatabaseStatus is the replication of one migrated database.
+type MigrateDatabaseStatus struct {
+	Database     string                          `json:"database"`
+	Subscription *replication.SubscriptionStatus `json:"subscription,omitempty"`
+	Synced       bool                            `json:"synced"`
+	// LagBytes is the source WAL the subscription has not confirmed.
+	LagBytes int64  `json:"lag_bytes"`
+	Error    string `json:"error,omitempty"`
+}
+
+// MigrateStatusResult is the data of a "migrate status" response.
+type MigrateStatusResult struct {
+	Source          string                  `json:"source"`
+	Target          string                  `json:"target"`
+	Databases       []MigrateDatabaseStatus `json:"databases"`
+	ReadyForCutover bool                    `json:"ready_for_cutover"`
+	Warnings        []string                `json:"warnings"`
+}
+
+// TableHeader implements output.Table.
+func (r MigrateStatusResult) TableHeader() []string {
+	return []string{"DATABASE", "SYNCED", "TABLE STATES", "LAG BYTES", "ERROR"}
+}
+
+// TableRows implements output.Table.
+func (r MigrateStatusResult) TableRows() [][]string {
+	rows := make([][]string, 0, len(r.Databases))
+	for _, d := range r.Databases {
+		states := []string{}
+		if d.Subscription != nil {
+			for state, n := range d.Subscription.TableStates {
+				states = append(states, fmt.Sprintf("%s=%d", state, n))
+			}
+			sort.Strings(states)
+		}
+		errText := d.Error
+		if errText == "" {
+			errText = "-"
+		}
+		rows = append(rows, []string{d.Database, strconv.FormatBool(d.Synced), strings.Join(states, ","),
+			strconv.FormatInt(d.LagBytes, 10), errText})
+	}
+	return rows
+}
+
+// newMigrateStatusCmd implements "migrate status".
+func newMigrateStatusCmd(cfg *config.Config, format *output.Format, reg *cluster.Registry) *cobra.Command {
+	var f migrateFlags
+
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "Show the initial copy and catch-up lag of a migration",
+		Long: "For every database, read the migration subscription on the target leader with the\n" +
+			"sync state of each table, and the WAL its source slot holds unconfirmed (lag_bytes).\n" +
+			"ready_for_cutover is set once every table of every database is ready.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := f.validate(); err != nil {
+				return writeFailure(cmd, *format, "migrate status", err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			result := MigrateStatusResult{Source: f.name, Target: f.target, ReadyForCutover: true, Warnings: []string{}}
+			for _, db := range splitList(f.databases) {
+				st, warnings := migrateDatabaseStatus(ctx, cfg, reg, f.name, f.target, db)
+				if !st.Synced {
+					result.ReadyForCutover = false
+				}
+				result.Databases = append(result.Databases, st)
+				result.Warnings = append(result.Warnings, warnings...)
+			}
+			return writeSuccess(cmd, *format, "migrate status", result)
+		},
+	}
+	f.register(cmd)
+	return cmd
+}
+
+// migrateDatabaseStatus reads the migration subscription of database db and
+// the warnings about it.
+func migrateDatabaseStatus(ctx context.Context, cfg *config.Config, reg *cluster.Registry,
+	source, target, db string) (MigrateDatabaseStatus, []string) {
+
+	st := MigrateDatabaseStatus{Database: db}
+	src, _, err := openLogical(ctx, nil, source, db, cfg, reg)
+	if err != nil {
+		st.Error = err.Error()
+		return st, nil
+	}
+	defer src.Close(ctx)
+	dst, _, err := openLogical(ctx, nil, target, db, cfg, reg)
+	if err != nil {
+		st.Error = err.Error()
+		return st, nil
+	}
+	defer dst.Close(ctx)
+
+	status, err := replication.Status(ctx, src, dst)
+	if err != nil {
+		st.Error = err.Error()
+		return st, nil
+	}
+	for i := range status.Subscriptions {
+		if status.Subscriptions[i].Name == lifecycle.MigrationName {
+			st.Subscription = &status.Subscriptions[i]
+		}
+	}
+	if st.Subscription == nil {
+		st.Error = "no migration subscription; run migrate start"
+		return st, nil
+	}
+	st.Synced = st.Subscription.Synced
+	if st.Subscription.SourceSlot != nil {
+		st.LagBytes = st.Subscription.SourceSlot.PendingBytes
+	}
+	var warnings []string
+	for _, w := range status.Warnings {
+		if strings.Contains(w, " "+lifecycle.MigrationName+" ") {
+			warnings = append(warnings, db+": "+w)
+		}
+	}
+	return st, warnings
+}
@@ -2,26 +2,31 @@ package cli
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"time"
 
 	"github.com/spf13/cobra"
 
 	"github.com/luckyjian/pgdba/internal/cluster"
+	"github.com/luckyjian/pgdba/internal/config"
 	"github.com/luckyjian/pgdba/internal/failover"
+	"github.com/luckyjian/pgdba/internal/lifecycle"
 	"github.com/luckyjian/pgdba/internal/output"
-	"github.com/luckyjian/pgdba/internal/patroni"
 )
 
 // newReplicaCmd returns the "replica" parent command.
-func newReplicaCmd(format *output.Format, reg *cluster.Registry) *cobra.Command {
+func newReplicaCmd(cfg *config.Config, format *output.Format, reg *cluster.Registry) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "replica",
-		Short: "Manage cluster replicas (list, promote)",
+		Short: "Manage cluster replicas (list, promote, reinit, sync, slots)",
 	}
 	cmd.AddCommand(
 		newReplicaListCmd(format, reg),
 		newReplicaPromoteCmd(format, reg),
+		newReplicaReinitCmd(format, reg),
+		newReplicaSyncCmd(cfg, format, reg),
+		newReplicaSlotsCmd(cfg, format, reg),
 	)
 	return cmd
 }
@@ -34,7 +39,7 @@ func newReplicaListCmd(format *output.Format, reg *cluster.Registry) *cobra.Comm
 		Use:   "list",
 		Short: "List all replica nodes and their replication lag",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			url, err := resolvePatroniURL(name, patroniURL, reg)
+			client, err := resolvePatroniClient(name, patroniURL, reg)
 			if err != nil {
 				return writeFailure(cmd, *format, "replica list", err)
 			}
@@ -42,7 +47,7 @@ func newReplicaListCmd(format *output.Format, reg *cluster.Registry) *cobra.Comm
 			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 			defer cancel()
 
-			cs, err := patroni.NewClient(url).GetClusterStatus(ctx)
+			cs, err := client.GetClusterStatus(ctx)
 			if err != nil {
 				return writeFailure(cmd, *format, "replica list",
 					fmt.Errorf("get cluster status: %w", err))
@@ -87,7 +92,8 @@ func newReplicaListCmd(format *output.Format, reg *cluster.Registry) *cobra.Comm
 // newReplicaPromoteCmd implements "replica promote".
 // It runs a controlled switchover targeting the specified candidate.
 func newReplicaPromoteCmd(format *output.Format, reg *cluster.Registry) *cobra.Command {
-	var name, patroniURL, candidate string
+	var name, patroniURL, candidate, at string
+	var lag lagFlags
 
 	cmd := &cobra.Command{
 		Use:   "promote",
@@ -97,8 +103,16 @@ func newReplicaPromoteCmd(format *output.Format, reg *cluster.Registry) *cobra.C
 				return writeFailure(cmd, *format, "replica promote",
 					fmt.Errorf("--candidate is required"))
 			}
+			scheduledAt, err := parseAt(at, time.Now())
+			if err != nil {
+				return writeFailure(cmd, *format, "replica promote", err)
+			}
 
-			url, err := resolvePatroniURL(name, patroniURL, reg)
+			client, err := resolvePatroniClient(name, patroniURL, reg)
+			if err != nil {
+				return writeFailure(cmd, *format, "replica promote", err)
+			}
+			policy, err := lag.resolve(reg, name)
 			if err != nil {
 				return writeFailure(cmd, *format, "replica promote", err)
 			}
@@ -106,7 +120,9 @@ func newReplicaPromoteCmd(format *output.Format, reg *cluster.Registry) *cobra.C
 			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 			defer cancel()
 
-			client := patroni.NewClient(url)
+			if err := checkPaused(ctx, cmd, reg, name, client, true); err != nil {
+				return writeFailure(cmd, *format, "replica promote", err)
+			}
 			cs, err := client.GetClusterStatus(ctx)
 			if err != nil {
 				return writeFailure(cmd, *format, "replica promote",
@@ -114,7 +130,11 @@ func newReplicaPromoteCmd(format *output.Format, reg *cluster.Registry) *cobra.C
 			}
 
 			// Validate the candidate before calling Patroni.
-			if err := failover.CheckSwitchover(cs, candidate, failover.DefaultMaxLagBytes); err != nil {
+			if err := failover.CheckSwitchover(cs, candidate, policy.MaxLagBytes); err != nil {
+				return writeFailure(cmd, *format, "replica promote", err)
+			}
+			ranking := failover.RankCandidates(cs, failover.ReplayLags(ctx, client, cs), policy)
+			if err := failover.CheckCandidate(ranking, candidate); err != nil {
 				return writeFailure(cmd, *format, "replica promote", err)
 			}
 
@@ -123,16 +143,21 @@ func newReplicaPromoteCmd(format *output.Format, reg *cluster.Registry) *cobra.C
 				return writeFailure(cmd, *format, "replica promote", err)
 			}
 
-			if err := client.Switchover(ctx, primary, candidate); err != nil {
+			if err := client.ScheduleSwitchover(ctx, primary, candidate, scheduledAt); err != nil {
 				return writeFailure(cmd, *format, "replica promote",
 					fmt.Errorf("switchover failed: %w", err))
 			}
 
-			resp := output.Success("replica promote", map[string]string{
+			data := map[string]string{
 				"candidate": candidate,
 				"from":      primary,
 				"status":    "promoted",
-			})
+			}
+			if !scheduledAt.IsZero() {
+				data["status"] = "scheduled"
+				data["scheduled_at"] = scheduledAt.Format(time.RFC3339)
+			}
+			resp := output.Success("replica promote", data)
 			out, err := output.FormatResponse(resp, *format)
 			if err != nil {
 				return err
@@ -144,5 +169,61 @@ func newReplicaPromoteCmd(format *output.Format, reg *cluster.Registry) *cobra.C
 	cmd.Flags().StringVar(&name, "name", "", "Cluster name (looks up Patroni URL from registry)")
 	cmd.Flags().StringVar(&patroniURL, "patroni-url", "", "Patroni API URL")
 	cmd.Flags().StringVar(&candidate, "candidate", "", "Replica node name to promote")
+	cmd.Flags().StringVar(&at, "at", "", "Schedule the switchover: RFC 3339 time (2025-06-01T02:00:00Z) or delay from now (90m)")
+	lag.register(cmd)
+	return cmd
+}
+
+// newReplicaReinitCmd implements "replica reinit".
+func newReplicaReinitCmd(format *output.Format, reg *cluster.Registry) *cobra.Command {
+	var name, patroniURL, member string
+	var force, fromLeader, progress bool
+	var timeout time.Duration
+
+	cmd := &cobra.Command{
+		Use:   "reinit",
+		Short: "Rebuild a replica from a fresh base backup",
+		Long: "Re-initialize --member through its own Patroni API: Patroni wipes its data directory\n" +
+			"and takes a fresh base backup. The member is then followed through \"creating replica\"\n" +
+			"until it replicates again, or the command fails after --timeout. The current leader is\n" +
+			"refused; switch over first. So is a paused cluster, where Patroni would not act.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if member == "" {
+				return writeFailure(cmd, *format, "replica reinit", fmt.Errorf("--member is required"))
+			}
+			client, err := resolvePatroniClient(name, patroniURL, reg)
+			if err != nil {
+				return writeFailure(cmd, *format, "replica reinit", err)
+			}
+			if err := checkPaused(context.Background(), cmd, reg, name, client, true); err != nil {
+				return writeFailure(cmd, *format, "replica reinit", err)
+			}
+
+			opts := lifecycle.ReinitOptions{Force: force, FromLeader: fromLeader, Timeout: timeout}
+			if progress {
+				enc := json.NewEncoder(cmd.ErrOrStderr())
+				opts.OnEvent = func(ev lifecycle.StepEvent) { _ = enc.Encode(ev) }
+			}
+			result, err := lifecycle.Reinit(context.Background(), client, member, opts)
+			if err != nil {
+				return writeFailure(cmd, *format, "replica reinit", err)
+			}
+
+			resp := output.Success("replica reinit", result)
+			out, err := output.FormatResponse(resp, *format)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), out)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&name, "name", "", "Cluster name (looks up Patroni URL from registry)")
+	cmd.Flags().StringVar(&patroniURL, "patroni-url", "", "Patroni API URL")
+	cmd.Flags().StringVar(&member, "member", "", "Replica to re-initialize")
+	cmd.Flags().BoolVar(&force, "force", false, "Cancel a bootstrap or re-initialization already running on the member")
+	cmd.Flags().BoolVar(&fromLeader, "from-leader", false, "Take the base backup from the leader rather than a replica")
+	cmd.Flags().DurationVar(&timeout, "timeout", 30*time.Minute, "How long to wait for the member to replicate again")
+	cmd.Flags().BoolVar(&progress, "progress", false, "Stream step events to stderr as NDJSON")
 	return cmd
 }
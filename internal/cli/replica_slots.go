@@ -0,0 +1,280 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/luckyjian/pgdba/internal/cluster"
+	"github.com/luckyjian/pgdba/internal/config"
+	"github.com/luckyjian/pgdba/internal/failover"
+	"github.com/luckyjian/pgdba/internal/output"
+	"github.com/luckyjian/pgdba/internal/patroni"
+	"github.com/luckyjian/pgdba/internal/postgres"
+	"github.com/luckyjian/pgdba/internal/replication"
+)
+
+// newReplicaSlotsCmd returns the "replica slots" parent command.
+func newReplicaSlotsCmd(cfg *config.Config, format *output.Format, reg *cluster.Registry) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "slots",
+		Short: "Inspect and clean up replication slots (list, drop, advance)",
+	}
+	cmd.AddCommand(
+		newReplicaSlotsListCmd(cfg, format, reg),
+		newReplicaSlotsDropCmd(cfg, format, reg),
+		newReplicaSlotsAdvanceCmd(cfg, format, reg),
+	)
+	return cmd
+}
+
+// slotFlags are the flags shared by the "replica slots" subcommands.
+type slotFlags struct {
+	name, patroniURL, member string
+}
+
+func (f *slotFlags) register(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&f.name, "name", "", "Cluster name (looks up Patroni URL from registry)")
+	cmd.Flags().StringVar(&f.patroniURL, "patroni-url", "", "Patroni API URL")
+	cmd.Flags().StringVar(&f.member, "member", "", "Member whose slots to read (default: the leader)")
+}
+
+// open connects to the member's PostgreSQL and lists its slots, classified
+// against the DCS configuration and the cluster members. Commands that
+// change a slot pass cmd, to warn when the cluster is paused. The caller
+// closes the returned DB.
+func (f *slotFlags) open(ctx context.Context, cmd *cobra.Command, cfg *config.Config, reg *cluster.Registry) (
+	replication.DB, *replication.SlotInventory, string, error) {
+
+	client, err := resolvePatroniClient(f.name, f.patroniURL, reg)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if cmd != nil {
+		_ = checkPaused(ctx, cmd, reg, f.name, client, false)
+	}
+	dynamic, err := client.GetConfig(ctx)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	cs, err := client.GetClusterStatus(ctx)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("get cluster status: %w", err)
+	}
+	member := f.member
+	if member == "" {
+		if member, err = failover.FindPrimary(cs); err != nil {
+			return nil, nil, "", err
+		}
+	}
+	var target *patroni.Member
+	names := make([]string, 0, len(cs.Members))
+	for i, m := range cs.Members {
+		names = append(names, m.Name)
+		if m.Name == member {
+			target = &cs.Members[i]
+		}
+	}
+	if target == nil {
+		return nil, nil, "", fmt.Errorf("member %q not found in cluster", member)
+	}
+	pgCfg, err := memberPGConfig(f.name, cfg, reg, *target)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	conn, err := postgres.Connect(ctx, pgCfg)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	db := replication.NewPgxDB(conn)
+	inv, err := replication.ListSlots(ctx, db, dynamic, names)
+	if err != nil {
+		db.Close(ctx)
+		return nil, nil, "", err
+	}
+	return db, inv, member, nil
+}
+
+// SlotListResult is the data of a "replica slots list" response.
+type SlotListResult struct {
+	Member string `json:"member"`
+	*replication.SlotInventory
+}
+
+// TableHeader implements output.Table.
+func (r SlotListResult) TableHeader() []string {
+	return []string{"SLOT", "TYPE", "DATABASE", "ACTIVE", "PID", "RETAINED WAL", "WAL STATUS", "SAFE WAL SIZE", "MANAGED BY"}
+}
+
+// TableRows implements output.Table.
+func (r SlotListResult) TableRows() [][]string {
+	dash := func(s string) string {
+		if s == "" {
+			return "-"
+		}
+		return s
+	}
+	rows := make([][]string, 0, len(r.Slots))
+	for _, s := range r.Slots {
+		pid, safe := "-", "-"
+		if s.ActivePID != nil {
+			pid = strconv.Itoa(int(*s.ActivePID))
+		}
+		if s.SafeWALSize != nil {
+			safe = strconv.FormatInt(*s.SafeWALSize, 10)
+		}
+		rows = append(rows, []string{s.Name, s.Type, dash(s.Database), strconv.FormatBool(s.Active), pid,
+			strconv.FormatInt(s.RetainedBytes, 10), dash(s.WALStatus), safe, s.ManagedBy})
+	}
+	return rows
+}
+
+// newReplicaSlotsListCmd implements "replica slots list".
+func newReplicaSlotsListCmd(cfg *config.Config, format *output.Format, reg *cluster.Registry) *cobra.Command {
+	var f slotFlags
+
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List replication slots with their retained WAL and who manages them",
+		Long: "List pg_replication_slots on a member (the leader by default): slot type, active\n" +
+			"PID, the WAL each slot retains (pg_wal_lsn_diff from its restart_lsn) and, on\n" +
+			"PostgreSQL 13+, wal_status and safe_wal_size. managed_by tells permanent slots of\n" +
+			"the DCS \"slots\" config and the members' own slots, which Patroni recreates, from\n" +
+			"slots matched by \"ignore_slots\" and orphaned ones.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			db, inv, member, err := f.open(ctx, nil, cfg, reg)
+			if err != nil {
+				return writeFailure(cmd, *format, "replica slots list", err)
+			}
+			defer db.Close(ctx)
+
+			resp := output.Success("replica slots list", SlotListResult{Member: member, SlotInventory: inv})
+			out, err := output.FormatResponse(resp, *format)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), out)
+			return nil
+		},
+	}
+	f.register(cmd)
+	return cmd
+}
+
+// SlotChangeResult is the data of a "replica slots drop|advance" response.
+type SlotChangeResult struct {
+	Member  string           `json:"member"`
+	Slot    replication.Slot `json:"slot"`
+	Dropped bool             `json:"dropped,omitempty"`
+	// EndLSN is where an advanced slot now starts.
+	EndLSN string `json:"end_lsn,omitempty"`
+}
+
+// newReplicaSlotsDropCmd implements "replica slots drop".
+func newReplicaSlotsDropCmd(cfg *config.Config, format *output.Format, reg *cluster.Registry) *cobra.Command {
+	var f slotFlags
+	var slot string
+	var confirm bool
+
+	cmd := &cobra.Command{
+		Use:   "drop",
+		Short: "Drop an inactive replication slot that Patroni does not manage",
+		Long: "Drop a replication slot to release the WAL it retains. The slot must be inactive,\n" +
+			"and neither a permanent slot of the DCS config nor a member's slot: Patroni would\n" +
+			"recreate those. A logical slot's consumer loses its position for good, hence\n" +
+			"--confirm.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if slot == "" {
+				return writeFailure(cmd, *format, "replica slots drop", fmt.Errorf("--slot is required"))
+			}
+			if !confirm {
+				return writeFailure(cmd, *format, "replica slots drop",
+					fmt.Errorf("--confirm flag is required to drop a replication slot"))
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			db, inv, member, err := f.open(ctx, cmd, cfg, reg)
+			if err != nil {
+				return writeFailure(cmd, *format, "replica slots drop", err)
+			}
+			defer db.Close(ctx)
+
+			s, err := replication.CheckDrop(inv, slot)
+			if err != nil {
+				return writeFailure(cmd, *format, "replica slots drop", err)
+			}
+			if err := db.DropSlot(ctx, slot); err != nil {
+				return writeFailure(cmd, *format, "replica slots drop", fmt.Errorf("drop slot %s: %w", slot, err))
+			}
+
+			resp := output.Success("replica slots drop", SlotChangeResult{Member: member, Slot: s, Dropped: true})
+			out, err := output.FormatResponse(resp, *format)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), out)
+			return nil
+		},
+	}
+	f.register(cmd)
+	cmd.Flags().StringVar(&slot, "slot", "", "Slot to drop (required)")
+	cmd.Flags().BoolVar(&confirm, "confirm", false, "Confirm dropping the slot")
+	return cmd
+}
+
+// newReplicaSlotsAdvanceCmd implements "replica slots advance".
+func newReplicaSlotsAdvanceCmd(cfg *config.Config, format *output.Format, reg *cluster.Registry) *cobra.Command {
+	var f slotFlags
+	var slot, to string
+
+	cmd := &cobra.Command{
+		Use:   "advance",
+		Short: "Move an inactive slot forward to release retained WAL",
+		Long: "Advance an inactive replication slot with pg_replication_slot_advance, to --to or\n" +
+			"to the current WAL position. The changes it skips are never delivered to the\n" +
+			"slot's consumer.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if slot == "" {
+				return writeFailure(cmd, *format, "replica slots advance", fmt.Errorf("--slot is required"))
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			db, inv, member, err := f.open(ctx, cmd, cfg, reg)
+			if err != nil {
+				return writeFailure(cmd, *format, "replica slots advance", err)
+			}
+			defer db.Close(ctx)
+
+			s, err := replication.CheckAdvance(inv, slot)
+			if err != nil {
+				return writeFailure(cmd, *format, "replica slots advance", err)
+			}
+			end, err := db.AdvanceSlot(ctx, slot, to)
+			if err != nil {
+				return writeFailure(cmd, *format, "replica slots advance", fmt.Errorf("advance slot %s: %w", slot, err))
+			}
+
+			resp := output.Success("replica slots advance", SlotChangeResult{Member: member, Slot: s, EndLSN: end})
+			out, err := output.FormatResponse(resp, *format)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), out)
+			return nil
+		},
+	}
+	f.register(cmd)
+	cmd.Flags().StringVar(&slot, "slot", "", "Slot to advance (required)")
+	cmd.Flags().StringVar(&to, "to", "", "Target LSN (default: the current WAL position)")
+	return cmd
+}
@@ -0,0 +1,301 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/luckyjian/pgdba/internal/cluster"
+	"github.com/luckyjian/pgdba/internal/config"
+	"github.com/luckyjian/pgdba/internal/failover"
+	"github.com/luckyjian/pgdba/internal/output"
+	"github.com/luckyjian/pgdba/internal/patroni"
+	"github.com/luckyjian/pgdba/internal/postgres"
+	"github.com/luckyjian/pgdba/internal/replication"
+)
+
+// newReplicaSyncCmd returns the "replica sync" parent command.
+func newReplicaSyncCmd(cfg *config.Config, format *output.Format, reg *cluster.Registry) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "sync",
+		Short: "Manage Patroni synchronous replication (enable, disable, status)",
+	}
+	cmd.AddCommand(
+		newReplicaSyncSetCmd(format, reg, true),
+		newReplicaSyncSetCmd(format, reg, false),
+		newReplicaSyncStatusCmd(cfg, format, reg),
+	)
+	return cmd
+}
+
+// SyncChangeResult is the data of a "replica sync enable|disable" response.
+type SyncChangeResult struct {
+	Sync failover.SyncConfig `json:"sync"`
+	*ConfigPatchResult
+}
+
+// newReplicaSyncSetCmd implements "replica sync enable" and "replica sync
+// disable".
+func newReplicaSyncSetCmd(format *output.Format, reg *cluster.Registry, enable bool) *cobra.Command {
+	var name, patroniURL string
+	var strict, dryRun bool
+	var count int
+
+	use, short, command := "enable", "Turn on synchronous_mode in the DCS configuration", "replica sync enable"
+	if !enable {
+		use, short, command = "disable", "Turn off synchronous_mode (and synchronous_mode_strict)", "replica sync disable"
+	}
+	cmd := &cobra.Command{
+		Use:   use,
+		Short: short,
+		Long: "Patch synchronous_mode, synchronous_mode_strict and synchronous_node_count in the\n" +
+			"dynamic configuration. Patroni then picks the synchronous standbys itself; they show\n" +
+			"as sync_standby in \"replica sync status\" within a loop_wait or two. Strict mode\n" +
+			"blocks writes while fewer standbys than --count are available, so enable refuses it\n" +
+			"unless that many replicas are healthy. A paused cluster is refused, except with\n" +
+			"--dry-run.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if count < 0 {
+				return writeFailure(cmd, *format, command, fmt.Errorf("--count must not be negative"))
+			}
+			client, err := resolvePatroniClient(name, patroniURL, reg)
+			if err != nil {
+				return writeFailure(cmd, *format, command, err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			if err := checkPaused(ctx, cmd, reg, name, client, !dryRun); err != nil {
+				return writeFailure(cmd, *format, command, err)
+			}
+
+			current, err := client.GetConfig(ctx)
+			if err != nil {
+				return writeFailure(cmd, *format, command, err)
+			}
+			next := failover.ParseSyncConfig(current)
+			// Enabling keeps quorum mode if the cluster already uses it.
+			next.Mode, next.Quorum, next.Strict = enable, enable && next.Quorum, enable && strict
+			if count > 0 {
+				next.NodeCount = count
+			}
+
+			var warnings []string
+			if enable {
+				cs, err := client.GetClusterStatus(ctx)
+				if err != nil {
+					return writeFailure(cmd, *format, command, fmt.Errorf("get cluster status: %w", err))
+				}
+				healthy := 0
+				for _, m := range failover.ListReplicas(cs) {
+					if m.State == patroni.StateRunning || m.State == patroni.StateStreaming {
+						healthy++
+					}
+				}
+				if healthy < next.NodeCount {
+					if next.Strict {
+						return writeFailure(cmd, *format, command, fmt.Errorf(
+							"strict synchronous mode needs %d healthy replicas, found %d; writes would block", next.NodeCount, healthy))
+					}
+					warnings = append(warnings, fmt.Sprintf(
+						"only %d healthy replicas for synchronous_node_count %d; commits fall back to asynchronous", healthy, next.NodeCount))
+				}
+			}
+
+			result, err := patchDynamicConfig(ctx, client, current, next.Patch(), dryRun, nil)
+			if err != nil {
+				return writeFailure(cmd, *format, command, err)
+			}
+			result.Warnings = append(result.Warnings, warnings...)
+
+			resp := output.Success(command, SyncChangeResult{Sync: next, ConfigPatchResult: result})
+			out, err := output.FormatResponse(resp, *format)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), out)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&name, "name", "", "Cluster name (looks up Patroni URL from registry)")
+	cmd.Flags().StringVar(&patroniURL, "patroni-url", "", "Patroni API URL")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show the diff without patching")
+	if enable {
+		cmd.Flags().BoolVar(&strict, "strict", false, "Set synchronous_mode_strict: never commit without a synchronous standby")
+		cmd.Flags().IntVar(&count, "count", 0, "synchronous_node_count (default: keep the current value, else 1)")
+	}
+	return cmd
+}
+
+// SyncStatusMember is a member's synchronous replication state: its
+// Patroni role and, for standbys connected to the leader, the
+// pg_stat_replication sync_state and lags.
+type SyncStatusMember struct {
+	failover.SyncMember
+	SyncState   string   `json:"sync_state,omitempty"`
+	WriteLagMs  *float64 `json:"write_lag_ms,omitempty"`
+	FlushLagMs  *float64 `json:"flush_lag_ms,omitempty"`
+	ReplayLagMs *float64 `json:"replay_lag_ms,omitempty"`
+}
+
+// SyncStatusResult is the data of a "replica sync status" response.
+type SyncStatusResult struct {
+	Cluster      string                    `json:"cluster,omitempty"`
+	Config       failover.SyncConfig       `json:"config"`
+	Members      []SyncStatusMember        `json:"members"`
+	CommitImpact *replication.CommitImpact `json:"commit_impact,omitempty"`
+	// ReplicationError says why pg_stat_replication could not be read
+	// from the leader; the Patroni view is still reported.
+	ReplicationError string   `json:"replication_error,omitempty"`
+	Warnings         []string `json:"warnings"`
+}
+
+// TableHeader implements output.Table.
+func (r SyncStatusResult) TableHeader() []string {
+	return []string{"MEMBER", "ROLE", "STATE", "LAG BYTES", "SYNC STATE", "WRITE LAG", "FLUSH LAG", "REPLAY LAG"}
+}
+
+// TableRows implements output.Table.
+func (r SyncStatusResult) TableRows() [][]string {
+	ms := func(v *float64) string {
+		if v == nil {
+			return "-"
+		}
+		return fmt.Sprintf("%.1fms", *v)
+	}
+	rows := make([][]string, 0, len(r.Members))
+	for _, m := range r.Members {
+		syncState := m.SyncState
+		if syncState == "" {
+			syncState = "-"
+		}
+		rows = append(rows, []string{m.Name, m.Role, string(m.State), strconv.FormatInt(m.LagBytes, 10),
+			syncState, ms(m.WriteLagMs), ms(m.FlushLagMs), ms(m.ReplayLagMs)})
+	}
+	return rows
+}
+
+// newReplicaSyncStatusCmd implements "replica sync status".
+func newReplicaSyncStatusCmd(cfg *config.Config, format *output.Format, reg *cluster.Registry) *cobra.Command {
+	var name, patroniURL string
+
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "Show synchronous mode, sync_standby vs async members and the commit latency it costs",
+		Long: "Show the synchronous replication settings, each member's role as Patroni reports it\n" +
+			"(sync_standby or async), and from the leader's pg_stat_replication the write, flush\n" +
+			"and replay lag of every standby. commit_impact estimates what a commit waits for\n" +
+			"under the leader's synchronous_commit setting: the slowest synchronous standby.\n" +
+			"The leader is reached at its Patroni host and port with the configured user\n" +
+			"(PGDBA_PG_USER, PGDBA_PG_PASSWORD).",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client, err := resolvePatroniClient(name, patroniURL, reg)
+			if err != nil {
+				return writeFailure(cmd, *format, "replica sync status", err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			dynamic, err := client.GetConfig(ctx)
+			if err != nil {
+				return writeFailure(cmd, *format, "replica sync status", err)
+			}
+			cs, err := client.GetClusterStatus(ctx)
+			if err != nil {
+				return writeFailure(cmd, *format, "replica sync status",
+					fmt.Errorf("get cluster status: %w", err))
+			}
+
+			result := SyncStatusResult{Cluster: name, Config: failover.ParseSyncConfig(dynamic), Warnings: []string{}}
+			for _, m := range failover.SyncMembers(cs) {
+				result.Members = append(result.Members, SyncStatusMember{SyncMember: m})
+			}
+			standbys := failover.SyncStandbys(cs)
+			switch {
+			case result.Config.Mode && len(standbys) < result.Config.NodeCount:
+				result.Warnings = append(result.Warnings, fmt.Sprintf(
+					"synchronous_node_count is %d but Patroni reports %d sync_standby members", result.Config.NodeCount, len(standbys)))
+			case !result.Config.Mode && len(standbys) > 0:
+				result.Warnings = append(result.Warnings, "synchronous_mode is off but Patroni still reports sync_standby members")
+			}
+
+			impact, err := readReplication(ctx, name, cfg, reg, cs, result.Members)
+			if err != nil {
+				result.ReplicationError = err.Error()
+			}
+			result.CommitImpact = impact
+
+			resp := output.Success("replica sync status", result)
+			out, err := output.FormatResponse(resp, *format)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), out)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&name, "name", "", "Cluster name (looks up Patroni URL from registry)")
+	cmd.Flags().StringVar(&patroniURL, "patroni-url", "", "Patroni API URL")
+	return cmd
+}
+
+// readReplication reads pg_stat_replication from the leader of cs, fills in
+// the lags of members and estimates the commit impact.
+func readReplication(ctx context.Context, name string, cfg *config.Config, reg *cluster.Registry,
+	cs *patroni.ClusterStatus, members []SyncStatusMember) (*replication.CommitImpact, error) {
+
+	leader, err := failover.FindPrimary(cs)
+	if err != nil {
+		return nil, err
+	}
+	var pgCfg postgres.Config
+	for _, m := range cs.Members {
+		if m.Name == leader {
+			pgCfg, err = memberPGConfig(name, cfg, reg, m)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn, err := postgres.Connect(ctx, pgCfg)
+	if err != nil {
+		return nil, err
+	}
+	db := replication.NewPgxDB(conn)
+	defer db.Close(ctx)
+
+	standbys, err := db.Standbys(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("read pg_stat_replication: %w", err)
+	}
+	syncCommit, err := db.Setting(ctx, "synchronous_commit")
+	if err != nil {
+		return nil, fmt.Errorf("read synchronous_commit: %w", err)
+	}
+	for i := range members {
+		for _, s := range standbys {
+			if s.ApplicationName == members[i].Name {
+				members[i].SyncState = s.SyncState
+				members[i].WriteLagMs, members[i].FlushLagMs, members[i].ReplayLagMs = s.WriteLagMs, s.FlushLagMs, s.ReplayLagMs
+			}
+		}
+	}
+	impact := replication.EstimateCommitImpact(syncCommit, standbys)
+	return &impact, nil
+}
+
+// memberPGConfig returns the connection config for member m's PostgreSQL:
+// its host and port as Patroni reports them, the rest as resolvePGConfig
+// resolves it.
+func memberPGConfig(name string, cfg *config.Config, reg *cluster.Registry, m patroni.Member) (postgres.Config, error) {
+	if m.Host == "" {
+		return resolvePGConfig(name, cfg, reg)
+	}
+	own := *cfg
+	own.PG.Host, own.PG.Port = m.Host, m.Port
+	return resolvePGConfig("", &own, reg)
+}
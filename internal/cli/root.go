@@ -38,7 +38,8 @@ func buildRootCmd(reg *cluster.Registry) *cobra.Command {
 	// subcommand runs, ensuring environment variables and config file are loaded.
 	cfg := &config.Config{}
 
-	root := &cobra.Command{
+	var root *cobra.Command
+	root = &cobra.Command{
 		Use:   "pgdba",
 		Short: "PostgreSQL virtual DBA expert system",
 		Long: "pgdba provides a full suite of PostgreSQL DBA operations — high-availability " +
@@ -66,8 +67,11 @@ func buildRootCmd(reg *cluster.Registry) *cobra.Command {
 				return fmt.Errorf("load config: %w", err)
 			}
 			*cfg = *loaded
+			// The root --provider flag overrides provider.type from the config file.
+			if f := root.PersistentFlags().Lookup("provider"); f != nil && f.Changed {
+				cfg.Provider.Type = provider
+			}
 			_ = verbose
-			_ = provider
 			return nil
 		},
 	}
@@ -79,8 +83,10 @@ func buildRootCmd(reg *cluster.Registry) *cobra.Command {
 
 	root.AddCommand(newHealthCmd(cfg, &format))
 	root.AddCommand(newClusterCmd(cfg, &format, reg))
-	root.AddCommand(newFailoverCmd(&format, reg))
-	root.AddCommand(newReplicaCmd(&format, reg))
+	root.AddCommand(newFailoverCmd(cfg, &format, reg))
+	root.AddCommand(newReplicaCmd(cfg, &format, reg))
+	root.AddCommand(newLogicalCmd(cfg, &format, reg))
+	root.AddCommand(newMigrateCmd(cfg, &format, reg))
 	root.AddCommand(newInspectCmd(cfg, &format, reg))
 	root.AddCommand(newConfigCmd(cfg, &format, reg))
 	root.AddCommand(newQueryCmd(cfg, &format, reg))
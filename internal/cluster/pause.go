@@ -0,0 +1,73 @@
+package cluster
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// PauseRecord records who put a cluster into Patroni maintenance mode, why,
+// and until when. Patroni itself only knows whether the cluster is paused.
+type PauseRecord struct {
+	Cluster   string     `json:"cluster"`
+	By        string     `json:"by"`
+	Reason    string     `json:"reason"`
+	PausedAt  time.Time  `json:"paused_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // deadline; nil = until resumed
+}
+
+// Expired reports whether the pause had a deadline that has passed at now.
+// Nothing lifts an expired pause by itself; pgdba commands resume it when
+// they next change the cluster.
+func (p *PauseRecord) Expired(now time.Time) bool {
+	return p.ExpiresAt != nil && !now.Before(*p.ExpiresAt)
+}
+
+// pausePath returns the file holding the pause record of cluster name,
+// kept with other per-cluster operation state next to the registry.
+func (r *Registry) pausePath(name string) string {
+	return filepath.Join(r.Dir(), "operations", name+"-pause.json")
+}
+
+// GetPause returns the pause record of cluster name, or nil if pgdba has
+// not paused it.
+func (r *Registry) GetPause(name string) (*PauseRecord, error) {
+	data, err := os.ReadFile(r.pausePath(name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read pause record: %w", err)
+	}
+	var p PauseRecord
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("parse pause record: %w", err)
+	}
+	return &p, nil
+}
+
+// SetPause stores p as the pause record of cluster p.Cluster.
+func (r *Registry) SetPause(p PauseRecord) error {
+	path := r.pausePath(p.Cluster)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("create operations dir: %w", err)
+	}
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal pause record: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("write pause record: %w", err)
+	}
+	return nil
+}
+
+// ClearPause removes the pause record of cluster name, if any.
+func (r *Registry) ClearPause(name string) error {
+	if err := os.Remove(r.pausePath(name)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove pause record: %w", err)
+	}
+	return nil
+}
@@ -26,6 +26,46 @@ type Entry struct {
 	Source     Source            `json:"source"`
 	CreatedAt  time.Time         `json:"created_at"`
 	Labels     map[string]string `json:"labels,omitempty"`
+
+	// EtcdEndpoints, DataDir, PGVersion and Image record how a managed
+	// cluster was bootstrapped (or last upgraded) so that members added
+	// later are configured alike. EtcdEndpoints ("host:2379") is also set
+	// on connect, for the DCS checks of status.
+	EtcdEndpoints []string `json:"etcd_endpoints,omitempty"`
+	DataDir       string   `json:"data_dir,omitempty"`
+	PGVersion     int      `json:"pg_version,omitempty"`
+	Image         string   `json:"image,omitempty"`
+
+	// PatroniAccess is set for clusters whose Patroni REST API needs
+	// authentication or TLS, or lists further endpoints to fail over to.
+	PatroniAccess *PatroniAccess `json:"patroni_access,omitempty"`
+
+	// Failover overrides the default lag thresholds for switchover and
+	// failover candidates of this cluster and for the members of its rolling
+	// operations, and how a forced failover fences the old leader.
+	Failover *FailoverPolicy `json:"failover,omitempty"`
+}
+
+// FailoverPolicy bounds how far behind a replica may be to be promoted and
+// says how the old leader is fenced after a forced failover (Fence: none,
+// stop or partition; FenceWhen: before or after the promotion). Zero fields
+// take the defaults.
+type FailoverPolicy struct {
+	MaxLagBytes   int64   `json:"max_lag_bytes,omitempty"`
+	MaxLagSeconds float64 `json:"max_lag_seconds,omitempty"`
+	Fence         string  `json:"fence,omitempty"`
+	FenceWhen     string  `json:"fence_when,omitempty"`
+}
+
+// PatroniAccess describes how to reach a cluster's Patroni REST API. File
+// paths are stored, never secrets: the basic auth password is read from
+// PGDBA_PATRONI_PASSWORD or PGDBA_PATRONI_PASSWORD_FILE.
+type PatroniAccess struct {
+	Username  string   `json:"username,omitempty"`
+	CAFile    string   `json:"ca_file,omitempty"`
+	CertFile  string   `json:"cert_file,omitempty"`
+	KeyFile   string   `json:"key_file,omitempty"`
+	Endpoints []string `json:"endpoints,omitempty"`
 }
 
 // Registry manages cluster entries persisted to a JSON file.
@@ -51,6 +91,12 @@ func NewRegistry(path string) *Registry {
 	return &Registry{path: path}
 }
 
+// Dir returns the directory holding the registry file. Other per-cluster
+// state, such as interrupted operations, is kept next to it.
+func (r *Registry) Dir() string {
+	return filepath.Dir(r.path)
+}
+
 // Add inserts or overwrites a cluster entry (keyed by Name).
 func (r *Registry) Add(e Entry) error {
 	entries, err := r.load()
@@ -22,9 +22,12 @@ type ClusterConfig struct {
 	Name string `yaml:"name" mapstructure:"name"`
 }
 
-// ProviderConfig specifies the infrastructure provider.
+// ProviderConfig specifies the infrastructure provider. Options holds the
+// provider-specific settings passed to provider.New (e.g. the Docker network,
+// the bare-metal host inventory or the Kubernetes namespace).
 type ProviderConfig struct {
-	Type string `yaml:"type" mapstructure:"type"`
+	Type    string            `yaml:"type"    mapstructure:"type"`
+	Options map[string]string `yaml:"options" mapstructure:"options"`
 }
 
 // PGConfig holds PostgreSQL connection parameters. No Password field — passwords
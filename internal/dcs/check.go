@@ -0,0 +1,154 @@
+package dcs
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/luckyjian/pgdba/internal/patroni"
+)
+
+// Severities of a Finding.
+const (
+	SeverityWarn = "warn"
+	SeverityFail = "fail"
+)
+
+// Finding codes reported by Check.
+const (
+	FindingNoLeader          = "no_leader"
+	FindingStaleLeaderLock   = "stale_leader_lock"
+	FindingLeaderNotMember   = "leader_not_member"
+	FindingLeaderUnreachable = "leader_unreachable"
+	FindingSplitBrain        = "split_brain"
+	FindingLeaseTTL          = "lease_ttl_mismatch"
+	FindingMemberUnreachable = "member_unreachable"
+	FindingTimeline          = "timeline_mismatch"
+	FindingPendingFailover   = "pending_failover"
+)
+
+// MemberView is what a member's own REST API reports about it.
+type MemberView struct {
+	Name      string `json:"name"`
+	APIURL    string `json:"api_url"`
+	Reachable bool   `json:"reachable"`
+	Role      string `json:"role,omitempty"`
+	State     string `json:"state,omitempty"`
+	Timeline  int64  `json:"timeline,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Finding is one inconsistency between the DCS and the members.
+type Finding struct {
+	Severity string `json:"severity"`
+	Code     string `json:"code"`
+	Member   string `json:"member,omitempty"`
+	Message  string `json:"message"`
+}
+
+// CheckReport is the result of comparing a Snapshot with the members' views.
+type CheckReport struct {
+	Leader   string       `json:"leader"`
+	LeaseTTL int64        `json:"lease_ttl"` // remaining seconds, -1 = no live lease
+	TTL      int64        `json:"ttl"`       // configured Patroni ttl
+	Members  []MemberView `json:"members"`
+	Findings []Finding    `json:"findings"`
+	Healthy  bool         `json:"healthy"` // no fail findings
+}
+
+// Check compares the DCS snapshot with what each member's REST API
+// reports. It flags a missing or stale leader lock (no live lease, or held
+// by a member that is not running as primary), more than one primary or a
+// primary that does not hold the lock (split-brain), a lease granted with a
+// TTL other than the configured one, and unreachable members.
+func Check(snap *Snapshot, views []MemberView) *CheckReport {
+	r := &CheckReport{Leader: snap.Leader, LeaseTTL: -1, Members: views, Findings: []Finding{}}
+	if r.Members == nil {
+		r.Members = []MemberView{}
+	}
+	r.TTL = patroni.DefaultTTL
+	if v, ok := patroni.ConfigNumber(snap.Config["ttl"]); ok {
+		r.TTL = int64(v)
+	}
+	add := func(severity, code, member, format string, args ...interface{}) {
+		r.Findings = append(r.Findings, Finding{Severity: severity, Code: code, Member: member,
+			Message: fmt.Sprintf(format, args...)})
+	}
+
+	byName := map[string]MemberView{}
+	for _, v := range views {
+		byName[v.Name] = v
+	}
+
+	switch {
+	case snap.Leader == "":
+		add(SeverityFail, FindingNoLeader, "", "no leader key in the DCS")
+	case snap.LeaderLease == nil:
+		add(SeverityFail, FindingStaleLeaderLock, snap.Leader, "leader key of %s has no lease and will never expire", snap.Leader)
+	case snap.LeaderLease.TTL <= 0:
+		add(SeverityFail, FindingStaleLeaderLock, snap.Leader, "lease of the leader key of %s has expired", snap.Leader)
+	default:
+		r.LeaseTTL = snap.LeaderLease.TTL
+		if g := snap.LeaderLease.GrantedTTL; g != 0 && g != r.TTL {
+			add(SeverityWarn, FindingLeaseTTL, snap.Leader, "leader lease granted for %ds but ttl is %ds", g, r.TTL)
+		}
+	}
+
+	if snap.Leader != "" {
+		v, ok := byName[snap.Leader]
+		switch {
+		case !ok:
+			add(SeverityFail, FindingLeaderNotMember, snap.Leader, "leader %s has no member key", snap.Leader)
+		case !v.Reachable:
+			add(SeverityWarn, FindingLeaderUnreachable, snap.Leader, "leader %s REST API is unreachable: %s", snap.Leader, v.Error)
+		case !isPrimary(v.Role):
+			add(SeverityFail, FindingStaleLeaderLock, snap.Leader, "%s holds the leader lock but reports role %s", snap.Leader, v.Role)
+		}
+	}
+
+	var primaries []string
+	for _, v := range views {
+		if !v.Reachable {
+			if v.Name != snap.Leader {
+				add(SeverityWarn, FindingMemberUnreachable, v.Name, "%s REST API is unreachable: %s", v.Name, v.Error)
+			}
+			continue
+		}
+		if isPrimary(v.Role) {
+			primaries = append(primaries, v.Name)
+		}
+	}
+	sort.Strings(primaries)
+	if len(primaries) > 1 {
+		add(SeverityFail, FindingSplitBrain, "", "more than one member reports primary: %s", strings.Join(primaries, ", "))
+	}
+	for _, p := range primaries {
+		if p != snap.Leader {
+			add(SeverityFail, FindingSplitBrain, p, "%s reports primary but does not hold the leader lock", p)
+		}
+	}
+
+	for _, m := range snap.Members {
+		v, ok := byName[m.Name]
+		if ok && v.Reachable && m.Timeline != 0 && v.Timeline != 0 && m.Timeline != v.Timeline {
+			add(SeverityWarn, FindingTimeline, m.Name, "%s is on timeline %d but its member key says %d", m.Name, v.Timeline, m.Timeline)
+		}
+	}
+
+	if len(snap.Failover) > 0 {
+		add(SeverityWarn, FindingPendingFailover, "", "a failover key is pending: %v", snap.Failover)
+	}
+
+	r.Healthy = true
+	for _, f := range r.Findings {
+		if f.Severity == SeverityFail {
+			r.Healthy = false
+		}
+	}
+	return r
+}
+
+// isPrimary reports whether a Patroni role is a read-write one.
+func isPrimary(role string) bool {
+	return role == "master" || role == "primary" || role == "leader"
+}
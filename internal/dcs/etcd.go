@@ -0,0 +1,185 @@
+// Package dcs reads Patroni's state straight from its distributed
+// configuration store (etcd v3, through the JSON gateway), for diagnosis
+// when the Patroni REST API cannot be trusted or reached.
+package dcs
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// KeyValue is one etcd key with the lease attached to it (0 = none).
+type KeyValue struct {
+	Key         string
+	Value       []byte
+	Lease       int64
+	ModRevision int64
+}
+
+// Lease is the remaining and granted time to live of an etcd lease, in
+// seconds. TTL is -1 once the lease has expired.
+type Lease struct {
+	ID         int64 `json:"id"`
+	TTL        int64 `json:"ttl"`
+	GrantedTTL int64 `json:"granted_ttl"`
+}
+
+// EtcdClient talks to the etcd v3 JSON gateway (/v3/kv/range,
+// /v3/lease/timetolive). Endpoints are tried in order until one answers.
+type EtcdClient struct {
+	endpoints  []string
+	httpClient *http.Client
+}
+
+// NewEtcdClient creates a client for the given endpoints, either URLs or
+// "host:port" (http is assumed).
+func NewEtcdClient(endpoints []string) *EtcdClient {
+	c := &EtcdClient{httpClient: &http.Client{Timeout: 10 * time.Second}}
+	for _, e := range endpoints {
+		e = strings.TrimRight(strings.TrimSpace(e), "/")
+		if e == "" {
+			continue
+		}
+		if !strings.Contains(e, "://") {
+			e = "http://" + e
+		}
+		c.endpoints = append(c.endpoints, e)
+	}
+	return c
+}
+
+// Prefix returns the keys starting with prefix, sorted by key.
+func (c *EtcdClient) Prefix(ctx context.Context, prefix string) ([]KeyValue, error) {
+	req := map[string]string{
+		"key":       base64.StdEncoding.EncodeToString([]byte(prefix)),
+		"range_end": base64.StdEncoding.EncodeToString(prefixEnd([]byte(prefix))),
+	}
+	var resp struct {
+		Kvs []struct {
+			Key         string `json:"key"`
+			Value       string `json:"value"`
+			Lease       int64s `json:"lease"`
+			ModRevision int64s `json:"mod_revision"`
+		} `json:"kvs"`
+	}
+	if err := c.post(ctx, "/v3/kv/range", req, &resp); err != nil {
+		return nil, fmt.Errorf("range %s: %w", prefix, err)
+	}
+
+	kvs := make([]KeyValue, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		key, err := base64.StdEncoding.DecodeString(kv.Key)
+		if err != nil {
+			return nil, fmt.Errorf("decode key: %w", err)
+		}
+		value, err := base64.StdEncoding.DecodeString(kv.Value)
+		if err != nil {
+			return nil, fmt.Errorf("decode value of %s: %w", key, err)
+		}
+		kvs = append(kvs, KeyValue{Key: string(key), Value: value,
+			Lease: int64(kv.Lease), ModRevision: int64(kv.ModRevision)})
+	}
+	return kvs, nil
+}
+
+// TimeToLive returns the remaining time to live of lease id.
+func (c *EtcdClient) TimeToLive(ctx context.Context, id int64) (*Lease, error) {
+	var resp struct {
+		TTL        int64s `json:"TTL"`
+		GrantedTTL int64s `json:"grantedTTL"`
+	}
+	if err := c.post(ctx, "/v3/lease/timetolive", map[string]string{"ID": strconv.FormatInt(id, 10)}, &resp); err != nil {
+		return nil, fmt.Errorf("lease %x time to live: %w", id, err)
+	}
+	return &Lease{ID: id, TTL: int64(resp.TTL), GrantedTTL: int64(resp.GrantedTTL)}, nil
+}
+
+// post sends body to path on the first endpoint that answers and decodes
+// the response into out.
+func (c *EtcdClient) post(ctx context.Context, path string, body, out interface{}) error {
+	if len(c.endpoints) == 0 {
+		return fmt.Errorf("no etcd endpoints")
+	}
+	var lastErr error
+	for _, base := range c.endpoints {
+		err := c.postTo(ctx, base, path, body, out)
+		if err == nil {
+			return nil
+		}
+		if _, unreachable := err.(*transportError); !unreachable || ctx.Err() != nil {
+			return err
+		}
+		lastErr = err
+	}
+	return lastErr
+}
+
+// transportError is a request that did not get an HTTP response.
+type transportError struct{ err error }
+
+func (e *transportError) Error() string { return e.err.Error() }
+func (e *transportError) Unwrap() error { return e.err }
+
+// postTo sends body to path on endpoint base and decodes the response into
+// out.
+func (c *EtcdClient) postTo(ctx context.Context, base, path string, body, out interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("marshal request body: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, base+path, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return &transportError{err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("etcd %s returned HTTP %d", path, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
+
+// prefixEnd returns the range end matching every key with prefix.
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return []byte{0}
+}
+
+// int64s decodes the 64-bit integers the gateway sends as JSON strings
+// (and accepts plain numbers too).
+type int64s int64
+
+func (n *int64s) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	if s == "" || s == "null" {
+		*n = 0
+		return nil
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return err
+	}
+	*n = int64s(v)
+	return nil
+}
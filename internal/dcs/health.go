@@ -0,0 +1,189 @@
+package dcs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// EndpointStatus is what one etcd endpoint reports about itself
+// (GET /health and POST /v3/maintenance/status).
+type EndpointStatus struct {
+	Endpoint  string   `json:"endpoint"`
+	Healthy   bool     `json:"healthy"`
+	Error     string   `json:"error,omitempty"`
+	MemberID  string   `json:"member_id,omitempty"` // hex, as etcdctl prints it
+	Leader    string   `json:"leader,omitempty"`    // member id of the raft leader
+	IsLeader  bool     `json:"is_leader"`
+	Version   string   `json:"version,omitempty"`
+	RaftTerm  int64    `json:"raft_term,omitempty"`
+	RaftIndex int64    `json:"raft_index,omitempty"`
+	DBSize    int64    `json:"db_size,omitempty"` // bytes
+	Errors    []string `json:"errors,omitempty"`
+}
+
+// Alarm is an alarm raised on an etcd member (NOSPACE, CORRUPT).
+type Alarm struct {
+	MemberID string `json:"member_id"`
+	Alarm    string `json:"alarm"`
+}
+
+// QuorumStatus is the health of an etcd cluster as seen from its endpoints.
+// Quorum holds while a majority of the endpoints are healthy.
+type QuorumStatus struct {
+	Endpoints      []EndpointStatus `json:"endpoints"`
+	HealthyMembers int              `json:"healthy_members"`
+	Quorum         bool             `json:"quorum"`
+	Leader         string           `json:"leader,omitempty"`
+	Alarms         []Alarm          `json:"alarms,omitempty"`
+}
+
+// Health probes every endpoint of c concurrently and returns the quorum
+// status. Probe failures are reported per endpoint, never as an error.
+func (c *EtcdClient) Health(ctx context.Context) *QuorumStatus {
+	q := &QuorumStatus{Endpoints: make([]EndpointStatus, len(c.endpoints))}
+	var wg sync.WaitGroup
+	for i, base := range c.endpoints {
+		wg.Add(1)
+		go func(i int, base string) {
+			defer wg.Done()
+			q.Endpoints[i] = c.endpointStatus(ctx, base)
+		}(i, base)
+	}
+	wg.Wait()
+
+	for _, s := range q.Endpoints {
+		if !s.Healthy {
+			continue
+		}
+		q.HealthyMembers++
+		if q.Leader == "" {
+			q.Leader = s.Leader
+		}
+	}
+	q.Quorum = len(q.Endpoints) > 0 && q.HealthyMembers > len(q.Endpoints)/2
+
+	for _, s := range q.Endpoints {
+		if s.MemberID == "" {
+			continue
+		}
+		alarms, err := c.alarms(ctx, s.Endpoint)
+		if err != nil {
+			continue
+		}
+		q.Alarms = alarms
+		break
+	}
+	return q
+}
+
+// endpointStatus probes a single endpoint.
+func (c *EtcdClient) endpointStatus(ctx context.Context, base string) EndpointStatus {
+	s := EndpointStatus{Endpoint: base}
+
+	var health struct {
+		Health string `json:"health"`
+		Reason string `json:"reason"`
+	}
+	if err := c.getFrom(ctx, base, "/health", &health); err != nil {
+		s.Error = err.Error()
+	} else {
+		s.Healthy = health.Health == "true"
+		if !s.Healthy {
+			s.Error = "unhealthy"
+			if health.Reason != "" {
+				s.Error += ": " + health.Reason
+			}
+		}
+	}
+
+	var status struct {
+		Header struct {
+			MemberID memberID `json:"member_id"`
+		} `json:"header"`
+		Version   string   `json:"version"`
+		DBSize    int64s   `json:"dbSize"`
+		Leader    memberID `json:"leader"`
+		RaftIndex int64s   `json:"raftIndex"`
+		RaftTerm  int64s   `json:"raftTerm"`
+		Errors    []string `json:"errors"`
+	}
+	if err := c.postTo(ctx, base, "/v3/maintenance/status", map[string]string{}, &status); err != nil {
+		if s.Error == "" {
+			s.Error = fmt.Sprintf("status: %v", err)
+		}
+		s.Healthy = false
+		return s
+	}
+	s.MemberID = string(status.Header.MemberID)
+	s.Leader = string(status.Leader)
+	s.IsLeader = s.MemberID != "" && s.MemberID == s.Leader
+	s.Version = status.Version
+	s.DBSize = int64(status.DBSize)
+	s.RaftIndex = int64(status.RaftIndex)
+	s.RaftTerm = int64(status.RaftTerm)
+	s.Errors = status.Errors
+	return s
+}
+
+// alarms lists the alarms raised in the cluster, as seen from base.
+func (c *EtcdClient) alarms(ctx context.Context, base string) ([]Alarm, error) {
+	var resp struct {
+		Alarms []struct {
+			MemberID memberID `json:"memberID"`
+			Alarm    string   `json:"alarm"`
+		} `json:"alarms"`
+	}
+	if err := c.postTo(ctx, base, "/v3/maintenance/alarm", map[string]string{"action": "GET"}, &resp); err != nil {
+		return nil, err
+	}
+	var alarms []Alarm
+	for _, a := range resp.Alarms {
+		if a.Alarm == "" || a.Alarm == "NONE" {
+			continue
+		}
+		alarms = append(alarms, Alarm{MemberID: string(a.MemberID), Alarm: a.Alarm})
+	}
+	return alarms, nil
+}
+
+// getFrom queries path on endpoint base and decodes the response into out.
+// Error responses that carry a JSON body (/health answers 503 when
+// unhealthy) are decoded too.
+func (c *EtcdClient) getFrom(ctx context.Context, base, path string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+path, nil)
+	if err != nil {
+		return fmt.Errorf("build request: %w", err)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return &transportError{err}
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("etcd %s returned HTTP %d", path, resp.StatusCode)
+	}
+	return nil
+}
+
+// memberID decodes the unsigned 64-bit member ids the gateway sends and
+// keeps them in hex.
+type memberID string
+
+func (m *memberID) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	if s == "" || s == "null" || s == "0" {
+		*m = ""
+		return nil
+	}
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return err
+	}
+	*m = memberID(strconv.FormatUint(v, 16))
+	return nil
+}
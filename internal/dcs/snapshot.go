@@ -0,0 +1,100 @@
+package dcs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/luckyjian/pgdba/internal/patroni"
+)
+
+// DefaultNamespace is Patroni's default DCS namespace.
+const DefaultNamespace = "/service/"
+
+// Member is a member key (members/<name>) as Patroni writes it.
+type Member struct {
+	Name         string `json:"name"`
+	APIURL       string `json:"api_url"`
+	ConnURL      string `json:"conn_url"`
+	State        string `json:"state"`
+	Role         string `json:"role"`
+	Version      string `json:"version,omitempty"`
+	Timeline     int64  `json:"timeline,omitempty"`
+	XlogLocation int64  `json:"xlog_location,omitempty"`
+}
+
+// Snapshot is the content of a cluster's keys in the DCS. Keys pgdba does
+// not interpret are kept raw in Other, by their name below the scope.
+type Snapshot struct {
+	Namespace   string                 `json:"namespace"`
+	Scope       string                 `json:"scope"`
+	Leader      string                 `json:"leader"`
+	LeaderLease *Lease                 `json:"leader_lease,omitempty"`
+	Members     []Member               `json:"members"`
+	Config      map[string]interface{} `json:"config,omitempty"`
+	History     []patroni.HistoryEntry `json:"history,omitempty"`
+	Initialize  string                 `json:"initialize,omitempty"` // system identifier
+	Failover    map[string]interface{} `json:"failover,omitempty"`
+	Other       map[string]string      `json:"other,omitempty"`
+}
+
+// KeyPrefix returns the prefix of the keys of scope in namespace.
+func KeyPrefix(namespace, scope string) string {
+	if namespace == "" {
+		namespace = DefaultNamespace
+	}
+	return "/" + strings.Trim(namespace, "/") + "/" + scope + "/"
+}
+
+// Dump reads every key of scope in namespace. The leader lock's lease is
+// looked up so that its remaining TTL can be judged.
+func Dump(ctx context.Context, c *EtcdClient, namespace, scope string) (*Snapshot, error) {
+	prefix := KeyPrefix(namespace, scope)
+	kvs, err := c.Prefix(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	snap := &Snapshot{Namespace: strings.TrimSuffix(prefix, scope+"/"), Scope: scope, Members: []Member{}}
+	for _, kv := range kvs {
+		name := strings.TrimPrefix(kv.Key, prefix)
+		switch {
+		case name == "leader":
+			snap.Leader = string(kv.Value)
+			if kv.Lease != 0 {
+				if snap.LeaderLease, err = c.TimeToLive(ctx, kv.Lease); err != nil {
+					return nil, err
+				}
+			}
+		case strings.HasPrefix(name, "members/"):
+			m := Member{}
+			if err := json.Unmarshal(kv.Value, &m); err != nil {
+				return nil, fmt.Errorf("parse %s: %w", kv.Key, err)
+			}
+			m.Name = strings.TrimPrefix(name, "members/")
+			snap.Members = append(snap.Members, m)
+		case name == "config":
+			err = json.Unmarshal(kv.Value, &snap.Config)
+		case name == "history":
+			err = json.Unmarshal(kv.Value, &snap.History)
+		case name == "failover":
+			if len(kv.Value) > 0 {
+				err = json.Unmarshal(kv.Value, &snap.Failover)
+			}
+		case name == "initialize":
+			snap.Initialize = string(kv.Value)
+		default:
+			if snap.Other == nil {
+				snap.Other = map[string]string{}
+			}
+			snap.Other[name] = string(kv.Value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %w", kv.Key, err)
+		}
+	}
+	sort.Slice(snap.Members, func(i, j int) bool { return snap.Members[i].Name < snap.Members[j].Name })
+	return snap, nil
+}
@@ -0,0 +1,243 @@
+package failover
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/luckyjian/pgdba/internal/patroni"
+)
+
+// LagPolicy bounds how far behind a replica may be to be promoted.
+// MaxLagSeconds compares against the replay lag in seconds; 0 leaves it
+// unchecked, as does a replay lag that could not be measured.
+type LagPolicy struct {
+	MaxLagBytes   int64   `json:"max_lag_bytes"`
+	MaxLagSeconds float64 `json:"max_lag_seconds,omitempty"`
+}
+
+// DefaultLagPolicy returns the policy used for clusters without their own.
+func DefaultLagPolicy() LagPolicy {
+	return LagPolicy{MaxLagBytes: DefaultMaxLagBytes}
+}
+
+// Candidate is a replica's standing as a switchover or failover target.
+// Eligible candidates are ranked from 1; Reasons explain the rank, or why
+// the member cannot be promoted.
+type Candidate struct {
+	Name             string            `json:"name"`
+	Rank             int               `json:"rank"` // 0 = not eligible
+	Eligible         bool              `json:"eligible"`
+	State            patroni.NodeState `json:"state"`
+	Sync             bool              `json:"sync"`
+	NoFailover       bool              `json:"nofailover"`
+	FailoverPriority int               `json:"failover_priority"`
+	LagBytes         int64             `json:"lag_bytes"`
+	ReplayLagSeconds *float64          `json:"replay_lag_seconds"`
+	Reasons          []string          `json:"reasons"`
+}
+
+// defaultFailoverPriority is what Patroni assumes for members without a
+// failover_priority tag.
+const defaultFailoverPriority = 1
+
+// RankCandidates orders the replicas of cs from best to worst promotion
+// target. Unhealthy members, members Patroni will not promote (nofailover,
+// failover_priority 0) and members beyond policy are not eligible and come
+// last. Eligible members are ordered by synchronous standby status (no
+// committed transaction is lost), then failover_priority, then lag in
+// bytes, then replay lag in seconds (replayLag, by member name; members
+// missing from it rank after measured ones), then name. A policy field of
+// 0 is not checked.
+func RankCandidates(cs *patroni.ClusterStatus, replayLag map[string]float64, policy LagPolicy) []Candidate {
+	candidates := make([]Candidate, 0, len(cs.Members))
+	for _, m := range cs.Members {
+		if isLeader(m.Role) {
+			continue
+		}
+		c := Candidate{Name: m.Name, State: m.State, LagBytes: m.Lag, Reasons: []string{},
+			Sync: IsSyncStandby(m.Role), FailoverPriority: defaultFailoverPriority}
+		if lag, ok := replayLag[m.Name]; ok {
+			c.ReplayLagSeconds = &lag
+		}
+		if v, ok := m.Tags["failover_priority"]; ok {
+			if n, ok := patroni.ConfigNumber(v); ok {
+				c.FailoverPriority = int(n)
+			}
+		}
+		c.NoFailover = tagSet(m.Tags["nofailover"]) || c.FailoverPriority <= 0
+
+		var blocked []string
+		if !isReplicaHealthy(m.State) {
+			blocked = append(blocked, fmt.Sprintf("state is %s", m.State))
+		}
+		if c.NoFailover {
+			blocked = append(blocked, "tagged nofailover or failover_priority 0; Patroni will not promote it")
+		}
+		if policy.MaxLagBytes > 0 && m.Lag > policy.MaxLagBytes {
+			blocked = append(blocked, fmt.Sprintf("lag %d bytes exceeds %d", m.Lag, policy.MaxLagBytes))
+		}
+		if policy.MaxLagSeconds > 0 && c.ReplayLagSeconds != nil && *c.ReplayLagSeconds > policy.MaxLagSeconds {
+			blocked = append(blocked, fmt.Sprintf("replay lag %.1fs exceeds %gs", *c.ReplayLagSeconds, policy.MaxLagSeconds))
+		}
+		c.Eligible = len(blocked) == 0
+		c.Reasons = append(c.Reasons, blocked...)
+		candidates = append(candidates, c)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		_, less := compareCandidates(candidates[i], candidates[j])
+		return less
+	})
+
+	rank := 0
+	for i := range candidates {
+		c := &candidates[i]
+		if !c.Eligible {
+			continue
+		}
+		rank++
+		c.Rank = rank
+		c.Reasons = append(c.Reasons, describe(*c))
+		if i > 0 {
+			if why, _ := compareCandidates(candidates[i-1], *c); why != "" {
+				c.Reasons = append(c.Reasons, fmt.Sprintf("ranked after %s: %s", candidates[i-1].Name, why))
+			}
+		}
+	}
+	return candidates
+}
+
+// BestCandidate returns the top-ranked eligible candidate, or an error that
+// says why no replica can be promoted.
+func BestCandidate(candidates []Candidate) (string, error) {
+	if len(candidates) > 0 && candidates[0].Eligible {
+		return candidates[0].Name, nil
+	}
+	if len(candidates) == 0 {
+		return "", fmt.Errorf("no running replica found for promotion")
+	}
+	why := make([]string, 0, len(candidates))
+	for _, c := range candidates {
+		why = append(why, c.Name+": "+strings.Join(c.Reasons, ", "))
+	}
+	return "", fmt.Errorf("no replica is eligible for promotion (%s)", strings.Join(why, "; "))
+}
+
+// compareCandidates reports whether a ranks before b and the criterion that
+// decides it, phrased as the reason b ranks after a.
+func compareCandidates(a, b Candidate) (string, bool) {
+	switch {
+	case a.Eligible != b.Eligible:
+		return "not eligible", a.Eligible
+	case !a.Eligible:
+		return "", a.Name < b.Name
+	case a.Sync != b.Sync:
+		return "not a synchronous standby", a.Sync
+	case a.FailoverPriority != b.FailoverPriority:
+		return fmt.Sprintf("lower failover_priority (%d < %d)", b.FailoverPriority, a.FailoverPriority),
+			a.FailoverPriority > b.FailoverPriority
+	case a.LagBytes != b.LagBytes:
+		return "more lag in bytes", a.LagBytes < b.LagBytes
+	case (a.ReplayLagSeconds == nil) != (b.ReplayLagSeconds == nil):
+		return "replay lag unknown", a.ReplayLagSeconds != nil
+	case a.ReplayLagSeconds != nil && *a.ReplayLagSeconds != *b.ReplayLagSeconds:
+		return "more replay lag in seconds", *a.ReplayLagSeconds < *b.ReplayLagSeconds
+	default:
+		return "tie, ordered by name", a.Name < b.Name
+	}
+}
+
+// describe summarises the standing of an eligible candidate.
+func describe(c Candidate) string {
+	parts := []string{}
+	if c.Sync {
+		parts = append(parts, "synchronous standby")
+	}
+	parts = append(parts, fmt.Sprintf("failover_priority %d", c.FailoverPriority),
+		fmt.Sprintf("lag %d bytes", c.LagBytes))
+	if c.ReplayLagSeconds != nil {
+		parts = append(parts, fmt.Sprintf("replay lag %.1fs", *c.ReplayLagSeconds))
+	} else {
+		parts = append(parts, "replay lag unknown")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// tagSet reports whether a boolean Patroni tag is set; YAML configs may
+// give it as a string.
+func tagSet(v interface{}) bool {
+	switch t := v.(type) {
+	case bool:
+		return t
+	case string:
+		return strings.EqualFold(t, "true") || strings.EqualFold(t, "on") || strings.EqualFold(t, "yes")
+	}
+	return false
+}
+
+// replayedTimestampLayouts are the formats Patroni renders
+// pg_last_xact_replay_timestamp() in.
+var replayedTimestampLayouts = []string{
+	"2006-01-02 15:04:05.999999-07:00",
+	"2006-01-02 15:04:05.999999-07",
+	time.RFC3339Nano,
+}
+
+// ReplayLags measures the replay lag in seconds of every replica of cs
+// through its own Patroni REST API. A replica that has replayed everything
+// the leader has written has no lag; otherwise the lag is the age of the
+// last transaction it replayed, which overstates the lag while the primary
+// is idle. Replicas that cannot be reached or report no replay timestamp
+// are left out.
+func ReplayLags(ctx context.Context, client *patroni.Client, cs *patroni.ClusterStatus) map[string]float64 {
+	lags := map[string]float64{}
+	var leaderLSN int64
+	for _, m := range cs.Members {
+		if !isLeader(m.Role) {
+			continue
+		}
+		if info, err := memberInfo(ctx, client, m); err == nil {
+			leaderLSN = info.Xlog.Location
+		}
+	}
+	now := time.Now()
+	for _, m := range cs.Members {
+		if isLeader(m.Role) {
+			continue
+		}
+		info, err := memberInfo(ctx, client, m)
+		if err != nil {
+			continue
+		}
+		if leaderLSN > 0 && info.Xlog.ReplayedLocation >= leaderLSN {
+			lags[m.Name] = 0
+			continue
+		}
+		for _, layout := range replayedTimestampLayouts {
+			if ts, err := time.Parse(layout, info.Xlog.ReplayedTimestamp); err == nil {
+				lag := now.Sub(ts).Seconds()
+				if lag < 0 {
+					lag = 0
+				}
+				lags[m.Name] = lag
+				break
+			}
+		}
+	}
+	return lags
+}
+
+// memberInfo reads GET /patroni from member m, giving up after a few
+// seconds so that one unreachable member does not stall the ranking.
+func memberInfo(ctx context.Context, client *patroni.Client, m patroni.Member) (*patroni.NodeInfo, error) {
+	mc, err := client.ForMember(m)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	return mc.GetNodeInfo(ctx)
+}
@@ -0,0 +1,119 @@
+package failover
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/luckyjian/pgdba/internal/patroni"
+)
+
+// Defaults for WaitForSwitchover.
+const (
+	DefaultConvergeTimeout = 2 * time.Minute
+	DefaultPollInterval    = time.Second
+)
+
+// Convergence is the state of a cluster once a switchover has settled.
+// Downtime is the time without a writable leader: from the request (or the
+// last poll that still saw the old leader running) to the first poll that
+// saw the new one, so it is accurate to the poll interval.
+type Convergence struct {
+	Leader     string           `json:"leader"`
+	Timeline   int64            `json:"timeline"`
+	DowntimeMS int64            `json:"downtime_ms"`
+	ElapsedMS  int64            `json:"elapsed_ms"`
+	Members    []patroni.Member `json:"members"`
+}
+
+// WaitForSwitchover polls Patroni after a switchover from one member to
+// another was requested at requested, until to is the running leader on a
+// timeline above timeline (0 = not checked) and from has rejoined as a
+// healthy replica, or fails after timeout. interval is the poll interval.
+// Patroni before 3.0 reports replicas as running rather than streaming; such
+// a replica counts once it is on the new leader's timeline.
+func WaitForSwitchover(ctx context.Context, client *patroni.Client, from, to string, timeline int64,
+	requested time.Time, timeout, interval time.Duration) (*Convergence, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	downSince := requested
+	var upAt time.Time
+	var up patroni.Member // the leader as seen by the poll that set upAt
+	var lastErr error
+	for {
+		cs, err := client.GetClusterStatus(ctx)
+		now := time.Now()
+		if err != nil {
+			lastErr = err
+		} else {
+			leader, ok := runningLeader(cs)
+			switch {
+			case ok && leader.Name == from && upAt.IsZero():
+				downSince = now
+			case ok && leader.Name == to && (timeline == 0 || leader.Timeline == 0 || leader.Timeline > timeline):
+				if upAt.IsZero() {
+					upAt, up = now, leader
+				}
+			}
+
+			old, found := memberByName(cs, from)
+			switch {
+			case upAt.IsZero():
+				lastErr = fmt.Errorf("%s is not the running leader on a new timeline yet", to)
+			case !found:
+				lastErr = fmt.Errorf("%s has not rejoined the cluster", from)
+			case isLeader(old.Role) || !rejoined(old, up.Timeline):
+				lastErr = fmt.Errorf("%s has not rejoined as a healthy replica (role %s, state %s, timeline %d)",
+					from, old.Role, old.State, old.Timeline)
+			default:
+				return &Convergence{
+					Leader:     to,
+					Timeline:   up.Timeline,
+					DowntimeMS: upAt.Sub(downSince).Milliseconds(),
+					ElapsedMS:  now.Sub(requested).Milliseconds(),
+					Members:    cs.Members,
+				}, nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("switchover to %s did not converge within %s: %w", to, timeout, lastErr)
+		case <-time.After(interval):
+		}
+	}
+}
+
+// rejoined reports whether replica m follows a leader on timeline (0 = not
+// known): it streams, or it is running on that timeline.
+func rejoined(m patroni.Member, timeline int64) bool {
+	if m.State == patroni.StateStreaming {
+		return true
+	}
+	return isReplicaHealthy(m.State) && (timeline == 0 || m.Timeline == timeline)
+}
+
+// runningLeader returns the member of cs that is leader in a running state.
+func runningLeader(cs *patroni.ClusterStatus) (patroni.Member, bool) {
+	for _, m := range cs.Members {
+		if isLeader(m.Role) && m.State == patroni.StateRunning {
+			return m, true
+		}
+	}
+	return patroni.Member{}, false
+}
+
+// memberByName returns the member of cs called name.
+func memberByName(cs *patroni.ClusterStatus, name string) (patroni.Member, bool) {
+	for _, m := range cs.Members {
+		if m.Name == name {
+			return m, true
+		}
+	}
+	return patroni.Member{}, false
+}
+
+// isLeader reports whether a Patroni role string denotes the primary.
+func isLeader(role string) bool {
+	return role == "leader" || role == "master" || role == "primary"
+}
@@ -0,0 +1,80 @@
+package failover
+
+import (
+	"github.com/luckyjian/pgdba/internal/patroni"
+)
+
+// TimelineEntry is one past timeline of the cluster with the members that
+// still report it. Members on a past timeline have not followed a switch.
+type TimelineEntry struct {
+	Timeline  int64    `json:"timeline"`
+	LSN       string   `json:"lsn"` // where the timeline ended
+	Reason    string   `json:"reason"`
+	Timestamp string   `json:"timestamp,omitempty"`
+	NewLeader string   `json:"new_leader,omitempty"`
+	Members   []string `json:"members,omitempty"`
+}
+
+// StaleMember is a member reporting a timeline older than the cluster's.
+type StaleMember struct {
+	Name     string `json:"name"`
+	Role     string `json:"role"`
+	State    string `json:"state"`
+	Timeline int64  `json:"timeline"`
+	Behind   int64  `json:"behind"` // timelines behind the current one
+}
+
+// History is the timeline history of a cluster correlated with the
+// timelines its members report.
+type History struct {
+	CurrentTimeline int64           `json:"current_timeline"`
+	Entries         []TimelineEntry `json:"entries"`
+	Current         []string        `json:"current_members"`
+	Stale           []StaleMember   `json:"stale_members"`
+}
+
+// BuildHistory correlates Patroni's timeline history with the timelines
+// the members of cs report. The current timeline is the one after the last
+// switch, or the highest a member reports if that is newer. Members that
+// report no timeline (stopped, unreachable) are left out.
+func BuildHistory(history []patroni.HistoryEntry, cs *patroni.ClusterStatus) *History {
+	h := &History{Entries: []TimelineEntry{}, Current: []string{}, Stale: []StaleMember{}}
+	if n := len(history); n > 0 {
+		h.CurrentTimeline = history[n-1].Timeline + 1
+	}
+	for _, m := range cs.Members {
+		if m.Timeline > h.CurrentTimeline {
+			h.CurrentTimeline = m.Timeline
+		}
+	}
+
+	on := map[int64][]string{}
+	for _, m := range cs.Members {
+		if m.Timeline == 0 {
+			continue
+		}
+		if m.Timeline == h.CurrentTimeline {
+			h.Current = append(h.Current, m.Name)
+			continue
+		}
+		on[m.Timeline] = append(on[m.Timeline], m.Name)
+		h.Stale = append(h.Stale, StaleMember{
+			Name:     m.Name,
+			Role:     m.Role,
+			State:    string(m.State),
+			Timeline: m.Timeline,
+			Behind:   h.CurrentTimeline - m.Timeline,
+		})
+	}
+	for _, e := range history {
+		h.Entries = append(h.Entries, TimelineEntry{
+			Timeline:  e.Timeline,
+			LSN:       patroni.FormatLSN(e.LSN),
+			Reason:    e.Reason,
+			Timestamp: e.Timestamp,
+			NewLeader: e.NewLeader,
+			Members:   on[e.Timeline],
+		})
+	}
+	return h
+}
@@ -1,9 +1,10 @@
-// Package failover provides pre-flight validation logic for Patroni cluster
-// switchover and failover operations.
+// Package failover provides pre-flight validation, convergence checks and
+// timeline history for Patroni cluster switchover and failover operations.
 package failover
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/luckyjian/pgdba/internal/patroni"
 )
@@ -30,33 +31,37 @@ func isReplicaHealthy(state patroni.NodeState) bool {
 	return state == patroni.StateRunning || state == patroni.StateStreaming
 }
 
-// FindBestCandidate returns the healthy replica with the lowest replication lag.
-// Returns an error if no healthy replica is available.
+// FindBestCandidate returns the healthy replica ranked best by
+// RankCandidates, without lag thresholds: a synchronous standby first,
+// then by failover_priority tag and lowest replication lag. Members tagged
+// nofailover are skipped. Returns an error if no such replica is available.
 func FindBestCandidate(cs *patroni.ClusterStatus) (string, error) {
-	var best *patroni.Member
-	for i, m := range cs.Members {
-		if m.Role == "leader" || m.Role == "master" {
-			continue
-		}
-		if !isReplicaHealthy(m.State) {
+	return BestCandidate(RankCandidates(cs, nil, LagPolicy{}))
+}
+
+// CheckCandidate returns an error if name is not an eligible member of
+// candidates, as ranked by RankCandidates.
+func CheckCandidate(candidates []Candidate, name string) error {
+	for _, c := range candidates {
+		if c.Name != name {
 			continue
 		}
-		if best == nil || m.Lag < best.Lag {
-			best = &cs.Members[i]
+		if !c.Eligible {
+			return fmt.Errorf("candidate %q cannot be promoted: %s", name, strings.Join(c.Reasons, ", "))
 		}
+		return nil
 	}
-	if best == nil {
-		return "", fmt.Errorf("no running replica found for promotion")
-	}
-	return best.Name, nil
+	return fmt.Errorf("candidate %q is not a replica of the cluster", name)
 }
 
 // CheckSwitchover validates that a controlled switchover is safe to perform.
 // It checks:
 //  1. The cluster has a reachable primary.
 //  2. If a candidate is specified: it exists, is a running replica, and its lag
-//     is within maxLagBytes.
-//  3. At least one running replica is available (if no candidate specified).
+//     is within maxLagBytes. While synchronous mode is on, it must also be a
+//     synchronous standby.
+//  3. At least one running replica is available (if no candidate specified);
+//     a healthy synchronous standby, while synchronous mode is on.
 func CheckSwitchover(cs *patroni.ClusterStatus, candidate string, maxLagBytes int64) error {
 	// Cluster must have a primary.
 	if _, err := FindPrimary(cs); err != nil {
@@ -64,10 +69,23 @@ func CheckSwitchover(cs *patroni.ClusterStatus, candidate string, maxLagBytes in
 	}
 
 	if candidate != "" {
-		return validateCandidate(cs, candidate, maxLagBytes)
+		if err := validateCandidate(cs, candidate, maxLagBytes); err != nil {
+			return err
+		}
+		return checkSyncCandidate(cs, candidate)
 	}
 
-	// No candidate specified: ensure at least one healthy replica exists.
+	// No candidate specified: ensure at least one healthy replica exists,
+	// preferring the synchronous standbys when there are any.
+	if standbys := SyncStandbys(cs); len(standbys) > 0 {
+		for _, m := range cs.Members {
+			if IsSyncStandby(m.Role) && isReplicaHealthy(m.State) {
+				return nil
+			}
+		}
+		return fmt.Errorf("switchover pre-check: synchronous mode is on but no synchronous standby is running (%s)",
+			strings.Join(standbys, ", "))
+	}
 	for _, m := range cs.Members {
 		if m.Role != "leader" && m.Role != "master" && isReplicaHealthy(m.State) {
 			return nil
@@ -88,6 +106,9 @@ func validateCandidate(cs *patroni.ClusterStatus, candidate string, maxLagBytes
 		if !isReplicaHealthy(m.State) {
 			return fmt.Errorf("candidate %q is not healthy (state: %s)", candidate, m.State)
 		}
+		if tagSet(m.Tags["nofailover"]) {
+			return fmt.Errorf("candidate %q is tagged nofailover", candidate)
+		}
 		if m.Lag > maxLagBytes {
 			return fmt.Errorf("candidate %q replication lag %d bytes exceeds threshold %d bytes",
 				candidate, m.Lag, maxLagBytes)
@@ -0,0 +1,109 @@
+package failover
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/luckyjian/pgdba/internal/patroni"
+)
+
+// SyncConfig is the synchronous replication part of Patroni's dynamic
+// configuration. NodeCount is how many synchronous standbys Patroni keeps
+// (synchronous_node_count, default 1). Quorum is set for Patroni's quorum
+// mode (synchronous_mode: quorum), which also sets Mode.
+type SyncConfig struct {
+	Mode      bool `json:"synchronous_mode"`
+	Quorum    bool `json:"quorum,omitempty"`
+	Strict    bool `json:"synchronous_mode_strict"`
+	NodeCount int  `json:"synchronous_node_count"`
+}
+
+// ParseSyncConfig reads the synchronous replication settings from the
+// dynamic configuration cfg (GET /config).
+func ParseSyncConfig(cfg map[string]interface{}) SyncConfig {
+	s := SyncConfig{NodeCount: 1}
+	if mode, ok := cfg["synchronous_mode"].(string); ok && strings.EqualFold(mode, "quorum") {
+		s.Mode, s.Quorum = true, true
+	} else {
+		s.Mode = tagSet(cfg["synchronous_mode"])
+	}
+	s.Strict = tagSet(cfg["synchronous_mode_strict"])
+	if n, ok := patroni.ConfigNumber(cfg["synchronous_node_count"]); ok && n > 0 {
+		s.NodeCount = int(n)
+	}
+	return s
+}
+
+// Patch returns the dynamic configuration patch that applies s.
+func (s SyncConfig) Patch() map[string]interface{} {
+	var mode interface{} = s.Mode
+	if s.Mode && s.Quorum {
+		mode = "quorum"
+	}
+	return map[string]interface{}{
+		"synchronous_mode":        mode,
+		"synchronous_mode_strict": s.Strict,
+		"synchronous_node_count":  s.NodeCount,
+	}
+}
+
+// SyncMember is a member's place in synchronous replication: "leader",
+// "sync_standby" (commits wait for it), "quorum_standby" (commits wait for
+// a quorum of them) or "async".
+type SyncMember struct {
+	Name     string            `json:"name"`
+	Role     string            `json:"role"`
+	State    patroni.NodeState `json:"state"`
+	LagBytes int64             `json:"lag_bytes"`
+}
+
+// SyncMembers classifies the members of cs by the roles Patroni reports.
+func SyncMembers(cs *patroni.ClusterStatus) []SyncMember {
+	members := make([]SyncMember, 0, len(cs.Members))
+	for _, m := range cs.Members {
+		role := "async"
+		switch {
+		case isLeader(m.Role):
+			role = "leader"
+		case IsSyncStandby(m.Role):
+			role = m.Role
+		}
+		members = append(members, SyncMember{Name: m.Name, Role: role, State: m.State, LagBytes: m.Lag})
+	}
+	return members
+}
+
+// IsSyncStandby reports whether a Patroni role denotes a synchronous
+// standby: sync_standby, or quorum_standby in quorum mode.
+func IsSyncStandby(role string) bool {
+	return role == "sync_standby" || role == "quorum_standby"
+}
+
+// SyncStandbys returns the names of the synchronous standbys of cs. Patroni
+// only reports them while synchronous mode is on.
+func SyncStandbys(cs *patroni.ClusterStatus) []string {
+	var names []string
+	for _, m := range cs.Members {
+		if IsSyncStandby(m.Role) {
+			names = append(names, m.Name)
+		}
+	}
+	return names
+}
+
+// checkSyncCandidate refuses an asynchronous candidate while the cluster
+// has synchronous standbys: promoting it could lose committed transactions,
+// and Patroni refuses it in synchronous mode anyway.
+func checkSyncCandidate(cs *patroni.ClusterStatus, candidate string) error {
+	standbys := SyncStandbys(cs)
+	if len(standbys) == 0 {
+		return nil
+	}
+	for _, name := range standbys {
+		if name == candidate {
+			return nil
+		}
+	}
+	return fmt.Errorf("synchronous mode is on and candidate %q is not a synchronous standby (sync standbys: %s)",
+		candidate, strings.Join(standbys, ", "))
+}
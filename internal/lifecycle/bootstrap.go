@@ -0,0 +1,407 @@
+// Package lifecycle orchestrates creating and tearing down pgdba-managed
+// Patroni clusters through a provider.Provider.
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/luckyjian/pgdba/internal/cluster"
+	"github.com/luckyjian/pgdba/internal/patroni"
+	"github.com/luckyjian/pgdba/internal/provider"
+)
+
+// Ports and paths shared by every node pgdba provisions.
+const (
+	EtcdMembers        = 3
+	EtcdClientPort     = 2379
+	EtcdPeerPort       = 2380
+	PatroniAPIPort     = 8008
+	PgBouncerPort      = 6432
+	DefaultDataDir     = "/var/lib/postgresql/data"
+	DefaultEtcdDataDir = "/var/lib/etcd"
+	PatroniConfigPath  = "/etc/patroni/patroni.yml"
+	SpiloConfigPath    = "/home/postgres/postgres.yml" // generated by spilo from the node env
+	EtcdConfigPath     = "/etc/etcd/etcd.yml"
+	PgBouncerIniPath   = "/etc/pgbouncer/pgbouncer.ini"
+	PgBouncerUsersPath = "/etc/pgbouncer/userlist.txt"
+	PgBouncerImage     = "edoburu/pgbouncer:v1.25.1-p0"
+
+	defaultLeaderTimeout = 5 * time.Minute
+	defaultPollInterval  = 2 * time.Second
+)
+
+// Step names reported in StepEvent.Step, in execution order.
+const (
+	StepEtcd      = "etcd"
+	StepPrimary   = "primary"
+	StepLeader    = "leader"
+	StepReplica   = "replica"
+	StepPgBouncer = "pgbouncer"
+	StepRegister  = "register"
+)
+
+// Step statuses reported in StepEvent.Status.
+const (
+	StatusStarted  = "started"
+	StatusDone     = "done"
+	StatusFailed   = "failed"
+	StatusProgress = "progress" // an intermediate state of a running step
+)
+
+// StepEvent is a structured progress record emitted while a lifecycle
+// operation runs. Agents can follow along by consuming them as NDJSON.
+type StepEvent struct {
+	Step    string    `json:"step"`
+	Node    string    `json:"node,omitempty"`
+	Status  string    `json:"status"`
+	Message string    `json:"message,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// Passwords holds the cluster credentials. They are read from the environment
+// by PasswordsFromEnv and only ever written into rendered node config files.
+type Passwords struct {
+	Superuser   string
+	Replication string
+	Rewind      string
+}
+
+// PasswordsFromEnv reads PGDBA_PG_PASSWORD, PGDBA_REPLICATION_PASSWORD and
+// PGDBA_REWIND_PASSWORD. The rewind password defaults to the replication
+// password; the other two are required.
+func PasswordsFromEnv() (Passwords, error) {
+	pw := Passwords{
+		Superuser:   os.Getenv("PGDBA_PG_PASSWORD"),
+		Replication: os.Getenv("PGDBA_REPLICATION_PASSWORD"),
+		Rewind:      os.Getenv("PGDBA_REWIND_PASSWORD"),
+	}
+	if pw.Superuser == "" {
+		return pw, fmt.Errorf("PGDBA_PG_PASSWORD must be set to bootstrap a cluster")
+	}
+	if pw.Replication == "" {
+		return pw, fmt.Errorf("PGDBA_REPLICATION_PASSWORD must be set to bootstrap a cluster")
+	}
+	if pw.Rewind == "" {
+		pw.Rewind = pw.Replication
+	}
+	return pw, nil
+}
+
+// BootstrapOptions describes the cluster to create.
+type BootstrapOptions struct {
+	ClusterName  string
+	PrimaryHost  string   // address the primary advertises to peers
+	Replicas     int      // number of replicas besides the primary
+	ReplicaHosts []string // advertised replica addresses; missing entries default to the node name
+	EtcdHosts    []string // advertised etcd addresses; missing entries default to the node name
+	PGPort       int
+	DataDir      string
+	PgBouncer    bool
+	Passwords    Passwords
+
+	// PGVersion is the PostgreSQL major version of the Patroni members
+	// (default patroni.DefaultPGVersion); Image, if set, overrides the
+	// provider's default image for them.
+	PGVersion int
+	Image     string
+
+	// PatroniPort is the port pgdba uses to reach the primary's REST API
+	// when waiting for a leader (default 8008).
+	PatroniPort   int
+	LeaderTimeout time.Duration
+	PollInterval  time.Duration
+
+	// OnEvent, if set, is called synchronously for every step event.
+	OnEvent func(StepEvent)
+}
+
+// BootstrapResult summarises a successful (or partially successful) bootstrap.
+type BootstrapResult struct {
+	Cluster    string                `json:"cluster"`
+	Provider   string                `json:"provider"`
+	Leader     string                `json:"leader,omitempty"`
+	PatroniURL string                `json:"patroni_url,omitempty"`
+	Nodes      []provider.NodeStatus `json:"nodes"`
+	Events     []StepEvent           `json:"events"`
+}
+
+// EtcdNodeName returns the node name of the i-th etcd member (0-based).
+func EtcdNodeName(clusterName string, i int) string {
+	return fmt.Sprintf("%s-etcd-%d", clusterName, i)
+}
+
+// PGNodeName returns the node name of the i-th Patroni member (0-based);
+// ordinal 0 is bootstrapped as the primary.
+func PGNodeName(clusterName string, i int) string {
+	return fmt.Sprintf("%s-pg-%d", clusterName, i)
+}
+
+// PgBouncerNodeName returns the node name of the cluster's PgBouncer.
+func PgBouncerNodeName(clusterName string) string {
+	return clusterName + "-pgbouncer-0"
+}
+
+// recorder collects step events and forwards them to an optional callback.
+type recorder struct {
+	events  *[]StepEvent
+	onEvent func(StepEvent)
+}
+
+func (r recorder) emit(step, node, status, message string) {
+	ev := StepEvent{Step: step, Node: node, Status: status, Message: message, Time: time.Now().UTC()}
+	*r.events = append(*r.events, ev)
+	if r.onEvent != nil {
+		r.onEvent(ev)
+	}
+}
+
+// fail records a failed step and returns err wrapped with the step name.
+func (r recorder) fail(step, node string, err error) error {
+	r.emit(step, node, StatusFailed, err.Error())
+	if node != "" {
+		return fmt.Errorf("%s %s: %w", step, node, err)
+	}
+	return fmt.Errorf("%s: %w", step, err)
+}
+
+// bootstrap carries the state of one Bootstrap call.
+type bootstrap struct {
+	recorder
+	p      provider.Provider
+	opts   BootstrapOptions
+	result *BootstrapResult
+}
+
+// Bootstrap creates a new managed cluster through p: an etcd quorum, a
+// primary, opts.Replicas replicas and optionally a PgBouncer. It waits for
+// Patroni to elect a leader before adding replicas, and registers the cluster
+// in reg as SourceManaged once every node is up. Nodes created before a
+// failure are left in place so they can be inspected or destroyed.
+func Bootstrap(ctx context.Context, p provider.Provider, reg *cluster.Registry, opts BootstrapOptions) (*BootstrapResult, error) {
+	if opts.ClusterName == "" {
+		return nil, fmt.Errorf("cluster name is required")
+	}
+	if opts.PrimaryHost == "" {
+		return nil, fmt.Errorf("primary host is required")
+	}
+	if opts.Replicas < 0 {
+		return nil, fmt.Errorf("replica count must not be negative")
+	}
+	if opts.PGPort == 0 {
+		opts.PGPort = 5432
+	}
+	if opts.DataDir == "" {
+		opts.DataDir = DefaultDataDir
+	}
+	if opts.PGVersion == 0 {
+		opts.PGVersion = patroni.DefaultPGVersion
+	}
+	if opts.PatroniPort == 0 {
+		opts.PatroniPort = PatroniAPIPort
+	}
+	if opts.LeaderTimeout == 0 {
+		opts.LeaderTimeout = defaultLeaderTimeout
+	}
+	if opts.PollInterval == 0 {
+		opts.PollInterval = defaultPollInterval
+	}
+	if _, err := reg.Get(opts.ClusterName); err == nil {
+		return nil, fmt.Errorf("cluster %q already exists in registry", opts.ClusterName)
+	}
+
+	b := &bootstrap{p: p, opts: opts, result: &BootstrapResult{
+		Cluster:  opts.ClusterName,
+		Provider: p.Type(),
+		Nodes:    []provider.NodeStatus{},
+		Events:   []StepEvent{},
+	}}
+	b.recorder = recorder{events: &b.result.Events, onEvent: opts.OnEvent}
+
+	etcdHosts, err := b.createEtcd(ctx)
+	if err != nil {
+		return b.result, err
+	}
+
+	primary, err := b.createPatroni(ctx, 0, opts.PrimaryHost, provider.RolePrimary, StepPrimary, etcdHosts)
+	if err != nil {
+		return b.result, err
+	}
+	apiHost := primary.Host
+	if apiHost == "" {
+		apiHost = opts.PrimaryHost
+	}
+	b.result.PatroniURL = fmt.Sprintf("http://%s:%d", apiHost, opts.PatroniPort)
+
+	b.emit(StepLeader, "", StatusStarted, "waiting for Patroni to elect a leader at "+b.result.PatroniURL)
+	leader, err := WaitForLeader(ctx, patroni.NewClient(b.result.PatroniURL), opts.LeaderTimeout, opts.PollInterval)
+	if err != nil {
+		return b.result, b.fail(StepLeader, "", err)
+	}
+	b.result.Leader = leader
+	b.emit(StepLeader, leader, StatusDone, "")
+
+	for i := 1; i <= opts.Replicas; i++ {
+		host := hostAt(opts.ReplicaHosts, i-1, PGNodeName(opts.ClusterName, i))
+		if _, err := b.createPatroni(ctx, i, host, provider.RoleStandby, StepReplica, etcdHosts); err != nil {
+			return b.result, err
+		}
+	}
+
+	if opts.PgBouncer {
+		if err := b.createPgBouncer(ctx); err != nil {
+			return b.result, err
+		}
+	}
+
+	b.emit(StepRegister, "", StatusStarted, "")
+	entry := cluster.Entry{
+		Name:       opts.ClusterName,
+		PatroniURL: b.result.PatroniURL,
+		PGHost:     apiHost,
+		PGPort:     opts.PGPort,
+		Provider:   p.Type(),
+		Source:     cluster.SourceManaged,
+		CreatedAt:  time.Now().UTC(),
+		Labels:     map[string]string{provider.LabelCluster: opts.ClusterName},
+
+		EtcdEndpoints: etcdHosts,
+		DataDir:       opts.DataDir,
+		PGVersion:     opts.PGVersion,
+		Image:         opts.Image,
+	}
+	if err := reg.Add(entry); err != nil {
+		return b.result, b.fail(StepRegister, "", fmt.Errorf("write registry: %w", err))
+	}
+	b.emit(StepRegister, "", StatusDone, "")
+	return b.result, nil
+}
+
+// createNode provisions one node and records its status.
+func (b *bootstrap) createNode(ctx context.Context, step string, cfg provider.NodeConfig) (provider.NodeStatus, error) {
+	b.emit(step, cfg.Name, StatusStarted, "")
+	if cfg.Labels == nil {
+		cfg.Labels = map[string]string{}
+	}
+	cfg.Labels[provider.LabelCluster] = b.opts.ClusterName
+	st, err := b.p.CreateNode(ctx, cfg)
+	if err != nil {
+		return st, b.fail(step, cfg.Name, err)
+	}
+	b.result.Nodes = append(b.result.Nodes, st)
+	b.emit(step, cfg.Name, StatusDone, "")
+	return st, nil
+}
+
+// createEtcd provisions the etcd quorum and returns the client endpoints
+// ("host:2379") Patroni should use.
+func (b *bootstrap) createEtcd(ctx context.Context) ([]string, error) {
+	name := b.opts.ClusterName
+	hosts := make([]string, EtcdMembers)
+	peers := make([]string, EtcdMembers)
+	clients := make([]string, EtcdMembers)
+	for i := range hosts {
+		hosts[i] = hostAt(b.opts.EtcdHosts, i, EtcdNodeName(name, i))
+		peers[i] = fmt.Sprintf("%s=http://%s:%d", EtcdNodeName(name, i), hosts[i], EtcdPeerPort)
+		clients[i] = fmt.Sprintf("%s:%d", hosts[i], EtcdClientPort)
+	}
+
+	for i, host := range hosts {
+		node := EtcdNodeName(name, i)
+		rendered, err := patroni.RenderEtcdConfig(patroni.EtcdConfig{
+			NodeName:       node,
+			Host:           host,
+			DataDir:        DefaultEtcdDataDir,
+			ClusterName:    name,
+			InitialCluster: strings.Join(peers, ","),
+		})
+		if err != nil {
+			return nil, b.fail(StepEtcd, node, err)
+		}
+		if _, err := b.createNode(ctx, StepEtcd, provider.NodeConfig{
+			Name:    node,
+			Role:    provider.RoleEtcd,
+			Host:    host,
+			DataDir: DefaultEtcdDataDir,
+			Port:    EtcdClientPort,
+			Env:     map[string]string{"ETCD_CONFIG_FILE": EtcdConfigPath},
+			Files:   map[string]string{EtcdConfigPath: rendered},
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return clients, nil
+}
+
+// createPatroni provisions the i-th Patroni member.
+func (b *bootstrap) createPatroni(ctx context.Context, i int, host string, role provider.NodeRole, step string, etcdHosts []string) (provider.NodeStatus, error) {
+	node := PGNodeName(b.opts.ClusterName, i)
+	cfg, err := PatroniNode(b.template(etcdHosts), node, host, role)
+	if err != nil {
+		return provider.NodeStatus{}, b.fail(step, node, err)
+	}
+	return b.createNode(ctx, step, cfg)
+}
+
+// template returns the member template of the cluster being bootstrapped.
+func (b *bootstrap) template(etcdHosts []string) MemberTemplate {
+	return MemberTemplate{
+		ClusterName: b.opts.ClusterName,
+		EtcdHosts:   etcdHosts,
+		PGPort:      b.opts.PGPort,
+		DataDir:     b.opts.DataDir,
+		PGVersion:   b.opts.PGVersion,
+		Image:       b.opts.Image,
+		Passwords:   b.opts.Passwords,
+	}
+}
+
+// createPgBouncer provisions a PgBouncer pointing at the primary.
+func (b *bootstrap) createPgBouncer(ctx context.Context) error {
+	_, err := b.createNode(ctx, StepPgBouncer,
+		PgBouncerNode(b.template(nil), b.opts.PrimaryHost, DefaultPgBouncerSettings()))
+	return err
+}
+
+// WaitForLeader polls Patroni until a member reports the leader role in a
+// running state, returning its name, or fails after timeout.
+func WaitForLeader(ctx context.Context, client *patroni.Client, timeout, interval time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var lastErr error
+	for {
+		cs, err := client.GetClusterStatus(ctx)
+		if err == nil {
+			for _, m := range cs.Members {
+				if isLeaderRole(m.Role) && m.State == patroni.StateRunning {
+					return m.Name, nil
+				}
+			}
+			lastErr = fmt.Errorf("no running leader among %d members", len(cs.Members))
+		} else {
+			lastErr = err
+		}
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("no leader elected within %s: %w", timeout, lastErr)
+		case <-time.After(interval):
+		}
+	}
+}
+
+// isLeaderRole reports whether a Patroni role string denotes the primary.
+func isLeaderRole(role string) bool {
+	return role == "leader" || role == "master" || role == "primary"
+}
+
+// hostAt returns hosts[i] when present, otherwise fallback.
+func hostAt(hosts []string, i int, fallback string) string {
+	if i < len(hosts) && hosts[i] != "" {
+		return hosts[i]
+	}
+	return fallback
+}
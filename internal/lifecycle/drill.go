@@ -0,0 +1,529 @@
+package lifecycle
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/luckyjian/pgdba/internal/cluster"
+	"github.com/luckyjian/pgdba/internal/dcs"
+	"github.com/luckyjian/pgdba/internal/failover"
+	"github.com/luckyjian/pgdba/internal/patroni"
+	"github.com/luckyjian/pgdba/internal/provider"
+)
+
+// Drill scenarios.
+const (
+	ScenarioPrimaryPartition = "primary-partition"
+	ScenarioReplicaPartition = "replica-partition"
+	ScenarioEtcdMinority     = "etcd-minority"
+)
+
+// Drill step names reported in StepEvent.Step.
+const (
+	StepPrecheck = "precheck"
+	StepIsolate  = "isolate"
+	StepObserve  = "observe"
+	StepHeal     = "heal"
+	StepConverge = "converge"
+)
+
+const (
+	defaultDrillTimeout = 5 * time.Minute
+	defaultDrillObserve = time.Minute
+	drillProbeTimeout   = 5 * time.Second
+)
+
+// DrillOptions controls a failover drill.
+type DrillOptions struct {
+	Scenario string
+
+	// Targets overrides the nodes to isolate for replica-partition (default:
+	// the first replica by name) and etcd-minority (default: the first
+	// minority of the cluster's etcd nodes by name).
+	Targets []string
+
+	// Observe is how long replica-partition and etcd-minority watch the
+	// leader stay in place before healing (default 1m, above Patroni's
+	// default ttl).
+	Observe      time.Duration
+	Timeout      time.Duration // for the new leader and for convergence
+	PollInterval time.Duration
+
+	// OnEvent, if set, is called synchronously for every step event.
+	OnEvent func(StepEvent)
+}
+
+// DrillReport is the outcome of a failover drill. RTO is the time without a
+// writable leader; RPO is the WAL the old primary had written (sampled just
+// before the partition) beyond the point the new timeline starts from, nil
+// when it could not be measured. Passed is set when the cluster behaved as
+// the scenario expects and converged after healing; Findings says why not.
+type DrillReport struct {
+	Cluster          string            `json:"cluster"`
+	Scenario         string            `json:"scenario"`
+	Targets          []string          `json:"targets"`
+	StartedAt        time.Time         `json:"started_at"`
+	FinishedAt       time.Time         `json:"finished_at"`
+	Leader           string            `json:"leader"`
+	NewLeader        string            `json:"new_leader"`
+	FailoverExpected bool              `json:"failover_expected"`
+	FailedOver       bool              `json:"failed_over"`
+	RTOMS            int64             `json:"rto_ms"`
+	RPOBytes         *int64            `json:"rpo_bytes"`
+	TimelineBefore   int64             `json:"timeline_before"`
+	TimelineAfter    int64             `json:"timeline_after"`
+	Converged        bool              `json:"converged"`
+	Passed           bool              `json:"passed"`
+	Findings         []string          `json:"findings"`
+	Members          []patroni.Member  `json:"members"`
+	Etcd             *dcs.QuorumStatus `json:"etcd,omitempty"`
+	Events           []StepEvent       `json:"events"`
+}
+
+// DrillReportPath returns the file in dir the report of a drill on
+// clusterName started at started is kept in.
+func DrillReportPath(dir, clusterName, scenario string, started time.Time) string {
+	return filepath.Join(dir, "drills", fmt.Sprintf("%s-%s-%s.json",
+		clusterName, scenario, started.UTC().Format("20060102T150405Z")))
+}
+
+// SaveDrillReport writes report to path.
+func SaveDrillReport(path string, report *DrillReport) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("create drill report dir: %w", err)
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal drill report: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("write drill report %s: %w", path, err)
+	}
+	return nil
+}
+
+// Drill injects a network partition into the cluster entry through p and
+// watches Patroni react. primary-partition isolates the leader and expects
+// another member to take over; replica-partition and etcd-minority isolate
+// a replica or a minority of etcd and expect the leader to stay put. The
+// partition is always healed, then Drill waits for every member to stream
+// from the leader again. The returned error is set when the drill could
+// not be run; a drill that ran but did not pass has a report with Findings.
+func Drill(ctx context.Context, p provider.Provider, client *patroni.Client, entry *cluster.Entry, opts DrillOptions) (*DrillReport, error) {
+	if opts.Timeout == 0 {
+		opts.Timeout = defaultDrillTimeout
+	}
+	if opts.Observe == 0 {
+		opts.Observe = defaultDrillObserve
+	}
+	if opts.PollInterval == 0 {
+		opts.PollInterval = defaultPollInterval
+	}
+
+	report := &DrillReport{Cluster: entry.Name, Scenario: opts.Scenario, StartedAt: time.Now().UTC(),
+		FailoverExpected: opts.Scenario == ScenarioPrimaryPartition,
+		Findings:         []string{}, Members: []patroni.Member{}, Events: []StepEvent{}}
+	rec := recorder{events: &report.Events, onEvent: opts.OnEvent}
+	d := &drill{p: p, client: client, entry: entry, opts: opts, report: report, rec: rec}
+
+	if err := d.precheck(ctx); err != nil {
+		return report, rec.fail(StepPrecheck, "", err)
+	}
+	if err := d.isolate(ctx); err != nil {
+		d.heal()
+		return report, err
+	}
+	d.observe(ctx)
+	if err := d.heal(); err != nil {
+		return report, err
+	}
+	d.converge(ctx)
+
+	report.Passed = len(report.Findings) == 0
+	report.FinishedAt = time.Now().UTC()
+	return report, nil
+}
+
+// drill carries the state of one Drill call.
+type drill struct {
+	p      provider.Provider
+	client *patroni.Client
+	entry  *cluster.Entry
+	opts   DrillOptions
+	report *DrillReport
+	rec    recorder
+
+	leader     patroni.Member
+	replicas   []string
+	observers  map[string]*patroni.Client // members outside the partition
+	lsnBefore  int64
+	isolatedAt time.Time
+	isolated   []string
+}
+
+// precheck requires a running leader with every replica healthy (streaming,
+// or running on Patroni before 3.0), and picks the nodes to isolate.
+func (d *drill) precheck(ctx context.Context) error {
+	d.rec.emit(StepPrecheck, "", StatusStarted, "")
+	cs, err := d.client.GetClusterStatus(ctx)
+	if err != nil {
+		return fmt.Errorf("get cluster status: %w", err)
+	}
+	leader, ok := runningLeaderOf(cs)
+	if !ok {
+		return fmt.Errorf("cluster has no running leader")
+	}
+	d.leader = leader
+	d.report.Leader, d.report.TimelineBefore = leader.Name, leader.Timeline
+	for _, m := range failover.ListReplicas(cs) {
+		if !streamingOrRunning(m.State) {
+			return fmt.Errorf("replica %s is %s; the cluster must be healthy before a drill", m.Name, m.State)
+		}
+		d.replicas = append(d.replicas, m.Name)
+	}
+	sort.Strings(d.replicas)
+	if len(d.replicas) == 0 {
+		return fmt.Errorf("cluster has no replica")
+	}
+
+	switch d.opts.Scenario {
+	case ScenarioPrimaryPartition:
+		if len(d.opts.Targets) > 0 && (len(d.opts.Targets) != 1 || d.opts.Targets[0] != leader.Name) {
+			return fmt.Errorf("%s isolates the leader %s; other targets cannot be chosen", d.opts.Scenario, leader.Name)
+		}
+		d.report.Targets = []string{leader.Name}
+		lc, err := d.client.ForMember(leader)
+		if err != nil {
+			return err
+		}
+		info, err := lc.GetNodeInfo(ctx)
+		if err != nil {
+			return fmt.Errorf("read leader LSN: %w", err)
+		}
+		d.lsnBefore = info.Xlog.Location
+	case ScenarioReplicaPartition:
+		d.report.Targets = d.opts.Targets
+		if len(d.report.Targets) == 0 {
+			d.report.Targets = d.replicas[:1]
+		}
+		for _, t := range d.report.Targets {
+			if t == leader.Name {
+				return fmt.Errorf("%s is the leader; use %s to isolate it", t, ScenarioPrimaryPartition)
+			}
+		}
+	case ScenarioEtcdMinority:
+		if d.report.Targets, err = d.etcdMinority(ctx); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown drill scenario %q: must be %s, %s or %s", d.opts.Scenario,
+			ScenarioPrimaryPartition, ScenarioReplicaPartition, ScenarioEtcdMinority)
+	}
+
+	isolated := map[string]bool{}
+	for _, t := range d.report.Targets {
+		isolated[t] = true
+	}
+	d.observers = map[string]*patroni.Client{}
+	for _, m := range cs.Members {
+		if isolated[m.Name] {
+			continue
+		}
+		if mc, err := d.client.ForMember(m); err == nil {
+			d.observers[m.Name] = mc
+		}
+	}
+	if len(d.observers) == 0 {
+		return fmt.Errorf("no member outside the partition has a reachable api_url")
+	}
+	d.rec.emit(StepPrecheck, "", StatusDone, fmt.Sprintf("leader %s on timeline %d", leader.Name, leader.Timeline))
+	return nil
+}
+
+// etcdMinority returns the etcd nodes to isolate: opts.Targets, which must
+// be distinct etcd nodes of the cluster, or the first minority of the
+// cluster's etcd nodes by name.
+func (d *drill) etcdMinority(ctx context.Context) ([]string, error) {
+	nodes, err := d.p.ListNodes(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list nodes: %w", err)
+	}
+	var etcd []string
+	for _, n := range nodes {
+		if n.Role == provider.RoleEtcd && n.Labels[provider.LabelCluster] == d.entry.Name {
+			etcd = append(etcd, n.ID)
+		}
+	}
+	sort.Strings(etcd)
+	minority := (len(etcd) - 1) / 2
+	if minority == 0 {
+		return nil, fmt.Errorf("cluster %q has %d etcd nodes; a minority can only be isolated from 3 or more", d.entry.Name, len(etcd))
+	}
+	if len(d.opts.Targets) == 0 {
+		return etcd[:minority], nil
+	}
+	known := map[string]bool{}
+	for _, id := range etcd {
+		known[id] = true
+	}
+	seen := map[string]bool{}
+	for _, t := range d.opts.Targets {
+		switch {
+		case !known[t]:
+			return nil, fmt.Errorf("%s is not an etcd node of cluster %q (etcd nodes: %s)",
+				t, d.entry.Name, strings.Join(etcd, ", "))
+		case seen[t]:
+			return nil, fmt.Errorf("target %s is listed twice", t)
+		}
+		seen[t] = true
+	}
+	if len(d.opts.Targets) > minority {
+		return nil, fmt.Errorf("isolating %d of %d etcd nodes would lose quorum", len(d.opts.Targets), len(etcd))
+	}
+	return d.opts.Targets, nil
+}
+
+// isolate partitions every target.
+func (d *drill) isolate(ctx context.Context) error {
+	d.isolatedAt = time.Now()
+	for _, t := range d.report.Targets {
+		d.rec.emit(StepIsolate, t, StatusStarted, "")
+		if err := d.p.PartitionNode(ctx, t, true); err != nil {
+			return d.rec.fail(StepIsolate, t, err)
+		}
+		d.isolated = append(d.isolated, t)
+		d.rec.emit(StepIsolate, t, StatusDone, "")
+	}
+	return nil
+}
+
+// heal reconnects every isolated node, even when ctx is done.
+func (d *drill) heal() error {
+	var firstErr error
+	for _, t := range d.isolated {
+		d.rec.emit(StepHeal, t, StatusStarted, "")
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		err := d.p.PartitionNode(ctx, t, false)
+		cancel()
+		if err != nil {
+			err = d.rec.fail(StepHeal, t, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		d.rec.emit(StepHeal, t, StatusDone, "")
+	}
+	d.isolated = nil
+	return firstErr
+}
+
+// status returns the cluster status as seen by the first member outside
+// the partition that answers.
+func (d *drill) status(ctx context.Context) (*patroni.ClusterStatus, error) {
+	names := make([]string, 0, len(d.observers))
+	for name := range d.observers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var lastErr error
+	for _, name := range names {
+		pctx, cancel := context.WithTimeout(ctx, drillProbeTimeout)
+		cs, err := d.observers[name].GetClusterStatus(pctx)
+		cancel()
+		if err == nil {
+			return cs, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// observe watches the leader while the partition is in place.
+func (d *drill) observe(ctx context.Context) {
+	if d.opts.Scenario == ScenarioPrimaryPartition {
+		d.awaitNewLeader(ctx)
+		return
+	}
+
+	d.rec.emit(StepObserve, "", StatusStarted, fmt.Sprintf("expecting %s to stay leader for %s", d.leader.Name, d.opts.Observe))
+	deadline := time.Now().Add(d.opts.Observe)
+	var downSince time.Time
+	var down time.Duration
+	for time.Now().Before(deadline) {
+		if cs, err := d.status(ctx); err == nil {
+			now := time.Now()
+			leader, ok := runningLeaderOf(cs)
+			switch {
+			case ok && downSince.IsZero():
+			case ok:
+				down += now.Sub(downSince)
+				downSince = time.Time{}
+			case downSince.IsZero():
+				downSince = now
+			}
+			if ok && leader.Name != d.leader.Name && !d.report.FailedOver {
+				d.report.FailedOver = true
+				d.report.Findings = append(d.report.Findings,
+					fmt.Sprintf("leadership moved from %s to %s although the leader was not isolated", d.leader.Name, leader.Name))
+			}
+		}
+		if !sleepCtx(ctx, d.opts.PollInterval) {
+			break
+		}
+	}
+	if !downSince.IsZero() {
+		down += time.Since(downSince)
+	}
+	d.report.RTOMS = down.Milliseconds()
+	if down > 0 {
+		d.report.Findings = append(d.report.Findings,
+			fmt.Sprintf("no writable leader for %s although the leader was not isolated", down.Round(time.Millisecond)))
+	}
+	d.rec.emit(StepObserve, "", StatusDone, "")
+}
+
+// awaitNewLeader waits for a member outside the partition to become the
+// running leader, then measures RTO and RPO.
+func (d *drill) awaitNewLeader(ctx context.Context) {
+	d.rec.emit(StepObserve, "", StatusStarted, "waiting for a new leader")
+	wctx, cancel := context.WithTimeout(ctx, d.opts.Timeout)
+	defer cancel()
+	for {
+		if cs, err := d.status(wctx); err == nil {
+			if leader, ok := runningLeaderOf(cs); ok && leader.Name != d.leader.Name {
+				d.report.RTOMS = time.Since(d.isolatedAt).Milliseconds()
+				d.report.FailedOver = true
+				d.report.NewLeader = leader.Name
+				d.rec.emit(StepObserve, leader.Name, StatusDone, leader.Name+" is the new leader")
+				d.measureRPO(wctx, leader.Name)
+				return
+			}
+		}
+		if !sleepCtx(wctx, d.opts.PollInterval) {
+			d.report.RTOMS = time.Since(d.isolatedAt).Milliseconds()
+			msg := fmt.Sprintf("no new leader within %s of isolating %s", d.opts.Timeout, d.leader.Name)
+			d.report.Findings = append(d.report.Findings, msg)
+			d.rec.emit(StepObserve, "", StatusFailed, msg)
+			return
+		}
+	}
+}
+
+// measureRPO compares the old leader's LSN with where the new leader's
+// timeline history says the old timeline ended.
+func (d *drill) measureRPO(ctx context.Context, newLeader string) {
+	mc, ok := d.observers[newLeader]
+	if !ok {
+		return
+	}
+	history, err := mc.GetHistory(ctx)
+	if err != nil {
+		d.rec.emit(StepObserve, newLeader, StatusDone, "RPO not measured: "+err.Error())
+		return
+	}
+	for _, h := range history {
+		if h.Timeline == d.report.TimelineBefore {
+			rpo := d.lsnBefore - h.LSN
+			if rpo < 0 {
+				rpo = 0
+			}
+			d.report.RPOBytes = &rpo
+			return
+		}
+	}
+	d.rec.emit(StepObserve, newLeader, StatusDone,
+		fmt.Sprintf("RPO not measured: no history entry for timeline %d", d.report.TimelineBefore))
+}
+
+// converge waits for the cluster to settle after healing: every member a
+// healthy replica of the leader (the old leader rejoining as a replica after a
+// failover), and etcd fully healthy after etcd-minority.
+func (d *drill) converge(ctx context.Context) {
+	d.rec.emit(StepConverge, "", StatusStarted, "")
+	var err error
+	if d.report.FailedOver && d.opts.Scenario == ScenarioPrimaryPartition {
+		var conv *failover.Convergence
+		conv, err = failover.WaitForSwitchover(ctx, d.observers[d.report.NewLeader], d.leader.Name,
+			d.report.NewLeader, d.report.TimelineBefore, d.isolatedAt, d.opts.Timeout, d.opts.PollInterval)
+		if err == nil {
+			d.report.Members, d.report.TimelineAfter = conv.Members, conv.Timeline
+		}
+	} else {
+		if err = waitForState(ctx, d.client, d.replicas, streamingOrRunning, "replicating",
+			d.opts.Timeout, d.opts.PollInterval); err == nil {
+			err = d.finalTopology(ctx)
+		}
+	}
+	if err == nil && d.opts.Scenario == ScenarioEtcdMinority && len(d.entry.EtcdEndpoints) > 0 {
+		err = d.awaitEtcd(ctx)
+	}
+	if err != nil {
+		d.report.Findings = append(d.report.Findings, "cluster did not converge after healing: "+err.Error())
+		d.rec.emit(StepConverge, "", StatusFailed, err.Error())
+		return
+	}
+	d.report.Converged = true
+	d.rec.emit(StepConverge, "", StatusDone, "")
+}
+
+// finalTopology records the members, leader and timeline after healing.
+func (d *drill) finalTopology(ctx context.Context) error {
+	cs, err := d.client.GetClusterStatus(ctx)
+	if err != nil {
+		return fmt.Errorf("get cluster status: %w", err)
+	}
+	d.report.Members = cs.Members
+	leader, ok := runningLeaderOf(cs)
+	if !ok {
+		return fmt.Errorf("cluster has no running leader")
+	}
+	if d.report.NewLeader == "" {
+		d.report.NewLeader = leader.Name
+	}
+	d.report.TimelineAfter = leader.Timeline
+	return nil
+}
+
+// awaitEtcd waits for every etcd endpoint of the cluster to be healthy.
+func (d *drill) awaitEtcd(ctx context.Context) error {
+	ec := dcs.NewEtcdClient(d.entry.EtcdEndpoints)
+	deadline := time.Now().Add(d.opts.Timeout)
+	for {
+		pctx, cancel := context.WithTimeout(ctx, drillProbeTimeout)
+		d.report.Etcd = ec.Health(pctx)
+		cancel()
+		if d.report.Etcd.HealthyMembers == len(d.report.Etcd.Endpoints) {
+			return nil
+		}
+		if time.Now().After(deadline) || !sleepCtx(ctx, d.opts.PollInterval) {
+			return fmt.Errorf("%d of %d etcd members healthy", d.report.Etcd.HealthyMembers, len(d.report.Etcd.Endpoints))
+		}
+	}
+}
+
+// runningLeaderOf returns the member of cs that is leader in a running state.
+func runningLeaderOf(cs *patroni.ClusterStatus) (patroni.Member, bool) {
+	for _, m := range cs.Members {
+		if isLeaderRole(m.Role) && m.State == patroni.StateRunning {
+			return m, true
+		}
+	}
+	return patroni.Member{}, false
+}
+
+// sleepCtx waits for d, returning false if ctx is done first.
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
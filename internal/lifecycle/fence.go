@@ -0,0 +1,201 @@
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/luckyjian/pgdba/internal/patroni"
+	"github.com/luckyjian/pgdba/internal/provider"
+)
+
+// Fencing methods for the old leader of a forced failover.
+const (
+	FenceNone      = "none"      // leave it alone
+	FenceStop      = "stop"      // stop Patroni and PostgreSQL on it
+	FencePartition = "partition" // cut it off the network through the provider
+)
+
+// When to fence, relative to the promotion of the new leader.
+const (
+	FenceBefore = "before"
+	FenceAfter  = "after"
+)
+
+// Fencing step names reported in StepEvent.Step.
+const (
+	StepFence       = "fence"
+	StepVerifyFence = "verify-fence"
+)
+
+const defaultFenceVerifyTimeout = 30 * time.Second
+
+// FenceOptions controls Fence.
+type FenceOptions struct {
+	Method string
+	When   string // recorded in the result only
+
+	// DataDir and PGVersion locate pg_ctl and the cluster for FenceStop
+	// (defaults: DefaultDataDir, pg_ctl from PATH).
+	DataDir   string
+	PGVersion int
+
+	VerifyTimeout time.Duration // default 30s
+	PollInterval  time.Duration
+
+	// OnEvent, if set, is called synchronously for every step event.
+	OnEvent func(StepEvent)
+}
+
+// FenceResult records how the old leader was fenced. Fenced is set when
+// the provider action succeeded; Verified when the node was shown not to
+// accept writes: its own Patroni API reports it is no longer a running
+// primary, or cannot be reached once it was stopped or partitioned.
+type FenceResult struct {
+	Node     string      `json:"node"`
+	Method   string      `json:"method"`
+	When     string      `json:"when"`
+	Fenced   bool        `json:"fenced"`
+	Verified bool        `json:"verified"`
+	Role     string      `json:"role,omitempty"`
+	State    string      `json:"state,omitempty"`
+	Detail   string      `json:"detail"`
+	Error    string      `json:"error,omitempty"`
+	Events   []StepEvent `json:"events"`
+}
+
+// ValidateFence checks a fencing method and timing.
+func ValidateFence(method, when string) error {
+	switch method {
+	case FenceNone, FenceStop, FencePartition:
+	default:
+		return fmt.Errorf("unknown fencing method %q: must be %s, %s or %s", method, FenceNone, FenceStop, FencePartition)
+	}
+	switch when {
+	case FenceBefore, FenceAfter:
+	default:
+		return fmt.Errorf("unknown fencing time %q: must be %s or %s", when, FenceBefore, FenceAfter)
+	}
+	return nil
+}
+
+// Fence fences old, the leader a forced failover replaces, with p and then
+// polls old's own Patroni REST API (reached through client) until it is no
+// longer a writable primary or opts.VerifyTimeout passes. With FenceNone
+// nothing is done. Failures are recorded in the result, never returned: the
+// caller decides whether an unverified fence stops the failover.
+func Fence(ctx context.Context, p provider.Provider, client *patroni.Client, old patroni.Member, opts FenceOptions) *FenceResult {
+	if opts.VerifyTimeout == 0 {
+		opts.VerifyTimeout = defaultFenceVerifyTimeout
+	}
+	if opts.PollInterval == 0 {
+		opts.PollInterval = defaultPollInterval
+	}
+	if opts.DataDir == "" {
+		opts.DataDir = DefaultDataDir
+	}
+	res := &FenceResult{Node: old.Name, Method: opts.Method, When: opts.When, Events: []StepEvent{}}
+	if opts.Method == FenceNone {
+		res.Detail = "not fenced"
+		return res
+	}
+	rec := recorder{events: &res.Events, onEvent: opts.OnEvent}
+
+	rec.emit(StepFence, old.Name, StatusStarted, opts.Method)
+	var err error
+	// Stopping Patroni may take the exec session down with it, so a failed
+	// stop still counts once the API goes away, provided the API answered
+	// before the stop: an API that was never reachable proves nothing.
+	stopTookAPI := false
+	switch {
+	case p == nil:
+		err = fmt.Errorf("no provider to fence %s with; the cluster is not managed by pgdba", old.Name)
+	case opts.Method == FencePartition:
+		err = p.PartitionNode(ctx, old.Name, true)
+	default:
+		reachable := apiReachable(ctx, client, old)
+		_, err = p.ExecOnNode(ctx, old.Name, postgresCmd(stopScript(opts.PGVersion, opts.DataDir)))
+		stopTookAPI = err != nil && reachable
+	}
+	if err != nil {
+		res.Error = rec.fail(StepFence, old.Name, err).Error()
+	} else {
+		res.Fenced = true
+		rec.emit(StepFence, old.Name, StatusDone, "")
+	}
+
+	rec.emit(StepVerifyFence, old.Name, StatusStarted, "")
+	verifyFenced(ctx, client, old, res, opts, stopTookAPI)
+	status := StatusDone
+	if !res.Verified {
+		status = StatusFailed
+	}
+	rec.emit(StepVerifyFence, old.Name, status, res.Detail)
+	return res
+}
+
+// apiReachable reports whether old's Patroni API answers.
+func apiReachable(ctx context.Context, client *patroni.Client, old patroni.Member) bool {
+	mc, err := client.ForMember(old)
+	if err != nil {
+		return false
+	}
+	pctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	_, err = mc.GetNodeInfo(pctx)
+	return err == nil
+}
+
+// verifyFenced polls old's Patroni API and fills in the verdict of res. An
+// unreachable API counts as fenced after a fence that succeeded or, with
+// stopTookAPI, after a stop whose exec session ended as the API went away.
+func verifyFenced(ctx context.Context, client *patroni.Client, old patroni.Member, res *FenceResult, opts FenceOptions, stopTookAPI bool) {
+	mc, err := client.ForMember(old)
+	if err != nil {
+		res.Detail = fmt.Sprintf("cannot verify: %v", err)
+		return
+	}
+	deadline := time.Now().Add(opts.VerifyTimeout)
+	for {
+		pctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		info, err := mc.GetNodeInfo(pctx)
+		cancel()
+		switch {
+		case err != nil && res.Fenced:
+			res.Verified = true
+			res.Detail = fmt.Sprintf("Patroni API unreachable after %s", res.Method)
+			return
+		case err != nil && stopTookAPI:
+			res.Verified = true
+			res.Detail = fmt.Sprintf("Patroni API unreachable after %s; the exec session ended with it", res.Method)
+			return
+		case err != nil:
+			res.Detail = fmt.Sprintf("Patroni API unreachable, but the %s failed; cannot prove it is not writable: %v", res.Method, err)
+		default:
+			res.Role, res.State = info.Role, string(info.State)
+			if !isLeaderRole(info.Role) || info.State != patroni.StateRunning {
+				res.Verified = true
+				res.Detail = fmt.Sprintf("no longer a writable primary (role %s, state %s)", info.Role, info.State)
+				return
+			}
+			res.Detail = fmt.Sprintf("still a running primary (role %s)", info.Role)
+		}
+		if time.Now().After(deadline) || !sleepCtx(ctx, opts.PollInterval) {
+			return
+		}
+	}
+}
+
+// stopScript stops Patroni first, so that it cannot start PostgreSQL
+// again, then PostgreSQL itself if it is still up. The [b] keeps pkill
+// and pgrep from matching the shell running the script. It runs through
+// postgresCmd, as pg_ctl refuses to run as root.
+func stopScript(pgVersion int, dataDir string) string {
+	pgCtl := "pg_ctl"
+	if pgVersion > 0 {
+		pgCtl = patroni.BinDir(pgVersion) + "/pg_ctl"
+	}
+	return fmt.Sprintf("pkill -TERM -f '[b]in/patroni'; "+
+		"for i in $(seq 30); do pgrep -f '[b]in/patroni' >/dev/null || break; sleep 1; done; "+
+		"if %[1]s status -D %[2]s >/dev/null 2>&1; then %[1]s stop -D %[2]s -m immediate; fi", pgCtl, dataDir)
+}
@@ -0,0 +1,168 @@
+package lifecycle
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/luckyjian/pgdba/internal/cluster"
+	"github.com/luckyjian/pgdba/internal/patroni"
+	"github.com/luckyjian/pgdba/internal/pgbouncer"
+	"github.com/luckyjian/pgdba/internal/provider"
+)
+
+// MemberTemplate carries the cluster-wide settings every Patroni member of a
+// cluster is rendered with.
+type MemberTemplate struct {
+	ClusterName string
+	EtcdHosts   []string // etcd client endpoints, "host:2379"
+	PGPort      int
+	DataDir     string
+	PGVersion   int    // PostgreSQL major version; 0 = patroni.DefaultPGVersion
+	Image       string // Patroni member image; empty = provider default
+	Passwords   Passwords
+}
+
+// TemplateFor returns the member template of the registered managed cluster
+// entry. The etcd endpoints recorded at bootstrap are used when present;
+// otherwise they are derived from the cluster's etcd nodes in nodes.
+func TemplateFor(entry *cluster.Entry, nodes []provider.NodeStatus, pw Passwords) (MemberTemplate, error) {
+	t := MemberTemplate{
+		ClusterName: entry.Name,
+		EtcdHosts:   entry.EtcdEndpoints,
+		PGPort:      entry.PGPort,
+		DataDir:     entry.DataDir,
+		PGVersion:   entry.PGVersion,
+		Image:       entry.Image,
+		Passwords:   pw,
+	}
+	if t.PGPort == 0 {
+		t.PGPort = 5432
+	}
+	if t.DataDir == "" {
+		t.DataDir = DefaultDataDir
+	}
+	if len(t.EtcdHosts) == 0 {
+		for _, n := range nodes {
+			if n.Role == provider.RoleEtcd {
+				t.EtcdHosts = append(t.EtcdHosts, fmt.Sprintf("%s:%d", n.ID, EtcdClientPort))
+			}
+		}
+	}
+	if len(t.EtcdHosts) == 0 {
+		return t, fmt.Errorf("cannot determine etcd endpoints of cluster %q", entry.Name)
+	}
+	return t, nil
+}
+
+// PatroniNode renders the NodeConfig of the Patroni member node advertising
+// host. The node is labelled with the owning cluster.
+//
+// The default image, spilo, generates its own Patroni config rather than
+// reading PatroniConfigPath, so the settings that matter to pgdba are also
+// passed in the environment: spilo's own variables for the users it creates,
+// and Patroni's PATRONI_* overrides, which take precedence over any config
+// file, for the namespace, data directory and credentials.
+func PatroniNode(t MemberTemplate, node, host string, role provider.NodeRole) (provider.NodeConfig, error) {
+	etcdHosts := strings.Join(t.EtcdHosts, ",")
+	version := t.PGVersion
+	if version == 0 {
+		version = patroni.DefaultPGVersion
+	}
+	rendered, err := patroni.RenderPatroniConfig(patroni.PatroniConfig{
+		ClusterName:         t.ClusterName,
+		NodeName:            node,
+		Host:                host,
+		PGPort:              t.PGPort,
+		DataDir:             t.DataDir,
+		EtcdHosts:           etcdHosts,
+		ReplicationPassword: t.Passwords.Replication,
+		SuperuserPassword:   t.Passwords.Superuser,
+		RewindPassword:      t.Passwords.Rewind,
+		PGVersion:           version,
+	})
+	if err != nil {
+		return provider.NodeConfig{}, err
+	}
+	return provider.NodeConfig{
+		Name:    node,
+		Role:    role,
+		Host:    host,
+		DataDir: t.DataDir,
+		Port:    t.PGPort,
+		Image:   t.Image,
+		Labels:  map[string]string{provider.LabelCluster: t.ClusterName},
+		Env: map[string]string{
+			"SCOPE":                        t.ClusterName,
+			"ETCD3_HOSTS":                  etcdHosts,
+			"PGVERSION":                    strconv.Itoa(version),
+			"PGDATA":                       t.DataDir,
+			"PGUSER_SUPERUSER":             "postgres",
+			"PGPASSWORD_SUPERUSER":         t.Passwords.Superuser,
+			"PGUSER_STANDBY":               "replicator",
+			"PGPASSWORD_STANDBY":           t.Passwords.Replication,
+			"PATRONI_NAMESPACE":            PatroniNamespace + "/",
+			"PATRONI_POSTGRESQL_DATA_DIR":  t.DataDir,
+			"PATRONI_SUPERUSER_USERNAME":   "postgres",
+			"PATRONI_SUPERUSER_PASSWORD":   t.Passwords.Superuser,
+			"PATRONI_REPLICATION_USERNAME": "replicator",
+			"PATRONI_REPLICATION_PASSWORD": t.Passwords.Replication,
+			"PATRONI_REWIND_USERNAME":      "rewind_user",
+			"PATRONI_REWIND_PASSWORD":      t.Passwords.Rewind,
+		},
+		Files: map[string]string{PatroniConfigPath: rendered},
+	}, nil
+}
+
+// PgBouncerSettings are the pool settings of a cluster's PgBouncer.
+type PgBouncerSettings struct {
+	PoolMode        string `json:"pool_mode" yaml:"pool_mode"`
+	MaxClientConn   int    `json:"max_client_conn" yaml:"max_client_conn"`
+	DefaultPoolSize int    `json:"default_pool_size" yaml:"default_pool_size"`
+}
+
+// DefaultPgBouncerSettings returns the settings cluster init uses.
+func DefaultPgBouncerSettings() PgBouncerSettings {
+	return PgBouncerSettings{PoolMode: "transaction", MaxClientConn: 1000, DefaultPoolSize: 20}
+}
+
+// RenderPgBouncerIni renders pgbouncer.ini routing the postgres database to
+// primaryHost.
+func RenderPgBouncerIni(primaryHost string, pgPort int, s PgBouncerSettings) string {
+	return pgbouncer.RenderConfig(pgbouncer.Config{
+		ListenPort:      PgBouncerPort,
+		AuthFile:        PgBouncerUsersPath,
+		AdminUsers:      "postgres",
+		PoolMode:        s.PoolMode,
+		MaxClientConn:   s.MaxClientConn,
+		DefaultPoolSize: s.DefaultPoolSize,
+		Databases: []pgbouncer.Database{
+			{Name: "postgres", Host: primaryHost, Port: pgPort, DBName: "postgres"},
+		},
+	})
+}
+
+// PgBouncerNode returns the NodeConfig of the cluster's PgBouncer.
+func PgBouncerNode(t MemberTemplate, primaryHost string, s PgBouncerSettings) provider.NodeConfig {
+	users := pgbouncer.RenderUserlist(map[string]string{
+		"postgres": md5Password("postgres", t.Passwords.Superuser),
+	})
+	return provider.NodeConfig{
+		Name:   PgBouncerNodeName(t.ClusterName),
+		Image:  PgBouncerImage,
+		Port:   PgBouncerPort,
+		Labels: map[string]string{provider.LabelCluster: t.ClusterName},
+		Files: map[string]string{
+			PgBouncerIniPath:   RenderPgBouncerIni(primaryHost, t.PGPort, s),
+			PgBouncerUsersPath: users,
+		},
+	}
+}
+
+// md5Password returns the PostgreSQL/PgBouncer "md5" password hash.
+func md5Password(user, password string) string {
+	sum := md5.Sum([]byte(password + user))
+	return "md5" + hex.EncodeToString(sum[:])
+}
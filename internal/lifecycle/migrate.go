@@ -0,0 +1,556 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/luckyjian/pgdba/internal/pgbouncer"
+	"github.com/luckyjian/pgdba/internal/provider"
+	"github.com/luckyjian/pgdba/internal/replication"
+	"github.com/luckyjian/pgdba/internal/upgrade"
+)
+
+// Migration step names reported in StepEvent.Step. StartMigration runs
+// StepSchema, StepPublish, StepSubscribe and, when asked to wait, StepSync.
+// Cutover runs StepSync, StepPgBouncerPause, StepReadOnly, StepCatchUp,
+// StepSequences, StepRepoint, StepPgBouncerResume and StepCleanup; it runs
+// StepRollback when it fails before clients were repointed.
+const (
+	StepPgBouncerPause  = "pgbouncer_pause"
+	StepReadOnly        = "read_only"
+	StepCatchUp         = "catch_up"
+	StepRepoint         = "repoint"
+	StepPgBouncerResume = "pgbouncer_resume"
+	StepRollback        = "rollback"
+)
+
+// Migration check names, in plan order.
+const (
+	CheckWALLevel        = "wal_level"
+	CheckSlots           = "replication_slots"
+	CheckSourceDatabases = "source_databases"
+	CheckTargetDatabases = "target_databases"
+	CheckReplicaIdentity = "replica_identity"
+	CheckPgBouncer       = "pgbouncer"
+)
+
+// MigrationName names the publication and the subscription a migration
+// creates in every database.
+const MigrationName = "pgdba_migrate"
+
+// MigrationSlot returns the name of the source slot feeding database db's
+// subscription. Slots are per cluster, so it carries the database name,
+// made a valid slot name the way member names are.
+func MigrationSlot(db string) string {
+	name := MigrationName + "_" + replication.MemberSlotName(db)
+	if len(name) > 63 {
+		name = name[:63]
+	}
+	return name
+}
+
+// MigrationEndpoint is the leader of one side of a migration, as reached
+// from MigrateOptions.Node.
+type MigrationEndpoint struct {
+	Cluster string `json:"cluster"`
+	Host    string `json:"host"`
+	Port    int    `json:"port"`
+}
+
+// MigrateOptions describes a migration of databases from one cluster's
+// leader to another's.
+type MigrateOptions struct {
+	Source MigrationEndpoint
+	Target MigrationEndpoint
+
+	// Node runs psql and pg_dump against both leaders, usually the target
+	// leader's node.
+	Node      string
+	Databases []string
+	// Password is the postgres superuser's on both clusters.
+	Password string
+	// PgBouncerNode runs the PgBouncer through which clients reach
+	// Databases on the source; its [databases] entries are repointed at
+	// cutover. Empty means clients are repointed by other means.
+	PgBouncerNode string
+	// Wait makes StartMigration wait for the initial table copy.
+	Wait bool
+
+	Timeout      time.Duration // per wait: initial copy, catch-up
+	PollInterval time.Duration
+
+	// OnEvent, if set, is called synchronously for every step event.
+	OnEvent func(StepEvent)
+}
+
+// PgBouncerRepoint lists the [databases] entries of a PgBouncer that a
+// cutover repoints at the target (Changed) or adds for it (Added).
+type PgBouncerRepoint struct {
+	Node    string   `json:"node"`
+	Changed []string `json:"changed"`
+	Added   []string `json:"added"`
+}
+
+// MigrationPlan is the readiness of a migration and what it will do.
+type MigrationPlan struct {
+	Source    MigrationEndpoint     `json:"source"`
+	Target    MigrationEndpoint     `json:"target"`
+	Databases []string              `json:"databases"`
+	Ready     bool                  `json:"ready"`
+	Checks    []upgrade.CheckResult `json:"checks"`
+	PgBouncer *PgBouncerRepoint     `json:"pgbouncer,omitempty"`
+	Steps     []string              `json:"steps"`
+}
+
+// MigrationResult records StartMigration or Cutover.
+type MigrationResult struct {
+	Source    MigrationEndpoint `json:"source"`
+	Target    MigrationEndpoint `json:"target"`
+	Databases []string          `json:"databases"`
+	PgBouncer *PgBouncerRepoint `json:"pgbouncer,omitempty"`
+	// CatchUpLSN is the source WAL position every subscription confirmed
+	// before clients were repointed.
+	CatchUpLSN string      `json:"catch_up_lsn,omitempty"`
+	Events     []StepEvent `json:"events"`
+}
+
+// migration carries the state of one migration call.
+type migration struct {
+	recorder
+	p        provider.Provider
+	opts     MigrateOptions
+	src, dst pgEndpoint
+}
+
+func newMigration(p provider.Provider, opts MigrateOptions, events *[]StepEvent) (*migration, error) {
+	switch {
+	case opts.Node == "":
+		return nil, fmt.Errorf("no node to run psql on")
+	case len(opts.Databases) == 0:
+		return nil, fmt.Errorf("no databases to migrate")
+	case opts.Source.Host == "" || opts.Target.Host == "":
+		return nil, fmt.Errorf("source and target leaders are required")
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = defaultScaleTimeout
+	}
+	if opts.PollInterval == 0 {
+		opts.PollInterval = defaultPollInterval
+	}
+	return &migration{
+		recorder: recorder{events: events, onEvent: opts.OnEvent},
+		p:        p,
+		opts:     opts,
+		src:      pgEndpoint{host: opts.Source.Host, port: opts.Source.Port},
+		dst:      pgEndpoint{host: opts.Target.Host, port: opts.Target.Port},
+	}, nil
+}
+
+func newMigrationResult(opts MigrateOptions) *MigrationResult {
+	return &MigrationResult{Source: opts.Source, Target: opts.Target, Databases: opts.Databases, Events: []StepEvent{}}
+}
+
+func (m *migration) query(ctx context.Context, e pgEndpoint, db, sql string) (string, error) {
+	return psqlQuery(ctx, m.p, m.opts.Node, m.opts.Password, e, db, sql)
+}
+
+// run runs sql in database db of e as one step. message describes it in the
+// events, which never carry the statement: it may hold a password.
+func (m *migration) run(ctx context.Context, step string, e pgEndpoint, db, sql, message string) error {
+	m.emit(step, db, StatusStarted, message)
+	if _, err := m.query(ctx, e, db, sql); err != nil {
+		return m.fail(step, db, err)
+	}
+	m.emit(step, db, StatusDone, "")
+	return nil
+}
+
+// console runs a command on the PgBouncer admin console.
+func (m *migration) console(ctx context.Context, command string) error {
+	_, err := m.query(ctx, pgEndpoint{host: m.opts.PgBouncerNode, port: PgBouncerPort}, "pgbouncer", command)
+	return err
+}
+
+// poll runs the boolean query sql until it returns true, or fails after
+// opts.Timeout.
+func (m *migration) poll(ctx context.Context, e pgEndpoint, db, sql string) error {
+	ctx, cancel := context.WithTimeout(ctx, m.opts.Timeout)
+	defer cancel()
+
+	var last string
+	for {
+		out, err := m.query(ctx, e, db, sql)
+		if err == nil && out == "t" {
+			return nil
+		}
+		last = out
+		if err != nil {
+			last = err.Error()
+		}
+		if !sleepCtx(ctx, m.opts.PollInterval) {
+			return fmt.Errorf("%s: not done within %s (last result %q)", db, m.opts.Timeout, last)
+		}
+	}
+}
+
+// syncedSQL is true once the migration subscription of the current database
+// exists and every table of it is ready.
+const syncedSQL = "SELECT count(DISTINCT s.oid) = 1 AND count(r.srrelid) FILTER (WHERE r.srsubstate <> 'r') = 0 " +
+	"FROM pg_subscription s LEFT JOIN pg_subscription_rel r ON r.srsubid = s.oid " +
+	"WHERE s.subname = '" + MigrationName + "' AND s.subdbid = (SELECT oid FROM pg_database WHERE datname = current_database())"
+
+// readWrite wraps sql in a read-write transaction, for source databases a
+// cutover made read-only by default.
+func readWrite(sql string) string {
+	return "BEGIN READ WRITE; " + sql + "; COMMIT"
+}
+
+// PlanMigration checks that opts.Databases can be migrated: the source
+// decodes WAL logically and has free slots, the databases exist there and
+// not yet, or empty, on the target, and the PgBouncer configuration can be
+// read. Tables that cannot replicate UPDATE and DELETE are warned about.
+// Nothing is changed.
+func PlanMigration(ctx context.Context, p provider.Provider, opts MigrateOptions) (*MigrationPlan, error) {
+	var events []StepEvent
+	m, err := newMigration(p, opts, &events)
+	if err != nil {
+		return nil, err
+	}
+	plan := &MigrationPlan{Source: opts.Source, Target: opts.Target, Databases: opts.Databases,
+		Checks: []upgrade.CheckResult{}}
+
+	walLevel, err := m.query(ctx, m.src, "postgres", "SHOW wal_level")
+	if err != nil {
+		return nil, fmt.Errorf("query source %s: %w", opts.Source.Cluster, err)
+	}
+	c := upgrade.CheckResult{Name: CheckWALLevel, Status: upgrade.StatusOK, Detail: "wal_level is logical"}
+	if walLevel != "logical" {
+		c.Status = upgrade.StatusFail
+		c.Detail = fmt.Sprintf("wal_level is %s; set it to logical on the source and restart it", walLevel)
+	}
+	plan.Checks = append(plan.Checks, c)
+
+	out, err := m.query(ctx, m.src, "postgres",
+		"SELECT current_setting('max_replication_slots')::int - count(*) FROM pg_replication_slots")
+	if err != nil {
+		return nil, fmt.Errorf("query source %s: %w", opts.Source.Cluster, err)
+	}
+	free, _ := strconv.Atoi(out)
+	c = upgrade.CheckResult{Name: CheckSlots, Status: upgrade.StatusOK,
+		Detail: fmt.Sprintf("%d free replication slots for %d databases", free, len(opts.Databases))}
+	switch {
+	case free < len(opts.Databases):
+		c.Status = upgrade.StatusFail
+		c.Detail += "; raise max_replication_slots on the source"
+	case free < 3*len(opts.Databases):
+		c.Status = upgrade.StatusWarn
+		c.Detail += "; the initial copy also takes temporary slots, so fewer tables copy in parallel"
+	}
+	plan.Checks = append(plan.Checks, c)
+
+	source := upgrade.CheckResult{Name: CheckSourceDatabases, Status: upgrade.StatusOK, Detail: "all databases exist on the source"}
+	target := upgrade.CheckResult{Name: CheckTargetDatabases, Status: upgrade.StatusOK,
+		Detail: "no database has tables on the target yet"}
+	identity := upgrade.CheckResult{Name: CheckReplicaIdentity, Status: upgrade.StatusOK,
+		Detail: "every table has a replica identity"}
+	for _, db := range opts.Databases {
+		exists := fmt.Sprintf("SELECT count(*) FROM pg_database WHERE datname = %s", quoteLiteral(db))
+		if out, err := m.query(ctx, m.src, "postgres", exists); err != nil {
+			return nil, fmt.Errorf("query source %s: %w", opts.Source.Cluster, err)
+		} else if out != "1" {
+			source.Status, source.Detail = upgrade.StatusFail, "databases missing on the source"
+			source.Items = append(source.Items, db)
+			continue
+		}
+		out, err := m.query(ctx, m.src, db,
+			"SELECT format('%I.%I', n.nspname, c.relname) FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace "+
+				"WHERE c.relkind = 'r' AND n.nspname NOT IN ('pg_catalog', 'information_schema') AND n.nspname NOT LIKE 'pg_toast%' "+
+				"AND (c.relreplident = 'n' OR (c.relreplident = 'd' AND NOT EXISTS "+
+				"(SELECT 1 FROM pg_index i WHERE i.indrelid = c.oid AND i.indisprimary))) ORDER BY 1")
+		if err != nil {
+			return nil, fmt.Errorf("query source database %s: %w", db, err)
+		}
+		for _, table := range strings.Split(out, "\n") {
+			if table == "" {
+				continue
+			}
+			identity.Status = upgrade.StatusWarn
+			identity.Detail = "UPDATE and DELETE on these tables fail while they are published; add a primary key or REPLICA IDENTITY FULL"
+			identity.Items = append(identity.Items, db+": "+table)
+		}
+
+		out, err = m.query(ctx, m.dst, "postgres", exists)
+		if err != nil {
+			return nil, fmt.Errorf("query target %s: %w", opts.Target.Cluster, err)
+		}
+		if out != "1" {
+			continue
+		}
+		out, err = m.query(ctx, m.dst, db,
+			"SELECT count(*) FROM pg_tables WHERE schemaname NOT IN ('pg_catalog', 'information_schema')")
+		if err != nil {
+			return nil, fmt.Errorf("query target database %s: %w", db, err)
+		}
+		if out != "0" {
+			target.Status, target.Detail = upgrade.StatusFail, "databases that already have tables on the target"
+			target.Items = append(target.Items, fmt.Sprintf("%s: %s tables", db, out))
+		}
+	}
+	plan.Checks = append(plan.Checks, source, target, identity)
+
+	c = upgrade.CheckResult{Name: CheckPgBouncer, Status: upgrade.StatusWarn,
+		Detail: "no PgBouncer: point clients at the target after the cutover"}
+	if opts.PgBouncerNode != "" {
+		ini, err := p.ExecOnNode(ctx, opts.PgBouncerNode, []string{"cat", PgBouncerIniPath})
+		if err != nil {
+			c.Status, c.Detail = upgrade.StatusFail, fmt.Sprintf("cannot read %s on %s: %v", PgBouncerIniPath, opts.PgBouncerNode, err)
+		} else {
+			rp := pgbouncer.RepointDatabases(ini, opts.Databases, opts.Target.Host, opts.Target.Port)
+			plan.PgBouncer = &PgBouncerRepoint{Node: opts.PgBouncerNode, Changed: rp.Changed, Added: rp.Added}
+			c.Status, c.Detail = upgrade.StatusOK, fmt.Sprintf("%d entries repointed, %d added", len(rp.Changed), len(rp.Added))
+			if len(rp.Added) > 0 {
+				c.Status = upgrade.StatusWarn
+				c.Detail += "; clients of added entries are not paused at cutover"
+			}
+		}
+	}
+	plan.Checks = append(plan.Checks, c)
+
+	plan.Ready = true
+	for _, c := range plan.Checks {
+		if c.Status == upgrade.StatusFail {
+			plan.Ready = false
+		}
+	}
+	plan.Steps = []string{
+		"start: copy the roles to the target (pg_dumpall --roles-only)",
+		"start: create the missing databases on the target and copy the schema (pg_dump --schema-only)",
+		"start: create publication " + MigrationName + " for all tables on the source",
+		"start: subscribe the target through slot " + MigrationName + "_<database> and copy the rows",
+		"status: wait until every table is ready and the lag is small",
+		"cutover: pause PgBouncer, make the source databases read-only, wait for the subscriptions to confirm the source LSN",
+		"cutover: copy sequence values, repoint PgBouncer [databases] at the target, resume, drop publication and subscription",
+	}
+	return plan, nil
+}
+
+// StartMigration copies the roles of the source and the schema of
+// opts.Databases to the target, creating missing databases, publishes all
+// their tables on the source and subscribes the target to them. With opts.Wait it also waits for the
+// initial copy; otherwise its progress shows in the subscription status.
+func StartMigration(ctx context.Context, p provider.Provider, opts MigrateOptions) (*MigrationResult, error) {
+	res := newMigrationResult(opts)
+	m, err := newMigration(p, opts, &res.Events)
+	if err != nil {
+		return nil, err
+	}
+
+	// The schema's owners and grants name roles, which pg_dump does not copy.
+	m.emit(StepSchema, "", StatusStarted, "copy roles")
+	if err := copyRoles(ctx, p, opts.Node, opts.Password, m.src, m.dst); err != nil {
+		return res, m.fail(StepSchema, "", err)
+	}
+	m.emit(StepSchema, "", StatusDone, "")
+
+	for _, db := range opts.Databases {
+		exists, err := m.query(ctx, m.dst, "postgres",
+			fmt.Sprintf("SELECT count(*) FROM pg_database WHERE datname = %s", quoteLiteral(db)))
+		if err != nil {
+			return res, m.fail(StepSchema, db, err)
+		}
+		if exists != "1" {
+			if err := m.run(ctx, StepSchema, m.dst, "postgres", "CREATE DATABASE "+quoteIdent(db), "create database "+db); err != nil {
+				return res, err
+			}
+		}
+		m.emit(StepSchema, db, StatusStarted, "copy schema")
+		if _, err := p.ExecOnNode(ctx, opts.Node, []string{"env", "PGPASSWORD=" + opts.Password, "sh", "-c",
+			fmt.Sprintf(`set -e; f=$(mktemp); pg_dump %s --schema-only --no-publications --no-subscriptions -f "$f"; `+
+				`psql %s -v ON_ERROR_STOP=1 -q -f "$f"; rm -f "$f"`, m.src.args(db), m.dst.args(db))}); err != nil {
+			return res, m.fail(StepSchema, db, err)
+		}
+		m.emit(StepSchema, db, StatusDone, "")
+
+		if err := m.run(ctx, StepPublish, m.src, db, replication.CreatePublicationSQL(MigrationName, nil),
+			"publish all tables"); err != nil {
+			return res, err
+		}
+		conninfo := fmt.Sprintf("host=%s port=%d dbname=%s user=postgres password=%s", m.src.host, m.src.port, db, opts.Password)
+		if err := m.run(ctx, StepSubscribe, m.dst, db, replication.CreateSubscriptionSQL(MigrationName, conninfo,
+			[]string{MigrationName}, replication.SubscriptionOptions{CopyData: true, SlotName: MigrationSlot(db)}),
+			"subscribe through slot "+MigrationSlot(db)); err != nil {
+			return res, err
+		}
+	}
+
+	if opts.Wait {
+		m.emit(StepSync, "", StatusStarted, "waiting for the initial table copy")
+		for _, db := range opts.Databases {
+			if err := m.poll(ctx, m.dst, db, syncedSQL); err != nil {
+				return res, m.fail(StepSync, db, err)
+			}
+		}
+		m.emit(StepSync, "", StatusDone, "")
+	}
+	return res, nil
+}
+
+// Cutover moves the clients of opts.Databases to the target. It refuses to
+// start before every table is replicated, then pauses the PgBouncer
+// entries of the databases, makes them read-only on the source and ends
+// their sessions, waits for every subscription to confirm the source's WAL
+// position, copies sequence values and repoints the PgBouncer entries at
+// the target before resuming them. Until the entries are repointed a
+// failure is rolled back: the source is writable again and PgBouncer
+// resumed. Afterwards the subscriptions and publications are dropped; the
+// source databases stay read-only.
+func Cutover(ctx context.Context, p provider.Provider, opts MigrateOptions) (*MigrationResult, error) {
+	res := newMigrationResult(opts)
+	m, err := newMigration(p, opts, &res.Events)
+	if err != nil {
+		return nil, err
+	}
+
+	m.emit(StepSync, "", StatusStarted, "checking that every table is replicated")
+	for _, db := range opts.Databases {
+		out, err := m.query(ctx, m.dst, db, syncedSQL)
+		if err != nil {
+			return res, m.fail(StepSync, db, err)
+		}
+		if out != "t" {
+			return res, m.fail(StepSync, db, fmt.Errorf("the initial copy has not finished; see migrate status"))
+		}
+	}
+	m.emit(StepSync, "", StatusDone, "")
+
+	var rp pgbouncer.Repoint
+	var paused, readOnly []string
+	if node := opts.PgBouncerNode; node != "" {
+		ini, err := p.ExecOnNode(ctx, node, []string{"cat", PgBouncerIniPath})
+		if err != nil {
+			return res, m.fail(StepPgBouncerPause, node, fmt.Errorf("read %s: %w", PgBouncerIniPath, err))
+		}
+		rp = pgbouncer.RepointDatabases(ini, opts.Databases, opts.Target.Host, opts.Target.Port)
+		res.PgBouncer = &PgBouncerRepoint{Node: node, Changed: rp.Changed, Added: rp.Added}
+
+		m.emit(StepPgBouncerPause, node, StatusStarted, strings.Join(rp.Changed, ","))
+		for _, alias := range rp.Changed {
+			if err := m.console(ctx, "PAUSE "+alias); err != nil {
+				return res, m.rollback(m.fail(StepPgBouncerPause, node, fmt.Errorf("pause %s: %w", alias, err)), paused, readOnly)
+			}
+			paused = append(paused, alias)
+		}
+		m.emit(StepPgBouncerPause, node, StatusDone, "")
+	}
+
+	for _, db := range opts.Databases {
+		m.emit(StepReadOnly, db, StatusStarted, "")
+		readOnly = append(readOnly, db)
+		if _, err := m.query(ctx, m.src, "postgres", readWrite(fmt.Sprintf(
+			"ALTER DATABASE %s SET default_transaction_read_only = on", quoteIdent(db)))); err != nil {
+			return res, m.rollback(m.fail(StepReadOnly, db, err), paused, readOnly)
+		}
+		// Sessions opened before keep writing; end them.
+		if _, err := m.query(ctx, m.src, "postgres", fmt.Sprintf(
+			"SELECT count(pg_terminate_backend(pid)) FROM pg_stat_activity "+
+				"WHERE datname = %s AND pid <> pg_backend_pid() AND backend_type = 'client backend'", quoteLiteral(db))); err != nil {
+			return res, m.rollback(m.fail(StepReadOnly, db, err), paused, readOnly)
+		}
+		m.emit(StepReadOnly, db, StatusDone, "")
+	}
+
+	lsn, err := m.query(ctx, m.src, "postgres", "SELECT pg_current_wal_lsn()")
+	if err != nil {
+		return res, m.rollback(m.fail(StepCatchUp, "", err), paused, readOnly)
+	}
+	res.CatchUpLSN = lsn
+	m.emit(StepCatchUp, "", StatusStarted, "waiting for the subscriptions to confirm "+lsn)
+	for _, db := range opts.Databases {
+		if err := m.poll(ctx, m.src, "postgres", fmt.Sprintf(
+			"SELECT confirmed_flush_lsn >= %s::pg_lsn FROM pg_replication_slots WHERE slot_name = %s",
+			quoteLiteral(lsn), quoteLiteral(MigrationSlot(db)))); err != nil {
+			return res, m.rollback(m.fail(StepCatchUp, db, err), paused, readOnly)
+		}
+	}
+	m.emit(StepCatchUp, "", StatusDone, "")
+
+	const sequences = "SELECT format('SELECT setval(%L, %s, true);', format('%I.%I', schemaname, sequencename), last_value) " +
+		"FROM pg_sequences WHERE last_value IS NOT NULL"
+	for _, db := range opts.Databases {
+		m.emit(StepSequences, db, StatusStarted, "")
+		if _, err := p.ExecOnNode(ctx, opts.Node, []string{"env", "PGPASSWORD=" + opts.Password, "sh", "-c",
+			fmt.Sprintf(`set -e; f=$(mktemp); psql %s -Atc "$1" -o "$f"; psql %s -v ON_ERROR_STOP=1 -q -f "$f"; rm -f "$f"`,
+				m.src.args(db), m.dst.args(db)),
+			"sh", sequences}); err != nil {
+			return res, m.rollback(m.fail(StepSequences, db, err), paused, readOnly)
+		}
+		m.emit(StepSequences, db, StatusDone, "")
+	}
+
+	if node := opts.PgBouncerNode; node != "" {
+		m.emit(StepRepoint, node, StatusStarted, fmt.Sprintf("%s:%d", opts.Target.Host, opts.Target.Port))
+		if err := provider.WriteFile(ctx, p, node, PgBouncerIniPath, rp.INI); err != nil {
+			return res, m.rollback(m.fail(StepRepoint, node, err), paused, readOnly)
+		}
+		if _, err := p.ExecOnNode(ctx, node, []string{"pkill", "-HUP", "-x", "pgbouncer"}); err != nil {
+			return res, m.fail(StepRepoint, node, fmt.Errorf("reload pgbouncer: %w", err))
+		}
+		m.emit(StepRepoint, node, StatusDone, "")
+
+		m.emit(StepPgBouncerResume, node, StatusStarted, "")
+		var errs []error
+		for _, alias := range paused {
+			if err := m.console(ctx, "RESUME "+alias); err != nil {
+				errs = append(errs, fmt.Errorf("resume %s: %w", alias, err))
+			}
+		}
+		if err := errors.Join(errs...); err != nil {
+			return res, m.fail(StepPgBouncerResume, node, err)
+		}
+		m.emit(StepPgBouncerResume, node, StatusDone, "")
+	}
+
+	for _, db := range opts.Databases {
+		if err := m.run(ctx, StepCleanup, m.dst, db, replication.DropSubscriptionSQL(MigrationName),
+			"drop subscription"); err != nil {
+			return res, err
+		}
+		if err := m.run(ctx, StepCleanup, m.src, db, readWrite(replication.DropPublicationSQL(MigrationName)),
+			"drop publication"); err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// rollback undoes a cutover that failed with cause before the PgBouncer
+// entries were repointed: the readOnly databases are writable again and
+// the paused aliases resumed. It runs even if ctx is done and returns
+// cause, joined with any rollback failure.
+func (m *migration) rollback(cause error, paused, readOnly []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	m.emit(StepRollback, "", StatusStarted, "")
+	var errs []error
+	for _, db := range readOnly {
+		if _, err := m.query(ctx, m.src, "postgres", readWrite(fmt.Sprintf(
+			"ALTER DATABASE %s RESET default_transaction_read_only", quoteIdent(db)))); err != nil {
+			errs = append(errs, fmt.Errorf("make %s writable: %w", db, err))
+		}
+	}
+	for _, alias := range paused {
+		if err := m.console(ctx, "RESUME "+alias); err != nil {
+			errs = append(errs, fmt.Errorf("resume %s: %w", alias, err))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return errors.Join(cause, m.fail(StepRollback, "", err))
+	}
+	m.emit(StepRollback, "", StatusDone, "")
+	return cause
+}
@@ -0,0 +1,129 @@
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/luckyjian/pgdba/internal/patroni"
+)
+
+// Re-initialization step names reported in StepEvent.Step.
+const (
+	StepReinit     = "reinit"
+	StepReinitWait = "reinit-wait"
+)
+
+// ReinitOptions controls Reinit.
+type ReinitOptions struct {
+	Force      bool // cancel a bootstrap or re-initialization already running
+	FromLeader bool // take the base backup from the leader
+
+	Timeout      time.Duration // default 30m
+	PollInterval time.Duration
+
+	// OnEvent, if set, is called synchronously for every step event.
+	OnEvent func(StepEvent)
+}
+
+const defaultReinitTimeout = 30 * time.Minute
+
+// StateChange is a member state seen while it was re-initialized.
+type StateChange struct {
+	State patroni.NodeState `json:"state"`
+	At    time.Time         `json:"at"`
+}
+
+// ReinitResult records a member's re-initialization.
+type ReinitResult struct {
+	Member    string        `json:"member"`
+	States    []StateChange `json:"states"`
+	ElapsedMS int64         `json:"elapsed_ms"`
+	Lag       int64         `json:"lag_bytes"`
+	Events    []StepEvent   `json:"events"`
+}
+
+// Reinit re-initializes replica member through its own Patroni REST API
+// (reached through client, which must answer /cluster): Patroni wipes its
+// data directory and takes a fresh base backup. It then follows the member
+// in /cluster, typically through "creating replica", until it is a healthy
+// replica again (streaming, or running on Patroni before 3.0) from a
+// PostgreSQL started after the request. Reinit refuses the leader.
+func Reinit(ctx context.Context, client *patroni.Client, member string, opts ReinitOptions) (*ReinitResult, error) {
+	if opts.Timeout == 0 {
+		opts.Timeout = defaultReinitTimeout
+	}
+	if opts.PollInterval == 0 {
+		opts.PollInterval = defaultPollInterval
+	}
+	res := &ReinitResult{Member: member, States: []StateChange{}, Events: []StepEvent{}}
+	rec := recorder{events: &res.Events, onEvent: opts.OnEvent}
+
+	rec.emit(StepReinit, member, StatusStarted, "")
+	cs, err := client.GetClusterStatus(ctx)
+	if err != nil {
+		return res, rec.fail(StepReinit, member, fmt.Errorf("get cluster status: %w", err))
+	}
+	m, ok := findMember(cs, member)
+	switch {
+	case !ok:
+		return res, rec.fail(StepReinit, member, fmt.Errorf("member not found in cluster"))
+	case isLeaderRole(m.Role):
+		return res, rec.fail(StepReinit, member,
+			fmt.Errorf("%s is the current leader; switch over before re-initializing it", member))
+	}
+	mc, err := client.ForMember(m)
+	if err != nil {
+		return res, rec.fail(StepReinit, member, err)
+	}
+	var startedBefore string
+	if info, err := mc.GetNodeInfo(ctx); err == nil {
+		startedBefore = info.PostmasterStartTime
+	}
+	requested := time.Now()
+	if err := mc.ReinitializeWith(ctx, patroni.ReinitOptions{Force: opts.Force, FromLeader: opts.FromLeader}); err != nil {
+		return res, rec.fail(StepReinit, member, err)
+	}
+	rec.emit(StepReinit, member, StatusDone, "")
+
+	rec.emit(StepReinitWait, member, StatusStarted, "waiting for the member to replicate again")
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+	restarted := false
+	var last patroni.NodeState
+	var lastErr error
+	for {
+		if cs, err := client.GetClusterStatus(ctx); err != nil {
+			lastErr = err
+		} else if m, ok := findMember(cs, member); !ok {
+			lastErr = fmt.Errorf("%s is not in the cluster view", member)
+		} else {
+			if m.State != last {
+				last = m.State
+				res.States = append(res.States, StateChange{State: m.State, At: time.Now().UTC()})
+				rec.emit(StepReinitWait, member, StatusProgress, string(m.State))
+			}
+			if !streamingOrRunning(m.State) {
+				restarted = true
+			} else if !restarted {
+				// A quick re-initialization may finish between two polls;
+				// a new postmaster start time tells it happened.
+				if info, err := mc.GetNodeInfo(ctx); err == nil && startedBefore != "" &&
+					info.PostmasterStartTime != "" && info.PostmasterStartTime != startedBefore {
+					restarted = true
+				}
+			}
+			if restarted && streamingOrRunning(m.State) {
+				res.Lag = m.Lag
+				res.ElapsedMS = time.Since(requested).Milliseconds()
+				rec.emit(StepReinitWait, member, StatusDone, fmt.Sprintf("%s, lag %d bytes", m.State, m.Lag))
+				return res, nil
+			}
+			lastErr = fmt.Errorf("%s is %s", member, m.State)
+		}
+		if !sleepCtx(ctx, opts.PollInterval) {
+			return res, rec.fail(StepReinitWait, member,
+				fmt.Errorf("not replicating again within %s: %w", opts.Timeout, lastErr))
+		}
+	}
+}
@@ -0,0 +1,370 @@
+package lifecycle
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/luckyjian/pgdba/internal/cluster"
+	"github.com/luckyjian/pgdba/internal/failover"
+	"github.com/luckyjian/pgdba/internal/patroni"
+	"github.com/luckyjian/pgdba/internal/provider"
+)
+
+// Rolling operation kinds.
+const (
+	RollingRestart = "rolling-restart"
+	RollingUpgrade = "rolling-upgrade"
+)
+
+// Rolling step names reported in StepEvent.Step.
+const (
+	StepRestart    = "restart"
+	StepSwitchover = "switchover"
+)
+
+// RollingOptions controls a rolling restart or minor-version upgrade.
+type RollingOptions struct {
+	Kind  string // RollingRestart or RollingUpgrade
+	Image string // new image, for RollingUpgrade
+
+	// Lag bounds how far behind a restarted member may be before the next
+	// one is restarted, and which replica the leader is switched over to.
+	// A zero MaxLagBytes means failover.DefaultMaxLagBytes.
+	Lag          failover.LagPolicy
+	Timeout      time.Duration // per-member wait for streaming
+	PollInterval time.Duration
+
+	// StatePath, if set, records progress after every member so that an
+	// interrupted run resumes where it stopped. The file is removed once
+	// the operation completes.
+	StatePath string
+
+	// OnEvent, if set, is called synchronously for every step event.
+	OnEvent func(StepEvent)
+}
+
+// RollingState is the persisted progress of a rolling operation.
+type RollingState struct {
+	Cluster   string    `json:"cluster"`
+	Kind      string    `json:"kind"`
+	Image     string    `json:"image,omitempty"`
+	Order     []string  `json:"order"`
+	Done      []string  `json:"done"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// RollingResult summarises a rolling operation.
+type RollingResult struct {
+	Cluster   string      `json:"cluster"`
+	Kind      string      `json:"kind"`
+	Image     string      `json:"image,omitempty"`
+	Order     []string    `json:"order"`
+	Restarted []string    `json:"restarted"`
+	Resumed   bool        `json:"resumed"`
+	Skipped   []string    `json:"skipped,omitempty"` // done by an earlier, interrupted run
+	Leader    string      `json:"leader"`
+	Events    []StepEvent `json:"events"`
+}
+
+// OperationStatePath returns the file in dir that records the progress of
+// operation op on clusterName.
+func OperationStatePath(dir, clusterName, op string) string {
+	return filepath.Join(dir, "operations", clusterName+"-"+op+".json")
+}
+
+// Rolling restarts every member of the cluster entry one at a time: replicas
+// first, in name order, then the leader after a switchover to the best
+// candidate. Each member is restarted through its own REST API
+// (Member.APIURL) or, for RollingUpgrade, recreated on opts.Image through p,
+// which must implement provider.ImageUpdater. After each member Rolling waits
+// for it to stream again within opts.Lag before moving on.
+func Rolling(ctx context.Context, p provider.Provider, client *patroni.Client, entry *cluster.Entry, opts RollingOptions) (*RollingResult, error) {
+	var updater provider.ImageUpdater
+	switch opts.Kind {
+	case RollingRestart:
+	case RollingUpgrade:
+		if opts.Image == "" {
+			return nil, fmt.Errorf("an image is required for a rolling upgrade")
+		}
+		var ok bool
+		if updater, ok = p.(provider.ImageUpdater); !ok {
+			return nil, fmt.Errorf("provider of cluster %q cannot update node images", entry.Name)
+		}
+	default:
+		return nil, fmt.Errorf("unknown rolling operation %q", opts.Kind)
+	}
+	if opts.Lag.MaxLagBytes == 0 {
+		opts.Lag.MaxLagBytes = failover.DefaultMaxLagBytes
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = defaultScaleTimeout
+	}
+	if opts.PollInterval == 0 {
+		opts.PollInterval = defaultPollInterval
+	}
+
+	state, err := loadRollingState(opts.StatePath)
+	if err != nil {
+		return nil, err
+	}
+	if state != nil && (state.Kind != opts.Kind || state.Image != opts.Image) {
+		return nil, fmt.Errorf("an interrupted %s (image %q) of cluster %q is pending; rerun it or remove %s",
+			state.Kind, state.Image, entry.Name, opts.StatePath)
+	}
+
+	result := &RollingResult{Cluster: entry.Name, Kind: opts.Kind, Image: opts.Image,
+		Restarted: []string{}, Events: []StepEvent{}}
+	rec := recorder{events: &result.Events, onEvent: opts.OnEvent}
+
+	cs, err := client.GetClusterStatus(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get cluster status: %w", err)
+	}
+	leader, err := failover.FindPrimary(cs)
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		state = &RollingState{Cluster: entry.Name, Kind: opts.Kind, Image: opts.Image,
+			Done: []string{}, StartedAt: time.Now().UTC()}
+		for _, m := range failover.ListReplicas(cs) {
+			state.Order = append(state.Order, m.Name)
+		}
+		sort.Strings(state.Order)
+		state.Order = append(state.Order, leader)
+		if err := saveRollingState(opts.StatePath, state); err != nil {
+			return nil, err
+		}
+	} else {
+		result.Resumed = true
+		result.Skipped = append([]string(nil), state.Done...)
+	}
+	result.Order = state.Order
+
+	done := map[string]bool{}
+	for _, name := range state.Done {
+		done[name] = true
+	}
+	for _, name := range state.Order {
+		if done[name] {
+			continue
+		}
+		if cs, err = client.GetClusterStatus(ctx); err != nil {
+			return result, fmt.Errorf("get cluster status: %w", err)
+		}
+		m, ok := findMember(cs, name)
+		if !ok {
+			return result, fmt.Errorf("member %s is no longer part of cluster %q", name, entry.Name)
+		}
+
+		if isLeaderRole(m.Role) {
+			if client, err = switchAway(ctx, client, cs, name, opts, rec); err != nil {
+				return result, err
+			}
+			if cs, err = client.GetClusterStatus(ctx); err != nil {
+				return result, fmt.Errorf("get cluster status: %w", err)
+			}
+			m, _ = findMember(cs, name)
+		}
+
+		startedBefore := postmasterStart(ctx, client, m)
+		rec.emit(StepRestart, name, StatusStarted, "")
+		if updater != nil {
+			err = updater.UpdateNodeImage(ctx, name, opts.Image)
+		} else {
+			var mc *patroni.Client
+			if mc, err = client.ForMember(m); err == nil {
+				err = mc.Restart(ctx)
+			}
+		}
+		if err != nil {
+			return result, rec.fail(StepRestart, name, err)
+		}
+		rec.emit(StepRestart, name, StatusDone, "")
+
+		rec.emit(StepWait, name, StatusStarted, "waiting for member to stream")
+		if err := waitCaughtUp(ctx, client, name, startedBefore, opts); err != nil {
+			return result, rec.fail(StepWait, name, err)
+		}
+		rec.emit(StepWait, name, StatusDone, "")
+
+		result.Restarted = append(result.Restarted, name)
+		state.Done = append(state.Done, name)
+		if err := saveRollingState(opts.StatePath, state); err != nil {
+			return result, err
+		}
+	}
+
+	if cs, err = client.GetClusterStatus(ctx); err == nil {
+		result.Leader, _ = failover.FindPrimary(cs)
+	}
+	if opts.StatePath != "" {
+		if err := os.Remove(opts.StatePath); err != nil && !os.IsNotExist(err) {
+			return result, fmt.Errorf("remove operation state: %w", err)
+		}
+	}
+	return result, nil
+}
+
+// switchAway hands leadership from leader to the best candidate and waits
+// for the change. It returns a client for the new leader, so that the rest of
+// the operation does not depend on the member about to be restarted.
+func switchAway(ctx context.Context, client *patroni.Client, cs *patroni.ClusterStatus, leader string,
+	opts RollingOptions, rec recorder) (*patroni.Client, error) {
+	candidate, err := failover.BestCandidate(failover.RankCandidates(cs, failover.ReplayLags(ctx, client, cs), opts.Lag))
+	if err != nil {
+		return nil, rec.fail(StepSwitchover, leader, err)
+	}
+	if err := failover.CheckSwitchover(cs, candidate, opts.Lag.MaxLagBytes); err != nil {
+		return nil, rec.fail(StepSwitchover, leader, err)
+	}
+	rec.emit(StepSwitchover, leader, StatusStarted, "switching over to "+candidate)
+	if err := client.Switchover(ctx, leader, candidate); err != nil {
+		return nil, rec.fail(StepSwitchover, leader, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+	for {
+		if cs, err := client.GetClusterStatus(ctx); err == nil {
+			if m, ok := findMember(cs, candidate); ok && isLeaderRole(m.Role) && m.State == patroni.StateRunning {
+				rec.emit(StepSwitchover, leader, StatusDone, candidate+" is the new leader")
+				if next, err := client.ForMember(m); err == nil {
+					return next, nil
+				}
+				return client, nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil, rec.fail(StepSwitchover, leader,
+				fmt.Errorf("%s did not become leader within %s", candidate, opts.Timeout))
+		case <-time.After(opts.PollInterval):
+		}
+	}
+}
+
+// waitCaughtUp polls until member has restarted and is a healthy replica
+// within the lag bounds of opts.Lag, or fails after opts.Timeout. Right
+// after an image update the DCS may still hold the member's last entry, so a
+// healthy state only counts once the member was seen in another state or
+// reports a postmaster start time other than startedBefore.
+func waitCaughtUp(ctx context.Context, client *patroni.Client, member, startedBefore string, opts RollingOptions) error {
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	restarted := startedBefore == ""
+	var lastErr error
+	for {
+		cs, err := client.GetClusterStatus(ctx)
+		if err == nil {
+			m, ok := findMember(cs, member)
+			if ok && !restarted && streamingOrRunning(m.State) {
+				started := postmasterStart(ctx, client, m)
+				restarted = started != "" && started != startedBefore
+			}
+			switch {
+			case !ok:
+				lastErr = fmt.Errorf("member not reported")
+			case !streamingOrRunning(m.State):
+				restarted = true
+				lastErr = fmt.Errorf("state %s", m.State)
+			case !restarted:
+				lastErr = fmt.Errorf("postmaster still started at %s", startedBefore)
+			case m.Lag > opts.Lag.MaxLagBytes:
+				lastErr = fmt.Errorf("lag %d bytes exceeds %d", m.Lag, opts.Lag.MaxLagBytes)
+			default:
+				if err := checkReplayLag(ctx, client, cs, member, opts.Lag.MaxLagSeconds); err != nil {
+					lastErr = err
+					break
+				}
+				return nil
+			}
+		} else {
+			lastErr = err
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("not caught up within %s: %w", opts.Timeout, lastErr)
+		case <-time.After(opts.PollInterval):
+		}
+	}
+}
+
+// checkReplayLag returns an error if member's replay lag exceeds
+// maxSeconds. A lag that cannot be measured passes, as in RankCandidates;
+// a zero maxSeconds leaves the lag unchecked.
+func checkReplayLag(ctx context.Context, client *patroni.Client, cs *patroni.ClusterStatus, member string, maxSeconds float64) error {
+	if maxSeconds <= 0 {
+		return nil
+	}
+	if lag, ok := failover.ReplayLags(ctx, client, cs)[member]; ok && lag > maxSeconds {
+		return fmt.Errorf("replay lag %.1fs exceeds %.1fs", lag, maxSeconds)
+	}
+	return nil
+}
+
+// postmasterStart returns the postmaster start time member m reports, or ""
+// if it cannot be read.
+func postmasterStart(ctx context.Context, client *patroni.Client, m patroni.Member) string {
+	mc, err := client.ForMember(m)
+	if err != nil {
+		return ""
+	}
+	info, err := mc.GetNodeInfo(ctx)
+	if err != nil {
+		return ""
+	}
+	return info.PostmasterStartTime
+}
+
+func findMember(cs *patroni.ClusterStatus, name string) (patroni.Member, bool) {
+	for _, m := range cs.Members {
+		if m.Name == name {
+			return m, true
+		}
+	}
+	return patroni.Member{}, false
+}
+
+// loadRollingState reads the state file at path; a missing file (or an
+// empty path) yields nil.
+func loadRollingState(path string) (*RollingState, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read operation state %s: %w", path, err)
+	}
+	var st RollingState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, fmt.Errorf("parse operation state %s: %w", path, err)
+	}
+	return &st, nil
+}
+
+func saveRollingState(path string, st *RollingState) error {
+	if path == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("create operation state dir: %w", err)
+	}
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal operation state: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("write operation state %s: %w", path, err)
+	}
+	return nil
+}
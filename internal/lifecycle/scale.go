@@ -0,0 +1,390 @@
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/luckyjian/pgdba/internal/cluster"
+	"github.com/luckyjian/pgdba/internal/failover"
+	"github.com/luckyjian/pgdba/internal/patroni"
+	"github.com/luckyjian/pgdba/internal/provider"
+)
+
+// PatroniNamespace is the DCS namespace of the rendered Patroni config.
+const PatroniNamespace = "/db"
+
+// Scale step names reported in StepEvent.Step.
+const (
+	StepWait       = "wait"
+	StepRemove     = "remove"
+	StepDCSCleanup = "dcs_cleanup"
+)
+
+const defaultScaleTimeout = 10 * time.Minute
+
+// ScaleOptions describes the desired replica count of a managed cluster.
+type ScaleOptions struct {
+	// Replicas is the number of replicas besides the leader.
+	Replicas int
+	// Hosts are the advertised addresses of added members, in order;
+	// missing entries default to the node name.
+	Hosts []string
+	// Passwords is called only when members are added.
+	Passwords func() (Passwords, error)
+
+	Timeout      time.Duration // how long to wait for new members to stream
+	PollInterval time.Duration
+
+	// OnEvent, if set, is called synchronously for every step event.
+	OnEvent func(StepEvent)
+}
+
+// ScaleResult summarises a scale operation.
+type ScaleResult struct {
+	Cluster string      `json:"cluster"`
+	From    int         `json:"from_replicas"`
+	To      int         `json:"to_replicas"`
+	Added   []string    `json:"added"`
+	Removed []string    `json:"removed"`
+	Events  []StepEvent `json:"events"`
+}
+
+// MemberKey returns the DCS key Patroni keeps for member in cluster scope.
+func MemberKey(scope, member string) string {
+	return fmt.Sprintf("%s/%s/members/%s", PatroniNamespace, scope, member)
+}
+
+// Scale grows or shrinks the managed cluster entry to opts.Replicas replicas.
+// New members are provisioned through p and must reach the streaming state
+// before Scale returns. Removed members are chosen by ScaleDownVictims, or
+// on kubernetes from the highest ordinal down; their nodes are destroyed and
+// their Patroni member keys deleted from etcd.
+func Scale(ctx context.Context, p provider.Provider, client *patroni.Client, entry *cluster.Entry, opts ScaleOptions) (*ScaleResult, error) {
+	if entry.Source != cluster.SourceManaged {
+		return nil, fmt.Errorf("cluster %q is not managed by pgdba; cannot scale it", entry.Name)
+	}
+	if opts.Replicas < 0 {
+		return nil, fmt.Errorf("replica count must not be negative")
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = defaultScaleTimeout
+	}
+	if opts.PollInterval == 0 {
+		opts.PollInterval = defaultPollInterval
+	}
+
+	cs, err := client.GetClusterStatus(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get cluster status: %w", err)
+	}
+	if _, err := failover.FindPrimary(cs); err != nil {
+		return nil, err
+	}
+	current := len(failover.ListReplicas(cs))
+	result := &ScaleResult{Cluster: entry.Name, From: current, To: opts.Replicas,
+		Added: []string{}, Removed: []string{}, Events: []StepEvent{}}
+	rec := recorder{events: &result.Events, onEvent: opts.OnEvent}
+
+	nodes, err := ClusterNodes(ctx, p, entry.Name)
+	if err != nil {
+		return result, err
+	}
+
+	switch {
+	case opts.Replicas > current:
+		return result, scaleUp(ctx, p, client, entry, nodes, cs, opts, rec, result)
+	case opts.Replicas < current:
+		return result, scaleDown(ctx, p, entry, nodes, cs, current-opts.Replicas, rec, result)
+	}
+	return result, nil
+}
+
+func scaleUp(ctx context.Context, p provider.Provider, client *patroni.Client, entry *cluster.Entry,
+	nodes []provider.NodeStatus, cs *patroni.ClusterStatus, opts ScaleOptions, rec recorder, result *ScaleResult) error {
+	if opts.Passwords == nil {
+		return fmt.Errorf("passwords are required to add members")
+	}
+	pw, err := opts.Passwords()
+	if err != nil {
+		return err
+	}
+	tmpl, err := TemplateFor(entry, nodes, pw)
+	if err != nil {
+		return err
+	}
+
+	next := nextPGOrdinal(entry.Name, nodes, cs)
+	members := make([]string, opts.Replicas-result.From)
+	hosts := map[string]string{}
+	for i := range members {
+		members[i] = PGNodeName(entry.Name, next+i)
+		hosts[members[i]] = hostAt(opts.Hosts, i, members[i])
+	}
+	added, err := addMembers(ctx, p, tmpl, members, hosts, rec)
+	result.Added = append(result.Added, added...)
+	if err != nil {
+		return err
+	}
+
+	rec.emit(StepWait, "", StatusStarted, "waiting for new members to stream")
+	if err := WaitForStreaming(ctx, client, result.Added, opts.Timeout, opts.PollInterval); err != nil {
+		return rec.fail(StepWait, "", err)
+	}
+	rec.emit(StepWait, "", StatusDone, "")
+	return nil
+}
+
+func scaleDown(ctx context.Context, p provider.Provider, entry *cluster.Entry,
+	nodes []provider.NodeStatus, cs *patroni.ClusterStatus, count int, rec recorder, result *ScaleResult) error {
+	var victims []string
+	var err error
+	if p.Type() == "kubernetes" {
+		victims, err = highestOrdinals(entry.Name, cs, count)
+	} else {
+		victims, err = ScaleDownVictims(cs, count)
+	}
+	if err != nil {
+		return err
+	}
+	removed, err := removeMembers(ctx, p, entry.Name, nodes, victims, rec)
+	result.Removed = append(result.Removed, removed...)
+	return err
+}
+
+// AddMembers provisions members as replicas rendered from tmpl, lowest
+// ordinal first, the order StatefulSets scale up in. hosts maps a member to
+// its advertised address; members without one advertise their node name.
+// It returns the members created before any failure and does not wait for
+// them to stream.
+func AddMembers(ctx context.Context, p provider.Provider, tmpl MemberTemplate, members []string,
+	hosts map[string]string, events *[]StepEvent) ([]string, error) {
+	return addMembers(ctx, p, tmpl, members, hosts, recorder{events: events})
+}
+
+// RemoveMembers destroys the nodes of members, highest ordinal first, and
+// deletes their Patroni member keys from the DCS of scope through an etcd
+// node among nodes, so that Patroni stops listing them. It returns the
+// members removed before any failure.
+func RemoveMembers(ctx context.Context, p provider.Provider, scope string, nodes []provider.NodeStatus,
+	members []string, events *[]StepEvent) ([]string, error) {
+	return removeMembers(ctx, p, scope, nodes, members, recorder{events: events})
+}
+
+func addMembers(ctx context.Context, p provider.Provider, tmpl MemberTemplate, members []string,
+	hosts map[string]string, rec recorder) ([]string, error) {
+	members = sortByOrdinal(members, false)
+	added := []string{}
+	for _, node := range members {
+		rec.emit(StepReplica, node, StatusStarted, "")
+		host := hosts[node]
+		if host == "" {
+			host = node
+		}
+		cfg, err := PatroniNode(tmpl, node, host, provider.RoleStandby)
+		if err != nil {
+			return added, rec.fail(StepReplica, node, err)
+		}
+		if _, err := p.CreateNode(ctx, cfg); err != nil {
+			return added, rec.fail(StepReplica, node, err)
+		}
+		added = append(added, node)
+		rec.emit(StepReplica, node, StatusDone, "")
+	}
+	return added, nil
+}
+
+func removeMembers(ctx context.Context, p provider.Provider, scope string, nodes []provider.NodeStatus,
+	members []string, rec recorder) ([]string, error) {
+	removed := []string{}
+	etcdNode, err := runningEtcdNode(nodes)
+	if err != nil {
+		return removed, err
+	}
+	for _, name := range sortByOrdinal(members, true) {
+		rec.emit(StepRemove, name, StatusStarted, "")
+		if err := p.DestroyNode(ctx, name); err != nil {
+			return removed, rec.fail(StepRemove, name, err)
+		}
+		removed = append(removed, name)
+		rec.emit(StepRemove, name, StatusDone, "")
+
+		key := MemberKey(scope, name)
+		rec.emit(StepDCSCleanup, name, StatusStarted, key)
+		if _, err := p.ExecOnNode(ctx, etcdNode, []string{"etcdctl", "del", key}); err != nil {
+			return removed, rec.fail(StepDCSCleanup, name, err)
+		}
+		rec.emit(StepDCSCleanup, name, StatusDone, "")
+	}
+	return removed, nil
+}
+
+// sortByOrdinal returns a sorted copy of node names, by name prefix and then
+// numeric ordinal, descending if desc.
+func sortByOrdinal(names []string, desc bool) []string {
+	sorted := append([]string(nil), names...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		gi, oi := splitOrdinal(sorted[i])
+		gj, oj := splitOrdinal(sorted[j])
+		if gi != gj {
+			return (gi < gj) != desc
+		}
+		return (oi < oj) != desc
+	})
+	return sorted
+}
+
+// PGOrdinal returns the ordinal of node among the Patroni nodes of
+// clusterName (as named by PGNodeName), or -1 if node is not one of them.
+func PGOrdinal(clusterName, node string) int {
+	group, _ := splitOrdinal(PGNodeName(clusterName, 0))
+	if g, n := splitOrdinal(node); g == group {
+		return n
+	}
+	return -1
+}
+
+// ScaleDownVictims picks count replicas to remove, most lagged first.
+// Members that are neither running nor streaming count as the most lagged.
+// The leader is never picked; while any replica is kept, neither are
+// synchronous standbys and the best failover candidate among the others.
+func ScaleDownVictims(cs *patroni.ClusterStatus, count int) ([]string, error) {
+	replicas := failover.ListReplicas(cs)
+	if count > len(replicas) {
+		return nil, fmt.Errorf("cannot remove %d of %d replicas", count, len(replicas))
+	}
+	keepCandidates := count < len(replicas)
+	async := &patroni.ClusterStatus{}
+	for _, m := range cs.Members {
+		if !failover.IsSyncStandby(m.Role) {
+			async.Members = append(async.Members, m)
+		}
+	}
+	best, _ := failover.FindBestCandidate(async)
+
+	var pool []patroni.Member
+	for _, m := range replicas {
+		if keepCandidates && (m.Name == best || failover.IsSyncStandby(m.Role)) {
+			continue
+		}
+		pool = append(pool, m)
+	}
+	if count > len(pool) {
+		return nil, fmt.Errorf("only %d replica(s) can be removed without losing a failover candidate, %d requested",
+			len(pool), count)
+	}
+	sort.SliceStable(pool, func(i, j int) bool {
+		hi, hj := streamingOrRunning(pool[i].State), streamingOrRunning(pool[j].State)
+		if hi != hj {
+			return hj
+		}
+		return pool[i].Lag > pool[j].Lag
+	})
+	victims := make([]string, count)
+	for i := range victims {
+		victims[i] = pool[i].Name
+	}
+	return victims, nil
+}
+
+// highestOrdinals picks the count members of clusterName with the highest
+// ordinals, the only ones a StatefulSet can remove, and refuses when the
+// leader is among them rather than removing other members instead.
+func highestOrdinals(clusterName string, cs *patroni.ClusterStatus, count int) ([]string, error) {
+	var names []string
+	for _, m := range cs.Members {
+		if PGOrdinal(clusterName, m.Name) >= 0 {
+			names = append(names, m.Name)
+		}
+	}
+	if count >= len(names) {
+		return nil, fmt.Errorf("cannot remove %d of %d members", count, len(names))
+	}
+	victims := sortByOrdinal(names, true)[:count]
+	leader, _ := failover.FindPrimary(cs)
+	for _, v := range victims {
+		if v == leader {
+			return nil, fmt.Errorf("cannot remove %s: kubernetes removes the highest ordinals first and it is the leader; "+
+				"switch over to a lower ordinal first", v)
+		}
+	}
+	return victims, nil
+}
+
+// WaitForStreaming polls Patroni until every named member reports the
+// streaming state, or fails after timeout. Patroni versions before 3.0
+// report a streaming replica as running, which counts too.
+func WaitForStreaming(ctx context.Context, client *patroni.Client, members []string, timeout, interval time.Duration) error {
+	return waitForState(ctx, client, members, streamingOrRunning, "streaming", timeout, interval)
+}
+
+// waitForState polls Patroni until the state of every named member
+// satisfies ok, or fails after timeout; want describes ok in errors.
+func waitForState(ctx context.Context, client *patroni.Client, members []string, ok func(patroni.NodeState) bool,
+	want string, timeout, interval time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var lastErr error
+	for {
+		cs, err := client.GetClusterStatus(ctx)
+		if err == nil {
+			state := map[string]patroni.NodeState{}
+			for _, m := range cs.Members {
+				state[m.Name] = m.State
+			}
+			var pending []string
+			for _, name := range members {
+				if !ok(state[name]) {
+					pending = append(pending, name)
+				}
+			}
+			if len(pending) == 0 {
+				return nil
+			}
+			lastErr = fmt.Errorf("not %s yet: %v", want, pending)
+		} else {
+			lastErr = err
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("members not %s within %s: %w", want, timeout, lastErr)
+		case <-time.After(interval):
+		}
+	}
+}
+
+// nextPGOrdinal returns the ordinal after the highest Patroni member ordinal
+// known to the provider or to Patroni.
+func nextPGOrdinal(clusterName string, nodes []provider.NodeStatus, cs *patroni.ClusterStatus) int {
+	group, _ := splitOrdinal(PGNodeName(clusterName, 0))
+	next := 0
+	consider := func(name string) {
+		if g, n := splitOrdinal(name); g == group && n >= next {
+			next = n + 1
+		}
+	}
+	for _, n := range nodes {
+		consider(n.ID)
+	}
+	for _, m := range cs.Members {
+		consider(m.Name)
+	}
+	return next
+}
+
+// runningEtcdNode returns the first running etcd node in nodes, where
+// etcdctl can be run against the cluster's DCS.
+func runningEtcdNode(nodes []provider.NodeStatus) (string, error) {
+	for _, n := range nodes {
+		if n.Role == provider.RoleEtcd && n.Running {
+			return n.ID, nil
+		}
+	}
+	return "", fmt.Errorf("no running etcd node to run etcdctl on")
+}
+
+func streamingOrRunning(s patroni.NodeState) bool {
+	return s == patroni.StateStreaming || s == patroni.StateRunning
+}
@@ -0,0 +1,190 @@
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/luckyjian/pgdba/internal/cluster"
+	"github.com/luckyjian/pgdba/internal/provider"
+)
+
+// Teardown step names reported in StepEvent.Step.
+const (
+	StepList       = "list"
+	StepDestroy    = "destroy"
+	StepPurge      = "purge"
+	StepUnregister = "unregister"
+)
+
+// TeardownOptions controls how a managed cluster is destroyed.
+type TeardownOptions struct {
+	// PurgeData also deletes node data (volumes, claims). The provider must
+	// implement provider.DataPurger.
+	PurgeData bool
+	// Force removes the registry entry even when some nodes could not be
+	// listed or destroyed.
+	Force bool
+	// OnEvent, if set, is called synchronously for every step event.
+	OnEvent func(StepEvent)
+}
+
+// NodeTeardown is the per-node outcome of a teardown.
+type NodeTeardown struct {
+	Node       string `json:"node"`
+	Role       string `json:"role,omitempty"`
+	Destroyed  bool   `json:"destroyed"`
+	DataPurged bool   `json:"data_purged"`
+	Error      string `json:"error,omitempty"`
+}
+
+// TeardownResult summarises a teardown.
+type TeardownResult struct {
+	Cluster         string         `json:"cluster"`
+	Provider        string         `json:"provider"`
+	Nodes           []NodeTeardown `json:"nodes"`
+	Failed          int            `json:"failed"`
+	RegistryRemoved bool           `json:"registry_removed"`
+	Forced          bool           `json:"forced,omitempty"`
+	Events          []StepEvent    `json:"events"`
+}
+
+// Teardown destroys every node of the managed cluster name that p reports
+// with a matching provider.LabelCluster label, optionally purging node data,
+// and then removes the registry entry. Nodes are destroyed best-effort: a
+// failure is recorded and the remaining nodes are still processed. The
+// registry entry is kept when any node fails unless opts.Force is set.
+// Nodes whose owner p cannot tell are never touched: Teardown refuses while
+// there are any, and with opts.Force leaves them and counts a failure.
+func Teardown(ctx context.Context, p provider.Provider, reg *cluster.Registry, name string, opts TeardownOptions) (*TeardownResult, error) {
+	entry, err := reg.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	if entry.Source == cluster.SourceExternal {
+		return nil, fmt.Errorf("cluster %q was connected with 'cluster connect' and is not managed by pgdba; refusing to destroy", name)
+	}
+	purger, canPurge := p.(provider.DataPurger)
+	if opts.PurgeData && !canPurge {
+		return nil, fmt.Errorf("%s provider cannot purge node data; rerun without --purge-data", p.Type())
+	}
+
+	result := &TeardownResult{Cluster: name, Provider: p.Type(), Nodes: []NodeTeardown{}, Events: []StepEvent{}, Forced: opts.Force}
+	rec := recorder{events: &result.Events, onEvent: opts.OnEvent}
+
+	rec.emit(StepList, "", StatusStarted, "")
+	nodes, unknown, err := clusterNodes(ctx, p, name)
+	if err == nil && len(unknown) > 0 {
+		err = fmt.Errorf("cannot tell whether %s belong to %q", strings.Join(unknown, ", "), name)
+	}
+	if err != nil {
+		err = rec.fail(StepList, "", err)
+		if !opts.Force {
+			return result, fmt.Errorf("%w; registry entry kept (use --force to remove it anyway)", err)
+		}
+		result.Failed++
+	} else {
+		rec.emit(StepList, "", StatusDone, fmt.Sprintf("%d nodes", len(nodes)))
+	}
+
+	for _, n := range TeardownOrder(nodes) {
+		nt := NodeTeardown{Node: n.ID, Role: string(n.Role)}
+		rec.emit(StepDestroy, n.ID, StatusStarted, "")
+		if err := p.DestroyNode(ctx, n.ID); err != nil {
+			nt.Error = rec.fail(StepDestroy, n.ID, err).Error()
+			result.Nodes = append(result.Nodes, nt)
+			result.Failed++
+			continue
+		}
+		nt.Destroyed = true
+		rec.emit(StepDestroy, n.ID, StatusDone, "")
+
+		if opts.PurgeData {
+			rec.emit(StepPurge, n.ID, StatusStarted, "")
+			if err := purger.PurgeNodeData(ctx, n.ID); err != nil {
+				nt.Error = rec.fail(StepPurge, n.ID, err).Error()
+				result.Failed++
+			} else {
+				nt.DataPurged = true
+				rec.emit(StepPurge, n.ID, StatusDone, "")
+			}
+		}
+		result.Nodes = append(result.Nodes, nt)
+	}
+
+	if result.Failed > 0 && !opts.Force {
+		return result, fmt.Errorf("teardown of %q incomplete: %d failure(s); registry entry kept (use --force to remove it anyway)",
+			name, result.Failed)
+	}
+
+	rec.emit(StepUnregister, "", StatusStarted, "")
+	if err := reg.Remove(name); err != nil {
+		return result, rec.fail(StepUnregister, "", fmt.Errorf("remove from registry: %w", err))
+	}
+	result.RegistryRemoved = true
+	rec.emit(StepUnregister, "", StatusDone, "")
+	return result, nil
+}
+
+// ClusterNodes returns the nodes p reports for clusterName, identified by the
+// provider.LabelCluster label.
+func ClusterNodes(ctx context.Context, p provider.Provider, clusterName string) ([]provider.NodeStatus, error) {
+	nodes, _, err := clusterNodes(ctx, p, clusterName)
+	return nodes, err
+}
+
+// clusterNodes is ClusterNodes that also returns the IDs of the nodes
+// labelled provider.LabelOwnerUnknown, which may belong to clusterName.
+func clusterNodes(ctx context.Context, p provider.Provider, clusterName string) ([]provider.NodeStatus, []string, error) {
+	all, err := p.ListNodes(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("list nodes: %w", err)
+	}
+	var nodes []provider.NodeStatus
+	var unknown []string
+	for _, n := range all {
+		switch {
+		case n.Labels[provider.LabelCluster] == clusterName:
+			nodes = append(nodes, n)
+		case n.Labels[provider.LabelOwnerUnknown] == "true":
+			unknown = append(unknown, n.ID)
+		}
+	}
+	return nodes, unknown, nil
+}
+
+// TeardownOrder sorts nodes for removal: Patroni and PgBouncer nodes before
+// the etcd quorum, and within a node group the highest ordinal first (the
+// order StatefulSets scale down in).
+func TeardownOrder(nodes []provider.NodeStatus) []provider.NodeStatus {
+	sorted := append([]provider.NodeStatus(nil), nodes...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		ei, ej := sorted[i].Role == provider.RoleEtcd, sorted[j].Role == provider.RoleEtcd
+		if ei != ej {
+			return ej
+		}
+		gi, oi := splitOrdinal(sorted[i].ID)
+		gj, oj := splitOrdinal(sorted[j].ID)
+		if gi != gj {
+			return gi > gj
+		}
+		return oi > oj
+	})
+	return sorted
+}
+
+// splitOrdinal splits "prod-pg-10" into ("prod-pg", 10); names without a
+// numeric suffix get ordinal -1.
+func splitOrdinal(name string) (string, int) {
+	i := strings.LastIndex(name, "-")
+	if i < 0 {
+		return name, -1
+	}
+	n, err := strconv.Atoi(name[i+1:])
+	if err != nil {
+		return name, -1
+	}
+	return name[:i], n
+}
@@ -0,0 +1,597 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/luckyjian/pgdba/internal/cluster"
+	"github.com/luckyjian/pgdba/internal/failover"
+	"github.com/luckyjian/pgdba/internal/patroni"
+	"github.com/luckyjian/pgdba/internal/provider"
+	"github.com/luckyjian/pgdba/internal/upgrade"
+)
+
+// Major upgrade step names reported in StepEvent.Step. The link method runs
+// StepUpgradeCheck through StepAnalyze, and StepRollback if it fails before
+// pg_upgrade completes; the logical method bootstraps the new cluster
+// (StepTarget) and runs StepSchema through StepCleanup.
+const (
+	StepUpgradeCheck = "upgrade_check"
+	StepPause        = "pause"
+	StepStop         = "stop"
+	StepPgUpgrade    = "pg_upgrade"
+	StepConfig       = "config"
+	StepDCSReset     = "dcs_reset"
+	StepWipe         = "wipe"
+	StepResume       = "resume"
+	StepReclone      = "reclone"
+	StepAnalyze      = "analyze"
+
+	StepTarget    = "target"
+	StepSchema    = "schema"
+	StepPublish   = "publish"
+	StepSubscribe = "subscribe"
+	StepSync      = "sync"
+	StepCutover   = "cutover"
+	StepSequences = "sequences"
+	StepCleanup   = "cleanup"
+)
+
+// upgradeWorkDir is the directory, inside the data directory, that holds the
+// new and old clusters while pg_upgrade runs. Keeping it on the data volume
+// lets pg_upgrade --link hard-link the data files.
+const upgradeWorkDir = ".pgdba-upgrade"
+
+// logicalName names the publication, subscriptions and (with a database
+// ordinal suffix) replication slots of a logical upgrade.
+const logicalName = "pgdba_upgrade"
+
+// MajorUpgradeOptions describes a major version upgrade.
+type MajorUpgradeOptions struct {
+	From   int    // running major version
+	To     int    // target major version
+	Method string // upgrade.MethodLink or upgrade.MethodLogical
+
+	// Image, for the link method, is an image carrying both the old and the
+	// new binaries; members are rolled onto it before pg_upgrade runs. For
+	// the logical method it is the image of the new cluster.
+	Image string
+
+	// Databases are replicated by the logical method.
+	Databases []string
+	// Target describes the new cluster of the logical method. PGVersion,
+	// Image, Passwords and OnEvent are filled in from these options.
+	Target BootstrapOptions
+	// Passwords are used by the logical method to bootstrap the new cluster
+	// and to connect to both clusters as the superuser.
+	Passwords Passwords
+
+	// Lag bounds the replication lag of the rolling upgrade that moves the
+	// link method's members onto Image (see RollingOptions.Lag).
+	Lag failover.LagPolicy
+
+	Timeout      time.Duration // per wait: leader, re-clone, sync, catch-up
+	PollInterval time.Duration
+
+	// OnEvent, if set, is called synchronously for every step event.
+	OnEvent func(StepEvent)
+}
+
+// MajorUpgradeResult summarises a major version upgrade.
+type MajorUpgradeResult struct {
+	Cluster string      `json:"cluster"`
+	Method  string      `json:"method"`
+	From    int         `json:"from"`
+	To      int         `json:"to"`
+	Leader  string      `json:"leader,omitempty"`
+	Target  string      `json:"target,omitempty"` // new cluster of the logical method
+	Events  []StepEvent `json:"events"`
+}
+
+// majorUpgrade carries the state of one UpgradeMajor call.
+type majorUpgrade struct {
+	recorder
+	p      provider.Provider
+	reg    *cluster.Registry
+	client *patroni.Client
+	entry  *cluster.Entry
+	opts   MajorUpgradeOptions
+	result *MajorUpgradeResult
+}
+
+// UpgradeMajor moves the cluster entry from major version opts.From to
+// opts.To. Readiness (upgrade.Check) is the caller's responsibility.
+//
+// The link method runs pg_upgrade --link on the leader while Patroni is
+// paused, points every member at the new binaries, resets the DCS
+// initialize key and re-clones the replicas from the upgraded leader. It
+// needs a managed cluster whose nodes carry both versions' binaries and a
+// writable patroni.yml, which rules out Kubernetes. A failure before
+// pg_upgrade completes restores the old data directory, starts the old
+// binaries and resumes Patroni.
+//
+// The logical method bootstraps opts.Target on the new version, copies the
+// roles and schema, replicates opts.Databases through a publication and
+// subscriptions, and cuts over by making the old cluster read-only, ending
+// its client sessions, waiting for the subscriptions to catch up and copying
+// sequence values. The old cluster is
+// left read-only; clients must be pointed at the new one.
+func UpgradeMajor(ctx context.Context, p provider.Provider, reg *cluster.Registry, client *patroni.Client,
+	entry *cluster.Entry, opts MajorUpgradeOptions) (*MajorUpgradeResult, error) {
+	if opts.To <= opts.From {
+		return nil, fmt.Errorf("target version %d is not newer than %d", opts.To, opts.From)
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = defaultScaleTimeout
+	}
+	if opts.PollInterval == 0 {
+		opts.PollInterval = defaultPollInterval
+	}
+	u := &majorUpgrade{p: p, reg: reg, client: client, entry: entry, opts: opts,
+		result: &MajorUpgradeResult{Cluster: entry.Name, Method: opts.Method, From: opts.From, To: opts.To,
+			Events: []StepEvent{}}}
+	u.recorder = recorder{events: &u.result.Events, onEvent: opts.OnEvent}
+
+	switch opts.Method {
+	case upgrade.MethodLink:
+		if entry.Source != cluster.SourceManaged {
+			return nil, fmt.Errorf("cluster %q is not managed by pgdba; the link method needs access to its nodes", entry.Name)
+		}
+		if p.Type() == "kubernetes" {
+			return nil, fmt.Errorf("the link method rewrites patroni.yml, which kubernetes rebuilds from a Secret when a pod restarts; use --method %s",
+				upgrade.MethodLogical)
+		}
+		return u.result, u.link(ctx)
+	case upgrade.MethodLogical:
+		if opts.Target.ClusterName == "" {
+			return nil, fmt.Errorf("a target cluster is required for the logical method")
+		}
+		if len(opts.Databases) == 0 {
+			return nil, fmt.Errorf("no databases to replicate")
+		}
+		return u.result, u.logical(ctx)
+	}
+	return nil, fmt.Errorf("unknown upgrade method %q", opts.Method)
+}
+
+// link runs the pg_upgrade --link method.
+func (u *majorUpgrade) link(ctx context.Context) (err error) {
+	if u.opts.Image != "" {
+		rr, err := Rolling(ctx, u.p, u.client, u.entry, RollingOptions{Kind: RollingUpgrade, Image: u.opts.Image,
+			Lag: u.opts.Lag, Timeout: u.opts.Timeout, PollInterval: u.opts.PollInterval, OnEvent: u.onEvent})
+		if rr != nil {
+			*u.events = append(*u.events, rr.Events...)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	cs, err := u.client.GetClusterStatus(ctx)
+	if err != nil {
+		return fmt.Errorf("get cluster status: %w", err)
+	}
+	leader, err := failover.FindPrimary(cs)
+	if err != nil {
+		return err
+	}
+	replicas := failover.ListReplicas(cs)
+	nodes, err := ClusterNodes(ctx, u.p, u.entry.Name)
+	if err != nil {
+		return err
+	}
+	etcdNode, err := runningEtcdNode(nodes)
+	if err != nil {
+		return err
+	}
+
+	data := u.entry.DataDir
+	if data == "" {
+		data = DefaultDataDir
+	}
+	port := u.entry.PGPort
+	if port == 0 {
+		port = 5432
+	}
+	oldBin, newBin := patroni.BinDir(u.opts.From), patroni.BinDir(u.opts.To)
+	work := data + "/" + upgradeWorkDir
+	oldData, newData := fmt.Sprintf("%s/%d", work, u.opts.From), fmt.Sprintf("%s/%d", work, u.opts.To)
+	pgUpgrade := fmt.Sprintf("%s/pg_upgrade -U postgres -b %s -B %s", newBin, oldBin, newBin)
+
+	// initdb and pg_upgrade --check run against the live leader first, so
+	// that a missing binary or incompatibility stops the upgrade before any
+	// member is stopped.
+	if err := u.sh(ctx, StepUpgradeCheck, leader, fmt.Sprintf(
+		"set -e; rm -rf %[1]s; mkdir -p %[1]s; %[2]s/initdb -D %[3]s -U postgres --encoding=UTF8 --data-checksums; "+
+			"cd %[1]s; %[4]s --check -p %[5]d -d %[6]s -D %[3]s",
+		work, newBin, newData, pgUpgrade, port, data)); err != nil {
+		return err
+	}
+
+	u.emit(StepPause, "", StatusStarted, "")
+	if err := u.client.SetPause(ctx, true); err != nil {
+		return u.fail(StepPause, "", err)
+	}
+	u.emit(StepPause, "", StatusDone, "")
+
+	// Until pg_upgrade has linked the data files the old cluster is intact;
+	// put it back in service if anything fails before then.
+	var stopped []string
+	upgraded := false
+	defer func() {
+		if err != nil && !upgraded {
+			err = u.rollbackLink(err, stopped, data, oldData, work, oldBin)
+		}
+	}()
+
+	// Replicas stop first so they have received everything the leader wrote.
+	for _, m := range replicas {
+		if err := u.sh(ctx, StepStop, m.Name, fmt.Sprintf("%s/pg_ctl stop -D %s -m fast", oldBin, data)); err != nil {
+			return err
+		}
+		stopped = append(stopped, m.Name)
+	}
+	if err := u.sh(ctx, StepStop, leader, fmt.Sprintf("%s/pg_ctl stop -D %s -m fast", oldBin, data)); err != nil {
+		return err
+	}
+	stopped = append([]string{leader}, stopped...)
+
+	if err := u.sh(ctx, StepPgUpgrade, leader, fmt.Sprintf(
+		"set -e; mkdir -p %[1]s; mv %[2]s/* %[1]s/; cd %[3]s; %[4]s --link -d %[1]s -D %[5]s",
+		oldData, data, work, pgUpgrade, newData)); err != nil {
+		return err
+	}
+	upgraded = true
+	if err := u.sh(ctx, StepPgUpgrade, leader, fmt.Sprintf("mv %s/* %s/", newData, data)); err != nil {
+		return err
+	}
+
+	members := append([]patroni.Member{}, replicas...)
+	if m, ok := findMember(cs, leader); ok {
+		members = append(members, m)
+	}
+	for _, m := range members {
+		u.emit(StepConfig, m.Name, StatusStarted, "bin_dir "+newBin)
+		if _, err := u.p.ExecOnNode(ctx, m.Name, []string{"sh", "-c", fmt.Sprintf(
+			`for f in %s %s; do if [ -f "$f" ]; then sed -i 's#%s#%s#' "$f"; fi; done`,
+			PatroniConfigPath, SpiloConfigPath, oldBin, newBin)}); err != nil {
+			return u.fail(StepConfig, m.Name, err)
+		}
+		mc, err := u.client.ForMember(m)
+		if err == nil {
+			err = mc.Reload(ctx)
+		}
+		if err != nil {
+			return u.fail(StepConfig, m.Name, err)
+		}
+		u.emit(StepConfig, m.Name, StatusDone, "")
+	}
+
+	// The upgraded leader has a new system identifier; Patroni records it
+	// again once the initialize key is gone.
+	key := fmt.Sprintf("%s/%s/initialize", PatroniNamespace, u.entry.Name)
+	u.emit(StepDCSReset, "", StatusStarted, key)
+	if _, err := u.p.ExecOnNode(ctx, etcdNode, []string{"etcdctl", "del", key}); err != nil {
+		return u.fail(StepDCSReset, "", err)
+	}
+	u.emit(StepDCSReset, "", StatusDone, "")
+
+	for _, m := range replicas {
+		if err := u.sh(ctx, StepWipe, m.Name, fmt.Sprintf("find %s -mindepth 1 -delete", data)); err != nil {
+			return err
+		}
+	}
+
+	u.emit(StepResume, "", StatusStarted, "")
+	if err := u.client.SetPause(ctx, false); err != nil {
+		return u.fail(StepResume, "", err)
+	}
+	if u.result.Leader, err = WaitForLeader(ctx, u.client, u.opts.Timeout, u.opts.PollInterval); err != nil {
+		return u.fail(StepResume, "", err)
+	}
+	u.emit(StepResume, u.result.Leader, StatusDone, "")
+
+	// The old cluster cannot be started again once the new one has run;
+	// drop it before replicas clone the data directory.
+	if err := u.sh(ctx, StepCleanup, leader, "rm -rf "+work); err != nil {
+		return err
+	}
+
+	var names []string
+	for _, m := range replicas {
+		names = append(names, m.Name)
+	}
+	if len(names) > 0 {
+		u.emit(StepReclone, "", StatusStarted, "waiting for replicas to clone from the upgraded leader")
+		if err := WaitForStreaming(ctx, u.client, names, u.opts.Timeout, u.opts.PollInterval); err != nil {
+			return u.fail(StepReclone, "", err)
+		}
+		u.emit(StepReclone, "", StatusDone, "")
+	}
+
+	if err := u.sh(ctx, StepAnalyze, leader, fmt.Sprintf(
+		"%s/vacuumdb -U postgres -p %d --all --analyze-in-stages", newBin, port)); err != nil {
+		return err
+	}
+
+	u.entry.PGVersion = u.opts.To
+	if u.opts.Image != "" {
+		u.entry.Image = u.opts.Image
+	}
+	if err := u.reg.Add(*u.entry); err != nil {
+		return fmt.Errorf("write registry: %w", err)
+	}
+	return nil
+}
+
+// rollbackLink puts the old cluster back in service after a link upgrade
+// failed with cause before pg_upgrade completed: it moves the old data
+// directory back into place on the leader (the first stopped member), starts
+// the old binaries on the stopped members and resumes Patroni.
+func (u *majorUpgrade) rollbackLink(cause error, stopped []string, data, oldData, work, oldBin string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), u.opts.Timeout)
+	defer cancel()
+
+	u.emit(StepRollback, "", StatusStarted, "")
+	var errs []error
+	if len(stopped) > 0 {
+		// pg_upgrade --link renames pg_control once it starts linking.
+		if _, err := u.p.ExecOnNode(ctx, stopped[0], postgresCmd(fmt.Sprintf(
+			`set -e; if [ -d %[1]s ]; then for f in %[1]s/*; do if [ -e "$f" ]; then mv "$f" %[2]s/; fi; done; fi; `+
+				`if [ -f %[2]s/global/pg_control.old ]; then mv %[2]s/global/pg_control.old %[2]s/global/pg_control; fi`,
+			oldData, data))); err != nil {
+			errs = append(errs, fmt.Errorf("restore data directory on %s: %w", stopped[0], err))
+		}
+	}
+	for _, name := range stopped {
+		if _, err := u.p.ExecOnNode(ctx, name, postgresCmd(fmt.Sprintf(
+			"%s/pg_ctl start -D %s -w -l /tmp/pgdba-rollback.log", oldBin, data))); err != nil {
+			errs = append(errs, fmt.Errorf("start %s: %w", name, err))
+		}
+	}
+	if len(errs) == 0 && len(stopped) > 0 {
+		if _, err := u.p.ExecOnNode(ctx, stopped[0], []string{"rm", "-rf", work}); err != nil {
+			errs = append(errs, fmt.Errorf("remove %s on %s: %w", work, stopped[0], err))
+		}
+	}
+	if err := u.client.SetPause(ctx, false); err != nil {
+		errs = append(errs, fmt.Errorf("resume patroni: %w", err))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return errors.Join(cause, u.fail(StepRollback, "", err))
+	}
+	u.emit(StepRollback, "", StatusDone, "old cluster restored")
+	return cause
+}
+
+// logical runs the new-cluster-plus-logical-replication method. Every SQL
+// statement runs through psql on the new leader's node, which reaches the
+// old cluster over the network; the old cluster may therefore be external.
+func (u *majorUpgrade) logical(ctx context.Context) error {
+	t := u.opts.Target
+	t.PGVersion = u.opts.To
+	t.Image = u.opts.Image
+	t.Passwords = u.opts.Passwords
+	t.OnEvent = u.onEvent
+
+	u.emit(StepTarget, t.ClusterName, StatusStarted, "")
+	br, err := Bootstrap(ctx, u.p, u.reg, t)
+	if br != nil {
+		*u.events = append(*u.events, br.Events...)
+	}
+	if err != nil {
+		return u.fail(StepTarget, t.ClusterName, err)
+	}
+	u.emit(StepTarget, t.ClusterName, StatusDone, "")
+	u.result.Target = t.ClusterName
+	u.result.Leader = br.Leader
+
+	target, err := u.reg.Get(t.ClusterName)
+	if err != nil {
+		return err
+	}
+	node := br.Leader
+	src := pgEndpoint{host: u.entry.PGHost, port: u.entry.PGPort}
+	dst := pgEndpoint{host: target.PGHost, port: target.PGPort}
+
+	u.emit(StepSchema, node, StatusStarted, "roles")
+	if err := copyRoles(ctx, u.p, node, u.opts.Passwords.Superuser, src, dst); err != nil {
+		return u.fail(StepSchema, node, err)
+	}
+	u.emit(StepSchema, node, StatusDone, "roles")
+
+	for i, db := range u.opts.Databases {
+		if db != "postgres" {
+			if _, err := u.psql(ctx, StepSchema, node, dst, "postgres", "CREATE DATABASE "+quoteIdent(db),
+				"create database "+db); err != nil {
+				return err
+			}
+		}
+		u.emit(StepSchema, node, StatusStarted, db)
+		if _, err := u.p.ExecOnNode(ctx, node, []string{"env", "PGPASSWORD=" + u.opts.Passwords.Superuser, "sh", "-c",
+			fmt.Sprintf(`set -e; f=$(mktemp); pg_dump %s --schema-only --no-publications --no-subscriptions -f "$f"; `+
+				`psql %s -v ON_ERROR_STOP=1 -q -f "$f"; rm -f "$f"`, src.args(db), dst.args(db))}); err != nil {
+			return u.fail(StepSchema, node, err)
+		}
+		u.emit(StepSchema, node, StatusDone, db)
+
+		if _, err := u.psql(ctx, StepPublish, node, src, db,
+			"CREATE PUBLICATION "+logicalName+" FOR ALL TABLES", "publish all tables"); err != nil {
+			return err
+		}
+		conninfo := fmt.Sprintf("host=%s port=%d dbname=%s user=postgres password=%s",
+			src.host, src.port, db, u.opts.Passwords.Superuser)
+		if _, err := u.psql(ctx, StepSubscribe, node, dst, db, fmt.Sprintf(
+			"CREATE SUBSCRIPTION %s CONNECTION %s PUBLICATION %s WITH (slot_name = '%s_%d')",
+			logicalName, quoteLiteral(conninfo), logicalName, logicalName, i),
+			fmt.Sprintf("subscribe to %s:%d through slot %s_%d", src.host, src.port, logicalName, i)); err != nil {
+			return err
+		}
+	}
+
+	u.emit(StepSync, "", StatusStarted, "waiting for the initial table copy")
+	for _, db := range u.opts.Databases {
+		if err := u.poll(ctx, node, dst, db,
+			"SELECT count(*) = 0 FROM pg_subscription_rel WHERE srsubstate <> 'r'"); err != nil {
+			return u.fail(StepSync, db, err)
+		}
+	}
+	u.emit(StepSync, "", StatusDone, "")
+
+	u.emit(StepCutover, "", StatusStarted, "making "+u.entry.Name+" read-only")
+	if err := u.client.PatchConfig(ctx, map[string]interface{}{"postgresql": map[string]interface{}{
+		"parameters": map[string]interface{}{"default_transaction_read_only": "on"}}}); err != nil {
+		return u.fail(StepCutover, "", err)
+	}
+	// Sessions opened before the reload keep writing; end them once it is
+	// in effect.
+	if err := u.poll(ctx, node, src, "postgres", "SELECT current_setting('default_transaction_read_only') = 'on'"); err != nil {
+		return u.fail(StepCutover, "", err)
+	}
+	if _, err := u.query(ctx, node, src, "postgres", "SELECT count(pg_terminate_backend(pid)) FROM pg_stat_activity "+
+		"WHERE pid <> pg_backend_pid() AND backend_type = 'client backend'"); err != nil {
+		return u.fail(StepCutover, "", err)
+	}
+	for i, db := range u.opts.Databases {
+		if err := u.poll(ctx, node, src, db, fmt.Sprintf(
+			"SELECT confirmed_flush_lsn >= pg_current_wal_lsn() FROM pg_replication_slots WHERE slot_name = '%s_%d'",
+			logicalName, i)); err != nil {
+			return u.fail(StepCutover, db, err)
+		}
+	}
+	u.emit(StepCutover, "", StatusDone, "")
+
+	const sequences = "SELECT format('SELECT setval(%L, %s, true);', format('%I.%I', schemaname, sequencename), last_value) " +
+		"FROM pg_sequences WHERE last_value IS NOT NULL"
+	for _, db := range u.opts.Databases {
+		u.emit(StepSequences, node, StatusStarted, db)
+		if _, err := u.p.ExecOnNode(ctx, node, []string{"env", "PGPASSWORD=" + u.opts.Passwords.Superuser, "sh", "-c",
+			fmt.Sprintf(`set -e; f=$(mktemp); psql %s -Atc "$1" -o "$f"; psql %s -v ON_ERROR_STOP=1 -q -f "$f"; rm -f "$f"`,
+				src.args(db), dst.args(db)),
+			"sh", sequences}); err != nil {
+			return u.fail(StepSequences, node, err)
+		}
+		u.emit(StepSequences, node, StatusDone, db)
+	}
+
+	for _, db := range u.opts.Databases {
+		if _, err := u.psql(ctx, StepCleanup, node, dst, db, "DROP SUBSCRIPTION "+logicalName, "drop subscription"); err != nil {
+			return err
+		}
+		// The old cluster is read-only by default now.
+		if _, err := u.psql(ctx, StepCleanup, node, src, db,
+			"BEGIN READ WRITE; DROP PUBLICATION "+logicalName+"; COMMIT", "drop publication"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sh runs script on node as the postgres OS user, as one step.
+func (u *majorUpgrade) sh(ctx context.Context, step, node, script string) error {
+	u.emit(step, node, StatusStarted, "")
+	if _, err := u.p.ExecOnNode(ctx, node, postgresCmd(script)); err != nil {
+		return u.fail(step, node, err)
+	}
+	u.emit(step, node, StatusDone, "")
+	return nil
+}
+
+// postgresCmd returns the command running script as the postgres OS user:
+// initdb, pg_upgrade and pg_ctl refuse to run as root, which is what docker
+// exec and a bare-metal root login run as.
+func postgresCmd(script string) []string {
+	return []string{"sh", "-c", `case "$(id -un)" in ` +
+		`postgres) exec sh -c "$1" ;; ` +
+		`root) exec su postgres -s /bin/sh -c "$1" ;; ` +
+		`*) exec sudo -n -u postgres sh -c "$1" ;; esac`, "sh", script}
+}
+
+// pgEndpoint is a PostgreSQL server reachable from the node psql runs on.
+type pgEndpoint struct {
+	host string
+	port int
+}
+
+// args returns the psql/pg_dump connection arguments for database db,
+// quoted for sh.
+func (e pgEndpoint) args(db string) string {
+	return fmt.Sprintf("-h %s -p %d -U postgres -d %s", shellQuote(e.host), e.port, shellQuote(db))
+}
+
+// psql runs sql in database db of e from node as one step and returns the
+// unaligned output. The step event carries message rather than sql, which
+// may hold a password.
+func (u *majorUpgrade) psql(ctx context.Context, step, node string, e pgEndpoint, db, sql, message string) (string, error) {
+	u.emit(step, node, StatusStarted, db+": "+message)
+	out, err := u.query(ctx, node, e, db, sql)
+	if err != nil {
+		return "", u.fail(step, node, err)
+	}
+	u.emit(step, node, StatusDone, db)
+	return out, nil
+}
+
+func (u *majorUpgrade) query(ctx context.Context, node string, e pgEndpoint, db, sql string) (string, error) {
+	return psqlQuery(ctx, u.p, node, u.opts.Passwords.Superuser, e, db, sql)
+}
+
+// psqlQuery runs sql as the superuser in database db of e through psql on
+// node and returns the unaligned output.
+func psqlQuery(ctx context.Context, p provider.Provider, node, password string, e pgEndpoint, db, sql string) (string, error) {
+	out, err := p.ExecOnNode(ctx, node, []string{"env", "PGPASSWORD=" + password,
+		"psql", "-h", e.host, "-p", strconv.Itoa(e.port), "-U", "postgres", "-d", db,
+		"-v", "ON_ERROR_STOP=1", "-Atc", sql})
+	return strings.TrimSpace(out), err
+}
+
+// copyRoles copies the roles of src, with their passwords and memberships,
+// to dst through pg_dumpall on node. Roles that already exist on dst, such
+// as postgres, fail to be created and are altered instead, so errors do not
+// stop the restore.
+func copyRoles(ctx context.Context, p provider.Provider, node, password string, src, dst pgEndpoint) error {
+	_, err := p.ExecOnNode(ctx, node, []string{"env", "PGPASSWORD=" + password, "sh", "-c",
+		fmt.Sprintf(`set -e; f=$(mktemp); pg_dumpall %s --roles-only -f "$f"; psql %s -q -f "$f"; rm -f "$f"`,
+			src.args("postgres"), dst.args("postgres"))})
+	return err
+}
+
+// poll runs the boolean query sql until it returns true, or fails after
+// opts.Timeout.
+func (u *majorUpgrade) poll(ctx context.Context, node string, e pgEndpoint, db, sql string) error {
+	ctx, cancel := context.WithTimeout(ctx, u.opts.Timeout)
+	defer cancel()
+
+	var last string
+	for {
+		out, err := u.query(ctx, node, e, db, sql)
+		if err == nil && out == "t" {
+			return nil
+		}
+		last = out
+		if err != nil {
+			last = err.Error()
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s: not done within %s (last result %q)", db, u.opts.Timeout, last)
+		case <-time.After(u.opts.PollInterval):
+		}
+	}
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func quoteIdent(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
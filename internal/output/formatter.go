@@ -61,5 +61,8 @@ func formatTable(r Response) string {
 	if r.Error != nil {
 		result += fmt.Sprintf("ERROR: %s\n", *r.Error)
 	}
+	if t, ok := r.Data.(Table); ok {
+		result += "\n" + renderTable(t)
+	}
 	return result
 }
@@ -0,0 +1,25 @@
+package output
+
+import (
+	"strings"
+	"text/tabwriter"
+)
+
+// Table is implemented by response data that can be shown as rows with
+// --format table. Other data is omitted from table output.
+type Table interface {
+	TableHeader() []string
+	TableRows() [][]string
+}
+
+// renderTable lays out t in aligned columns.
+func renderTable(t Table) string {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	w.Write([]byte(strings.Join(t.TableHeader(), "\t") + "\n"))
+	for _, row := range t.TableRows() {
+		w.Write([]byte(strings.Join(row, "\t") + "\n"))
+	}
+	w.Flush()
+	return b.String()
+}
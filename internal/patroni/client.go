@@ -3,10 +3,17 @@ package patroni
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -30,6 +37,10 @@ type Member struct {
 	Lag      int64     `json:"lag"`
 	Timeline int64     `json:"timeline"`
 	APIURL   string    `json:"api_url"`
+
+	// Tags are the member's Patroni tags (nofailover, failover_priority,
+	// nosync, ...) as reported in /cluster.
+	Tags map[string]interface{} `json:"tags,omitempty"`
 }
 
 // UnmarshalJSON implements json.Unmarshaler to handle Patroni's inconsistent
@@ -38,14 +49,15 @@ type Member struct {
 // are treated as 0 (unknown lag).
 func (m *Member) UnmarshalJSON(data []byte) error {
 	type memberAlias struct {
-		Name     string          `json:"name"`
-		Host     string          `json:"host"`
-		Port     int             `json:"port"`
-		Role     string          `json:"role"`
-		State    NodeState       `json:"state"`
-		Lag      json.RawMessage `json:"lag"`
-		Timeline int64           `json:"timeline"`
-		APIURL   string          `json:"api_url"`
+		Name     string                 `json:"name"`
+		Host     string                 `json:"host"`
+		Port     int                    `json:"port"`
+		Role     string                 `json:"role"`
+		State    NodeState              `json:"state"`
+		Lag      json.RawMessage        `json:"lag"`
+		Timeline int64                  `json:"timeline"`
+		APIURL   string                 `json:"api_url"`
+		Tags     map[string]interface{} `json:"tags"`
 	}
 	var raw memberAlias
 	if err := json.Unmarshal(data, &raw); err != nil {
@@ -58,6 +70,7 @@ func (m *Member) UnmarshalJSON(data []byte) error {
 	m.State = raw.State
 	m.Timeline = raw.Timeline
 	m.APIURL = raw.APIURL
+	m.Tags = raw.Tags
 
 	if len(raw.Lag) > 0 {
 		if err := json.Unmarshal(raw.Lag, &m.Lag); err != nil {
@@ -70,9 +83,90 @@ func (m *Member) UnmarshalJSON(data []byte) error {
 
 // ClusterStatus holds the Patroni cluster topology.
 type ClusterStatus struct {
-	Members  []Member `json:"members"`
-	Failover *string  `json:"failover,omitempty"`
-	Pause    bool     `json:"pause"`
+	Members []Member `json:"members"`
+
+	// Failover is the switchover Patroni has been asked for but not carried
+	// out yet, usually one scheduled for later (scheduled_switchover in
+	// /cluster; older versions answer with a failover key).
+	Failover *PendingSwitchover `json:"scheduled_switchover,omitempty"`
+	Pause    bool               `json:"pause"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler, taking the pending switchover
+// from scheduled_switchover or, failing that, from failover.
+func (cs *ClusterStatus) UnmarshalJSON(data []byte) error {
+	type statusAlias ClusterStatus
+	var raw struct {
+		statusAlias
+		Legacy *PendingSwitchover `json:"failover"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*cs = ClusterStatus(raw.statusAlias)
+	if cs.Failover == nil {
+		cs.Failover = raw.Legacy
+	}
+	return nil
+}
+
+// PendingSwitchover is a switchover from one member to another requested
+// for a later time. At is nil when none was given; To is empty when Patroni
+// picks the candidate.
+type PendingSwitchover struct {
+	At   *time.Time `json:"at,omitempty"`
+	From string     `json:"from,omitempty"`
+	To   string     `json:"to,omitempty"`
+}
+
+// Scheduled reports whether the switchover is due after now.
+func (p *PendingSwitchover) Scheduled(now time.Time) bool {
+	return p != nil && p.At != nil && p.At.After(now)
+}
+
+// UnmarshalJSON implements json.Unmarshaler for both shapes Patroni uses:
+// {"at", "from", "to"} in /cluster and the DCS failover key
+// {"scheduled_at", "leader", "member"}. A bare string is taken as the time.
+func (p *PendingSwitchover) UnmarshalJSON(data []byte) error {
+	var at string
+	if err := json.Unmarshal(data, &at); err == nil {
+		*p = PendingSwitchover{At: parseSwitchoverTime(at)}
+		return nil
+	}
+	var raw struct {
+		At          string `json:"at"`
+		ScheduledAt string `json:"scheduled_at"`
+		From        string `json:"from"`
+		Leader      string `json:"leader"`
+		To          string `json:"to"`
+		Member      string `json:"member"`
+		Candidate   string `json:"candidate"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*p = PendingSwitchover{At: parseSwitchoverTime(firstOf(raw.At, raw.ScheduledAt)),
+		From: firstOf(raw.From, raw.Leader), To: firstOf(raw.To, raw.Member, raw.Candidate)}
+	return nil
+}
+
+// parseSwitchoverTime parses the ISO 8601 timestamps Patroni writes.
+func parseSwitchoverTime(s string) *time.Time {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999-07:00", "2006-01-02 15:04:05.999999-07:00"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return &t
+		}
+	}
+	return nil
+}
+
+func firstOf(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
 }
 
 // NodeInfo holds detailed information about a single Patroni node (from /patroni endpoint).
@@ -83,34 +177,239 @@ type NodeInfo struct {
 	ClusterName   string    `json:"patroni"`
 	Timeline      int64     `json:"timeline"`
 	Xlog          struct {
-		Location int64 `json:"location"`
+		Location int64 `json:"location"` // primaries
+
+		// Replicas report how far WAL was received and replayed, and the
+		// commit time of the last replayed transaction
+		// (pg_last_xact_replay_timestamp()).
+		ReceivedLocation  int64  `json:"received_location"`
+		ReplayedLocation  int64  `json:"replayed_location"`
+		ReplayedTimestamp string `json:"replayed_timestamp"`
+		Paused            bool   `json:"paused"`
 	} `json:"xlog"`
+
+	// PostmasterStartTime changes whenever PostgreSQL is restarted, e.g.
+	// after a re-initialization.
+	PostmasterStartTime string `json:"postmaster_start_time"`
 }
 
-// Client is a Patroni REST API client.
+// Client is a Patroni REST API client. A client built from a cluster URL
+// fails over to other endpoints (configured ones, then the api_url of members
+// it has seen in /cluster) when its current endpoint cannot be reached, for
+// requests any member can answer.
 type Client struct {
-	baseURL    string
 	httpClient *http.Client
+	username   string
+	password   string
+	pinned     bool // talk to the first endpoint only (member clients)
+
+	mu        sync.Mutex
+	endpoints []string // endpoints[0] is the one that answered last
+}
+
+// Options configure a client for a Patroni REST API that requires
+// authentication or TLS, or that is reachable through several members.
+type Options struct {
+	Username  string // HTTP basic auth, with Password
+	Password  string
+	CAFile    string // CA bundle for https endpoints (default: system roots)
+	CertFile  string // client certificate for mTLS, with KeyFile
+	KeyFile   string
+	Endpoints []string      // further API URLs to try when the base URL is down
+	Timeout   time.Duration // per request (default DefaultTimeout)
 }
 
+// DefaultTimeout bounds each request of a client.
+const DefaultTimeout = 10 * time.Second
+
 // NewClient creates a new Patroni client.
 // baseURL example: "http://10.0.0.1:8008"
 func NewClient(baseURL string) *Client {
 	return &Client{
-		baseURL:    strings.TrimRight(baseURL, "/"),
-		httpClient: &http.Client{Timeout: 10 * time.Second},
+		endpoints:  []string{strings.TrimRight(baseURL, "/")},
+		httpClient: &http.Client{Timeout: DefaultTimeout},
 	}
 }
 
-// GetClusterStatus queries the cluster topology (GET /cluster).
-func (c *Client) GetClusterStatus(ctx context.Context) (*ClusterStatus, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/cluster", nil)
+// NewClientWithOptions creates a Patroni client for baseURL configured by
+// opts. It fails if the TLS files cannot be loaded.
+func NewClientWithOptions(baseURL string, opts Options) (*Client, error) {
+	c := NewClient(baseURL)
+	c.username, c.password = opts.Username, opts.Password
+	if opts.Timeout > 0 {
+		c.httpClient.Timeout = opts.Timeout
+	}
+	for _, e := range opts.Endpoints {
+		c.addEndpoint(e)
+	}
+	if opts.CAFile == "" && opts.CertFile == "" && opts.KeyFile == "" {
+		return c, nil
+	}
+
+	tlsCfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if opts.CAFile != "" {
+		pem, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read patroni CA file: %w", err)
+		}
+		tlsCfg.RootCAs = x509.NewCertPool()
+		if !tlsCfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("patroni CA file %s holds no PEM certificates", opts.CAFile)
+		}
+	}
+	if opts.CertFile != "" || opts.KeyFile != "" {
+		if opts.CertFile == "" || opts.KeyFile == "" {
+			return nil, fmt.Errorf("patroni client certificate and key must be given together")
+		}
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load patroni client certificate: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsCfg
+	c.httpClient.Transport = transport
+	return c, nil
+}
+
+// Password reads the Patroni REST API password from the environment variable
+// PGDBA_PATRONI_PASSWORD or, if that is unset, from the file named by
+// PGDBA_PATRONI_PASSWORD_FILE. It returns "" when neither is set.
+func Password() (string, error) {
+	if pw := os.Getenv("PGDBA_PATRONI_PASSWORD"); pw != "" {
+		return pw, nil
+	}
+	file := os.Getenv("PGDBA_PATRONI_PASSWORD_FILE")
+	if file == "" {
+		return "", nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("read PGDBA_PATRONI_PASSWORD_FILE: %w", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// URL returns the endpoint the client currently talks to.
+func (c *Client) URL() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.endpoints[0]
+}
+
+// ForMember returns a client for member m's own REST API, derived from
+// m.APIURL (e.g. "http://10.0.0.2:8008/patroni"). It shares the credentials
+// and TLS settings of c and never fails over to another member.
+func (c *Client) ForMember(m Member) (*Client, error) {
+	base, err := memberBase(m)
 	if err != nil {
-		return nil, fmt.Errorf("build request: %w", err)
+		return nil, err
+	}
+	return &Client{
+		endpoints:  []string{base},
+		httpClient: c.httpClient,
+		username:   c.username,
+		password:   c.password,
+		pinned:     true,
+	}, nil
+}
+
+// memberBase returns the scheme and host of m's api_url.
+func memberBase(m Member) (string, error) {
+	if m.APIURL == "" {
+		return "", fmt.Errorf("member %s has no api_url", m.Name)
+	}
+	u, err := url.Parse(m.APIURL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("member %s has invalid api_url %q", m.Name, m.APIURL)
+	}
+	return u.Scheme + "://" + u.Host, nil
+}
+
+// addEndpoint appends e to the endpoints tried on failover, once.
+func (c *Client) addEndpoint(e string) {
+	e = strings.TrimRight(e, "/")
+	if e == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, have := range c.endpoints {
+		if have == e {
+			return
+		}
+	}
+	c.endpoints = append(c.endpoints, e)
+}
+
+// clusterWide lists the endpoints every member answers for the whole
+// cluster. Others (/patroni, /restart, ...) concern the member asked.
+var clusterWide = map[string]bool{
+	"/cluster":    true,
+	"/config":     true,
+	"/history":    true,
+	"/switchover": true,
+	"/failover":   true,
+}
+
+// do sends a request to the current endpoint. For cluster-wide paths it
+// tries the other endpoints in turn when the current one cannot be reached;
+// the first to answer becomes current. Requests other than GET are only
+// retried if the connection was refused, so they are never sent twice.
+// HTTP error statuses are returned to the caller, not retried.
+func (c *Client) do(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
+	c.mu.Lock()
+	endpoints := append([]string(nil), c.endpoints...)
+	c.mu.Unlock()
+	if c.pinned || !clusterWide[path] {
+		endpoints = endpoints[:1]
+	}
+
+	var lastErr error
+	for _, base := range endpoints {
+		req, err := http.NewRequestWithContext(ctx, method, base+path, bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("build request: %w", err)
+		}
+		req.Header.Set("Accept", "application/json")
+		if method != http.MethodGet {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if c.username != "" {
+			req.SetBasicAuth(c.username, c.password)
+		}
+
+		resp, err := c.httpClient.Do(req)
+		if err == nil {
+			c.promote(base)
+			return resp, nil
+		}
+		lastErr = err
+		var opErr *net.OpError
+		if ctx.Err() != nil || (method != http.MethodGet && !(errors.As(err, &opErr) && opErr.Op == "dial")) {
+			break
+		}
 	}
-	req.Header.Set("Accept", "application/json")
+	return nil, lastErr
+}
+
+// promote makes base the endpoint tried first.
+func (c *Client) promote(base string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, e := range c.endpoints {
+		if e == base && i > 0 {
+			copy(c.endpoints[1:i+1], c.endpoints[:i])
+			c.endpoints[0] = base
+			return
+		}
+	}
+}
 
-	resp, err := c.httpClient.Do(req)
+// GetClusterStatus queries the cluster topology (GET /cluster).
+func (c *Client) GetClusterStatus(ctx context.Context) (*ClusterStatus, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/cluster", nil)
 	if err != nil {
 		return nil, fmt.Errorf("get cluster status: %w", err)
 	}
@@ -124,18 +423,19 @@ func (c *Client) GetClusterStatus(ctx context.Context) (*ClusterStatus, error) {
 	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
 		return nil, fmt.Errorf("decode cluster status: %w", err)
 	}
+	if !c.pinned {
+		for _, m := range status.Members {
+			if base, err := memberBase(m); err == nil {
+				c.addEndpoint(base)
+			}
+		}
+	}
 	return &status, nil
 }
 
 // GetNodeInfo queries a single node's details (GET /patroni).
 func (c *Client) GetNodeInfo(ctx context.Context) (*NodeInfo, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/patroni", nil)
-	if err != nil {
-		return nil, fmt.Errorf("build request: %w", err)
-	}
-	req.Header.Set("Accept", "application/json")
-
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.do(ctx, http.MethodGet, "/patroni", nil)
 	if err != nil {
 		return nil, fmt.Errorf("get node info: %w", err)
 	}
@@ -152,14 +452,71 @@ func (c *Client) GetNodeInfo(ctx context.Context) (*NodeInfo, error) {
 	return &info, nil
 }
 
-// IsPrimary checks if the current node is the primary (GET /primary → 200 means primary).
-func (c *Client) IsPrimary(ctx context.Context) (bool, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/primary", nil)
+// HistoryEntry is one timeline switch from Patroni's /history: Timeline
+// ended at LSN and the cluster continued on Timeline+1 under NewLeader.
+// Timestamp and NewLeader are empty in histories written by old Patroni
+// versions.
+type HistoryEntry struct {
+	Timeline  int64  `json:"timeline"`
+	LSN       int64  `json:"lsn"`
+	Reason    string `json:"reason"`
+	Timestamp string `json:"timestamp,omitempty"`
+	NewLeader string `json:"new_leader,omitempty"`
+}
+
+// UnmarshalJSON decodes the [timeline, lsn, reason, timestamp, new_leader]
+// arrays Patroni returns; trailing elements may be missing.
+func (h *HistoryEntry) UnmarshalJSON(data []byte) error {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if len(raw) < 2 {
+		return fmt.Errorf("history entry has %d fields, want at least 2", len(raw))
+	}
+	targets := []interface{}{&h.Timeline, &h.LSN, &h.Reason, &h.Timestamp, &h.NewLeader}
+	for i, r := range raw {
+		if i == len(targets) {
+			break
+		}
+		if string(r) == "null" {
+			continue
+		}
+		if err := json.Unmarshal(r, targets[i]); err != nil {
+			return fmt.Errorf("history entry field %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// FormatLSN renders lsn the way PostgreSQL prints pg_lsn values (0/3000060).
+func FormatLSN(lsn int64) string {
+	return fmt.Sprintf("%X/%X", uint64(lsn)>>32, uint64(lsn)&0xFFFFFFFF)
+}
+
+// GetHistory returns the timeline history of the cluster (GET /history),
+// oldest switch first.
+func (c *Client) GetHistory(ctx context.Context) ([]HistoryEntry, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/history", nil)
 	if err != nil {
-		return false, fmt.Errorf("build request: %w", err)
+		return nil, fmt.Errorf("get history: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("patroni /history returned HTTP %d", resp.StatusCode)
+	}
+
+	history := []HistoryEntry{}
+	if err := json.NewDecoder(resp.Body).Decode(&history); err != nil {
+		return nil, fmt.Errorf("decode history: %w", err)
 	}
+	return history, nil
+}
 
-	resp, err := c.httpClient.Do(req)
+// IsPrimary checks if the current node is the primary (GET /primary → 200 means primary).
+func (c *Client) IsPrimary(ctx context.Context) (bool, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/primary", nil)
 	if err != nil {
 		return false, fmt.Errorf("check primary: %w", err)
 	}
@@ -171,13 +528,39 @@ func (c *Client) IsPrimary(ctx context.Context) (bool, error) {
 // Switchover triggers a controlled switchover (POST /switchover).
 // leader is the current primary name; candidate is the target node (empty = Patroni chooses).
 func (c *Client) Switchover(ctx context.Context, leader, candidate string) error {
+	return c.ScheduleSwitchover(ctx, leader, candidate, time.Time{})
+}
+
+// ScheduleSwitchover asks Patroni to switch over from leader to candidate
+// (empty = Patroni chooses when the time comes) at the given time; a zero
+// time switches over now.
+func (c *Client) ScheduleSwitchover(ctx context.Context, leader, candidate string, at time.Time) error {
 	payload := map[string]string{
 		"leader":    leader,
 		"candidate": candidate,
 	}
+	if !at.IsZero() {
+		payload["scheduled_at"] = at.Format(time.RFC3339)
+	}
 	return c.postJSON(ctx, "/switchover", payload)
 }
 
+// CancelSwitchover deletes the scheduled switchover (DELETE /switchover).
+func (c *Client) CancelSwitchover(ctx context.Context) error {
+	resp, err := c.do(ctx, http.MethodDelete, "/switchover", nil)
+	if err != nil {
+		return fmt.Errorf("DELETE /switchover: %w", err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return fmt.Errorf("no switchover is scheduled")
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		return fmt.Errorf("patroni /switchover returned HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
 // Failover triggers a forced failover (POST /failover) for use when the primary is unreachable.
 func (c *Client) Failover(ctx context.Context, candidate string) error {
 	payload := map[string]string{
@@ -188,7 +571,25 @@ func (c *Client) Failover(ctx context.Context, candidate string) error {
 
 // Reinitialize re-initializes the node (POST /reinitialize).
 func (c *Client) Reinitialize(ctx context.Context) error {
-	return c.postJSON(ctx, "/reinitialize", nil)
+	return c.ReinitializeWith(ctx, ReinitOptions{})
+}
+
+// ReinitOptions are the options of POST /reinitialize. Force cancels a
+// bootstrap or re-initialization already running on the member; FromLeader
+// takes the new base backup from the leader rather than a replica.
+type ReinitOptions struct {
+	Force      bool `json:"force,omitempty"`
+	FromLeader bool `json:"from_leader,omitempty"`
+}
+
+// ReinitializeWith re-initializes the node with opts: Patroni wipes its
+// data directory and takes a fresh base backup. Use it on a member client
+// (ForMember); the request is answered before the backup starts.
+func (c *Client) ReinitializeWith(ctx context.Context, opts ReinitOptions) error {
+	if opts == (ReinitOptions{}) {
+		return c.postJSON(ctx, "/reinitialize", nil)
+	}
+	return c.postJSON(ctx, "/reinitialize", opts)
 }
 
 // Restart restarts the Patroni-managed PostgreSQL (POST /restart).
@@ -196,29 +597,61 @@ func (c *Client) Restart(ctx context.Context) error {
 	return c.postJSON(ctx, "/restart", nil)
 }
 
+// Reload makes Patroni re-read its configuration file (POST /reload).
+func (c *Client) Reload(ctx context.Context) error {
+	return c.postJSON(ctx, "/reload", nil)
+}
+
+// GetConfig returns the dynamic cluster configuration stored in the DCS
+// (GET /config).
+func (c *Client) GetConfig(ctx context.Context) (map[string]interface{}, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/config", nil)
+	if err != nil {
+		return nil, fmt.Errorf("get config: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("patroni /config returned HTTP %d", resp.StatusCode)
+	}
+
+	cfg := map[string]interface{}{}
+	if err := json.NewDecoder(resp.Body).Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("decode config: %w", err)
+	}
+	return cfg, nil
+}
+
+// PatchConfig merges patch into the dynamic cluster configuration
+// (PATCH /config). A nil value removes the key.
+func (c *Client) PatchConfig(ctx context.Context, patch map[string]interface{}) error {
+	return c.sendJSON(ctx, http.MethodPatch, "/config", patch)
+}
+
+// SetPause enables or disables Patroni maintenance mode, in which Patroni
+// stops managing PostgreSQL and performs no automatic failover.
+func (c *Client) SetPause(ctx context.Context, paused bool) error {
+	return c.PatchConfig(ctx, map[string]interface{}{"pause": paused})
+}
+
 // postJSON sends a POST request with an optional JSON body and checks for a 2xx response.
 func (c *Client) postJSON(ctx context.Context, path string, body interface{}) error {
-	var bodyReader *bytes.Reader
+	return c.sendJSON(ctx, http.MethodPost, path, body)
+}
+
+// sendJSON sends a request with an optional JSON body and checks for a 2xx response.
+func (c *Client) sendJSON(ctx context.Context, method, path string, body interface{}) error {
+	var data []byte
 	if body != nil {
-		data, err := json.Marshal(body)
-		if err != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
 			return fmt.Errorf("marshal request body: %w", err)
 		}
-		bodyReader = bytes.NewReader(data)
-	} else {
-		bodyReader = bytes.NewReader([]byte{})
-	}
-
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+path, bodyReader)
-	if err != nil {
-		return fmt.Errorf("build request: %w", err)
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "application/json")
 
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.do(ctx, method, path, data)
 	if err != nil {
-		return fmt.Errorf("POST %s: %w", path, err)
+		return fmt.Errorf("%s %s: %w", method, path, err)
 	}
 	defer resp.Body.Close()
 
@@ -23,6 +23,17 @@ type PatroniConfig struct {
 	ReplicationPassword string // read from env var by caller
 	SuperuserPassword   string // read from env var by caller
 	RewindPassword      string // read from env var by caller
+	PGVersion           int    // PostgreSQL major version; selects bin_dir (default DefaultPGVersion)
+}
+
+// DefaultPGVersion is the PostgreSQL major version rendered when
+// PatroniConfig.PGVersion is unset.
+const DefaultPGVersion = 16
+
+// BinDir returns the PostgreSQL binary directory of major version in the
+// images pgdba provisions.
+func BinDir(version int) string {
+	return fmt.Sprintf("/usr/lib/postgresql/%d/bin", version)
 }
 
 // EtcdConfig holds the template variables for the etcd configuration file.
@@ -36,6 +47,9 @@ type EtcdConfig struct {
 
 // RenderPatroniConfig renders the Patroni configuration file content.
 func RenderPatroniConfig(cfg PatroniConfig) (string, error) {
+	if cfg.PGVersion == 0 {
+		cfg.PGVersion = DefaultPGVersion
+	}
 	return renderTemplate("templates/patroni.yml.tmpl", cfg)
 }
 
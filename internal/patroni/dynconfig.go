@@ -0,0 +1,216 @@
+package patroni
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Defaults Patroni applies to the timing keys of the dynamic configuration.
+const (
+	DefaultTTL          = 30
+	DefaultLoopWait     = 10
+	DefaultRetryTimeout = 10
+)
+
+// ConfigChange is one changed leaf of the dynamic configuration. From is nil
+// when the key is added, To is nil when it is removed.
+type ConfigChange struct {
+	Key  string      `json:"key"`
+	From interface{} `json:"from,omitempty"`
+	To   interface{} `json:"to,omitempty"`
+}
+
+// ConfigDiff is the structured difference between two dynamic
+// configurations: PostgreSQL parameters (postgresql.parameters, keyed by
+// parameter name) apart from Patroni's own keys (ttl, loop_wait,
+// maximum_lag_on_failover, ..., keyed by dotted path).
+type ConfigDiff struct {
+	Parameters []ConfigChange `json:"parameters"`
+	Patroni    []ConfigChange `json:"patroni"`
+}
+
+// Empty reports whether the diff has no changes.
+func (d ConfigDiff) Empty() bool {
+	return len(d.Parameters) == 0 && len(d.Patroni) == 0
+}
+
+// MergeConfig returns the configuration Patroni would store after PATCH
+// /config with patch: nested objects are merged, nil values remove keys.
+// cfg is not modified.
+func MergeConfig(cfg, patch map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(cfg))
+	for k, v := range cfg {
+		out[k] = v
+	}
+	for k, v := range patch {
+		switch pv := v.(type) {
+		case nil:
+			delete(out, k)
+		case map[string]interface{}:
+			base, _ := out[k].(map[string]interface{})
+			out[k] = MergeConfig(base, pv)
+		default:
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// DiffConfig compares two dynamic configurations leaf by leaf. Values are
+// compared by their rendering, so JSON numbers, YAML integers and quoted
+// numbers compare equal.
+func DiffConfig(before, after map[string]interface{}) ConfigDiff {
+	from, to := map[string]interface{}{}, map[string]interface{}{}
+	flattenConfig(nil, before, from)
+	flattenConfig(nil, after, to)
+
+	keys := make([]string, 0, len(from)+len(to))
+	for k := range from {
+		keys = append(keys, k)
+	}
+	for k := range to {
+		if _, ok := from[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	diff := ConfigDiff{Parameters: []ConfigChange{}, Patroni: []ConfigChange{}}
+	for _, k := range keys {
+		f, inFrom := from[k]
+		t, inTo := to[k]
+		if inFrom && inTo && ConfigString(f) == ConfigString(t) {
+			continue
+		}
+		if name, ok := parameterName(k); ok {
+			diff.Parameters = append(diff.Parameters, ConfigChange{Key: name, From: f, To: t})
+		} else {
+			diff.Patroni = append(diff.Patroni, ConfigChange{Key: k, From: f, To: t})
+		}
+	}
+	return diff
+}
+
+// EditPatch returns the PATCH /config body that turns before into after,
+// removing keys that after no longer has.
+func EditPatch(before, after map[string]interface{}) map[string]interface{} {
+	patch := map[string]interface{}{}
+	for k, v := range after {
+		old, ok := before[k]
+		sub, isMap := v.(map[string]interface{})
+		oldSub, wasMap := old.(map[string]interface{})
+		switch {
+		case isMap && wasMap:
+			if p := EditPatch(oldSub, sub); len(p) > 0 {
+				patch[k] = p
+			}
+		case !ok || isMap != wasMap || ConfigString(old) != ConfigString(v):
+			patch[k] = v
+		}
+	}
+	for k := range before {
+		if _, ok := after[k]; !ok {
+			patch[k] = nil
+		}
+	}
+	return patch
+}
+
+// ValidateTiming checks the timing keys of a dynamic configuration against
+// the rules Patroni enforces: ttl, loop_wait, retry_timeout and
+// maximum_lag_on_failover must be non-negative numbers, and loop_wait +
+// 2*retry_timeout must not exceed ttl. It returns one message per problem.
+func ValidateTiming(cfg map[string]interface{}) []string {
+	var errs []string
+	get := func(key string, def float64) float64 {
+		v, ok := cfg[key]
+		if !ok || v == nil {
+			return def
+		}
+		n, ok := ConfigNumber(v)
+		if !ok || n < 0 {
+			errs = append(errs, fmt.Sprintf("%s: must be a non-negative number, got %v", key, v))
+			return def
+		}
+		return n
+	}
+	ttl := get("ttl", DefaultTTL)
+	loopWait := get("loop_wait", DefaultLoopWait)
+	retry := get("retry_timeout", DefaultRetryTimeout)
+	get("maximum_lag_on_failover", 0)
+	if len(errs) == 0 && loopWait+2*retry > ttl {
+		errs = append(errs, fmt.Sprintf("loop_wait (%g) + 2*retry_timeout (%g) must not exceed ttl (%g)",
+			loopWait, retry, ttl))
+	}
+	return errs
+}
+
+// ConfigString renders a dynamic configuration value as PostgreSQL and
+// Patroni read it.
+func ConfigString(v interface{}) string {
+	switch n := v.(type) {
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(n, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// ConfigNumber returns v as a number if it is one or a string holding one.
+func ConfigNumber(v interface{}) (float64, bool) {
+	n, err := strconv.ParseFloat(strings.TrimSpace(ConfigString(v)), 64)
+	return n, err == nil
+}
+
+// parametersPrefix is the dotted path of PostgreSQL parameters in the
+// dynamic configuration.
+const parametersPrefix = "postgresql.parameters."
+
+// parameterName returns the PostgreSQL parameter a flattened key names.
+// Parameter names may contain dots themselves (pg_stat_statements.max).
+func parameterName(key string) (string, bool) {
+	if strings.HasPrefix(key, parametersPrefix) {
+		return strings.TrimPrefix(key, parametersPrefix), true
+	}
+	return "", false
+}
+
+// ConfigPath splits a dotted dynamic configuration key into its path,
+// keeping PostgreSQL parameter names whole.
+func ConfigPath(key string) []string {
+	if name, ok := parameterName(key); ok {
+		return []string{"postgresql", "parameters", name}
+	}
+	return strings.Split(key, ".")
+}
+
+// SetConfigPath sets path in the nested configuration m to value, creating
+// intermediate objects.
+func SetConfigPath(m map[string]interface{}, path []string, value interface{}) {
+	for _, k := range path[:len(path)-1] {
+		sub, ok := m[k].(map[string]interface{})
+		if !ok {
+			sub = map[string]interface{}{}
+			m[k] = sub
+		}
+		m = sub
+	}
+	m[path[len(path)-1]] = value
+}
+
+// flattenConfig collects the leaves of m under prefix into out, keyed by
+// dotted path.
+func flattenConfig(prefix []string, m map[string]interface{}, out map[string]interface{}) {
+	for k, v := range m {
+		path := append(append([]string(nil), prefix...), k)
+		if sub, ok := v.(map[string]interface{}); ok && len(sub) > 0 {
+			flattenConfig(path, sub, out)
+			continue
+		}
+		out[strings.Join(path, ".")] = v
+	}
+}
@@ -0,0 +1,135 @@
+package pgbouncer
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Repoint is the outcome of RepointDatabases.
+type Repoint struct {
+	INI string
+	// Changed are the aliases of existing entries now pointing elsewhere;
+	// Added are the entries appended for databases that had none (they
+	// were reached through the "*" fallback, if at all).
+	Changed []string
+	Added   []string
+}
+
+// RepointDatabases rewrites the [databases] entries of ini that connect to
+// one of dbnames (an entry without dbname connects to the database named
+// like its alias) to host:port, keeping their other settings and the rest
+// of the file. Databases without an entry get one.
+func RepointDatabases(ini string, dbnames []string, host string, port int) Repoint {
+	want := map[string]bool{}
+	for _, db := range dbnames {
+		want[db] = true
+	}
+	seen := map[string]bool{}
+
+	var res Repoint
+	lines := strings.Split(ini, "\n")
+	section, end := "", -1
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+			section = strings.ToLower(strings.TrimSpace(trimmed[1 : len(trimmed)-1]))
+			if section == "databases" {
+				end = i + 1
+			}
+			continue
+		}
+		if section != "databases" {
+			continue
+		}
+		alias, conn, ok := strings.Cut(trimmed, "=")
+		if trimmed == "" || trimmed[0] == ';' || trimmed[0] == '#' || !ok {
+			continue
+		}
+		end = i + 1
+		alias = strings.TrimSpace(alias)
+		params := parseConnString(conn)
+		dbname := alias
+		for _, p := range params {
+			if p[0] == "dbname" {
+				dbname = p[1]
+			}
+		}
+		if alias == "*" || !want[dbname] {
+			continue
+		}
+		params = setParam(params, "host", host)
+		params = setParam(params, "port", fmt.Sprint(port))
+		lines[i] = alias + " = " + formatConnString(params)
+		res.Changed = append(res.Changed, alias)
+		seen[dbname] = true
+	}
+
+	var added []string
+	for _, db := range dbnames {
+		if !seen[db] {
+			added = append(added, fmt.Sprintf("%s = host=%s port=%d dbname=%s", db, host, port, db))
+			res.Added = append(res.Added, db)
+		}
+	}
+	if len(added) > 0 {
+		if end < 0 {
+			lines = append(append([]string{"[databases]"}, added...), append([]string{""}, lines...)...)
+		} else {
+			lines = append(lines[:end], append(added, lines[end:]...)...)
+		}
+	}
+	res.INI = strings.Join(lines, "\n")
+	return res
+}
+
+// parseConnString splits a PgBouncer connection string into key/value
+// pairs, in order. Values may be single-quoted.
+func parseConnString(s string) [][2]string {
+	var params [][2]string
+	s = strings.TrimSpace(s)
+	for s != "" {
+		key, rest, ok := strings.Cut(s, "=")
+		if !ok {
+			break
+		}
+		key, rest = strings.TrimSpace(key), strings.TrimLeft(rest, " ")
+		var value string
+		if strings.HasPrefix(rest, "'") {
+			j := strings.Index(rest[1:], "'")
+			if j < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:j+1], rest[j+2:]
+			}
+		} else if j := strings.IndexAny(rest, " \t"); j >= 0 {
+			value, rest = rest[:j], rest[j:]
+		} else {
+			value, rest = rest, ""
+		}
+		params = append(params, [2]string{key, value})
+		s = strings.TrimSpace(rest)
+	}
+	return params
+}
+
+func setParam(params [][2]string, key, value string) [][2]string {
+	for i, p := range params {
+		if p[0] == key {
+			params[i][1] = value
+			return params
+		}
+	}
+	return append(params, [2]string{key, value})
+}
+
+func formatConnString(params [][2]string) string {
+	parts := make([]string, len(params))
+	for i, p := range params {
+		v := p[1]
+		if v == "" || strings.ContainsAny(v, " \t") {
+			v = "'" + v + "'"
+		}
+		parts[i] = p[0] + "=" + v
+	}
+	return strings.Join(parts, " ")
+}
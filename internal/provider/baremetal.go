@@ -0,0 +1,542 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	defaultPatroniUnit   = "patroni"
+	defaultEtcdUnit      = "etcd"
+	defaultPatroniConfig = "/etc/patroni/patroni.yml"
+	defaultEtcdConfig    = "/etc/etcd/etcd.yml"
+	clusterMarkerPath    = "/etc/pgdba/cluster"
+	defaultPatroniPort   = 8008
+	defaultEtcdPort      = 2379
+	partitionChain       = "PGDBA_PARTITION"
+)
+
+// BaremetalProvider implements Provider for VMs and physical hosts reached
+// over SSH. Nodes are not created from nothing: they must be listed in the
+// host inventory, and CreateNode installs and starts the systemd units that
+// run Patroni (or etcd) on them. CreateNode records the owning cluster in
+// /etc/pgdba/cluster on the host; only hosts carrying that record get the
+// pgdba.cluster label, so a shared inventory is not mistaken for one cluster.
+//
+// Recognised config keys:
+//
+//	hosts        "pg1=10.0.0.1,pg2=10.0.0.2:2222" (required; node=host[:sshport])
+//	etcd_hosts   same format, for dedicated etcd nodes
+//	cluster      owning cluster recorded by CreateNode when NodeConfig.Labels has none
+//	user         SSH user (default root)
+//	key_file     private key path (default ~/.ssh/id_ed25519)
+//	known_hosts  known_hosts path (default ~/.ssh/known_hosts)
+//	sudo         "true" to prefix privileged commands with sudo -n
+//	patroni_port Patroni REST port probed by GetNodeStatus (default 8008)
+type BaremetalProvider struct {
+	hosts       map[string]string // node name → host:port
+	roles       map[string]NodeRole
+	cluster     string
+	user        string
+	sudo        bool
+	patroniPort int
+	sshConfig   *ssh.ClientConfig
+
+	mu    sync.Mutex
+	conns map[string]*ssh.Client
+}
+
+func newBaremetalProvider(cfg map[string]string) (*BaremetalProvider, error) {
+	if cfg == nil || cfg["hosts"] == "" {
+		return nil, fmt.Errorf("baremetal provider: host inventory is required (config key \"hosts\")")
+	}
+	b := &BaremetalProvider{
+		hosts:       map[string]string{},
+		roles:       map[string]NodeRole{},
+		cluster:     cfg["cluster"],
+		user:        "root",
+		sudo:        cfg["sudo"] == "true",
+		patroniPort: defaultPatroniPort,
+		conns:       map[string]*ssh.Client{},
+	}
+	if err := b.addHosts(cfg["hosts"], RoleStandby); err != nil {
+		return nil, err
+	}
+	if err := b.addHosts(cfg["etcd_hosts"], RoleEtcd); err != nil {
+		return nil, err
+	}
+	if v := cfg["user"]; v != "" {
+		b.user = v
+	}
+	if v := cfg["patroni_port"]; v != "" {
+		port, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("baremetal provider: invalid patroni_port %q: %w", v, err)
+		}
+		b.patroniPort = port
+	}
+
+	home, _ := os.UserHomeDir()
+	keyFile := cfg["key_file"]
+	if keyFile == "" {
+		keyFile = filepath.Join(home, ".ssh", "id_ed25519")
+	}
+	knownHostsFile := cfg["known_hosts"]
+	if knownHostsFile == "" {
+		knownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+	}
+
+	keyData, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("baremetal provider: read key file: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(keyData)
+	if err != nil {
+		return nil, fmt.Errorf("baremetal provider: parse key file %s: %w", keyFile, err)
+	}
+	hostKeys, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("baremetal provider: load known_hosts: %w", err)
+	}
+
+	b.sshConfig = &ssh.ClientConfig{
+		User:            b.user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeys,
+		Timeout:         10 * time.Second,
+	}
+	return b, nil
+}
+
+// addHosts parses a "name=host[:port]" inventory list into b.hosts.
+func (b *BaremetalProvider) addHosts(list string, role NodeRole) error {
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, addr, ok := strings.Cut(item, "=")
+		if !ok || name == "" || addr == "" {
+			return fmt.Errorf("baremetal provider: invalid inventory entry %q: want name=host[:port]", item)
+		}
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(addr, "22")
+		}
+		b.hosts[name] = addr
+		b.roles[name] = role
+	}
+	return nil
+}
+
+// Type returns the provider identifier string.
+func (b *BaremetalProvider) Type() string { return "baremetal" }
+
+// CreateNode installs cfg.Files, the data directory and a systemd unit on an
+// inventory host, then enables and starts the unit.
+func (b *BaremetalProvider) CreateNode(ctx context.Context, cfg NodeConfig) (NodeStatus, error) {
+	if cfg.Name == "" {
+		return NodeStatus{}, fmt.Errorf("node name is required")
+	}
+	if _, ok := b.hosts[cfg.Name]; !ok {
+		return NodeStatus{}, fmt.Errorf("node %q is not in the baremetal inventory", cfg.Name)
+	}
+	owner := cfg.Labels[LabelCluster]
+	if owner == "" {
+		owner = b.cluster
+	}
+	recorded, err := b.owner(ctx, cfg.Name)
+	if err != nil {
+		return NodeStatus{}, err
+	}
+	if recorded != "" && recorded != owner {
+		return NodeStatus{}, fmt.Errorf("node %q belongs to cluster %q", cfg.Name, recorded)
+	}
+
+	// The units run as postgres or etcd, which must be able to read their files.
+	user := "postgres"
+	if cfg.Role == RoleEtcd {
+		user = "etcd"
+	}
+	paths := make([]string, 0, len(cfg.Files))
+	for p := range cfg.Files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		if err := b.writeFile(ctx, cfg.Name, p, cfg.Files[p], "0600", user); err != nil {
+			return NodeStatus{}, err
+		}
+	}
+
+	if cfg.DataDir != "" && cfg.Role != RoleEtcd {
+		if _, err := b.run(ctx, cfg.Name, b.privileged(
+			"install -d -o postgres -g postgres -m 0700 "+shellQuote(cfg.DataDir)), ""); err != nil {
+			return NodeStatus{}, fmt.Errorf("create data dir on %s: %w", cfg.Name, err)
+		}
+	}
+
+	if owner != "" {
+		if err := b.writeFile(ctx, cfg.Name, clusterMarkerPath, owner+"\n", "0644", ""); err != nil {
+			return NodeStatus{}, err
+		}
+	}
+	unit := unitFor(cfg.Role)
+	// The environment may carry passwords; the unit file is world-readable.
+	if err := b.writeFile(ctx, cfg.Name, envPath(unit), renderEnv(cfg.Env), "0600", ""); err != nil {
+		return NodeStatus{}, err
+	}
+	if err := b.writeFile(ctx, cfg.Name, unitPath(unit), renderUnit(cfg), "0644", ""); err != nil {
+		return NodeStatus{}, err
+	}
+	if _, err := b.run(ctx, cfg.Name, b.privileged(
+		"systemctl daemon-reload && systemctl enable --now "+unit), ""); err != nil {
+		return NodeStatus{}, fmt.Errorf("start %s on %s: %w", unit, cfg.Name, err)
+	}
+	return b.GetNodeStatus(ctx, cfg.Name)
+}
+
+// DestroyNode stops and removes the pgdba-installed unit and the cluster
+// record on the node. The data directory is left in place.
+func (b *BaremetalProvider) DestroyNode(ctx context.Context, id string) error {
+	if id == "" {
+		return fmt.Errorf("node id is required")
+	}
+	if _, ok := b.hosts[id]; !ok {
+		return fmt.Errorf("node %q is not in the baremetal inventory", id)
+	}
+	unit := unitFor(b.roles[id])
+	script := fmt.Sprintf("systemctl disable --now %s || true; rm -f %s %s %s; systemctl daemon-reload",
+		unit, unitPath(unit), envPath(unit), clusterMarkerPath)
+	if _, err := b.run(ctx, id, b.privileged(script), ""); err != nil {
+		return fmt.Errorf("remove %s on %s: %w", unit, id, err)
+	}
+	return nil
+}
+
+// ExecOnNode runs a command on the host identified by id over SSH.
+func (b *BaremetalProvider) ExecOnNode(ctx context.Context, id string, cmd []string) (string, error) {
+	if id == "" {
+		return "", fmt.Errorf("node id is required")
+	}
+	if len(cmd) == 0 {
+		return "", fmt.Errorf("command is required")
+	}
+	quoted := make([]string, len(cmd))
+	for i, arg := range cmd {
+		quoted[i] = shellQuote(arg)
+	}
+	return b.run(ctx, id, strings.Join(quoted, " "), "")
+}
+
+// GetNodeStatus reports the node's systemd unit state. A running node is
+// healthy when its service port (Patroni REST or etcd client) is listening;
+// a Patroni node answering 200 on /primary is reported as RolePrimary. The
+// pgdba.cluster label is the cluster recorded on the host, if any.
+func (b *BaremetalProvider) GetNodeStatus(ctx context.Context, id string) (NodeStatus, error) {
+	if id == "" {
+		return NodeStatus{}, fmt.Errorf("node id is required")
+	}
+	addr, ok := b.hosts[id]
+	if !ok {
+		return NodeStatus{}, fmt.Errorf("node %q is not in the baremetal inventory", id)
+	}
+	host, _, _ := net.SplitHostPort(addr)
+	role := b.roles[id]
+	st := NodeStatus{ID: id, Host: host, Role: role, Labels: b.labels(id, "")}
+
+	owner, err := b.owner(ctx, id)
+	if err != nil {
+		return st, err
+	}
+	st.Labels = b.labels(id, owner)
+
+	unit := unitFor(role)
+	// systemctl is-active exits non-zero for inactive units; only stdout matters.
+	state, err := b.run(ctx, id, "systemctl is-active "+unit, "")
+	if err != nil && strings.TrimSpace(state) == "" {
+		return st, fmt.Errorf("query %s on %s: %w", unit, id, err)
+	}
+	st.Running = strings.TrimSpace(state) == "active"
+	if !st.Running {
+		return st, nil
+	}
+
+	port := b.patroniPort
+	if role == RoleEtcd {
+		port = defaultEtcdPort
+	}
+	listening, err := b.run(ctx, id, fmt.Sprintf("ss -Hltn 'sport = :%d'", port), "")
+	st.Healthy = err == nil && strings.TrimSpace(listening) != ""
+
+	if st.Healthy && role != RoleEtcd {
+		code, err := b.run(ctx, id, fmt.Sprintf(
+			"curl -s -o /dev/null -w '%%{http_code}' http://127.0.0.1:%d/primary", port), "")
+		if err == nil && strings.TrimSpace(code) == "200" {
+			st.Role = RolePrimary
+		}
+	}
+	return st, nil
+}
+
+// ListNodes returns the status of every inventory host. Hosts that cannot be
+// reached are reported as not running rather than failing the whole listing;
+// those whose cluster record could not be read get LabelOwnerUnknown.
+func (b *BaremetalProvider) ListNodes(ctx context.Context) ([]NodeStatus, error) {
+	names := make([]string, 0, len(b.hosts))
+	for name := range b.hosts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	nodes := make([]NodeStatus, 0, len(names))
+	for _, name := range names {
+		st, err := b.GetNodeStatus(ctx, name)
+		if err != nil {
+			host, _, _ := net.SplitHostPort(b.hosts[name])
+			owner := st.Labels[LabelCluster]
+			st = NodeStatus{ID: name, Host: host, Role: b.roles[name], Labels: b.labels(name, owner)}
+			if owner == "" {
+				st.Labels[LabelOwnerUnknown] = "true"
+			}
+		}
+		nodes = append(nodes, st)
+	}
+	return nodes, nil
+}
+
+// PartitionNode isolates or reconnects the host identified by id using an
+// iptables chain that drops traffic to and from every other inventory host.
+// SSH from the pgdba controller is unaffected so the partition can be healed.
+func (b *BaremetalProvider) PartitionNode(ctx context.Context, id string, isolate bool) error {
+	if id == "" {
+		return fmt.Errorf("node id is required")
+	}
+	if _, ok := b.hosts[id]; !ok {
+		return fmt.Errorf("node %q is not in the baremetal inventory", id)
+	}
+
+	var script string
+	if isolate {
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "iptables -N %[1]s 2>/dev/null || iptables -F %[1]s", partitionChain)
+		for _, peer := range b.peers(id) {
+			fmt.Fprintf(&sb, " && iptables -A %[1]s -s %[2]s -j DROP && iptables -A %[1]s -d %[2]s -j DROP",
+				partitionChain, peer)
+		}
+		for _, hook := range []string{"INPUT", "OUTPUT"} {
+			fmt.Fprintf(&sb, " && (iptables -C %[1]s -j %[2]s 2>/dev/null || iptables -I %[1]s -j %[2]s)",
+				hook, partitionChain)
+		}
+		script = sb.String()
+	} else {
+		script = fmt.Sprintf("iptables -D INPUT -j %[1]s 2>/dev/null; iptables -D OUTPUT -j %[1]s 2>/dev/null; "+
+			"iptables -F %[1]s 2>/dev/null; iptables -X %[1]s 2>/dev/null; true", partitionChain)
+	}
+	if _, err := b.run(ctx, id, b.privileged(script), ""); err != nil {
+		return fmt.Errorf("partition %s (isolate=%t): %w", id, isolate, err)
+	}
+	return nil
+}
+
+// peers returns the sorted, de-duplicated addresses of every other inventory host.
+func (b *BaremetalProvider) peers(id string) []string {
+	self, _, _ := net.SplitHostPort(b.hosts[id])
+	seen := map[string]bool{self: true}
+	var peers []string
+	for name, addr := range b.hosts {
+		host, _, _ := net.SplitHostPort(addr)
+		if name == id || seen[host] {
+			continue
+		}
+		seen[host] = true
+		peers = append(peers, host)
+	}
+	sort.Strings(peers)
+	return peers
+}
+
+// labels returns the labels reported for an inventory node owned by cluster
+// owner ("" when no cluster is recorded on it).
+func (b *BaremetalProvider) labels(id, owner string) map[string]string {
+	labels := map[string]string{"pgdba.node": id}
+	if owner != "" {
+		labels[LabelCluster] = owner
+	}
+	return labels
+}
+
+// owner returns the cluster recorded on node id by CreateNode, or "".
+func (b *BaremetalProvider) owner(ctx context.Context, id string) (string, error) {
+	out, err := b.run(ctx, id, "cat "+clusterMarkerPath+" 2>/dev/null || true", "")
+	if err != nil {
+		return "", fmt.Errorf("read cluster record on %s: %w", id, err)
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// privileged prefixes a shell script with sudo when configured.
+func (b *BaremetalProvider) privileged(script string) string {
+	if !b.sudo {
+		return "sh -c " + shellQuote(script)
+	}
+	return "sudo -n sh -c " + shellQuote(script)
+}
+
+// writeFile streams content to path on the node, creating parent
+// directories. A non-empty owner is given the file, user and group.
+func (b *BaremetalProvider) writeFile(ctx context.Context, id, p, content, mode, owner string) error {
+	script := fmt.Sprintf("mkdir -p %s && cat > %s && chmod %s %s",
+		shellQuote(path.Dir(p)), shellQuote(p), mode, shellQuote(p))
+	if owner != "" {
+		script += fmt.Sprintf(" && chown %s:%s %s", owner, owner, shellQuote(p))
+	}
+	if _, err := b.run(ctx, id, b.privileged(script), content); err != nil {
+		return fmt.Errorf("write %s on %s: %w", p, id, err)
+	}
+	return nil
+}
+
+// run executes command on node id, feeding stdin, and returns stdout.
+// A non-zero exit status is returned as an error that includes stderr.
+func (b *BaremetalProvider) run(ctx context.Context, id, command, stdin string) (string, error) {
+	client, err := b.client(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		b.dropClient(id)
+		return "", fmt.Errorf("open ssh session to %s: %w", id, err)
+	}
+	defer session.Close()
+
+	var stdout, stderr bytes.Buffer
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+	session.Stdin = strings.NewReader(stdin)
+
+	done := make(chan error, 1)
+	go func() { done <- session.Run(command) }()
+	select {
+	case <-ctx.Done():
+		_ = session.Signal(ssh.SIGKILL)
+		return stdout.String(), ctx.Err()
+	case err := <-done:
+		if err != nil {
+			return stdout.String(), fmt.Errorf("ssh %s: %w: %s", id, err, strings.TrimSpace(stderr.String()))
+		}
+		return stdout.String(), nil
+	}
+}
+
+// client returns a cached SSH connection to node id, dialling on first use.
+func (b *BaremetalProvider) client(ctx context.Context, id string) (*ssh.Client, error) {
+	addr, ok := b.hosts[id]
+	if !ok {
+		return nil, fmt.Errorf("node %q is not in the baremetal inventory", id)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c, ok := b.conns[id]; ok {
+		return c, nil
+	}
+
+	d := net.Dialer{Timeout: b.sshConfig.Timeout}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("dial %s (%s): %w", id, addr, err)
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, b.sshConfig)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("ssh handshake with %s (%s): %w", id, addr, err)
+	}
+	c := ssh.NewClient(sshConn, chans, reqs)
+	b.conns[id] = c
+	return c, nil
+}
+
+// dropClient closes and forgets a cached connection after a failure.
+func (b *BaremetalProvider) dropClient(id string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c, ok := b.conns[id]; ok {
+		c.Close()
+		delete(b.conns, id)
+	}
+}
+
+// unitFor returns the systemd unit name that runs the given role.
+func unitFor(role NodeRole) string {
+	if role == RoleEtcd {
+		return defaultEtcdUnit
+	}
+	return defaultPatroniUnit
+}
+
+// unitPath returns the unit file location for a pgdba-installed unit.
+func unitPath(unit string) string {
+	return "/etc/systemd/system/" + unit + ".service"
+}
+
+// envPath returns the environment file a pgdba-installed unit reads.
+func envPath(unit string) string {
+	return "/etc/pgdba/" + unit + ".env"
+}
+
+// renderEnv produces the systemd environment file holding env.
+func renderEnv(env map[string]string) string {
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s=%q\n", k, env[k])
+	}
+	return b.String()
+}
+
+// renderUnit produces the systemd unit file for a node.
+func renderUnit(cfg NodeConfig) string {
+	var b strings.Builder
+	if cfg.Role == RoleEtcd {
+		b.WriteString("[Unit]\nDescription=etcd key-value store (managed by pgdba)\n" +
+			"After=network-online.target\nWants=network-online.target\n\n[Service]\nType=notify\n" +
+			"User=etcd\nExecStart=/usr/bin/etcd --config-file " + defaultEtcdConfig + "\n")
+	} else {
+		b.WriteString("[Unit]\nDescription=Patroni PostgreSQL HA (managed by pgdba)\n" +
+			"After=network-online.target\nWants=network-online.target\n\n[Service]\nType=simple\n" +
+			"User=postgres\nGroup=postgres\nExecStart=/usr/bin/patroni " + defaultPatroniConfig + "\n" +
+			"ExecReload=/bin/kill -s HUP $MAINPID\nKillMode=process\nTimeoutSec=30\n")
+	}
+	b.WriteString("EnvironmentFile=" + envPath(unitFor(cfg.Role)) + "\n")
+	b.WriteString("Restart=on-failure\n\n[Install]\nWantedBy=multi-user.target\n")
+	return b.String()
+}
+
+// shellQuote quotes s for safe use as a single POSIX shell word.
+func shellQuote(s string) string {
+	if s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return !(r == '-' || r == '_' || r == '.' || r == '/' || r == ':' || r == '=' || r == ',' ||
+			(r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9'))
+	}) < 0 {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
@@ -1,78 +1,553 @@
 package provider
 
 import (
+	"archive/tar"
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
 )
 
-// DockerProvider implements Provider for Docker/container environments.
-// Full Docker SDK integration is covered by integration tests; the unit
-// surface validates input contracts without requiring a running Docker daemon.
+// Default images used when NodeConfig.Image is empty. They match the
+// deployments/docker stack.
+const (
+	defaultDockerHost      = "unix:///var/run/docker.sock"
+	defaultPostgresImage   = "ghcr.io/zalando/spilo-16:3.3-p3"
+	defaultEtcdImage       = "quay.io/coreos/etcd:v3.5.9"
+	dockerManagedLabelTrue = "true"
+)
+
+// DockerProvider implements Provider for Docker/container environments by
+// talking to the Docker Engine HTTP API. Every container it creates is
+// attached to networkName and labelled with labelPrefix.* keys so that
+// ListNodes only returns pgdba-managed containers.
+//
+// Recognised config keys: "network", "host" (daemon address, e.g.
+// unix:///var/run/docker.sock or tcp://127.0.0.1:2375), "image" and
+// "etcd_image".
 type DockerProvider struct {
 	networkName string
 	labelPrefix string
+	image       string
+	etcdImage   string
+	client      *dockerClient
 }
 
-func newDockerProvider(cfg map[string]string) *DockerProvider {
+func newDockerProvider(cfg map[string]string) (*DockerProvider, error) {
 	network := "pgdba-net"
+	host := defaultDockerHost
+	image := defaultPostgresImage
+	etcdImage := defaultEtcdImage
 	if cfg != nil {
 		if v, ok := cfg["network"]; ok {
 			network = v
 		}
+		if v, ok := cfg["host"]; ok && v != "" {
+			host = v
+		}
+		if v, ok := cfg["image"]; ok && v != "" {
+			image = v
+		}
+		if v, ok := cfg["etcd_image"]; ok && v != "" {
+			etcdImage = v
+		}
+	}
+	client, err := newDockerClient(host)
+	if err != nil {
+		return nil, err
 	}
 	return &DockerProvider{
 		networkName: network,
 		labelPrefix: "pgdba",
-	}
+		image:       image,
+		etcdImage:   etcdImage,
+		client:      client,
+	}, nil
 }
 
 // Type returns the provider identifier string.
 func (d *DockerProvider) Type() string { return "docker" }
 
-// CreateNode provisions a new container node for the cluster.
-func (d *DockerProvider) CreateNode(_ context.Context, cfg NodeConfig) (NodeStatus, error) {
+// label returns the fully-qualified label key for the given suffix.
+func (d *DockerProvider) label(suffix string) string {
+	return d.labelPrefix + "." + suffix
+}
+
+// CreateNode provisions a new container node for the cluster: it ensures the
+// cluster network and the image exist, creates a labelled container with a
+// named data volume, uploads cfg.Files, starts it and returns its status. A
+// container that fails to come up is removed again, so that CreateNode can
+// be retried under the same name.
+func (d *DockerProvider) CreateNode(ctx context.Context, cfg NodeConfig) (NodeStatus, error) {
 	if cfg.Name == "" {
 		return NodeStatus{}, fmt.Errorf("node name is required")
 	}
-	return NodeStatus{}, fmt.Errorf("not implemented: requires Docker SDK integration test")
+	if err := d.ensureNetwork(ctx); err != nil {
+		return NodeStatus{}, err
+	}
+
+	body := d.containerSpec(cfg)
+	if err := d.ensureImage(ctx, body.Image); err != nil {
+		return NodeStatus{}, err
+	}
+	var created struct {
+		ID string `json:"Id"`
+	}
+	if err := d.client.do(ctx, http.MethodPost, "/containers/create",
+		url.Values{"name": {cfg.Name}}, body, &created); err != nil {
+		return NodeStatus{}, fmt.Errorf("create container %s: %w", cfg.Name, err)
+	}
+	if err := d.startCreated(ctx, cfg, created.ID); err != nil {
+		return NodeStatus{}, d.removeCreated(ctx, created.ID, err)
+	}
+	return d.GetNodeStatus(ctx, cfg.Name)
+}
+
+// startCreated uploads cfg.Files to the created container id and starts it.
+func (d *DockerProvider) startCreated(ctx context.Context, cfg NodeConfig, id string) error {
+	if len(cfg.Files) > 0 {
+		archive, err := tarFiles(cfg.Files)
+		if err != nil {
+			return fmt.Errorf("pack files for %s: %w", cfg.Name, err)
+		}
+		if err := d.client.putArchive(ctx, id, "/", archive); err != nil {
+			return fmt.Errorf("upload files to %s: %w", cfg.Name, err)
+		}
+	}
+	if err := d.client.do(ctx, http.MethodPost, "/containers/"+url.PathEscape(id)+"/start",
+		nil, nil, nil); err != nil {
+		return fmt.Errorf("start container %s: %w", cfg.Name, err)
+	}
+	return nil
+}
+
+// removeCreated force-removes container id after cause, even if ctx is done,
+// and returns cause together with any removal failure.
+func (d *DockerProvider) removeCreated(ctx context.Context, id string, cause error) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+	defer cancel()
+	if err := d.client.do(ctx, http.MethodDelete, "/containers/"+url.PathEscape(id),
+		url.Values{"force": {"true"}}, nil, nil); err != nil && !isNotFound(err) {
+		return fmt.Errorf("%w (and remove container %s: %v)", cause, id, err)
+	}
+	return cause
+}
+
+// ensureImage pulls image unless the daemon already has it. The pull
+// progress is streamed as JSON messages; a failure arrives as one with an
+// error field, after the HTTP 200.
+func (d *DockerProvider) ensureImage(ctx context.Context, image string) error {
+	err := d.client.do(ctx, http.MethodGet, "/images/"+image+"/json", nil, nil, nil)
+	if err == nil {
+		return nil
+	}
+	if !isNotFound(err) {
+		return fmt.Errorf("inspect image %s: %w", image, err)
+	}
+	resp, err := d.client.send(ctx, http.MethodPost, "/images/create", url.Values{"fromImage": {image}}, nil)
+	if err != nil {
+		return fmt.Errorf("pull image %s: %w", image, err)
+	}
+	defer resp.Body.Close()
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var msg struct {
+			Error string `json:"error"`
+		}
+		if err := dec.Decode(&msg); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("pull image %s: read progress: %w", image, err)
+		}
+		if msg.Error != "" {
+			return fmt.Errorf("pull image %s: %s", image, msg.Error)
+		}
+	}
+}
+
+// containerSpec builds the Engine API create payload for cfg.
+func (d *DockerProvider) containerSpec(cfg NodeConfig) dockerContainerCreate {
+	image := cfg.Image
+	if image == "" {
+		image = d.image
+		if cfg.Role == RoleEtcd {
+			image = d.etcdImage
+		}
+	}
+
+	labels := map[string]string{
+		d.label("managed"): dockerManagedLabelTrue,
+		d.label("role"):    string(cfg.Role),
+		d.label("node"):    cfg.Name,
+	}
+	for k, v := range cfg.Labels {
+		labels[k] = v
+	}
+	if len(cfg.Files) > 0 {
+		paths := make([]string, 0, len(cfg.Files))
+		for p := range cfg.Files {
+			paths = append(paths, p)
+		}
+		sort.Strings(paths)
+		labels[d.label("files")] = strings.Join(paths, ",")
+	}
+
+	env := make([]string, 0, len(cfg.Env))
+	for k, v := range cfg.Env {
+		env = append(env, k+"="+v)
+	}
+	sort.Strings(env)
+
+	spec := dockerContainerCreate{
+		Image:    image,
+		Hostname: cfg.Name,
+		Env:      env,
+		Labels:   labels,
+		HostConfig: dockerHostConfig{
+			NetworkMode:   d.networkName,
+			RestartPolicy: dockerRestartPolicy{Name: "unless-stopped"},
+		},
+		NetworkingConfig: dockerNetworkingConfig{
+			EndpointsConfig: map[string]dockerEndpointConfig{
+				d.networkName: {Aliases: []string{cfg.Name}},
+			},
+		},
+	}
+	if cfg.DataDir != "" {
+		spec.HostConfig.Binds = []string{dataVolumeName(cfg.Name) + ":" + cfg.DataDir}
+	}
+	if cfg.Port > 0 {
+		spec.ExposedPorts = map[string]struct{}{fmt.Sprintf("%d/tcp", cfg.Port): {}}
+	}
+	return spec
 }
 
-// DestroyNode stops and removes the container identified by id.
-func (d *DockerProvider) DestroyNode(_ context.Context, id string) error {
+// dataVolumeName returns the named volume that holds a node's data directory.
+func dataVolumeName(node string) string {
+	return node + "-data"
+}
+
+// ensureNetwork creates the cluster bridge network if it does not exist yet.
+func (d *DockerProvider) ensureNetwork(ctx context.Context) error {
+	err := d.client.do(ctx, http.MethodGet, "/networks/"+url.PathEscape(d.networkName), nil, nil, nil)
+	if err == nil {
+		return nil
+	}
+	if !isNotFound(err) {
+		return fmt.Errorf("inspect network %s: %w", d.networkName, err)
+	}
+	payload := map[string]interface{}{
+		"Name":   d.networkName,
+		"Driver": "bridge",
+		"Labels": map[string]string{d.label("managed"): dockerManagedLabelTrue},
+	}
+	if err := d.client.do(ctx, http.MethodPost, "/networks/create", nil, payload, nil); err != nil {
+		return fmt.Errorf("create network %s: %w", d.networkName, err)
+	}
+	return nil
+}
+
+// DestroyNode stops and removes the container identified by id. The named data
+// volume is kept so that a node can be recreated without re-cloning.
+func (d *DockerProvider) DestroyNode(ctx context.Context, id string) error {
 	if id == "" {
 		return fmt.Errorf("node id is required")
 	}
-	return fmt.Errorf("not implemented: requires Docker SDK integration test")
+	err := d.client.do(ctx, http.MethodDelete, "/containers/"+url.PathEscape(id),
+		url.Values{"force": {"true"}}, nil, nil)
+	if err != nil && !isNotFound(err) {
+		return fmt.Errorf("remove container %s: %w", id, err)
+	}
+	return nil
 }
 
-// ExecOnNode runs a command inside the container identified by id.
-func (d *DockerProvider) ExecOnNode(_ context.Context, id string, cmd []string) (string, error) {
+// UpdateNodeImage recreates the container id from image. Its environment,
+// labels, data volume and the files uploaded by CreateNode (listed in the
+// files label) carry over to the new container.
+func (d *DockerProvider) UpdateNodeImage(ctx context.Context, id, image string) error {
+	if id == "" {
+		return fmt.Errorf("node id is required")
+	}
+	var info dockerContainerInspect
+	if err := d.client.do(ctx, http.MethodGet, "/containers/"+url.PathEscape(id)+"/json",
+		nil, nil, &info); err != nil {
+		return fmt.Errorf("inspect container %s: %w", id, err)
+	}
+	name := strings.TrimPrefix(info.Name, "/")
+
+	archives := map[string][]byte{}
+	for _, p := range strings.Split(info.Config.Labels[d.label("files")], ",") {
+		if p == "" {
+			continue
+		}
+		archive, err := d.client.getArchive(ctx, info.ID, p)
+		if err != nil {
+			return fmt.Errorf("copy %s from %s: %w", p, name, err)
+		}
+		archives[p] = archive
+	}
+
+	spec := dockerContainerCreate{
+		Image:        image,
+		Hostname:     info.Config.Hostname,
+		Env:          info.Config.Env,
+		Labels:       info.Config.Labels,
+		ExposedPorts: info.Config.ExposedPorts,
+		HostConfig: dockerHostConfig{
+			NetworkMode:   d.networkName,
+			Binds:         info.HostConfig.Binds,
+			RestartPolicy: dockerRestartPolicy{Name: "unless-stopped"},
+		},
+		NetworkingConfig: dockerNetworkingConfig{
+			EndpointsConfig: map[string]dockerEndpointConfig{
+				d.networkName: {Aliases: []string{name}},
+			},
+		},
+	}
+	if err := d.ensureImage(ctx, image); err != nil {
+		return err
+	}
+	if err := d.DestroyNode(ctx, name); err != nil {
+		return err
+	}
+	var created struct {
+		ID string `json:"Id"`
+	}
+	if err := d.client.do(ctx, http.MethodPost, "/containers/create",
+		url.Values{"name": {name}}, spec, &created); err != nil {
+		return fmt.Errorf("create container %s: %w", name, err)
+	}
+	for p, archive := range archives {
+		if err := d.client.putArchive(ctx, created.ID, path.Dir(p), archive); err != nil {
+			return fmt.Errorf("restore %s to %s: %w", p, name, err)
+		}
+	}
+	if err := d.client.do(ctx, http.MethodPost, "/containers/"+url.PathEscape(created.ID)+"/start",
+		nil, nil, nil); err != nil {
+		return fmt.Errorf("start container %s: %w", name, err)
+	}
+	return nil
+}
+
+// PurgeNodeData removes the named data volume of a destroyed node.
+func (d *DockerProvider) PurgeNodeData(ctx context.Context, id string) error {
+	if id == "" {
+		return fmt.Errorf("node id is required")
+	}
+	vol := dataVolumeName(id)
+	err := d.client.do(ctx, http.MethodDelete, "/volumes/"+url.PathEscape(vol), nil, nil, nil)
+	if err != nil && !isNotFound(err) {
+		return fmt.Errorf("remove volume %s: %w", vol, err)
+	}
+	return nil
+}
+
+// ExecOnNode runs a command inside the container identified by id and returns
+// its stdout. A non-zero exit code is reported as an error including stderr.
+func (d *DockerProvider) ExecOnNode(ctx context.Context, id string, cmd []string) (string, error) {
 	if id == "" {
 		return "", fmt.Errorf("node id is required")
 	}
 	if len(cmd) == 0 {
 		return "", fmt.Errorf("command is required")
 	}
-	return "", fmt.Errorf("not implemented: requires Docker SDK integration test")
+
+	var created struct {
+		ID string `json:"Id"`
+	}
+	if err := d.client.do(ctx, http.MethodPost, "/containers/"+url.PathEscape(id)+"/exec", nil,
+		dockerExecCreate{AttachStdout: true, AttachStderr: true, Cmd: cmd}, &created); err != nil {
+		return "", fmt.Errorf("create exec on %s: %w", id, err)
+	}
+
+	resp, err := d.client.send(ctx, http.MethodPost, "/exec/"+url.PathEscape(created.ID)+"/start", nil,
+		map[string]bool{"Detach": false, "Tty": false})
+	if err != nil {
+		return "", fmt.Errorf("start exec on %s: %w", id, err)
+	}
+	stdout, stderr, err := demuxDockerStream(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return stdout, fmt.Errorf("read exec output on %s: %w", id, err)
+	}
+
+	code, err := d.execExitCode(ctx, created.ID)
+	if err != nil {
+		return stdout, fmt.Errorf("inspect exec on %s: %w", id, err)
+	}
+	if code != 0 {
+		return stdout, fmt.Errorf("command %q on %s exited with code %d: %s",
+			strings.Join(cmd, " "), id, code, strings.TrimSpace(stderr))
+	}
+	return stdout, nil
+}
+
+// execExitCode waits for the exec to be marked finished and returns its exit code.
+func (d *DockerProvider) execExitCode(ctx context.Context, execID string) (int, error) {
+	for {
+		var info dockerExecInspect
+		if err := d.client.do(ctx, http.MethodGet, "/exec/"+url.PathEscape(execID)+"/json",
+			nil, nil, &info); err != nil {
+			return 0, err
+		}
+		if !info.Running {
+			return info.ExitCode, nil
+		}
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(dockerExecPollInterval):
+		}
+	}
 }
 
 // GetNodeStatus returns the runtime status of the container identified by id.
-func (d *DockerProvider) GetNodeStatus(_ context.Context, id string) (NodeStatus, error) {
+func (d *DockerProvider) GetNodeStatus(ctx context.Context, id string) (NodeStatus, error) {
 	if id == "" {
 		return NodeStatus{}, fmt.Errorf("node id is required")
 	}
-	return NodeStatus{}, fmt.Errorf("not implemented: requires Docker SDK integration test")
+	var info dockerContainerInspect
+	if err := d.client.do(ctx, http.MethodGet, "/containers/"+url.PathEscape(id)+"/json",
+		nil, nil, &info); err != nil {
+		return NodeStatus{}, fmt.Errorf("inspect container %s: %w", id, err)
+	}
+	return d.statusFromInspect(info), nil
+}
+
+// statusFromInspect maps a container inspect payload to a NodeStatus.
+// A container without a HEALTHCHECK is considered healthy while running.
+func (d *DockerProvider) statusFromInspect(info dockerContainerInspect) NodeStatus {
+	st := NodeStatus{
+		ID:      strings.TrimPrefix(info.Name, "/"),
+		Role:    NodeRole(info.Config.Labels[d.label("role")]),
+		Running: info.State.Running,
+		Labels:  info.Config.Labels,
+	}
+	if n, ok := info.NetworkSettings.Networks[d.networkName]; ok && n.IPAddress != "" {
+		st.Host = n.IPAddress
+	} else {
+		st.Host = info.Config.Hostname
+	}
+	if info.State.Health != nil {
+		st.Healthy = info.State.Running && info.State.Health.Status == "healthy"
+	} else {
+		st.Healthy = info.State.Running
+	}
+	return st
 }
 
 // ListNodes returns the status of all cluster containers managed by this provider.
-func (d *DockerProvider) ListNodes(_ context.Context) ([]NodeStatus, error) {
-	return nil, fmt.Errorf("not implemented: requires Docker SDK integration test")
+func (d *DockerProvider) ListNodes(ctx context.Context) ([]NodeStatus, error) {
+	filters, err := json.Marshal(map[string][]string{
+		"label": {d.label("managed") + "=" + dockerManagedLabelTrue},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("marshal filters: %w", err)
+	}
+	var summaries []dockerContainerSummary
+	if err := d.client.do(ctx, http.MethodGet, "/containers/json",
+		url.Values{"all": {"true"}, "filters": {string(filters)}}, nil, &summaries); err != nil {
+		return nil, fmt.Errorf("list containers: %w", err)
+	}
+
+	nodes := make([]NodeStatus, 0, len(summaries))
+	for _, s := range summaries {
+		id := s.ID
+		if len(s.Names) > 0 {
+			id = strings.TrimPrefix(s.Names[0], "/")
+		}
+		st, err := d.GetNodeStatus(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, st)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+	return nodes, nil
 }
 
 // PartitionNode isolates or reconnects the container identified by id to the cluster network.
-func (d *DockerProvider) PartitionNode(_ context.Context, id string, _ bool) error {
+// Isolation disconnects the container from networkName; reconnecting restores
+// its node-name alias so peers can resolve it again.
+func (d *DockerProvider) PartitionNode(ctx context.Context, id string, isolate bool) error {
 	if id == "" {
 		return fmt.Errorf("node id is required")
 	}
-	return fmt.Errorf("not implemented: requires Docker SDK integration test")
+	base := "/networks/" + url.PathEscape(d.networkName)
+	if isolate {
+		payload := map[string]interface{}{"Container": id, "Force": true}
+		if err := d.client.do(ctx, http.MethodPost, base+"/disconnect", nil, payload, nil); err != nil {
+			return fmt.Errorf("disconnect %s from %s: %w", id, d.networkName, err)
+		}
+		return nil
+	}
+	payload := map[string]interface{}{
+		"Container":      id,
+		"EndpointConfig": dockerEndpointConfig{Aliases: []string{id}},
+	}
+	if err := d.client.do(ctx, http.MethodPost, base+"/connect", nil, payload, nil); err != nil {
+		return fmt.Errorf("connect %s to %s: %w", id, d.networkName, err)
+	}
+	return nil
+}
+
+// tarFiles packs path → content pairs into a tar archive rooted at "/".
+// Parent directories are emitted first so the daemon can create them.
+func tarFiles(files map[string]string) ([]byte, error) {
+	paths := make([]string, 0, len(files))
+	for p := range files {
+		if !path.IsAbs(p) {
+			return nil, fmt.Errorf("file path %q must be absolute", p)
+		}
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	seenDirs := map[string]bool{}
+	for _, p := range paths {
+		for _, dir := range parentDirs(p) {
+			if seenDirs[dir] {
+				continue
+			}
+			seenDirs[dir] = true
+			if err := tw.WriteHeader(&tar.Header{Name: dir + "/", Typeflag: tar.TypeDir, Mode: 0o755}); err != nil {
+				return nil, err
+			}
+		}
+		content := files[p]
+		hdr := &tar.Header{Name: strings.TrimPrefix(p, "/"), Mode: 0o600, Size: int64(len(content))}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// parentDirs returns the relative parent directories of an absolute path,
+// outermost first: "/etc/patroni/patroni.yml" → ["etc", "etc/patroni"].
+func parentDirs(p string) []string {
+	parts := strings.Split(strings.Trim(path.Dir(p), "/"), "/")
+	var dirs []string
+	for i := range parts {
+		if parts[i] == "" {
+			continue
+		}
+		dirs = append(dirs, strings.Join(parts[:i+1], "/"))
+	}
+	return dirs
 }
@@ -0,0 +1,276 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// dockerAPIVersion is the Engine API version prefix sent with every request.
+// v1.41 is supported by Docker Engine 20.10+.
+const dockerAPIVersion = "v1.41"
+
+// dockerClient is a minimal Docker Engine HTTP API client. It speaks to the
+// daemon over a unix socket (unix:///var/run/docker.sock) or plain TCP
+// (tcp://host:2375, http://host:2375) without pulling in the Docker SDK.
+type dockerClient struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+// newDockerClient builds a client for the given daemon address.
+func newDockerClient(host string) (*dockerClient, error) {
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("parse docker host %q: %w", host, err)
+	}
+
+	switch u.Scheme {
+	case "unix":
+		socket := u.Path
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		}
+		return &dockerClient{
+			baseURL:    "http://docker/" + dockerAPIVersion,
+			httpClient: &http.Client{Transport: transport},
+		}, nil
+	case "tcp", "http":
+		return &dockerClient{
+			baseURL:    "http://" + u.Host + "/" + dockerAPIVersion,
+			httpClient: &http.Client{},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported docker host scheme %q: must be unix, tcp, or http", u.Scheme)
+	}
+}
+
+// do sends a request to the Engine API and decodes a JSON response into out
+// (if out is non-nil). Non-2xx responses are converted to errors that carry
+// the daemon's "message" field.
+func (c *dockerClient) do(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	resp, err := c.send(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode docker %s %s: %w", method, path, err)
+	}
+	return nil
+}
+
+// send issues the request and returns the raw response after checking the
+// status code. The caller must close the response body.
+func (c *dockerClient) send(ctx context.Context, method, path string, query url.Values, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("marshal docker request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	target := c.baseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return nil, fmt.Errorf("build request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("docker %s %s: %w", method, path, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&apiErr)
+		if apiErr.Message == "" {
+			apiErr.Message = http.StatusText(resp.StatusCode)
+		}
+		return nil, &dockerAPIError{StatusCode: resp.StatusCode, Message: apiErr.Message,
+			Method: method, Path: path}
+	}
+	return resp, nil
+}
+
+// putArchive uploads a tar archive and extracts it at dir inside the container.
+func (c *dockerClient) putArchive(ctx context.Context, id, dir string, archive []byte) error {
+	target := c.baseURL + "/containers/" + url.PathEscape(id) + "/archive?" +
+		url.Values{"path": {dir}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, target, bytes.NewReader(archive))
+	if err != nil {
+		return fmt.Errorf("build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-tar")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("docker PUT archive: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &dockerAPIError{StatusCode: resp.StatusCode, Message: http.StatusText(resp.StatusCode),
+			Method: http.MethodPut, Path: "/containers/" + id + "/archive"}
+	}
+	return nil
+}
+
+// getArchive downloads path from the container as a tar archive whose single
+// top-level entry is the base name of path.
+func (c *dockerClient) getArchive(ctx context.Context, id, path string) ([]byte, error) {
+	resp, err := c.send(ctx, http.MethodGet, "/containers/"+url.PathEscape(id)+"/archive",
+		url.Values{"path": {path}}, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// dockerAPIError is returned when the Engine API responds with a non-2xx status.
+type dockerAPIError struct {
+	StatusCode int
+	Message    string
+	Method     string
+	Path       string
+}
+
+func (e *dockerAPIError) Error() string {
+	return fmt.Sprintf("docker %s %s returned HTTP %d: %s", e.Method, e.Path, e.StatusCode, e.Message)
+}
+
+// isNotFound reports whether err is a Docker 404 (no such container/network/volume).
+func isNotFound(err error) bool {
+	apiErr, ok := err.(*dockerAPIError)
+	return ok && apiErr.StatusCode == http.StatusNotFound
+}
+
+// demuxDockerStream splits Docker's multiplexed attach stream (used when the
+// exec has no TTY) into stdout and stderr. Each frame has an 8-byte header:
+// [stream, 0, 0, 0, size(uint32 big-endian)].
+func demuxDockerStream(r io.Reader) (stdout, stderr string, err error) {
+	var outBuf, errBuf strings.Builder
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return outBuf.String(), errBuf.String(), fmt.Errorf("read stream header: %w", err)
+		}
+		size := binary.BigEndian.Uint32(header[4:])
+		var dst io.Writer = &outBuf
+		if header[0] == 2 {
+			dst = &errBuf
+		}
+		if _, err := io.CopyN(dst, r, int64(size)); err != nil {
+			return outBuf.String(), errBuf.String(), fmt.Errorf("read stream frame: %w", err)
+		}
+	}
+	return outBuf.String(), errBuf.String(), nil
+}
+
+// Engine API payloads — only the fields pgdba uses are declared.
+
+type dockerContainerCreate struct {
+	Image            string                 `json:"Image"`
+	Hostname         string                 `json:"Hostname,omitempty"`
+	Env              []string               `json:"Env,omitempty"`
+	Labels           map[string]string      `json:"Labels,omitempty"`
+	HostConfig       dockerHostConfig       `json:"HostConfig"`
+	NetworkingConfig dockerNetworkingConfig `json:"NetworkingConfig"`
+	ExposedPorts     map[string]struct{}    `json:"ExposedPorts,omitempty"`
+}
+
+type dockerHostConfig struct {
+	NetworkMode   string              `json:"NetworkMode,omitempty"`
+	Binds         []string            `json:"Binds,omitempty"`
+	RestartPolicy dockerRestartPolicy `json:"RestartPolicy"`
+}
+
+type dockerRestartPolicy struct {
+	Name string `json:"Name,omitempty"`
+}
+
+type dockerNetworkingConfig struct {
+	EndpointsConfig map[string]dockerEndpointConfig `json:"EndpointsConfig,omitempty"`
+}
+
+type dockerEndpointConfig struct {
+	Aliases []string `json:"Aliases,omitempty"`
+}
+
+type dockerContainerInspect struct {
+	ID     string `json:"Id"`
+	Name   string `json:"Name"`
+	Config struct {
+		Hostname     string              `json:"Hostname"`
+		Image        string              `json:"Image"`
+		Env          []string            `json:"Env"`
+		Labels       map[string]string   `json:"Labels"`
+		ExposedPorts map[string]struct{} `json:"ExposedPorts"`
+	} `json:"Config"`
+	State struct {
+		Status  string `json:"Status"`
+		Running bool   `json:"Running"`
+		Health  *struct {
+			Status string `json:"Status"`
+		} `json:"Health,omitempty"`
+	} `json:"State"`
+	HostConfig struct {
+		Binds []string `json:"Binds"`
+	} `json:"HostConfig"`
+	NetworkSettings struct {
+		Networks map[string]struct {
+			IPAddress string `json:"IPAddress"`
+		} `json:"Networks"`
+	} `json:"NetworkSettings"`
+}
+
+type dockerContainerSummary struct {
+	ID     string            `json:"Id"`
+	Names  []string          `json:"Names"`
+	State  string            `json:"State"`
+	Labels map[string]string `json:"Labels"`
+}
+
+type dockerExecCreate struct {
+	AttachStdout bool     `json:"AttachStdout"`
+	AttachStderr bool     `json:"AttachStderr"`
+	Cmd          []string `json:"Cmd"`
+}
+
+type dockerExecInspect struct {
+	Running  bool `json:"Running"`
+	ExitCode int  `json:"ExitCode"`
+}
+
+// dockerExecPollInterval is how often ExecOnNode re-checks a finished exec
+// whose exit code has not been published yet.
+const dockerExecPollInterval = 100 * time.Millisecond
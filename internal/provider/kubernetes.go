@@ -0,0 +1,711 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	defaultKubeNamespace   = "default"
+	defaultKubeStorage     = "10Gi"
+	defaultKubeRoleLabel   = "role"
+	kubePodNameLabel       = "statefulset.kubernetes.io/pod-name"
+	kubePartitionPrefix    = "pgdba-partition-"
+	kubeDataVolume         = "data"
+	kubeFilesVolume        = "pgdba-files"
+	kubeRenderedVolume     = "pgdba-rendered"
+	kubeFilesDir           = "/pgdba-files"
+	kubeRenderedDir        = "/pgdba-rendered"
+	defaultKubeInitImage   = "busybox:1.36"
+	kubeManagedLabelTrue   = "true"
+	kubeDefaultPatroniPort = 8008
+)
+
+// PodExecutor runs a command in a pod container and returns its output. The
+// production implementation uses the pod exec subresource; tests substitute a
+// fake because the fake clientset cannot stream.
+type PodExecutor interface {
+	Exec(ctx context.Context, namespace, pod, container string, cmd []string) (stdout, stderr string, err error)
+}
+
+// KubernetesProvider implements Provider on top of Patroni StatefulSets.
+//
+// A node name is "<statefulset>-<ordinal>", the pod name the StatefulSet
+// controller assigns. Nodes sharing a prefix (e.g. prod-pg-0, prod-pg-1) live
+// in one StatefulSet: CreateNode creates it for ordinal 0 and scales it up for
+// later ordinals; DestroyNode scales it down and therefore only accepts the
+// highest ordinal. The pod template (image, env, file paths) is taken from
+// the NodeConfig that created the StatefulSet. File contents are per pod:
+// the "<set>-files" Secret keeps every ordinal's copy and an init container
+// puts the pod's own in place, so each member runs with the config rendered
+// for it. The environment is read from the "<set>-env" Secret. Both may hold
+// passwords, so neither is kept in a ConfigMap or the pod template.
+//
+// Recognised config keys: "namespace", "kubeconfig", "context", "image",
+// "etcd_image", "init_image" (the image copying the files, default busybox),
+// "storage_class", "storage_size" and "role_label" (the pod label Patroni
+// maintains with the member role, default "role").
+type KubernetesProvider struct {
+	client       kubernetes.Interface
+	exec         PodExecutor
+	namespace    string
+	image        string
+	etcdImage    string
+	initImage    string
+	storageClass string
+	storageSize  string
+	roleLabel    string
+}
+
+func newKubernetesProvider(cfg map[string]string) (*KubernetesProvider, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("kubernetes provider: config is required (at least \"namespace\")")
+	}
+	restCfg, err := kubeRESTConfig(cfg["kubeconfig"], cfg["context"])
+	if err != nil {
+		return nil, fmt.Errorf("kubernetes provider: %w", err)
+	}
+	client, err := kubernetes.NewForConfig(restCfg)
+	if err != nil {
+		return nil, fmt.Errorf("kubernetes provider: build clientset: %w", err)
+	}
+	return NewKubernetesProviderWithClient(client, &spdyPodExecutor{config: restCfg, client: client}, cfg), nil
+}
+
+// NewKubernetesProviderWithClient builds a provider around an existing
+// clientset and executor. It is used by tests with a fake clientset.
+func NewKubernetesProviderWithClient(client kubernetes.Interface, exec PodExecutor, cfg map[string]string) *KubernetesProvider {
+	k := &KubernetesProvider{
+		client:      client,
+		exec:        exec,
+		namespace:   defaultKubeNamespace,
+		image:       defaultPostgresImage,
+		etcdImage:   defaultEtcdImage,
+		initImage:   defaultKubeInitImage,
+		storageSize: defaultKubeStorage,
+		roleLabel:   defaultKubeRoleLabel,
+	}
+	if v := cfg["namespace"]; v != "" {
+		k.namespace = v
+	}
+	if v := cfg["image"]; v != "" {
+		k.image = v
+	}
+	if v := cfg["etcd_image"]; v != "" {
+		k.etcdImage = v
+	}
+	if v := cfg["init_image"]; v != "" {
+		k.initImage = v
+	}
+	if v := cfg["storage_class"]; v != "" {
+		k.storageClass = v
+	}
+	if v := cfg["storage_size"]; v != "" {
+		k.storageSize = v
+	}
+	if v := cfg["role_label"]; v != "" {
+		k.roleLabel = v
+	}
+	return k
+}
+
+// Type returns the provider identifier string.
+func (k *KubernetesProvider) Type() string { return "kubernetes" }
+
+// splitPodName splits "prod-pg-2" into ("prod-pg", 2).
+func splitPodName(name string) (string, int, error) {
+	i := strings.LastIndex(name, "-")
+	if i <= 0 || i == len(name)-1 {
+		return "", 0, fmt.Errorf("node name %q must be <statefulset>-<ordinal>", name)
+	}
+	ordinal, err := strconv.Atoi(name[i+1:])
+	if err != nil || ordinal < 0 {
+		return "", 0, fmt.Errorf("node name %q must be <statefulset>-<ordinal>", name)
+	}
+	return name[:i], ordinal, nil
+}
+
+// CreateNode adds the pod cfg.Name to its StatefulSet. Ordinal 0 creates the
+// StatefulSet (plus its headless Service and Secrets); any other
+// ordinal must be the next one, with the same env and file paths as the
+// template, and scales the StatefulSet up by one after adding its files.
+func (k *KubernetesProvider) CreateNode(ctx context.Context, cfg NodeConfig) (NodeStatus, error) {
+	if cfg.Name == "" {
+		return NodeStatus{}, fmt.Errorf("node name is required")
+	}
+	setName, ordinal, err := splitPodName(cfg.Name)
+	if err != nil {
+		return NodeStatus{}, err
+	}
+
+	sets := k.client.AppsV1().StatefulSets(k.namespace)
+	set, err := sets.Get(ctx, setName, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		if ordinal != 0 {
+			return NodeStatus{}, fmt.Errorf("statefulset %s does not exist: first node must be %s-0", setName, setName)
+		}
+		if err := k.createStatefulSet(ctx, setName, cfg); err != nil {
+			return NodeStatus{}, err
+		}
+	case err != nil:
+		return NodeStatus{}, fmt.Errorf("get statefulset %s: %w", setName, err)
+	default:
+		replicas := replicaCount(set)
+		if int32(ordinal) != replicas {
+			return NodeStatus{}, fmt.Errorf("statefulset %s has %d replicas: next node is %s-%d, not %s",
+				setName, replicas, setName, replicas, cfg.Name)
+		}
+		env, err := k.secretData(ctx, setName+"-env")
+		if err != nil {
+			return NodeStatus{}, err
+		}
+		if err := checkTemplate(set, env, cfg); err != nil {
+			return NodeStatus{}, err
+		}
+		if err := k.putFiles(ctx, setName, ordinal, cfg); err != nil {
+			return NodeStatus{}, err
+		}
+		next := replicas + 1
+		set.Spec.Replicas = &next
+		if _, err := sets.Update(ctx, set, metav1.UpdateOptions{}); err != nil {
+			return NodeStatus{}, fmt.Errorf("scale statefulset %s to %d: %w", setName, next, err)
+		}
+	}
+
+	st, err := k.GetNodeStatus(ctx, cfg.Name)
+	if err != nil {
+		// The controller has not created the pod yet; report it as pending.
+		return NodeStatus{ID: cfg.Name, Role: cfg.Role, Labels: k.templateLabels(setName, cfg)}, nil
+	}
+	return st, nil
+}
+
+// createStatefulSet creates the headless Service, the optional env and file
+// Secrets and a one-replica StatefulSet for cfg.
+func (k *KubernetesProvider) createStatefulSet(ctx context.Context, setName string, cfg NodeConfig) error {
+	podLabels := k.templateLabels(setName, cfg)
+
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: setName, Namespace: k.namespace, Labels: podLabels},
+		Spec: corev1.ServiceSpec{
+			ClusterIP:                corev1.ClusterIPNone,
+			Selector:                 map[string]string{"pgdba.statefulset": setName},
+			PublishNotReadyAddresses: true,
+		},
+	}
+	for _, p := range nodePorts(cfg) {
+		svc.Spec.Ports = append(svc.Spec.Ports, corev1.ServicePort{Name: p.Name, Port: p.ContainerPort})
+	}
+	if _, err := k.client.CoreV1().Services(k.namespace).Create(ctx, svc, metav1.CreateOptions{}); err != nil &&
+		!apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("create service %s: %w", setName, err)
+	}
+
+	podSpec := k.podSpec(setName, cfg)
+	if len(cfg.Env) > 0 {
+		err := k.putSecret(ctx, setName+"-env", k.templateLabels(setName, cfg), func(data map[string][]byte) {
+			for key, v := range cfg.Env {
+				data[key] = []byte(v)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		podSpec.Containers[0].EnvFrom = []corev1.EnvFromSource{{
+			SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: setName + "-env"}},
+		}}
+	}
+	if len(cfg.Files) > 0 {
+		if err := k.putFiles(ctx, setName, 0, cfg); err != nil {
+			return err
+		}
+		k.mountFiles(&podSpec, setName, cfg)
+	}
+
+	one := int32(1)
+	set := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: setName, Namespace: k.namespace, Labels: podLabels},
+		Spec: appsv1.StatefulSetSpec{
+			Replicas:    &one,
+			ServiceName: setName,
+			Selector:    &metav1.LabelSelector{MatchLabels: map[string]string{"pgdba.statefulset": setName}},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: podLabels},
+				Spec:       podSpec,
+			},
+		},
+	}
+	if cfg.DataDir != "" {
+		size, err := resource.ParseQuantity(k.storageSize)
+		if err != nil {
+			return fmt.Errorf("invalid storage_size %q: %w", k.storageSize, err)
+		}
+		pvc := corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: kubeDataVolume},
+			Spec: corev1.PersistentVolumeClaimSpec{
+				AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+				Resources: corev1.VolumeResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceStorage: size},
+				},
+			},
+		}
+		if k.storageClass != "" {
+			pvc.Spec.StorageClassName = &k.storageClass
+		}
+		set.Spec.VolumeClaimTemplates = []corev1.PersistentVolumeClaim{pvc}
+	}
+	if _, err := k.client.AppsV1().StatefulSets(k.namespace).Create(ctx, set, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("create statefulset %s: %w", setName, err)
+	}
+	return nil
+}
+
+// fileKey returns the Secret key holding the i-th file (in path order) of
+// ordinal's pod, and the name the init container copies it to.
+func fileKey(i, ordinal int) (key, rendered string) {
+	rendered = fmt.Sprintf("file-%d", i)
+	return fmt.Sprintf("%s.%d", rendered, ordinal), rendered
+}
+
+// putFiles stores the files of ordinal's pod in the set's files Secret,
+// creating it for the first pod.
+func (k *KubernetesProvider) putFiles(ctx context.Context, setName string, ordinal int, cfg NodeConfig) error {
+	if len(cfg.Files) == 0 {
+		return nil
+	}
+	return k.putSecret(ctx, setName+"-files", k.templateLabels(setName, cfg), func(data map[string][]byte) {
+		for i, p := range sortedKeys(cfg.Files) {
+			key, _ := fileKey(i, ordinal)
+			data[key] = []byte(cfg.Files[p])
+		}
+	})
+}
+
+// putSecret creates Secret name with the data set by fill, or, for a Secret
+// left by an earlier attempt, updates it.
+func (k *KubernetesProvider) putSecret(ctx context.Context, name string, labels map[string]string,
+	fill func(map[string][]byte)) error {
+
+	secrets := k.client.CoreV1().Secrets(k.namespace)
+	secret, err := secrets.Get(ctx, name, metav1.GetOptions{})
+	exists := err == nil
+	switch {
+	case apierrors.IsNotFound(err):
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: k.namespace, Labels: labels},
+			Type:       corev1.SecretTypeOpaque,
+		}
+	case err != nil:
+		return fmt.Errorf("get secret %s: %w", name, err)
+	}
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	fill(secret.Data)
+	if exists {
+		_, err = secrets.Update(ctx, secret, metav1.UpdateOptions{})
+	} else {
+		_, err = secrets.Create(ctx, secret, metav1.CreateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("write secret %s: %w", name, err)
+	}
+	return nil
+}
+
+// secretData returns the data of Secret name as strings; a missing Secret
+// has none.
+func (k *KubernetesProvider) secretData(ctx context.Context, name string) (map[string]string, error) {
+	secret, err := k.client.CoreV1().Secrets(k.namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get secret %s: %w", name, err)
+	}
+	data := make(map[string]string, len(secret.Data))
+	for key, v := range secret.Data {
+		data[key] = string(v)
+	}
+	return data, nil
+}
+
+// mountFiles adds the init container that copies each pod's files out of
+// the Secret by ordinal, and mounts the copies at their paths.
+func (k *KubernetesProvider) mountFiles(podSpec *corev1.PodSpec, setName string, cfg NodeConfig) {
+	var script strings.Builder
+	script.WriteString(`set -e; o="${POD_NAME##*-}"`)
+	for i, p := range sortedKeys(cfg.Files) {
+		_, rendered := fileKey(i, 0)
+		fmt.Fprintf(&script, `; cp "%s/%s.$o" "%s/%s"`, kubeFilesDir, rendered, kubeRenderedDir, rendered)
+		podSpec.Containers[0].VolumeMounts = append(podSpec.Containers[0].VolumeMounts,
+			corev1.VolumeMount{Name: kubeRenderedVolume, MountPath: p, SubPath: rendered})
+	}
+	podSpec.InitContainers = append(podSpec.InitContainers, corev1.Container{
+		Name:    kubeFilesVolume,
+		Image:   k.initImage,
+		Command: []string{"sh", "-c", script.String()},
+		Env: []corev1.EnvVar{
+			{Name: "POD_NAME", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"}}},
+		},
+		VolumeMounts: []corev1.VolumeMount{
+			{Name: kubeFilesVolume, MountPath: kubeFilesDir, ReadOnly: true},
+			{Name: kubeRenderedVolume, MountPath: kubeRenderedDir},
+		},
+	})
+	podSpec.Volumes = append(podSpec.Volumes,
+		corev1.Volume{
+			Name: kubeFilesVolume,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{SecretName: setName + "-files"},
+			},
+		},
+		corev1.Volume{Name: kubeRenderedVolume, VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+	)
+}
+
+// checkTemplate reports an error unless cfg can run from set's pod
+// template: the same env (as stored in the set's env Secret) and the same
+// file paths (the contents may differ).
+func checkTemplate(set *appsv1.StatefulSet, env map[string]string, cfg NodeConfig) error {
+	c := set.Spec.Template.Spec.Containers[0]
+	var paths []string
+	for _, m := range c.VolumeMounts {
+		if m.Name == kubeRenderedVolume {
+			paths = append(paths, m.MountPath)
+		}
+	}
+	sort.Strings(paths)
+	if want := sortedKeys(cfg.Files); strings.Join(paths, ",") != strings.Join(want, ",") {
+		return fmt.Errorf("statefulset %s mounts files [%s] in every pod; %s has [%s]",
+			set.Name, strings.Join(paths, ", "), cfg.Name, strings.Join(want, ", "))
+	}
+	if len(env) != len(cfg.Env) {
+		return fmt.Errorf("statefulset %s runs every pod with one environment; %s has a different one", set.Name, cfg.Name)
+	}
+	for key, v := range cfg.Env {
+		if got, ok := env[key]; !ok || got != v {
+			return fmt.Errorf("statefulset %s runs every pod with one environment; %s sets %s differently", set.Name, cfg.Name, key)
+		}
+	}
+	return nil
+}
+
+// isPatroniRole reports whether a node of role runs Patroni.
+func isPatroniRole(role NodeRole) bool {
+	return role == RolePrimary || role == RoleStandby
+}
+
+// templateLabels returns the labels applied to every pod of a StatefulSet.
+func (k *KubernetesProvider) templateLabels(setName string, cfg NodeConfig) map[string]string {
+	podLabels := map[string]string{
+		"pgdba.managed":     kubeManagedLabelTrue,
+		"pgdba.role":        string(cfg.Role),
+		"pgdba.statefulset": setName,
+	}
+	for key, v := range cfg.Labels {
+		podLabels[key] = v
+	}
+	return podLabels
+}
+
+// podSpec builds the single-container pod spec for cfg.
+func (k *KubernetesProvider) podSpec(setName string, cfg NodeConfig) corev1.PodSpec {
+	image := cfg.Image
+	if image == "" {
+		image = k.image
+		if cfg.Role == RoleEtcd {
+			image = k.etcdImage
+		}
+	}
+
+	env := []corev1.EnvVar{
+		{Name: "POD_NAME", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"}}},
+		{Name: "POD_IP", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "status.podIP"}}},
+	}
+
+	container := corev1.Container{
+		Name:  setName,
+		Image: image,
+		Env:   env,
+		Ports: nodePorts(cfg),
+	}
+	if cfg.DataDir != "" {
+		container.VolumeMounts = []corev1.VolumeMount{{Name: kubeDataVolume, MountPath: cfg.DataDir}}
+	}
+	switch {
+	case isPatroniRole(cfg.Role):
+		container.ReadinessProbe = &corev1.Probe{
+			ProbeHandler: corev1.ProbeHandler{
+				HTTPGet: &corev1.HTTPGetAction{Path: "/readiness", Port: intOrStringPort(kubeDefaultPatroniPort)},
+			},
+			PeriodSeconds: 10,
+		}
+	case cfg.Role != RoleEtcd && cfg.Port > 0:
+		// PgBouncer and other services: ready once they accept connections.
+		container.ReadinessProbe = &corev1.Probe{
+			ProbeHandler: corev1.ProbeHandler{
+				TCPSocket: &corev1.TCPSocketAction{Port: intOrStringPort(cfg.Port)},
+			},
+			PeriodSeconds: 10,
+		}
+	}
+	return corev1.PodSpec{Containers: []corev1.Container{container}}
+}
+
+// nodePorts returns the container ports exposed for cfg.
+func nodePorts(cfg NodeConfig) []corev1.ContainerPort {
+	var ports []corev1.ContainerPort
+	if cfg.Port > 0 {
+		name := "postgresql"
+		switch {
+		case cfg.Role == RoleEtcd:
+			name = "client"
+		case !isPatroniRole(cfg.Role):
+			name = "service"
+		}
+		ports = append(ports, corev1.ContainerPort{Name: name, ContainerPort: int32(cfg.Port)})
+	}
+	if isPatroniRole(cfg.Role) {
+		ports = append(ports, corev1.ContainerPort{Name: "patroni", ContainerPort: kubeDefaultPatroniPort})
+	}
+	return ports
+}
+
+// replicaCount returns the desired replica count of a StatefulSet (default 1).
+func replicaCount(set *appsv1.StatefulSet) int32 {
+	if set.Spec.Replicas == nil {
+		return 1
+	}
+	return *set.Spec.Replicas
+}
+
+// DestroyNode scales the pod's StatefulSet down by one. Only the highest
+// ordinal can be removed; removing the last pod deletes the StatefulSet,
+// Service and Secrets. PersistentVolumeClaims are kept.
+func (k *KubernetesProvider) DestroyNode(ctx context.Context, id string) error {
+	if id == "" {
+		return fmt.Errorf("node id is required")
+	}
+	setName, ordinal, err := splitPodName(id)
+	if err != nil {
+		return err
+	}
+	sets := k.client.AppsV1().StatefulSets(k.namespace)
+	set, err := sets.Get(ctx, setName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("get statefulset %s: %w", setName, err)
+	}
+
+	replicas := replicaCount(set)
+	if int32(ordinal) >= replicas {
+		return nil
+	}
+	if int32(ordinal) != replicas-1 {
+		return fmt.Errorf("cannot remove %s: statefulset %s can only scale down from its highest ordinal (%s-%d)",
+			id, setName, setName, replicas-1)
+	}
+
+	if replicas > 1 {
+		next := replicas - 1
+		set.Spec.Replicas = &next
+		if _, err := sets.Update(ctx, set, metav1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("scale statefulset %s to %d: %w", setName, next, err)
+		}
+		return nil
+	}
+
+	if err := sets.Delete(ctx, setName, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("delete statefulset %s: %w", setName, err)
+	}
+	if err := k.client.CoreV1().Services(k.namespace).Delete(ctx, setName, metav1.DeleteOptions{}); err != nil &&
+		!apierrors.IsNotFound(err) {
+		return fmt.Errorf("delete service %s: %w", setName, err)
+	}
+	for _, name := range []string{setName + "-files", setName + "-env"} {
+		if err := k.client.CoreV1().Secrets(k.namespace).Delete(ctx, name, metav1.DeleteOptions{}); err != nil &&
+			!apierrors.IsNotFound(err) {
+			return fmt.Errorf("delete secret %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// UpdateNodeImage sets image on the pod's StatefulSet template, switching it
+// to the OnDelete update strategy so that no other pod is restarted, and
+// deletes the pod so the controller recreates it from the new template.
+func (k *KubernetesProvider) UpdateNodeImage(ctx context.Context, id, image string) error {
+	if id == "" {
+		return fmt.Errorf("node id is required")
+	}
+	setName, _, err := splitPodName(id)
+	if err != nil {
+		return err
+	}
+	sets := k.client.AppsV1().StatefulSets(k.namespace)
+	set, err := sets.Get(ctx, setName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("get statefulset %s: %w", setName, err)
+	}
+	set.Spec.UpdateStrategy = appsv1.StatefulSetUpdateStrategy{Type: appsv1.OnDeleteStatefulSetStrategyType}
+	set.Spec.Template.Spec.Containers[0].Image = image
+	if _, err := sets.Update(ctx, set, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("update statefulset %s: %w", setName, err)
+	}
+	if err := k.client.CoreV1().Pods(k.namespace).Delete(ctx, id, metav1.DeleteOptions{}); err != nil &&
+		!apierrors.IsNotFound(err) {
+		return fmt.Errorf("delete pod %s: %w", id, err)
+	}
+	return nil
+}
+
+// PurgeNodeData deletes the data PersistentVolumeClaim of a removed pod
+// ("data-<pod>", as created from the StatefulSet volume claim template).
+func (k *KubernetesProvider) PurgeNodeData(ctx context.Context, id string) error {
+	if id == "" {
+		return fmt.Errorf("node id is required")
+	}
+	claim := kubeDataVolume + "-" + id
+	err := k.client.CoreV1().PersistentVolumeClaims(k.namespace).Delete(ctx, claim, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("delete persistentvolumeclaim %s: %w", claim, err)
+	}
+	return nil
+}
+
+// ExecOnNode runs a command in the pod identified by id using the exec
+// subresource. A failed command is reported as an error including stderr.
+func (k *KubernetesProvider) ExecOnNode(ctx context.Context, id string, cmd []string) (string, error) {
+	if id == "" {
+		return "", fmt.Errorf("node id is required")
+	}
+	if len(cmd) == 0 {
+		return "", fmt.Errorf("command is required")
+	}
+	stdout, stderr, err := k.exec.Exec(ctx, k.namespace, id, "", cmd)
+	if err != nil {
+		return stdout, fmt.Errorf("command %q in pod %s: %w: %s",
+			strings.Join(cmd, " "), id, err, strings.TrimSpace(stderr))
+	}
+	return stdout, nil
+}
+
+// GetNodeStatus returns the status of the pod identified by id.
+func (k *KubernetesProvider) GetNodeStatus(ctx context.Context, id string) (NodeStatus, error) {
+	if id == "" {
+		return NodeStatus{}, fmt.Errorf("node id is required")
+	}
+	pod, err := k.client.CoreV1().Pods(k.namespace).Get(ctx, id, metav1.GetOptions{})
+	if err != nil {
+		return NodeStatus{}, fmt.Errorf("get pod %s: %w", id, err)
+	}
+	return k.statusFromPod(pod), nil
+}
+
+// statusFromPod maps a pod to a NodeStatus. Running follows the pod phase,
+// Healthy the Ready condition, and the role the Patroni-maintained role label
+// (master/primary → RolePrimary) unless the pod was created as an etcd node.
+func (k *KubernetesProvider) statusFromPod(pod *corev1.Pod) NodeStatus {
+	st := NodeStatus{
+		ID:      pod.Name,
+		Host:    pod.Status.PodIP,
+		Running: pod.Status.Phase == corev1.PodRunning,
+		Labels:  map[string]string{"pgdba.node": pod.Name},
+	}
+	for key, v := range pod.Labels {
+		st.Labels[key] = v
+	}
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			st.Healthy = st.Running && c.Status == corev1.ConditionTrue
+		}
+	}
+
+	switch {
+	case pod.Labels["pgdba.role"] == string(RoleEtcd):
+		st.Role = RoleEtcd
+	case pod.Labels[k.roleLabel] == "master" || pod.Labels[k.roleLabel] == "primary":
+		st.Role = RolePrimary
+	default:
+		st.Role = RoleStandby
+	}
+	return st
+}
+
+// ListNodes returns the status of every pgdba-managed pod in the namespace.
+func (k *KubernetesProvider) ListNodes(ctx context.Context) ([]NodeStatus, error) {
+	selector := labels.SelectorFromSet(labels.Set{"pgdba.managed": kubeManagedLabelTrue}).String()
+	pods, err := k.client.CoreV1().Pods(k.namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, fmt.Errorf("list pods in %s: %w", k.namespace, err)
+	}
+	nodes := make([]NodeStatus, 0, len(pods.Items))
+	for i := range pods.Items {
+		nodes = append(nodes, k.statusFromPod(&pods.Items[i]))
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+	return nodes, nil
+}
+
+// PartitionNode isolates or reconnects the pod identified by id. Isolation
+// creates a deny-all ingress and egress NetworkPolicy selecting only that pod;
+// reconnecting deletes it. The cluster's CNI must enforce NetworkPolicies.
+func (k *KubernetesProvider) PartitionNode(ctx context.Context, id string, isolate bool) error {
+	if id == "" {
+		return fmt.Errorf("node id is required")
+	}
+	policies := k.client.NetworkingV1().NetworkPolicies(k.namespace)
+	name := kubePartitionPrefix + id
+
+	if !isolate {
+		if err := policies.Delete(ctx, name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("delete networkpolicy %s: %w", name, err)
+		}
+		return nil
+	}
+
+	if _, err := k.client.CoreV1().Pods(k.namespace).Get(ctx, id, metav1.GetOptions{}); err != nil {
+		return fmt.Errorf("get pod %s: %w", id, err)
+	}
+	policy := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: k.namespace,
+			Labels:    map[string]string{"pgdba.managed": kubeManagedLabelTrue, "pgdba.node": id},
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{kubePodNameLabel: id}},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress},
+		},
+	}
+	if _, err := policies.Create(ctx, policy, metav1.CreateOptions{}); err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("create networkpolicy %s: %w", name, err)
+	}
+	return nil
+}
+
+// sortedKeys returns the keys of m in lexical order.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,76 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// kubeRESTConfig loads client configuration from kubeconfig (or $KUBECONFIG,
+// or ~/.kube/config), falling back to the in-cluster service account when no
+// kubeconfig file exists.
+func kubeRESTConfig(kubeconfig, context string) (*rest.Config, error) {
+	if kubeconfig == "" {
+		kubeconfig = os.Getenv("KUBECONFIG")
+	}
+	if kubeconfig == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			kubeconfig = filepath.Join(home, ".kube", "config")
+		}
+	}
+	if _, err := os.Stat(kubeconfig); err != nil {
+		cfg, inErr := rest.InClusterConfig()
+		if inErr != nil {
+			return nil, fmt.Errorf("no kubeconfig at %s and not running in a cluster: %w", kubeconfig, inErr)
+		}
+		return cfg, nil
+	}
+	rules := &clientcmd.ClientConfigLoadingRules{ExplicitPath: kubeconfig}
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: context}
+	cfg, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("load kubeconfig %s: %w", kubeconfig, err)
+	}
+	return cfg, nil
+}
+
+// spdyPodExecutor runs commands through the pods/exec subresource.
+type spdyPodExecutor struct {
+	config *rest.Config
+	client kubernetes.Interface
+}
+
+// Exec implements PodExecutor.
+func (e *spdyPodExecutor) Exec(ctx context.Context, namespace, pod, container string, cmd []string) (string, string, error) {
+	req := e.client.CoreV1().RESTClient().Post().
+		Resource("pods").Namespace(namespace).Name(pod).SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: container,
+			Command:   cmd,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+
+	executor, err := remotecommand.NewSPDYExecutor(e.config, "POST", req.URL())
+	if err != nil {
+		return "", "", fmt.Errorf("build exec request: %w", err)
+	}
+	var stdout, stderr bytes.Buffer
+	err = executor.StreamWithContext(ctx, remotecommand.StreamOptions{Stdout: &stdout, Stderr: &stderr})
+	return stdout.String(), stderr.String(), err
+}
+
+// intOrStringPort wraps a numeric port for probe definitions.
+func intOrStringPort(port int) intstr.IntOrString {
+	return intstr.FromInt32(int32(port))
+}
@@ -2,7 +2,9 @@ package provider
 
 import (
 	"context"
+	"encoding/base64"
 	"fmt"
+	"path/filepath"
 )
 
 // NodeRole identifies the role of a PostgreSQL cluster node.
@@ -14,6 +16,15 @@ const (
 	RoleEtcd    NodeRole = "etcd"
 )
 
+// LabelCluster is the node label carrying the owning cluster name. Callers set
+// it in NodeConfig.Labels so that ListNodes results can be filtered per cluster.
+const LabelCluster = "pgdba.cluster"
+
+// LabelOwnerUnknown is set to "true" on nodes whose owning cluster the
+// provider could not read, such as unreachable bare-metal hosts. They carry
+// no LabelCluster label but may still belong to a cluster.
+const LabelOwnerUnknown = "pgdba.owner-unknown"
+
 // NodeStatus describes the runtime state of a single cluster node.
 type NodeStatus struct {
 	ID      string
@@ -21,6 +32,7 @@ type NodeStatus struct {
 	Role    NodeRole
 	Running bool
 	Healthy bool
+	Labels  map[string]string
 }
 
 // NodeConfig describes a new node to be provisioned.
@@ -31,6 +43,9 @@ type NodeConfig struct {
 	DataDir string
 	Port    int
 	Labels  map[string]string
+	Image   string            // container image; empty = provider default for Role
+	Env     map[string]string // environment variables passed to the node
+	Files   map[string]string // absolute path → content, written before the node starts
 }
 
 // Provider is the abstraction for infrastructure backends (Docker, baremetal, Kubernetes).
@@ -44,15 +59,30 @@ type Provider interface {
 	Type() string
 }
 
+// DataPurger is implemented by providers that keep node data outside the node
+// itself (Docker volumes, Kubernetes PersistentVolumeClaims). DestroyNode
+// leaves that data in place; PurgeNodeData deletes it. Purging data that is
+// already gone is not an error.
+type DataPurger interface {
+	PurgeNodeData(ctx context.Context, id string) error
+}
+
+// ImageUpdater is implemented by providers whose nodes run from an image
+// (Docker containers, Kubernetes pods). UpdateNodeImage restarts the node on
+// image, keeping its data, configuration and files.
+type ImageUpdater interface {
+	UpdateNodeImage(ctx context.Context, id, image string) error
+}
+
 // New returns a Provider implementation for the given type.
 func New(providerType string, cfg map[string]string) (Provider, error) {
 	switch providerType {
 	case "docker":
-		return newDockerProvider(cfg), nil
+		return newDockerProvider(cfg)
 	case "baremetal":
-		return nil, fmt.Errorf("baremetal provider not yet implemented")
+		return newBaremetalProvider(cfg)
 	case "kubernetes":
-		return nil, fmt.Errorf("kubernetes provider not yet implemented")
+		return newKubernetesProvider(cfg)
 	default:
 		return nil, fmt.Errorf(
 			"unknown provider type %q: must be docker, baremetal, or kubernetes",
@@ -60,3 +90,15 @@ func New(providerType string, cfg map[string]string) (Provider, error) {
 		)
 	}
 }
+
+// WriteFile writes content to path on node id through p.ExecOnNode, creating
+// the parent directory first. The node needs sh and base64.
+func WriteFile(ctx context.Context, p Provider, id, path, content string) error {
+	encoded := base64.StdEncoding.EncodeToString([]byte(content))
+	script := fmt.Sprintf("mkdir -p %s && printf '%%s' %s | base64 -d > %s",
+		shellQuote(filepath.Dir(path)), encoded, shellQuote(path))
+	if _, err := p.ExecOnNode(ctx, id, []string{"sh", "-c", script}); err != nil {
+		return fmt.Errorf("write %s on %s: %w", path, id, err)
+	}
+	return nil
+}
@@ -0,0 +1,270 @@
+package replication
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// LogicalDB abstracts the logical replication catalogs of one database:
+// pg_publication on a source, pg_subscription, pg_subscription_rel and
+// pg_stat_subscription on a target. Exec runs the DDL built by the
+// *SQL functions.
+type LogicalDB interface {
+	ServerVersionNum(ctx context.Context) (int, error)
+	Slots(ctx context.Context, walStatus bool) ([]Slot, error)
+	Publications(ctx context.Context) ([]Publication, error)
+	// Subscriptions returns the subscriptions of the current database with
+	// their tables and workers.
+	Subscriptions(ctx context.Context) ([]Subscription, error)
+	Exec(ctx context.Context, sql string) error
+	Close(ctx context.Context) error
+}
+
+// Publication is a row of pg_publication and the tables it publishes.
+type Publication struct {
+	Name       string   `json:"name"`
+	Owner      string   `json:"owner"`
+	AllTables  bool     `json:"all_tables"`
+	Operations []string `json:"operations"`
+	Tables     []string `json:"tables"`
+}
+
+// SubscriptionTable is a row of pg_subscription_rel: the synchronization
+// state of one subscribed table.
+type SubscriptionTable struct {
+	Table string `json:"table"`
+	State string `json:"state"`
+	LSN   string `json:"lsn,omitempty"`
+}
+
+// SubscriptionWorker is a row of pg_stat_subscription. Table is set for the
+// workers copying a table, empty for the apply worker.
+type SubscriptionWorker struct {
+	PID            *int32     `json:"pid"`
+	Table          string     `json:"table,omitempty"`
+	ReceivedLSN    string     `json:"received_lsn,omitempty"`
+	LatestEndLSN   string     `json:"latest_end_lsn,omitempty"`
+	LastMsgReceipt *time.Time `json:"last_msg_receipt_time,omitempty"`
+}
+
+// Subscription is a row of pg_subscription with its tables and workers.
+type Subscription struct {
+	Name         string               `json:"name"`
+	Owner        string               `json:"owner"`
+	Enabled      bool                 `json:"enabled"`
+	SlotName     string               `json:"slot_name,omitempty"`
+	Publications []string             `json:"publications"`
+	Tables       []SubscriptionTable  `json:"tables"`
+	Workers      []SubscriptionWorker `json:"workers"`
+}
+
+// Table synchronization states of pg_subscription_rel.srsubstate, as
+// reported in SubscriptionTable.State.
+const (
+	TableInit         = "init"
+	TableDataCopy     = "data copy"
+	TableFinishedCopy = "finished copy"
+	TableSynchronized = "synchronized"
+	TableReady        = "ready"
+)
+
+// TableStateName returns the name of srsubstate code.
+func TableStateName(code string) string {
+	switch code {
+	case "i":
+		return TableInit
+	case "d":
+		return TableDataCopy
+	case "f":
+		return TableFinishedCopy
+	case "s":
+		return TableSynchronized
+	case "r":
+		return TableReady
+	}
+	return code
+}
+
+// Ready reports whether every table of the subscription is ready, i.e.
+// copied and followed by the apply worker.
+func (s Subscription) Ready() bool {
+	for _, t := range s.Tables {
+		if t.State != TableReady {
+			return false
+		}
+	}
+	return true
+}
+
+// applyWorker returns the subscription's running apply worker, if any.
+func (s Subscription) applyWorker() (SubscriptionWorker, bool) {
+	for _, w := range s.Workers {
+		if w.Table == "" && w.PID != nil {
+			return w, true
+		}
+	}
+	return SubscriptionWorker{}, false
+}
+
+// SubscriptionOptions are the WITH options of CreateSubscriptionSQL.
+type SubscriptionOptions struct {
+	// CopyData copies the existing rows of the published tables first.
+	CopyData bool
+	// SlotName names the slot created on the source; empty means the
+	// subscription name.
+	SlotName string
+}
+
+// CreatePublicationSQL returns the statement creating publication name for
+// tables ("table" or "schema.table"), or for all tables if there are none.
+func CreatePublicationSQL(name string, tables []string) string {
+	if len(tables) == 0 {
+		return "CREATE PUBLICATION " + quoteIdent(name) + " FOR ALL TABLES"
+	}
+	quoted := make([]string, len(tables))
+	for i, t := range tables {
+		quoted[i] = QualifiedName(t)
+	}
+	return "CREATE PUBLICATION " + quoteIdent(name) + " FOR TABLE " + strings.Join(quoted, ", ")
+}
+
+// DropPublicationSQL returns the statement dropping publication name.
+func DropPublicationSQL(name string) string {
+	return "DROP PUBLICATION " + quoteIdent(name)
+}
+
+// CreateSubscriptionSQL returns the statement subscribing to publications
+// of the source reached with conninfo. The source slot is created with it.
+func CreateSubscriptionSQL(name, conninfo string, publications []string, opts SubscriptionOptions) string {
+	quoted := make([]string, len(publications))
+	for i, p := range publications {
+		quoted[i] = quoteIdent(p)
+	}
+	with := fmt.Sprintf("copy_data = %t", opts.CopyData)
+	if opts.SlotName != "" {
+		with += ", slot_name = " + quoteLiteral(opts.SlotName)
+	}
+	return fmt.Sprintf("CREATE SUBSCRIPTION %s CONNECTION %s PUBLICATION %s WITH (%s)",
+		quoteIdent(name), quoteLiteral(conninfo), strings.Join(quoted, ", "), with)
+}
+
+// DropSubscriptionSQL returns the statement dropping subscription name,
+// which also drops its slot on the source.
+func DropSubscriptionSQL(name string) string {
+	return "DROP SUBSCRIPTION " + quoteIdent(name)
+}
+
+// RefreshSubscriptionSQL returns the statement that picks up the tables
+// added to the subscription's publications since it was created or last
+// refreshed.
+func RefreshSubscriptionSQL(name string, copyData bool) string {
+	return fmt.Sprintf("ALTER SUBSCRIPTION %s REFRESH PUBLICATION WITH (copy_data = %t)", quoteIdent(name), copyData)
+}
+
+// QualifiedName quotes "table" or "schema.table" as an identifier.
+func QualifiedName(table string) string {
+	if schema, name, ok := strings.Cut(table, "."); ok {
+		return quoteIdent(schema) + "." + quoteIdent(name)
+	}
+	return quoteIdent(table)
+}
+
+func quoteIdent(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// SubscriptionStatus is a target subscription with the source slot that
+// feeds it.
+type SubscriptionStatus struct {
+	Subscription
+	// Synced reports every table ready.
+	Synced bool `json:"synced"`
+	// TableStates counts the subscribed tables by state.
+	TableStates map[string]int `json:"table_states"`
+	SourceSlot  *Slot          `json:"source_slot,omitempty"`
+	// MissingPublications are subscribed but not defined on the source.
+	MissingPublications []string `json:"missing_publications,omitempty"`
+}
+
+// LogicalStatus is the logical replication between a source and a target
+// database.
+type LogicalStatus struct {
+	Publications  []Publication        `json:"publications"`
+	Subscriptions []SubscriptionStatus `json:"subscriptions"`
+	Warnings      []string             `json:"warnings"`
+}
+
+// Status reads the publications of src and the subscriptions of dst that
+// replicate from it: those subscribing to a publication of src or fed by one
+// of its slots.
+func Status(ctx context.Context, src, dst LogicalDB) (*LogicalStatus, error) {
+	pubs, err := src.Publications(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("read source pg_publication: %w", err)
+	}
+	version, err := src.ServerVersionNum(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("read source server version: %w", err)
+	}
+	slots, err := src.Slots(ctx, version >= 130000)
+	if err != nil {
+		return nil, fmt.Errorf("read source pg_replication_slots: %w", err)
+	}
+	subs, err := dst.Subscriptions(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("read target pg_subscription: %w", err)
+	}
+
+	published := map[string]bool{}
+	for _, p := range pubs {
+		published[p.Name] = true
+	}
+	st := &LogicalStatus{Publications: pubs, Subscriptions: []SubscriptionStatus{}, Warnings: []string{}}
+	if st.Publications == nil {
+		st.Publications = []Publication{}
+	}
+	for _, sub := range subs {
+		ss := SubscriptionStatus{Subscription: sub, Synced: sub.Ready(), TableStates: map[string]int{}}
+		for _, p := range sub.Publications {
+			if !published[p] {
+				ss.MissingPublications = append(ss.MissingPublications, p)
+			}
+		}
+		for i := range slots {
+			if sub.SlotName != "" && slots[i].Name == sub.SlotName {
+				ss.SourceSlot = &slots[i]
+			}
+		}
+		if len(ss.MissingPublications) == len(sub.Publications) && ss.SourceSlot == nil {
+			continue // replicates from another cluster
+		}
+		for _, t := range sub.Tables {
+			ss.TableStates[t.State]++
+		}
+
+		if len(ss.MissingPublications) > 0 {
+			st.Warnings = append(st.Warnings, fmt.Sprintf("subscription %s subscribes to %s, not published on the source",
+				sub.Name, strings.Join(ss.MissingPublications, ", ")))
+		}
+		switch _, running := sub.applyWorker(); {
+		case !sub.Enabled:
+			st.Warnings = append(st.Warnings, fmt.Sprintf("subscription %s is disabled", sub.Name))
+		case !running:
+			st.Warnings = append(st.Warnings, fmt.Sprintf("subscription %s has no running apply worker", sub.Name))
+		}
+		switch {
+		case sub.SlotName != "" && ss.SourceSlot == nil:
+			st.Warnings = append(st.Warnings, fmt.Sprintf("slot %s of subscription %s not found on the source", sub.SlotName, sub.Name))
+		case ss.SourceSlot != nil && ss.SourceSlot.WALStatus == "lost":
+			st.Warnings = append(st.Warnings, fmt.Sprintf("slot %s of subscription %s has lost required WAL", sub.SlotName, sub.Name))
+		}
+		st.Subscriptions = append(st.Subscriptions, ss)
+	}
+	return st, nil
+}
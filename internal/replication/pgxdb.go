@@ -0,0 +1,218 @@
+package replication
+
+import (
+	"context"
+	"sort"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// PgxDB implements the DB and LogicalDB interfaces using a real pgx.Conn.
+type PgxDB struct {
+	conn *pgx.Conn
+}
+
+// NewPgxDB wraps a pgx connection as a replication.DB.
+func NewPgxDB(conn *pgx.Conn) *PgxDB {
+	return &PgxDB{conn: conn}
+}
+
+func (p *PgxDB) ServerVersionNum(ctx context.Context) (int, error) {
+	var v int
+	err := p.conn.QueryRow(ctx, "SHOW server_version_num").Scan(&v)
+	return v, err
+}
+
+func (p *PgxDB) Standbys(ctx context.Context) ([]Standby, error) {
+	rows, err := p.conn.Query(ctx,
+		`SELECT COALESCE(application_name,''), COALESCE(client_addr::text,''),
+		        COALESCE(state,''), COALESCE(sync_state,''),
+		        COALESCE(pg_wal_lsn_diff(pg_current_wal_lsn(), replay_lsn),0)::bigint,
+		        (EXTRACT(EPOCH FROM write_lag)*1000)::float8,
+		        (EXTRACT(EPOCH FROM flush_lag)*1000)::float8,
+		        (EXTRACT(EPOCH FROM replay_lag)*1000)::float8
+		 FROM pg_stat_replication ORDER BY application_name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var standbys []Standby
+	for rows.Next() {
+		var s Standby
+		if err := rows.Scan(&s.ApplicationName, &s.ClientAddr, &s.State, &s.SyncState,
+			&s.ReplayLagBytes, &s.WriteLagMs, &s.FlushLagMs, &s.ReplayLagMs); err != nil {
+			return nil, err
+		}
+		standbys = append(standbys, s)
+	}
+	return standbys, rows.Err()
+}
+
+func (p *PgxDB) Setting(ctx context.Context, name string) (string, error) {
+	var v string
+	err := p.conn.QueryRow(ctx, "SELECT current_setting($1)", name).Scan(&v)
+	return v, err
+}
+
+// currentLSN is the instance's WAL position: the last replayed one on a
+// standby.
+const currentLSN = "CASE WHEN pg_is_in_recovery() THEN pg_last_wal_replay_lsn() ELSE pg_current_wal_lsn() END"
+
+func (p *PgxDB) Slots(ctx context.Context, walStatus bool) ([]Slot, error) {
+	extra := ", '', NULL::bigint"
+	if walStatus {
+		extra = ", COALESCE(wal_status,''), safe_wal_size"
+	}
+	rows, err := p.conn.Query(ctx,
+		`SELECT slot_name, COALESCE(plugin,''), slot_type, COALESCE(database,''),
+		        temporary, active, active_pid,
+		        COALESCE(restart_lsn::text,''), COALESCE(confirmed_flush_lsn::text,''),
+		        COALESCE(pg_wal_lsn_diff(`+currentLSN+`, restart_lsn),0)::bigint,
+		        COALESCE(pg_wal_lsn_diff(`+currentLSN+`, confirmed_flush_lsn),0)::bigint`+extra+`
+		 FROM pg_replication_slots ORDER BY slot_name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var slots []Slot
+	for rows.Next() {
+		var s Slot
+		if err := rows.Scan(&s.Name, &s.Plugin, &s.Type, &s.Database, &s.Temporary, &s.Active, &s.ActivePID,
+			&s.RestartLSN, &s.ConfirmedFlushLSN, &s.RetainedBytes, &s.PendingBytes, &s.WALStatus, &s.SafeWALSize); err != nil {
+			return nil, err
+		}
+		slots = append(slots, s)
+	}
+	return slots, rows.Err()
+}
+
+func (p *PgxDB) DropSlot(ctx context.Context, name string) error {
+	_, err := p.conn.Exec(ctx, "SELECT pg_drop_replication_slot($1)", name)
+	return err
+}
+
+func (p *PgxDB) AdvanceSlot(ctx context.Context, name, lsn string) (string, error) {
+	var end string
+	var err error
+	if lsn == "" {
+		err = p.conn.QueryRow(ctx,
+			"SELECT end_lsn::text FROM pg_replication_slot_advance($1, "+currentLSN+")", name).Scan(&end)
+	} else {
+		err = p.conn.QueryRow(ctx,
+			"SELECT end_lsn::text FROM pg_replication_slot_advance($1, $2::pg_lsn)", name, lsn).Scan(&end)
+	}
+	return end, err
+}
+
+func (p *PgxDB) Publications(ctx context.Context) ([]Publication, error) {
+	rows, err := p.conn.Query(ctx,
+		`SELECT p.pubname, pg_get_userbyid(p.pubowner), p.puballtables,
+		        p.pubinsert, p.pubupdate, p.pubdelete, p.pubtruncate,
+		        COALESCE((SELECT array_agg(format('%I.%I', t.schemaname, t.tablename) ORDER BY t.schemaname, t.tablename)
+		                  FROM pg_publication_tables t WHERE t.pubname = p.pubname), '{}')
+		 FROM pg_publication p ORDER BY p.pubname`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pubs []Publication
+	for rows.Next() {
+		var pub Publication
+		var ins, upd, del, trunc bool
+		if err := rows.Scan(&pub.Name, &pub.Owner, &pub.AllTables, &ins, &upd, &del, &trunc, &pub.Tables); err != nil {
+			return nil, err
+		}
+		pub.Operations = []string{}
+		for op, on := range map[string]bool{"insert": ins, "update": upd, "delete": del, "truncate": trunc} {
+			if on {
+				pub.Operations = append(pub.Operations, op)
+			}
+		}
+		sort.Strings(pub.Operations)
+		pubs = append(pubs, pub)
+	}
+	return pubs, rows.Err()
+}
+
+func (p *PgxDB) Subscriptions(ctx context.Context) ([]Subscription, error) {
+	rows, err := p.conn.Query(ctx,
+		`SELECT s.oid, s.subname, pg_get_userbyid(s.subowner), s.subenabled,
+		        COALESCE(s.subslotname::text,''), s.subpublications
+		 FROM pg_subscription s
+		 WHERE s.subdbid = (SELECT oid FROM pg_database WHERE datname = current_database())
+		 ORDER BY s.subname`)
+	if err != nil {
+		return nil, err
+	}
+	var subs []Subscription
+	index := map[uint32]int{}
+	for rows.Next() {
+		var oid uint32
+		s := Subscription{Tables: []SubscriptionTable{}, Workers: []SubscriptionWorker{}}
+		if err := rows.Scan(&oid, &s.Name, &s.Owner, &s.Enabled, &s.SlotName, &s.Publications); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		index[oid] = len(subs)
+		subs = append(subs, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = p.conn.Query(ctx,
+		`SELECT srsubid, srrelid::regclass::text, srsubstate::text, COALESCE(srsublsn::text,'')
+		 FROM pg_subscription_rel ORDER BY 2`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var oid uint32
+		var t SubscriptionTable
+		if err := rows.Scan(&oid, &t.Table, &t.State, &t.LSN); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		t.State = TableStateName(t.State)
+		if i, ok := index[oid]; ok {
+			subs[i].Tables = append(subs[i].Tables, t)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = p.conn.Query(ctx,
+		`SELECT subid, pid, COALESCE(relid::regclass::text,''),
+		        COALESCE(received_lsn::text,''), COALESCE(latest_end_lsn::text,''), last_msg_receipt_time
+		 FROM pg_stat_subscription`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var oid uint32
+		var w SubscriptionWorker
+		if err := rows.Scan(&oid, &w.PID, &w.Table, &w.ReceivedLSN, &w.LatestEndLSN, &w.LastMsgReceipt); err != nil {
+			return nil, err
+		}
+		if i, ok := index[oid]; ok {
+			subs[i].Workers = append(subs[i].Workers, w)
+		}
+	}
+	return subs, rows.Err()
+}
+
+func (p *PgxDB) Exec(ctx context.Context, sql string) error {
+	_, err := p.conn.Exec(ctx, sql)
+	return err
+}
+
+func (p *PgxDB) Close(ctx context.Context) error {
+	return p.conn.Close(ctx)
+}
@@ -0,0 +1,97 @@
+// Package replication reads the replication state of a live PostgreSQL
+// primary: its standbys and what synchronous commits wait for.
+package replication
+
+import (
+	"context"
+	"strings"
+)
+
+// Standby is a row of pg_stat_replication. The lag columns are the time
+// the primary waited, over the last commits, for the standby to write,
+// flush and replay WAL; they are nil when PostgreSQL has no sample (an idle
+// primary, or a standby that has caught up).
+type Standby struct {
+	ApplicationName string   `json:"application_name"`
+	ClientAddr      string   `json:"client_addr"`
+	State           string   `json:"state"`
+	SyncState       string   `json:"sync_state"` // async, potential, sync or quorum
+	ReplayLagBytes  int64    `json:"replay_lag_bytes"`
+	WriteLagMs      *float64 `json:"write_lag_ms"`
+	FlushLagMs      *float64 `json:"flush_lag_ms"`
+	ReplayLagMs     *float64 `json:"replay_lag_ms"`
+}
+
+// DB abstracts the replication queries run against the primary. This
+// interface enables unit testing with mocks.
+type DB interface {
+	ServerVersionNum(ctx context.Context) (int, error)
+	Standbys(ctx context.Context) ([]Standby, error)
+	Setting(ctx context.Context, name string) (string, error)
+	// Slots reads pg_replication_slots; walStatus adds the PostgreSQL 13
+	// columns wal_status and safe_wal_size.
+	Slots(ctx context.Context, walStatus bool) ([]Slot, error)
+	DropSlot(ctx context.Context, name string) error
+	// AdvanceSlot moves slot name forward to lsn ("" = the current WAL
+	// position) and returns where it ended.
+	AdvanceSlot(ctx context.Context, name, lsn string) (string, error)
+	Close(ctx context.Context) error
+}
+
+// CommitImpact estimates what synchronous replication adds to the latency
+// of a commit: with synchronous_commit remote_write, on or remote_apply, a
+// commit waits until every synchronous standby has written, flushed or
+// replayed its WAL, so the slowest one sets the cost.
+type CommitImpact struct {
+	SynchronousCommit string   `json:"synchronous_commit"`
+	WaitsFor          string   `json:"waits_for"` // write, flush, replay or nothing
+	SyncStandbys      []string `json:"sync_standbys"`
+	LatencyMs         *float64 `json:"latency_ms"`
+	Detail            string   `json:"detail"`
+}
+
+// EstimateCommitImpact computes the commit impact of standbys under the
+// given synchronous_commit setting.
+func EstimateCommitImpact(synchronousCommit string, standbys []Standby) CommitImpact {
+	impact := CommitImpact{SynchronousCommit: synchronousCommit, SyncStandbys: []string{}}
+	switch strings.ToLower(synchronousCommit) {
+	case "remote_write":
+		impact.WaitsFor = "write"
+	case "remote_apply":
+		impact.WaitsFor = "replay"
+	case "on", "true", "yes", "1", "":
+		impact.WaitsFor = "flush"
+	default: // local, off
+		impact.WaitsFor = "nothing"
+		impact.Detail = "commits do not wait for standbys with synchronous_commit " + synchronousCommit
+		return impact
+	}
+
+	for _, s := range standbys {
+		if s.SyncState != "sync" && s.SyncState != "quorum" {
+			continue
+		}
+		impact.SyncStandbys = append(impact.SyncStandbys, s.ApplicationName)
+		lag := s.FlushLagMs
+		switch impact.WaitsFor {
+		case "write":
+			lag = s.WriteLagMs
+		case "replay":
+			lag = s.ReplayLagMs
+		}
+		if lag != nil && (impact.LatencyMs == nil || *lag > *impact.LatencyMs) {
+			v := *lag
+			impact.LatencyMs = &v
+		}
+	}
+	switch {
+	case len(impact.SyncStandbys) == 0:
+		impact.WaitsFor = "nothing"
+		impact.Detail = "no synchronous standby; commits do not wait for replication"
+	case impact.LatencyMs == nil:
+		impact.Detail = "no recent " + impact.WaitsFor + " lag sample; the primary may be idle"
+	default:
+		impact.Detail = "each commit waits for the slowest synchronous standby to " + impact.WaitsFor + " its WAL"
+	}
+	return impact
+}
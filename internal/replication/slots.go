@@ -0,0 +1,190 @@
+package replication
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/luckyjian/pgdba/internal/patroni"
+)
+
+// Who keeps a replication slot, reported in Slot.ManagedBy.
+const (
+	SlotPermanent = "permanent" // listed in the DCS "slots" config; Patroni recreates it
+	SlotMember    = "member"    // the physical slot Patroni keeps for a cluster member
+	SlotIgnored   = "ignored"   // matched by the DCS "ignore_slots" config
+	SlotOrphaned  = "orphaned"  // nobody: it only retains WAL until dropped
+)
+
+// Slot is a row of pg_replication_slots. RetainedBytes is the WAL kept for
+// it, from its restart_lsn to the current (or, on a standby, the last
+// replayed) position; PendingBytes, for a logical slot, is the part its
+// consumer has not confirmed yet. WALStatus and SafeWALSize are only
+// reported by PostgreSQL 13 and later.
+type Slot struct {
+	Name              string `json:"slot_name"`
+	Type              string `json:"slot_type"`
+	Plugin            string `json:"plugin,omitempty"`
+	Database          string `json:"database,omitempty"`
+	Temporary         bool   `json:"temporary"`
+	Active            bool   `json:"active"`
+	ActivePID         *int32 `json:"active_pid"`
+	RestartLSN        string `json:"restart_lsn"`
+	ConfirmedFlushLSN string `json:"confirmed_flush_lsn,omitempty"`
+	RetainedBytes     int64  `json:"retained_bytes"`
+	PendingBytes      int64  `json:"pending_bytes,omitempty"`
+	WALStatus         string `json:"wal_status,omitempty"`
+	SafeWALSize       *int64 `json:"safe_wal_size,omitempty"`
+	ManagedBy         string `json:"managed_by"`
+}
+
+// SlotInventory is the replication slots of one instance.
+type SlotInventory struct {
+	ServerVersionNum int      `json:"server_version_num"`
+	Slots            []Slot   `json:"slots"`
+	RetainedBytes    int64    `json:"retained_bytes"`
+	Warnings         []string `json:"warnings"`
+}
+
+// MemberSlotName returns the name of the physical slot Patroni keeps for
+// member, as Patroni derives it: lower case, '-' and '.' replaced by '_',
+// other characters outside [a-z0-9_] by their code point, at most 63 bytes.
+func MemberSlotName(member string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(member) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_':
+			b.WriteRune(r)
+		case r == '-', r == '.':
+			b.WriteByte('_')
+		default:
+			fmt.Fprintf(&b, "u%04d", r)
+		}
+	}
+	name := b.String()
+	if len(name) > 63 {
+		name = name[:63]
+	}
+	return name
+}
+
+// ListSlots reads the replication slots of db and tells which ones Patroni
+// manages, from the dynamic configuration dcs (GET /config: "slots",
+// "ignore_slots") and the names of the cluster members. Slots are ordered
+// by retained WAL, largest first.
+func ListSlots(ctx context.Context, db DB, dcs map[string]interface{}, members []string) (*SlotInventory, error) {
+	version, err := db.ServerVersionNum(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("read server version: %w", err)
+	}
+	slots, err := db.Slots(ctx, version >= 130000)
+	if err != nil {
+		return nil, fmt.Errorf("read pg_replication_slots: %w", err)
+	}
+
+	permanent := map[string]bool{}
+	if cfg, ok := dcs["slots"].(map[string]interface{}); ok {
+		for name := range cfg {
+			permanent[name] = true
+		}
+	}
+	memberSlots := map[string]bool{}
+	for _, m := range members {
+		memberSlots[MemberSlotName(m)] = true
+	}
+	ignore, _ := dcs["ignore_slots"].([]interface{})
+
+	inv := &SlotInventory{ServerVersionNum: version, Slots: []Slot{}, Warnings: []string{}}
+	for _, s := range slots {
+		switch {
+		case permanent[s.Name]:
+			s.ManagedBy = SlotPermanent
+		case memberSlots[s.Name] && s.Type == "physical":
+			s.ManagedBy = SlotMember
+		case ignoredSlot(ignore, s):
+			s.ManagedBy = SlotIgnored
+		default:
+			s.ManagedBy = SlotOrphaned
+		}
+		inv.RetainedBytes += s.RetainedBytes
+		switch {
+		case s.WALStatus == "lost":
+			inv.Warnings = append(inv.Warnings, fmt.Sprintf("slot %s has lost required WAL; its consumer cannot resume", s.Name))
+		case s.ManagedBy == SlotOrphaned && !s.Active && s.RetainedBytes > 0:
+			inv.Warnings = append(inv.Warnings, fmt.Sprintf("slot %s is inactive and not managed by Patroni but retains %d bytes of WAL", s.Name, s.RetainedBytes))
+		}
+		inv.Slots = append(inv.Slots, s)
+	}
+	sort.SliceStable(inv.Slots, func(i, j int) bool { return inv.Slots[i].RetainedBytes > inv.Slots[j].RetainedBytes })
+	return inv, nil
+}
+
+// ignoredSlot reports whether s matches an entry of Patroni's ignore_slots:
+// every attribute the entry gives (name, type, database, plugin) matches.
+func ignoredSlot(ignore []interface{}, s Slot) bool {
+	for _, item := range ignore {
+		rule, ok := item.(map[string]interface{})
+		if !ok || len(rule) == 0 {
+			continue
+		}
+		match := true
+		for key, want := range map[string]string{"name": s.Name, "type": s.Type, "database": s.Database, "plugin": s.Plugin} {
+			if v, ok := rule[key]; ok && patroni.ConfigString(v) != want {
+				match = false
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckDrop returns slot name of inv if it may be dropped: it must be
+// inactive, and not one Patroni would recreate.
+func CheckDrop(inv *SlotInventory, name string) (Slot, error) {
+	s, err := findSlot(inv, name)
+	if err != nil {
+		return s, err
+	}
+	switch {
+	case s.Active:
+		return s, fmt.Errorf("slot %s is active (%s); stop its consumer first", name, activeBy(s))
+	case s.ManagedBy == SlotPermanent:
+		return s, fmt.Errorf("slot %s is a permanent slot in the DCS config; remove it from \"slots\" instead", name)
+	case s.ManagedBy == SlotMember:
+		return s, fmt.Errorf("slot %s belongs to a cluster member; Patroni would recreate it", name)
+	}
+	return s, nil
+}
+
+// CheckAdvance returns slot name of inv if it may be advanced: it must be
+// inactive.
+func CheckAdvance(inv *SlotInventory, name string) (Slot, error) {
+	s, err := findSlot(inv, name)
+	if err != nil {
+		return s, err
+	}
+	if s.Active {
+		return s, fmt.Errorf("slot %s is active (%s); only an inactive slot can be advanced", name, activeBy(s))
+	}
+	return s, nil
+}
+
+func findSlot(inv *SlotInventory, name string) (Slot, error) {
+	for _, s := range inv.Slots {
+		if s.Name == name {
+			return s, nil
+		}
+	}
+	return Slot{}, fmt.Errorf("replication slot %q not found", name)
+}
+
+// activeBy describes the process using an active slot.
+func activeBy(s Slot) string {
+	if s.ActivePID == nil {
+		return "pid unknown"
+	}
+	return fmt.Sprintf("pid %d", *s.ActivePID)
+}
@@ -0,0 +1,183 @@
+package spec
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/luckyjian/pgdba/internal/cluster"
+	"github.com/luckyjian/pgdba/internal/lifecycle"
+	"github.com/luckyjian/pgdba/internal/patroni"
+	"github.com/luckyjian/pgdba/internal/provider"
+)
+
+// Target bundles what Apply needs to change a cluster. Provider may be nil
+// for clusters that are not managed by pgdba, in which case only label and
+// DCS config actions can be applied.
+type Target struct {
+	Registry  *cluster.Registry
+	Patroni   *patroni.Client
+	Provider  provider.Provider
+	Passwords lifecycle.Passwords
+}
+
+// ApplyResult reports the actions Apply carried out.
+type ApplyResult struct {
+	Cluster string                `json:"cluster"`
+	Applied []Action              `json:"applied"`
+	Events  []lifecycle.StepEvent `json:"events"`
+	Plan    *Plan                 `json:"plan"`
+}
+
+// Apply carries out plan, computed by Compute from s and st, against t.
+// Actions run in plan order except that all DCS config changes are sent as a
+// single PATCH /config, and members are added and removed together the way
+// cluster scale does, in ordinal order and with their DCS keys cleaned up.
+// Apply stops at the first failing action; the result lists what was
+// applied before it. Plans with blocked actions are refused.
+func Apply(ctx context.Context, s *Spec, st *State, plan *Plan, t Target) (*ApplyResult, error) {
+	result := &ApplyResult{Cluster: plan.Cluster, Applied: []Action{}, Events: []lifecycle.StepEvent{}, Plan: plan}
+	if plan.Blocked > 0 {
+		return result, fmt.Errorf("plan has %d blocked action(s); resolve them before applying", plan.Blocked)
+	}
+
+	var tmpl lifecycle.MemberTemplate
+	if plan.NeedsPasswords() {
+		var err error
+		if tmpl, err = lifecycle.TemplateFor(st.Entry, st.Nodes, t.Passwords); err != nil {
+			return result, err
+		}
+		if s.Postgres.DataDir != "" {
+			tmpl.DataDir = s.Postgres.DataDir
+		}
+	}
+	hosts := map[string]string{}
+	for _, m := range s.Members {
+		hosts[m.Name] = m.host()
+	}
+
+	patch := map[string]interface{}{}
+	var patched, adds, removes []Action
+	for _, a := range plan.Actions {
+		switch a.Type {
+		case ActionPatchConfig:
+			patroni.SetConfigPath(patch, a.path, a.To)
+			patched = append(patched, a)
+		case ActionAddMember:
+			adds = append(adds, a)
+		case ActionRemoveMember:
+			removes = append(removes, a)
+		}
+	}
+
+	for _, a := range plan.Actions {
+		var err error
+		switch a.Type {
+		case ActionSetLabels:
+			err = setLabels(t.Registry, st.Entry.Name, s.Labels)
+		case ActionPatchConfig:
+			if patched == nil {
+				continue // already sent with the first config action
+			}
+			if err = t.Patroni.PatchConfig(ctx, patch); err == nil {
+				result.Applied = append(result.Applied, patched...)
+				patched = nil
+				continue
+			}
+		case ActionAddMember:
+			if adds == nil {
+				continue // already created with the first member action
+			}
+			var done []string
+			done, err = lifecycle.AddMembers(ctx, t.Provider, tmpl, targets(adds), hosts, &result.Events)
+			result.Applied = append(result.Applied, completed(adds, done)...)
+			if err == nil {
+				adds = nil
+				continue
+			}
+		case ActionRemoveMember:
+			if removes == nil {
+				continue // already removed with the first member action
+			}
+			var done []string
+			done, err = lifecycle.RemoveMembers(ctx, t.Provider, st.Entry.Name, st.Nodes, targets(removes), &result.Events)
+			result.Applied = append(result.Applied, completed(removes, done)...)
+			if err == nil {
+				removes = nil
+				continue
+			}
+		case ActionRemovePgBouncer:
+			err = t.Provider.DestroyNode(ctx, a.Target)
+		case ActionCreatePgBouncer:
+			_, err = t.Provider.CreateNode(ctx, lifecycle.PgBouncerNode(tmpl, st.primaryHost(), s.PgBouncer.Settings()))
+		case ActionUpdatePgBouncer:
+			err = reloadPgBouncer(ctx, t.Provider, a.Target,
+				lifecycle.RenderPgBouncerIni(st.primaryHost(), portOf(st.Entry), s.PgBouncer.Settings()))
+		default:
+			err = fmt.Errorf("unsupported action")
+		}
+		if err != nil {
+			return result, fmt.Errorf("%s %s: %w", a.Type, a.Target, err)
+		}
+		result.Applied = append(result.Applied, a)
+	}
+	return result, nil
+}
+
+// targets returns the targets of actions.
+func targets(actions []Action) []string {
+	names := make([]string, len(actions))
+	for i, a := range actions {
+		names[i] = a.Target
+	}
+	return names
+}
+
+// completed returns the actions whose target is in done, in the order of done.
+func completed(actions []Action, done []string) []Action {
+	byTarget := map[string]Action{}
+	for _, a := range actions {
+		byTarget[a.Target] = a
+	}
+	out := make([]Action, 0, len(done))
+	for _, name := range done {
+		out = append(out, byTarget[name])
+	}
+	return out
+}
+
+// setLabels replaces the user labels of the registry entry name, keeping the
+// provider.LabelCluster label.
+func setLabels(reg *cluster.Registry, name string, labels map[string]string) error {
+	entry, err := reg.Get(name)
+	if err != nil {
+		return err
+	}
+	next := map[string]string{}
+	if v, ok := entry.Labels[provider.LabelCluster]; ok {
+		next[provider.LabelCluster] = v
+	}
+	for k, v := range labels {
+		next[k] = v
+	}
+	entry.Labels = next
+	return reg.Add(*entry)
+}
+
+// reloadPgBouncer rewrites pgbouncer.ini on node and asks PgBouncer to
+// reload it.
+func reloadPgBouncer(ctx context.Context, p provider.Provider, node, ini string) error {
+	if err := provider.WriteFile(ctx, p, node, lifecycle.PgBouncerIniPath, ini); err != nil {
+		return err
+	}
+	if _, err := p.ExecOnNode(ctx, node, []string{"pkill", "-HUP", "-x", "pgbouncer"}); err != nil {
+		return fmt.Errorf("reload pgbouncer: %w", err)
+	}
+	return nil
+}
+
+func portOf(e *cluster.Entry) int {
+	if e.PGPort == 0 {
+		return 5432
+	}
+	return e.PGPort
+}
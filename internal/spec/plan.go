@@ -0,0 +1,349 @@
+package spec
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/luckyjian/pgdba/internal/cluster"
+	"github.com/luckyjian/pgdba/internal/lifecycle"
+	"github.com/luckyjian/pgdba/internal/patroni"
+	"github.com/luckyjian/pgdba/internal/provider"
+)
+
+// Action types reported in Action.Type.
+const (
+	ActionSetLabels       = "set_labels"
+	ActionPatchConfig     = "patch_config"
+	ActionAddMember       = "add_member"
+	ActionRemoveMember    = "remove_member"
+	ActionCreatePgBouncer = "create_pgbouncer"
+	ActionUpdatePgBouncer = "update_pgbouncer"
+	ActionRemovePgBouncer = "remove_pgbouncer"
+	ActionChangeVersion   = "change_version"
+	ActionChangePort      = "change_port"
+)
+
+// Action is one change needed to converge a cluster to its spec. Blocked
+// actions cannot be applied automatically; Reason says why.
+type Action struct {
+	Type    string      `json:"type"`
+	Target  string      `json:"target"`
+	From    interface{} `json:"from,omitempty"`
+	To      interface{} `json:"to,omitempty"`
+	Blocked bool        `json:"blocked,omitempty"`
+	Reason  string      `json:"reason,omitempty"`
+
+	path []string // DCS config key path for ActionPatchConfig
+}
+
+// Plan is the ordered list of actions that converge a cluster to a spec.
+type Plan struct {
+	Cluster string   `json:"cluster"`
+	Actions []Action `json:"actions"`
+	InSync  bool     `json:"in_sync"`
+	Blocked int      `json:"blocked"`
+}
+
+// LabelsOnly reports whether the plan changes nothing but registry labels,
+// which leaves Patroni alone and is therefore safe while it is paused.
+func (p *Plan) LabelsOnly() bool {
+	for _, a := range p.Actions {
+		if a.Type != ActionSetLabels {
+			return false
+		}
+	}
+	return true
+}
+
+// NeedsPasswords reports whether applying the plan renders node
+// configuration that embeds the cluster credentials.
+func (p *Plan) NeedsPasswords() bool {
+	for _, a := range p.Actions {
+		if a.Type == ActionAddMember || a.Type == ActionCreatePgBouncer {
+			return true
+		}
+	}
+	return false
+}
+
+// State is the observed state of a registered cluster.
+type State struct {
+	Entry   *cluster.Entry
+	Members []patroni.Member
+	Config  map[string]interface{}
+	// Nodes are the provider nodes of the cluster; nil when the cluster is
+	// not managed by pgdba.
+	Nodes []provider.NodeStatus
+	// Version is the PostgreSQL major version, 0 when unknown.
+	Version      int
+	PgBouncer    bool
+	PgBouncerIni string
+}
+
+// Observe reads the live state of the cluster entry from Patroni (/cluster,
+// /config, /patroni) and, for managed clusters, from p. p may be nil for
+// external clusters.
+func Observe(ctx context.Context, entry *cluster.Entry, client *patroni.Client, p provider.Provider) (*State, error) {
+	st := &State{Entry: entry}
+	cs, err := client.GetClusterStatus(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get cluster status: %w", err)
+	}
+	st.Members = cs.Members
+	if st.Config, err = client.GetConfig(ctx); err != nil {
+		return nil, fmt.Errorf("get dynamic config: %w", err)
+	}
+	if info, err := client.GetNodeInfo(ctx); err == nil {
+		st.Version = info.ServerVersion / 10000
+	}
+	if p == nil {
+		return st, nil
+	}
+
+	nodes, err := lifecycle.ClusterNodes(ctx, p, entry.Name)
+	if err != nil {
+		return nil, err
+	}
+	st.Nodes = []provider.NodeStatus{}
+	bouncer := lifecycle.PgBouncerNodeName(entry.Name)
+	for _, n := range nodes {
+		st.Nodes = append(st.Nodes, n)
+		if n.ID == bouncer {
+			st.PgBouncer = true
+			// An unreadable ini is treated as out of date.
+			st.PgBouncerIni, _ = p.ExecOnNode(ctx, n.ID, []string{"cat", lifecycle.PgBouncerIniPath})
+		}
+	}
+	return st, nil
+}
+
+// managed reports whether st was observed through a provider.
+func (st *State) managed() bool {
+	return st.Nodes != nil
+}
+
+// leader returns the current leader member, if any.
+func (st *State) leader() *patroni.Member {
+	for i, m := range st.Members {
+		if m.Role == "leader" || m.Role == "master" || m.Role == "primary" {
+			return &st.Members[i]
+		}
+	}
+	return nil
+}
+
+// primaryHost returns the address PgBouncer should route to.
+func (st *State) primaryHost() string {
+	if l := st.leader(); l != nil && l.Host != "" {
+		return l.Host
+	}
+	return st.Entry.PGHost
+}
+
+// memberNames returns the Patroni members known to Patroni or present as
+// provider nodes, sorted.
+func (st *State) memberNames() []string {
+	seen := map[string]bool{}
+	for _, m := range st.Members {
+		seen[m.Name] = true
+	}
+	bouncer := lifecycle.PgBouncerNodeName(st.Entry.Name)
+	for _, n := range st.Nodes {
+		if n.Role != provider.RoleEtcd && n.ID != bouncer {
+			seen[n.ID] = true
+		}
+	}
+	names := make([]string, 0, len(seen))
+	for n := range seen {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Compute returns the plan converging the observed state st to s.
+func Compute(s *Spec, st *State) *Plan {
+	plan := &Plan{Cluster: s.Name, Actions: []Action{}}
+	add := func(a Action) {
+		if a.Blocked {
+			plan.Blocked++
+		}
+		plan.Actions = append(plan.Actions, a)
+	}
+	unmanaged := "cluster is not managed by pgdba"
+
+	if s.Postgres.Port != 0 && st.Entry.PGPort != 0 && s.Postgres.Port != st.Entry.PGPort {
+		add(Action{Type: ActionChangePort, Target: s.Name, From: st.Entry.PGPort, To: s.Postgres.Port,
+			Blocked: true, Reason: "the PostgreSQL port cannot be changed in place"})
+	}
+	if s.Postgres.Version != 0 && st.Version != 0 && s.Postgres.Version != st.Version {
+		add(Action{Type: ActionChangeVersion, Target: s.Name, From: st.Version, To: s.Postgres.Version,
+			Blocked: true, Reason: "major version changes need a major upgrade, not a spec apply"})
+	}
+
+	current := map[string]string{}
+	for k, v := range st.Entry.Labels {
+		if k != provider.LabelCluster {
+			current[k] = v
+		}
+	}
+	desired := map[string]string{}
+	for k, v := range s.Labels {
+		desired[k] = v
+	}
+	if !equalLabels(current, desired) {
+		add(Action{Type: ActionSetLabels, Target: s.Name, From: current, To: desired})
+	}
+
+	for _, a := range configActions(s, st.Config) {
+		add(a)
+	}
+
+	live := st.memberNames()
+	isLive := map[string]bool{}
+	for _, n := range live {
+		isLive[n] = true
+	}
+	wanted := map[string]bool{}
+	for _, m := range s.Members {
+		wanted[m.Name] = true
+		if isLive[m.Name] {
+			continue
+		}
+		a := Action{Type: ActionAddMember, Target: m.Name, To: m.host()}
+		switch {
+		case !st.managed():
+			a.Blocked, a.Reason = true, unmanaged
+		case st.Entry.Provider == "kubernetes" && lifecycle.PGOrdinal(s.Name, m.Name) < 0:
+			a.Blocked, a.Reason = true, fmt.Sprintf("kubernetes pods are named by ordinal; name the member like %s",
+				lifecycle.PGNodeName(s.Name, 0))
+		}
+		add(a)
+	}
+	leader := st.leader()
+	for _, n := range live {
+		if wanted[n] {
+			continue
+		}
+		a := Action{Type: ActionRemoveMember, Target: n}
+		switch {
+		case !st.managed():
+			a.Blocked, a.Reason = true, unmanaged
+		case leader != nil && leader.Name == n:
+			a.Blocked, a.Reason = true, "member is the current leader; switch over first"
+		}
+		add(a)
+	}
+
+	bouncer := lifecycle.PgBouncerNodeName(s.Name)
+	ini := lifecycle.RenderPgBouncerIni(st.primaryHost(), portOf(st.Entry), s.PgBouncer.Settings())
+	switch {
+	case s.PgBouncer.Enabled && !st.PgBouncer:
+		a := Action{Type: ActionCreatePgBouncer, Target: bouncer, To: s.PgBouncer.Settings()}
+		if !st.managed() {
+			a.Blocked, a.Reason = true, unmanaged
+		}
+		add(a)
+	case s.PgBouncer.Enabled && st.PgBouncerIni != ini:
+		add(Action{Type: ActionUpdatePgBouncer, Target: bouncer, To: s.PgBouncer.Settings()})
+	case !s.PgBouncer.Enabled && st.PgBouncer:
+		add(Action{Type: ActionRemovePgBouncer, Target: bouncer})
+	}
+
+	plan.InSync = len(plan.Actions) == 0
+	return plan
+}
+
+// configActions compares the DCS keys the spec sets with the live dynamic
+// configuration. Keys the spec does not mention are left alone.
+func configActions(s *Spec, live map[string]interface{}) []Action {
+	want := map[string]leaf{}
+	flatten(nil, s.Patroni, want)
+	for k, v := range s.Postgres.Parameters {
+		path := []string{"postgresql", "parameters", k}
+		want[strings.Join(path, ".")] = leaf{path: path, value: v}
+	}
+
+	keys := make([]string, 0, len(want))
+	for k := range want {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var actions []Action
+	for _, k := range keys {
+		w := want[k]
+		cur, ok := lookup(live, w.path)
+		if ok && normalize(cur) == normalize(w.value) {
+			continue
+		}
+		a := Action{Type: ActionPatchConfig, Target: k, To: w.value, path: w.path}
+		if ok {
+			a.From = cur
+		}
+		actions = append(actions, a)
+	}
+	return actions
+}
+
+// leaf is a scalar value at a DCS config key path.
+type leaf struct {
+	path  []string
+	value interface{}
+}
+
+// flatten collects the scalar leaves of m under prefix into out, keyed by
+// their dotted path.
+func flatten(prefix []string, m map[string]interface{}, out map[string]leaf) {
+	for k, v := range m {
+		path := append(append([]string(nil), prefix...), k)
+		if sub, ok := v.(map[string]interface{}); ok {
+			flatten(path, sub, out)
+			continue
+		}
+		out[strings.Join(path, ".")] = leaf{path: path, value: v}
+	}
+}
+
+// lookup returns the value at path in m.
+func lookup(m map[string]interface{}, path []string) (interface{}, bool) {
+	var cur interface{} = m
+	for _, k := range path {
+		sub, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = sub[k]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// normalize renders a config value for comparison, so that YAML integers,
+// JSON numbers and quoted numbers compare equal.
+func normalize(v interface{}) string {
+	switch n := v.(type) {
+	case float64:
+		return strconv.FormatFloat(n, 'f', -1, 64)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func equalLabels(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
+}
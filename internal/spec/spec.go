@@ -0,0 +1,123 @@
+// Package spec describes a cluster declaratively and reconciles a live
+// cluster towards that description: Plan computes the difference between a
+// Spec and the observed state, Apply converges it.
+package spec
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/luckyjian/pgdba/internal/lifecycle"
+)
+
+// Spec is the desired state of a cluster, as read from a YAML file.
+type Spec struct {
+	Name      string                 `yaml:"name" json:"name"`
+	Postgres  PostgresSpec           `yaml:"postgres" json:"postgres"`
+	Members   []MemberSpec           `yaml:"members" json:"members"`
+	Patroni   map[string]interface{} `yaml:"patroni" json:"patroni,omitempty"`
+	PgBouncer PgBouncerSpec          `yaml:"pgbouncer" json:"pgbouncer"`
+	Labels    map[string]string      `yaml:"labels" json:"labels,omitempty"`
+}
+
+// PostgresSpec holds the PostgreSQL settings of a spec. Parameters are
+// managed through the DCS as postgresql.parameters.
+type PostgresSpec struct {
+	Version    int                    `yaml:"version" json:"version,omitempty"` // major version; 0 = not managed
+	Port       int                    `yaml:"port" json:"port,omitempty"`
+	DataDir    string                 `yaml:"data_dir" json:"data_dir,omitempty"`
+	Parameters map[string]interface{} `yaml:"parameters" json:"parameters,omitempty"`
+}
+
+// MemberSpec is one Patroni member. Host defaults to the member name.
+type MemberSpec struct {
+	Name string `yaml:"name" json:"name"`
+	Host string `yaml:"host" json:"host,omitempty"`
+}
+
+// PgBouncerSpec enables and configures the cluster's PgBouncer. Zero
+// settings take the cluster init defaults.
+type PgBouncerSpec struct {
+	Enabled                     bool `yaml:"enabled" json:"enabled"`
+	lifecycle.PgBouncerSettings `yaml:",inline"`
+}
+
+// Settings returns the PgBouncer settings with defaults filled in.
+func (p PgBouncerSpec) Settings() lifecycle.PgBouncerSettings {
+	s := p.PgBouncerSettings
+	def := lifecycle.DefaultPgBouncerSettings()
+	if s.PoolMode == "" {
+		s.PoolMode = def.PoolMode
+	}
+	if s.MaxClientConn == 0 {
+		s.MaxClientConn = def.MaxClientConn
+	}
+	if s.DefaultPoolSize == 0 {
+		s.DefaultPoolSize = def.DefaultPoolSize
+	}
+	return s
+}
+
+// Load reads and validates the spec file at path.
+func Load(path string) (*Spec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read spec %s: %w", path, err)
+	}
+	var s Spec
+	if err := yaml.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("parse spec %s: %w", path, err)
+	}
+	if err := s.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid spec %s: %w", path, err)
+	}
+	return &s, nil
+}
+
+// Validate checks the spec for structural errors.
+func (s *Spec) Validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if len(s.Members) == 0 {
+		return fmt.Errorf("at least one member is required")
+	}
+	seen := make(map[string]bool, len(s.Members))
+	for i, m := range s.Members {
+		if m.Name == "" {
+			return fmt.Errorf("members[%d]: name is required", i)
+		}
+		if seen[m.Name] {
+			return fmt.Errorf("members[%d]: duplicate member %q", i, m.Name)
+		}
+		seen[m.Name] = true
+	}
+	if s.Postgres.Port < 0 || s.Postgres.Port > 65535 {
+		return fmt.Errorf("postgres.port %d out of range", s.Postgres.Port)
+	}
+	if s.Postgres.Version < 0 {
+		return fmt.Errorf("postgres.version must not be negative")
+	}
+	if _, ok := s.Patroni["postgresql"]; ok {
+		return fmt.Errorf("patroni.postgresql is not allowed; set postgres.parameters instead")
+	}
+	switch s.PgBouncer.PoolMode {
+	case "", "session", "transaction", "statement":
+	default:
+		return fmt.Errorf("pgbouncer.pool_mode must be session, transaction or statement, got %q", s.PgBouncer.PoolMode)
+	}
+	if s.PgBouncer.MaxClientConn < 0 || s.PgBouncer.DefaultPoolSize < 0 {
+		return fmt.Errorf("pgbouncer pool sizes must not be negative")
+	}
+	return nil
+}
+
+// host returns the address member m advertises.
+func (m MemberSpec) host() string {
+	if m.Host != "" {
+		return m.Host
+	}
+	return m.Name
+}
@@ -0,0 +1,112 @@
+package tuning
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/luckyjian/pgdba/internal/inspect"
+	"github.com/luckyjian/pgdba/internal/patroni"
+)
+
+// patroniLocalParams are taken from each member's local Patroni
+// configuration; Patroni ignores them in postgresql.parameters of the DCS.
+var patroniLocalParams = map[string]bool{
+	"listen_addresses": true,
+	"port":             true,
+	"cluster_name":     true,
+	"hba_file":         true,
+	"ident_file":       true,
+	"config_file":      true,
+	"data_directory":   true,
+}
+
+// boolValues are the spellings PostgreSQL accepts for boolean parameters.
+var boolValues = map[string]bool{
+	"on": true, "off": true, "true": true, "false": true, "yes": true, "no": true,
+	"1": true, "0": true, "t": true, "f": true, "y": true, "n": true,
+}
+
+// ValidateDCSParameters checks PostgreSQL parameters about to be written to
+// Patroni's postgresql.parameters against pg_settings. A nil value removes the
+// parameter from the DCS. Unknown, read-only and Patroni-local parameters and
+// values of the wrong type are errors; parameters that need a restart, and
+// unknown extension parameters (names with a dot), are warnings.
+func ValidateDCSParameters(settings []inspect.PGSetting, params map[string]interface{}) *inspect.DryRunResult {
+	result := &inspect.DryRunResult{OK: true}
+	byName := make(map[string]inspect.PGSetting, len(settings))
+	for _, s := range settings {
+		byName[s.Name] = s
+	}
+	fail := func(format string, args ...interface{}) {
+		result.OK = false
+		result.Errors = append(result.Errors, fmt.Sprintf(format, args...))
+	}
+
+	names := make([]string, 0, len(params))
+	for n := range params {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value := params[name]
+		if patroniLocalParams[name] {
+			fail("%s: set from the local Patroni configuration, ignored in the DCS", name)
+			continue
+		}
+		s, ok := byName[name]
+		if !ok {
+			if strings.Contains(name, ".") {
+				result.Warnings = append(result.Warnings,
+					fmt.Sprintf("%s: not known to the server; the extension defining it may not be loaded", name))
+			} else {
+				fail("%s: unknown parameter", name)
+			}
+			continue
+		}
+		if s.Context == "internal" {
+			fail("%s: read-only parameter (context=internal)", name)
+			continue
+		}
+		if value != nil {
+			if msg := checkSettingValue(s, patroni.ConfigString(value)); msg != "" {
+				fail("%s: %s", name, msg)
+				continue
+			}
+		}
+		if s.Context == "postmaster" {
+			result.Warnings = append(result.Warnings,
+				fmt.Sprintf("%s: requires PostgreSQL restart to take effect (context=%s)", name, s.Context))
+		}
+	}
+	return result
+}
+
+// checkSettingValue returns why value is not valid for s, or "". Values with
+// units (64MB, 5min) are accepted for parameters that have a unit; bounds
+// are checked for plain numbers only.
+func checkSettingValue(s inspect.PGSetting, value string) string {
+	v := strings.TrimSpace(value)
+	switch s.VarType {
+	case "bool":
+		if !boolValues[strings.ToLower(v)] {
+			return fmt.Sprintf("invalid boolean %q", value)
+		}
+	case "integer", "real":
+		n, ok := patroni.ConfigNumber(v)
+		if !ok {
+			if s.Unit == "" || v == "" || ((v[0] < '0' || v[0] > '9') && v[0] != '-') {
+				return fmt.Sprintf("invalid %s %q", s.VarType, value)
+			}
+			return ""
+		}
+		if min, ok := patroni.ConfigNumber(s.MinVal); ok && n < min {
+			return fmt.Sprintf("%s is below the minimum %s", v, s.MinVal)
+		}
+		if max, ok := patroni.ConfigNumber(s.MaxVal); ok && n > max {
+			return fmt.Sprintf("%s is above the maximum %s", v, s.MaxVal)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,129 @@
+package upgrade
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// PgxDB implements the DB interface using a real pgx.Conn.
+type PgxDB struct {
+	conn *pgx.Conn
+}
+
+// NewPgxDB wraps a pgx connection as an upgrade.DB.
+func NewPgxDB(conn *pgx.Conn) *PgxDB {
+	return &PgxDB{conn: conn}
+}
+
+func (p *PgxDB) ServerVersionNum(ctx context.Context) (int, error) {
+	var v int
+	err := p.conn.QueryRow(ctx, "SHOW server_version_num").Scan(&v)
+	return v, err
+}
+
+func (p *PgxDB) Databases(ctx context.Context) ([]string, error) {
+	rows, err := p.conn.Query(ctx,
+		"SELECT datname FROM pg_database WHERE datallowconn AND NOT datistemplate ORDER BY datname")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		result = append(result, name)
+	}
+	return result, rows.Err()
+}
+
+func (p *PgxDB) Extensions(ctx context.Context) ([]Extension, error) {
+	rows, err := p.conn.Query(ctx, "SELECT extname, extversion FROM pg_extension ORDER BY extname")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []Extension
+	for rows.Next() {
+		var e Extension
+		if err := rows.Scan(&e.Name, &e.Version); err != nil {
+			return nil, err
+		}
+		result = append(result, e)
+	}
+	return result, rows.Err()
+}
+
+func (p *PgxDB) RegColumns(ctx context.Context) ([]Column, error) {
+	rows, err := p.conn.Query(ctx,
+		`SELECT n.nspname, c.relname, a.attname, t.typname
+		 FROM pg_catalog.pg_class c
+		 JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+		 JOIN pg_catalog.pg_attribute a ON a.attrelid = c.oid
+		 JOIN pg_catalog.pg_type t ON t.oid = a.atttypid
+		 WHERE c.relkind IN ('r', 'm', 'p')
+		   AND a.attnum > 0 AND NOT a.attisdropped
+		   AND t.typnamespace = 'pg_catalog'::regnamespace
+		   AND t.typname = ANY($1)
+		   AND n.nspname NOT IN ('pg_catalog', 'information_schema')
+		 ORDER BY 1, 2, 3`, UnsupportedRegTypes)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []Column
+	for rows.Next() {
+		var c Column
+		if err := rows.Scan(&c.Schema, &c.Table, &c.Column, &c.Type); err != nil {
+			return nil, err
+		}
+		result = append(result, c)
+	}
+	return result, rows.Err()
+}
+
+func (p *PgxDB) Settings(ctx context.Context) ([]Setting, error) {
+	rows, err := p.conn.Query(ctx, "SELECT name, setting, source FROM pg_settings ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []Setting
+	for rows.Next() {
+		var s Setting
+		if err := rows.Scan(&s.Name, &s.Setting, &s.Source); err != nil {
+			return nil, err
+		}
+		result = append(result, s)
+	}
+	return result, rows.Err()
+}
+
+func (p *PgxDB) PreparedXacts(ctx context.Context) ([]PreparedXact, error) {
+	rows, err := p.conn.Query(ctx, "SELECT gid, owner, database FROM pg_prepared_xacts ORDER BY prepared")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []PreparedXact
+	for rows.Next() {
+		var x PreparedXact
+		if err := rows.Scan(&x.GID, &x.Owner, &x.Database); err != nil {
+			return nil, err
+		}
+		result = append(result, x)
+	}
+	return result, rows.Err()
+}
+
+func (p *PgxDB) Close(ctx context.Context) error {
+	return p.conn.Close(ctx)
+}
@@ -0,0 +1,282 @@
+// Package upgrade checks whether a live PostgreSQL instance can be moved to
+// a newer major version.
+package upgrade
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// Upgrade methods.
+const (
+	MethodLink    = "link"    // pg_upgrade --link on the leader, replicas re-cloned
+	MethodLogical = "logical" // new cluster fed by logical replication, then cut over
+)
+
+// Check statuses.
+const (
+	StatusOK   = "ok"
+	StatusWarn = "warn"
+	StatusFail = "fail"
+)
+
+// Check names, in report order.
+const (
+	CheckVersion       = "target_version"
+	CheckExtensions    = "extensions"
+	CheckRegColumns    = "reg_columns"
+	CheckRemovedGUCs   = "removed_gucs"
+	CheckPreparedXacts = "prepared_xacts"
+	CheckWalLevel      = "wal_level"
+)
+
+// Extension is an extension installed in a database.
+type Extension struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// Column is a user table column.
+type Column struct {
+	Schema string `json:"schema"`
+	Table  string `json:"table"`
+	Column string `json:"column"`
+	Type   string `json:"type"`
+}
+
+// Setting is a row of pg_settings.
+type Setting struct {
+	Name    string `json:"name"`
+	Setting string `json:"setting"`
+	Source  string `json:"source"`
+}
+
+// PreparedXact is a row of pg_prepared_xacts.
+type PreparedXact struct {
+	GID      string `json:"gid"`
+	Owner    string `json:"owner"`
+	Database string `json:"database"`
+}
+
+// DB abstracts the catalog queries of the readiness checks, run against one
+// database of the instance. This interface enables unit testing with mocks.
+type DB interface {
+	ServerVersionNum(ctx context.Context) (int, error)
+	Databases(ctx context.Context) ([]string, error)
+	Extensions(ctx context.Context) ([]Extension, error)
+	RegColumns(ctx context.Context) ([]Column, error)
+	Settings(ctx context.Context) ([]Setting, error)
+	PreparedXacts(ctx context.Context) ([]PreparedXact, error)
+	Close(ctx context.Context) error
+}
+
+// Opener connects to another database of the same instance.
+type Opener func(ctx context.Context, database string) (DB, error)
+
+// CheckResult is the outcome of one readiness check.
+type CheckResult struct {
+	Name   string   `json:"name"`
+	Status string   `json:"status"`
+	Detail string   `json:"detail"`
+	Items  []string `json:"items,omitempty"`
+}
+
+// Report is the readiness of an instance for a major version upgrade.
+type Report struct {
+	FromVersion int           `json:"from_version"` // server_version_num
+	FromMajor   int           `json:"from_major"`
+	ToMajor     int           `json:"to_major"`
+	Method      string        `json:"method"`
+	Databases   []string      `json:"databases"`
+	Ready       bool          `json:"ready"`
+	Checks      []CheckResult `json:"checks"`
+}
+
+// removedExtensions maps extensions to the major version that no longer
+// ships them; pg_upgrade cannot carry them over.
+var removedExtensions = map[string]int{
+	"tsearch2":     10,
+	"chkpass":      11,
+	"timetravel":   12,
+	"plpythonu":    15,
+	"plpython2u":   15,
+	"adminpack":    17,
+	"old_snapshot": 17,
+}
+
+// removedGUCs maps configuration parameters to the major version that
+// removed them; the new server refuses to start while they are set.
+var removedGUCs = map[string]int{
+	"min_parallel_relation_size":        10,
+	"sql_inheritance":                   10,
+	"replacement_sort_tuples":           11,
+	"default_with_oids":                 12,
+	"wal_keep_segments":                 13,
+	"operator_precedence_warning":       14,
+	"vacuum_cleanup_index_scale_factor": 14,
+	"stats_temp_directory":              15,
+	"force_parallel_mode":               16,
+	"promote_trigger_file":              16,
+	"vacuum_defer_cleanup_age":          16,
+	"db_user_namespace":                 17,
+	"old_snapshot_threshold":            17,
+	"trace_recovery_messages":           17,
+}
+
+// UnsupportedRegTypes are the reg* types pg_upgrade refuses in user tables,
+// because their values are OIDs that change across the upgrade.
+var UnsupportedRegTypes = []string{
+	"regcollation", "regconfig", "regdictionary", "regnamespace",
+	"regoper", "regoperator", "regproc", "regprocedure",
+}
+
+// Check runs the readiness checks of an upgrade to major version to with
+// method against the instance db is connected to. Per-database checks
+// (extensions, reg* columns) visit every connectable database through open;
+// with a nil open only db's own database is checked.
+func Check(ctx context.Context, db DB, open Opener, to int, method string) (*Report, error) {
+	if method != MethodLink && method != MethodLogical {
+		return nil, fmt.Errorf("unknown upgrade method %q (want %s or %s)", method, MethodLink, MethodLogical)
+	}
+	version, err := db.ServerVersionNum(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("read server version: %w", err)
+	}
+	r := &Report{FromVersion: version, FromMajor: version / 10000, ToMajor: to, Method: method}
+
+	r.add(checkVersion(r.FromMajor, to))
+
+	settings, err := db.Settings(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("read pg_settings: %w", err)
+	}
+	xacts, err := db.PreparedXacts(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("read pg_prepared_xacts: %w", err)
+	}
+
+	if r.Databases, err = db.Databases(ctx); err != nil {
+		return nil, fmt.Errorf("list databases: %w", err)
+	}
+	var exts, cols []string
+	visit := func(name string, d DB) error {
+		e, err := d.Extensions(ctx)
+		if err != nil {
+			return fmt.Errorf("list extensions in %s: %w", name, err)
+		}
+		for _, x := range e {
+			if v, ok := removedExtensions[x.Name]; ok && v > r.FromMajor && v <= to {
+				exts = append(exts, fmt.Sprintf("%s: %s %s (removed in %d)", name, x.Name, x.Version, v))
+			}
+		}
+		c, err := d.RegColumns(ctx)
+		if err != nil {
+			return fmt.Errorf("list reg* columns in %s: %w", name, err)
+		}
+		for _, x := range c {
+			cols = append(cols, fmt.Sprintf("%s: %s.%s.%s (%s)", name, x.Schema, x.Table, x.Column, x.Type))
+		}
+		return nil
+	}
+	if open == nil {
+		if err := visit("current database", db); err != nil {
+			return nil, err
+		}
+	} else {
+		for _, name := range r.Databases {
+			d, err := open(ctx, name)
+			if err != nil {
+				return nil, fmt.Errorf("connect to database %s: %w", name, err)
+			}
+			err = visit(name, d)
+			d.Close(ctx) //nolint:errcheck
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	r.add(itemsCheck(CheckExtensions, exts,
+		"no installed extension is missing from the target version",
+		"extensions not shipped with the target version must be dropped first"))
+	r.add(itemsCheck(CheckRegColumns, cols,
+		"no user column uses an OID-based reg* type",
+		"pg_upgrade cannot migrate columns of these reg* types; convert them to text first"))
+	r.add(checkRemovedGUCs(settings, r.FromMajor, to))
+
+	var gids []string
+	for _, x := range xacts {
+		gids = append(gids, fmt.Sprintf("%s (%s on %s)", x.GID, x.Owner, x.Database))
+	}
+	r.add(itemsCheck(CheckPreparedXacts, gids,
+		"no prepared transactions",
+		"commit or roll back prepared transactions before upgrading"))
+
+	if method == MethodLogical {
+		r.add(checkWalLevel(settings))
+	}
+
+	r.Ready = true
+	for _, c := range r.Checks {
+		if c.Status == StatusFail {
+			r.Ready = false
+		}
+	}
+	return r, nil
+}
+
+func (r *Report) add(c CheckResult) {
+	r.Checks = append(r.Checks, c)
+}
+
+func checkVersion(from, to int) CheckResult {
+	c := CheckResult{Name: CheckVersion, Status: StatusOK,
+		Detail: fmt.Sprintf("upgrading from %d to %d", from, to)}
+	if to <= from {
+		c.Status = StatusFail
+		c.Detail = fmt.Sprintf("target version %d is not newer than the running %d", to, from)
+	}
+	return c
+}
+
+// checkRemovedGUCs reports parameters removed by a version in (from, to]
+// that are set explicitly; parameters left at their default are harmless.
+func checkRemovedGUCs(settings []Setting, from, to int) CheckResult {
+	var items []string
+	for _, s := range settings {
+		v, ok := removedGUCs[s.Name]
+		if !ok || v <= from || v > to {
+			continue
+		}
+		if s.Source == "default" || s.Source == "override" {
+			continue
+		}
+		items = append(items, fmt.Sprintf("%s = %s (set in %s, removed in %d)", s.Name, s.Setting, s.Source, v))
+	}
+	sort.Strings(items)
+	return itemsCheck(CheckRemovedGUCs, items,
+		"no removed parameter is set",
+		"remove these parameters from the configuration before upgrading")
+}
+
+func checkWalLevel(settings []Setting) CheckResult {
+	for _, s := range settings {
+		if s.Name == "wal_level" {
+			if s.Setting == "logical" {
+				return CheckResult{Name: CheckWalLevel, Status: StatusOK, Detail: "wal_level is logical"}
+			}
+			return CheckResult{Name: CheckWalLevel, Status: StatusFail,
+				Detail: fmt.Sprintf("wal_level is %s; the logical method needs wal_level=logical (requires a restart)", s.Setting)}
+		}
+	}
+	return CheckResult{Name: CheckWalLevel, Status: StatusWarn, Detail: "wal_level not reported by pg_settings"}
+}
+
+// itemsCheck fails when items is non-empty.
+func itemsCheck(name string, items []string, okDetail, failDetail string) CheckResult {
+	if len(items) == 0 {
+		return CheckResult{Name: name, Status: StatusOK, Detail: okDetail}
+	}
+	return CheckResult{Name: name, Status: StatusFail, Detail: failDetail, Items: items}
+}
@@ -11,6 +11,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 )
 
@@ -392,16 +393,37 @@ func mockPatroniFull(t *testing.T) *httptest.Server {
 	t.Helper()
 	mux := http.NewServeMux()
 
+	// After a switchover the candidate leads on timeline 2 and the others,
+	// the old primary included, follow it. Like the initial view, replicas
+	// report "running", as Patroni before 3.0 does.
+	var mu sync.Mutex
+	leader := ""
+
 	mux.HandleFunc("/cluster", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
+		if leader != "" {
+			members := []map[string]interface{}{}
+			for _, name := range []string{"pg-primary", "pg-replica-1", "pg-replica-2"} {
+				m := map[string]interface{}{"name": name, "role": "replica", "state": "running",
+					"host": name, "port": 5432, "lag": 0, "timeline": 2}
+				if name == leader {
+					m["role"], m["state"] = "leader", "running"
+				}
+				members = append(members, m)
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"scope": "e2e-cluster", "members": members}) //nolint:errcheck
+			return
+		}
 		//nolint:errcheck
 		w.Write([]byte(`{
 			"scope": "e2e-cluster",
 			"members": [
-				{"name":"pg-primary","role":"leader","state":"running","host":"pg-primary","port":5432,"lag":0},
-				{"name":"pg-replica-1","role":"replica","state":"running","host":"pg-replica-1","port":5432,"lag":512},
-				{"name":"pg-replica-2","role":"replica","state":"running","host":"pg-replica-2","port":5432,"lag":1024}
+				{"name":"pg-primary","role":"leader","state":"running","host":"pg-primary","port":5432,"lag":0,"timeline":1},
+				{"name":"pg-replica-1","role":"replica","state":"running","host":"pg-replica-1","port":5432,"lag":512,"timeline":1},
+				{"name":"pg-replica-2","role":"replica","state":"running","host":"pg-replica-2","port":5432,"lag":1024,"timeline":1}
 			]
 		}`))
 	})
@@ -411,6 +433,13 @@ func mockPatroniFull(t *testing.T) *httptest.Server {
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
+		var body struct {
+			Candidate string `json:"candidate"`
+		}
+		json.NewDecoder(r.Body).Decode(&body) //nolint:errcheck
+		mu.Lock()
+		leader = body.Candidate
+		mu.Unlock()
 		w.WriteHeader(http.StatusOK)
 	})
 
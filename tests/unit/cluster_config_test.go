@@ -0,0 +1,115 @@
+package unit_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// dcsPatroni is a fake Patroni API serving GET and PATCH /config.
+type dcsPatroni struct {
+	mu      sync.Mutex
+	config  map[string]interface{}
+	patches []map[string]interface{}
+	srv     *httptest.Server
+}
+
+func newDCSPatroni(t *testing.T) *dcsPatroni {
+	t.Helper()
+	d := &dcsPatroni{config: liveDCSConfig()}
+	d.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if r.URL.Path != "/config" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method == http.MethodPatch {
+			var patch map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&patch)
+			d.patches = append(d.patches, patch)
+		}
+		json.NewEncoder(w).Encode(d.config)
+	}))
+	t.Cleanup(d.srv.Close)
+	return d
+}
+
+func (d *dcsPatroni) sent() []map[string]interface{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.patches
+}
+
+func TestClusterConfigPatch_RequiresChange(t *testing.T) {
+	d := newDCSPatroni(t)
+	regPath := filepath.Join(t.TempDir(), "clusters.json")
+	err := executeClusterCmdWithRegistry(t, regPath, "cluster", "config", "patch", "--patroni-url", d.srv.URL)
+	if err == nil || !strings.Contains(err.Error(), "--set") {
+		t.Errorf("expected --set or --file to be required, got %v", err)
+	}
+}
+
+func TestClusterConfigPatch_SendsValidPatch(t *testing.T) {
+	d := newDCSPatroni(t)
+	regPath := filepath.Join(t.TempDir(), "clusters.json")
+
+	if err := executeClusterCmdWithRegistry(t, regPath, "cluster", "config", "patch", "--patroni-url", d.srv.URL,
+		"--set", "loop_wait=5", "--set", "maximum_lag_on_failover=null", "--dry-run"); err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+	if len(d.sent()) != 0 {
+		t.Fatal("expected --dry-run not to patch")
+	}
+
+	if err := executeClusterCmdWithRegistry(t, regPath, "cluster", "config", "patch", "--patroni-url", d.srv.URL,
+		"--set", "loop_wait=5", "--set", "maximum_lag_on_failover=null"); err != nil {
+		t.Fatalf("patch: %v", err)
+	}
+	sent := d.sent()
+	if len(sent) != 1 || sent[0]["loop_wait"] != float64(5) {
+		t.Fatalf("expected loop_wait patched, got %v", sent)
+	}
+	if v, ok := sent[0]["maximum_lag_on_failover"]; !ok || v != nil {
+		t.Errorf("expected maximum_lag_on_failover removed, got %v", sent[0])
+	}
+}
+
+func TestClusterConfigPatch_RejectsInvalidTiming(t *testing.T) {
+	d := newDCSPatroni(t)
+	regPath := filepath.Join(t.TempDir(), "clusters.json")
+
+	err := executeClusterCmdWithRegistry(t, regPath, "cluster", "config", "patch", "--patroni-url", d.srv.URL,
+		"--set", "ttl=15")
+	if err == nil || !strings.Contains(err.Error(), "must not exceed ttl") {
+		t.Errorf("expected the ttl rule to reject the patch, got %v", err)
+	}
+	if len(d.sent()) != 0 {
+		t.Error("expected nothing sent for an invalid patch")
+	}
+}
+
+func TestClusterConfigEdit_PatchesEditorChanges(t *testing.T) {
+	d := newDCSPatroni(t)
+	regPath := filepath.Join(t.TempDir(), "clusters.json")
+	editor := filepath.Join(t.TempDir(), "editor.sh")
+	script := "#!/bin/sh\nsed -i 's/^loop_wait: 10$/loop_wait: 5/; /^maximum_lag_on_failover:/d' \"$1\"\n"
+	if err := os.WriteFile(editor, []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("VISUAL", "")
+	t.Setenv("EDITOR", editor)
+
+	if err := executeClusterCmdWithRegistry(t, regPath, "cluster", "config", "edit", "--patroni-url", d.srv.URL); err != nil {
+		t.Fatalf("edit: %v", err)
+	}
+	sent := d.sent()
+	if len(sent) != 1 || len(sent[0]) != 2 || sent[0]["loop_wait"] != float64(5) {
+		t.Fatalf("expected only the edited keys patched, got %v", sent)
+	}
+}
@@ -0,0 +1,259 @@
+package unit_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/luckyjian/pgdba/internal/cli"
+	"github.com/luckyjian/pgdba/internal/cluster"
+)
+
+// pausablePatroni is a fake Patroni API that tracks maintenance mode.
+type pausablePatroni struct {
+	mu     sync.Mutex
+	paused bool
+	srv    *httptest.Server
+}
+
+func newPausablePatroni(t *testing.T, paused bool) *pausablePatroni {
+	t.Helper()
+	p := &pausablePatroni{paused: paused}
+	p.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		switch {
+		case r.URL.Path == "/cluster":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"members": []map[string]interface{}{
+					{"name": "pg-0", "role": "leader", "state": "running"},
+					{"name": "pg-1", "role": "replica", "state": "streaming"},
+				},
+				"pause": p.paused,
+			})
+		case r.URL.Path == "/config" && r.Method == http.MethodPatch:
+			var body map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&body)
+			if v, ok := body["pause"].(bool); ok {
+				p.paused = v
+			}
+			w.Write([]byte("{}"))
+		case r.URL.Path == "/switchover" && r.Method == http.MethodDelete:
+			w.Write([]byte("{}"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(p.srv.Close)
+	return p
+}
+
+func (p *pausablePatroni) isPaused() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.paused
+}
+
+// pauseRegistry registers cluster "prod" behind patroniURL.
+func pauseRegistry(t *testing.T, patroniURL string) (string, *cluster.Registry) {
+	t.Helper()
+	regPath := filepath.Join(t.TempDir(), "clusters.json")
+	reg := cluster.NewRegistry(regPath)
+	if err := reg.Add(cluster.Entry{Name: "prod", PatroniURL: patroniURL, Provider: "docker"}); err != nil {
+		t.Fatalf("add entry: %v", err)
+	}
+	return regPath, reg
+}
+
+func TestPauseRecord_RoundTrip(t *testing.T) {
+	reg := cluster.NewRegistry(filepath.Join(t.TempDir(), "clusters.json"))
+	if rec, err := reg.GetPause("prod"); err != nil || rec != nil {
+		t.Fatalf("expected no record, got %+v (%v)", rec, err)
+	}
+	expires := time.Now().Add(-time.Minute)
+	if err := reg.SetPause(cluster.PauseRecord{Cluster: "prod", By: "ops", Reason: "disk swap", ExpiresAt: &expires}); err != nil {
+		t.Fatalf("SetPause: %v", err)
+	}
+	rec, err := reg.GetPause("prod")
+	if err != nil || rec == nil || rec.By != "ops" || !rec.Expired(time.Now()) {
+		t.Fatalf("expected an expired record by ops, got %+v (%v)", rec, err)
+	}
+	if err := reg.ClearPause("prod"); err != nil {
+		t.Fatalf("ClearPause: %v", err)
+	}
+	if err := reg.ClearPause("prod"); err != nil {
+		t.Errorf("expected clearing a missing record to succeed, got %v", err)
+	}
+}
+
+func TestClusterPause_RequiresReason(t *testing.T) {
+	regPath, _ := pauseRegistry(t, "http://127.0.0.1:1")
+	if err := executeClusterCmdWithRegistry(t, regPath, "cluster", "pause", "--name", "prod"); err == nil ||
+		!strings.Contains(err.Error(), "--reason") {
+		t.Errorf("expected --reason to be required, got %v", err)
+	}
+}
+
+func TestClusterPause_RecordsAndResumes(t *testing.T) {
+	pp := newPausablePatroni(t, false)
+	regPath, reg := pauseRegistry(t, pp.srv.URL)
+
+	if err := executeClusterCmdWithRegistry(t, regPath, "cluster", "pause", "--name", "prod",
+		"--reason", "storage maintenance", "--by", "alice", "--deadline", "1h"); err != nil {
+		t.Fatalf("cluster pause: %v", err)
+	}
+	if !pp.isPaused() {
+		t.Error("expected Patroni to be paused")
+	}
+	rec, err := reg.GetPause("prod")
+	if err != nil || rec == nil || rec.By != "alice" || rec.Reason != "storage maintenance" || rec.ExpiresAt == nil {
+		t.Fatalf("expected the pause recorded with its deadline, got %+v (%v)", rec, err)
+	}
+
+	// The deadline has not passed, so --if-expired leaves the pause alone.
+	if err := executeClusterCmdWithRegistry(t, regPath, "cluster", "resume", "--name", "prod", "--if-expired"); err != nil {
+		t.Fatalf("cluster resume --if-expired: %v", err)
+	}
+	if !pp.isPaused() {
+		t.Error("expected an unexpired pause to be kept")
+	}
+
+	if err := executeClusterCmdWithRegistry(t, regPath, "cluster", "resume", "--name", "prod"); err != nil {
+		t.Fatalf("cluster resume: %v", err)
+	}
+	if pp.isPaused() {
+		t.Error("expected Patroni to be resumed")
+	}
+	if rec, _ := reg.GetPause("prod"); rec != nil {
+		t.Errorf("expected the pause record cleared, got %+v", rec)
+	}
+}
+
+func TestClusterPause_MutatingCommandRefused(t *testing.T) {
+	pp := newPausablePatroni(t, true)
+	regPath, reg := pauseRegistry(t, pp.srv.URL)
+	if err := reg.SetPause(cluster.PauseRecord{Cluster: "prod", By: "alice", Reason: "storage maintenance"}); err != nil {
+		t.Fatalf("SetPause: %v", err)
+	}
+
+	err := executeClusterCmdWithRegistry(t, regPath, "replica", "promote", "--name", "prod", "--candidate", "pg-1")
+	if err == nil || !strings.Contains(err.Error(), "paused") || !strings.Contains(err.Error(), "alice") {
+		t.Errorf("expected promote to be refused with the pause record, got %v", err)
+	}
+	err = executeClusterCmdWithRegistry(t, regPath, "failover", "trigger", "--name", "prod", "--candidate", "pg-1")
+	if err == nil || !strings.Contains(err.Error(), "cluster resume") {
+		t.Errorf("expected failover trigger to be refused, got %v", err)
+	}
+	err = executeClusterCmdWithRegistry(t, regPath, "cluster", "config", "patch", "--name", "prod", "--set", "ttl=40")
+	if err == nil || !strings.Contains(err.Error(), "paused") {
+		t.Errorf("expected config patch to be refused, got %v", err)
+	}
+	err = executeClusterCmdWithRegistry(t, regPath, "cluster", "config", "edit", "--name", "prod")
+	if err == nil || !strings.Contains(err.Error(), "paused") {
+		t.Errorf("expected config edit to be refused, got %v", err)
+	}
+	err = executeClusterCmdWithRegistry(t, regPath, "replica", "sync", "enable", "--name", "prod")
+	if err == nil || !strings.Contains(err.Error(), "paused") {
+		t.Errorf("expected replica sync enable to be refused, got %v", err)
+	}
+	err = executeClusterCmdWithRegistry(t, regPath, "replica", "reinit", "--name", "prod", "--member", "pg-1")
+	if err == nil || !strings.Contains(err.Error(), "paused") {
+		t.Errorf("expected replica reinit to be refused, got %v", err)
+	}
+}
+
+func TestClusterPause_ExpiredPauseLiftedOnNextCommand(t *testing.T) {
+	pp := newPausablePatroni(t, true)
+	regPath, reg := pauseRegistry(t, pp.srv.URL)
+	expires := time.Now().Add(-time.Second)
+	if err := reg.SetPause(cluster.PauseRecord{Cluster: "prod", By: "alice", Reason: "x", ExpiresAt: &expires}); err != nil {
+		t.Fatalf("SetPause: %v", err)
+	}
+
+	if err := executeClusterCmdWithRegistry(t, regPath, "failover", "cancel", "--name", "prod"); err != nil {
+		t.Fatalf("failover cancel: %v", err)
+	}
+	if pp.isPaused() {
+		t.Error("expected the expired pause to be lifted")
+	}
+	if rec, _ := reg.GetPause("prod"); rec != nil {
+		t.Errorf("expected the expired record cleared, got %+v", rec)
+	}
+}
+
+func TestClusterPause_StatusReportsExpiredPause(t *testing.T) {
+	pp := newPausablePatroni(t, true)
+	regPath, reg := pauseRegistry(t, pp.srv.URL)
+	expires := time.Now().Add(-time.Second)
+	if err := reg.SetPause(cluster.PauseRecord{Cluster: "prod", By: "alice", Reason: "x", ExpiresAt: &expires}); err != nil {
+		t.Fatalf("SetPause: %v", err)
+	}
+
+	for _, args := range [][]string{
+		{"cluster", "status", "--name", "prod"},
+		{"failover", "status", "--name", "prod"},
+	} {
+		cmd := cli.NewRootCmdWithRegistry(regPath)
+		stdout := new(strings.Builder)
+		cmd.SetOut(stdout)
+		cmd.SetErr(new(strings.Builder))
+		cmd.SetArgs(args)
+		if err := cmd.Execute(); err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+		var resp struct {
+			Data struct {
+				Pause *cli.PauseStatus `json:"pause"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal([]byte(stdout.String()), &resp); err != nil {
+			t.Fatalf("%v: output not valid JSON: %v\n%s", args, err, stdout)
+		}
+		if p := resp.Data.Pause; p == nil || !p.Expired || p.PauseRecord == nil || p.By != "alice" {
+			t.Errorf("%v: expected the expired pause reported, got %s", args, stdout)
+		}
+	}
+	if !pp.isPaused() {
+		t.Error("expected status commands to leave Patroni paused")
+	}
+	if rec, _ := reg.GetPause("prod"); rec == nil {
+		t.Error("expected status commands to keep the pause record")
+	}
+}
+
+func TestClusterPause_WarnOnlyCommands(t *testing.T) {
+	pp := newPausablePatroni(t, true)
+	regPath, _ := pauseRegistry(t, pp.srv.URL)
+
+	for _, tc := range []struct {
+		args    []string
+		succeed bool
+	}{
+		{[]string{"failover", "cancel", "--name", "prod"}, true},
+		// The fake has no PostgreSQL behind it; the warning comes first.
+		{[]string{"replica", "slots", "drop", "--name", "prod", "--slot", "old", "--confirm"}, false},
+		{[]string{"replica", "slots", "advance", "--name", "prod", "--slot", "old"}, false},
+		{[]string{"logical", "pub", "create", "--name", "prod", "--pub", "p"}, false},
+		{[]string{"logical", "pub", "drop", "--name", "prod", "--pub", "p"}, false},
+		{[]string{"logical", "sub", "create", "--name", "prod", "--target", "prod", "--sub", "s", "--pub", "p"}, false},
+		{[]string{"logical", "sub", "drop", "--target", "prod", "--sub", "s"}, false},
+		{[]string{"logical", "sub", "refresh", "--target", "prod", "--sub", "s"}, false},
+	} {
+		cmd := cli.NewRootCmdWithRegistry(regPath)
+		stdout, stderr := new(strings.Builder), new(strings.Builder)
+		cmd.SetOut(stdout)
+		cmd.SetErr(stderr)
+		cmd.SetArgs(tc.args)
+		if err := cmd.Execute(); tc.succeed && err != nil {
+			t.Errorf("%v: %v\n%s", tc.args, err, stdout)
+		}
+		if !strings.Contains(stderr.String(), `"warning"`) || !strings.Contains(stderr.String(), "paused") {
+			t.Errorf("%v: expected a pause warning on stderr, got %q", tc.args, stderr)
+		}
+	}
+}
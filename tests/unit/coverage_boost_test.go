@@ -101,73 +101,86 @@ func TestConnect_FailsWithInvalidDSN(t *testing.T) {
 
 // --- provider package additional coverage ---
 
-func TestDockerProvider_ListNodes_ReturnsError(t *testing.T) {
-	p, _ := provider.New("docker", nil)
+// unreachableDocker returns a docker provider pointed at a socket that does
+// not exist, so every Engine API call fails fast.
+func unreachableDocker(t *testing.T) provider.Provider {
+	t.Helper()
+	p, err := provider.New("docker", map[string]string{
+		"host": "unix://" + t.TempDir() + "/missing.sock",
+	})
+	if err != nil {
+		t.Fatalf("provider.New: %v", err)
+	}
+	return p
+}
+
+func TestDockerProvider_ListNodes_DaemonUnreachable(t *testing.T) {
+	p := unreachableDocker(t)
 	_, err := p.ListNodes(context.Background())
 	if err == nil {
-		t.Error("expected error from unimplemented ListNodes")
+		t.Error("expected error from ListNodes when the daemon is unreachable")
 	}
 }
 
-func TestDockerProvider_CreateNode_ValidName_ReturnsNotImplemented(t *testing.T) {
-	p, _ := provider.New("docker", nil)
+func TestDockerProvider_CreateNode_DaemonUnreachable(t *testing.T) {
+	p := unreachableDocker(t)
 	_, err := p.CreateNode(context.Background(), provider.NodeConfig{Name: "pg-primary"})
 	if err == nil {
-		t.Error("expected not-implemented error for CreateNode with valid name")
+		t.Fatal("expected error for CreateNode when the daemon is unreachable")
 	}
-	if !strings.Contains(err.Error(), "not implemented") {
-		t.Errorf("expected 'not implemented' in error, got: %v", err)
+	if !strings.Contains(err.Error(), "pgdba-net") {
+		t.Errorf("expected error to mention the network, got: %v", err)
 	}
 }
 
-func TestDockerProvider_DestroyNode_ValidID_ReturnsNotImplemented(t *testing.T) {
-	p, _ := provider.New("docker", nil)
+func TestDockerProvider_DestroyNode_DaemonUnreachable(t *testing.T) {
+	p := unreachableDocker(t)
 	err := p.DestroyNode(context.Background(), "container-abc123")
 	if err == nil {
-		t.Error("expected not-implemented error for DestroyNode with valid id")
+		t.Fatal("expected error for DestroyNode when the daemon is unreachable")
 	}
-	if !strings.Contains(err.Error(), "not implemented") {
-		t.Errorf("expected 'not implemented' in error, got: %v", err)
+	if !strings.Contains(err.Error(), "container-abc123") {
+		t.Errorf("expected error to mention the container, got: %v", err)
 	}
 }
 
-func TestDockerProvider_ExecOnNode_ValidArgs_ReturnsNotImplemented(t *testing.T) {
-	p, _ := provider.New("docker", nil)
+func TestDockerProvider_ExecOnNode_DaemonUnreachable(t *testing.T) {
+	p := unreachableDocker(t)
 	_, err := p.ExecOnNode(context.Background(), "container-abc123", []string{"psql", "-c", "SELECT 1"})
 	if err == nil {
-		t.Error("expected not-implemented error for ExecOnNode with valid args")
+		t.Fatal("expected error for ExecOnNode when the daemon is unreachable")
 	}
-	if !strings.Contains(err.Error(), "not implemented") {
-		t.Errorf("expected 'not implemented' in error, got: %v", err)
+	if !strings.Contains(err.Error(), "container-abc123") {
+		t.Errorf("expected error to mention the container, got: %v", err)
 	}
 }
 
-func TestDockerProvider_GetNodeStatus_ValidID_ReturnsNotImplemented(t *testing.T) {
-	p, _ := provider.New("docker", nil)
+func TestDockerProvider_GetNodeStatus_DaemonUnreachable(t *testing.T) {
+	p := unreachableDocker(t)
 	_, err := p.GetNodeStatus(context.Background(), "container-abc123")
 	if err == nil {
-		t.Error("expected not-implemented error for GetNodeStatus with valid id")
+		t.Fatal("expected error for GetNodeStatus when the daemon is unreachable")
 	}
-	if !strings.Contains(err.Error(), "not implemented") {
-		t.Errorf("expected 'not implemented' in error, got: %v", err)
+	if !strings.Contains(err.Error(), "container-abc123") {
+		t.Errorf("expected error to mention the container, got: %v", err)
 	}
 }
 
-func TestDockerProvider_PartitionNode_ValidID_ReturnsNotImplemented(t *testing.T) {
-	p, _ := provider.New("docker", nil)
+func TestDockerProvider_PartitionNode_DaemonUnreachable(t *testing.T) {
+	p := unreachableDocker(t)
 	err := p.PartitionNode(context.Background(), "container-abc123", true)
 	if err == nil {
-		t.Error("expected not-implemented error for PartitionNode with valid id")
+		t.Fatal("expected error for PartitionNode when the daemon is unreachable")
 	}
-	if !strings.Contains(err.Error(), "not implemented") {
-		t.Errorf("expected 'not implemented' in error, got: %v", err)
+	if !strings.Contains(err.Error(), "container-abc123") {
+		t.Errorf("expected error to mention the container, got: %v", err)
 	}
 }
 
 func TestDockerProvider_PartitionNode_Deactivate(t *testing.T) {
-	p, _ := provider.New("docker", nil)
+	p := unreachableDocker(t)
 	err := p.PartitionNode(context.Background(), "container-abc123", false)
 	if err == nil {
-		t.Error("expected not-implemented error for PartitionNode(isolate=false)")
+		t.Error("expected error for PartitionNode(isolate=false) when the daemon is unreachable")
 	}
 }
@@ -1,6 +1,7 @@
 package unit_test
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
@@ -12,6 +13,7 @@ import (
 
 	"github.com/luckyjian/pgdba/internal/cli"
 	"github.com/luckyjian/pgdba/internal/cluster"
+	"github.com/luckyjian/pgdba/internal/provider"
 	"github.com/spf13/cobra"
 )
 
@@ -22,23 +24,94 @@ func newRootWithReg(regPath string) *cobra.Command {
 
 // --- Cluster CLI: happy-path destroy of a managed cluster ---
 
+// writeDockerConfig writes a pgdba config file pointing the docker provider
+// at host and returns its path.
+func writeDockerConfig(t *testing.T, host string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := "provider:\n  type: docker\n  options:\n    host: " + host + "\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
 func TestClusterDestroy_ManagedCluster_Succeeds(t *testing.T) {
+	engine, srv := newFakeDockerEngine(t)
+	p, err := provider.New("docker", map[string]string{"host": srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for node, owner := range map[string]string{
+		"managed-cluster-etcd-0": "managed-cluster",
+		"managed-cluster-pg-0":   "managed-cluster",
+		"other-pg-0":             "other",
+	} {
+		if _, err := p.CreateNode(context.Background(), provider.NodeConfig{
+			Name: node, DataDir: "/data",
+			Labels: map[string]string{provider.LabelCluster: owner},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
 	dir := t.TempDir()
 	regPath := filepath.Join(dir, "clusters.json")
 	reg := cluster.NewRegistry(regPath)
 	if err := reg.Add(cluster.Entry{
 		Name:      "managed-cluster",
+		Provider:  "docker",
 		Source:    cluster.SourceManaged,
 		CreatedAt: time.Now(),
 	}); err != nil {
 		t.Fatalf("Add failed: %v", err)
 	}
 
-	err := executeClusterCmdWithRegistry(t, regPath,
-		"cluster", "destroy", "--name", "managed-cluster", "--confirm")
+	err = executeClusterCmdWithRegistry(t, regPath,
+		"--config", writeDockerConfig(t, srv.URL),
+		"cluster", "destroy", "--name", "managed-cluster", "--confirm", "--purge-data")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
+	if _, err := reg.Get("managed-cluster"); err == nil {
+		t.Error("expected registry entry to be removed")
+	}
+	if engine.lookup("managed-cluster-pg-0") != nil || engine.lookup("managed-cluster-etcd-0") != nil {
+		t.Error("expected cluster containers to be removed")
+	}
+	if engine.lookup("other-pg-0") == nil {
+		t.Error("containers of other clusters must be left alone")
+	}
+	if engine.volumes["managed-cluster-pg-0-data"] || !engine.volumes["other-pg-0-data"] {
+		t.Errorf("expected only the destroyed cluster's volumes to be purged, got %v", engine.volumes)
+	}
+}
+
+func TestClusterDestroy_ProviderUnreachable_KeepsEntry(t *testing.T) {
+	regPath := filepath.Join(t.TempDir(), "clusters.json")
+	reg := cluster.NewRegistry(regPath)
+	if err := reg.Add(cluster.Entry{Name: "managed-cluster", Provider: "docker", Source: cluster.SourceManaged}); err != nil {
+		t.Fatal(err)
+	}
+	cfgPath := writeDockerConfig(t, "unix://"+t.TempDir()+"/missing.sock")
+
+	err := executeClusterCmdWithRegistry(t, regPath,
+		"--config", cfgPath, "cluster", "destroy", "--name", "managed-cluster", "--confirm")
+	if err == nil || !strings.Contains(err.Error(), "--force") {
+		t.Fatalf("expected teardown failure suggesting --force, got %v", err)
+	}
+	if _, err := reg.Get("managed-cluster"); err != nil {
+		t.Error("registry entry must be kept when teardown fails")
+	}
+
+	err = executeClusterCmdWithRegistry(t, regPath,
+		"--config", cfgPath, "cluster", "destroy", "--name", "managed-cluster", "--confirm", "--force")
+	if err != nil {
+		t.Fatalf("forced destroy: %v", err)
+	}
+	if _, err := reg.Get("managed-cluster"); err == nil {
+		t.Error("forced destroy must remove the registry entry")
+	}
 }
 
 // executeClusterCmdWithRegistry runs the root command with a custom registry path.
@@ -282,19 +355,38 @@ func TestClusterStatus_WithReplica(t *testing.T) {
 	}
 }
 
-// --- Cluster init with both flags provided (reaches not-implemented error) ---
+// --- Cluster init with both flags provided (requires passwords from env) ---
 
-func TestClusterInit_ReachesNotImplemented(t *testing.T) {
+func TestClusterInit_RequiresPasswords(t *testing.T) {
+	t.Setenv("PGDBA_PG_PASSWORD", "")
+	t.Setenv("PGDBA_REPLICATION_PASSWORD", "")
 	_, err := executeClusterCmd(t,
 		"cluster", "init",
 		"--name", "new-cluster",
 		"--primary-host", "10.0.0.1",
 	)
 	if err == nil {
-		t.Error("expected 'not implemented' error from cluster init")
+		t.Fatal("expected error from cluster init without passwords")
+	}
+	if !strings.Contains(err.Error(), "PGDBA_PG_PASSWORD") {
+		t.Errorf("expected missing password error, got: %v", err)
+	}
+}
+
+func TestClusterInit_InvalidProviderConfig(t *testing.T) {
+	t.Setenv("PGDBA_PG_PASSWORD", "pw")
+	t.Setenv("PGDBA_REPLICATION_PASSWORD", "rpw")
+	_, err := executeClusterCmd(t,
+		"cluster", "init",
+		"--name", "new-cluster",
+		"--primary-host", "10.0.0.1",
+		"--provider", "baremetal",
+	)
+	if err == nil {
+		t.Fatal("expected error for baremetal provider without inventory")
 	}
-	if !strings.Contains(err.Error(), "not implemented") {
-		t.Errorf("expected 'not implemented' error, got: %v", err)
+	if !strings.Contains(err.Error(), "baremetal provider") {
+		t.Errorf("expected provider construction error, got: %v", err)
 	}
 }
 
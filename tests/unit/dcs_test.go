@@ -0,0 +1,402 @@
+package unit_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/luckyjian/pgdba/internal/cli"
+	"github.com/luckyjian/pgdba/internal/cluster"
+	"github.com/luckyjian/pgdba/internal/dcs"
+	"github.com/luckyjian/pgdba/internal/patroni"
+)
+
+// fakeEtcd is a minimal etcd v3 JSON gateway: prefix ranges over kvs,
+// lease lookups from leases (remaining TTL by id), and /health and
+// maintenance status for member id of a cluster led by leader.
+type fakeEtcd struct {
+	kvs    map[string]string
+	leases map[string]int64 // key -> lease id
+	ttls   map[int64][2]int64
+
+	id, leader uint64
+	unhealthy  bool
+	alarm      string
+}
+
+func (f *fakeEtcd) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req map[string]string
+	json.NewDecoder(r.Body).Decode(&req)
+	switch r.URL.Path {
+	case "/v3/kv/range":
+		key, _ := base64.StdEncoding.DecodeString(req["key"])
+		end, _ := base64.StdEncoding.DecodeString(req["range_end"])
+		var keys []string
+		for k := range f.kvs {
+			if k >= string(key) && k < string(end) {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		kvs := []map[string]string{}
+		for _, k := range keys {
+			kv := map[string]string{
+				"key":          base64.StdEncoding.EncodeToString([]byte(k)),
+				"value":        base64.StdEncoding.EncodeToString([]byte(f.kvs[k])),
+				"mod_revision": "7",
+			}
+			if id := f.leases[k]; id != 0 {
+				kv["lease"] = fmt.Sprint(id)
+			}
+			kvs = append(kvs, kv)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"kvs": kvs, "count": fmt.Sprint(len(kvs))})
+	case "/v3/lease/timetolive":
+		var id int64
+		fmt.Sscan(req["ID"], &id)
+		ttl, ok := f.ttls[id]
+		if !ok {
+			ttl = [2]int64{-1, 0}
+		}
+		json.NewEncoder(w).Encode(map[string]string{"ID": req["ID"],
+			"TTL": fmt.Sprint(ttl[0]), "grantedTTL": fmt.Sprint(ttl[1])})
+	case "/health":
+		if f.unhealthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"health":"false","reason":"RAFT NO LEADER"}`))
+			return
+		}
+		w.Write([]byte(`{"health":"true","reason":""}`))
+	case "/v3/maintenance/status":
+		fmt.Fprintf(w, `{"header":{"cluster_id":"14841639068965178418","member_id":"%d","revision":"120","raft_term":"3"},`+
+			`"version":"3.5.9","dbSize":"40960","leader":"%d","raftIndex":"5120","raftTerm":"3"}`, f.id, f.leader)
+	case "/v3/maintenance/alarm":
+		if f.alarm == "" {
+			w.Write([]byte(`{"header":{}}`))
+			return
+		}
+		fmt.Fprintf(w, `{"header":{},"alarms":[{"memberID":"%d","alarm":%q}]}`, f.id, f.alarm)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// etcdMembers starts n fake etcd members with ids 1..n led by member 1.
+func etcdMembers(t *testing.T, n int) ([]*fakeEtcd, []string) {
+	t.Helper()
+	var members []*fakeEtcd
+	var endpoints []string
+	for i := 1; i <= n; i++ {
+		f := &fakeEtcd{id: uint64(i), leader: 1}
+		srv := httptest.NewServer(f)
+		t.Cleanup(srv.Close)
+		members = append(members, f)
+		endpoints = append(endpoints, strings.TrimPrefix(srv.URL, "http://"))
+	}
+	return members, endpoints
+}
+
+// nodePatroni serves GET /patroni for a member reporting role.
+func nodePatroni(t *testing.T, role string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/patroni" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, `{"state": "running", "role": %q, "timeline": 4}`, role)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// dcsCluster seeds a fake gateway with scope "main" under /service/: the
+// leader key held by pg-0 with a live 30s lease, and one member key per
+// api URL.
+func dcsCluster(t *testing.T, apiURLs map[string]string) (*fakeEtcd, *httptest.Server) {
+	t.Helper()
+	f := &fakeEtcd{
+		kvs: map[string]string{
+			"/service/main/leader":     "pg-0",
+			"/service/main/config":     `{"ttl": 30, "loop_wait": 10}`,
+			"/service/main/history":    `[[3, 50331808, "no recovery target specified", "2024-05-02T08:30:00+00:00", "pg-0"]]`,
+			"/service/main/initialize": "7340958402713950213",
+			"/service/main/status":     `{"optime": 50331808}`,
+			"/service/other/leader":    "x-0",
+		},
+		leases: map[string]int64{"/service/main/leader": 42},
+		ttls:   map[int64][2]int64{42: {21, 30}},
+	}
+	for name, url := range apiURLs {
+		f.kvs["/service/main/members/"+name] = fmt.Sprintf(
+			`{"conn_url": "postgres://%s:5432/postgres", "api_url": "%s/patroni", "state": "running", "role": "replica", "timeline": 4}`,
+			name, url)
+	}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+func TestDCSDump_ClassifiesKeys(t *testing.T) {
+	_, srv := dcsCluster(t, map[string]string{"pg-0": "http://10.0.0.1:8008", "pg-1": "http://10.0.0.2:8008"})
+
+	snap, err := dcs.Dump(context.Background(), dcs.NewEtcdClient([]string{srv.URL}), "service", "main")
+	if err != nil {
+		t.Fatalf("Dump: %v", err)
+	}
+	if snap.Leader != "pg-0" || snap.LeaderLease == nil || snap.LeaderLease.TTL != 21 || snap.LeaderLease.GrantedTTL != 30 {
+		t.Errorf("unexpected leader %q lease %+v", snap.Leader, snap.LeaderLease)
+	}
+	if len(snap.Members) != 2 || snap.Members[1].Name != "pg-1" || snap.Members[1].APIURL != "http://10.0.0.2:8008/patroni" {
+		t.Errorf("unexpected members %+v", snap.Members)
+	}
+	if snap.Config["ttl"] != float64(30) || len(snap.History) != 1 || snap.History[0].NewLeader != "pg-0" {
+		t.Errorf("unexpected config %v or history %+v", snap.Config, snap.History)
+	}
+	if snap.Initialize != "7340958402713950213" || snap.Other["status"] == "" {
+		t.Errorf("unexpected initialize %q or other keys %v", snap.Initialize, snap.Other)
+	}
+	if snap.Namespace != "/service/" {
+		t.Errorf("expected namespace /service/, got %q", snap.Namespace)
+	}
+}
+
+func TestDCSClient_FailsOverToNextEndpoint(t *testing.T) {
+	_, srv := dcsCluster(t, nil)
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	kvs, err := dcs.NewEtcdClient([]string{down.URL, strings.TrimPrefix(srv.URL, "http://")}).
+		Prefix(context.Background(), "/service/main/")
+	if err != nil {
+		t.Fatalf("Prefix: %v", err)
+	}
+	if len(kvs) != 5 || kvs[0].Key != "/service/main/config" || kvs[0].ModRevision != 7 {
+		t.Errorf("unexpected keys %+v", kvs)
+	}
+}
+
+func TestDCSCheck_Healthy(t *testing.T) {
+	snap := &dcs.Snapshot{Leader: "pg-0", LeaderLease: &dcs.Lease{ID: 42, TTL: 21, GrantedTTL: 30},
+		Members: []dcs.Member{{Name: "pg-0", Timeline: 4}, {Name: "pg-1", Timeline: 4}}}
+	r := dcs.Check(snap, []dcs.MemberView{
+		{Name: "pg-0", Reachable: true, Role: "master", Timeline: 4},
+		{Name: "pg-1", Reachable: true, Role: "replica", Timeline: 4},
+	})
+	if !r.Healthy || len(r.Findings) != 0 || r.LeaseTTL != 21 || r.TTL != 30 {
+		t.Errorf("expected a healthy report, got %+v", r)
+	}
+}
+
+func findingCodes(r *dcs.CheckReport) string {
+	var codes []string
+	for _, f := range r.Findings {
+		codes = append(codes, f.Severity+":"+f.Code+":"+f.Member)
+	}
+	return strings.Join(codes, ",")
+}
+
+func TestDCSCheck_Findings(t *testing.T) {
+	lease := &dcs.Lease{ID: 42, TTL: 21, GrantedTTL: 30}
+	healthyViews := []dcs.MemberView{
+		{Name: "pg-0", Reachable: true, Role: "master"},
+		{Name: "pg-1", Reachable: true, Role: "replica"},
+	}
+	members := []dcs.Member{{Name: "pg-0"}, {Name: "pg-1"}}
+
+	tests := []struct {
+		name  string
+		snap  *dcs.Snapshot
+		views []dcs.MemberView
+		want  string
+	}{
+		{"split brain", &dcs.Snapshot{Leader: "pg-0", LeaderLease: lease, Members: members},
+			[]dcs.MemberView{healthyViews[0], {Name: "pg-1", Reachable: true, Role: "primary"}},
+			"fail:split_brain:,fail:split_brain:pg-1"},
+		{"expired lease", &dcs.Snapshot{Leader: "pg-0", LeaderLease: &dcs.Lease{ID: 42, TTL: -1}, Members: members},
+			healthyViews, "fail:stale_leader_lock:pg-0"},
+		{"lock without lease", &dcs.Snapshot{Leader: "pg-0", Members: members},
+			healthyViews, "fail:stale_leader_lock:pg-0"},
+		{"lock held by a replica", &dcs.Snapshot{Leader: "pg-1", LeaderLease: lease, Members: members},
+			healthyViews, "fail:stale_leader_lock:pg-1,fail:split_brain:pg-0"},
+		{"no leader", &dcs.Snapshot{Members: members},
+			[]dcs.MemberView{{Name: "pg-0", Reachable: true, Role: "replica"}, healthyViews[1]}, "fail:no_leader:"},
+		{"lease ttl mismatch", &dcs.Snapshot{Leader: "pg-0", LeaderLease: lease, Members: members,
+			Config: map[string]interface{}{"ttl": 60}}, healthyViews, "warn:lease_ttl_mismatch:pg-0"},
+		{"unreachable members", &dcs.Snapshot{Leader: "pg-0", LeaderLease: lease, Members: members},
+			[]dcs.MemberView{{Name: "pg-0", Error: "refused"}, {Name: "pg-1", Error: "refused"}},
+			"warn:leader_unreachable:pg-0,warn:member_unreachable:pg-1"},
+		{"pending failover", &dcs.Snapshot{Leader: "pg-0", LeaderLease: lease, Members: members,
+			Failover: map[string]interface{}{"member": "pg-1"}}, healthyViews, "warn:pending_failover:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := dcs.Check(tt.snap, tt.views)
+			if got := findingCodes(r); got != tt.want {
+				t.Errorf("expected findings %s, got %s", tt.want, got)
+			}
+			if r.Healthy != !strings.Contains(tt.want, "fail:") {
+				t.Errorf("unexpected healthy=%v", r.Healthy)
+			}
+		})
+	}
+}
+
+func TestClusterDCSCheck_DetectsSplitBrain(t *testing.T) {
+	pg0 := nodePatroni(t, "master")
+	pg1 := nodePatroni(t, "master")
+	_, srv := dcsCluster(t, map[string]string{"pg-0": pg0.URL, "pg-1": pg1.URL})
+
+	cmd := cli.NewRootCmdWithRegistry(filepath.Join(t.TempDir(), "clusters.json"))
+	buf := new(bytes.Buffer)
+	cmd.SetOut(buf)
+	cmd.SetErr(buf)
+	cmd.SetArgs([]string{"cluster", "dcs", "check", "--scope", "main", "--etcd-endpoints", srv.URL})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("cluster dcs check: %v\n%s", err, buf.String())
+	}
+
+	var resp struct {
+		Data dcs.CheckReport `json:"data"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &resp); err != nil {
+		t.Fatalf("decode output: %v\n%s", err, buf.String())
+	}
+	if resp.Data.Healthy || findingCodes(&resp.Data) != "fail:split_brain:,fail:split_brain:pg-1" {
+		t.Errorf("expected split-brain, got %+v", resp.Data)
+	}
+	if len(resp.Data.Members) != 2 || !resp.Data.Members[1].Reachable || resp.Data.Members[1].Timeline != 4 {
+		t.Errorf("unexpected member views %+v", resp.Data.Members)
+	}
+}
+
+func TestClusterDCSDump_UsesRegistryEndpointsAndNamespace(t *testing.T) {
+	f, srv := dcsCluster(t, nil)
+	for k, v := range f.kvs {
+		f.kvs["/db/"+strings.TrimPrefix(k, "/service/")] = v
+	}
+	f.kvs["/db/main/leader"] = "pg-9"
+
+	regPath := filepath.Join(t.TempDir(), "clusters.json")
+	reg := cluster.NewRegistry(regPath)
+	if err := reg.Add(cluster.Entry{Name: "main", PatroniURL: "http://127.0.0.1:1", Source: cluster.SourceManaged,
+		EtcdEndpoints: []string{strings.TrimPrefix(srv.URL, "http://")}}); err != nil {
+		t.Fatalf("add entry: %v", err)
+	}
+
+	cmd := cli.NewRootCmdWithRegistry(regPath)
+	buf := new(bytes.Buffer)
+	cmd.SetOut(buf)
+	cmd.SetErr(buf)
+	cmd.SetArgs([]string{"cluster", "dcs", "dump", "--name", "main"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("cluster dcs dump: %v\n%s", err, buf.String())
+	}
+	if !strings.Contains(buf.String(), `"leader": "pg-9"`) {
+		t.Errorf("expected the /db/ namespace of the managed cluster, got:\n%s", buf.String())
+	}
+}
+
+func TestClusterDCS_RequiresEndpoints(t *testing.T) {
+	err := executeClusterCmdWithRegistry(t, filepath.Join(t.TempDir(), "clusters.json"),
+		"cluster", "dcs", "dump", "--scope", "main")
+	if err == nil || !strings.Contains(err.Error(), "--etcd-endpoints is required") {
+		t.Errorf("expected missing endpoints error, got %v", err)
+	}
+}
+
+func TestEtcdHealth_ReportsMembers(t *testing.T) {
+	members, endpoints := etcdMembers(t, 3)
+	members[0].alarm = "NOSPACE"
+
+	q := dcs.NewEtcdClient(endpoints).Health(context.Background())
+	if !q.Quorum || q.HealthyMembers != 3 || q.Leader != "1" {
+		t.Fatalf("expected a healthy quorum led by 1, got %+v", q)
+	}
+	first := q.Endpoints[0]
+	if !first.IsLeader || first.MemberID != "1" || first.RaftIndex != 5120 || first.DBSize != 40960 || first.Version != "3.5.9" {
+		t.Errorf("unexpected first endpoint %+v", first)
+	}
+	if q.Endpoints[1].IsLeader {
+		t.Errorf("member 2 is not the leader: %+v", q.Endpoints[1])
+	}
+	if len(q.Alarms) != 1 || q.Alarms[0].Alarm != "NOSPACE" || q.Alarms[0].MemberID != "1" {
+		t.Errorf("expected the NOSPACE alarm of member 1, got %+v", q.Alarms)
+	}
+}
+
+func TestEtcdHealth_QuorumLost(t *testing.T) {
+	members, endpoints := etcdMembers(t, 3)
+	members[1].unhealthy = true
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	endpoints[2] = down.URL
+
+	q := dcs.NewEtcdClient(endpoints).Health(context.Background())
+	if q.Quorum || q.HealthyMembers != 1 {
+		t.Errorf("expected quorum lost with one healthy member, got %+v", q)
+	}
+	if !strings.Contains(q.Endpoints[1].Error, "RAFT NO LEADER") || q.Endpoints[2].Error == "" {
+		t.Errorf("expected per-endpoint errors, got %+v", q.Endpoints)
+	}
+}
+
+func TestClusterStatus_EtcdQuorum(t *testing.T) {
+	patroniSrv := httptest.NewServer(clusterHandler(new([]string),
+		patroni.Member{Name: "pg-0", Role: "leader", State: patroni.StateRunning}))
+	t.Cleanup(patroniSrv.Close)
+	members, endpoints := etcdMembers(t, 3)
+
+	regPath := filepath.Join(t.TempDir(), "clusters.json")
+	run := func(args ...string) clusterStatusOutput {
+		t.Helper()
+		cmd := cli.NewRootCmdWithRegistry(regPath)
+		buf := new(bytes.Buffer)
+		cmd.SetOut(buf)
+		cmd.SetErr(buf)
+		cmd.SetArgs(args)
+		if err := cmd.Execute(); err != nil {
+			t.Fatalf("%v: %v\n%s", args, err, buf.String())
+		}
+		var resp clusterStatusOutput
+		if err := json.Unmarshal(buf.Bytes(), &resp); err != nil {
+			t.Fatalf("decode output: %v\n%s", err, buf.String())
+		}
+		return resp
+	}
+
+	run("cluster", "connect", "--name", "prod", "--patroni-url", patroniSrv.URL, "--pg-host", "10.0.0.1",
+		"--etcd-endpoints", strings.Join(endpoints, ","))
+	entry, err := cluster.NewRegistry(regPath).Get("prod")
+	if err != nil || len(entry.EtcdEndpoints) != 3 {
+		t.Fatalf("expected etcd endpoints recorded, got %+v (%v)", entry, err)
+	}
+
+	resp := run("cluster", "status", "--name", "prod")
+	if !resp.Data.Healthy || resp.Data.Etcd == nil || !resp.Data.Etcd.Quorum {
+		t.Errorf("expected a healthy cluster with etcd quorum, got %+v", resp.Data)
+	}
+
+	members[0].unhealthy = true
+	members[1].unhealthy = true
+	resp = run("cluster", "status", "--name", "prod")
+	if resp.Data.Healthy || resp.Data.Etcd.Quorum || resp.Data.Etcd.HealthyMembers != 1 {
+		t.Errorf("expected unhealthy after losing etcd quorum, got %+v", resp.Data.Etcd)
+	}
+}
+
+// clusterStatusOutput is the JSON envelope of "cluster status".
+type clusterStatusOutput struct {
+	Data struct {
+		Healthy bool              `json:"healthy"`
+		Etcd    *dcs.QuorumStatus `json:"etcd"`
+	} `json:"data"`
+}
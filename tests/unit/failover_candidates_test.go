@@ -0,0 +1,223 @@
+package unit_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/luckyjian/pgdba/internal/cli"
+	"github.com/luckyjian/pgdba/internal/cluster"
+	"github.com/luckyjian/pgdba/internal/failover"
+	"github.com/luckyjian/pgdba/internal/patroni"
+)
+
+func tagged(m patroni.Member, tags map[string]interface{}) patroni.Member {
+	m.Tags = tags
+	return m
+}
+
+func rankNames(candidates []failover.Candidate) string {
+	names := make([]string, 0, len(candidates))
+	for _, c := range candidates {
+		names = append(names, fmt.Sprintf("%s:%d", c.Name, c.Rank))
+	}
+	return strings.Join(names, ",")
+}
+
+func TestRankCandidates_Order(t *testing.T) {
+	status := cs(
+		running("pg-0", "leader", 0),
+		running("pg-1", "replica", 0),
+		running("pg-2", "sync_standby", 300),
+		tagged(running("pg-3", "replica", 500), map[string]interface{}{"failover_priority": 2}),
+		running("pg-4", "replica", 0),
+	)
+	replay := map[string]float64{"pg-1": 4, "pg-4": 1.5}
+
+	ranked := failover.RankCandidates(status, replay, failover.DefaultLagPolicy())
+	if got := rankNames(ranked); got != "pg-2:1,pg-3:2,pg-4:3,pg-1:4" {
+		t.Fatalf("expected sync, then priority, then replay lag, got %s", got)
+	}
+	last := ranked[3]
+	if last.ReplayLagSeconds == nil || *last.ReplayLagSeconds != 4 ||
+		!strings.Contains(strings.Join(last.Reasons, "; "), "ranked after pg-4: more replay lag in seconds") {
+		t.Errorf("expected pg-1 to be explained by replay lag, got %+v", last)
+	}
+	if got := strings.Join(ranked[1].Reasons, "; "); !strings.Contains(got, "ranked after pg-2: not a synchronous standby") {
+		t.Errorf("expected pg-3 to be explained by sync status, got %s", got)
+	}
+	if got := strings.Join(ranked[2].Reasons, "; "); !strings.Contains(got, "lower failover_priority (1 < 2)") {
+		t.Errorf("expected pg-4 to be explained by priority, got %s", got)
+	}
+}
+
+func TestRankCandidates_Ineligible(t *testing.T) {
+	status := cs(
+		running("pg-0", "leader", 0),
+		tagged(running("pg-1", "replica", 0), map[string]interface{}{"nofailover": true}),
+		tagged(running("pg-2", "replica", 0), map[string]interface{}{"failover_priority": "0"}),
+		running("pg-3", "replica", 2048),
+		running("pg-4", "replica", 0),
+		stopped("pg-5", "replica"),
+		running("pg-6", "replica", 10),
+	)
+	policy := failover.LagPolicy{MaxLagBytes: 1024, MaxLagSeconds: 30}
+
+	ranked := failover.RankCandidates(status, map[string]float64{"pg-4": 120}, policy)
+	if got := rankNames(ranked); got != "pg-6:1,pg-1:0,pg-2:0,pg-3:0,pg-4:0,pg-5:0" {
+		t.Fatalf("expected only pg-6 eligible, got %s", got)
+	}
+	reasons := map[string]string{}
+	for _, c := range ranked {
+		reasons[c.Name] = strings.Join(c.Reasons, "; ")
+	}
+	for name, want := range map[string]string{
+		"pg-1": "nofailover", "pg-2": "nofailover", "pg-3": "lag 2048 bytes exceeds 1024",
+		"pg-4": "replay lag 120.0s exceeds 30s", "pg-5": "state is stopped",
+	} {
+		if !strings.Contains(reasons[name], want) {
+			t.Errorf("expected %s to be blocked by %q, got %q", name, want, reasons[name])
+		}
+	}
+
+	if err := failover.CheckCandidate(ranked, "pg-3"); err == nil || !strings.Contains(err.Error(), "exceeds 1024") {
+		t.Errorf("expected pg-3 to be refused, got %v", err)
+	}
+	if err := failover.CheckCandidate(ranked, "pg-6"); err != nil {
+		t.Errorf("expected pg-6 to be accepted, got %v", err)
+	}
+}
+
+func TestFindBestCandidate_SkipsNoFailover(t *testing.T) {
+	candidate, err := failover.FindBestCandidate(cs(
+		running("pg-primary", "leader", 0),
+		tagged(running("pg-replica-1", "replica", 0), map[string]interface{}{"nofailover": "true"}),
+		running("pg-replica-2", "replica", 500),
+	))
+	if err != nil || candidate != "pg-replica-2" {
+		t.Errorf("expected pg-replica-2, got %s (%v)", candidate, err)
+	}
+}
+
+func TestMember_DecodesTags(t *testing.T) {
+	var m patroni.Member
+	if err := json.Unmarshal([]byte(`{"name":"pg-1","role":"replica","lag":"unknown","tags":{"nofailover":true,"failover_priority":3}}`), &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Tags["nofailover"] != true || m.Tags["failover_priority"] != float64(3) {
+		t.Errorf("unexpected tags %v", m.Tags)
+	}
+}
+
+// replayPatroni serves /patroni for one member.
+func replayPatroni(t *testing.T, body string) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/patroni" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL + "/patroni"
+}
+
+func TestReplayLags(t *testing.T) {
+	behind := time.Now().Add(-10 * time.Second).UTC().Format("2006-01-02 15:04:05.999999-07:00")
+	status := cs(
+		patroni.Member{Name: "pg-0", Role: "leader", State: patroni.StateRunning,
+			APIURL: replayPatroni(t, `{"role":"master","xlog":{"location":1000}}`)},
+		patroni.Member{Name: "pg-1", Role: "replica", State: patroni.StateStreaming,
+			APIURL: replayPatroni(t, `{"role":"replica","xlog":{"replayed_location":1000,"replayed_timestamp":"2020-01-01 00:00:00+00:00"}}`)},
+		patroni.Member{Name: "pg-2", Role: "replica", State: patroni.StateStreaming,
+			APIURL: replayPatroni(t, `{"role":"replica","xlog":{"replayed_location":600,"replayed_timestamp":"`+behind+`"}}`)},
+		patroni.Member{Name: "pg-3", Role: "replica", State: patroni.StateStreaming},
+	)
+
+	lags := failover.ReplayLags(context.Background(), patroni.NewClient("http://127.0.0.1:1"), status)
+	if lag, ok := lags["pg-1"]; !ok || lag != 0 {
+		t.Errorf("expected a caught-up replica to have no lag, got %v", lags)
+	}
+	if lag := lags["pg-2"]; lag < 9 || lag > 60 {
+		t.Errorf("expected about 10s of replay lag for pg-2, got %v", lag)
+	}
+	if _, ok := lags["pg-3"]; ok {
+		t.Errorf("expected a member without api_url to be left out, got %v", lags)
+	}
+}
+
+func TestFailoverPolicy_SetShowReset(t *testing.T) {
+	regPath := filepath.Join(t.TempDir(), "clusters.json")
+	reg := cluster.NewRegistry(regPath)
+	if err := reg.Add(cluster.Entry{Name: "prod", PatroniURL: "http://127.0.0.1:1"}); err != nil {
+		t.Fatal(err)
+	}
+	run := func(args ...string) failover.LagPolicy {
+		t.Helper()
+		root := cli.NewRootCmdWithRegistry(regPath)
+		buf := new(strings.Builder)
+		root.SetOut(buf)
+		root.SetErr(buf)
+		root.SetArgs(append([]string{"failover", "policy", "--name", "prod"}, args...))
+		if err := root.Execute(); err != nil {
+			t.Fatalf("failover policy %v: %v\n%s", args, err, buf)
+		}
+		var resp struct {
+			Data struct {
+				Policy failover.LagPolicy `json:"policy"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal([]byte(buf.String()), &resp); err != nil {
+			t.Fatalf("output not valid JSON: %v\n%s", err, buf)
+		}
+		return resp.Data.Policy
+	}
+
+	if p := run(); p != failover.DefaultLagPolicy() {
+		t.Errorf("expected the default policy, got %+v", p)
+	}
+	if p := run("--max-lag-bytes", "1048576", "--max-lag-seconds", "15"); p.MaxLagBytes != 1048576 || p.MaxLagSeconds != 15 {
+		t.Errorf("expected the new thresholds, got %+v", p)
+	}
+	if p := run("--max-lag-seconds", "5"); p.MaxLagBytes != 1048576 || p.MaxLagSeconds != 5 {
+		t.Errorf("expected only the seconds changed, got %+v", p)
+	}
+	if entry, _ := reg.Get("prod"); entry.Failover == nil || entry.Failover.MaxLagSeconds != 5 {
+		t.Errorf("expected the policy in the registry, got %+v", entry.Failover)
+	}
+	if p := run("--reset"); p != failover.DefaultLagPolicy() {
+		t.Errorf("expected the default policy after reset, got %+v", p)
+	}
+	if entry, _ := reg.Get("prod"); entry.Failover != nil {
+		t.Errorf("expected the policy removed, got %+v", entry.Failover)
+	}
+}
+
+func TestFailoverTrigger_RefusesIneligibleCandidate(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/switchover" {
+			t.Error("switchover must not be requested")
+		}
+		json.NewEncoder(w).Encode(cs( //nolint:errcheck
+			running("pg-0", "leader", 0),
+			tagged(running("pg-1", "replica", 0), map[string]interface{}{"failover_priority": 0}),
+		))
+	}))
+	defer srv.Close()
+
+	out, err := executeCmd(t, nil, "failover", "trigger", "--patroni-url", srv.URL, "--candidate", "pg-1")
+	if err == nil || !strings.Contains(out, "cannot be promoted") {
+		t.Errorf("expected pg-1 to be refused, got %v\n%s", err, out)
+	}
+	out, err = executeCmd(t, nil, "failover", "trigger", "--patroni-url", srv.URL)
+	if err == nil || !strings.Contains(out, "no replica is eligible") {
+		t.Errorf("expected no eligible candidate, got %v\n%s", err, out)
+	}
+}
@@ -0,0 +1,138 @@
+package unit_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/luckyjian/pgdba/internal/failover"
+	"github.com/luckyjian/pgdba/internal/patroni"
+)
+
+// stagedPatroni serves the given /cluster responses one per request,
+// repeating the last one.
+func stagedPatroni(t *testing.T, stages ...[]patroni.Member) *httptest.Server {
+	t.Helper()
+	var mu sync.Mutex
+	n := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		stage := stages[n]
+		if n < len(stages)-1 {
+			n++
+		}
+		json.NewEncoder(w).Encode(patroni.ClusterStatus{Members: stage}) //nolint:errcheck
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func member(name, role string, state patroni.NodeState, timeline int64) patroni.Member {
+	return patroni.Member{Name: name, Role: role, State: state, Timeline: timeline}
+}
+
+func TestWaitForSwitchover_Converges(t *testing.T) {
+	srv := stagedPatroni(t,
+		[]patroni.Member{member("pg-0", "leader", patroni.StateRunning, 1), member("pg-1", "replica", patroni.StateStreaming, 1)},
+		[]patroni.Member{member("pg-0", "replica", patroni.StateStopped, 1), member("pg-1", "replica", patroni.StateRunning, 1)},
+		[]patroni.Member{member("pg-0", "replica", patroni.StateStopped, 1), member("pg-1", "leader", patroni.StateRunning, 2)},
+		[]patroni.Member{member("pg-0", "replica", patroni.StateRunning, 2), member("pg-1", "leader", patroni.StateRunning, 2)},
+		[]patroni.Member{member("pg-0", "replica", patroni.StateStreaming, 2), member("pg-1", "leader", patroni.StateRunning, 2)},
+	)
+
+	start := time.Now()
+	conv, err := failover.WaitForSwitchover(context.Background(), patroni.NewClient(srv.URL),
+		"pg-0", "pg-1", 1, start, 5*time.Second, 20*time.Millisecond)
+	if err != nil {
+		t.Fatalf("WaitForSwitchover: %v", err)
+	}
+	if conv.Leader != "pg-1" || conv.Timeline != 2 || len(conv.Members) != 2 {
+		t.Errorf("unexpected convergence %+v", conv)
+	}
+	// Down from the first poll (pg-0 still leading) to the third (pg-1 up).
+	if conv.DowntimeMS < 30 || conv.DowntimeMS > conv.ElapsedMS {
+		t.Errorf("expected about two poll intervals of downtime, got %dms of %dms", conv.DowntimeMS, conv.ElapsedMS)
+	}
+}
+
+func TestWaitForSwitchover_OldPrimaryNeverRejoins(t *testing.T) {
+	srv := stagedPatroni(t,
+		[]patroni.Member{member("pg-0", "replica", patroni.StateStopped, 1), member("pg-1", "leader", patroni.StateRunning, 2)},
+	)
+	_, err := failover.WaitForSwitchover(context.Background(), patroni.NewClient(srv.URL),
+		"pg-0", "pg-1", 1, time.Now(), 100*time.Millisecond, 20*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "pg-0 has not rejoined as a healthy replica") {
+		t.Errorf("expected a convergence timeout naming pg-0, got %v", err)
+	}
+}
+
+func TestWaitForSwitchover_AcceptsRunningReplicaOnNewTimeline(t *testing.T) {
+	// Patroni before 3.0 reports replicas as running. The leader's view is
+	// taken from the poll that saw it come up, not from later polls.
+	srv := stagedPatroni(t,
+		[]patroni.Member{member("pg-0", "replica", patroni.StateRunning, 1), member("pg-1", "leader", patroni.StateRunning, 2)},
+		[]patroni.Member{member("pg-0", "replica", patroni.StateRunning, 2), member("pg-1", "leader", patroni.NodeState("restarting"), 2)},
+	)
+	conv, err := failover.WaitForSwitchover(context.Background(), patroni.NewClient(srv.URL),
+		"pg-0", "pg-1", 1, time.Now(), 5*time.Second, 20*time.Millisecond)
+	if err != nil {
+		t.Fatalf("WaitForSwitchover: %v", err)
+	}
+	if conv.Leader != "pg-1" || conv.Timeline != 2 {
+		t.Errorf("unexpected convergence %+v", conv)
+	}
+}
+
+func TestWaitForSwitchover_RunningReplicaOnOldTimelineHasNotRejoined(t *testing.T) {
+	srv := stagedPatroni(t,
+		[]patroni.Member{member("pg-0", "replica", patroni.StateRunning, 1), member("pg-1", "leader", patroni.StateRunning, 2)},
+	)
+	_, err := failover.WaitForSwitchover(context.Background(), patroni.NewClient(srv.URL),
+		"pg-0", "pg-1", 1, time.Now(), 100*time.Millisecond, 20*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "timeline 1") {
+		t.Errorf("expected pg-0 on timeline 1 not to count as rejoined, got %v", err)
+	}
+}
+
+func TestWaitForSwitchover_RequiresNewTimeline(t *testing.T) {
+	srv := stagedPatroni(t,
+		[]patroni.Member{member("pg-0", "replica", patroni.StateStreaming, 1), member("pg-1", "leader", patroni.StateRunning, 1)},
+	)
+	_, err := failover.WaitForSwitchover(context.Background(), patroni.NewClient(srv.URL),
+		"pg-0", "pg-1", 1, time.Now(), 100*time.Millisecond, 20*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "new timeline") {
+		t.Errorf("expected the old timeline to be refused, got %v", err)
+	}
+}
+
+func TestFailoverTrigger_ReportsConvergence(t *testing.T) {
+	srv := mockPatroniServer(t)
+	defer srv.Close()
+
+	out, err := executeCmd(t, nil, "failover", "trigger", "--patroni-url", srv.URL)
+	if err != nil {
+		t.Fatalf("failover trigger failed: %v\n%s", err, out)
+	}
+	var resp struct {
+		Data struct {
+			Status     string           `json:"status"`
+			Leader     string           `json:"leader"`
+			Timeline   int64            `json:"timeline"`
+			DowntimeMS *int64           `json:"downtime_ms"`
+			Members    []patroni.Member `json:"members"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(out), &resp); err != nil {
+		t.Fatalf("output not valid JSON: %v\n%s", err, out)
+	}
+	d := resp.Data
+	if d.Status != "completed" || d.Leader != "pg-replica-1" || d.Timeline != 2 || d.DowntimeMS == nil || len(d.Members) != 3 {
+		t.Errorf("unexpected switchover result %+v", d)
+	}
+}
@@ -0,0 +1,298 @@
+package unit_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/luckyjian/pgdba/internal/cluster"
+	"github.com/luckyjian/pgdba/internal/lifecycle"
+	"github.com/luckyjian/pgdba/internal/patroni"
+	"github.com/luckyjian/pgdba/internal/provider"
+)
+
+// drillCluster fakes a Patroni cluster whose members each serve their own
+// REST API. An isolated member stops answering and drops out of /cluster;
+// isolating the leader promotes the first other member on timeline 2 when
+// autoFailover is set, and healing brings the old leader back streaming.
+type drillCluster struct {
+	mu           sync.Mutex
+	members      []patroni.Member
+	isolated     map[string]bool
+	autoFailover bool
+	leaderLSN    int64
+	history      [][]interface{}
+}
+
+func newDrillCluster(t *testing.T, names ...string) *drillCluster {
+	t.Helper()
+	dc := &drillCluster{isolated: map[string]bool{}, autoFailover: true, leaderLSN: 5000, history: [][]interface{}{}}
+	for i, name := range names {
+		name := name
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			dc.mu.Lock()
+			defer dc.mu.Unlock()
+			if dc.isolated[name] {
+				http.Error(w, "partitioned", http.StatusServiceUnavailable)
+				return
+			}
+			switch r.URL.Path {
+			case "/cluster":
+				visible := []patroni.Member{}
+				for _, m := range dc.members {
+					if !dc.isolated[m.Name] {
+						visible = append(visible, m)
+					}
+				}
+				json.NewEncoder(w).Encode(patroni.ClusterStatus{Members: visible}) //nolint:errcheck
+			case "/patroni":
+				fmt.Fprintf(w, `{"state":"running","role":"master","xlog":{"location":%d}}`, dc.leaderLSN)
+			case "/history":
+				json.NewEncoder(w).Encode(dc.history) //nolint:errcheck
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+		t.Cleanup(srv.Close)
+		m := patroni.Member{Name: name, Role: "replica", State: patroni.StateStreaming, Timeline: 1, APIURL: srv.URL + "/patroni"}
+		if i == 0 {
+			m.Role, m.State = "leader", patroni.StateRunning
+		}
+		dc.members = append(dc.members, m)
+	}
+	return dc
+}
+
+func (dc *drillCluster) client() *patroni.Client {
+	return patroni.NewClient(strings.TrimSuffix(dc.members[0].APIURL, "/patroni"))
+}
+
+// partition applies a provider partition to the fake topology.
+func (dc *drillCluster) partition(id string, isolate bool) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	dc.isolated[id] = isolate
+	if !isolate || !dc.autoFailover {
+		return
+	}
+	for i, m := range dc.members {
+		if m.Name != id || m.Role != "leader" {
+			continue
+		}
+		next := &dc.members[(i+1)%len(dc.members)]
+		next.Role, next.State = "leader", patroni.StateRunning
+		// Hidden until healed, then back as a replica of the new leader.
+		dc.members[i].Role, dc.members[i].State = "replica", patroni.StateStreaming
+		for j := range dc.members {
+			dc.members[j].Timeline = 2
+		}
+		dc.history = append(dc.history, []interface{}{1, 4096, "no recovery target specified", "", next.Name})
+		return
+	}
+}
+
+// drillProvider is a fakeProvider whose PartitionNode drives a drillCluster.
+type drillProvider struct {
+	*fakeProvider
+	dc         *drillCluster
+	partitions []string
+}
+
+func (p *drillProvider) PartitionNode(_ context.Context, id string, isolate bool) error {
+	action := "heal"
+	if isolate {
+		action = "isolate"
+	}
+	p.partitions = append(p.partitions, id+":"+action)
+	if p.dc != nil {
+		p.dc.partition(id, isolate)
+	}
+	return nil
+}
+
+func drillOptions(scenario string) lifecycle.DrillOptions {
+	return lifecycle.DrillOptions{
+		Scenario:     scenario,
+		Observe:      50 * time.Millisecond,
+		Timeout:      time.Second,
+		PollInterval: 10 * time.Millisecond,
+	}
+}
+
+func TestDrill_PrimaryPartitionFailsOver(t *testing.T) {
+	dc := newDrillCluster(t, "prod-pg-0", "prod-pg-1", "prod-pg-2")
+	p := &drillProvider{fakeProvider: newFakeProvider(), dc: dc}
+	var events []lifecycle.StepEvent
+	opts := drillOptions(lifecycle.ScenarioPrimaryPartition)
+	opts.OnEvent = func(ev lifecycle.StepEvent) { events = append(events, ev) }
+
+	report, err := lifecycle.Drill(context.Background(), p, dc.client(), &cluster.Entry{Name: "prod"}, opts)
+	if err != nil {
+		t.Fatalf("Drill: %v", err)
+	}
+	if got := strings.Join(p.partitions, ","); got != "prod-pg-0:isolate,prod-pg-0:heal" {
+		t.Errorf("expected the leader isolated then healed, got %s", got)
+	}
+	if !report.Passed || !report.FailedOver || !report.Converged || len(report.Findings) != 0 {
+		t.Fatalf("expected a passing drill, got %+v", report)
+	}
+	if report.Leader != "prod-pg-0" || report.NewLeader != "prod-pg-1" || report.TimelineBefore != 1 || report.TimelineAfter != 2 {
+		t.Errorf("unexpected topology change: %+v", report)
+	}
+	if report.RPOBytes == nil || *report.RPOBytes != 5000-4096 {
+		t.Errorf("expected RPO of 904 bytes, got %v", report.RPOBytes)
+	}
+	if report.RTOMS < 0 || len(report.Members) != 3 {
+		t.Errorf("expected RTO and the final members, got %+v", report)
+	}
+	if len(events) == 0 || len(events) != len(report.Events) {
+		t.Errorf("expected events streamed and recorded, got %d and %d", len(events), len(report.Events))
+	}
+}
+
+func TestDrill_PrimaryPartitionWithoutFailoverFails(t *testing.T) {
+	dc := newDrillCluster(t, "prod-pg-0", "prod-pg-1")
+	dc.autoFailover = false
+	p := &drillProvider{fakeProvider: newFakeProvider(), dc: dc}
+	opts := drillOptions(lifecycle.ScenarioPrimaryPartition)
+	opts.Timeout = 100 * time.Millisecond
+
+	report, err := lifecycle.Drill(context.Background(), p, dc.client(), &cluster.Entry{Name: "prod"}, opts)
+	if err != nil {
+		t.Fatalf("Drill: %v", err)
+	}
+	if report.Passed || report.FailedOver || len(report.Findings) == 0 ||
+		!strings.Contains(report.Findings[0], "no new leader") {
+		t.Errorf("expected a failed drill naming the missing leader, got %+v", report)
+	}
+	if got := strings.Join(p.partitions, ","); got != "prod-pg-0:isolate,prod-pg-0:heal" {
+		t.Errorf("expected the partition healed even though the drill failed, got %s", got)
+	}
+}
+
+func TestDrill_ReplicaPartitionKeepsLeader(t *testing.T) {
+	dc := newDrillCluster(t, "prod-pg-0", "prod-pg-1", "prod-pg-2")
+	p := &drillProvider{fakeProvider: newFakeProvider(), dc: dc}
+	opts := drillOptions(lifecycle.ScenarioReplicaPartition)
+	opts.Targets = []string{"prod-pg-2"}
+
+	report, err := lifecycle.Drill(context.Background(), p, dc.client(), &cluster.Entry{Name: "prod"}, opts)
+	if err != nil {
+		t.Fatalf("Drill: %v", err)
+	}
+	if got := strings.Join(p.partitions, ","); got != "prod-pg-2:isolate,prod-pg-2:heal" {
+		t.Errorf("expected prod-pg-2 isolated then healed, got %s", got)
+	}
+	if !report.Passed || report.FailedOver || report.RTOMS != 0 || report.NewLeader != "prod-pg-0" || !report.Converged {
+		t.Errorf("expected the leader to stay in place, got %+v", report)
+	}
+}
+
+func TestDrill_AcceptsRunningReplicas(t *testing.T) {
+	// Patroni before 3.0 reports healthy replicas as running.
+	dc := newDrillCluster(t, "prod-pg-0", "prod-pg-1", "prod-pg-2")
+	for i := range dc.members[1:] {
+		dc.members[i+1].State = patroni.StateRunning
+	}
+	p := &drillProvider{fakeProvider: newFakeProvider(), dc: dc}
+	opts := drillOptions(lifecycle.ScenarioReplicaPartition)
+	opts.Targets = []string{"prod-pg-2"}
+
+	report, err := lifecycle.Drill(context.Background(), p, dc.client(), &cluster.Entry{Name: "prod"}, opts)
+	if err != nil {
+		t.Fatalf("Drill: %v", err)
+	}
+	if !report.Passed || !report.Converged {
+		t.Errorf("expected a passing drill, got %+v", report)
+	}
+}
+
+func TestDrill_ReplicaPartitionRefusesLeaderTarget(t *testing.T) {
+	dc := newDrillCluster(t, "prod-pg-0", "prod-pg-1")
+	p := &drillProvider{fakeProvider: newFakeProvider(), dc: dc}
+	opts := drillOptions(lifecycle.ScenarioReplicaPartition)
+	opts.Targets = []string{"prod-pg-0"}
+
+	_, err := lifecycle.Drill(context.Background(), p, dc.client(), &cluster.Entry{Name: "prod"}, opts)
+	if err == nil || !strings.Contains(err.Error(), "is the leader") {
+		t.Errorf("expected the leader to be refused as a target, got %v", err)
+	}
+	if len(p.partitions) != 0 {
+		t.Errorf("expected nothing partitioned, got %v", p.partitions)
+	}
+}
+
+func TestDrill_EtcdMinorityIsolatesMinority(t *testing.T) {
+	dc := newDrillCluster(t, "prod-pg-0", "prod-pg-1")
+	p := &drillProvider{fakeProvider: newFakeProvider(), dc: dc}
+	for _, id := range []string{"prod-etcd-0", "prod-etcd-1", "prod-etcd-2", "other-etcd-0"} {
+		clusterName := strings.SplitN(id, "-", 2)[0]
+		p.nodes[id] = provider.NodeStatus{ID: id, Role: provider.RoleEtcd,
+			Labels: map[string]string{provider.LabelCluster: clusterName}}
+	}
+
+	report, err := lifecycle.Drill(context.Background(), p, dc.client(), &cluster.Entry{Name: "prod"},
+		drillOptions(lifecycle.ScenarioEtcdMinority))
+	if err != nil {
+		t.Fatalf("Drill: %v", err)
+	}
+	if got := strings.Join(p.partitions, ","); got != "prod-etcd-0:isolate,prod-etcd-0:heal" {
+		t.Errorf("expected one of three etcd nodes isolated, got %s", got)
+	}
+	if !report.Passed {
+		t.Errorf("expected a passing drill, got %+v", report)
+	}
+
+	opts := drillOptions(lifecycle.ScenarioEtcdMinority)
+	opts.Targets = []string{"prod-etcd-0", "prod-etcd-1"}
+	if _, err := lifecycle.Drill(context.Background(), p, dc.client(), &cluster.Entry{Name: "prod"}, opts); err == nil ||
+		!strings.Contains(err.Error(), "would lose quorum") {
+		t.Errorf("expected a majority target to be refused, got %v", err)
+	}
+
+	for _, targets := range [][]string{{"other-etcd-0"}, {"prod-pg-1"}, {"prod-etcd-9"}} {
+		opts.Targets = targets
+		if _, err := lifecycle.Drill(context.Background(), p, dc.client(), &cluster.Entry{Name: "prod"}, opts); err == nil ||
+			!strings.Contains(err.Error(), "is not an etcd node of cluster") {
+			t.Errorf("expected target %v to be refused, got %v", targets, err)
+		}
+	}
+	opts.Targets = []string{"prod-etcd-1", "prod-etcd-1"}
+	if _, err := lifecycle.Drill(context.Background(), p, dc.client(), &cluster.Entry{Name: "prod"}, opts); err == nil ||
+		!strings.Contains(err.Error(), "listed twice") {
+		t.Errorf("expected a repeated target to be refused, got %v", err)
+	}
+	if got := strings.Join(p.partitions, ","); got != "prod-etcd-0:isolate,prod-etcd-0:heal" {
+		t.Errorf("expected nothing more partitioned, got %s", got)
+	}
+}
+
+func TestDrillReport_SavedPrivately(t *testing.T) {
+	started := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	path := lifecycle.DrillReportPath(t.TempDir(), "prod", lifecycle.ScenarioPrimaryPartition, started)
+	if filepath.Base(path) != "prod-primary-partition-20260102T030405Z.json" {
+		t.Errorf("unexpected report path %s", path)
+	}
+	if err := lifecycle.SaveDrillReport(path, &lifecycle.DrillReport{Cluster: "prod", Passed: true}); err != nil {
+		t.Fatalf("SaveDrillReport: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("expected a 0600 report file, got %v %v", info, err)
+	}
+}
+
+func TestFailoverDrill_RequiresScenario(t *testing.T) {
+	out, err := executeCmd(t, nil, "failover", "drill", "--name", "prod")
+	if err == nil || !strings.Contains(out, "--scenario is required") {
+		t.Errorf("expected --scenario to be required, got %v\n%s", err, out)
+	}
+}
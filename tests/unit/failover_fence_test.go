@@ -0,0 +1,266 @@
+package unit_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/luckyjian/pgdba/internal/cli"
+	"github.com/luckyjian/pgdba/internal/cluster"
+	"github.com/luckyjian/pgdba/internal/lifecycle"
+	"github.com/luckyjian/pgdba/internal/patroni"
+)
+
+// fencedLeader serves /patroni for an old leader that reports itself a
+// running primary until stop() is called.
+func fencedLeader(t *testing.T) (patroni.Member, func()) {
+	t.Helper()
+	var mu sync.Mutex
+	stopped := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if stopped {
+			fmt.Fprint(w, `{"state":"stopped","role":"uninitialized"}`)
+			return
+		}
+		fmt.Fprint(w, `{"state":"running","role":"master","xlog":{"location":100}}`)
+	}))
+	t.Cleanup(srv.Close)
+	m := patroni.Member{Name: "pg-0", Role: "leader", State: patroni.StateRunning, APIURL: srv.URL + "/patroni"}
+	return m, func() { mu.Lock(); stopped = true; mu.Unlock() }
+}
+
+func fenceOptions(method string) lifecycle.FenceOptions {
+	return lifecycle.FenceOptions{Method: method, When: lifecycle.FenceAfter, PGVersion: 16,
+		VerifyTimeout: 200 * time.Millisecond, PollInterval: 10 * time.Millisecond}
+}
+
+func TestFence_StopVerified(t *testing.T) {
+	old, stop := fencedLeader(t)
+	p := newFakeProvider()
+	p.execFn = func(id string, cmd []string) (string, error) {
+		stop()
+		return "", nil
+	}
+
+	res := lifecycle.Fence(context.Background(), p, patroni.NewClient("http://127.0.0.1:1"), old, fenceOptions(lifecycle.FenceStop))
+	if !res.Fenced || !res.Verified || res.State != "stopped" {
+		t.Fatalf("expected pg-0 stopped and verified, got %+v", res)
+	}
+	if len(p.execs) != 1 || !strings.HasPrefix(p.execs[0], "pg-0: ") ||
+		!strings.Contains(p.execs[0], "/usr/lib/postgresql/16/bin/pg_ctl stop -D /var/lib/postgresql/data -m immediate") ||
+		!strings.Contains(p.execs[0], "pkill -TERM -f '[b]in/patroni'") ||
+		!strings.Contains(p.execs[0], `root) exec su postgres -s /bin/sh -c "$1"`) {
+		t.Errorf("unexpected fencing command %v", p.execs)
+	}
+}
+
+func TestFence_StopVerifiedWhenSessionDiesWithPatroni(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"state":"running","role":"master"}`)
+	}))
+	defer srv.Close()
+	old := patroni.Member{Name: "pg-0", Role: "leader", State: patroni.StateRunning, APIURL: srv.URL + "/patroni"}
+	p := newFakeProvider()
+	p.execFn = func(string, []string) (string, error) {
+		srv.Close()
+		return "", fmt.Errorf("exec: stream closed")
+	}
+
+	res := lifecycle.Fence(context.Background(), p, patroni.NewClient("http://127.0.0.1:1"), old, fenceOptions(lifecycle.FenceStop))
+	if res.Fenced || !res.Verified || !strings.Contains(res.Detail, "exec session ended") {
+		t.Fatalf("expected the stop verified by the API going away, got %+v", res)
+	}
+}
+
+func TestFence_StillWritable(t *testing.T) {
+	old, _ := fencedLeader(t)
+	p := newFakeProvider()
+
+	res := lifecycle.Fence(context.Background(), p, patroni.NewClient("http://127.0.0.1:1"), old, fenceOptions(lifecycle.FenceStop))
+	if !res.Fenced || res.Verified || !strings.Contains(res.Detail, "still a running primary") {
+		t.Fatalf("expected the fence not verified, got %+v", res)
+	}
+	if last := res.Events[len(res.Events)-1]; last.Step != lifecycle.StepVerifyFence || last.Status != lifecycle.StatusFailed {
+		t.Errorf("expected a failed verify-fence event, got %+v", last)
+	}
+}
+
+func TestFence_PartitionUnreachable(t *testing.T) {
+	dc := newDrillCluster(t, "pg-0", "pg-1")
+	dc.autoFailover = false
+	p := &drillProvider{fakeProvider: newFakeProvider(), dc: dc}
+
+	opts := fenceOptions(lifecycle.FencePartition)
+	opts.When = lifecycle.FenceBefore
+	res := lifecycle.Fence(context.Background(), p, dc.client(), dc.members[0], opts)
+	if !res.Fenced || !res.Verified || !strings.Contains(res.Detail, "unreachable after partition") {
+		t.Fatalf("expected pg-0 partitioned and verified, got %+v", res)
+	}
+	if strings.Join(p.partitions, ",") != "pg-0:isolate" {
+		t.Errorf("expected pg-0 isolated, got %v", p.partitions)
+	}
+}
+
+func TestFence_NoneAndUnreachableAfterFailure(t *testing.T) {
+	old, _ := fencedLeader(t)
+	if res := lifecycle.Fence(context.Background(), nil, nil, old, fenceOptions(lifecycle.FenceNone)); res.Fenced || res.Verified || len(res.Events) != 0 {
+		t.Errorf("expected nothing done for none, got %+v", res)
+	}
+
+	p := newFakeProvider()
+	p.execFn = func(string, []string) (string, error) { return "", fmt.Errorf("ssh: connection refused") }
+	gone := patroni.Member{Name: "pg-0", APIURL: "http://127.0.0.1:1/patroni"}
+	res := lifecycle.Fence(context.Background(), p, patroni.NewClient("http://127.0.0.1:1"), gone, fenceOptions(lifecycle.FenceStop))
+	if res.Fenced || res.Verified || !strings.Contains(res.Error, "connection refused") {
+		t.Errorf("expected an unreachable node that could not be stopped to stay unverified, got %+v", res)
+	}
+}
+
+func TestFailoverTrigger_ForceRecordsFencing(t *testing.T) {
+	srv := mockPatroniServer(t)
+	defer srv.Close()
+
+	out, err := executeCmd(t, nil, "failover", "trigger", "--patroni-url", srv.URL, "--force", "--candidate", "pg-replica-1")
+	if err != nil {
+		t.Fatalf("failover trigger --force: %v\n%s", err, out)
+	}
+	var resp struct {
+		Data cli.ForcedFailoverResult `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(out), &resp); err != nil {
+		t.Fatalf("output not valid JSON: %v\n%s", err, out)
+	}
+	if resp.Data.From != "pg-primary" || resp.Data.Fencing == nil || resp.Data.Fencing.Method != lifecycle.FenceNone ||
+		resp.Data.Fencing.Node != "pg-primary" {
+		t.Errorf("expected the unfenced old leader recorded, got %+v", resp.Data)
+	}
+}
+
+func TestFailoverTrigger_FenceNeedsManagedCluster(t *testing.T) {
+	requested := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/failover" {
+			requested = true
+		}
+		json.NewEncoder(w).Encode(cs(running("pg-0", "leader", 0), running("pg-1", "replica", 0))) //nolint:errcheck
+	}))
+	defer srv.Close()
+	regPath := filepath.Join(t.TempDir(), "clusters.json")
+	if err := cluster.NewRegistry(regPath).Add(cluster.Entry{Name: "ext", PatroniURL: srv.URL, Source: cluster.SourceExternal}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, args := range [][]string{
+		{"--patroni-url", srv.URL, "--fence", "stop"},
+		{"--name", "ext", "--fence", "partition"},
+		{"--name", "ext", "--fence", "stop", "--fence-when", "during"},
+	} {
+		root := cli.NewRootCmdWithRegistry(regPath)
+		buf := new(strings.Builder)
+		root.SetOut(buf)
+		root.SetErr(buf)
+		root.SetArgs(append([]string{"failover", "trigger", "--force", "--candidate", "pg-1"}, args...))
+		if err := root.Execute(); err == nil {
+			t.Errorf("expected %v to be refused\n%s", args, buf)
+		}
+	}
+	if requested {
+		t.Error("failover must not be requested when fencing cannot be set up")
+	}
+}
+
+func TestFailoverPolicy_Fence(t *testing.T) {
+	regPath := filepath.Join(t.TempDir(), "clusters.json")
+	reg := cluster.NewRegistry(regPath)
+	if err := reg.Add(cluster.Entry{Name: "prod", PatroniURL: "http://127.0.0.1:1", Source: cluster.SourceManaged}); err != nil {
+		t.Fatal(err)
+	}
+	run := func(args ...string) (string, string, error) {
+		t.Helper()
+		root := cli.NewRootCmdWithRegistry(regPath)
+		buf := new(strings.Builder)
+		root.SetOut(buf)
+		root.SetErr(buf)
+		root.SetArgs(append([]string{"failover", "policy", "--name", "prod"}, args...))
+		err := root.Execute()
+		var resp struct {
+			Data struct {
+				Fence     string `json:"fence"`
+				FenceWhen string `json:"fence_when"`
+			} `json:"data"`
+		}
+		json.Unmarshal([]byte(buf.String()), &resp) //nolint:errcheck
+		return resp.Data.Fence, resp.Data.FenceWhen, err
+	}
+
+	if fence, when, err := run(); err != nil || fence != "stop" || when != "after" {
+		t.Errorf("expected managed clusters to stop after promotion, got %s/%s (%v)", fence, when, err)
+	}
+	if fence, when, err := run("--fence", "partition"); err != nil || fence != "partition" || when != "before" {
+		t.Errorf("expected partition before promotion, got %s/%s (%v)", fence, when, err)
+	}
+	if entry, _ := reg.Get("prod"); entry.Failover == nil || entry.Failover.Fence != "partition" {
+		t.Errorf("expected the fencing method in the registry, got %+v", entry.Failover)
+	}
+	if _, _, err := run("--fence", "shoot"); err == nil {
+		t.Error("expected an unknown fencing method to be refused")
+	}
+	if fence, when, err := run("--reset"); err != nil || fence != "stop" || when != "after" {
+		t.Errorf("expected the defaults after reset, got %s/%s (%v)", fence, when, err)
+	}
+}
+
+func TestFailoverTrigger_UnverifiedFenceIsOneFailure(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/patroni":
+			fmt.Fprint(w, `{"state":"running","role":"master"}`)
+		case "/failover":
+			fmt.Fprint(w, "Successfully failed over to \"pg-1\"")
+		default:
+			leader := running("pg-0", "leader", 0)
+			leader.APIURL = srv.URL + "/patroni"
+			json.NewEncoder(w).Encode(cs(leader, running("pg-1", "replica", 0))) //nolint:errcheck
+		}
+	}))
+	defer srv.Close()
+	regPath := filepath.Join(t.TempDir(), "clusters.json")
+	if err := cluster.NewRegistry(regPath).Add(cluster.Entry{Name: "prod", PatroniURL: srv.URL, Source: cluster.SourceManaged}); err != nil {
+		t.Fatal(err)
+	}
+
+	root := cli.NewRootCmdWithRegistry(regPath)
+	stdout, stderr := new(strings.Builder), new(strings.Builder)
+	root.SetOut(stdout)
+	root.SetErr(stderr)
+	root.SetArgs([]string{"failover", "trigger", "--name", "prod", "--force", "--candidate", "pg-1",
+		"--fence", "stop", "--fence-timeout", "50ms"})
+	if err := root.Execute(); err == nil {
+		t.Fatalf("expected an unverified fence to fail the command\n%s", stdout)
+	}
+	if stdout.Len() != 0 {
+		t.Errorf("expected no success document, got %s", stdout)
+	}
+	var resp struct {
+		Success bool                     `json:"success"`
+		Error   string                   `json:"error"`
+		Data    cli.ForcedFailoverResult `json:"data"`
+	}
+	if err := json.NewDecoder(strings.NewReader(stderr.String())).Decode(&resp); err != nil {
+		t.Fatalf("failure not valid JSON: %v\n%s", err, stderr)
+	}
+	if resp.Success || !strings.Contains(resp.Error, "not fenced") || resp.Data.To != "pg-1" ||
+		resp.Data.Fencing == nil || resp.Data.Fencing.Verified {
+		t.Errorf("expected one failure carrying the failover result, got %+v", resp)
+	}
+}
@@ -0,0 +1,121 @@
+package unit_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/luckyjian/pgdba/internal/cli"
+	"github.com/luckyjian/pgdba/internal/failover"
+	"github.com/luckyjian/pgdba/internal/patroni"
+)
+
+const historyJSON = `[
+	[1, 25623960, "no recovery target specified", "2024-03-01T10:00:00+00:00"],
+	[2, 50331808, "no recovery target specified", "2024-05-02T08:30:00+00:00", "pg-1"]
+]`
+
+const historyClusterJSON = `{"members": [
+	{"name": "pg-0", "role": "replica", "state": "streaming", "timeline": 3},
+	{"name": "pg-1", "role": "leader", "state": "running", "timeline": 3},
+	{"name": "pg-2", "role": "replica", "state": "running", "timeline": 2},
+	{"name": "pg-3", "role": "replica", "state": "stopped"}
+]}`
+
+func historyPatroni(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/history":
+			w.Write([]byte(historyJSON))
+		case "/cluster":
+			w.Write([]byte(historyClusterJSON))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestGetHistory_DecodesOldAndNewEntries(t *testing.T) {
+	srv := historyPatroni(t)
+	history, err := patroni.NewClient(srv.URL).GetHistory(context.Background())
+	if err != nil {
+		t.Fatalf("GetHistory: %v", err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("expected two entries, got %d", len(history))
+	}
+	if history[0].Timeline != 1 || history[0].LSN != 25623960 || history[0].NewLeader != "" {
+		t.Errorf("unexpected first entry: %+v", history[0])
+	}
+	if history[1].NewLeader != "pg-1" || history[1].Timestamp != "2024-05-02T08:30:00+00:00" {
+		t.Errorf("unexpected second entry: %+v", history[1])
+	}
+	if got := patroni.FormatLSN(history[0].LSN); got != "0/186FD98" {
+		t.Errorf("expected LSN 0/186FD98, got %s", got)
+	}
+	if got := patroni.FormatLSN(0x100000060); got != "1/60" {
+		t.Errorf("expected LSN 1/60, got %s", got)
+	}
+}
+
+func TestBuildHistory_FlagsMembersOnOldTimelines(t *testing.T) {
+	srv := historyPatroni(t)
+	client := patroni.NewClient(srv.URL)
+	history, _ := client.GetHistory(context.Background())
+	cs, _ := client.GetClusterStatus(context.Background())
+
+	h := failover.BuildHistory(history, cs)
+	if h.CurrentTimeline != 3 {
+		t.Errorf("expected current timeline 3, got %d", h.CurrentTimeline)
+	}
+	if strings.Join(h.Current, ",") != "pg-0,pg-1" {
+		t.Errorf("expected pg-0 and pg-1 on the current timeline, got %v", h.Current)
+	}
+	if len(h.Stale) != 1 || h.Stale[0].Name != "pg-2" || h.Stale[0].Behind != 1 {
+		t.Errorf("expected pg-2 one timeline behind, got %+v", h.Stale)
+	}
+	if len(h.Entries[1].Members) != 1 || h.Entries[1].Members[0] != "pg-2" || h.Entries[0].Members != nil {
+		t.Errorf("expected pg-2 listed on timeline 2 only, got %+v", h.Entries)
+	}
+}
+
+func TestBuildHistory_NoSwitchesYet(t *testing.T) {
+	cs := &patroni.ClusterStatus{Members: []patroni.Member{{Name: "pg-0", Role: "leader", Timeline: 1}}}
+	h := failover.BuildHistory(nil, cs)
+	if h.CurrentTimeline != 1 || len(h.Entries) != 0 || len(h.Stale) != 0 {
+		t.Errorf("unexpected history for a fresh cluster: %+v", h)
+	}
+}
+
+func TestClusterHistory_TableOutput(t *testing.T) {
+	srv := historyPatroni(t)
+	cmd := cli.NewRootCmdWithRegistry(filepath.Join(t.TempDir(), "clusters.json"))
+	buf := new(strings.Builder)
+	cmd.SetOut(buf)
+	cmd.SetErr(buf)
+	cmd.SetArgs([]string{"cluster", "history", "--patroni-url", srv.URL, "--format", "table"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("cluster history: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{"ENDED AT LSN", "0/186FD98", "pg-1", "(current)"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in table output, got:\n%s", want, out)
+		}
+	}
+	var row string
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, "2 ") {
+			row = line
+		}
+	}
+	if !strings.HasSuffix(strings.TrimSpace(row), "pg-2") {
+		t.Errorf("expected pg-2 on the timeline 2 row, got %q", row)
+	}
+}
@@ -0,0 +1,167 @@
+package unit_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/luckyjian/pgdba/internal/patroni"
+)
+
+func TestClusterStatus_DecodesPendingSwitchover(t *testing.T) {
+	cases := map[string]struct {
+		body           string
+		from, to, when string
+	}{
+		"scheduled_switchover": {`{"members":[],"scheduled_switchover":{"at":"2030-01-02T03:04:05+00:00","from":"pg-0","to":"pg-1"}}`,
+			"pg-0", "pg-1", "2030-01-02T03:04:05Z"},
+		"dcs failover key": {`{"members":[],"failover":{"leader":"pg-0","member":"pg-2","scheduled_at":"2030-01-02T03:04:05.123456+00:00"}}`,
+			"pg-0", "pg-2", "2030-01-02T03:04:05Z"},
+		"bare string": {`{"members":[],"failover":"2030-01-02T03:04:05Z"}`, "", "", "2030-01-02T03:04:05Z"},
+	}
+	for name, tc := range cases {
+		var status patroni.ClusterStatus
+		if err := json.Unmarshal([]byte(tc.body), &status); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		p := status.Failover
+		if p == nil || p.From != tc.from || p.To != tc.to || p.At == nil ||
+			p.At.UTC().Truncate(time.Second).Format(time.RFC3339) != tc.when {
+			t.Errorf("%s: unexpected pending switchover %+v", name, p)
+		}
+		if !p.Scheduled(time.Date(2029, 1, 1, 0, 0, 0, 0, time.UTC)) || p.Scheduled(time.Date(2031, 1, 1, 0, 0, 0, 0, time.UTC)) {
+			t.Errorf("%s: expected the switchover to be due in 2030", name)
+		}
+	}
+
+	var status patroni.ClusterStatus
+	if err := json.Unmarshal([]byte(`{"members":[]}`), &status); err != nil || status.Failover != nil {
+		t.Errorf("expected no pending switchover, got %+v (%v)", status.Failover, err)
+	}
+}
+
+// schedulingPatroni fakes a Patroni that accepts scheduled switchovers and
+// lists the pending one in /cluster until it is cancelled.
+type schedulingPatroni struct {
+	mu      sync.Mutex
+	srv     *httptest.Server
+	pending map[string]string
+}
+
+func newSchedulingPatroni(t *testing.T) *schedulingPatroni {
+	t.Helper()
+	sp := &schedulingPatroni{}
+	sp.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sp.mu.Lock()
+		defer sp.mu.Unlock()
+		switch {
+		case r.URL.Path == "/cluster":
+			body := map[string]interface{}{"members": []patroni.Member{
+				running("pg-0", "leader", 0), running("pg-1", "replica", 0)}}
+			if sp.pending != nil {
+				body["scheduled_switchover"] = map[string]string{
+					"at": sp.pending["scheduled_at"], "from": sp.pending["leader"], "to": sp.pending["candidate"]}
+			}
+			json.NewEncoder(w).Encode(body) //nolint:errcheck
+		case r.URL.Path == "/switchover" && r.Method == http.MethodPost:
+			json.NewDecoder(r.Body).Decode(&sp.pending) //nolint:errcheck
+			if sp.pending["scheduled_at"] == "" {
+				t.Error("expected a scheduled switchover")
+			}
+			w.WriteHeader(http.StatusAccepted)
+		case r.URL.Path == "/switchover" && r.Method == http.MethodDelete:
+			if sp.pending == nil {
+				http.Error(w, "no switchover is scheduled", http.StatusNotFound)
+				return
+			}
+			sp.pending = nil
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(sp.srv.Close)
+	return sp
+}
+
+func TestFailoverTrigger_ScheduleStatusCancel(t *testing.T) {
+	sp := newSchedulingPatroni(t)
+
+	out, err := executeCmd(t, nil, "failover", "trigger", "--patroni-url", sp.srv.URL, "--candidate", "pg-1", "--at", "2h")
+	if err != nil {
+		t.Fatalf("failover trigger --at: %v\n%s", err, out)
+	}
+	var trig struct {
+		Data struct {
+			Status      string     `json:"status"`
+			To          string     `json:"to"`
+			ScheduledAt *time.Time `json:"scheduled_at"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(out), &trig); err != nil {
+		t.Fatalf("output not valid JSON: %v\n%s", err, out)
+	}
+	if trig.Data.Status != "scheduled" || trig.Data.To != "pg-1" || trig.Data.ScheduledAt == nil ||
+		time.Until(*trig.Data.ScheduledAt) < 119*time.Minute {
+		t.Errorf("unexpected trigger result %+v", trig.Data)
+	}
+	if at, err := time.Parse(time.RFC3339, sp.pending["scheduled_at"]); err != nil || !at.Equal(*trig.Data.ScheduledAt) {
+		t.Errorf("expected scheduled_at %v sent to Patroni, got %q", trig.Data.ScheduledAt, sp.pending["scheduled_at"])
+	}
+
+	out, err = executeCmd(t, nil, "failover", "status", "--patroni-url", sp.srv.URL)
+	if err != nil {
+		t.Fatalf("failover status: %v\n%s", err, out)
+	}
+	var status struct {
+		Data struct {
+			InProgress bool                       `json:"failover_in_progress"`
+			Scheduled  *patroni.PendingSwitchover `json:"scheduled_switchover"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(out), &status); err != nil {
+		t.Fatalf("output not valid JSON: %v\n%s", err, out)
+	}
+	if status.Data.InProgress || status.Data.Scheduled == nil || status.Data.Scheduled.From != "pg-0" ||
+		status.Data.Scheduled.To != "pg-1" || status.Data.Scheduled.At == nil {
+		t.Errorf("expected the scheduled switchover in failover status, got %s", out)
+	}
+
+	out, err = executeCmd(t, nil, "failover", "cancel", "--patroni-url", sp.srv.URL)
+	if err != nil || !strings.Contains(out, `"cancelled"`) || sp.pending != nil {
+		t.Errorf("expected the switchover cancelled, got %v\n%s", err, out)
+	}
+	out, err = executeCmd(t, nil, "failover", "cancel", "--patroni-url", sp.srv.URL)
+	if err == nil || !strings.Contains(out, "no switchover is scheduled") {
+		t.Errorf("expected cancelling nothing to fail, got %v\n%s", err, out)
+	}
+}
+
+func TestFailoverTrigger_AtValidation(t *testing.T) {
+	for _, args := range [][]string{
+		{"--at", "2h", "--force", "--candidate", "pg-1"},
+		{"--at", "2001-01-01T00:00:00Z"},
+		{"--at", "tomorrow"},
+	} {
+		out, err := executeCmd(t, nil, append([]string{"failover", "trigger", "--patroni-url", "http://127.0.0.1:1"}, args...)...)
+		if err == nil || !strings.Contains(out, "--at") {
+			t.Errorf("expected %v to be refused, got %v\n%s", args, err, out)
+		}
+	}
+}
+
+func TestReplicaPromote_Scheduled(t *testing.T) {
+	sp := newSchedulingPatroni(t)
+	at := time.Now().Add(time.Hour).UTC().Truncate(time.Second).Format(time.RFC3339)
+
+	out, err := executeCmd(t, nil, "replica", "promote", "--patroni-url", sp.srv.URL, "--candidate", "pg-1", "--at", at)
+	if err != nil || !strings.Contains(out, `"scheduled"`) {
+		t.Fatalf("replica promote --at: %v\n%s", err, out)
+	}
+	if sp.pending["scheduled_at"] != at || sp.pending["candidate"] != "pg-1" {
+		t.Errorf("expected a switchover to pg-1 at %s, got %v", at, sp.pending)
+	}
+}
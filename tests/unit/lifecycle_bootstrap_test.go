@@ -0,0 +1,350 @@
+package unit_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/luckyjian/pgdba/internal/cluster"
+	"github.com/luckyjian/pgdba/internal/lifecycle"
+	"github.com/luckyjian/pgdba/internal/provider"
+)
+
+// fakeProvider is an in-memory provider.Provider used by lifecycle tests.
+// Created nodes report fakeHost as their address.
+type fakeProvider struct {
+	mu        sync.Mutex
+	fakeHost  string
+	nodes     map[string]provider.NodeStatus
+	configs   map[string]provider.NodeConfig
+	created   []string
+	destroyed []string
+	execs     []string
+	failOn    map[string]error // node name → error returned by CreateNode/DestroyNode
+	execFn    func(id string, cmd []string) (string, error)
+}
+
+func newFakeProvider() *fakeProvider {
+	return &fakeProvider{
+		fakeHost: "127.0.0.1",
+		nodes:    map[string]provider.NodeStatus{},
+		configs:  map[string]provider.NodeConfig{},
+		failOn:   map[string]error{},
+	}
+}
+
+func (f *fakeProvider) Type() string { return "fake" }
+
+func (f *fakeProvider) CreateNode(_ context.Context, cfg provider.NodeConfig) (provider.NodeStatus, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.failOn[cfg.Name]; err != nil {
+		return provider.NodeStatus{}, err
+	}
+	labels := map[string]string{}
+	for k, v := range cfg.Labels {
+		labels[k] = v
+	}
+	st := provider.NodeStatus{ID: cfg.Name, Host: f.fakeHost, Role: cfg.Role, Running: true, Healthy: true, Labels: labels}
+	f.nodes[cfg.Name] = st
+	f.configs[cfg.Name] = cfg
+	f.created = append(f.created, cfg.Name)
+	return st, nil
+}
+
+func (f *fakeProvider) DestroyNode(_ context.Context, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.failOn[id]; err != nil {
+		return err
+	}
+	delete(f.nodes, id)
+	f.destroyed = append(f.destroyed, id)
+	return nil
+}
+
+func (f *fakeProvider) ExecOnNode(_ context.Context, id string, cmd []string) (string, error) {
+	f.mu.Lock()
+	f.execs = append(f.execs, id+": "+strings.Join(cmd, " "))
+	fn := f.execFn
+	f.mu.Unlock()
+	if fn != nil {
+		return fn(id, cmd)
+	}
+	return "", nil
+}
+
+func (f *fakeProvider) GetNodeStatus(_ context.Context, id string) (provider.NodeStatus, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	st, ok := f.nodes[id]
+	if !ok {
+		return provider.NodeStatus{}, fmt.Errorf("node %s not found", id)
+	}
+	return st, nil
+}
+
+func (f *fakeProvider) ListNodes(_ context.Context) ([]provider.NodeStatus, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	nodes := make([]provider.NodeStatus, 0, len(f.nodes))
+	for _, st := range f.nodes {
+		nodes = append(nodes, st)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+	return nodes, nil
+}
+
+func (f *fakeProvider) PartitionNode(_ context.Context, _ string, _ bool) error { return nil }
+
+// leaderPatroni serves /cluster with a running leader once ready() is true.
+func leaderPatroni(t *testing.T, leader string, ready func() bool) (*httptest.Server, int) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		members := []map[string]interface{}{}
+		if ready() {
+			members = append(members, map[string]interface{}{"name": leader, "role": "leader", "state": "running"})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"members": members}) //nolint:errcheck
+	}))
+	t.Cleanup(srv.Close)
+	_, portStr, _ := net.SplitHostPort(strings.TrimPrefix(srv.URL, "http://"))
+	port, _ := strconv.Atoi(portStr)
+	return srv, port
+}
+
+func bootstrapOptions(port int) lifecycle.BootstrapOptions {
+	return lifecycle.BootstrapOptions{
+		ClusterName:  "prod",
+		PrimaryHost:  "10.0.0.10",
+		Replicas:     2,
+		Passwords:    lifecycle.Passwords{Superuser: "su-secret", Replication: "repl-secret", Rewind: "rw-secret"},
+		PatroniPort:  port,
+		PollInterval: 10 * time.Millisecond,
+	}
+}
+
+func TestBootstrap_CreatesTopologyAndRegisters(t *testing.T) {
+	_, port := leaderPatroni(t, "prod-pg-0", func() bool { return true })
+	p := newFakeProvider()
+	reg := cluster.NewRegistry(filepath.Join(t.TempDir(), "clusters.json"))
+
+	opts := bootstrapOptions(port)
+	opts.PgBouncer = true
+	var streamed []lifecycle.StepEvent
+	opts.OnEvent = func(ev lifecycle.StepEvent) { streamed = append(streamed, ev) }
+
+	result, err := lifecycle.Bootstrap(context.Background(), p, reg, opts)
+	if err != nil {
+		t.Fatalf("Bootstrap: %v", err)
+	}
+
+	want := []string{"prod-etcd-0", "prod-etcd-1", "prod-etcd-2", "prod-pg-0", "prod-pg-1", "prod-pg-2", "prod-pgbouncer-0"}
+	if strings.Join(p.created, ",") != strings.Join(want, ",") {
+		t.Errorf("expected creation order %v, got %v", want, p.created)
+	}
+	for _, name := range want {
+		if p.configs[name].Labels[provider.LabelCluster] != "prod" {
+			t.Errorf("%s: expected cluster label, got %v", name, p.configs[name].Labels)
+		}
+	}
+	if p.configs["prod-pg-0"].Role != provider.RolePrimary || p.configs["prod-pg-1"].Role != provider.RoleStandby {
+		t.Errorf("unexpected roles: pg-0=%q pg-1=%q", p.configs["prod-pg-0"].Role, p.configs["prod-pg-1"].Role)
+	}
+
+	patroniYML := p.configs["prod-pg-0"].Files[lifecycle.PatroniConfigPath]
+	for _, s := range []string{"scope: prod", "name: prod-pg-0", "connect_address: 10.0.0.10:8008",
+		"prod-etcd-0:2379,prod-etcd-1:2379,prod-etcd-2:2379", "su-secret"} {
+		if !strings.Contains(patroniYML, s) {
+			t.Errorf("patroni.yml missing %q:\n%s", s, patroniYML)
+		}
+	}
+	etcdYML := p.configs["prod-etcd-1"].Files[lifecycle.EtcdConfigPath]
+	if !strings.Contains(etcdYML, "prod-etcd-0=http://prod-etcd-0:2380,prod-etcd-1=http://prod-etcd-1:2380") {
+		t.Errorf("etcd.yml missing initial cluster:\n%s", etcdYML)
+	}
+	ini := p.configs["prod-pgbouncer-0"].Files[lifecycle.PgBouncerIniPath]
+	if !strings.Contains(ini, "host=10.0.0.10 port=5432") {
+		t.Errorf("pgbouncer.ini should point at the primary:\n%s", ini)
+	}
+	if users := p.configs["prod-pgbouncer-0"].Files[lifecycle.PgBouncerUsersPath]; strings.Contains(users, "su-secret") {
+		t.Error("userlist.txt must contain a hash, not the plaintext password")
+	}
+
+	if result.Leader != "prod-pg-0" {
+		t.Errorf("expected leader prod-pg-0, got %q", result.Leader)
+	}
+	if len(result.Events) == 0 || len(streamed) != len(result.Events) {
+		t.Errorf("expected every event to be streamed: result=%d streamed=%d", len(result.Events), len(streamed))
+	}
+	last := result.Events[len(result.Events)-1]
+	if last.Step != lifecycle.StepRegister || last.Status != lifecycle.StatusDone {
+		t.Errorf("expected final register/done event, got %+v", last)
+	}
+
+	entry, err := reg.Get("prod")
+	if err != nil {
+		t.Fatalf("expected registry entry: %v", err)
+	}
+	if entry.Source != cluster.SourceManaged || entry.Provider != "fake" {
+		t.Errorf("unexpected entry: %+v", entry)
+	}
+	if entry.PatroniURL != fmt.Sprintf("http://127.0.0.1:%d", port) {
+		t.Errorf("unexpected patroni url %q", entry.PatroniURL)
+	}
+}
+
+func TestBootstrap_WaitsForLeaderBeforeReplicas(t *testing.T) {
+	var mu sync.Mutex
+	calls := 0
+	_, port := leaderPatroni(t, "prod-pg-0", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		return calls >= 3
+	})
+	p := newFakeProvider()
+	reg := cluster.NewRegistry(filepath.Join(t.TempDir(), "clusters.json"))
+
+	opts := bootstrapOptions(port)
+	opts.Replicas = 1
+	result, err := lifecycle.Bootstrap(context.Background(), p, reg, opts)
+	if err != nil {
+		t.Fatalf("Bootstrap: %v", err)
+	}
+	var leaderIdx, replicaIdx int
+	for i, ev := range result.Events {
+		if ev.Step == lifecycle.StepLeader && ev.Status == lifecycle.StatusDone {
+			leaderIdx = i
+		}
+		if ev.Step == lifecycle.StepReplica && ev.Status == lifecycle.StatusStarted && replicaIdx == 0 {
+			replicaIdx = i
+		}
+	}
+	if leaderIdx == 0 || replicaIdx < leaderIdx {
+		t.Errorf("replicas must start after the leader is elected (leader=%d replica=%d)", leaderIdx, replicaIdx)
+	}
+}
+
+func TestBootstrap_LeaderTimeout(t *testing.T) {
+	_, port := leaderPatroni(t, "prod-pg-0", func() bool { return false })
+	p := newFakeProvider()
+	reg := cluster.NewRegistry(filepath.Join(t.TempDir(), "clusters.json"))
+
+	opts := bootstrapOptions(port)
+	opts.LeaderTimeout = 50 * time.Millisecond
+	result, err := lifecycle.Bootstrap(context.Background(), p, reg, opts)
+	if err == nil || !strings.Contains(err.Error(), "no leader elected") {
+		t.Fatalf("expected leader timeout, got %v", err)
+	}
+	if _, err := reg.Get("prod"); err == nil {
+		t.Error("cluster must not be registered when bootstrap fails")
+	}
+	last := result.Events[len(result.Events)-1]
+	if last.Step != lifecycle.StepLeader || last.Status != lifecycle.StatusFailed {
+		t.Errorf("expected leader/failed event, got %+v", last)
+	}
+	for _, name := range p.created {
+		if strings.HasPrefix(name, "prod-pg-1") {
+			t.Error("replicas must not be created without a leader")
+		}
+	}
+}
+
+func TestBootstrap_CreateNodeFailure(t *testing.T) {
+	_, port := leaderPatroni(t, "prod-pg-0", func() bool { return true })
+	p := newFakeProvider()
+	p.failOn["prod-etcd-1"] = fmt.Errorf("disk full")
+	reg := cluster.NewRegistry(filepath.Join(t.TempDir(), "clusters.json"))
+
+	_, err := lifecycle.Bootstrap(context.Background(), p, reg, bootstrapOptions(port))
+	if err == nil || !strings.Contains(err.Error(), "etcd prod-etcd-1") || !strings.Contains(err.Error(), "disk full") {
+		t.Fatalf("expected etcd step error, got %v", err)
+	}
+	if len(p.created) != 1 {
+		t.Errorf("expected bootstrap to stop after the failure, created %v", p.created)
+	}
+}
+
+func TestBootstrap_RejectsExistingCluster(t *testing.T) {
+	reg := cluster.NewRegistry(filepath.Join(t.TempDir(), "clusters.json"))
+	if err := reg.Add(cluster.Entry{Name: "prod", Source: cluster.SourceExternal}); err != nil {
+		t.Fatal(err)
+	}
+	p := newFakeProvider()
+	_, err := lifecycle.Bootstrap(context.Background(), p, reg, bootstrapOptions(8008))
+	if err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("expected duplicate cluster error, got %v", err)
+	}
+	if len(p.created) != 0 {
+		t.Errorf("no nodes should be created, got %v", p.created)
+	}
+}
+
+func TestPasswordsFromEnv(t *testing.T) {
+	t.Setenv("PGDBA_PG_PASSWORD", "a")
+	t.Setenv("PGDBA_REPLICATION_PASSWORD", "b")
+	t.Setenv("PGDBA_REWIND_PASSWORD", "")
+	pw, err := lifecycle.PasswordsFromEnv()
+	if err != nil {
+		t.Fatalf("PasswordsFromEnv: %v", err)
+	}
+	if pw.Rewind != "b" {
+		t.Errorf("expected rewind password to default to replication password, got %q", pw.Rewind)
+	}
+
+	t.Setenv("PGDBA_REPLICATION_PASSWORD", "")
+	if _, err := lifecycle.PasswordsFromEnv(); err == nil {
+		t.Error("expected error when PGDBA_REPLICATION_PASSWORD is unset")
+	}
+}
+
+func TestPatroniNode_PassesSettingsThroughEnvForDefaultImage(t *testing.T) {
+	tmpl := lifecycle.MemberTemplate{
+		ClusterName: "prod",
+		EtcdHosts:   []string{"prod-etcd-0:2379"},
+		PGPort:      5432,
+		DataDir:     lifecycle.DefaultDataDir,
+		Passwords:   lifecycle.Passwords{Superuser: "su", Replication: "repl", Rewind: "rw"},
+	}
+	cfg, err := lifecycle.PatroniNode(tmpl, "prod-pg-0", "10.0.0.1", provider.RoleStandby)
+	if err != nil {
+		t.Fatalf("PatroniNode: %v", err)
+	}
+	if cfg.Image != "" {
+		t.Fatalf("expected the provider's default image, got %q", cfg.Image)
+	}
+	want := map[string]string{
+		"SCOPE":                        "prod",
+		"PGVERSION":                    "16",
+		"PGDATA":                       lifecycle.DefaultDataDir,
+		"PGPASSWORD_SUPERUSER":         "su",
+		"PGUSER_STANDBY":               "replicator",
+		"PGPASSWORD_STANDBY":           "repl",
+		"PATRONI_NAMESPACE":            "/db/",
+		"PATRONI_POSTGRESQL_DATA_DIR":  lifecycle.DefaultDataDir,
+		"PATRONI_SUPERUSER_PASSWORD":   "su",
+		"PATRONI_REPLICATION_USERNAME": "replicator",
+		"PATRONI_REPLICATION_PASSWORD": "repl",
+		"PATRONI_REWIND_USERNAME":      "rewind_user",
+		"PATRONI_REWIND_PASSWORD":      "rw",
+	}
+	for k, v := range want {
+		if cfg.Env[k] != v {
+			t.Errorf("expected %s=%q, got %q", k, v, cfg.Env[k])
+		}
+	}
+	if !strings.HasPrefix(lifecycle.MemberKey("prod", "prod-pg-0"), cfg.Env["PATRONI_NAMESPACE"]) {
+		t.Errorf("expected member keys under the namespace passed to Patroni, got %s", lifecycle.MemberKey("prod", "prod-pg-0"))
+	}
+}
@@ -0,0 +1,263 @@
+package unit_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/luckyjian/pgdba/internal/cluster"
+	"github.com/luckyjian/pgdba/internal/lifecycle"
+	"github.com/luckyjian/pgdba/internal/patroni"
+	"github.com/luckyjian/pgdba/internal/provider"
+)
+
+// rollingCluster fakes a Patroni cluster in which every member has its own
+// REST API server. Restarts and reloads are recorded per member, config
+// patches as JSON, and a switchover moves the leader role immediately. A
+// restart changes the member's postmaster start time.
+type rollingCluster struct {
+	mu       sync.Mutex
+	members  []patroni.Member
+	starts   map[string]int
+	restarts []string
+	reloads  []string
+	patches  []string
+	switches []string
+}
+
+func newRollingCluster(t *testing.T, names ...string) *rollingCluster {
+	t.Helper()
+	rc := &rollingCluster{starts: map[string]int{}}
+	for i, name := range names {
+		name := name
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rc.mu.Lock()
+			defer rc.mu.Unlock()
+			switch r.URL.Path {
+			case "/cluster":
+				json.NewEncoder(w).Encode(patroni.ClusterStatus{Members: rc.members}) //nolint:errcheck
+			case "/patroni":
+				json.NewEncoder(w).Encode(patroni.NodeInfo{ //nolint:errcheck
+					PostmasterStartTime: fmt.Sprintf("2026-01-01 00:00:%02d+00", rc.starts[name])})
+			case "/restart":
+				rc.restarts = append(rc.restarts, name)
+				rc.starts[name]++
+			case "/reload":
+				rc.reloads = append(rc.reloads, name)
+			case "/config":
+				body, _ := io.ReadAll(r.Body)
+				rc.patches = append(rc.patches, string(body))
+			case "/switchover":
+				var body map[string]string
+				json.NewDecoder(r.Body).Decode(&body) //nolint:errcheck
+				rc.switches = append(rc.switches, body["leader"]+"->"+body["candidate"])
+				for j := range rc.members {
+					switch rc.members[j].Name {
+					case body["leader"]:
+						rc.members[j].Role, rc.members[j].State = "replica", patroni.StateStreaming
+					case body["candidate"]:
+						rc.members[j].Role, rc.members[j].State = "leader", patroni.StateRunning
+					}
+				}
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+		t.Cleanup(srv.Close)
+		m := patroni.Member{Name: name, Role: "replica", State: patroni.StateStreaming,
+			Lag: int64(i * 100), APIURL: srv.URL + "/patroni"}
+		if i == 0 {
+			m.Role, m.State = "leader", patroni.StateRunning
+		}
+		rc.members = append(rc.members, m)
+	}
+	return rc
+}
+
+func (rc *rollingCluster) client() *patroni.Client {
+	return patroni.NewClient(strings.TrimSuffix(rc.members[0].APIURL, "/patroni"))
+}
+
+func rollingOptions(t *testing.T, kind string) lifecycle.RollingOptions {
+	return lifecycle.RollingOptions{
+		Kind:         kind,
+		Timeout:      time.Second,
+		PollInterval: 10 * time.Millisecond,
+		StatePath:    lifecycle.OperationStatePath(t.TempDir(), "prod", kind),
+	}
+}
+
+// imageProvider is a fakeProvider that also implements provider.ImageUpdater.
+// Updated members restart in rc when it is set; otherwise Patroni keeps
+// reporting their stale entries.
+type imageProvider struct {
+	*fakeProvider
+	rc      *rollingCluster
+	updates []string
+}
+
+func (p *imageProvider) UpdateNodeImage(_ context.Context, id, image string) error {
+	p.updates = append(p.updates, id+"="+image)
+	if p.rc != nil {
+		p.rc.mu.Lock()
+		p.rc.starts[id]++
+		p.rc.mu.Unlock()
+	}
+	return nil
+}
+
+func TestRolling_RestartsReplicasThenLeader(t *testing.T) {
+	rc := newRollingCluster(t, "prod-pg-0", "prod-pg-1", "prod-pg-2")
+	opts := rollingOptions(t, lifecycle.RollingRestart)
+
+	result, err := lifecycle.Rolling(context.Background(), nil, rc.client(), &cluster.Entry{Name: "prod"}, opts)
+	if err != nil {
+		t.Fatalf("Rolling: %v", err)
+	}
+	if got := strings.Join(rc.restarts, ","); got != "prod-pg-1,prod-pg-2,prod-pg-0" {
+		t.Errorf("expected replicas then the old leader, got %s", got)
+	}
+	if len(rc.switches) != 1 || rc.switches[0] != "prod-pg-0->prod-pg-1" {
+		t.Errorf("expected a switchover to the least-lagged replica, got %v", rc.switches)
+	}
+	if result.Leader != "prod-pg-1" || result.Resumed {
+		t.Errorf("unexpected result: %+v", result)
+	}
+	if _, err := os.Stat(opts.StatePath); !os.IsNotExist(err) {
+		t.Error("expected operation state to be removed on completion")
+	}
+}
+
+func TestRolling_ResumesInterruptedRun(t *testing.T) {
+	rc := newRollingCluster(t, "prod-pg-0", "prod-pg-1", "prod-pg-2")
+	opts := rollingOptions(t, lifecycle.RollingRestart)
+	os.MkdirAll(filepath.Dir(opts.StatePath), 0700) //nolint:errcheck
+	state := `{"cluster":"prod","kind":"rolling-restart","order":["prod-pg-1","prod-pg-2","prod-pg-0"],"done":["prod-pg-1"]}`
+	if err := os.WriteFile(opts.StatePath, []byte(state), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := lifecycle.Rolling(context.Background(), nil, rc.client(), &cluster.Entry{Name: "prod"}, opts)
+	if err != nil {
+		t.Fatalf("Rolling: %v", err)
+	}
+	if got := strings.Join(rc.restarts, ","); got != "prod-pg-2,prod-pg-0" {
+		t.Errorf("expected only the remaining members restarted, got %s", got)
+	}
+	if !result.Resumed || strings.Join(result.Skipped, ",") != "prod-pg-1" {
+		t.Errorf("expected a resumed run skipping prod-pg-1, got %+v", result)
+	}
+}
+
+func TestRolling_RejectsDifferentPendingOperation(t *testing.T) {
+	rc := newRollingCluster(t, "prod-pg-0", "prod-pg-1")
+	opts := rollingOptions(t, lifecycle.RollingUpgrade)
+	opts.Image = "spilo:16.5"
+	os.MkdirAll(filepath.Dir(opts.StatePath), 0700) //nolint:errcheck
+	state := `{"cluster":"prod","kind":"rolling-upgrade","image":"spilo:16.4","order":["prod-pg-1","prod-pg-0"],"done":[]}`
+	if err := os.WriteFile(opts.StatePath, []byte(state), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	p := &imageProvider{fakeProvider: newFakeProvider()}
+	_, err := lifecycle.Rolling(context.Background(), p, rc.client(), &cluster.Entry{Name: "prod"}, opts)
+	if err == nil || !strings.Contains(err.Error(), "pending") {
+		t.Errorf("expected pending operation error, got %v", err)
+	}
+}
+
+func TestRolling_LaggingMemberStopsAndKeepsState(t *testing.T) {
+	rc := newRollingCluster(t, "prod-pg-0", "prod-pg-1", "prod-pg-2")
+	rc.members[1].Lag = 1 << 40
+	opts := rollingOptions(t, lifecycle.RollingRestart)
+	opts.Timeout = 50 * time.Millisecond
+
+	_, err := lifecycle.Rolling(context.Background(), nil, rc.client(), &cluster.Entry{Name: "prod"}, opts)
+	if err == nil || !strings.Contains(err.Error(), "lag") {
+		t.Fatalf("expected a lag timeout, got %v", err)
+	}
+	if got := strings.Join(rc.restarts, ","); got != "prod-pg-1" {
+		t.Errorf("expected to stop after the first member, got %s", got)
+	}
+	data, err := os.ReadFile(opts.StatePath)
+	if err != nil || !strings.Contains(string(data), `"done": []`) {
+		t.Errorf("expected resumable state with nothing done, got %s (%v)", data, err)
+	}
+}
+
+func TestRolling_UpgradeUsesImageUpdater(t *testing.T) {
+	rc := newRollingCluster(t, "prod-pg-0", "prod-pg-1")
+	opts := rollingOptions(t, lifecycle.RollingUpgrade)
+	opts.Image = "spilo:16.4"
+
+	if _, err := lifecycle.Rolling(context.Background(), newFakeProvider(), rc.client(),
+		&cluster.Entry{Name: "prod"}, opts); err == nil {
+		t.Error("expected an error for a provider without ImageUpdater")
+	}
+
+	p := &imageProvider{fakeProvider: newFakeProvider(), rc: rc}
+	var _ provider.ImageUpdater = p
+	if _, err := lifecycle.Rolling(context.Background(), p, rc.client(), &cluster.Entry{Name: "prod"}, opts); err != nil {
+		t.Fatalf("Rolling: %v", err)
+	}
+	if got := strings.Join(p.updates, ","); got != "prod-pg-1=spilo:16.4,prod-pg-0=spilo:16.4" {
+		t.Errorf("unexpected image updates: %s", got)
+	}
+	if len(rc.restarts) != 0 {
+		t.Errorf("an upgrade must not restart through Patroni, got %v", rc.restarts)
+	}
+}
+
+func TestRolling_UpgradeWaitsForStaleEntryToChange(t *testing.T) {
+	rc := newRollingCluster(t, "prod-pg-0", "prod-pg-1")
+	opts := rollingOptions(t, lifecycle.RollingUpgrade)
+	opts.Image = "spilo:16.4"
+	opts.Timeout = 50 * time.Millisecond
+
+	p := &imageProvider{fakeProvider: newFakeProvider()}
+	_, err := lifecycle.Rolling(context.Background(), p, rc.client(), &cluster.Entry{Name: "prod"}, opts)
+	if err == nil || !strings.Contains(err.Error(), "postmaster still started") {
+		t.Fatalf("expected the stale streaming entry not to count, got %v", err)
+	}
+	if got := strings.Join(p.updates, ","); got != "prod-pg-1=spilo:16.4" {
+		t.Errorf("expected to stop after the first member, got %s", got)
+	}
+}
+
+func TestRolling_AcceptsRunningReplicas(t *testing.T) {
+	rc := newRollingCluster(t, "prod-pg-0", "prod-pg-1")
+	rc.members[1].State = patroni.StateRunning // Patroni before 3.0
+	opts := rollingOptions(t, lifecycle.RollingRestart)
+
+	if _, err := lifecycle.Rolling(context.Background(), nil, rc.client(), &cluster.Entry{Name: "prod"}, opts); err != nil {
+		t.Fatalf("Rolling: %v", err)
+	}
+}
+
+func TestClusterRollingRestart_UsesClusterLagPolicy(t *testing.T) {
+	rc := newRollingCluster(t, "prod-pg-0", "prod-pg-1", "prod-pg-2")
+	regPath := filepath.Join(t.TempDir(), "clusters.json")
+	if err := cluster.NewRegistry(regPath).Add(cluster.Entry{Name: "prod", PatroniURL: rc.client().URL(),
+		Source: cluster.SourceExternal, Failover: &cluster.FailoverPolicy{MaxLagBytes: 50}}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := executeClusterCmdWithRegistry(t, regPath, "cluster", "rolling-restart", "--name", "prod", "--timeout", "100ms")
+	if err == nil || !strings.Contains(err.Error(), "lag 100 bytes exceeds 50") {
+		t.Fatalf("expected the cluster's 50-byte lag policy enforced, got %v", err)
+	}
+
+	if err := executeClusterCmdWithRegistry(t, regPath, "cluster", "rolling-restart", "--name", "prod",
+		"--max-lag-bytes", "1000"); err != nil {
+		t.Fatalf("expected --max-lag-bytes to override the policy, got %v", err)
+	}
+}
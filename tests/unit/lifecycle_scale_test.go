@@ -0,0 +1,216 @@
+package unit_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/luckyjian/pgdba/internal/cluster"
+	"github.com/luckyjian/pgdba/internal/lifecycle"
+	"github.com/luckyjian/pgdba/internal/patroni"
+)
+
+// scalePatroni serves /cluster from the given members plus every other
+// "<cluster>-pg-N" node of p, reported as streaming when streaming is true.
+func scalePatroni(t *testing.T, p *fakeProvider, members []map[string]interface{}, streaming bool) *patroni.Client {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		known := map[string]bool{}
+		all := append([]map[string]interface{}(nil), members...)
+		for _, m := range members {
+			known[m["name"].(string)] = true
+		}
+		p.mu.Lock()
+		for id := range p.nodes {
+			if strings.Contains(id, "-pg-") && !known[id] {
+				state := "starting"
+				if streaming {
+					state = "streaming"
+				}
+				all = append(all, map[string]interface{}{"name": id, "role": "replica", "state": state})
+			}
+		}
+		p.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]interface{}{"members": all}) //nolint:errcheck
+	}))
+	t.Cleanup(srv.Close)
+	return patroni.NewClient(srv.URL)
+}
+
+func scaleOptions(replicas int) lifecycle.ScaleOptions {
+	return lifecycle.ScaleOptions{
+		Replicas: replicas,
+		Passwords: func() (lifecycle.Passwords, error) {
+			return lifecycle.Passwords{Superuser: "su", Replication: "repl", Rewind: "rw"}, nil
+		},
+		Timeout:      time.Second,
+		PollInterval: 10 * time.Millisecond,
+	}
+}
+
+func TestScaleDownVictims(t *testing.T) {
+	cs := &patroni.ClusterStatus{Members: []patroni.Member{
+		{Name: "pg-0", Role: "leader", State: patroni.StateRunning},
+		{Name: "pg-1", Role: "replica", State: patroni.StateStreaming, Lag: 0},
+		{Name: "pg-2", Role: "replica", State: patroni.StateStreaming, Lag: 500},
+		{Name: "pg-3", Role: "replica", State: patroni.StateStopped, Lag: 0},
+		{Name: "pg-4", Role: "sync_standby", State: patroni.StateStreaming, Lag: 1000},
+	}}
+
+	victims, err := lifecycle.ScaleDownVictims(cs, 2)
+	if err != nil {
+		t.Fatalf("ScaleDownVictims: %v", err)
+	}
+	if got := strings.Join(victims, ","); got != "pg-3,pg-2" {
+		t.Errorf("expected stopped then most-lagged replica, got %s", got)
+	}
+
+	if _, err := lifecycle.ScaleDownVictims(cs, 3); err == nil {
+		t.Error("expected an error when only candidates are left to remove")
+	}
+
+	victims, err = lifecycle.ScaleDownVictims(cs, 4)
+	if err != nil || len(victims) != 4 {
+		t.Errorf("expected all replicas removable when scaling to zero, got %v, %v", victims, err)
+	}
+}
+
+func TestScale_AddsMembersAndWaitsForStreaming(t *testing.T) {
+	p := newFakeProvider()
+	reg := seedCluster(t, p, "prod", 1)
+	entry, _ := reg.Get("prod")
+	entry.EtcdEndpoints = []string{"10.0.1.1:2379", "10.0.1.2:2379", "10.0.1.3:2379"}
+	client := scalePatroni(t, p, []map[string]interface{}{
+		{"name": "prod-pg-0", "role": "leader", "state": "running"},
+	}, true)
+
+	opts := scaleOptions(3)
+	opts.Hosts = []string{"10.0.0.12"}
+	result, err := lifecycle.Scale(context.Background(), p, client, entry, opts)
+	if err != nil {
+		t.Fatalf("Scale: %v", err)
+	}
+	if result.From != 1 || strings.Join(result.Added, ",") != "prod-pg-2,prod-pg-3" {
+		t.Errorf("unexpected result: %+v", result)
+	}
+	cfg := p.configs["prod-pg-2"]
+	if cfg.Host != "10.0.0.12" || p.configs["prod-pg-3"].Host != "prod-pg-3" {
+		t.Errorf("unexpected hosts: %s, %s", cfg.Host, p.configs["prod-pg-3"].Host)
+	}
+	if cfg.Env["ETCD3_HOSTS"] != "10.0.1.1:2379,10.0.1.2:2379,10.0.1.3:2379" {
+		t.Errorf("expected recorded etcd endpoints, got %s", cfg.Env["ETCD3_HOSTS"])
+	}
+	if !strings.Contains(cfg.Files[lifecycle.PatroniConfigPath], "name: prod-pg-2") {
+		t.Error("expected a rendered patroni.yml for the new member")
+	}
+}
+
+func TestScale_WaitTimeout(t *testing.T) {
+	p := newFakeProvider()
+	reg := seedCluster(t, p, "prod", 0)
+	entry, _ := reg.Get("prod")
+	client := scalePatroni(t, p, []map[string]interface{}{
+		{"name": "prod-pg-0", "role": "leader", "state": "running"},
+	}, false)
+
+	opts := scaleOptions(1)
+	opts.Timeout = 50 * time.Millisecond
+	result, err := lifecycle.Scale(context.Background(), p, client, entry, opts)
+	if err == nil || !strings.Contains(err.Error(), "not streaming") {
+		t.Fatalf("expected a streaming timeout, got %v", err)
+	}
+	if len(result.Added) != 1 {
+		t.Errorf("expected the created member to be reported, got %+v", result.Added)
+	}
+}
+
+func TestScale_RemovesLaggedMembersAndDCSKeys(t *testing.T) {
+	p := newFakeProvider()
+	reg := seedCluster(t, p, "prod", 2)
+	entry, _ := reg.Get("prod")
+	client := scalePatroni(t, p, []map[string]interface{}{
+		{"name": "prod-pg-0", "role": "leader", "state": "running"},
+		{"name": "prod-pg-1", "role": "replica", "state": "streaming", "lag": 900},
+		{"name": "prod-pg-2", "role": "replica", "state": "streaming", "lag": 0},
+	}, true)
+
+	result, err := lifecycle.Scale(context.Background(), p, client, entry, scaleOptions(1))
+	if err != nil {
+		t.Fatalf("Scale: %v", err)
+	}
+	if strings.Join(result.Removed, ",") != "prod-pg-1" || strings.Join(p.destroyed, ",") != "prod-pg-1" {
+		t.Errorf("expected the lagged prod-pg-1 removed, got %v", result.Removed)
+	}
+	if len(p.execs) != 1 || p.execs[0] != "prod-etcd-0: etcdctl del /db/prod/members/prod-pg-1" {
+		t.Errorf("expected member key cleanup on etcd, got %v", p.execs)
+	}
+}
+
+func TestScale_KubernetesRemovesHighestOrdinals(t *testing.T) {
+	p := newFakeProvider()
+	reg := seedCluster(t, p, "prod", 2)
+	entry, _ := reg.Get("prod")
+	client := scalePatroni(t, p, []map[string]interface{}{
+		{"name": "prod-pg-0", "role": "leader", "state": "running"},
+		{"name": "prod-pg-1", "role": "replica", "state": "streaming", "lag": 900},
+		{"name": "prod-pg-2", "role": "replica", "state": "streaming", "lag": 0},
+	}, true)
+
+	result, err := lifecycle.Scale(context.Background(), kubernetesTypedProvider{p}, client, entry, scaleOptions(1))
+	if err != nil {
+		t.Fatalf("Scale: %v", err)
+	}
+	if strings.Join(result.Removed, ",") != "prod-pg-2" || strings.Join(p.destroyed, ",") != "prod-pg-2" {
+		t.Errorf("expected the highest ordinal prod-pg-2 removed despite the lag of prod-pg-1, got %v", result.Removed)
+	}
+}
+
+func TestScale_KubernetesRefusesToRemoveLeader(t *testing.T) {
+	p := newFakeProvider()
+	reg := seedCluster(t, p, "prod", 2)
+	entry, _ := reg.Get("prod")
+	client := scalePatroni(t, p, []map[string]interface{}{
+		{"name": "prod-pg-0", "role": "replica", "state": "streaming"},
+		{"name": "prod-pg-1", "role": "replica", "state": "streaming"},
+		{"name": "prod-pg-2", "role": "leader", "state": "running"},
+	}, true)
+
+	_, err := lifecycle.Scale(context.Background(), kubernetesTypedProvider{p}, client, entry, scaleOptions(1))
+	if err == nil || !strings.Contains(err.Error(), "prod-pg-2") || !strings.Contains(err.Error(), "switch over") {
+		t.Fatalf("expected removing the leader refused, got %v", err)
+	}
+	if len(p.destroyed) != 0 {
+		t.Errorf("expected nothing destroyed, got %v", p.destroyed)
+	}
+}
+
+func TestWaitForStreaming_AcceptsRunningReplicas(t *testing.T) {
+	p := newFakeProvider()
+	client := scalePatroni(t, p, []map[string]interface{}{
+		{"name": "prod-pg-0", "role": "leader", "state": "running"},
+		{"name": "prod-pg-1", "role": "replica", "state": "running"},
+	}, false)
+	if err := lifecycle.WaitForStreaming(context.Background(), client, []string{"prod-pg-1"}, time.Second, 10*time.Millisecond); err != nil {
+		t.Errorf("expected a running replica (Patroni before 3.0) accepted, got %v", err)
+	}
+}
+
+func TestScale_RejectsExternalCluster(t *testing.T) {
+	entry := &cluster.Entry{Name: "ext", Source: cluster.SourceExternal}
+	if _, err := lifecycle.Scale(context.Background(), newFakeProvider(), nil, entry, scaleOptions(1)); err == nil {
+		t.Error("expected external cluster to be rejected")
+	}
+}
+
+func TestClusterScale_RequiresReplicas(t *testing.T) {
+	regPath := filepath.Join(t.TempDir(), "clusters.json")
+	if err := executeClusterCmdWithRegistry(t, regPath, "cluster", "scale", "--name", "prod"); err == nil ||
+		!strings.Contains(err.Error(), "--replicas") {
+		t.Errorf("expected --replicas to be required, got %v", err)
+	}
+}
@@ -0,0 +1,178 @@
+package unit_test
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/luckyjian/pgdba/internal/cluster"
+	"github.com/luckyjian/pgdba/internal/lifecycle"
+	"github.com/luckyjian/pgdba/internal/provider"
+)
+
+// purgingProvider adds provider.DataPurger to fakeProvider.
+type purgingProvider struct {
+	*fakeProvider
+	purged []string
+}
+
+func (p *purgingProvider) PurgeNodeData(_ context.Context, id string) error {
+	p.purged = append(p.purged, id)
+	return nil
+}
+
+// seedCluster creates the nodes of a bootstrapped cluster in p and registers it.
+func seedCluster(t *testing.T, p *fakeProvider, name string, replicas int) *cluster.Registry {
+	t.Helper()
+	ctx := context.Background()
+	label := map[string]string{provider.LabelCluster: name}
+	for i := 0; i < lifecycle.EtcdMembers; i++ {
+		p.CreateNode(ctx, provider.NodeConfig{Name: lifecycle.EtcdNodeName(name, i), Role: provider.RoleEtcd, Labels: label}) //nolint:errcheck
+	}
+	for i := 0; i <= replicas; i++ {
+		p.CreateNode(ctx, provider.NodeConfig{Name: lifecycle.PGNodeName(name, i), Role: provider.RoleStandby, Labels: label}) //nolint:errcheck
+	}
+	reg := cluster.NewRegistry(filepath.Join(t.TempDir(), "clusters.json"))
+	if err := reg.Add(cluster.Entry{Name: name, Provider: p.Type(), Source: cluster.SourceManaged}); err != nil {
+		t.Fatal(err)
+	}
+	return reg
+}
+
+func TestTeardown_DestroysClusterNodesInOrder(t *testing.T) {
+	p := newFakeProvider()
+	reg := seedCluster(t, p, "prod", 2)
+	p.CreateNode(context.Background(), provider.NodeConfig{Name: "other-pg-0", //nolint:errcheck
+		Labels: map[string]string{provider.LabelCluster: "other"}})
+
+	result, err := lifecycle.Teardown(context.Background(), p, reg, "prod", lifecycle.TeardownOptions{})
+	if err != nil {
+		t.Fatalf("Teardown: %v", err)
+	}
+	want := "prod-pg-2,prod-pg-1,prod-pg-0,prod-etcd-2,prod-etcd-1,prod-etcd-0"
+	if got := strings.Join(p.destroyed, ","); got != want {
+		t.Errorf("expected destroy order %s, got %s", want, got)
+	}
+	if len(result.Nodes) != 6 || result.Failed != 0 || !result.RegistryRemoved {
+		t.Errorf("unexpected result: %+v", result)
+	}
+	for _, n := range result.Nodes {
+		if !n.Destroyed || n.DataPurged {
+			t.Errorf("%s: expected destroyed without purge, got %+v", n.Node, n)
+		}
+	}
+	if _, err := reg.Get("prod"); err == nil {
+		t.Error("expected registry entry to be removed")
+	}
+}
+
+func TestTeardown_PartialFailureKeepsEntry(t *testing.T) {
+	p := newFakeProvider()
+	reg := seedCluster(t, p, "prod", 1)
+	p.failOn["prod-pg-1"] = fmt.Errorf("container busy")
+
+	result, err := lifecycle.Teardown(context.Background(), p, reg, "prod", lifecycle.TeardownOptions{})
+	if err == nil || !strings.Contains(err.Error(), "1 failure") {
+		t.Fatalf("expected incomplete teardown error, got %v", err)
+	}
+	if len(p.destroyed) != 4 {
+		t.Errorf("remaining nodes should still be destroyed, got %v", p.destroyed)
+	}
+	var failed *lifecycle.NodeTeardown
+	for i := range result.Nodes {
+		if result.Nodes[i].Node == "prod-pg-1" {
+			failed = &result.Nodes[i]
+		}
+	}
+	if failed == nil || failed.Destroyed || !strings.Contains(failed.Error, "container busy") {
+		t.Errorf("expected per-node failure for prod-pg-1, got %+v", failed)
+	}
+	if _, err := reg.Get("prod"); err != nil {
+		t.Error("registry entry must be kept after a failed teardown")
+	}
+
+	result, err = lifecycle.Teardown(context.Background(), p, reg, "prod", lifecycle.TeardownOptions{Force: true})
+	if err != nil {
+		t.Fatalf("forced teardown: %v", err)
+	}
+	if !result.RegistryRemoved || result.Failed != 1 {
+		t.Errorf("expected forced removal with 1 failure, got %+v", result)
+	}
+}
+
+func TestTeardown_RefusesNodesOfUnknownOwner(t *testing.T) {
+	p := newFakeProvider()
+	reg := seedCluster(t, p, "prod", 0)
+	p.CreateNode(context.Background(), provider.NodeConfig{Name: "pg-9", //nolint:errcheck
+		Labels: map[string]string{provider.LabelOwnerUnknown: "true"}})
+
+	_, err := lifecycle.Teardown(context.Background(), p, reg, "prod", lifecycle.TeardownOptions{})
+	if err == nil || !strings.Contains(err.Error(), "cannot tell whether pg-9 belong") {
+		t.Fatalf("expected a node of unknown owner to stop the teardown, got %v", err)
+	}
+	if len(p.destroyed) != 0 {
+		t.Errorf("expected nothing destroyed, got %v", p.destroyed)
+	}
+
+	result, err := lifecycle.Teardown(context.Background(), p, reg, "prod", lifecycle.TeardownOptions{Force: true})
+	if err != nil {
+		t.Fatalf("forced teardown: %v", err)
+	}
+	if len(p.destroyed) != 4 || strings.Contains(strings.Join(p.destroyed, ","), "pg-9") || result.Failed != 1 {
+		t.Errorf("expected only the cluster's own nodes destroyed, got %v (%+v)", p.destroyed, result)
+	}
+}
+
+func TestTeardown_PurgeData(t *testing.T) {
+	p := &purgingProvider{fakeProvider: newFakeProvider()}
+	reg := seedCluster(t, p.fakeProvider, "prod", 0)
+
+	result, err := lifecycle.Teardown(context.Background(), p, reg, "prod", lifecycle.TeardownOptions{PurgeData: true})
+	if err != nil {
+		t.Fatalf("Teardown: %v", err)
+	}
+	if len(p.purged) != 4 {
+		t.Errorf("expected every node to be purged, got %v", p.purged)
+	}
+	for _, n := range result.Nodes {
+		if !n.DataPurged {
+			t.Errorf("%s: expected data_purged", n.Node)
+		}
+	}
+}
+
+func TestTeardown_PurgeUnsupported(t *testing.T) {
+	p := newFakeProvider()
+	reg := seedCluster(t, p, "prod", 0)
+	_, err := lifecycle.Teardown(context.Background(), p, reg, "prod", lifecycle.TeardownOptions{PurgeData: true})
+	if err == nil || !strings.Contains(err.Error(), "cannot purge") {
+		t.Fatalf("expected purge unsupported error, got %v", err)
+	}
+	if len(p.destroyed) != 0 {
+		t.Error("nothing should be destroyed when purge is unsupported")
+	}
+}
+
+func TestTeardown_RefusesExternalCluster(t *testing.T) {
+	p := newFakeProvider()
+	reg := cluster.NewRegistry(filepath.Join(t.TempDir(), "clusters.json"))
+	reg.Add(cluster.Entry{Name: "ext", Source: cluster.SourceExternal}) //nolint:errcheck
+	if _, err := lifecycle.Teardown(context.Background(), p, reg, "ext", lifecycle.TeardownOptions{Force: true}); err == nil {
+		t.Fatal("expected external clusters to be refused even with force")
+	}
+}
+
+func TestTeardownOrder_NumericOrdinals(t *testing.T) {
+	nodes := []provider.NodeStatus{
+		{ID: "c-pg-9"}, {ID: "c-etcd-0", Role: provider.RoleEtcd}, {ID: "c-pg-10"}, {ID: "c-pgbouncer-0"},
+	}
+	var got []string
+	for _, n := range lifecycle.TeardownOrder(nodes) {
+		got = append(got, n.ID)
+	}
+	if strings.Join(got, ",") != "c-pgbouncer-0,c-pg-10,c-pg-9,c-etcd-0" {
+		t.Errorf("unexpected order %v", got)
+	}
+}
@@ -0,0 +1,266 @@
+package unit_test
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/luckyjian/pgdba/internal/cluster"
+	"github.com/luckyjian/pgdba/internal/lifecycle"
+	"github.com/luckyjian/pgdba/internal/upgrade"
+)
+
+func majorUpgradeOptions(method string) lifecycle.MajorUpgradeOptions {
+	return lifecycle.MajorUpgradeOptions{
+		From:         16,
+		To:           17,
+		Method:       method,
+		Timeout:      time.Second,
+		PollInterval: 10 * time.Millisecond,
+	}
+}
+
+// execsMatching returns the recorded execs containing substr.
+func execsMatching(p *fakeProvider, substr string) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var out []string
+	for _, e := range p.execs {
+		if strings.Contains(e, substr) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+func TestUpgradeMajor_LinkUpgradesLeaderAndReclonesReplicas(t *testing.T) {
+	p := newFakeProvider()
+	reg := seedCluster(t, p, "prod", 2)
+	entry, _ := reg.Get("prod")
+	rc := newRollingCluster(t, "prod-pg-0", "prod-pg-1", "prod-pg-2")
+
+	result, err := lifecycle.UpgradeMajor(context.Background(), p, reg, rc.client(), entry,
+		majorUpgradeOptions(upgrade.MethodLink))
+	if err != nil {
+		t.Fatalf("UpgradeMajor: %v", err)
+	}
+	if result.Leader != "prod-pg-0" {
+		t.Errorf("expected prod-pg-0 to lead after the upgrade, got %q", result.Leader)
+	}
+
+	check := execsMatching(p, "--check")
+	if len(check) != 1 || !strings.HasPrefix(check[0], "prod-pg-0: ") ||
+		!strings.Contains(check[0], "-b /usr/lib/postgresql/16/bin -B /usr/lib/postgresql/17/bin") {
+		t.Errorf("expected pg_upgrade --check on the leader, got %v", check)
+	}
+	var stopped []string
+	for _, e := range execsMatching(p, "pg_ctl stop") {
+		stopped = append(stopped, strings.SplitN(e, ":", 2)[0])
+	}
+	if got := strings.Join(stopped, ","); got != "prod-pg-1,prod-pg-2,prod-pg-0" {
+		t.Errorf("expected replicas stopped before the leader, got %s", got)
+	}
+	if link := execsMatching(p, "--link"); len(link) != 1 || !strings.HasPrefix(link[0], "prod-pg-0: ") {
+		t.Errorf("expected pg_upgrade --link on the leader only, got %v", link)
+	}
+	for _, tool := range []string{"initdb", "pg_ctl stop", "--link", "vacuumdb"} {
+		for _, e := range execsMatching(p, tool) {
+			if !strings.Contains(e, `root) exec su postgres -s /bin/sh -c "$1"`) {
+				t.Errorf("expected %s run as the postgres OS user, got %s", tool, e)
+			}
+		}
+	}
+	sed := execsMatching(p, lifecycle.PatroniConfigPath)
+	if len(sed) != 3 || !strings.Contains(sed[0], lifecycle.SpiloConfigPath) {
+		t.Errorf("expected bin_dir rewritten in both configs on every member, got %v", sed)
+	}
+	if len(rc.reloads) != 3 {
+		t.Errorf("expected every member reloaded, got %v", rc.reloads)
+	}
+	if del := execsMatching(p, "etcdctl del /db/prod/initialize"); len(del) != 1 {
+		t.Errorf("expected the initialize key reset, got %v", p.execs)
+	}
+	var wiped []string
+	for _, e := range execsMatching(p, "-mindepth 1 -delete") {
+		wiped = append(wiped, strings.SplitN(e, ":", 2)[0])
+	}
+	if got := strings.Join(wiped, ","); got != "prod-pg-1,prod-pg-2" {
+		t.Errorf("expected only replicas wiped for re-clone, got %s", got)
+	}
+	if got := strings.Join(rc.patches, ";"); got != `{"pause":true};{"pause":false}` {
+		t.Errorf("expected pause then resume, got %s", got)
+	}
+
+	updated, _ := reg.Get("prod")
+	if updated.PGVersion != 17 {
+		t.Errorf("expected registry to record version 17, got %d", updated.PGVersion)
+	}
+}
+
+func TestUpgradeMajor_LinkCheckFailureLeavesClusterRunning(t *testing.T) {
+	p := newFakeProvider()
+	reg := seedCluster(t, p, "prod", 1)
+	entry, _ := reg.Get("prod")
+	rc := newRollingCluster(t, "prod-pg-0", "prod-pg-1")
+	p.execFn = func(id string, cmd []string) (string, error) {
+		if strings.Contains(strings.Join(cmd, " "), "--check") {
+			return "", fmt.Errorf("pg_upgrade: incompatible extension")
+		}
+		return "", nil
+	}
+
+	_, err := lifecycle.UpgradeMajor(context.Background(), p, reg, rc.client(), entry,
+		majorUpgradeOptions(upgrade.MethodLink))
+	if err == nil || !strings.Contains(err.Error(), "upgrade_check") {
+		t.Fatalf("expected the check step to fail, got %v", err)
+	}
+	if len(rc.patches) != 0 || len(execsMatching(p, "pg_ctl stop")) != 0 {
+		t.Errorf("expected nothing paused or stopped, got patches %v", rc.patches)
+	}
+}
+
+func TestUpgradeMajor_LinkFailureRestoresOldCluster(t *testing.T) {
+	p := newFakeProvider()
+	reg := seedCluster(t, p, "prod", 2)
+	entry, _ := reg.Get("prod")
+	rc := newRollingCluster(t, "prod-pg-0", "prod-pg-1", "prod-pg-2")
+	p.execFn = func(id string, cmd []string) (string, error) {
+		if strings.Contains(strings.Join(cmd, " "), "--link") {
+			return "", fmt.Errorf("pg_upgrade: could not link file")
+		}
+		return "", nil
+	}
+
+	result, err := lifecycle.UpgradeMajor(context.Background(), p, reg, rc.client(), entry,
+		majorUpgradeOptions(upgrade.MethodLink))
+	if err == nil || !strings.Contains(err.Error(), "pg_upgrade") {
+		t.Fatalf("expected the pg_upgrade step to fail, got %v", err)
+	}
+	restore := execsMatching(p, "pg_control.old")
+	if len(restore) != 1 || !strings.HasPrefix(restore[0], "prod-pg-0: ") ||
+		!strings.Contains(restore[0], "/.pgdba-upgrade/16/*") {
+		t.Errorf("expected the old data directory moved back on the leader, got %v", restore)
+	}
+	var started []string
+	for _, e := range execsMatching(p, "/usr/lib/postgresql/16/bin/pg_ctl start") {
+		started = append(started, strings.SplitN(e, ":", 2)[0])
+	}
+	if got := strings.Join(started, ","); got != "prod-pg-0,prod-pg-1,prod-pg-2" {
+		t.Errorf("expected the old binaries started, leader first, got %s", got)
+	}
+	if got := strings.Join(rc.patches, ";"); got != `{"pause":true};{"pause":false}` {
+		t.Errorf("expected Patroni resumed, got %s", got)
+	}
+	if len(execsMatching(p, lifecycle.PatroniConfigPath)) != 0 {
+		t.Error("expected patroni.yml left untouched")
+	}
+	last := result.Events[len(result.Events)-1]
+	if last.Step != lifecycle.StepRollback || last.Status != lifecycle.StatusDone {
+		t.Errorf("expected a completed rollback event last, got %+v", last)
+	}
+}
+
+// kubernetesTypedProvider is a fakeProvider that reports the kubernetes type.
+type kubernetesTypedProvider struct{ *fakeProvider }
+
+func (kubernetesTypedProvider) Type() string { return "kubernetes" }
+
+func TestUpgradeMajor_LinkRejectsKubernetes(t *testing.T) {
+	p := newFakeProvider()
+	reg := seedCluster(t, p, "prod", 1)
+	entry, _ := reg.Get("prod")
+	rc := newRollingCluster(t, "prod-pg-0", "prod-pg-1")
+
+	_, err := lifecycle.UpgradeMajor(context.Background(), kubernetesTypedProvider{p}, reg, rc.client(), entry,
+		majorUpgradeOptions(upgrade.MethodLink))
+	if err == nil || !strings.Contains(err.Error(), "kubernetes") {
+		t.Fatalf("expected the link method refused on kubernetes, got %v", err)
+	}
+	if len(p.execs) != 0 || len(rc.patches) != 0 {
+		t.Errorf("expected nothing run, got execs %v patches %v", p.execs, rc.patches)
+	}
+}
+
+func TestUpgradeMajor_LinkRejectsExternalCluster(t *testing.T) {
+	entry := &cluster.Entry{Name: "ext", Source: cluster.SourceExternal}
+	if _, err := lifecycle.UpgradeMajor(context.Background(), newFakeProvider(), nil, nil, entry,
+		majorUpgradeOptions(upgrade.MethodLink)); err == nil {
+		t.Error("expected external cluster to be rejected")
+	}
+}
+
+func TestUpgradeMajor_LogicalReplicatesIntoNewCluster(t *testing.T) {
+	p := newFakeProvider()
+	p.execFn = func(id string, cmd []string) (string, error) { return "t\n", nil }
+	reg := cluster.NewRegistry(filepath.Join(t.TempDir(), "clusters.json"))
+	entry := &cluster.Entry{Name: "prod", PGHost: "10.0.0.5", PGPort: 5432, Source: cluster.SourceExternal}
+	rc := newRollingCluster(t, "prod-pg-0", "prod-pg-1")
+	_, port := leaderPatroni(t, "prod-pg17-pg-0", func() bool { return true })
+
+	opts := majorUpgradeOptions(upgrade.MethodLogical)
+	opts.Image = "spilo-17:4.0"
+	opts.Databases = []string{"postgres", "app"}
+	opts.Passwords = lifecycle.Passwords{Superuser: "su", Replication: "repl", Rewind: "rw"}
+	opts.Target = lifecycle.BootstrapOptions{ClusterName: "prod-pg17", PrimaryHost: "10.0.1.10",
+		PatroniPort: port, PollInterval: 10 * time.Millisecond}
+
+	result, err := lifecycle.UpgradeMajor(context.Background(), p, reg, rc.client(), entry, opts)
+	if err != nil {
+		t.Fatalf("UpgradeMajor: %v", err)
+	}
+	if result.Target != "prod-pg17" || result.Leader != "prod-pg17-pg-0" {
+		t.Errorf("unexpected result: %+v", result)
+	}
+
+	target, err := reg.Get("prod-pg17")
+	if err != nil || target.PGVersion != 17 || target.Image != "spilo-17:4.0" {
+		t.Fatalf("expected the new cluster registered on 17, got %+v (%v)", target, err)
+	}
+	cfg := p.configs["prod-pg17-pg-0"]
+	if cfg.Image != "spilo-17:4.0" || !strings.Contains(cfg.Files[lifecycle.PatroniConfigPath], "/usr/lib/postgresql/17/bin") {
+		t.Error("expected the new cluster to run the 17 image and binaries")
+	}
+
+	roles := execIndex(p, "pg_dumpall -h '10.0.0.5' -p 5432 -U postgres -d 'postgres' --roles-only")
+	if roles < 0 || roles > execIndex(p, "--schema-only") {
+		t.Errorf("expected roles copied before the schema, got %v", p.execs)
+	}
+	if got := execsMatching(p, "CREATE DATABASE"); len(got) != 1 || !strings.Contains(got[0], `"app"`) {
+		t.Errorf("expected only app to be created, got %v", got)
+	}
+	if got := execsMatching(p, "CREATE PUBLICATION"); len(got) != 2 || !strings.Contains(got[0], "-h 10.0.0.5") {
+		t.Errorf("expected a publication per database on the old cluster, got %v", got)
+	}
+	sub := execsMatching(p, "CREATE SUBSCRIPTION")
+	if len(sub) != 2 || !strings.Contains(sub[1], "slot_name = 'pgdba_upgrade_1'") {
+		t.Errorf("expected a subscription with its own slot per database, got %v", sub)
+	}
+	for _, ev := range result.Events {
+		if strings.Contains(ev.Message, "password") {
+			t.Errorf("expected the superuser password kept out of events, got %+v", ev)
+		}
+	}
+	if len(rc.patches) != 1 || !strings.Contains(rc.patches[0], `"default_transaction_read_only":"on"`) {
+		t.Errorf("expected the old cluster made read-only, got %v", rc.patches)
+	}
+	if got := execsMatching(p, "pg_terminate_backend"); len(got) != 1 || !strings.Contains(got[0], "-h 10.0.0.5") {
+		t.Errorf("expected client sessions on the old cluster ended, got %v", got)
+	}
+	if got := execsMatching(p, "setval"); len(got) != 2 {
+		t.Errorf("expected sequences copied per database, got %v", got)
+	}
+	if got := execsMatching(p, "DROP SUBSCRIPTION"); len(got) != 2 {
+		t.Errorf("expected subscriptions dropped, got %v", got)
+	}
+}
+
+func TestClusterUpgradeMajor_RequiresTo(t *testing.T) {
+	regPath := filepath.Join(t.TempDir(), "clusters.json")
+	if err := executeClusterCmdWithRegistry(t, regPath, "cluster", "upgrade-major", "--name", "prod"); err == nil ||
+		!strings.Contains(err.Error(), "--to") {
+		t.Errorf("expected --to to be required, got %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+package unit_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/luckyjian/pgdba/internal/replication"
+)
+
+// fakeLogicalDB is a replication.LogicalDB over fixed catalogs.
+type fakeLogicalDB struct {
+	pubs  []replication.Publication
+	subs  []replication.Subscription
+	slots []replication.Slot
+}
+
+func (f *fakeLogicalDB) ServerVersionNum(ctx context.Context) (int, error) { return 160002, nil }
+func (f *fakeLogicalDB) Slots(ctx context.Context, walStatus bool) ([]replication.Slot, error) {
+	return f.slots, nil
+}
+func (f *fakeLogicalDB) Publications(ctx context.Context) ([]replication.Publication, error) {
+	return f.pubs, nil
+}
+func (f *fakeLogicalDB) Subscriptions(ctx context.Context) ([]replication.Subscription, error) {
+	return f.subs, nil
+}
+func (f *fakeLogicalDB) Exec(ctx context.Context, sql string) error { return nil }
+func (f *fakeLogicalDB) Close(ctx context.Context) error            { return nil }
+
+func TestLogicalSQL(t *testing.T) {
+	cases := []struct{ got, want string }{
+		{replication.CreatePublicationSQL("app_pub", nil), `CREATE PUBLICATION "app_pub" FOR ALL TABLES`},
+		{replication.CreatePublicationSQL("app_pub", []string{"orders", "sales.Items"}),
+			`CREATE PUBLICATION "app_pub" FOR TABLE "orders", "sales"."Items"`},
+		{replication.CreateSubscriptionSQL("app_sub", "host=pg-0 password=it's", []string{"app_pub", "more"},
+			replication.SubscriptionOptions{CopyData: true, SlotName: "app_slot"}),
+			`CREATE SUBSCRIPTION "app_sub" CONNECTION 'host=pg-0 password=it''s' PUBLICATION "app_pub", "more" WITH (copy_data = true, slot_name = 'app_slot')`},
+		{replication.RefreshSubscriptionSQL("app_sub", false),
+			`ALTER SUBSCRIPTION "app_sub" REFRESH PUBLICATION WITH (copy_data = false)`},
+		{replication.DropSubscriptionSQL(`we"ird`), `DROP SUBSCRIPTION "we""ird"`},
+	}
+	for _, tc := range cases {
+		if tc.got != tc.want {
+			t.Errorf("got  %s\nwant %s", tc.got, tc.want)
+		}
+	}
+}
+
+func TestTableStateName(t *testing.T) {
+	for code, want := range map[string]string{"i": "init", "d": "data copy", "f": "finished copy", "s": "synchronized", "r": "ready"} {
+		if got := replication.TableStateName(code); got != want {
+			t.Errorf("TableStateName(%q) = %q, want %q", code, got, want)
+		}
+	}
+}
+
+func TestLogicalStatus(t *testing.T) {
+	pid := int32(77)
+	src := &fakeLogicalDB{
+		pubs:  []replication.Publication{{Name: "app_pub", AllTables: true}},
+		slots: []replication.Slot{{Name: "app_sub", Type: "logical", Active: true, PendingBytes: 4096}},
+	}
+	dst := &fakeLogicalDB{subs: []replication.Subscription{
+		{Name: "app_sub", Enabled: true, SlotName: "app_sub", Publications: []string{"app_pub", "gone_pub"},
+			Tables: []replication.SubscriptionTable{
+				{Table: "public.orders", State: replication.TableReady},
+				{Table: "public.items", State: replication.TableDataCopy},
+			},
+			Workers: []replication.SubscriptionWorker{{PID: &pid}, {PID: &pid, Table: "public.items"}}},
+		{Name: "paused_sub", SlotName: "paused_sub", Publications: []string{"app_pub"}},
+		{Name: "other_cluster", Enabled: true, SlotName: "x", Publications: []string{"elsewhere"}},
+	}}
+
+	st, err := replication.Status(context.Background(), src, dst)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	if len(st.Subscriptions) != 2 {
+		t.Fatalf("expected the subscription of another cluster skipped, got %+v", st.Subscriptions)
+	}
+	app := st.Subscriptions[0]
+	if app.Synced || app.TableStates["ready"] != 1 || app.TableStates["data copy"] != 1 ||
+		app.SourceSlot == nil || app.SourceSlot.PendingBytes != 4096 || strings.Join(app.MissingPublications, ",") != "gone_pub" {
+		t.Errorf("unexpected status %+v", app)
+	}
+	warnings := strings.Join(st.Warnings, ";")
+	for _, want := range []string{"gone_pub, not published", "paused_sub is disabled", "slot paused_sub of subscription paused_sub not found"} {
+		if !strings.Contains(warnings, want) {
+			t.Errorf("expected warning %q in %v", want, st.Warnings)
+		}
+	}
+	if strings.Contains(warnings, "app_sub has no running apply worker") {
+		t.Errorf("unexpected apply worker warning: %v", st.Warnings)
+	}
+}
+
+func TestLogical_RequiredFlags(t *testing.T) {
+	cases := map[string][]string{
+		"--pub is required":    {"logical", "pub", "create", "--name", "src"},
+		"--name is required":   {"logical", "pub", "list"},
+		"--target is required": {"logical", "sub", "create", "--name", "src", "--sub", "s", "--pub", "p"},
+		"--sub is required":    {"logical", "sub", "refresh", "--target", "dst"},
+	}
+	for want, args := range cases {
+		out, err := executeCmd(t, nil, args...)
+		if err == nil || !strings.Contains(out, want) {
+			t.Errorf("%v: expected %q, got %v\n%s", args, want, err, out)
+		}
+	}
+}
@@ -0,0 +1,324 @@
+package unit_test
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/luckyjian/pgdba/internal/cli"
+	"github.com/luckyjian/pgdba/internal/cluster"
+	"github.com/luckyjian/pgdba/internal/lifecycle"
+	"github.com/luckyjian/pgdba/internal/pgbouncer"
+	"github.com/luckyjian/pgdba/internal/upgrade"
+)
+
+const migrateINI = `[databases]
+app = host=10.0.0.1 port=5432 dbname=app pool_size=20
+legacy = host=10.0.0.1 port=5432 dbname=app
+other = host=10.0.0.1 port=5432 dbname=other
+* = host=10.0.0.1 port=5432
+
+[pgbouncer]
+listen_port = 6432
+`
+
+func TestRepointDatabases_RewritesMatchingEntries(t *testing.T) {
+	rp := pgbouncer.RepointDatabases(migrateINI, []string{"app", "billing"}, "10.0.0.9", 5433)
+
+	if got := strings.Join(rp.Changed, ","); got != "app,legacy" {
+		t.Errorf("expected app and legacy repointed, got %s", got)
+	}
+	if got := strings.Join(rp.Added, ","); got != "billing" {
+		t.Errorf("expected billing added, got %s", got)
+	}
+	for _, want := range []string{
+		"app = host=10.0.0.9 port=5433 dbname=app pool_size=20",
+		"legacy = host=10.0.0.9 port=5433 dbname=app",
+		"other = host=10.0.0.1 port=5432 dbname=other",
+		"* = host=10.0.0.1 port=5432\nbilling = host=10.0.0.9 port=5433 dbname=billing\n",
+		"[pgbouncer]\nlisten_port = 6432",
+	} {
+		if !strings.Contains(rp.INI, want) {
+			t.Errorf("expected %q in\n%s", want, rp.INI)
+		}
+	}
+}
+
+func TestRepointDatabases_AddsSectionAndKeepsQuotedValues(t *testing.T) {
+	rp := pgbouncer.RepointDatabases("[pgbouncer]\nlisten_port = 6432\n", []string{"app"}, "db", 5432)
+	if !strings.HasPrefix(rp.INI, "[databases]\napp = host=db port=5432 dbname=app\n\n[pgbouncer]") {
+		t.Errorf("expected a [databases] section first, got\n%s", rp.INI)
+	}
+
+	rp = pgbouncer.RepointDatabases("[databases]\napp = host=old application_name='my app'\n", []string{"app"}, "new", 6000)
+	if !strings.Contains(rp.INI, "app = host=new application_name='my app' port=6000") {
+		t.Errorf("expected the quoted value kept, got\n%s", rp.INI)
+	}
+}
+
+// migrateCluster answers the psql and shell commands of a migration from
+// app on 10.0.0.1 to 10.0.0.9.
+type migrateCluster struct {
+	walLevel string
+	synced   string // syncedSQL result
+	caughtUp string // confirmed_flush_lsn poll result
+	onTarget bool   // app exists on the target
+}
+
+func (c *migrateCluster) provider() *fakeProvider {
+	p := newFakeProvider()
+	p.execFn = func(id string, cmd []string) (string, error) {
+		last := cmd[len(cmd)-1]
+		switch {
+		case cmd[0] == "cat":
+			return migrateINI, nil
+		case last == "SHOW wal_level":
+			return c.walLevel, nil
+		case strings.Contains(last, "max_replication_slots"):
+			return "10", nil
+		case strings.Contains(last, "pg_subscription_rel"):
+			return c.synced, nil
+		case strings.Contains(last, "FROM pg_database"):
+			if strings.Contains(strings.Join(cmd, " "), "-h 10.0.0.9") && !c.onTarget {
+				return "0", nil
+			}
+			return "1", nil
+		case strings.Contains(last, "relreplident"):
+			return "public.events\npublic.\"audit log\"", nil
+		case strings.Contains(last, "FROM pg_tables"):
+			return "0", nil
+		case last == "SELECT pg_current_wal_lsn()":
+			return "0/3000060", nil
+		case strings.Contains(last, "confirmed_flush_lsn"):
+			return c.caughtUp, nil
+		}
+		return "", nil
+	}
+	return p
+}
+
+func migrateOptions() lifecycle.MigrateOptions {
+	return lifecycle.MigrateOptions{
+		Source:        lifecycle.MigrationEndpoint{Cluster: "old", Host: "10.0.0.1", Port: 5432},
+		Target:        lifecycle.MigrationEndpoint{Cluster: "new", Host: "10.0.0.9", Port: 5432},
+		Node:          "new-pg-0",
+		Databases:     []string{"app"},
+		Password:      "s3cret",
+		PgBouncerNode: "old-pgbouncer-0",
+		Timeout:       50 * time.Millisecond,
+		PollInterval:  5 * time.Millisecond,
+	}
+}
+
+// execIndex returns the position of the first exec containing substr, or -1.
+func execIndex(p *fakeProvider, substr string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, e := range p.execs {
+		if strings.Contains(e, substr) {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestPlanMigration_ChecksSourceAndPgBouncer(t *testing.T) {
+	c := &migrateCluster{walLevel: "replica"}
+	plan, err := lifecycle.PlanMigration(context.Background(), c.provider(), migrateOptions())
+	if err != nil {
+		t.Fatalf("PlanMigration: %v", err)
+	}
+	if plan.Ready {
+		t.Error("expected a source without wal_level=logical not to be ready")
+	}
+	status := map[string]upgrade.CheckResult{}
+	for _, check := range plan.Checks {
+		status[check.Name] = check
+	}
+	if got := status[lifecycle.CheckWALLevel].Status; got != upgrade.StatusFail {
+		t.Errorf("expected wal_level to fail, got %s", got)
+	}
+	if got := status[lifecycle.CheckSlots].Status; got != upgrade.StatusOK {
+		t.Errorf("expected 10 free slots to be enough, got %s", got)
+	}
+	identity := status[lifecycle.CheckReplicaIdentity]
+	if identity.Status != upgrade.StatusWarn || len(identity.Items) != 2 || identity.Items[1] != `app: public."audit log"` {
+		t.Errorf("expected both tables without replica identity warned about, got %+v", identity)
+	}
+	if plan.PgBouncer == nil || strings.Join(plan.PgBouncer.Changed, ",") != "app,legacy" {
+		t.Errorf("expected app and legacy to be repointed, got %+v", plan.PgBouncer)
+	}
+}
+
+func TestStartMigration_CopiesSchemaAndSubscribes(t *testing.T) {
+	c := &migrateCluster{synced: "t"}
+	p := c.provider()
+	opts := migrateOptions()
+	opts.Wait = true
+
+	res, err := lifecycle.StartMigration(context.Background(), p, opts)
+	if err != nil {
+		t.Fatalf("StartMigration: %v", err)
+	}
+	if create := execsMatching(p, `CREATE DATABASE "app"`); len(create) != 1 || !strings.Contains(create[0], "-h 10.0.0.9") {
+		t.Errorf("expected app created on the target, got %v", create)
+	}
+	if dump := execsMatching(p, "pg_dump -h '10.0.0.1'"); len(dump) != 1 || !strings.HasPrefix(dump[0], "new-pg-0: ") {
+		t.Errorf("expected the schema dumped from the source on the target leader, got %v", dump)
+	}
+	roles := execIndex(p, "pg_dumpall -h '10.0.0.1' -p 5432 -U postgres -d 'postgres' --roles-only")
+	if roles < 0 || !strings.Contains(p.execs[roles], "psql -h '10.0.0.9'") || roles > execIndex(p, "--schema-only") {
+		t.Errorf("expected the roles copied to the target before the schema, got %v", p.execs)
+	}
+	if pub := execsMatching(p, `CREATE PUBLICATION "pgdba_migrate" FOR ALL TABLES`); len(pub) != 1 {
+		t.Errorf("expected the publication created once, got %v", pub)
+	}
+	sub := execsMatching(p, `CREATE SUBSCRIPTION "pgdba_migrate"`)
+	if len(sub) != 1 || !strings.Contains(sub[0], "slot_name = 'pgdba_migrate_app'") {
+		t.Errorf("expected a subscription through slot pgdba_migrate_app, got %v", sub)
+	}
+	for _, ev := range res.Events {
+		if strings.Contains(ev.Message, "s3cret") {
+			t.Errorf("expected the password kept out of the events, got %+v", ev)
+		}
+	}
+
+	c.onTarget = true
+	p = c.provider()
+	if _, err := lifecycle.StartMigration(context.Background(), p, opts); err != nil {
+		t.Fatalf("StartMigration: %v", err)
+	}
+	if create := execsMatching(p, "CREATE DATABASE"); len(create) != 0 {
+		t.Errorf("expected an existing database not to be created, got %v", create)
+	}
+}
+
+func TestCutover_RepointsInOrder(t *testing.T) {
+	c := &migrateCluster{synced: "t", caughtUp: "t"}
+	p := c.provider()
+
+	res, err := lifecycle.Cutover(context.Background(), p, migrateOptions())
+	if err != nil {
+		t.Fatalf("Cutover: %v", err)
+	}
+	if res.CatchUpLSN != "0/3000060" {
+		t.Errorf("expected the catch-up LSN recorded, got %q", res.CatchUpLSN)
+	}
+	order := []string{"PAUSE app", "PAUSE legacy", "default_transaction_read_only = on", "pg_terminate_backend",
+		"confirmed_flush_lsn >= '0/3000060'", "setval", "base64 -d > /etc/pgbouncer/pgbouncer.ini",
+		"pkill -HUP -x pgbouncer", "RESUME app", "RESUME legacy", `DROP SUBSCRIPTION "pgdba_migrate"`,
+		`BEGIN READ WRITE; DROP PUBLICATION "pgdba_migrate"`}
+	last := -1
+	for _, step := range order {
+		i := execIndex(p, step)
+		if i <= last {
+			t.Fatalf("expected %q after the previous steps, got %v", step, p.execs)
+		}
+		last = i
+	}
+	if pause := execsMatching(p, "PAUSE app"); !strings.HasPrefix(pause[0], "new-pg-0: ") ||
+		!strings.Contains(pause[0], "-h old-pgbouncer-0 -p 6432 -U postgres -d pgbouncer") {
+		t.Errorf("expected PAUSE on the PgBouncer console, got %v", pause)
+	}
+	if execIndex(p, "RESET default_transaction_read_only") >= 0 {
+		t.Error("expected no rollback")
+	}
+}
+
+func TestCutover_RollsBackWhenCatchUpTimesOut(t *testing.T) {
+	c := &migrateCluster{synced: "t", caughtUp: "f"}
+	p := c.provider()
+
+	_, err := lifecycle.Cutover(context.Background(), p, migrateOptions())
+	if err == nil || !strings.Contains(err.Error(), lifecycle.StepCatchUp) {
+		t.Fatalf("expected a catch_up failure, got %v", err)
+	}
+	if execIndex(p, "RESET default_transaction_read_only") < 0 {
+		t.Error("expected the source made writable again")
+	}
+	if resume := execsMatching(p, "RESUME"); len(resume) != 2 {
+		t.Errorf("expected both paused entries resumed, got %v", resume)
+	}
+	for _, step := range []string{"setval", "base64 -d", "DROP SUBSCRIPTION"} {
+		if execIndex(p, step) >= 0 {
+			t.Errorf("expected %q not to run after the failure", step)
+		}
+	}
+}
+
+func TestCutover_RefusesBeforeInitialCopy(t *testing.T) {
+	c := &migrateCluster{synced: "f"}
+	p := c.provider()
+
+	_, err := lifecycle.Cutover(context.Background(), p, migrateOptions())
+	if err == nil || !strings.Contains(err.Error(), "initial copy") {
+		t.Fatalf("expected the cutover refused, got %v", err)
+	}
+	if execIndex(p, "PAUSE") >= 0 || execIndex(p, "default_transaction_read_only") >= 0 {
+		t.Errorf("expected nothing paused or made read-only, got %v", p.execs)
+	}
+}
+
+func TestMigrate_RequiredFlags(t *testing.T) {
+	cases := map[string][]string{
+		"--name is required":      {"migrate", "plan", "--target", "new", "--databases", "app"},
+		"--target is required":    {"migrate", "start", "--name", "old", "--databases", "app"},
+		"--databases is required": {"migrate", "status", "--name", "old", "--target", "new"},
+		"different clusters":      {"migrate", "cutover", "--name", "old", "--target", "old", "--databases", "app"},
+	}
+	for want, args := range cases {
+		out, err := executeCmd(t, nil, args...)
+		if err == nil || !strings.Contains(out, want) {
+			t.Errorf("%v: expected %q, got %v\n%s", args, want, err, out)
+		}
+	}
+}
+
+func TestMigrate_RequiresManagedTarget(t *testing.T) {
+	t.Setenv("PGDBA_PG_PASSWORD", "pw")
+	regPath := filepath.Join(t.TempDir(), "clusters.json")
+	reg := cluster.NewRegistry(regPath)
+	for _, e := range []cluster.Entry{
+		{Name: "old", PatroniURL: "http://127.0.0.1:1", Provider: "docker", Source: cluster.SourceManaged},
+		{Name: "new", PatroniURL: "http://127.0.0.1:1", Provider: "docker", Source: cluster.SourceExternal},
+	} {
+		if err := reg.Add(e); err != nil {
+			t.Fatalf("add entry: %v", err)
+		}
+	}
+	err := executeClusterCmdWithRegistry(t, regPath, "migrate", "plan", "--name", "old", "--target", "new", "--databases", "app")
+	if err == nil || !strings.Contains(err.Error(), `cluster "new" is not managed by pgdba`) {
+		t.Errorf("expected an external target rejected, got %v", err)
+	}
+}
+
+func TestMigrate_WarnsAboutPausedClusters(t *testing.T) {
+	pp := newPausablePatroni(t, true)
+	regPath := filepath.Join(t.TempDir(), "clusters.json")
+	reg := cluster.NewRegistry(regPath)
+	for _, name := range []string{"old", "new"} {
+		if err := reg.Add(cluster.Entry{Name: name, PatroniURL: pp.srv.URL, Provider: "docker", Source: cluster.SourceManaged}); err != nil {
+			t.Fatalf("add entry: %v", err)
+		}
+	}
+	t.Setenv("PGDBA_PG_PASSWORD", "")
+
+	for _, use := range []string{"start", "cutover"} {
+		cmd := cli.NewRootCmdWithRegistry(regPath)
+		stderr := new(strings.Builder)
+		cmd.SetOut(new(strings.Builder))
+		cmd.SetErr(stderr)
+		cmd.SetArgs([]string{"migrate", use, "--name", "old", "--target", "new", "--databases", "app"})
+		// Without a password the command stops after the warnings.
+		if err := cmd.Execute(); err == nil {
+			t.Errorf("migrate %s: expected PGDBA_PG_PASSWORD to be required", use)
+		}
+		for _, want := range []string{`\"old\" is paused`, `\"new\" is paused`} {
+			if !strings.Contains(stderr.String(), want) {
+				t.Errorf("migrate %s: expected a warning containing %s, got %q", use, want, stderr)
+			}
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package unit_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/luckyjian/pgdba/internal/cluster"
+	"github.com/luckyjian/pgdba/internal/patroni"
+)
+
+// writeClientCert writes a self-signed client certificate and key to dir
+// and returns their paths with the parsed certificate.
+func writeClientCert(t *testing.T, dir string) (certFile, keyFile string, cert *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "pgdba"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	cert, _ = x509.ParseCertificate(der)
+	return certFile, keyFile, cert
+}
+
+// clusterHandler answers /cluster with members and records the paths hit.
+func clusterHandler(hits *[]string, members ...patroni.Member) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		*hits = append(*hits, r.URL.Path)
+		json.NewEncoder(w).Encode(patroni.ClusterStatus{Members: members})
+	}
+}
+
+func TestPatroniClient_BasicAuth(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, ok := r.BasicAuth(); !ok || u != "patroni" || p != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(patroni.ClusterStatus{})
+	}))
+	defer srv.Close()
+
+	if _, err := patroni.NewClient(srv.URL).GetClusterStatus(context.Background()); err == nil {
+		t.Error("expected an unauthenticated request to fail")
+	}
+	c, err := patroni.NewClientWithOptions(srv.URL, patroni.Options{Username: "patroni", Password: "s3cret"})
+	if err != nil {
+		t.Fatalf("NewClientWithOptions: %v", err)
+	}
+	if _, err := c.GetClusterStatus(context.Background()); err != nil {
+		t.Errorf("expected basic auth to be accepted, got %v", err)
+	}
+}
+
+func TestPatroniClient_MutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, clientCert := writeClientCert(t, dir)
+
+	var hits []string
+	srv := httptest.NewUnstartedServer(clusterHandler(&hits))
+	pool := x509.NewCertPool()
+	pool.AddCert(clientCert)
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: pool}
+	srv.StartTLS()
+	defer srv.Close()
+	caFile := filepath.Join(dir, "ca.crt")
+	os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0600)
+
+	noCert, err := patroni.NewClientWithOptions(srv.URL, patroni.Options{CAFile: caFile})
+	if err != nil {
+		t.Fatalf("NewClientWithOptions: %v", err)
+	}
+	if _, err := noCert.GetClusterStatus(context.Background()); err == nil {
+		t.Error("expected the server to require a client certificate")
+	}
+
+	c, err := patroni.NewClientWithOptions(srv.URL, patroni.Options{CAFile: caFile, CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatalf("NewClientWithOptions: %v", err)
+	}
+	if _, err := c.GetClusterStatus(context.Background()); err != nil {
+		t.Errorf("expected mTLS to succeed, got %v", err)
+	}
+
+	if _, err := patroni.NewClientWithOptions(srv.URL, patroni.Options{CertFile: certFile}); err == nil {
+		t.Error("expected a certificate without key to be rejected")
+	}
+	if _, err := patroni.NewClientWithOptions(srv.URL, patroni.Options{CAFile: filepath.Join(dir, "missing")}); err == nil {
+		t.Error("expected a missing CA file to be rejected")
+	}
+}
+
+func TestPatroniClient_FailsOverToConfiguredEndpoint(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	downURL := down.URL
+	down.Close()
+	var hits []string
+	up := httptest.NewServer(clusterHandler(&hits))
+	defer up.Close()
+
+	c, err := patroni.NewClientWithOptions(downURL, patroni.Options{Endpoints: []string{up.URL}})
+	if err != nil {
+		t.Fatalf("NewClientWithOptions: %v", err)
+	}
+	if _, err := c.GetClusterStatus(context.Background()); err != nil {
+		t.Fatalf("expected failover to the second endpoint, got %v", err)
+	}
+	if c.URL() != up.URL {
+		t.Errorf("expected the answering endpoint to become current, got %s", c.URL())
+	}
+}
+
+func TestPatroniClient_FailsOverToMemberAPIURLs(t *testing.T) {
+	var hitsB []string
+	b := httptest.NewServer(clusterHandler(&hitsB))
+	defer b.Close()
+	var hitsA []string
+	a := httptest.NewServer(clusterHandler(&hitsA,
+		patroni.Member{Name: "pg-0", Role: "leader"},
+		patroni.Member{Name: "pg-1", Role: "replica", APIURL: b.URL + "/patroni"}))
+
+	c := patroni.NewClient(a.URL)
+	if _, err := c.GetClusterStatus(context.Background()); err != nil {
+		t.Fatalf("GetClusterStatus: %v", err)
+	}
+	a.Close()
+
+	if err := c.Switchover(context.Background(), "pg-0", "pg-1"); err != nil {
+		t.Fatalf("expected the switchover to reach pg-1's API, got %v", err)
+	}
+	if strings.Join(hitsB, ",") != "/switchover" {
+		t.Errorf("expected /switchover on the member API, got %v", hitsB)
+	}
+	// Member-specific endpoints never go to another member.
+	if err := patroni.NewClient(a.URL).Restart(context.Background()); err == nil {
+		t.Error("expected restart of an unreachable member to fail")
+	}
+}
+
+func TestPatroniPassword_FromFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "pw")
+	os.WriteFile(file, []byte("from-file\n"), 0600)
+	t.Setenv("PGDBA_PATRONI_PASSWORD", "")
+	t.Setenv("PGDBA_PATRONI_PASSWORD_FILE", file)
+	if pw, err := patroni.Password(); err != nil || pw != "from-file" {
+		t.Errorf("expected the password file to be read, got %q (%v)", pw, err)
+	}
+	t.Setenv("PGDBA_PATRONI_PASSWORD", "from-env")
+	if pw, _ := patroni.Password(); pw != "from-env" {
+		t.Errorf("expected the environment variable to win, got %q", pw)
+	}
+}
+
+func TestClusterConnect_RecordsPatroniAccess(t *testing.T) {
+	var seenUser string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seenUser, _, _ = r.BasicAuth()
+		json.NewEncoder(w).Encode(patroni.ClusterStatus{})
+	}))
+	defer srv.Close()
+	t.Setenv("PGDBA_PATRONI_PASSWORD", "s3cret")
+	regPath := filepath.Join(t.TempDir(), "clusters.json")
+
+	err := executeClusterCmdWithRegistry(t, regPath, "cluster", "connect", "--name", "prod",
+		"--patroni-url", srv.URL, "--pg-host", "10.0.0.1", "--patroni-user", "patroni",
+		"--patroni-endpoints", "http://10.0.0.2:8008, http://10.0.0.3:8008")
+	if err != nil {
+		t.Fatalf("cluster connect: %v", err)
+	}
+	if seenUser != "patroni" {
+		t.Errorf("expected the pre-flight check to authenticate, got user %q", seenUser)
+	}
+	entry, err := cluster.NewRegistry(regPath).Get("prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := entry.PatroniAccess
+	if a == nil || a.Username != "patroni" || len(a.Endpoints) != 2 {
+		t.Fatalf("expected patroni access recorded, got %+v", a)
+	}
+	data, _ := os.ReadFile(regPath)
+	if strings.Contains(string(data), "s3cret") {
+		t.Error("the password must not be stored in the registry")
+	}
+}
@@ -343,3 +343,32 @@ func TestClusterStatus_PauseField(t *testing.T) {
 		t.Error("expected Pause field to be true")
 	}
 }
+
+func TestForMember_UsesMemberAPIURL(t *testing.T) {
+	called := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && r.URL.Path == "/restart" {
+			called = true
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		http.Error(w, "not found", http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	client := patroni.NewClient("http://10.255.255.1:8008")
+	member, err := client.ForMember(patroni.Member{Name: "pg-1", APIURL: srv.URL + "/patroni"})
+	if err != nil {
+		t.Fatalf("ForMember: %v", err)
+	}
+	if err := member.Restart(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !called {
+		t.Error("expected POST /restart on the member's own API")
+	}
+
+	if _, err := client.ForMember(patroni.Member{Name: "pg-2"}); err == nil {
+		t.Error("expected an error for a member without api_url")
+	}
+}
@@ -197,3 +197,22 @@ func TestRenderPatroniConfig_ContainsEtcdHosts(t *testing.T) {
 		t.Error("rendered config should contain etcd hosts")
 	}
 }
+
+func TestRenderPatroniConfig_BinDirFollowsPGVersion(t *testing.T) {
+	cfg := patroni.PatroniConfig{ClusterName: "c", NodeName: "n", Host: "h", PGPort: 5432, DataDir: "/data"}
+	out, err := patroni.RenderPatroniConfig(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(out, "bin_dir: "+patroni.BinDir(patroni.DefaultPGVersion)) {
+		t.Error("rendered config should default to the default version's binaries")
+	}
+	cfg.PGVersion = 17
+	out, err = patroni.RenderPatroniConfig(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(out, "bin_dir: /usr/lib/postgresql/17/bin") {
+		t.Error("rendered config should use the binaries of PGVersion")
+	}
+}
@@ -0,0 +1,115 @@
+package unit_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/luckyjian/pgdba/internal/patroni"
+)
+
+func liveDCSConfig() map[string]interface{} {
+	return map[string]interface{}{
+		"ttl":                     float64(30),
+		"loop_wait":               float64(10),
+		"maximum_lag_on_failover": float64(1048576),
+		"postgresql": map[string]interface{}{
+			"use_pg_rewind": true,
+			"parameters": map[string]interface{}{
+				"max_connections":        float64(100),
+				"pg_stat_statements.max": float64(5000),
+			},
+		},
+	}
+}
+
+func TestMergeConfig_MergesNestedAndRemovesNull(t *testing.T) {
+	live := liveDCSConfig()
+	merged := patroni.MergeConfig(live, map[string]interface{}{
+		"loop_wait": 5,
+		"postgresql": map[string]interface{}{
+			"parameters": map[string]interface{}{"work_mem": "64MB", "max_connections": nil},
+		},
+	})
+	params := merged["postgresql"].(map[string]interface{})["parameters"].(map[string]interface{})
+	if _, ok := params["max_connections"]; ok || params["work_mem"] != "64MB" || params["pg_stat_statements.max"] == nil {
+		t.Errorf("unexpected merged parameters: %v", params)
+	}
+	if merged["postgresql"].(map[string]interface{})["use_pg_rewind"] != true {
+		t.Error("expected sibling keys kept")
+	}
+	if _, ok := live["postgresql"].(map[string]interface{})["parameters"].(map[string]interface{})["work_mem"]; ok {
+		t.Error("expected the input configuration left unmodified")
+	}
+}
+
+func TestDiffConfig_SplitsParametersFromPatroniKeys(t *testing.T) {
+	live := liveDCSConfig()
+	merged := patroni.MergeConfig(live, map[string]interface{}{
+		"ttl":                     30, // same value as a YAML integer
+		"maximum_lag_on_failover": 0,
+		"postgresql": map[string]interface{}{
+			"parameters": map[string]interface{}{"pg_stat_statements.max": 10000, "max_connections": nil},
+		},
+	})
+	diff := patroni.DiffConfig(live, merged)
+
+	want := []patroni.ConfigChange{
+		{Key: "max_connections", From: float64(100)},
+		{Key: "pg_stat_statements.max", From: float64(5000), To: 10000},
+	}
+	if !reflect.DeepEqual(diff.Parameters, want) {
+		t.Errorf("unexpected parameter diff: %+v", diff.Parameters)
+	}
+	if len(diff.Patroni) != 1 || diff.Patroni[0].Key != "maximum_lag_on_failover" {
+		t.Errorf("expected only maximum_lag_on_failover to change, got %+v", diff.Patroni)
+	}
+	if !patroni.DiffConfig(live, live).Empty() {
+		t.Error("expected no diff between identical configurations")
+	}
+}
+
+func TestEditPatch_RoundTrips(t *testing.T) {
+	live := liveDCSConfig()
+	edited := liveDCSConfig()
+	edited["loop_wait"] = 5
+	delete(edited, "maximum_lag_on_failover")
+	edited["postgresql"].(map[string]interface{})["parameters"].(map[string]interface{})["work_mem"] = "64MB"
+
+	patch := patroni.EditPatch(live, edited)
+	want := map[string]interface{}{
+		"loop_wait":               5,
+		"maximum_lag_on_failover": nil,
+		"postgresql": map[string]interface{}{
+			"parameters": map[string]interface{}{"work_mem": "64MB"},
+		},
+	}
+	if !reflect.DeepEqual(patch, want) {
+		t.Errorf("unexpected patch: %v", patch)
+	}
+	if !patroni.DiffConfig(patroni.MergeConfig(live, patch), edited).Empty() {
+		t.Error("expected applying the patch to yield the edited configuration")
+	}
+}
+
+func TestValidateTiming(t *testing.T) {
+	if errs := patroni.ValidateTiming(liveDCSConfig()); len(errs) != 0 {
+		t.Errorf("expected defaults to be valid, got %v", errs)
+	}
+	errs := patroni.ValidateTiming(map[string]interface{}{"ttl": 20, "loop_wait": 10, "retry_timeout": 10})
+	if len(errs) != 1 || !strings.Contains(errs[0], "must not exceed ttl") {
+		t.Errorf("expected the ttl rule to be enforced, got %v", errs)
+	}
+	if errs := patroni.ValidateTiming(map[string]interface{}{"maximum_lag_on_failover": "-1"}); len(errs) != 1 {
+		t.Errorf("expected a negative lag to be rejected, got %v", errs)
+	}
+}
+
+func TestConfigPath_KeepsParameterNamesWhole(t *testing.T) {
+	if got := patroni.ConfigPath("postgresql.parameters.pg_stat_statements.max"); len(got) != 3 || got[2] != "pg_stat_statements.max" {
+		t.Errorf("unexpected path %v", got)
+	}
+	if got := patroni.ConfigPath("postgresql.use_pg_rewind"); len(got) != 2 {
+		t.Errorf("unexpected path %v", got)
+	}
+}
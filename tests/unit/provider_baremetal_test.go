@@ -0,0 +1,565 @@
+package unit_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/luckyjian/pgdba/internal/provider"
+)
+
+// fakeSSHHost is an in-process SSH server standing in for one inventory host.
+// Every exec request is recorded and answered by the reply function.
+type fakeSSHHost struct {
+	addr string
+
+	mu       sync.Mutex
+	commands []string
+	stdins   []string
+	reply    func(cmd string) (stdout string, exit uint32)
+}
+
+func (h *fakeSSHHost) record(cmd, stdin string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.commands = append(h.commands, cmd)
+	h.stdins = append(h.stdins, stdin)
+}
+
+// ran returns the recorded commands joined by newlines.
+func (h *fakeSSHHost) ran() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return strings.Join(h.commands, "\n")
+}
+
+// stdinFor returns the stdin sent with the first command containing substr.
+func (h *fakeSSHHost) stdinFor(substr string) (string, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, c := range h.commands {
+		if strings.Contains(c, substr) {
+			return h.stdins[i], true
+		}
+	}
+	return "", false
+}
+
+// startFakeSSHHost listens on the given loopback IP and serves exec sessions
+// for the client key authorised by clientKey.
+func startFakeSSHHost(t *testing.T, ip string, clientKey ssh.PublicKey) (*fakeSSHHost, ssh.PublicKey) {
+	t.Helper()
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) != string(clientKey.Marshal()) {
+				return nil, io.EOF
+			}
+			return nil, nil
+		},
+	}
+	cfg.AddHostKey(hostSigner)
+
+	ln, err := net.Listen("tcp", ip+":0")
+	if err != nil {
+		t.Skipf("cannot listen on %s: %v", ip, err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	h := &fakeSSHHost{
+		addr:  ln.Addr().String(),
+		reply: func(string) (string, uint32) { return "", 0 },
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go h.serveConn(conn, cfg)
+		}
+	}()
+	return h, hostSigner.PublicKey()
+}
+
+func (h *fakeSSHHost) serveConn(conn net.Conn, cfg *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for nc := range chans {
+		if nc.ChannelType() != "session" {
+			nc.Reject(ssh.UnknownChannelType, "session only") //nolint:errcheck
+			continue
+		}
+		ch, chReqs, err := nc.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			defer ch.Close()
+			for req := range chReqs {
+				if req.Type != "exec" {
+					req.Reply(false, nil) //nolint:errcheck
+					continue
+				}
+				cmdLen := binary.BigEndian.Uint32(req.Payload[:4])
+				cmd := string(req.Payload[4 : 4+cmdLen])
+				req.Reply(true, nil) //nolint:errcheck
+
+				stdin, _ := io.ReadAll(ch)
+				h.record(cmd, string(stdin))
+				h.mu.Lock()
+				reply := h.reply
+				h.mu.Unlock()
+				out, exit := reply(cmd)
+				io.WriteString(ch, out) //nolint:errcheck
+				status := make([]byte, 4)
+				binary.BigEndian.PutUint32(status, exit)
+				ch.SendRequest("exit-status", false, status) //nolint:errcheck
+				return
+			}
+		}()
+	}
+}
+
+// bareMetalFixture wires a baremetal provider to two fake SSH hosts:
+// pg-1 on 127.0.0.1 and pg-2 on 127.0.0.2, plus etcd-1 on 127.0.0.3.
+type bareMetalFixture struct {
+	p     provider.Provider
+	hosts map[string]*fakeSSHHost
+}
+
+func newBareMetalFixture(t *testing.T) *bareMetalFixture {
+	t.Helper()
+	dir := t.TempDir()
+
+	_, clientPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(clientPriv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(dir, "id_ed25519")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	clientSigner, err := ssh.NewSignerFromKey(clientPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &bareMetalFixture{hosts: map[string]*fakeSSHHost{}}
+	var known, inventory, etcd []string
+	for _, n := range []struct{ name, ip string }{
+		{"pg-1", "127.0.0.1"}, {"pg-2", "127.0.0.2"}, {"etcd-1", "127.0.0.3"},
+	} {
+		h, hostKey := startFakeSSHHost(t, n.ip, clientSigner.PublicKey())
+		f.hosts[n.name] = h
+		known = append(known, knownhosts.Line([]string{knownhosts.Normalize(h.addr)}, hostKey))
+		if strings.HasPrefix(n.name, "etcd") {
+			etcd = append(etcd, n.name+"="+h.addr)
+		} else {
+			inventory = append(inventory, n.name+"="+h.addr)
+		}
+	}
+	knownHosts := filepath.Join(dir, "known_hosts")
+	if err := os.WriteFile(knownHosts, []byte(strings.Join(known, "\n")+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := provider.New("baremetal", map[string]string{
+		"hosts":       strings.Join(inventory, ","),
+		"etcd_hosts":  strings.Join(etcd, ","),
+		"user":        "postgres",
+		"key_file":    keyFile,
+		"known_hosts": knownHosts,
+		"cluster":     "prod",
+	})
+	if err != nil {
+		t.Fatalf("provider.New: %v", err)
+	}
+	f.p = p
+	return f
+}
+
+// healthyReply answers as a node with an active unit and a listening Patroni
+// port; primary controls the /primary probe result.
+func healthyReply(primary bool) func(string) (string, uint32) {
+	return func(cmd string) (string, uint32) {
+		switch {
+		case strings.HasPrefix(cmd, "systemctl is-active"):
+			return "active\n", 0
+		case strings.HasPrefix(cmd, "ss "):
+			return "LISTEN 0 128 0.0.0.0:8008 0.0.0.0:*\n", 0
+		case strings.HasPrefix(cmd, "curl "):
+			if primary {
+				return "200", 0
+			}
+			return "503", 0
+		}
+		return "", 0
+	}
+}
+
+// ownedReply wraps reply to answer the cluster record as owned by cluster.
+func ownedReply(cluster string, reply func(string) (string, uint32)) func(string) (string, uint32) {
+	return func(cmd string) (string, uint32) {
+		if strings.HasPrefix(cmd, "cat /etc/pgdba/cluster") {
+			return cluster + "\n", 0
+		}
+		return reply(cmd)
+	}
+}
+
+func TestNew_Baremetal_RequiresInventory(t *testing.T) {
+	_, err := provider.New("baremetal", map[string]string{})
+	if err == nil || !strings.Contains(err.Error(), "hosts") {
+		t.Fatalf("expected inventory error, got %v", err)
+	}
+}
+
+func TestNew_Baremetal_InvalidInventoryEntry(t *testing.T) {
+	_, err := provider.New("baremetal", map[string]string{"hosts": "10.0.0.1"})
+	if err == nil || !strings.Contains(err.Error(), "name=host") {
+		t.Fatalf("expected inventory format error, got %v", err)
+	}
+}
+
+func TestBaremetalProvider_Type(t *testing.T) {
+	f := newBareMetalFixture(t)
+	if f.p.Type() != "baremetal" {
+		t.Errorf("expected 'baremetal', got %q", f.p.Type())
+	}
+}
+
+func TestBaremetalProvider_ExecOnNode_QuotesArguments(t *testing.T) {
+	f := newBareMetalFixture(t)
+	f.hosts["pg-1"].reply = func(string) (string, uint32) { return "1\n", 0 }
+
+	out, err := f.p.ExecOnNode(context.Background(), "pg-1", []string{"psql", "-c", "SELECT 'a b'"})
+	if err != nil {
+		t.Fatalf("ExecOnNode: %v", err)
+	}
+	if out != "1\n" {
+		t.Errorf("expected stdout '1\\n', got %q", out)
+	}
+	want := `psql -c 'SELECT '"'"'a b'"'"''`
+	if got := f.hosts["pg-1"].ran(); got != want {
+		t.Errorf("expected command %q, got %q", want, got)
+	}
+}
+
+func TestBaremetalProvider_ExecOnNode_NonZeroExit(t *testing.T) {
+	f := newBareMetalFixture(t)
+	f.hosts["pg-1"].reply = func(string) (string, uint32) { return "", 2 }
+
+	if _, err := f.p.ExecOnNode(context.Background(), "pg-1", []string{"false"}); err == nil {
+		t.Fatal("expected error for non-zero exit status")
+	}
+}
+
+func TestBaremetalProvider_ExecOnNode_UnknownNode(t *testing.T) {
+	f := newBareMetalFixture(t)
+	_, err := f.p.ExecOnNode(context.Background(), "pg-9", []string{"true"})
+	if err == nil || !strings.Contains(err.Error(), "inventory") {
+		t.Fatalf("expected inventory error, got %v", err)
+	}
+}
+
+func TestBaremetalProvider_RejectsUnknownHostKey(t *testing.T) {
+	f := newBareMetalFixture(t)
+	// A provider whose known_hosts file is empty must refuse to connect.
+	empty := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(empty, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "id_ed25519")
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	block, _ := ssh.MarshalPrivateKey(priv, "")
+	os.WriteFile(keyFile, pem.EncodeToMemory(block), 0o600) //nolint:errcheck
+
+	p, err := provider.New("baremetal", map[string]string{
+		"hosts":       "pg-1=" + f.hosts["pg-1"].addr,
+		"key_file":    keyFile,
+		"known_hosts": empty,
+	})
+	if err != nil {
+		t.Fatalf("provider.New: %v", err)
+	}
+	_, err = p.ExecOnNode(context.Background(), "pg-1", []string{"true"})
+	if err == nil || !strings.Contains(err.Error(), "handshake") {
+		t.Fatalf("expected handshake failure, got %v", err)
+	}
+}
+
+func TestBaremetalProvider_GetNodeStatus_Primary(t *testing.T) {
+	f := newBareMetalFixture(t)
+	f.hosts["pg-1"].reply = ownedReply("prod", healthyReply(true))
+
+	st, err := f.p.GetNodeStatus(context.Background(), "pg-1")
+	if err != nil {
+		t.Fatalf("GetNodeStatus: %v", err)
+	}
+	if !st.Running || !st.Healthy {
+		t.Errorf("expected running+healthy, got %+v", st)
+	}
+	if st.Role != provider.RolePrimary {
+		t.Errorf("expected primary role, got %q", st.Role)
+	}
+	if st.Host != "127.0.0.1" {
+		t.Errorf("expected host 127.0.0.1, got %q", st.Host)
+	}
+	if st.Labels[provider.LabelCluster] != "prod" {
+		t.Errorf("expected cluster label 'prod', got %v", st.Labels)
+	}
+	if !strings.Contains(f.hosts["pg-1"].ran(), "systemctl is-active patroni") {
+		t.Errorf("expected patroni unit probe, got:\n%s", f.hosts["pg-1"].ran())
+	}
+}
+
+func TestBaremetalProvider_GetNodeStatus_UnitInactive(t *testing.T) {
+	f := newBareMetalFixture(t)
+	f.hosts["pg-2"].reply = func(cmd string) (string, uint32) {
+		if strings.HasPrefix(cmd, "systemctl is-active") {
+			return "inactive\n", 3
+		}
+		return "", 0
+	}
+
+	st, err := f.p.GetNodeStatus(context.Background(), "pg-2")
+	if err != nil {
+		t.Fatalf("GetNodeStatus: %v", err)
+	}
+	if st.Running || st.Healthy {
+		t.Errorf("expected stopped node, got %+v", st)
+	}
+	if strings.Contains(f.hosts["pg-2"].ran(), "ss ") {
+		t.Error("port probe should be skipped for inactive units")
+	}
+}
+
+func TestBaremetalProvider_GetNodeStatus_PortNotListening(t *testing.T) {
+	f := newBareMetalFixture(t)
+	f.hosts["pg-2"].reply = func(cmd string) (string, uint32) {
+		if strings.HasPrefix(cmd, "systemctl is-active") {
+			return "active\n", 0
+		}
+		return "", 0
+	}
+
+	st, err := f.p.GetNodeStatus(context.Background(), "pg-2")
+	if err != nil {
+		t.Fatalf("GetNodeStatus: %v", err)
+	}
+	if !st.Running || st.Healthy {
+		t.Errorf("expected running but unhealthy node, got %+v", st)
+	}
+	if st.Role != provider.RoleStandby {
+		t.Errorf("expected standby role, got %q", st.Role)
+	}
+}
+
+func TestBaremetalProvider_ListNodes(t *testing.T) {
+	f := newBareMetalFixture(t)
+	f.hosts["pg-1"].reply = healthyReply(true)
+	f.hosts["pg-2"].reply = healthyReply(false)
+	f.hosts["etcd-1"].reply = healthyReply(false)
+
+	nodes, err := f.p.ListNodes(context.Background())
+	if err != nil {
+		t.Fatalf("ListNodes: %v", err)
+	}
+	if len(nodes) != 3 {
+		t.Fatalf("expected 3 nodes, got %d", len(nodes))
+	}
+	wantIDs := []string{"etcd-1", "pg-1", "pg-2"}
+	for i, n := range nodes {
+		if n.ID != wantIDs[i] {
+			t.Errorf("node %d: expected %s, got %s", i, wantIDs[i], n.ID)
+		}
+	}
+	if nodes[0].Role != provider.RoleEtcd {
+		t.Errorf("expected etcd role for etcd-1, got %q", nodes[0].Role)
+	}
+	if !strings.Contains(f.hosts["etcd-1"].ran(), "systemctl is-active etcd") {
+		t.Errorf("expected etcd unit probe, got:\n%s", f.hosts["etcd-1"].ran())
+	}
+}
+
+func TestBaremetalProvider_ListNodes_LabelsOnlyRecordedOwners(t *testing.T) {
+	f := newBareMetalFixture(t)
+	f.hosts["pg-1"].reply = ownedReply("prod", healthyReply(true))
+	f.hosts["pg-2"].reply = ownedReply("staging", healthyReply(false))
+	f.hosts["etcd-1"].reply = healthyReply(false)
+
+	nodes, err := f.p.ListNodes(context.Background())
+	if err != nil {
+		t.Fatalf("ListNodes: %v", err)
+	}
+	owners := map[string]string{}
+	for _, n := range nodes {
+		owners[n.ID] = n.Labels[provider.LabelCluster]
+	}
+	if owners["pg-1"] != "prod" || owners["pg-2"] != "staging" || owners["etcd-1"] != "" {
+		t.Errorf("expected only the recorded owners as labels, got %v", owners)
+	}
+	for _, n := range nodes {
+		if n.Labels[provider.LabelOwnerUnknown] != "" {
+			t.Errorf("%s: expected a readable cluster record, got %v", n.ID, n.Labels)
+		}
+	}
+}
+
+func TestBaremetalProvider_ListNodes_UnreadableOwnerIsUnknown(t *testing.T) {
+	f := newBareMetalFixture(t)
+	f.hosts["pg-1"].reply = func(string) (string, uint32) { return "", 255 }
+
+	nodes, err := f.p.ListNodes(context.Background())
+	if err != nil {
+		t.Fatalf("ListNodes: %v", err)
+	}
+	for _, n := range nodes {
+		if n.ID == "pg-1" && (n.Labels[provider.LabelOwnerUnknown] != "true" || n.Labels[provider.LabelCluster] != "") {
+			t.Errorf("expected pg-1 of unknown owner, got %v", n.Labels)
+		}
+	}
+}
+
+func TestBaremetalProvider_CreateNode_InstallsUnit(t *testing.T) {
+	f := newBareMetalFixture(t)
+	f.hosts["pg-2"].reply = healthyReply(false)
+
+	st, err := f.p.CreateNode(context.Background(), provider.NodeConfig{
+		Name:    "pg-2",
+		Role:    provider.RoleStandby,
+		DataDir: "/var/lib/postgresql/data",
+		Env:     map[string]string{"PATRONI_NAME": "pg-2"},
+		Files:   map[string]string{"/etc/patroni/patroni.yml": "scope: prod\n"},
+	})
+	if err != nil {
+		t.Fatalf("CreateNode: %v", err)
+	}
+	if !st.Running {
+		t.Errorf("expected running node after create, got %+v", st)
+	}
+
+	h := f.hosts["pg-2"]
+	if cfg, ok := h.stdinFor("/etc/patroni/patroni.yml"); !ok || cfg != "scope: prod\n" {
+		t.Errorf("expected patroni.yml upload, got %q (found=%t)", cfg, ok)
+	}
+	unit, ok := h.stdinFor("/etc/systemd/system/patroni.service")
+	if !ok {
+		t.Fatalf("expected unit file upload, commands:\n%s", h.ran())
+	}
+	if !strings.Contains(unit, "ExecStart=/usr/bin/patroni") || !strings.Contains(unit, "EnvironmentFile=/etc/pgdba/patroni.env") ||
+		strings.Contains(unit, "PATRONI_NAME") {
+		t.Errorf("unexpected unit file:\n%s", unit)
+	}
+	if env, ok := h.stdinFor("/etc/pgdba/patroni.env"); !ok || env != "PATRONI_NAME=\"pg-2\"\n" {
+		t.Errorf("expected the environment in its own file, got %q (found=%t)", env, ok)
+	}
+	if owner, ok := h.stdinFor("/etc/pgdba/cluster'"); !ok || owner != "prod\n" {
+		t.Errorf("expected the cluster recorded on the host, got %q (found=%t)", owner, ok)
+	}
+	ran := h.ran()
+	for _, want := range []string{"install -d -o postgres", "/var/lib/postgresql/data",
+		"chown postgres:postgres /etc/patroni/patroni.yml", "systemctl enable --now patroni"} {
+		if !strings.Contains(ran, want) {
+			t.Errorf("expected %q in commands:\n%s", want, ran)
+		}
+	}
+}
+
+func TestBaremetalProvider_CreateNode_RefusesHostOfAnotherCluster(t *testing.T) {
+	f := newBareMetalFixture(t)
+	f.hosts["pg-2"].reply = ownedReply("staging", healthyReply(false))
+
+	_, err := f.p.CreateNode(context.Background(), provider.NodeConfig{
+		Name:   "pg-2",
+		Role:   provider.RoleStandby,
+		Labels: map[string]string{provider.LabelCluster: "prod"},
+		Files:  map[string]string{"/etc/patroni/patroni.yml": "scope: prod\n"},
+	})
+	if err == nil || !strings.Contains(err.Error(), `belongs to cluster "staging"`) {
+		t.Fatalf("expected a host of another cluster refused, got %v", err)
+	}
+	if _, ok := f.hosts["pg-2"].stdinFor("patroni.yml"); ok {
+		t.Error("expected nothing written to the host")
+	}
+}
+
+func TestBaremetalProvider_CreateNode_NotInInventory(t *testing.T) {
+	f := newBareMetalFixture(t)
+	_, err := f.p.CreateNode(context.Background(), provider.NodeConfig{Name: "pg-9"})
+	if err == nil || !strings.Contains(err.Error(), "inventory") {
+		t.Fatalf("expected inventory error, got %v", err)
+	}
+}
+
+func TestBaremetalProvider_DestroyNode(t *testing.T) {
+	f := newBareMetalFixture(t)
+	if err := f.p.DestroyNode(context.Background(), "etcd-1"); err != nil {
+		t.Fatalf("DestroyNode: %v", err)
+	}
+	ran := f.hosts["etcd-1"].ran()
+	if !strings.Contains(ran, "systemctl disable --now etcd") || !strings.Contains(ran, "etcd.service") {
+		t.Errorf("expected etcd unit removal, got:\n%s", ran)
+	}
+}
+
+func TestBaremetalProvider_PartitionNode_IsolateAndHeal(t *testing.T) {
+	f := newBareMetalFixture(t)
+	h := f.hosts["pg-1"]
+
+	if err := f.p.PartitionNode(context.Background(), "pg-1", true); err != nil {
+		t.Fatalf("isolate: %v", err)
+	}
+	ran := h.ran()
+	for _, want := range []string{"PGDBA_PARTITION", "-s 127.0.0.2 -j DROP", "-d 127.0.0.3 -j DROP", "iptables -I INPUT"} {
+		if !strings.Contains(ran, want) {
+			t.Errorf("expected %q in isolate script:\n%s", want, ran)
+		}
+	}
+	if strings.Contains(ran, "127.0.0.1 -j DROP") {
+		t.Errorf("node must not drop its own address:\n%s", ran)
+	}
+
+	if err := f.p.PartitionNode(context.Background(), "pg-1", false); err != nil {
+		t.Fatalf("heal: %v", err)
+	}
+	if !strings.Contains(h.ran(), "iptables -X PGDBA_PARTITION") {
+		t.Errorf("expected chain removal on heal:\n%s", h.ran())
+	}
+}
+
+func TestBaremetalProvider_PartitionNode_CommandFails(t *testing.T) {
+	f := newBareMetalFixture(t)
+	f.hosts["pg-1"].reply = func(string) (string, uint32) { return "", 1 }
+	err := f.p.PartitionNode(context.Background(), "pg-1", true)
+	if err == nil || !strings.Contains(err.Error(), "pg-1") {
+		t.Fatalf("expected partition error mentioning node, got %v", err)
+	}
+}
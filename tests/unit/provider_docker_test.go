@@ -0,0 +1,538 @@
+package unit_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/luckyjian/pgdba/internal/provider"
+)
+
+// fakeContainer is the in-memory state of a container held by fakeDockerEngine.
+type fakeContainer struct {
+	ID        string
+	Name      string
+	Image     string
+	Labels    map[string]string
+	Binds     []string
+	Running   bool
+	Connected bool
+	Files     map[string]string
+}
+
+// fakeDockerEngine is an in-process fake of the Docker Engine API covering the
+// endpoints DockerProvider uses.
+type fakeDockerEngine struct {
+	mu         sync.Mutex
+	networks   map[string]bool
+	volumes    map[string]bool
+	containers map[string]*fakeContainer
+	execs      map[string][]string
+	images     map[string]bool
+	pulls      []string
+	pullError  string // streamed as the pull's error message
+	failStart  bool
+	// execResult maps the first command word to (stdout, stderr, exit code).
+	execResult map[string]struct {
+		stdout, stderr string
+		code           int
+	}
+}
+
+func newFakeDockerEngine(t *testing.T) (*fakeDockerEngine, *httptest.Server) {
+	t.Helper()
+	f := &fakeDockerEngine{
+		networks:   map[string]bool{},
+		volumes:    map[string]bool{},
+		containers: map[string]*fakeContainer{},
+		execs:      map[string][]string{},
+		images:     map[string]bool{},
+		execResult: map[string]struct {
+			stdout, stderr string
+			code           int
+		}{},
+	}
+	srv := httptest.NewServer(http.StripPrefix("/v1.41", http.HandlerFunc(f.serve)))
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+func (f *fakeDockerEngine) lookup(ref string) *fakeContainer {
+	if c, ok := f.containers[ref]; ok {
+		return c
+	}
+	for _, c := range f.containers {
+		if c.Name == ref {
+			return c
+		}
+	}
+	return nil
+}
+
+func writeDockerError(w http.ResponseWriter, code int, msg string) {
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"message": msg}) //nolint:errcheck
+}
+
+func (f *fakeDockerEngine) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.URL.Path == "/images/create":
+		image := r.URL.Query().Get("fromImage")
+		f.pulls = append(f.pulls, image)
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"status": "Pulling from " + image}) //nolint:errcheck
+		if f.pullError != "" {
+			json.NewEncoder(w).Encode(map[string]string{"error": f.pullError}) //nolint:errcheck
+			return
+		}
+		f.images[image] = true
+	case parts[0] == "images":
+		image := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/images/"), "/json")
+		if !f.images[image] {
+			writeDockerError(w, http.StatusNotFound, "No such image: "+image)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"Id": "sha256:" + image}) //nolint:errcheck
+	case parts[0] == "networks" && len(parts) == 2 && parts[1] == "create":
+		var body struct{ Name string }
+		json.NewDecoder(r.Body).Decode(&body) //nolint:errcheck
+		f.networks[body.Name] = true
+		w.WriteHeader(http.StatusCreated)
+	case parts[0] == "networks" && len(parts) == 2:
+		if !f.networks[parts[1]] {
+			writeDockerError(w, http.StatusNotFound, "network "+parts[1]+" not found")
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"Name": parts[1]}) //nolint:errcheck
+	case parts[0] == "networks" && len(parts) == 3:
+		var body struct{ Container string }
+		json.NewDecoder(r.Body).Decode(&body) //nolint:errcheck
+		c := f.lookup(body.Container)
+		if c == nil {
+			writeDockerError(w, http.StatusNotFound, "no such container")
+			return
+		}
+		c.Connected = parts[2] == "connect"
+		w.WriteHeader(http.StatusOK)
+	case parts[0] == "containers" && len(parts) == 2 && parts[1] == "create":
+		var body struct {
+			Image      string
+			Labels     map[string]string
+			HostConfig struct{ Binds []string }
+		}
+		json.NewDecoder(r.Body).Decode(&body) //nolint:errcheck
+		name := r.URL.Query().Get("name")
+		c := &fakeContainer{ID: "id-" + name, Name: name, Image: body.Image,
+			Labels: body.Labels, Binds: body.HostConfig.Binds, Connected: true,
+			Files: map[string]string{}}
+		f.containers[c.ID] = c
+		for _, b := range c.Binds {
+			f.volumes[strings.SplitN(b, ":", 2)[0]] = true
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]string{"Id": c.ID}) //nolint:errcheck
+	case parts[0] == "containers" && len(parts) == 2 && parts[1] == "json":
+		var out []map[string]interface{}
+		for _, c := range f.containers {
+			out = append(out, map[string]interface{}{
+				"Id": c.ID, "Names": []string{"/" + c.Name}, "Labels": c.Labels,
+			})
+		}
+		json.NewEncoder(w).Encode(out) //nolint:errcheck
+	case parts[0] == "containers" && len(parts) >= 2:
+		c := f.lookup(parts[1])
+		if c == nil {
+			writeDockerError(w, http.StatusNotFound, "No such container: "+parts[1])
+			return
+		}
+		action := ""
+		if len(parts) == 3 {
+			action = parts[2]
+		}
+		switch {
+		case r.Method == http.MethodDelete:
+			delete(f.containers, c.ID)
+			w.WriteHeader(http.StatusNoContent)
+		case action == "start":
+			if f.failStart {
+				writeDockerError(w, http.StatusInternalServerError, "driver failed programming external connectivity")
+				return
+			}
+			c.Running = true
+			w.WriteHeader(http.StatusNoContent)
+		case action == "archive" && r.Method == http.MethodGet:
+			path := r.URL.Query().Get("path")
+			data, ok := c.Files[path]
+			if !ok {
+				writeDockerError(w, http.StatusNotFound, "Could not find the file "+path)
+				return
+			}
+			hdr := &tar.Header{Name: path[strings.LastIndex(path, "/")+1:], Mode: 0644, Size: int64(len(data))}
+			tw := tar.NewWriter(w)
+			tw.WriteHeader(hdr)    //nolint:errcheck
+			tw.Write([]byte(data)) //nolint:errcheck
+			tw.Close()             //nolint:errcheck
+		case action == "archive":
+			dir := strings.TrimSuffix(r.URL.Query().Get("path"), "/")
+			tr := tar.NewReader(r.Body)
+			for {
+				hdr, err := tr.Next()
+				if err != nil {
+					break
+				}
+				if hdr.Typeflag == tar.TypeDir {
+					continue
+				}
+				data, _ := io.ReadAll(tr)
+				c.Files[dir+"/"+hdr.Name] = string(data)
+			}
+			w.WriteHeader(http.StatusOK)
+		case action == "exec":
+			var body struct{ Cmd []string }
+			json.NewDecoder(r.Body).Decode(&body) //nolint:errcheck
+			id := "exec-" + c.Name
+			f.execs[id] = body.Cmd
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(map[string]string{"Id": id}) //nolint:errcheck
+		case action == "json":
+			ip := ""
+			networks := map[string]interface{}{}
+			if c.Connected {
+				ip = "172.18.0.10"
+				networks["pgdba-net"] = map[string]string{"IPAddress": ip}
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{ //nolint:errcheck
+				"Id":              c.ID,
+				"Name":            "/" + c.Name,
+				"Config":          map[string]interface{}{"Hostname": c.Name, "Image": c.Image, "Labels": c.Labels},
+				"State":           map[string]interface{}{"Running": c.Running},
+				"HostConfig":      map[string]interface{}{"Binds": c.Binds},
+				"NetworkSettings": map[string]interface{}{"Networks": networks},
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	case parts[0] == "volumes" && len(parts) == 2 && r.Method == http.MethodDelete:
+		if !f.volumes[parts[1]] {
+			writeDockerError(w, http.StatusNotFound, "get "+parts[1]+": no such volume")
+			return
+		}
+		delete(f.volumes, parts[1])
+		w.WriteHeader(http.StatusNoContent)
+	case parts[0] == "exec" && len(parts) == 3 && parts[2] == "start":
+		cmd := f.execs[parts[1]]
+		res := f.execResult[cmd[0]]
+		w.Header().Set("Content-Type", "application/vnd.docker.raw-stream")
+		w.WriteHeader(http.StatusOK)
+		writeDockerFrame(w, 1, res.stdout)
+		writeDockerFrame(w, 2, res.stderr)
+	case parts[0] == "exec" && len(parts) == 3 && parts[2] == "json":
+		cmd := f.execs[parts[1]]
+		res := f.execResult[cmd[0]]
+		json.NewEncoder(w).Encode(map[string]interface{}{"Running": false, "ExitCode": res.code}) //nolint:errcheck
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// writeDockerFrame writes one multiplexed stream frame (stream 1=stdout, 2=stderr).
+func writeDockerFrame(w io.Writer, stream byte, payload string) {
+	if payload == "" {
+		return
+	}
+	header := make([]byte, 8)
+	header[0] = stream
+	binary.BigEndian.PutUint32(header[4:], uint32(len(payload)))
+	var buf bytes.Buffer
+	buf.Write(header)
+	buf.WriteString(payload)
+	w.Write(buf.Bytes()) //nolint:errcheck
+}
+
+func newFakeDockerProvider(t *testing.T) (*fakeDockerEngine, provider.Provider) {
+	t.Helper()
+	f, srv := newFakeDockerEngine(t)
+	p, err := provider.New("docker", map[string]string{"host": srv.URL})
+	if err != nil {
+		t.Fatalf("provider.New: %v", err)
+	}
+	return f, p
+}
+
+func TestDockerProvider_CreateNode_LabelsNetworkAndFiles(t *testing.T) {
+	f, p := newFakeDockerProvider(t)
+
+	st, err := p.CreateNode(context.Background(), provider.NodeConfig{
+		Name:    "demo-pg-0",
+		Role:    provider.RolePrimary,
+		DataDir: "/home/postgres/pgdata",
+		Port:    5432,
+		Labels:  map[string]string{provider.LabelCluster: "demo"},
+		Files:   map[string]string{"/etc/patroni/patroni.yml": "scope: demo\n"},
+	})
+	if err != nil {
+		t.Fatalf("CreateNode: %v", err)
+	}
+	if st.ID != "demo-pg-0" || !st.Running || !st.Healthy {
+		t.Errorf("unexpected status: %+v", st)
+	}
+	if st.Host != "172.18.0.10" {
+		t.Errorf("expected network IP as host, got %q", st.Host)
+	}
+	if st.Role != provider.RolePrimary {
+		t.Errorf("expected role primary, got %q", st.Role)
+	}
+	if !f.networks["pgdba-net"] {
+		t.Error("expected pgdba-net network to be created")
+	}
+
+	c := f.lookup("demo-pg-0")
+	if c.Labels["pgdba.managed"] != "true" || c.Labels[provider.LabelCluster] != "demo" {
+		t.Errorf("missing labels: %v", c.Labels)
+	}
+	if len(c.Binds) != 1 || c.Binds[0] != "demo-pg-0-data:/home/postgres/pgdata" {
+		t.Errorf("unexpected binds: %v", c.Binds)
+	}
+	if c.Image == "" {
+		t.Error("expected default image to be set")
+	}
+	if c.Files["/etc/patroni/patroni.yml"] != "scope: demo\n" {
+		t.Errorf("file not uploaded: %v", c.Files)
+	}
+}
+
+func TestDockerProvider_CreateNode_EtcdUsesEtcdImage(t *testing.T) {
+	f, p := newFakeDockerProvider(t)
+	if _, err := p.CreateNode(context.Background(), provider.NodeConfig{
+		Name: "demo-etcd-1", Role: provider.RoleEtcd,
+	}); err != nil {
+		t.Fatalf("CreateNode: %v", err)
+	}
+	if img := f.lookup("demo-etcd-1").Image; !strings.Contains(img, "etcd") {
+		t.Errorf("expected etcd image, got %q", img)
+	}
+}
+
+func TestDockerProvider_CreateNode_PullsMissingImageOnce(t *testing.T) {
+	f, p := newFakeDockerProvider(t)
+	for _, name := range []string{"demo-pg-0", "demo-pg-1"} {
+		if _, err := p.CreateNode(context.Background(), provider.NodeConfig{
+			Name: name, Role: provider.RoleStandby, Image: "ghcr.io/zalando/spilo-16:3.3-p3",
+		}); err != nil {
+			t.Fatalf("CreateNode %s: %v", name, err)
+		}
+	}
+	if len(f.pulls) != 1 || f.pulls[0] != "ghcr.io/zalando/spilo-16:3.3-p3" {
+		t.Errorf("expected the image pulled once, got %v", f.pulls)
+	}
+
+	f.pullError = "manifest unknown"
+	_, err := p.CreateNode(context.Background(), provider.NodeConfig{Name: "demo-pg-2", Image: "spilo:missing"})
+	if err == nil || !strings.Contains(err.Error(), "manifest unknown") {
+		t.Errorf("expected the pull error reported, got %v", err)
+	}
+	if f.lookup("demo-pg-2") != nil {
+		t.Error("expected no container created without its image")
+	}
+}
+
+func TestDockerProvider_CreateNode_RemovesContainerWhenStartFails(t *testing.T) {
+	f, p := newFakeDockerProvider(t)
+	f.failStart = true
+	cfg := provider.NodeConfig{Name: "demo-pg-0", Role: provider.RolePrimary}
+	if _, err := p.CreateNode(context.Background(), cfg); err == nil || !strings.Contains(err.Error(), "start container") {
+		t.Fatalf("expected the start failure, got %v", err)
+	}
+	if f.lookup("demo-pg-0") != nil {
+		t.Fatal("expected the failed container removed")
+	}
+
+	f.failStart = false
+	if _, err := p.CreateNode(context.Background(), cfg); err != nil {
+		t.Errorf("expected a retry under the same name to succeed, got %v", err)
+	}
+}
+
+func TestDockerProvider_ListNodes_ReturnsManagedContainers(t *testing.T) {
+	_, p := newFakeDockerProvider(t)
+	for _, name := range []string{"b-node", "a-node"} {
+		if _, err := p.CreateNode(context.Background(), provider.NodeConfig{Name: name}); err != nil {
+			t.Fatalf("CreateNode %s: %v", name, err)
+		}
+	}
+	nodes, err := p.ListNodes(context.Background())
+	if err != nil {
+		t.Fatalf("ListNodes: %v", err)
+	}
+	if len(nodes) != 2 || nodes[0].ID != "a-node" || nodes[1].ID != "b-node" {
+		t.Errorf("unexpected nodes: %+v", nodes)
+	}
+	if nodes[0].Labels["pgdba.node"] != "a-node" {
+		t.Errorf("expected labels to be populated, got %v", nodes[0].Labels)
+	}
+}
+
+func TestDockerProvider_ExecOnNode_ReturnsStdout(t *testing.T) {
+	f, p := newFakeDockerProvider(t)
+	if _, err := p.CreateNode(context.Background(), provider.NodeConfig{Name: "pg"}); err != nil {
+		t.Fatalf("CreateNode: %v", err)
+	}
+	f.execResult["psql"] = struct {
+		stdout, stderr string
+		code           int
+	}{stdout: "1\n"}
+
+	out, err := p.ExecOnNode(context.Background(), "pg", []string{"psql", "-c", "SELECT 1"})
+	if err != nil {
+		t.Fatalf("ExecOnNode: %v", err)
+	}
+	if out != "1\n" {
+		t.Errorf("expected stdout '1\\n', got %q", out)
+	}
+}
+
+func TestDockerProvider_ExecOnNode_NonZeroExit(t *testing.T) {
+	f, p := newFakeDockerProvider(t)
+	if _, err := p.CreateNode(context.Background(), provider.NodeConfig{Name: "pg"}); err != nil {
+		t.Fatalf("CreateNode: %v", err)
+	}
+	f.execResult["false"] = struct {
+		stdout, stderr string
+		code           int
+	}{stderr: "boom", code: 3}
+
+	_, err := p.ExecOnNode(context.Background(), "pg", []string{"false"})
+	if err == nil {
+		t.Fatal("expected error for non-zero exit code")
+	}
+	if !strings.Contains(err.Error(), "code 3") || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("error should carry exit code and stderr, got: %v", err)
+	}
+}
+
+func TestDockerProvider_DestroyNode_RemovesContainer(t *testing.T) {
+	f, p := newFakeDockerProvider(t)
+	if _, err := p.CreateNode(context.Background(), provider.NodeConfig{Name: "pg"}); err != nil {
+		t.Fatalf("CreateNode: %v", err)
+	}
+	if err := p.DestroyNode(context.Background(), "pg"); err != nil {
+		t.Fatalf("DestroyNode: %v", err)
+	}
+	if f.lookup("pg") != nil {
+		t.Error("expected container to be removed")
+	}
+	// Destroying an already-removed container is not an error.
+	if err := p.DestroyNode(context.Background(), "pg"); err != nil {
+		t.Errorf("expected idempotent DestroyNode, got: %v", err)
+	}
+}
+
+func TestDockerProvider_GetNodeStatus_NotFound(t *testing.T) {
+	_, p := newFakeDockerProvider(t)
+	_, err := p.GetNodeStatus(context.Background(), "ghost")
+	if err == nil {
+		t.Fatal("expected error for unknown container")
+	}
+	if !strings.Contains(err.Error(), "404") {
+		t.Errorf("expected HTTP 404 in error, got: %v", err)
+	}
+}
+
+func TestDockerProvider_PartitionNode_IsolateAndHeal(t *testing.T) {
+	f, p := newFakeDockerProvider(t)
+	if _, err := p.CreateNode(context.Background(), provider.NodeConfig{Name: "pg"}); err != nil {
+		t.Fatalf("CreateNode: %v", err)
+	}
+	if err := p.PartitionNode(context.Background(), "pg", true); err != nil {
+		t.Fatalf("PartitionNode(isolate): %v", err)
+	}
+	if f.lookup("pg").Connected {
+		t.Error("expected container to be disconnected")
+	}
+	if err := p.PartitionNode(context.Background(), "pg", false); err != nil {
+		t.Fatalf("PartitionNode(heal): %v", err)
+	}
+	if !f.lookup("pg").Connected {
+		t.Error("expected container to be reconnected")
+	}
+}
+
+func TestNew_DockerProvider_InvalidHost(t *testing.T) {
+	_, err := provider.New("docker", map[string]string{"host": "ftp://example"})
+	if err == nil {
+		t.Error("expected error for unsupported docker host scheme")
+	}
+}
+
+func TestDockerProvider_PurgeNodeData(t *testing.T) {
+	f, p := newFakeDockerProvider(t)
+	ctx := context.Background()
+	if _, err := p.CreateNode(ctx, provider.NodeConfig{Name: "demo-pg-1", DataDir: "/data"}); err != nil {
+		t.Fatalf("CreateNode: %v", err)
+	}
+	if err := p.DestroyNode(ctx, "demo-pg-1"); err != nil {
+		t.Fatalf("DestroyNode: %v", err)
+	}
+	if !f.volumes["demo-pg-1-data"] {
+		t.Fatal("DestroyNode must keep the data volume")
+	}
+
+	purger, ok := p.(provider.DataPurger)
+	if !ok {
+		t.Fatal("docker provider should implement DataPurger")
+	}
+	if err := purger.PurgeNodeData(ctx, "demo-pg-1"); err != nil {
+		t.Fatalf("PurgeNodeData: %v", err)
+	}
+	if f.volumes["demo-pg-1-data"] {
+		t.Error("expected data volume to be removed")
+	}
+	// Purging again is a no-op.
+	if err := purger.PurgeNodeData(ctx, "demo-pg-1"); err != nil {
+		t.Errorf("expected idempotent purge, got %v", err)
+	}
+}
+
+func TestDockerProvider_UpdateNodeImage(t *testing.T) {
+	f, p := newFakeDockerProvider(t)
+	ctx := context.Background()
+	if _, err := p.CreateNode(ctx, provider.NodeConfig{
+		Name:    "demo-pg-1",
+		DataDir: "/data",
+		Image:   "spilo:16.3",
+		Files:   map[string]string{"/etc/patroni/patroni.yml": "scope: demo\n"},
+	}); err != nil {
+		t.Fatalf("CreateNode: %v", err)
+	}
+
+	updater, ok := p.(provider.ImageUpdater)
+	if !ok {
+		t.Fatal("docker provider should implement ImageUpdater")
+	}
+	if err := updater.UpdateNodeImage(ctx, "demo-pg-1", "spilo:16.4"); err != nil {
+		t.Fatalf("UpdateNodeImage: %v", err)
+	}
+
+	c := f.lookup("demo-pg-1")
+	if c == nil || c.Image != "spilo:16.4" || !c.Running {
+		t.Fatalf("expected a running container on the new image, got %+v", c)
+	}
+	if len(c.Binds) != 1 || c.Binds[0] != "demo-pg-1-data:/data" {
+		t.Errorf("expected data volume to carry over, got %v", c.Binds)
+	}
+	if c.Files["/etc/patroni/patroni.yml"] != "scope: demo\n" {
+		t.Errorf("expected uploaded files restored, got %v", c.Files)
+	}
+}
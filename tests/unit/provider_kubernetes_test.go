@@ -0,0 +1,495 @@
+package unit_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/luckyjian/pgdba/internal/provider"
+)
+
+// fakePodExecutor records exec calls and answers them with canned output.
+type fakePodExecutor struct {
+	calls  [][]string
+	stdout string
+	stderr string
+	err    error
+}
+
+func (e *fakePodExecutor) Exec(_ context.Context, namespace, pod, _ string, cmd []string) (string, string, error) {
+	e.calls = append(e.calls, append([]string{namespace + "/" + pod}, cmd...))
+	return e.stdout, e.stderr, e.err
+}
+
+func newFakeKubernetesProvider(t *testing.T, objects ...*corev1.Pod) (*provider.KubernetesProvider, *fake.Clientset, *fakePodExecutor) {
+	t.Helper()
+	client := fake.NewClientset()
+	for _, pod := range objects {
+		if _, err := client.CoreV1().Pods("pg").Create(context.Background(), pod, metav1.CreateOptions{}); err != nil {
+			t.Fatalf("seed pod: %v", err)
+		}
+	}
+	exec := &fakePodExecutor{}
+	p := provider.NewKubernetesProviderWithClient(client, exec, map[string]string{
+		"namespace":    "pg",
+		"storage_size": "5Gi",
+	})
+	return p, client, exec
+}
+
+// patroniPod builds a pod as the StatefulSet controller and Patroni would
+// leave it: managed labels, a Patroni role label and a Ready condition.
+func patroniPod(name, role string, ready bool) *corev1.Pod {
+	cond := corev1.ConditionFalse
+	if ready {
+		cond = corev1.ConditionTrue
+	}
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "pg",
+			Labels: map[string]string{
+				"pgdba.managed":       "true",
+				"pgdba.role":          "standby",
+				"role":                role,
+				provider.LabelCluster: "prod",
+			},
+		},
+		Status: corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			PodIP:      "10.1.0." + name[len(name)-1:],
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: cond}},
+		},
+	}
+}
+
+func TestKubernetesProvider_Type(t *testing.T) {
+	p, _, _ := newFakeKubernetesProvider(t)
+	if p.Type() != "kubernetes" {
+		t.Errorf("expected 'kubernetes', got %q", p.Type())
+	}
+}
+
+func TestKubernetesProvider_CreateNode_CreatesStatefulSet(t *testing.T) {
+	p, client, _ := newFakeKubernetesProvider(t)
+	ctx := context.Background()
+
+	st, err := p.CreateNode(ctx, provider.NodeConfig{
+		Name:    "prod-pg-0",
+		Role:    provider.RolePrimary,
+		DataDir: "/home/postgres/pgdata",
+		Port:    5432,
+		Env:     map[string]string{"SCOPE": "prod"},
+		Labels:  map[string]string{provider.LabelCluster: "prod"},
+		Files:   map[string]string{"/etc/patroni/patroni.yml": "scope: prod\n"},
+	})
+	if err != nil {
+		t.Fatalf("CreateNode: %v", err)
+	}
+	if st.ID != "prod-pg-0" || st.Running {
+		t.Errorf("expected pending status for prod-pg-0, got %+v", st)
+	}
+
+	set, err := client.AppsV1().StatefulSets("pg").Get(ctx, "prod-pg", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected statefulset prod-pg: %v", err)
+	}
+	if *set.Spec.Replicas != 1 || set.Spec.ServiceName != "prod-pg" {
+		t.Errorf("unexpected statefulset spec: replicas=%d service=%q", *set.Spec.Replicas, set.Spec.ServiceName)
+	}
+	tmpl := set.Spec.Template
+	if tmpl.Labels["pgdba.managed"] != "true" || tmpl.Labels[provider.LabelCluster] != "prod" {
+		t.Errorf("unexpected template labels: %v", tmpl.Labels)
+	}
+	c := tmpl.Spec.Containers[0]
+	if c.Image != "ghcr.io/zalando/spilo-16:3.3-p3" {
+		t.Errorf("expected default spilo image, got %q", c.Image)
+	}
+	var mounted bool
+	for _, m := range c.VolumeMounts {
+		if m.MountPath == "/etc/patroni/patroni.yml" && m.SubPath != "" {
+			mounted = true
+		}
+	}
+	if !mounted {
+		t.Errorf("expected patroni.yml subPath mount, got %+v", c.VolumeMounts)
+	}
+	if len(set.Spec.VolumeClaimTemplates) != 1 {
+		t.Fatalf("expected a data volume claim template")
+	}
+	if got := set.Spec.VolumeClaimTemplates[0].Spec.Resources.Requests.Storage().String(); got != "5Gi" {
+		t.Errorf("expected 5Gi claim, got %s", got)
+	}
+
+	if _, err := client.CoreV1().Services("pg").Get(ctx, "prod-pg", metav1.GetOptions{}); err != nil {
+		t.Errorf("expected headless service: %v", err)
+	}
+	files, err := client.CoreV1().Secrets("pg").Get(ctx, "prod-pg-files", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected files secret: %v", err)
+	}
+	if string(files.Data["file-0.0"]) != "scope: prod\n" {
+		t.Errorf("unexpected files secret data: %v", files.Data)
+	}
+	if maps, _ := client.CoreV1().ConfigMaps("pg").List(ctx, metav1.ListOptions{}); len(maps.Items) != 0 {
+		t.Errorf("expected no configmaps, got %d", len(maps.Items))
+	}
+	env, err := client.CoreV1().Secrets("pg").Get(ctx, "prod-pg-env", metav1.GetOptions{})
+	if err != nil || string(env.Data["SCOPE"]) != "prod" {
+		t.Fatalf("expected the environment in secret prod-pg-env, got %v (%v)", env, err)
+	}
+	for _, e := range c.Env {
+		if e.Name == "SCOPE" {
+			t.Errorf("expected no environment values in the pod template, got %+v", e)
+		}
+	}
+	if len(c.EnvFrom) != 1 || c.EnvFrom[0].SecretRef == nil || c.EnvFrom[0].SecretRef.Name != "prod-pg-env" {
+		t.Errorf("expected the environment read from prod-pg-env, got %+v", c.EnvFrom)
+	}
+}
+
+func TestKubernetesProvider_CreateNode_ScalesUpInOrder(t *testing.T) {
+	p, client, _ := newFakeKubernetesProvider(t)
+	ctx := context.Background()
+
+	if _, err := p.CreateNode(ctx, provider.NodeConfig{Name: "prod-pg-0"}); err != nil {
+		t.Fatalf("create prod-pg-0: %v", err)
+	}
+	if _, err := p.CreateNode(ctx, provider.NodeConfig{Name: "prod-pg-2"}); err == nil {
+		t.Fatal("expected error when skipping an ordinal")
+	}
+	if _, err := p.CreateNode(ctx, provider.NodeConfig{Name: "prod-pg-1"}); err != nil {
+		t.Fatalf("create prod-pg-1: %v", err)
+	}
+	set, _ := client.AppsV1().StatefulSets("pg").Get(ctx, "prod-pg", metav1.GetOptions{})
+	if *set.Spec.Replicas != 2 {
+		t.Errorf("expected 2 replicas, got %d", *set.Spec.Replicas)
+	}
+}
+
+func TestKubernetesProvider_CreateNode_KeepsFilesPerPod(t *testing.T) {
+	p, client, _ := newFakeKubernetesProvider(t)
+	ctx := context.Background()
+	node := func(i int) provider.NodeConfig {
+		return provider.NodeConfig{
+			Name:  fmt.Sprintf("prod-pg-%d", i),
+			Role:  provider.RoleStandby,
+			Env:   map[string]string{"SCOPE": "prod"},
+			Files: map[string]string{"/etc/patroni/patroni.yml": fmt.Sprintf("name: prod-pg-%d\n", i)},
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := p.CreateNode(ctx, node(i)); err != nil {
+			t.Fatalf("create prod-pg-%d: %v", i, err)
+		}
+	}
+
+	files, _ := client.CoreV1().Secrets("pg").Get(ctx, "prod-pg-files", metav1.GetOptions{})
+	if string(files.Data["file-0.0"]) != "name: prod-pg-0\n" || string(files.Data["file-0.1"]) != "name: prod-pg-1\n" {
+		t.Errorf("expected each pod's own patroni.yml, got %v", files.Data)
+	}
+	set, _ := client.AppsV1().StatefulSets("pg").Get(ctx, "prod-pg", metav1.GetOptions{})
+	init := set.Spec.Template.Spec.InitContainers
+	if len(init) != 1 || !strings.Contains(init[0].Command[2], `cp "/pgdba-files/file-0.$o" "/pgdba-rendered/file-0"`) {
+		t.Errorf("expected an init container copying the pod's files by ordinal, got %+v", init)
+	}
+
+	other := node(2)
+	other.Env = map[string]string{"SCOPE": "other"}
+	if _, err := p.CreateNode(ctx, other); err == nil || !strings.Contains(err.Error(), "SCOPE") {
+		t.Errorf("expected a different environment refused, got %v", err)
+	}
+	other = node(2)
+	other.Files["/etc/patroni/extra.yml"] = "x"
+	if _, err := p.CreateNode(ctx, other); err == nil || !strings.Contains(err.Error(), "mounts files") {
+		t.Errorf("expected different file paths refused, got %v", err)
+	}
+}
+
+func TestKubernetesProvider_CreateNode_PgBouncerProbe(t *testing.T) {
+	p, client, _ := newFakeKubernetesProvider(t)
+	ctx := context.Background()
+	if _, err := p.CreateNode(ctx, provider.NodeConfig{
+		Name: "prod-pgbouncer-0", Port: 6432,
+		Files: map[string]string{"/etc/pgbouncer/pgbouncer.ini": "[databases]\n"},
+	}); err != nil {
+		t.Fatalf("CreateNode: %v", err)
+	}
+	set, _ := client.AppsV1().StatefulSets("pg").Get(ctx, "prod-pgbouncer", metav1.GetOptions{})
+	c := set.Spec.Template.Spec.Containers[0]
+	for _, port := range c.Ports {
+		if port.ContainerPort == 8008 {
+			t.Errorf("expected no Patroni port on PgBouncer, got %+v", c.Ports)
+		}
+	}
+	if c.ReadinessProbe == nil || c.ReadinessProbe.TCPSocket == nil || c.ReadinessProbe.TCPSocket.Port.IntValue() != 6432 {
+		t.Errorf("expected a TCP probe on 6432, got %+v", c.ReadinessProbe)
+	}
+}
+
+func TestKubernetesProvider_CreateNode_ReusesLeftoverSecret(t *testing.T) {
+	p, client, _ := newFakeKubernetesProvider(t)
+	ctx := context.Background()
+	if _, err := client.CoreV1().Secrets("pg").Create(ctx, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "prod-pg-files", Namespace: "pg"},
+		Data:       map[string][]byte{"file-0.0": []byte("stale")},
+	}, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("seed secret: %v", err)
+	}
+	if _, err := p.CreateNode(ctx, provider.NodeConfig{
+		Name: "prod-pg-0", Role: provider.RolePrimary,
+		Files: map[string]string{"/etc/patroni/patroni.yml": "fresh"},
+	}); err != nil {
+		t.Fatalf("CreateNode after a partial attempt: %v", err)
+	}
+	files, _ := client.CoreV1().Secrets("pg").Get(ctx, "prod-pg-files", metav1.GetOptions{})
+	if string(files.Data["file-0.0"]) != "fresh" {
+		t.Errorf("expected the leftover secret updated, got %v", files.Data)
+	}
+}
+
+func TestKubernetesProvider_CreateNode_RequiresOrdinalName(t *testing.T) {
+	p, _, _ := newFakeKubernetesProvider(t)
+	_, err := p.CreateNode(context.Background(), provider.NodeConfig{Name: "prod-pgbouncer"})
+	if err == nil || !strings.Contains(err.Error(), "<statefulset>-<ordinal>") {
+		t.Fatalf("expected naming error, got %v", err)
+	}
+}
+
+func TestKubernetesProvider_CreateNode_FirstNodeMustBeOrdinalZero(t *testing.T) {
+	p, _, _ := newFakeKubernetesProvider(t)
+	_, err := p.CreateNode(context.Background(), provider.NodeConfig{Name: "prod-pg-1"})
+	if err == nil || !strings.Contains(err.Error(), "prod-pg-0") {
+		t.Fatalf("expected ordinal error, got %v", err)
+	}
+}
+
+func TestKubernetesProvider_CreateNode_EtcdImage(t *testing.T) {
+	p, client, _ := newFakeKubernetesProvider(t)
+	ctx := context.Background()
+	if _, err := p.CreateNode(ctx, provider.NodeConfig{Name: "prod-etcd-0", Role: provider.RoleEtcd, Port: 2379}); err != nil {
+		t.Fatalf("CreateNode: %v", err)
+	}
+	set, _ := client.AppsV1().StatefulSets("pg").Get(ctx, "prod-etcd", metav1.GetOptions{})
+	c := set.Spec.Template.Spec.Containers[0]
+	if c.Image != "quay.io/coreos/etcd:v3.5.9" {
+		t.Errorf("expected etcd image, got %q", c.Image)
+	}
+	if c.ReadinessProbe != nil {
+		t.Error("etcd pods should not get the Patroni readiness probe")
+	}
+}
+
+func TestKubernetesProvider_DestroyNode_ScalesDownFromTop(t *testing.T) {
+	p, client, _ := newFakeKubernetesProvider(t)
+	ctx := context.Background()
+	for _, name := range []string{"prod-pg-0", "prod-pg-1"} {
+		if _, err := p.CreateNode(ctx, provider.NodeConfig{Name: name, Env: map[string]string{"SCOPE": "prod"},
+			Files: map[string]string{"/etc/patroni/patroni.yml": "scope: prod\n"}}); err != nil {
+			t.Fatalf("create %s: %v", name, err)
+		}
+	}
+
+	if err := p.DestroyNode(ctx, "prod-pg-0"); err == nil {
+		t.Fatal("expected error removing a non-highest ordinal")
+	}
+	if err := p.DestroyNode(ctx, "prod-pg-1"); err != nil {
+		t.Fatalf("destroy prod-pg-1: %v", err)
+	}
+	set, _ := client.AppsV1().StatefulSets("pg").Get(ctx, "prod-pg", metav1.GetOptions{})
+	if *set.Spec.Replicas != 1 {
+		t.Errorf("expected 1 replica, got %d", *set.Spec.Replicas)
+	}
+
+	if err := p.DestroyNode(ctx, "prod-pg-0"); err != nil {
+		t.Fatalf("destroy prod-pg-0: %v", err)
+	}
+	if _, err := client.AppsV1().StatefulSets("pg").Get(ctx, "prod-pg", metav1.GetOptions{}); err == nil {
+		t.Error("expected statefulset to be deleted with its last pod")
+	}
+	if _, err := client.CoreV1().Services("pg").Get(ctx, "prod-pg", metav1.GetOptions{}); err == nil {
+		t.Error("expected service to be deleted with its last pod")
+	}
+	if secrets, _ := client.CoreV1().Secrets("pg").List(ctx, metav1.ListOptions{}); len(secrets.Items) != 0 {
+		t.Errorf("expected the env and files secrets deleted with the last pod, got %d", len(secrets.Items))
+	}
+}
+
+func TestKubernetesProvider_DestroyNode_MissingIsNoop(t *testing.T) {
+	p, _, _ := newFakeKubernetesProvider(t)
+	if err := p.DestroyNode(context.Background(), "prod-pg-0"); err != nil {
+		t.Errorf("expected no error for missing statefulset, got %v", err)
+	}
+}
+
+func TestKubernetesProvider_GetNodeStatus_FromPod(t *testing.T) {
+	p, _, _ := newFakeKubernetesProvider(t,
+		patroniPod("prod-pg-0", "master", true),
+		patroniPod("prod-pg-1", "replica", false),
+	)
+	ctx := context.Background()
+
+	st, err := p.GetNodeStatus(ctx, "prod-pg-0")
+	if err != nil {
+		t.Fatalf("GetNodeStatus: %v", err)
+	}
+	if st.Role != provider.RolePrimary || !st.Running || !st.Healthy || st.Host != "10.1.0.0" {
+		t.Errorf("unexpected primary status: %+v", st)
+	}
+	if st.Labels[provider.LabelCluster] != "prod" {
+		t.Errorf("expected cluster label, got %v", st.Labels)
+	}
+
+	st, err = p.GetNodeStatus(ctx, "prod-pg-1")
+	if err != nil {
+		t.Fatalf("GetNodeStatus: %v", err)
+	}
+	if st.Role != provider.RoleStandby || !st.Running || st.Healthy {
+		t.Errorf("unexpected replica status: %+v", st)
+	}
+}
+
+func TestKubernetesProvider_GetNodeStatus_NotFound(t *testing.T) {
+	p, _, _ := newFakeKubernetesProvider(t)
+	_, err := p.GetNodeStatus(context.Background(), "prod-pg-9")
+	if err == nil || !strings.Contains(err.Error(), "prod-pg-9") {
+		t.Fatalf("expected not-found error mentioning pod, got %v", err)
+	}
+}
+
+func TestKubernetesProvider_ListNodes_OnlyManaged(t *testing.T) {
+	unmanaged := patroniPod("other-0", "replica", true)
+	delete(unmanaged.Labels, "pgdba.managed")
+	p, _, _ := newFakeKubernetesProvider(t,
+		patroniPod("prod-pg-1", "replica", true),
+		patroniPod("prod-pg-0", "master", true),
+		unmanaged,
+	)
+
+	nodes, err := p.ListNodes(context.Background())
+	if err != nil {
+		t.Fatalf("ListNodes: %v", err)
+	}
+	if len(nodes) != 2 {
+		t.Fatalf("expected 2 managed nodes, got %d", len(nodes))
+	}
+	if nodes[0].ID != "prod-pg-0" || nodes[1].ID != "prod-pg-1" {
+		t.Errorf("expected nodes sorted by name, got %s, %s", nodes[0].ID, nodes[1].ID)
+	}
+}
+
+func TestKubernetesProvider_ExecOnNode(t *testing.T) {
+	p, _, exec := newFakeKubernetesProvider(t)
+	exec.stdout = "1\n"
+
+	out, err := p.ExecOnNode(context.Background(), "prod-pg-0", []string{"psql", "-c", "SELECT 1"})
+	if err != nil {
+		t.Fatalf("ExecOnNode: %v", err)
+	}
+	if out != "1\n" {
+		t.Errorf("expected stdout '1\\n', got %q", out)
+	}
+	if len(exec.calls) != 1 || exec.calls[0][0] != "pg/prod-pg-0" || exec.calls[0][1] != "psql" {
+		t.Errorf("unexpected exec calls: %v", exec.calls)
+	}
+}
+
+func TestKubernetesProvider_ExecOnNode_Failure(t *testing.T) {
+	p, _, exec := newFakeKubernetesProvider(t)
+	exec.err = errors.New("command terminated with exit code 2")
+	exec.stderr = "psql: error: connection refused\n"
+
+	_, err := p.ExecOnNode(context.Background(), "prod-pg-0", []string{"psql"})
+	if err == nil || !strings.Contains(err.Error(), "connection refused") {
+		t.Fatalf("expected error with stderr, got %v", err)
+	}
+}
+
+func TestKubernetesProvider_PartitionNode_NetworkPolicy(t *testing.T) {
+	p, client, _ := newFakeKubernetesProvider(t, patroniPod("prod-pg-1", "replica", true))
+	ctx := context.Background()
+
+	if err := p.PartitionNode(ctx, "prod-pg-1", true); err != nil {
+		t.Fatalf("isolate: %v", err)
+	}
+	// Isolating twice is idempotent.
+	if err := p.PartitionNode(ctx, "prod-pg-1", true); err != nil {
+		t.Fatalf("second isolate: %v", err)
+	}
+	np, err := client.NetworkingV1().NetworkPolicies("pg").Get(ctx, "pgdba-partition-prod-pg-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected network policy: %v", err)
+	}
+	if np.Spec.PodSelector.MatchLabels["statefulset.kubernetes.io/pod-name"] != "prod-pg-1" {
+		t.Errorf("unexpected pod selector: %v", np.Spec.PodSelector.MatchLabels)
+	}
+	if len(np.Spec.PolicyTypes) != 2 || len(np.Spec.Ingress) != 0 || len(np.Spec.Egress) != 0 {
+		t.Errorf("expected deny-all ingress+egress policy, got %+v", np.Spec)
+	}
+
+	if err := p.PartitionNode(ctx, "prod-pg-1", false); err != nil {
+		t.Fatalf("heal: %v", err)
+	}
+	if _, err := client.NetworkingV1().NetworkPolicies("pg").Get(ctx, "pgdba-partition-prod-pg-1", metav1.GetOptions{}); err == nil {
+		t.Error("expected network policy to be removed on heal")
+	}
+}
+
+func TestKubernetesProvider_PartitionNode_UnknownPod(t *testing.T) {
+	p, _, _ := newFakeKubernetesProvider(t)
+	if err := p.PartitionNode(context.Background(), "prod-pg-9", true); err == nil {
+		t.Fatal("expected error isolating a pod that does not exist")
+	}
+}
+
+func TestKubernetesProvider_PurgeNodeData(t *testing.T) {
+	p, client, _ := newFakeKubernetesProvider(t)
+	ctx := context.Background()
+	claim := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "data-prod-pg-1", Namespace: "pg"}}
+	if _, err := client.CoreV1().PersistentVolumeClaims("pg").Create(ctx, claim, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.PurgeNodeData(ctx, "prod-pg-1"); err != nil {
+		t.Fatalf("PurgeNodeData: %v", err)
+	}
+	if _, err := client.CoreV1().PersistentVolumeClaims("pg").Get(ctx, "data-prod-pg-1", metav1.GetOptions{}); err == nil {
+		t.Error("expected claim to be deleted")
+	}
+	if err := p.PurgeNodeData(ctx, "prod-pg-1"); err != nil {
+		t.Errorf("expected idempotent purge, got %v", err)
+	}
+}
+
+func TestKubernetesProvider_UpdateNodeImage(t *testing.T) {
+	p, client, _ := newFakeKubernetesProvider(t, patroniPod("prod-pg-1", "replica", true))
+	ctx := context.Background()
+	if _, err := p.CreateNode(ctx, provider.NodeConfig{Name: "prod-pg-0", Role: provider.RolePrimary, Image: "spilo:16.3"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.CreateNode(ctx, provider.NodeConfig{Name: "prod-pg-1", Role: provider.RoleStandby}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.UpdateNodeImage(ctx, "prod-pg-1", "spilo:16.4"); err != nil {
+		t.Fatalf("UpdateNodeImage: %v", err)
+	}
+	set, err := client.AppsV1().StatefulSets("pg").Get(ctx, "prod-pg", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := set.Spec.Template.Spec.Containers[0].Image; got != "spilo:16.4" {
+		t.Errorf("expected template image spilo:16.4, got %s", got)
+	}
+	if set.Spec.UpdateStrategy.Type != "OnDelete" {
+		t.Errorf("expected OnDelete update strategy, got %q", set.Spec.UpdateStrategy.Type)
+	}
+	if _, err := client.CoreV1().Pods("pg").Get(ctx, "prod-pg-1", metav1.GetOptions{}); err == nil {
+		t.Error("expected the pod to be deleted so it is recreated on the new image")
+	}
+}
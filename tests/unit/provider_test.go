@@ -67,17 +67,17 @@ func TestDockerProvider_ExecOnNode_EmptyCmd(t *testing.T) {
 	}
 }
 
-func TestNew_BaremetalNotImplemented(t *testing.T) {
+func TestNew_BaremetalRequiresConfig(t *testing.T) {
 	_, err := provider.New("baremetal", nil)
 	if err == nil {
-		t.Error("expected error for unimplemented baremetal provider")
+		t.Error("expected error for baremetal provider without a host inventory")
 	}
 }
 
-func TestNew_KubernetesNotImplemented(t *testing.T) {
+func TestNew_KubernetesRequiresConfig(t *testing.T) {
 	_, err := provider.New("kubernetes", nil)
 	if err == nil {
-		t.Error("expected error for unimplemented kubernetes provider")
+		t.Error("expected error for kubernetes provider without config")
 	}
 }
 
@@ -0,0 +1,143 @@
+package unit_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/luckyjian/pgdba/internal/lifecycle"
+	"github.com/luckyjian/pgdba/internal/patroni"
+)
+
+// reinitCluster fakes a cluster of a leader pg-0 and a replica pg-1 whose
+// own API accepts POST /reinitialize. After it, pg-1 reports the states of
+// steps, one per /cluster poll, and PostgreSQL restarts with the last one.
+type reinitCluster struct {
+	mu      sync.Mutex
+	steps   []patroni.NodeState
+	state   patroni.NodeState
+	started string
+	body    map[string]interface{}
+	url     string
+}
+
+func newReinitCluster(t *testing.T, steps ...patroni.NodeState) *reinitCluster {
+	t.Helper()
+	rc := &reinitCluster{steps: steps, state: patroni.StateStreaming, started: "2024-01-01 00:00:00+00:00"}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rc.mu.Lock()
+		defer rc.mu.Unlock()
+		switch r.URL.Path {
+		case "/cluster":
+			if rc.body != nil && len(rc.steps) > 0 {
+				rc.state, rc.steps = rc.steps[0], rc.steps[1:]
+				if len(rc.steps) == 0 {
+					rc.started = "2024-01-01 00:05:00+00:00"
+				}
+			}
+			json.NewEncoder(w).Encode(patroni.ClusterStatus{Members: []patroni.Member{ //nolint:errcheck
+				{Name: "pg-0", Role: "leader", State: patroni.StateRunning, APIURL: rc.url + "/patroni"},
+				{Name: "pg-1", Role: "replica", State: rc.state, APIURL: rc.url + "/patroni"},
+			}})
+		case "/patroni":
+			fmt.Fprintf(w, `{"state":"running","role":"replica","postmaster_start_time":%q}`, rc.started)
+		case "/reinitialize":
+			rc.body = map[string]interface{}{}
+			json.NewDecoder(r.Body).Decode(&rc.body) //nolint:errcheck
+			if len(rc.steps) == 0 {
+				rc.started = "2024-01-01 00:05:00+00:00"
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	rc.url = srv.URL
+	return rc
+}
+
+func reinitOptions() lifecycle.ReinitOptions {
+	return lifecycle.ReinitOptions{Timeout: 2 * time.Second, PollInterval: 5 * time.Millisecond}
+}
+
+func TestReinit_FollowsStates(t *testing.T) {
+	rc := newReinitCluster(t, patroni.StateStreaming, patroni.StateStopped, patroni.StateCreating, patroni.StateCreating, "starting", patroni.StateStreaming)
+	opts := reinitOptions()
+	opts.Force, opts.FromLeader = true, true
+
+	res, err := lifecycle.Reinit(context.Background(), patroni.NewClient(rc.url), "pg-1", opts)
+	if err != nil {
+		t.Fatalf("Reinit: %v", err)
+	}
+	var states []string
+	for _, s := range res.States {
+		states = append(states, string(s.State))
+	}
+	if got := strings.Join(states, ","); got != "streaming,stopped,creating replica,starting,streaming" {
+		t.Errorf("unexpected states %s", got)
+	}
+	if rc.body["force"] != true || rc.body["from_leader"] != true {
+		t.Errorf("expected force and from_leader sent, got %v", rc.body)
+	}
+}
+
+func TestReinit_AcceptsRunningReplica(t *testing.T) {
+	// Patroni before 3.0 reports a healthy replica as running.
+	rc := newReinitCluster(t, patroni.StateRunning, patroni.StateStopped, patroni.StateCreating, patroni.StateRunning)
+
+	res, err := lifecycle.Reinit(context.Background(), patroni.NewClient(rc.url), "pg-1", reinitOptions())
+	if err != nil {
+		t.Fatalf("Reinit: %v", err)
+	}
+	if last := res.States[len(res.States)-1].State; last != patroni.StateRunning {
+		t.Errorf("expected the wait to end on running, got %s", last)
+	}
+}
+
+func TestReinit_QuickRestartDetected(t *testing.T) {
+	rc := newReinitCluster(t)
+
+	res, err := lifecycle.Reinit(context.Background(), patroni.NewClient(rc.url), "pg-1", reinitOptions())
+	if err != nil || len(res.States) != 1 {
+		t.Fatalf("expected the new postmaster start time to end the wait, got %+v (%v)", res, err)
+	}
+	if len(rc.body) != 0 {
+		t.Errorf("expected no options sent, got %v", rc.body)
+	}
+}
+
+func TestReinit_Refusals(t *testing.T) {
+	rc := newReinitCluster(t)
+	for member, want := range map[string]string{"pg-0": "current leader", "pg-9": "not found"} {
+		if _, err := lifecycle.Reinit(context.Background(), patroni.NewClient(rc.url), member, reinitOptions()); err == nil ||
+			!strings.Contains(err.Error(), want) {
+			t.Errorf("expected %s refused with %q, got %v", member, want, err)
+		}
+	}
+	if rc.body != nil {
+		t.Error("reinitialize must not be requested")
+	}
+
+	out, err := executeCmd(t, nil, "replica", "reinit", "--patroni-url", rc.url)
+	if err == nil || !strings.Contains(out, "--member is required") {
+		t.Errorf("expected --member to be required, got %v\n%s", err, out)
+	}
+}
+
+func TestReplicaReinit_Command(t *testing.T) {
+	rc := newReinitCluster(t, patroni.StateCreating, patroni.StateStreaming)
+
+	out, err := executeCmd(t, nil, "replica", "reinit", "--patroni-url", rc.url, "--member", "pg-1", "--progress")
+	if err != nil {
+		t.Fatalf("replica reinit: %v\n%s", err, out)
+	}
+	if !strings.Contains(out, `"step":"reinit-wait"`) || !strings.Contains(out, `"member": "pg-1"`) {
+		t.Errorf("expected progress events and the result, got\n%s", out)
+	}
+}
@@ -0,0 +1,115 @@
+package unit_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/luckyjian/pgdba/internal/replication"
+)
+
+// fakeSlotDB is a replication.DB serving a fixed set of slots.
+type fakeSlotDB struct {
+	version   int
+	slots     []replication.Slot
+	walStatus bool
+}
+
+func (f *fakeSlotDB) ServerVersionNum(ctx context.Context) (int, error) { return f.version, nil }
+func (f *fakeSlotDB) Standbys(ctx context.Context) ([]replication.Standby, error) {
+	return nil, nil
+}
+func (f *fakeSlotDB) Setting(ctx context.Context, name string) (string, error) { return "", nil }
+func (f *fakeSlotDB) Slots(ctx context.Context, walStatus bool) ([]replication.Slot, error) {
+	f.walStatus = walStatus
+	return f.slots, nil
+}
+func (f *fakeSlotDB) DropSlot(ctx context.Context, name string) error { return nil }
+func (f *fakeSlotDB) AdvanceSlot(ctx context.Context, name, lsn string) (string, error) {
+	return lsn, nil
+}
+func (f *fakeSlotDB) Close(ctx context.Context) error { return nil }
+
+func slotInventory(t *testing.T, version int) (*replication.SlotInventory, *fakeSlotDB) {
+	t.Helper()
+	pid := int32(4242)
+	db := &fakeSlotDB{version: version, slots: []replication.Slot{
+		{Name: "pg_1", Type: "physical", Active: true, ActivePID: &pid, RetainedBytes: 100},
+		{Name: "cdc", Type: "logical", Plugin: "pgoutput", Database: "app", RetainedBytes: 50},
+		{Name: "debezium", Type: "logical", Plugin: "pgoutput", Database: "app", RetainedBytes: 10},
+		{Name: "old_backup", Type: "physical", RetainedBytes: 9000, WALStatus: "lost"},
+		{Name: "stale", Type: "physical", RetainedBytes: 700},
+	}}
+	dcs := map[string]interface{}{
+		"slots":        map[string]interface{}{"cdc": map[string]interface{}{"type": "logical"}},
+		"ignore_slots": []interface{}{map[string]interface{}{"name": "debezium", "plugin": "pgoutput"}},
+	}
+	inv, err := replication.ListSlots(context.Background(), db, dcs, []string{"pg-0", "pg-1"})
+	if err != nil {
+		t.Fatalf("ListSlots: %v", err)
+	}
+	return inv, db
+}
+
+func TestMemberSlotName(t *testing.T) {
+	for member, want := range map[string]string{"pg-1": "pg_1", "Node.A": "node_a", "db_2": "db_2", "n@1": "nu00641"} {
+		if got := replication.MemberSlotName(member); got != want {
+			t.Errorf("MemberSlotName(%q) = %q, want %q", member, got, want)
+		}
+	}
+}
+
+func TestListSlots_Classifies(t *testing.T) {
+	inv, db := slotInventory(t, 160002)
+	if !db.walStatus {
+		t.Error("expected wal_status read on PostgreSQL 16")
+	}
+	var got []string
+	for _, s := range inv.Slots {
+		got = append(got, s.Name+":"+s.ManagedBy)
+	}
+	if strings.Join(got, ",") != "old_backup:orphaned,stale:orphaned,pg_1:member,cdc:permanent,debezium:ignored" {
+		t.Errorf("unexpected classification %v", got)
+	}
+	if inv.RetainedBytes != 9860 {
+		t.Errorf("expected 9860 retained bytes, got %d", inv.RetainedBytes)
+	}
+	warnings := strings.Join(inv.Warnings, ";")
+	if !strings.Contains(warnings, "old_backup has lost required WAL") || !strings.Contains(warnings, "stale is inactive") ||
+		len(inv.Warnings) != 2 {
+		t.Errorf("unexpected warnings %v", inv.Warnings)
+	}
+
+	if _, db := slotInventory(t, 120015); db.walStatus {
+		t.Error("wal_status must not be read before PostgreSQL 13")
+	}
+}
+
+func TestCheckDropAndAdvance(t *testing.T) {
+	inv, _ := slotInventory(t, 160002)
+	for slot, want := range map[string]string{
+		"pg_1":    "active (pid 4242)",
+		"cdc":     "permanent slot",
+		"missing": "not found",
+	} {
+		if _, err := replication.CheckDrop(inv, slot); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected dropping %s refused with %q, got %v", slot, want, err)
+		}
+	}
+	if s, err := replication.CheckDrop(inv, "stale"); err != nil || s.Name != "stale" {
+		t.Errorf("expected the orphaned slot droppable, got %+v (%v)", s, err)
+	}
+	if _, err := replication.CheckAdvance(inv, "pg_1"); err == nil {
+		t.Error("expected an active slot not to be advanced")
+	}
+	if _, err := replication.CheckAdvance(inv, "cdc"); err != nil {
+		t.Errorf("expected an inactive slot to be advanced, got %v", err)
+	}
+}
+
+func TestReplicaSlotsDrop_RequiresConfirm(t *testing.T) {
+	out, err := executeCmd(t, nil, "replica", "slots", "drop", "--patroni-url", "http://127.0.0.1:1", "--slot", "stale")
+	if err == nil || !strings.Contains(out, "--confirm") {
+		t.Errorf("expected --confirm to be required, got %v\n%s", err, out)
+	}
+}
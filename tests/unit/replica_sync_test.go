@@ -0,0 +1,222 @@
+package unit_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/luckyjian/pgdba/internal/cli"
+	"github.com/luckyjian/pgdba/internal/failover"
+	"github.com/luckyjian/pgdba/internal/patroni"
+	"github.com/luckyjian/pgdba/internal/replication"
+)
+
+func TestParseSyncConfig(t *testing.T) {
+	cases := []struct {
+		cfg  map[string]interface{}
+		want failover.SyncConfig
+	}{
+		{map[string]interface{}{}, failover.SyncConfig{NodeCount: 1}},
+		{map[string]interface{}{"synchronous_mode": true, "synchronous_node_count": float64(2)},
+			failover.SyncConfig{Mode: true, NodeCount: 2}},
+		{map[string]interface{}{"synchronous_mode": "on", "synchronous_mode_strict": "true"},
+			failover.SyncConfig{Mode: true, Strict: true, NodeCount: 1}},
+		{map[string]interface{}{"synchronous_mode": "quorum"}, failover.SyncConfig{Mode: true, Quorum: true, NodeCount: 1}},
+	}
+	for _, tc := range cases {
+		if got := failover.ParseSyncConfig(tc.cfg); got != tc.want {
+			t.Errorf("ParseSyncConfig(%v) = %+v, want %+v", tc.cfg, got, tc.want)
+		}
+	}
+}
+
+func TestSyncConfig_PatchKeepsQuorumMode(t *testing.T) {
+	quorum := failover.ParseSyncConfig(map[string]interface{}{"synchronous_mode": "quorum"})
+	if got := quorum.Patch()["synchronous_mode"]; got != "quorum" {
+		t.Errorf("expected quorum mode written back, got %v", got)
+	}
+	quorum.Mode = false
+	if got := quorum.Patch()["synchronous_mode"]; got != false {
+		t.Errorf("expected synchronous mode turned off, got %v", got)
+	}
+}
+
+func TestCheckSwitchover_QuorumStandbyIsSynchronous(t *testing.T) {
+	status := cs(
+		running("pg-0", "leader", 0),
+		running("pg-1", "quorum_standby", 0),
+		running("pg-2", "replica", 0),
+	)
+	if err := failover.CheckSwitchover(status, "pg-2", failover.DefaultMaxLagBytes); err == nil ||
+		!strings.Contains(err.Error(), "not a synchronous standby") {
+		t.Errorf("expected the async candidate refused, got %v", err)
+	}
+	if err := failover.CheckSwitchover(status, "pg-1", failover.DefaultMaxLagBytes); err != nil {
+		t.Errorf("expected the quorum standby accepted, got %v", err)
+	}
+	var roles []string
+	for _, m := range failover.SyncMembers(status) {
+		roles = append(roles, m.Role)
+	}
+	if got := strings.Join(roles, ","); got != "leader,quorum_standby,async" {
+		t.Errorf("unexpected sync roles %s", got)
+	}
+}
+
+func TestCheckSwitchover_PrefersSyncStandby(t *testing.T) {
+	status := cs(
+		running("pg-0", "leader", 0),
+		running("pg-1", "sync_standby", 0),
+		running("pg-2", "replica", 0),
+	)
+	if err := failover.CheckSwitchover(status, "pg-2", failover.DefaultMaxLagBytes); err == nil ||
+		!strings.Contains(err.Error(), "not a synchronous standby") {
+		t.Errorf("expected the async candidate refused, got %v", err)
+	}
+	if err := failover.CheckSwitchover(status, "pg-1", failover.DefaultMaxLagBytes); err != nil {
+		t.Errorf("expected the sync standby accepted, got %v", err)
+	}
+	if err := failover.CheckSwitchover(status, "", failover.DefaultMaxLagBytes); err != nil {
+		t.Errorf("expected a switchover without candidate accepted, got %v", err)
+	}
+
+	down := cs(running("pg-0", "leader", 0), stopped("pg-1", "sync_standby"), running("pg-2", "replica", 0))
+	if err := failover.CheckSwitchover(down, "", failover.DefaultMaxLagBytes); err == nil ||
+		!strings.Contains(err.Error(), "no synchronous standby is running") {
+		t.Errorf("expected no running sync standby to be refused, got %v", err)
+	}
+}
+
+func TestEstimateCommitImpact(t *testing.T) {
+	ms := func(v float64) *float64 { return &v }
+	standbys := []replication.Standby{
+		{ApplicationName: "pg-1", SyncState: "sync", WriteLagMs: ms(0.4), FlushLagMs: ms(1.2), ReplayLagMs: ms(3)},
+		{ApplicationName: "pg-2", SyncState: "sync", WriteLagMs: ms(0.6), FlushLagMs: ms(2.5), ReplayLagMs: ms(2)},
+		{ApplicationName: "pg-3", SyncState: "async", FlushLagMs: ms(90)},
+	}
+
+	impact := replication.EstimateCommitImpact("on", standbys)
+	if impact.WaitsFor != "flush" || impact.LatencyMs == nil || *impact.LatencyMs != 2.5 ||
+		strings.Join(impact.SyncStandbys, ",") != "pg-1,pg-2" {
+		t.Errorf("expected the slowest sync flush lag, got %+v", impact)
+	}
+	if impact := replication.EstimateCommitImpact("remote_apply", standbys); impact.LatencyMs == nil || *impact.LatencyMs != 3 {
+		t.Errorf("expected the slowest sync replay lag, got %+v", impact)
+	}
+	if impact := replication.EstimateCommitImpact("local", standbys); impact.WaitsFor != "nothing" || impact.LatencyMs != nil {
+		t.Errorf("expected no wait with synchronous_commit local, got %+v", impact)
+	}
+	if impact := replication.EstimateCommitImpact("on", standbys[2:]); impact.WaitsFor != "nothing" {
+		t.Errorf("expected no wait without sync standbys, got %+v", impact)
+	}
+}
+
+// syncPatroni serves /config and /cluster and records PATCH /config.
+type syncPatroni struct {
+	mu      sync.Mutex
+	config  map[string]interface{}
+	members []patroni.Member
+	patches []map[string]interface{}
+}
+
+func newSyncPatroni(t *testing.T, members ...patroni.Member) (*syncPatroni, string) {
+	t.Helper()
+	sp := &syncPatroni{config: map[string]interface{}{"ttl": 30, "loop_wait": 10}, members: members}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sp.mu.Lock()
+		defer sp.mu.Unlock()
+		switch {
+		case r.URL.Path == "/config" && r.Method == http.MethodPatch:
+			var patch map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&patch) //nolint:errcheck
+			sp.patches = append(sp.patches, patch)
+			for k, v := range patch {
+				sp.config[k] = v
+			}
+			json.NewEncoder(w).Encode(sp.config) //nolint:errcheck
+		case r.URL.Path == "/config":
+			json.NewEncoder(w).Encode(sp.config) //nolint:errcheck
+		case r.URL.Path == "/cluster":
+			json.NewEncoder(w).Encode(patroni.ClusterStatus{Members: sp.members}) //nolint:errcheck
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return sp, srv.URL
+}
+
+func TestReplicaSync_EnableDisable(t *testing.T) {
+	sp, url := newSyncPatroni(t, running("pg-0", "leader", 0), running("pg-1", "replica", 0), running("pg-2", "replica", 0))
+
+	out, err := executeCmd(t, nil, "replica", "sync", "enable", "--patroni-url", url, "--count", "2", "--strict")
+	if err != nil {
+		t.Fatalf("replica sync enable: %v\n%s", err, out)
+	}
+	var resp struct {
+		Data cli.SyncChangeResult `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(out), &resp); err != nil {
+		t.Fatalf("output not valid JSON: %v\n%s", err, out)
+	}
+	if !resp.Data.Applied || resp.Data.Sync != (failover.SyncConfig{Mode: true, Strict: true, NodeCount: 2}) {
+		t.Errorf("unexpected enable result %+v", resp.Data)
+	}
+	if len(sp.patches) != 1 || sp.patches[0]["synchronous_mode"] != true || sp.patches[0]["synchronous_node_count"] != float64(2) {
+		t.Errorf("unexpected patches %v", sp.patches)
+	}
+
+	out, err = executeCmd(t, nil, "replica", "sync", "disable", "--patroni-url", url)
+	if err != nil {
+		t.Fatalf("replica sync disable: %v\n%s", err, out)
+	}
+	if len(sp.patches) != 2 || sp.patches[1]["synchronous_mode"] != false || sp.patches[1]["synchronous_mode_strict"] != false {
+		t.Errorf("expected synchronous mode turned off, got %v", sp.patches)
+	}
+}
+
+func TestReplicaSync_StrictNeedsHealthyReplicas(t *testing.T) {
+	sp, url := newSyncPatroni(t, running("pg-0", "leader", 0), running("pg-1", "replica", 0), stopped("pg-2", "replica"))
+
+	out, err := executeCmd(t, nil, "replica", "sync", "enable", "--patroni-url", url, "--count", "2", "--strict")
+	if err == nil || !strings.Contains(out, "writes would block") {
+		t.Errorf("expected strict mode refused, got %v\n%s", err, out)
+	}
+	out, err = executeCmd(t, nil, "replica", "sync", "enable", "--patroni-url", url, "--count", "2", "--dry-run")
+	if err != nil || !strings.Contains(out, "fall back to asynchronous") || len(sp.patches) != 0 {
+		t.Errorf("expected a dry run with a warning, got %v\n%s", err, out)
+	}
+}
+
+func TestReplicaSync_Status(t *testing.T) {
+	leader := running("pg-0", "leader", 0)
+	leader.Host, leader.Port = "127.0.0.1", 1
+	sp, url := newSyncPatroni(t, leader, running("pg-1", "sync_standby", 0), running("pg-2", "replica", 4096))
+	sp.config["synchronous_mode"] = true
+	sp.config["synchronous_node_count"] = 2
+
+	out, err := executeCmd(t, nil, "replica", "sync", "status", "--patroni-url", url)
+	if err != nil {
+		t.Fatalf("replica sync status: %v\n%s", err, out)
+	}
+	var resp struct {
+		Data cli.SyncStatusResult `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(out), &resp); err != nil {
+		t.Fatalf("output not valid JSON: %v\n%s", err, out)
+	}
+	roles := []string{}
+	for _, m := range resp.Data.Members {
+		roles = append(roles, m.Name+":"+m.Role)
+	}
+	if got := strings.Join(roles, ","); got != "pg-0:leader,pg-1:sync_standby,pg-2:async" {
+		t.Errorf("unexpected roles %s", got)
+	}
+	if !resp.Data.Config.Mode || resp.Data.ReplicationError == "" ||
+		!strings.Contains(strings.Join(resp.Data.Warnings, ";"), "synchronous_node_count is 2 but Patroni reports 1") {
+		t.Errorf("unexpected status %+v", resp.Data)
+	}
+}
@@ -0,0 +1,416 @@
+package unit_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/luckyjian/pgdba/internal/cli"
+	"github.com/luckyjian/pgdba/internal/cluster"
+	"github.com/luckyjian/pgdba/internal/lifecycle"
+	"github.com/luckyjian/pgdba/internal/patroni"
+	"github.com/luckyjian/pgdba/internal/spec"
+)
+
+// specPatroni serves /cluster, /config and /patroni and records PATCH /config bodies.
+type specPatroni struct {
+	mu      sync.Mutex
+	members []map[string]interface{}
+	config  map[string]interface{}
+	version int
+	paused  bool
+	patches []map[string]interface{}
+}
+
+func newSpecPatroni(t *testing.T, sp *specPatroni) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sp.mu.Lock()
+		defer sp.mu.Unlock()
+		switch {
+		case r.URL.Path == "/cluster":
+			json.NewEncoder(w).Encode(map[string]interface{}{"members": sp.members, "pause": sp.paused}) //nolint:errcheck
+		case r.URL.Path == "/config" && r.Method == http.MethodGet:
+			json.NewEncoder(w).Encode(sp.config) //nolint:errcheck
+		case r.URL.Path == "/config" && r.Method == http.MethodPatch:
+			var body map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&body) //nolint:errcheck
+			sp.patches = append(sp.patches, body)
+			json.NewEncoder(w).Encode(body) //nolint:errcheck
+		case r.URL.Path == "/patroni":
+			json.NewEncoder(w).Encode(map[string]interface{}{"state": "running", "role": "master", "server_version": sp.version}) //nolint:errcheck
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// prodPatroni returns a three-member "prod" cluster led by prod-pg-0.
+func prodPatroni() *specPatroni {
+	return &specPatroni{
+		members: []map[string]interface{}{
+			{"name": "prod-pg-0", "role": "leader", "state": "running", "host": "10.0.0.10"},
+			{"name": "prod-pg-1", "role": "replica", "state": "streaming", "host": "prod-pg-1"},
+			{"name": "prod-pg-2", "role": "replica", "state": "streaming", "host": "prod-pg-2"},
+		},
+		config: map[string]interface{}{
+			"ttl":        30,
+			"postgresql": map[string]interface{}{"parameters": map[string]interface{}{"max_connections": 100}},
+		},
+		version: 160004,
+	}
+}
+
+func writeSpec(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "spec.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const prodSpec = `
+name: prod
+postgres:
+  version: 16
+  parameters:
+    max_connections: "200"
+    auto_explain.log_min_duration: 500
+members:
+  - name: prod-pg-0
+  - name: prod-pg-1
+  - name: prod-pg-3
+    host: 10.0.0.13
+patroni:
+  ttl: 30
+  loop_wait: 5
+pgbouncer:
+  enabled: true
+  pool_mode: session
+labels:
+  team: payments
+`
+
+// observeProd seeds a managed "prod" cluster and observes it against sp.
+func observeProd(t *testing.T, sp *specPatroni) (*fakeProvider, *cluster.Registry, *patroni.Client, *spec.State) {
+	t.Helper()
+	srv := newSpecPatroni(t, sp)
+	p := newFakeProvider()
+	reg := seedCluster(t, p, "prod", 2)
+	entry, _ := reg.Get("prod")
+	entry.PatroniURL = srv.URL
+	entry.PGHost = "10.0.0.10"
+	entry.PGPort = 5432
+	if err := reg.Add(*entry); err != nil {
+		t.Fatal(err)
+	}
+	client := patroni.NewClient(srv.URL)
+	st, err := spec.Observe(context.Background(), entry, client, p)
+	if err != nil {
+		t.Fatalf("Observe: %v", err)
+	}
+	return p, reg, client, st
+}
+
+func actionList(plan *spec.Plan) string {
+	var items []string
+	for _, a := range plan.Actions {
+		items = append(items, a.Type+":"+a.Target)
+	}
+	return strings.Join(items, ",")
+}
+
+func TestSpecLoad_Validation(t *testing.T) {
+	cases := []struct {
+		name, content, wantErr string
+	}{
+		{"missing name", "members: [{name: a}]", "name is required"},
+		{"no members", "name: prod", "at least one member"},
+		{"duplicate member", "name: prod\nmembers: [{name: a}, {name: a}]", "duplicate member"},
+		{"bad pool mode", "name: prod\nmembers: [{name: a}]\npgbouncer: {pool_mode: fast}", "pool_mode"},
+		{"raw postgresql", "name: prod\nmembers: [{name: a}]\npatroni: {postgresql: {}}", "postgres.parameters"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := spec.Load(writeSpec(t, tc.content))
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tc.wantErr, err)
+			}
+		})
+	}
+
+	s, err := spec.Load(writeSpec(t, prodSpec))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if got := s.PgBouncer.Settings(); got.PoolMode != "session" || got.MaxClientConn != 1000 {
+		t.Errorf("expected pgbouncer defaults filled in, got %+v", got)
+	}
+}
+
+func TestSpecCompute_DiffsLiveState(t *testing.T) {
+	_, _, _, st := observeProd(t, prodPatroni())
+	s, err := spec.Load(writeSpec(t, prodSpec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plan := spec.Compute(s, st)
+	want := "set_labels:prod," +
+		"patch_config:loop_wait," +
+		"patch_config:postgresql.parameters.auto_explain.log_min_duration," +
+		"patch_config:postgresql.parameters.max_connections," +
+		"add_member:prod-pg-3," +
+		"remove_member:prod-pg-2," +
+		"create_pgbouncer:prod-pgbouncer-0"
+	if got := actionList(plan); got != want {
+		t.Errorf("unexpected plan:\n got %s\nwant %s", got, want)
+	}
+	if plan.InSync || plan.Blocked != 0 {
+		t.Errorf("expected an unblocked, out-of-sync plan, got %+v", plan)
+	}
+	if !plan.NeedsPasswords() {
+		t.Error("adding a member must need passwords")
+	}
+}
+
+func TestSpecCompute_InSync(t *testing.T) {
+	_, _, _, st := observeProd(t, prodPatroni())
+	s := &spec.Spec{
+		Name:     "prod",
+		Postgres: spec.PostgresSpec{Parameters: map[string]interface{}{"max_connections": "100"}},
+		Members:  []spec.MemberSpec{{Name: "prod-pg-0"}, {Name: "prod-pg-1"}, {Name: "prod-pg-2"}},
+		Patroni:  map[string]interface{}{"ttl": 30},
+	}
+	if plan := spec.Compute(s, st); !plan.InSync {
+		t.Errorf("expected plan in sync, got %s", actionList(plan))
+	}
+}
+
+func TestSpecCompute_BlocksUnsafeChanges(t *testing.T) {
+	_, _, _, st := observeProd(t, prodPatroni())
+	s := &spec.Spec{
+		Name:     "prod",
+		Postgres: spec.PostgresSpec{Version: 17, Port: 6543},
+		Members:  []spec.MemberSpec{{Name: "prod-pg-1"}, {Name: "prod-pg-2"}},
+	}
+	plan := spec.Compute(s, st)
+	if plan.Blocked != 3 {
+		t.Fatalf("expected port, version and leader removal to be blocked, got %+v", plan.Actions)
+	}
+	for _, a := range plan.Actions {
+		if a.Type == spec.ActionRemoveMember && !strings.Contains(a.Reason, "leader") {
+			t.Errorf("expected leader removal reason, got %q", a.Reason)
+		}
+	}
+
+	_, err := spec.Apply(context.Background(), s, st, plan, spec.Target{})
+	if err == nil || !strings.Contains(err.Error(), "blocked") {
+		t.Errorf("expected Apply to refuse a blocked plan, got %v", err)
+	}
+}
+
+func TestSpecCompute_BlocksUnorderedKubernetesMembers(t *testing.T) {
+	_, _, _, st := observeProd(t, prodPatroni())
+	st.Entry.Provider = "kubernetes"
+	s := &spec.Spec{
+		Name:     "prod",
+		Postgres: spec.PostgresSpec{Version: 16},
+		Members: []spec.MemberSpec{{Name: "prod-pg-0"}, {Name: "prod-pg-1"}, {Name: "prod-pg-2"},
+			{Name: "prod-pg-3"}, {Name: "prod-replica"}},
+	}
+	plan := spec.Compute(s, st)
+	for _, a := range plan.Actions {
+		if a.Type != spec.ActionAddMember {
+			continue
+		}
+		if blocked := a.Target == "prod-replica"; a.Blocked != blocked {
+			t.Errorf("add %s: blocked=%v, want %v (%s)", a.Target, a.Blocked, blocked, a.Reason)
+		}
+	}
+}
+
+func TestSpecApply_Converges(t *testing.T) {
+	sp := prodPatroni()
+	p, reg, client, st := observeProd(t, sp)
+	s, err := spec.Load(writeSpec(t, prodSpec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan := spec.Compute(s, st)
+
+	result, err := spec.Apply(context.Background(), s, st, plan, spec.Target{
+		Registry:  reg,
+		Patroni:   client,
+		Provider:  p,
+		Passwords: lifecycle.Passwords{Superuser: "su", Replication: "repl", Rewind: "rw"},
+	})
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if len(result.Applied) != len(plan.Actions) {
+		t.Errorf("expected all %d actions applied, got %d", len(plan.Actions), len(result.Applied))
+	}
+
+	if len(sp.patches) != 1 {
+		t.Fatalf("expected a single PATCH /config, got %d", len(sp.patches))
+	}
+	params := sp.patches[0]["postgresql"].(map[string]interface{})["parameters"].(map[string]interface{})
+	if params["max_connections"] != "200" || params["auto_explain.log_min_duration"] != float64(500) {
+		t.Errorf("unexpected parameters patch: %v", params)
+	}
+	if sp.patches[0]["loop_wait"] != float64(5) {
+		t.Errorf("expected loop_wait patched, got %v", sp.patches[0])
+	}
+
+	cfg, ok := p.configs["prod-pg-3"]
+	if !ok {
+		t.Fatal("prod-pg-3 was not created")
+	}
+	if cfg.Host != "10.0.0.13" || cfg.Env["ETCD3_HOSTS"] != "prod-etcd-0:2379,prod-etcd-1:2379,prod-etcd-2:2379" {
+		t.Errorf("unexpected member config: host=%s env=%v", cfg.Host, cfg.Env)
+	}
+	if strings.Join(p.destroyed, ",") != "prod-pg-2" {
+		t.Errorf("expected prod-pg-2 destroyed, got %v", p.destroyed)
+	}
+	if len(execsMatching(p, "etcdctl del /db/prod/members/prod-pg-2")) != 1 {
+		t.Errorf("expected the prod-pg-2 member key deleted from etcd, got %v", p.execs)
+	}
+	ini := p.configs["prod-pgbouncer-0"].Files[lifecycle.PgBouncerIniPath]
+	if !strings.Contains(ini, "pool_mode = session") || !strings.Contains(ini, "host=10.0.0.10") {
+		t.Errorf("unexpected pgbouncer.ini:\n%s", ini)
+	}
+
+	entry, _ := reg.Get("prod")
+	if entry.Labels["team"] != "payments" {
+		t.Errorf("expected labels applied, got %v", entry.Labels)
+	}
+}
+
+func TestSpecApply_RerendersPgBouncer(t *testing.T) {
+	sp := prodPatroni()
+	srv := newSpecPatroni(t, sp)
+	p := newFakeProvider()
+	reg := seedCluster(t, p, "prod", 2)
+	p.CreateNode(context.Background(), lifecycle.PgBouncerNode( //nolint:errcheck
+		lifecycle.MemberTemplate{ClusterName: "prod", PGPort: 5432}, "10.0.0.10", lifecycle.DefaultPgBouncerSettings()))
+	p.execFn = func(id string, cmd []string) (string, error) {
+		if cmd[0] == "cat" {
+			return p.configs[id].Files[lifecycle.PgBouncerIniPath], nil
+		}
+		return "", nil
+	}
+	entry, _ := reg.Get("prod")
+	entry.PatroniURL, entry.PGHost, entry.PGPort = srv.URL, "10.0.0.10", 5432
+	client := patroni.NewClient(srv.URL)
+	st, err := spec.Observe(context.Background(), entry, client, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &spec.Spec{
+		Name:      "prod",
+		Members:   []spec.MemberSpec{{Name: "prod-pg-0"}, {Name: "prod-pg-1"}, {Name: "prod-pg-2"}},
+		PgBouncer: spec.PgBouncerSpec{Enabled: true},
+	}
+	if plan := spec.Compute(s, st); !plan.InSync {
+		t.Fatalf("unchanged pgbouncer settings must be in sync, got %s", actionList(plan))
+	}
+
+	s.PgBouncer.DefaultPoolSize = 50
+	plan := spec.Compute(s, st)
+	if got := actionList(plan); got != "update_pgbouncer:prod-pgbouncer-0" {
+		t.Fatalf("expected a pgbouncer update, got %s", got)
+	}
+	if _, err := spec.Apply(context.Background(), s, st, plan, spec.Target{Registry: reg, Patroni: client, Provider: p}); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if len(p.execs) < 3 || !strings.Contains(p.execs[1], lifecycle.PgBouncerIniPath) ||
+		!strings.Contains(p.execs[2], "pkill -HUP -x pgbouncer") {
+		t.Errorf("expected ini rewrite and reload, got %v", p.execs)
+	}
+}
+
+func TestClusterPlan_ExternalCluster(t *testing.T) {
+	srv := newSpecPatroni(t, prodPatroni())
+	regPath := filepath.Join(t.TempDir(), "clusters.json")
+	reg := cluster.NewRegistry(regPath)
+	if err := reg.Add(cluster.Entry{Name: "prod", PatroniURL: srv.URL, PGHost: "10.0.0.10", PGPort: 5432,
+		Source: cluster.SourceExternal}); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := cli.NewRootCmdWithRegistry(regPath)
+	buf := new(strings.Builder)
+	cmd.SetOut(buf)
+	cmd.SetErr(buf)
+	cmd.SetArgs([]string{"cluster", "plan", "-f", writeSpec(t, prodSpec)})
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("cluster plan: %v\n%s", err, buf.String())
+	}
+	var resp struct {
+		Success bool      `json:"success"`
+		Data    spec.Plan `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(buf.String()), &resp); err != nil {
+		t.Fatalf("parse output: %v\n%s", err, buf.String())
+	}
+	// Member and PgBouncer changes need a provider; config changes do not.
+	if resp.Data.Blocked != 3 || !strings.Contains(buf.String(), `"patch_config"`) {
+		t.Errorf("unexpected plan for external cluster: %s", buf.String())
+	}
+
+	if err := executeClusterCmdWithRegistry(t, regPath, "cluster", "plan"); err == nil {
+		t.Error("expected --file to be required")
+	}
+}
+
+func TestClusterApply_PausedClusterAllowsOnlyLabels(t *testing.T) {
+	sp := prodPatroni()
+	sp.paused = true
+	srv := newSpecPatroni(t, sp)
+	regPath := filepath.Join(t.TempDir(), "clusters.json")
+	reg := cluster.NewRegistry(regPath)
+	if err := reg.Add(cluster.Entry{Name: "prod", PatroniURL: srv.URL, PGHost: "10.0.0.10", PGPort: 5432,
+		Source: cluster.SourceExternal}); err != nil {
+		t.Fatal(err)
+	}
+	apply := func(content string) error {
+		cmd := cli.NewRootCmdWithRegistry(regPath)
+		buf := new(strings.Builder)
+		cmd.SetOut(buf)
+		cmd.SetErr(buf)
+		cmd.SetArgs([]string{"cluster", "apply", "-f", writeSpec(t, content)})
+		return cmd.Execute()
+	}
+	const members = `
+name: prod
+members:
+  - name: prod-pg-0
+  - name: prod-pg-1
+  - name: prod-pg-2
+`
+
+	if err := apply(members + "patroni:\n  ttl: 40\n"); err == nil || !strings.Contains(err.Error(), "paused") {
+		t.Fatalf("expected a config change refused while paused, got %v", err)
+	}
+	if len(sp.patches) != 0 {
+		t.Errorf("expected no PATCH /config while paused, got %v", sp.patches)
+	}
+
+	if err := apply(members + "labels:\n  team: payments\n"); err != nil {
+		t.Fatalf("expected a label-only plan applied while paused, got %v", err)
+	}
+	if e, _ := reg.Get("prod"); e.Labels["team"] != "payments" {
+		t.Errorf("expected the label recorded, got %v", e.Labels)
+	}
+}
@@ -256,3 +256,35 @@ func TestRollback_Success(t *testing.T) {
 		t.Error("expected RolledBackAt to be set")
 	}
 }
+
+func TestValidateDCSParameters(t *testing.T) {
+	settings := []inspect.PGSetting{
+		{Name: "work_mem", Context: "user", VarType: "integer", Unit: "kB", MinVal: "64", MaxVal: "2147483647"},
+		{Name: "shared_buffers", Context: "postmaster", VarType: "integer", Unit: "8kB", MinVal: "16", MaxVal: "1073741823"},
+		{Name: "hot_standby_feedback", Context: "sighup", VarType: "bool"},
+		{Name: "block_size", Context: "internal", VarType: "integer"},
+		{Name: "max_connections", Context: "postmaster", VarType: "integer", MinVal: "1", MaxVal: "262143"},
+	}
+
+	ok := tuning.ValidateDCSParameters(settings, map[string]interface{}{
+		"work_mem":                 "64MB",
+		"shared_buffers":           "2GB",
+		"hot_standby_feedback":     "on",
+		"pg_stat_statements.track": "all",
+	})
+	if !ok.OK || len(ok.Warnings) != 2 {
+		t.Errorf("expected valid params with restart and extension warnings, got %+v", ok)
+	}
+
+	bad := tuning.ValidateDCSParameters(settings, map[string]interface{}{
+		"work_mem":             "lots",
+		"hot_standby_feedback": "maybe",
+		"block_size":           16384,
+		"max_connections":      float64(0),
+		"port":                 5433,
+		"no_such_param":        "1",
+	})
+	if bad.OK || len(bad.Errors) != 6 {
+		t.Errorf("expected six errors, got %+v", bad.Errors)
+	}
+}
@@ -0,0 +1,123 @@
+package unit_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/luckyjian/pgdba/internal/upgrade"
+)
+
+// mockUpgradeDB implements upgrade.DB for testing.
+type mockUpgradeDB struct {
+	versionNum int
+	databases  []string
+	extensions []upgrade.Extension
+	regColumns []upgrade.Column
+	settings   []upgrade.Setting
+	xacts      []upgrade.PreparedXact
+	closed     bool
+}
+
+func (m *mockUpgradeDB) ServerVersionNum(ctx context.Context) (int, error) { return m.versionNum, nil }
+func (m *mockUpgradeDB) Databases(ctx context.Context) ([]string, error)   { return m.databases, nil }
+func (m *mockUpgradeDB) Extensions(ctx context.Context) ([]upgrade.Extension, error) {
+	return m.extensions, nil
+}
+func (m *mockUpgradeDB) RegColumns(ctx context.Context) ([]upgrade.Column, error) {
+	return m.regColumns, nil
+}
+func (m *mockUpgradeDB) Settings(ctx context.Context) ([]upgrade.Setting, error) {
+	return m.settings, nil
+}
+func (m *mockUpgradeDB) PreparedXacts(ctx context.Context) ([]upgrade.PreparedXact, error) {
+	return m.xacts, nil
+}
+func (m *mockUpgradeDB) Close(ctx context.Context) error { m.closed = true; return nil }
+
+func checkByName(r *upgrade.Report, name string) upgrade.CheckResult {
+	for _, c := range r.Checks {
+		if c.Name == name {
+			return c
+		}
+	}
+	return upgrade.CheckResult{}
+}
+
+func TestCheck_CleanInstanceIsReady(t *testing.T) {
+	db := &mockUpgradeDB{versionNum: 160004, databases: []string{"postgres"},
+		settings: []upgrade.Setting{{Name: "wal_level", Setting: "replica", Source: "configuration file"}}}
+
+	r, err := upgrade.Check(context.Background(), db, nil, 17, upgrade.MethodLink)
+	if err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if !r.Ready || r.FromMajor != 16 || len(r.Checks) != 5 {
+		t.Errorf("expected a ready report with five checks, got %+v", r)
+	}
+	if checkByName(r, upgrade.CheckWalLevel).Name != "" {
+		t.Error("wal_level is only checked for the logical method")
+	}
+}
+
+func TestCheck_ReportsBlockers(t *testing.T) {
+	db := &mockUpgradeDB{versionNum: 150008, databases: []string{"app", "postgres"},
+		settings: []upgrade.Setting{
+			{Name: "old_snapshot_threshold", Setting: "60", Source: "configuration file"},
+			{Name: "vacuum_defer_cleanup_age", Setting: "0", Source: "default"},
+			{Name: "stats_temp_directory", Setting: "pg_stat_tmp", Source: "configuration file"},
+			{Name: "wal_level", Setting: "replica", Source: "configuration file"},
+		},
+		xacts: []upgrade.PreparedXact{{GID: "tx1", Owner: "app", Database: "app"}},
+	}
+	perDB := map[string]*mockUpgradeDB{
+		"app": {
+			extensions: []upgrade.Extension{{Name: "adminpack", Version: "2.1"}, {Name: "pg_trgm", Version: "1.6"}},
+			regColumns: []upgrade.Column{{Schema: "public", Table: "jobs", Column: "fn", Type: "regproc"}},
+		},
+		"postgres": {},
+	}
+	open := func(ctx context.Context, name string) (upgrade.DB, error) { return perDB[name], nil }
+
+	r, err := upgrade.Check(context.Background(), db, open, 17, upgrade.MethodLogical)
+	if err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if r.Ready {
+		t.Error("expected the report not to be ready")
+	}
+	if c := checkByName(r, upgrade.CheckExtensions); c.Status != upgrade.StatusFail ||
+		len(c.Items) != 1 || !strings.HasPrefix(c.Items[0], "app: adminpack") {
+		t.Errorf("expected adminpack in app flagged, got %+v", c)
+	}
+	if c := checkByName(r, upgrade.CheckRegColumns); len(c.Items) != 1 || !strings.Contains(c.Items[0], "public.jobs.fn (regproc)") {
+		t.Errorf("expected the regproc column flagged, got %+v", c)
+	}
+	// stats_temp_directory went away in 15 and is already ignored by the
+	// running server; settings left at their default are harmless.
+	if c := checkByName(r, upgrade.CheckRemovedGUCs); len(c.Items) != 1 || !strings.HasPrefix(c.Items[0], "old_snapshot_threshold") {
+		t.Errorf("expected only old_snapshot_threshold flagged, got %+v", c)
+	}
+	if c := checkByName(r, upgrade.CheckPreparedXacts); c.Status != upgrade.StatusFail {
+		t.Errorf("expected prepared transactions to fail, got %+v", c)
+	}
+	if c := checkByName(r, upgrade.CheckWalLevel); c.Status != upgrade.StatusFail {
+		t.Errorf("expected wal_level=replica to fail the logical method, got %+v", c)
+	}
+	if !perDB["app"].closed || !perDB["postgres"].closed {
+		t.Error("expected per-database connections to be closed")
+	}
+}
+
+func TestCheck_RejectsOlderTarget(t *testing.T) {
+	r, err := upgrade.Check(context.Background(), &mockUpgradeDB{versionNum: 170002}, nil, 16, upgrade.MethodLink)
+	if err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if r.Ready || checkByName(r, upgrade.CheckVersion).Status != upgrade.StatusFail {
+		t.Errorf("expected a downgrade to be refused, got %+v", r.Checks)
+	}
+	if _, err := upgrade.Check(context.Background(), &mockUpgradeDB{}, nil, 17, "dump"); err == nil {
+		t.Error("expected an unknown method to be rejected")
+	}
+}